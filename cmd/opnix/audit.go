@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/audit"
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+)
+
+type auditCommand struct {
+	fs      *flag.FlagSet
+	logFile string
+	keyFile string
+	// Scan deployed secret files instead of verifying a log
+	checkPermissions bool
+	configFile       string
+	outputDir        string
+	fix              bool
+	jsonOut          bool
+}
+
+func newAuditCommand() *auditCommand {
+	ac := &auditCommand{
+		fs: flag.NewFlagSet("audit", flag.ExitOnError),
+	}
+
+	ac.fs.StringVar(&ac.logFile, "log", "", "Audit log written by 'opnix secret -audit-log'")
+	ac.fs.StringVar(&ac.keyFile, "key", "", "File containing the HMAC key the log was signed with")
+	ac.fs.BoolVar(&ac.checkPermissions, "check-permissions", false, "Report deployed secret files that are missing, world-readable, or whose mode or owner differ from the config")
+	ac.fs.StringVar(&ac.configFile, "config", "secrets.json", "With -check-permissions, path to secrets configuration file")
+	ac.fs.StringVar(&ac.outputDir, "output", "secrets", "With -check-permissions, directory secrets are written to")
+	ac.fs.BoolVar(&ac.fix, "fix", false, "With -check-permissions, reset mode and ownership to match the config")
+	ac.fs.BoolVar(&ac.jsonOut, "json", false, "With -check-permissions, print the findings as JSON")
+
+	ac.fs.Usage = func() {
+		fmt.Fprintf(ac.fs.Output(), "Usage: opnix audit -log FILE [-key FILE]\n")
+		fmt.Fprintf(ac.fs.Output(), "       opnix audit -check-permissions [-config FILE] [-fix]\n\n")
+		fmt.Fprintf(ac.fs.Output(), "Verify that an audit log's hash chain and signatures are intact, or\n")
+		fmt.Fprintf(ac.fs.Output(), "check deployed secret files against the config (values are never read)\n\n")
+		fmt.Fprintf(ac.fs.Output(), "Options:\n")
+		ac.fs.PrintDefaults()
+	}
+
+	return ac
+}
+
+func (a *auditCommand) Name() string { return a.fs.Name() }
+
+func (a *auditCommand) Init(args []string) error {
+	if err := a.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if a.checkPermissions {
+		if a.logFile != "" {
+			return fmt.Errorf("-log cannot be used together with -check-permissions")
+		}
+		return nil
+	}
+
+	if a.fix || a.jsonOut {
+		return fmt.Errorf("-fix and -json can only be used together with -check-permissions")
+	}
+	if a.logFile == "" {
+		return fmt.Errorf("-log or -check-permissions is required")
+	}
+
+	return nil
+}
+
+func (a *auditCommand) Run() error {
+	if a.checkPermissions {
+		return a.runCheckPermissions()
+	}
+
+	var key []byte
+	if a.keyFile != "" {
+		var err error
+		if key, err = audit.LoadKey(a.keyFile); err != nil {
+			return err
+		}
+	}
+
+	count, err := audit.NewLog(a.logFile, key).Verify()
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Audit log intact: %d entries verified\n", count)
+	return nil
+}
+
+// runCheckPermissions reports every deployed file that deviates from the
+// config and fails unless all of them were fixed
+func (a *auditCommand) runCheckPermissions() error {
+	cfg, err := config.Load(a.configFile)
+	if err != nil {
+		return err
+	}
+
+	// The client is never used: only paths and file metadata are inspected
+	processor := secrets.NewProcessor(nil, a.outputDir)
+	findings, err := processor.AuditPermissions(cfg, a.fix)
+	if err != nil {
+		return err
+	}
+
+	unresolved := 0
+	for _, finding := range findings {
+		if !finding.Fixed {
+			unresolved++
+		}
+	}
+
+	if a.jsonOut {
+		if findings == nil {
+			findings = []secrets.PermissionFinding{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(findings); err != nil {
+			return err
+		}
+	} else {
+		for _, finding := range findings {
+			status := "FAIL"
+			if finding.Fixed {
+				status = "FIXED"
+			}
+			fmt.Printf("[%-5s] %s\t%s\n", status, finding.Path, strings.Join(finding.Problems, "; "))
+		}
+	}
+
+	if unresolved > 0 {
+		return fmt.Errorf("%d deployed secret files need attention", unresolved)
+	}
+	fmt.Fprintf(os.Stderr, "All deployed secret files match the config\n")
+	return nil
+}
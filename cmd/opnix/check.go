@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+)
+
+type checkCommand struct {
+	fs           *flag.FlagSet
+	configFile   string
+	configKey    string
+	configFormat string
+	outputDir    string
+	tokenFile    string
+	account      string
+	json         bool
+}
+
+func newCheckCommand() *checkCommand {
+	cc := &checkCommand{
+		fs: flag.NewFlagSet("check", flag.ExitOnError),
+	}
+
+	cc.fs.StringVar(&cc.configFile, "config", "secrets.json", "Path to secrets configuration file")
+	cc.fs.StringVar(&cc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	cc.fs.StringVar(&cc.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
+	cc.fs.StringVar(&cc.outputDir, "output", "secrets", "Directory secrets are stored in")
+	cc.fs.StringVar(&cc.tokenFile, "token-file", defaultTokenPath, "Path to file containing 1Password service account token")
+	cc.fs.StringVar(&cc.account, "account", "", "1Password account the token must belong to, e.g. myteam or myteam.1password.com (default $OPNIX_ACCOUNT)")
+	cc.fs.BoolVar(&cc.json, "json", false, "Print the out-of-sync files as JSON")
+
+	cc.fs.Usage = func() {
+		fmt.Fprintf(cc.fs.Output(), "Usage: opnix check [options]\n\n")
+		fmt.Fprintf(cc.fs.Output(), "Resolve every secret and compare it with the file on disk: content hash,\n")
+		fmt.Fprintf(cc.fs.Output(), "mode and owner, for copies too, and where each symlink points. Exits 0\n")
+		fmt.Fprintf(cc.fs.Output(), "only when every file matches the config, and 1 when any is missing or\n")
+		fmt.Fprintf(cc.fs.Output(), "differs. Nothing is written and values are never printed, so it suits\n")
+		fmt.Fprintf(cc.fs.Output(), "cron jobs and monitoring.\n\n")
+		fmt.Fprintf(cc.fs.Output(), "Example:\n")
+		fmt.Fprintf(cc.fs.Output(), "  opnix check -config /etc/opnix/secrets.json -output /var/lib/opnix/secrets\n\n")
+		fmt.Fprintf(cc.fs.Output(), "Options:\n")
+		cc.fs.PrintDefaults()
+	}
+
+	return cc
+}
+
+func (c *checkCommand) Name() string { return c.fs.Name() }
+
+func (c *checkCommand) Init(args []string) error {
+	return c.fs.Parse(args)
+}
+
+func (c *checkCommand) Run() error {
+	cfg, err := config.LoadFormat(c.configFile, c.configKey, c.configFormat, nil)
+	if err != nil {
+		return err
+	}
+
+	client, err := onepass.NewClient(c.tokenFile, c.account, cfg.Network.Options())
+	if err != nil {
+		return err
+	}
+
+	processor := secrets.NewProcessor(client, c.outputDir)
+	processor.SetAccountClients(accountClients(cfg))
+	processor.SetCheckOnly(true)
+	if err := processor.Process(cfg); err != nil {
+		return err
+	}
+
+	drift := processor.Drift()
+	if c.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		// An empty list rather than null when everything matches
+		if drift == nil {
+			drift = []secrets.Drift{}
+		}
+		if err := encoder.Encode(drift); err != nil {
+			return err
+		}
+	} else {
+		writeDrift(os.Stdout, drift)
+	}
+
+	if len(drift) == 0 {
+		return nil
+	}
+
+	var paths []string
+	for _, d := range drift {
+		paths = append(paths, d.Path)
+	}
+	return &errors.OpnixError{
+		Operation: "Checking secrets against the configuration",
+		Component: "file system",
+		Issue:     fmt.Sprintf("%d secret file(s) do not match the configuration", len(drift)),
+		Context:   fmt.Sprintf("Out of sync: %s", strings.Join(paths, ", ")),
+		Suggestions: []string{
+			"Run 'opnix secret' to rewrite the affected secrets",
+			"Check for other processes writing to the same paths",
+		},
+	}
+}
+
+// writeDrift prints each out-of-sync file with its problems, or a single
+// line when everything matches
+func writeDrift(w io.Writer, drift []secrets.Drift) {
+	if len(drift) == 0 {
+		fmt.Fprintln(w, "All secrets match the configuration")
+		return
+	}
+	for _, d := range drift {
+		fmt.Fprintf(w, "%s (%s)\n", d.Path, d.Name)
+		for _, problem := range d.Problems {
+			fmt.Fprintf(w, "  %s\n", problem)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+// Check results reported by doctor
+const (
+	checkOK   = "ok"
+	checkWarn = "warn"
+	checkFail = "fail"
+)
+
+type doctorCommand struct {
+	fs           *flag.FlagSet
+	configFile   string
+	configKey    string
+	configFormat string
+	outputDir    string
+	tokenFile    string
+	jsonOut      bool
+}
+
+// doctorCheck is one entry of the doctor checklist
+type doctorCheck struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+}
+
+// doctorReport is the structured checklist printed with -json
+type doctorReport struct {
+	OK     bool          `json:"ok"`
+	Checks []doctorCheck `json:"checks"`
+}
+
+func newDoctorCommand() *doctorCommand {
+	dc := &doctorCommand{
+		fs: flag.NewFlagSet("doctor", flag.ExitOnError),
+	}
+
+	dc.fs.StringVar(&dc.configFile, "config", "secrets.json", "Path to secrets configuration file")
+	dc.fs.StringVar(&dc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	dc.fs.StringVar(&dc.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
+	dc.fs.StringVar(&dc.outputDir, "output", "secrets", "Directory secrets are written to")
+	dc.fs.StringVar(&dc.tokenFile, "token-file", defaultTokenPath, "Path to file containing 1Password service account token")
+	dc.fs.BoolVar(&dc.jsonOut, "json", false, "Print the checklist as JSON")
+
+	dc.fs.Usage = func() {
+		fmt.Fprintf(dc.fs.Output(), "Usage: opnix doctor [options]\n\n")
+		fmt.Fprintf(dc.fs.Output(), "Check the token, configuration and output directory without contacting 1Password\n\n")
+		fmt.Fprintf(dc.fs.Output(), "Options:\n")
+		dc.fs.PrintDefaults()
+	}
+
+	return dc
+}
+
+func (d *doctorCommand) Name() string { return d.fs.Name() }
+
+func (d *doctorCommand) Init(args []string) error {
+	return d.fs.Parse(args)
+}
+
+func (d *doctorCommand) Run() error {
+	report := doctorReport{
+		Checks: []doctorCheck{
+			d.checkToken(),
+			d.checkConfig(),
+			d.checkOutputDir(),
+		},
+	}
+
+	report.OK = true
+	for _, check := range report.Checks {
+		if check.Status == checkFail {
+			report.OK = false
+		}
+	}
+
+	if d.jsonOut {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		for _, check := range report.Checks {
+			fmt.Printf("[%-4s] %-7s %s\n", check.Status, check.Name, check.Detail)
+		}
+	}
+
+	if !report.OK {
+		return fmt.Errorf("doctor found problems")
+	}
+	return nil
+}
+
+// checkToken reports whether a usable token is available
+func (d *doctorCommand) checkToken() doctorCheck {
+	check := doctorCheck{Name: "token"}
+
+	if os.Getenv("OP_SERVICE_ACCOUNT_TOKEN") != "" {
+		check.Status = checkOK
+		check.Detail = "using OP_SERVICE_ACCOUNT_TOKEN from the environment"
+		return check
+	}
+
+	status, err := inspectToken(d.tokenFile)
+	switch {
+	case err != nil:
+		check.Status = checkFail
+		check.Detail = err.Error()
+	case !status.Exists:
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("token file %s does not exist (run 'opnix token set')", d.tokenFile)
+	case status.Fingerprint == "":
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("token file %s is empty", d.tokenFile)
+	case status.Mode != fmt.Sprintf("%04o", tokenFileMode):
+		check.Status = checkWarn
+		check.Detail = fmt.Sprintf("token file %s has mode %s, expected %04o", d.tokenFile, status.Mode, tokenFileMode)
+	default:
+		check.Status = checkOK
+		check.Detail = fmt.Sprintf("token file %s (%s)", d.tokenFile, status.Fingerprint)
+		if status.Account != "" {
+			check.Detail += ", authenticates as " + status.Account
+		}
+	}
+
+	return check
+}
+
+// checkConfig loads and validates the configuration
+func (d *doctorCommand) checkConfig() doctorCheck {
+	check := doctorCheck{Name: "config"}
+
+	cfg, err := config.LoadFormat(d.configFile, d.configKey, d.configFormat, nil)
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		return check
+	}
+
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("%s is valid (%d secrets)", d.configFile, len(cfg.Secrets))
+	return check
+}
+
+// checkOutputDir reports whether secrets could be written, without creating anything
+func (d *doctorCommand) checkOutputDir() doctorCheck {
+	check := doctorCheck{Name: "output"}
+
+	info, err := os.Stat(d.outputDir)
+	if os.IsNotExist(err) {
+		check.Status = checkWarn
+		check.Detail = fmt.Sprintf("%s does not exist yet and will be created", d.outputDir)
+		return check
+	}
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = err.Error()
+		return check
+	}
+	if !info.IsDir() {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("%s is not a directory", d.outputDir)
+		return check
+	}
+
+	probe, err := os.CreateTemp(d.outputDir, ".opnix-doctor-*")
+	if err != nil {
+		check.Status = checkFail
+		check.Detail = fmt.Sprintf("%s is not writable: %v", d.outputDir, err)
+		return check
+	}
+	_ = probe.Close()
+	_ = os.Remove(probe.Name())
+
+	check.Status = checkOK
+	check.Detail = fmt.Sprintf("%s is writable", d.outputDir)
+	return check
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+)
+
+type explainPathCommand struct {
+	fs           *flag.FlagSet
+	configFile   string
+	configKey    string
+	configFormat string
+	outputDir    string
+	secret       string
+	json         bool
+}
+
+func newExplainPathCommand() *explainPathCommand {
+	ec := &explainPathCommand{
+		fs: flag.NewFlagSet("explain-path", flag.ExitOnError),
+	}
+
+	ec.fs.StringVar(&ec.configFile, "config", "secrets.json", "Path to secrets configuration file")
+	ec.fs.StringVar(&ec.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	ec.fs.StringVar(&ec.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
+	ec.fs.StringVar(&ec.outputDir, "output", "secrets", "Directory secrets are stored in")
+	ec.fs.StringVar(&ec.secret, "secret", "", "Secret to explain: its configured path or a glob, as for -only, or its index in the config")
+	ec.fs.BoolVar(&ec.json, "json", false, "Print the steps as JSON")
+
+	ec.fs.Usage = func() {
+		fmt.Fprintf(ec.fs.Output(), "Usage: opnix explain-path -secret NAME [options]\n\n")
+		fmt.Fprintf(ec.fs.Output(), "Show step by step how a secret's final path is computed from its path or\n")
+		fmt.Fprintf(ec.fs.Output(), "pathTemplate, variables, defaults, pathPrefix and the output directory.\n")
+		fmt.Fprintf(ec.fs.Output(), "Nothing is read from 1Password.\n\n")
+		fmt.Fprintf(ec.fs.Output(), "Example:\n")
+		fmt.Fprintf(ec.fs.Output(), "  opnix explain-path -config secrets.json -secret 2\n\n")
+		fmt.Fprintf(ec.fs.Output(), "Options:\n")
+		ec.fs.PrintDefaults()
+	}
+
+	return ec
+}
+
+func (e *explainPathCommand) Name() string { return e.fs.Name() }
+
+func (e *explainPathCommand) Init(args []string) error {
+	if err := e.fs.Parse(args); err != nil {
+		return err
+	}
+	if e.secret == "" {
+		return errors.ConfigError(
+			"Parsing explain-path options",
+			"A secret to explain is required",
+			fmt.Errorf("use -secret with the secret's path, a glob, or its index in the config"),
+		)
+	}
+	return nil
+}
+
+func (e *explainPathCommand) Run() error {
+	cfg, err := config.LoadFormat(e.configFile, e.configKey, e.configFormat, nil)
+	if err != nil {
+		return err
+	}
+
+	// Secrets without a path can only be picked by index
+	index, indexErr := strconv.Atoi(e.secret)
+	processor := secrets.NewProcessor(nil, e.outputDir)
+	explanations := processor.ExplainPaths(cfg, func(i int, secret config.Secret) bool {
+		return (indexErr == nil && i == index) || matchesAnyPath([]string{e.secret}, secret.Path)
+	})
+	if len(explanations) == 0 {
+		return errors.ConfigError(
+			"Explaining secret path",
+			fmt.Sprintf("No secret in %s matches %q", e.configFile, e.secret),
+			fmt.Errorf("run 'opnix list' to see the configured secrets"),
+		)
+	}
+
+	if e.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(explanations)
+	}
+	writeExplanations(os.Stdout, explanations)
+	return nil
+}
+
+// writeExplanations prints each secret's steps, numbered, with the path after each
+func writeExplanations(w io.Writer, explanations []secrets.PathExplanation) {
+	for i, explanation := range explanations {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s\n", explanation.Name)
+		for j, step := range explanation.Steps {
+			fmt.Fprintf(w, "  %d. %s\n     -> %s\n", j+1, step.Step, step.Path)
+		}
+		if explanation.Error != "" {
+			fmt.Fprintf(w, "  Failed:\n%s\n", explanation.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  Final path: %s\n", explanation.Path)
+	}
+}
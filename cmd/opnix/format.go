@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/onepass"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+)
+
+// Compatibility formats shaped like the output of the op CLI, for scripts
+// migrating from it. opnix's own formats stay the default.
+const (
+	// formatOpJSON prints field objects like `op item get --format json`
+	formatOpJSON = "op-json"
+	// formatOpEnv prints NAME=value lines like the env files `op run` reads
+	formatOpEnv = "op-env"
+)
+
+// opField mirrors a field object of `op item get --format json`. Path is an
+// opnix addition naming the file the field is written to.
+type opField struct {
+	ID        string `json:"id"`
+	Label     string `json:"label"`
+	Value     string `json:"value,omitempty"`
+	Reference string `json:"reference"`
+	Path      string `json:"path,omitempty"`
+}
+
+// newOpField describes reference like op describes the field it points at;
+// op uses the field's label as its ID for references by name
+func newOpField(reference string) opField {
+	field := opField{Reference: reference}
+	if ref, err := onepass.ParseReference(reference); err == nil {
+		field.ID, field.Label = ref.Field, ref.Field
+	}
+	return field
+}
+
+// writeOpJSON prints v indented by two spaces and without HTML escaping, as op does
+func writeOpJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.SetEscapeHTML(false)
+	return encoder.Encode(v)
+}
+
+// opEnvName turns a label or path into an environment variable name, e.g.
+// db/password -> DB_PASSWORD
+func opEnvName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(name) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	env := strings.Trim(b.String(), "_")
+	if env != "" && env[0] >= '0' && env[0] <= '9' {
+		env = "_" + env
+	}
+	return env
+}
+
+// writeOpEnvList prints one NAME=op://... line per secret, which `op run
+// --env-file` accepts as is. Names come from the path below outputDir.
+// Secrets built from several references have no single line and are listed
+// as comments.
+func writeOpEnvList(w io.Writer, resolved []secrets.ResolvedSecret, outputDir string) error {
+	for _, secret := range resolved {
+		if secret.Reference == "" {
+			if _, err := fmt.Fprintf(w, "# %s: built from several references\n", secret.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		name := secret.Path
+		if rel, err := filepath.Rel(outputDir, secret.Path); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", opEnvName(name), secret.Reference); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeOpJSONList prints every single-reference secret as an op field
+// object without its value
+func writeOpJSONList(w io.Writer, resolved []secrets.ResolvedSecret) error {
+	fields := make([]opField, 0, len(resolved))
+	for _, secret := range resolved {
+		if secret.Reference == "" {
+			continue
+		}
+		field := newOpField(secret.Reference)
+		field.Path = secret.Path
+		fields = append(fields, field)
+	}
+	return writeOpJSON(w, fields)
+}
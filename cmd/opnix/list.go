@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+)
+
+type listCommand struct {
+	fs           *flag.FlagSet
+	configFile   string
+	configKey    string
+	configFormat string
+	outputDir    string
+	outputFormat string
+	tags         stringSliceFlag
+}
+
+func newListCommand() *listCommand {
+	lc := &listCommand{
+		fs: flag.NewFlagSet("list", flag.ExitOnError),
+	}
+
+	lc.fs.StringVar(&lc.configFile, "config", "secrets.json", "Path to secrets configuration file")
+	lc.fs.StringVar(&lc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	lc.fs.StringVar(&lc.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
+	lc.fs.StringVar(&lc.outputDir, "output", "secrets", "Directory secrets are stored in")
+	lc.fs.StringVar(&lc.outputFormat, "output-format", "files", "Output format: files, tree or json, or op-json or op-env for op CLI compatible output")
+	lc.fs.StringVar(&lc.outputFormat, "format", "files", "Same as -output-format, spelled as in the op CLI")
+	lc.fs.Var(&lc.tags, "tag", "Only list secrets carrying any of these tags (repeatable)")
+
+	lc.fs.Usage = func() {
+		fmt.Fprintf(lc.fs.Output(), "Usage: opnix list [options]\n\n")
+		fmt.Fprintf(lc.fs.Output(), "List where configured secrets will be written (values are never shown)\n\n")
+		fmt.Fprintf(lc.fs.Output(), "Options:\n")
+		lc.fs.PrintDefaults()
+	}
+
+	return lc
+}
+
+func (l *listCommand) Name() string { return l.fs.Name() }
+
+func (l *listCommand) Init(args []string) error {
+	if err := l.fs.Parse(args); err != nil {
+		return err
+	}
+
+	switch l.outputFormat {
+	case "files", "tree", "json", formatOpJSON, formatOpEnv:
+		return nil
+	default:
+		return fmt.Errorf("unknown output format: %s (expected files, tree, json, %s or %s)", l.outputFormat, formatOpJSON, formatOpEnv)
+	}
+}
+
+func (l *listCommand) Run() error {
+	cfg, err := config.LoadFormat(l.configFile, l.configKey, l.configFormat, nil)
+	if err != nil {
+		return err
+	}
+
+	var selected []config.Secret
+	for _, secret := range cfg.Secrets {
+		if hasAnyTag(secret, l.tags) {
+			selected = append(selected, secret)
+		}
+	}
+	cfg.Secrets = selected
+
+	// The client is never used: resolving paths does not touch 1Password
+	processor := secrets.NewProcessor(nil, l.outputDir)
+	resolved, err := processor.ResolvePaths(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch l.outputFormat {
+	case "tree":
+		return writeTree(os.Stdout, resolved)
+	case "json":
+		return writeJSON(os.Stdout, resolved)
+	case formatOpJSON:
+		return writeOpJSONList(os.Stdout, resolved)
+	case formatOpEnv:
+		return writeOpEnvList(os.Stdout, resolved, l.outputDir)
+	default:
+		return writeFiles(os.Stdout, resolved)
+	}
+}
+
+// writeFiles prints one line per secret file and symlink
+func writeFiles(w io.Writer, resolved []secrets.ResolvedSecret) error {
+	for _, secret := range resolved {
+		fmt.Fprintf(w, "%s\t%s\t%s:%s\t%s", secret.Path, secret.Mode, ownerOrDefault(secret.Owner), ownerOrDefault(secret.Group), secret.Reference)
+		if secret.Description != "" {
+			fmt.Fprintf(w, "\t# %s", secret.Description)
+		}
+		fmt.Fprintln(w)
+		for _, symlink := range secret.Symlinks {
+			fmt.Fprintf(w, "%s -> %s\n", symlink, secret.Path)
+		}
+		for _, secretCopy := range secret.Copies {
+			fmt.Fprintf(w, "%s\t%s\t%s:%s\tcopy of %s (%s)\n", secretCopy.Path, secretCopy.Mode, ownerOrDefault(secretCopy.Owner), ownerOrDefault(secretCopy.Group), secret.Path, encodingOrRaw(secretCopy.Encoding))
+		}
+	}
+	return nil
+}
+
+// writeJSON prints the resolved secrets as a JSON array for tooling
+func writeJSON(w io.Writer, resolved []secrets.ResolvedSecret) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(resolved)
+}
+
+// treeNode is a single directory or file in the tree view
+type treeNode struct {
+	children map[string]*treeNode
+	label    string
+}
+
+// writeTree prints a directory tree of where secrets and symlinks land
+func writeTree(w io.Writer, resolved []secrets.ResolvedSecret) error {
+	root := &treeNode{children: make(map[string]*treeNode)}
+
+	for _, secret := range resolved {
+		label := fmt.Sprintf(" (%s)", secret.Mode)
+		if secret.Description != "" {
+			label += " # " + secret.Description
+		}
+		root.insert(secret.Path, label)
+		for _, symlink := range secret.Symlinks {
+			root.insert(symlink, fmt.Sprintf(" -> %s", secret.Path))
+		}
+		for _, secretCopy := range secret.Copies {
+			root.insert(secretCopy.Path, fmt.Sprintf(" (%s, %s copy)", secretCopy.Mode, encodingOrRaw(secretCopy.Encoding)))
+		}
+	}
+
+	for _, name := range root.sortedNames() {
+		fmt.Fprintln(w, name+root.children[name].label)
+		root.children[name].print(w, "")
+	}
+	return nil
+}
+
+func (n *treeNode) insert(path, label string) {
+	path = filepath.Clean(path)
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if filepath.IsAbs(path) {
+		parts[0] = "/" + parts[0]
+	}
+
+	node := n
+	for _, part := range parts {
+		child, exists := node.children[part]
+		if !exists {
+			child = &treeNode{children: make(map[string]*treeNode)}
+			node.children[part] = child
+		}
+		node = child
+	}
+	node.label = label
+}
+
+func (n *treeNode) sortedNames() []string {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (n *treeNode) print(w io.Writer, indent string) {
+	names := n.sortedNames()
+	for i, name := range names {
+		branch, nextIndent := "├── ", "│   "
+		if i == len(names)-1 {
+			branch, nextIndent = "└── ", "    "
+		}
+		child := n.children[name]
+		fmt.Fprintf(w, "%s%s%s%s\n", indent, branch, name, child.label)
+		child.print(w, indent+nextIndent)
+	}
+}
+
+func ownerOrDefault(name string) string {
+	if name == "" {
+		return "root"
+	}
+	return name
+}
+
+func encodingOrRaw(encoding string) string {
+	if encoding == "" {
+		return "raw"
+	}
+	return encoding
+}
@@ -18,6 +18,19 @@ func main() {
 	cmds := []command{
 		newSecretCommand(),
 		newTokenCommand(),
+		newListCommand(),
+		newRunCommand(),
+		newValidateCommand(),
+		newExportSchemaCommand(),
+		newAuditCommand(),
+		newDoctorCommand(),
+		newUninstallCommand(),
+		newResolveCommand(),
+		newApplyPendingCommand(),
+		newTemplateCommand(),
+		newNixConfigCommand(),
+		newExplainPathCommand(),
+		newCheckCommand(),
 	}
 
 	if len(os.Args) < 2 {
@@ -48,7 +61,20 @@ func printUsage(cmds []command) {
 	fmt.Fprintf(os.Stderr, "Usage: opnix <command> [options]\n\n")
 	fmt.Fprintf(os.Stderr, "Available commands:\n")
 	fmt.Fprintf(os.Stderr, "  secret    Manage and retrieve secrets from 1Password\n")
-	fmt.Fprintf(os.Stderr, "  token     Manage the 1Password service account token\n\n")
+	fmt.Fprintf(os.Stderr, "  token     Manage the 1Password service account token\n")
+	fmt.Fprintf(os.Stderr, "  list      Show where configured secrets will be written\n")
+	fmt.Fprintf(os.Stderr, "  run       Run a command with secrets as environment variables\n")
+	fmt.Fprintf(os.Stderr, "  validate  Validate configuration offline\n")
+	fmt.Fprintf(os.Stderr, "  export-schema  Export vault/item/field names for offline validation\n")
+	fmt.Fprintf(os.Stderr, "  audit     Verify a signed audit log\n")
+	fmt.Fprintf(os.Stderr, "  doctor    Check token, configuration and output directory\n")
+	fmt.Fprintf(os.Stderr, "  uninstall Remove secret files and symlinks opnix created\n")
+	fmt.Fprintf(os.Stderr, "  resolve   Check that a single reference resolves\n")
+	fmt.Fprintf(os.Stderr, "  apply-pending  Run service restarts deferred to a maintenance window\n")
+	fmt.Fprintf(os.Stderr, "  template  Render a secret template with placeholder values\n")
+	fmt.Fprintf(os.Stderr, "  nix-config  Print or check the config the NixOS module writes\n")
+	fmt.Fprintf(os.Stderr, "  explain-path  Show how a secret's final path is computed\n")
+	fmt.Fprintf(os.Stderr, "  check     Exit 0 only if every secret file matches the config\n\n")
 	fmt.Fprintf(os.Stderr, "Use 'opnix <command> -h' for command-specific help\n")
 }
 
@@ -58,6 +84,12 @@ func handleError(err error) {
 		return
 	}
 
+	// A rejected token gets its own exit status, ahead of any wrapping
+	if authErr, ok := errors.AsUnauthorized(err); ok {
+		fmt.Fprintf(os.Stderr, "%s\n", authErr.Error())
+		os.Exit(errors.ExitUnauthorized)
+	}
+
 	// Check if it's an OpnixError with structured information
 	if opnixErr, ok := err.(*errors.OpnixError); ok {
 		// Print structured error with full context
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+type nixConfigCommand struct {
+	fs      *flag.FlagSet
+	options string
+	check   string
+	output  string
+}
+
+func newNixConfigCommand() *nixConfigCommand {
+	nc := &nixConfigCommand{
+		fs: flag.NewFlagSet("nix-config", flag.ExitOnError),
+	}
+
+	nc.fs.StringVar(&nc.options, "options", "", "services.onepassword-secrets options as JSON, or - for stdin")
+	nc.fs.StringVar(&nc.check, "check", "", "Check this config fragment instead of printing one; with -options it must match them exactly")
+	nc.fs.StringVar(&nc.output, "output", "", "Write the fragment to this file instead of stdout")
+
+	nc.fs.Usage = func() {
+		fmt.Fprintf(nc.fs.Output(), "Usage: opnix nix-config -options FILE [-output FILE]\n")
+		fmt.Fprintf(nc.fs.Output(), "       opnix nix-config -check FRAGMENT [-options FILE]\n\n")
+		fmt.Fprintf(nc.fs.Output(), "Print the config the NixOS module writes for its declarative options,\n")
+		fmt.Fprintf(nc.fs.Output(), "defaults included, or check that a fragment has the module's shape and\n")
+		fmt.Fprintf(nc.fs.Output(), "only fields this opnix accepts.\n\n")
+		fmt.Fprintf(nc.fs.Output(), "Example:\n")
+		fmt.Fprintf(nc.fs.Output(), "  nix eval --json .#nixosConfigurations.host.config.services.onepassword-secrets \\\n")
+		fmt.Fprintf(nc.fs.Output(), "    | opnix nix-config -options -\n\n")
+		fmt.Fprintf(nc.fs.Output(), "Options:\n")
+		nc.fs.PrintDefaults()
+	}
+
+	return nc
+}
+
+func (n *nixConfigCommand) Name() string { return n.fs.Name() }
+
+func (n *nixConfigCommand) Init(args []string) error {
+	if err := n.fs.Parse(args); err != nil {
+		return err
+	}
+	if n.options == "" && n.check == "" {
+		return errors.ConfigError(
+			"Parsing nix-config options",
+			"Either -options or -check is required",
+			fmt.Errorf("use -options options.json to print a fragment, or -check fragment.json to check one"),
+		)
+	}
+	if n.check != "" && n.output != "" {
+		return errors.ConfigError(
+			"Parsing nix-config options",
+			"-output cannot be combined with -check",
+			fmt.Errorf("-check only reports whether the fragment matches"),
+		)
+	}
+	return nil
+}
+
+func (n *nixConfigCommand) Run() error {
+	var options []byte
+	if n.options != "" {
+		var err error
+		if n.options == "-" {
+			options, err = io.ReadAll(os.Stdin)
+		} else {
+			options, err = os.ReadFile(n.options)
+		}
+		if err != nil {
+			return errors.FileOperationError("Reading NixOS module options", n.options, "Failed to read options file", err)
+		}
+	}
+
+	if n.check != "" {
+		fragment, err := os.ReadFile(n.check)
+		if err != nil {
+			return errors.FileOperationError("Reading NixOS config fragment", n.check, "Failed to read fragment file", err)
+		}
+		if err := config.CheckNixFragment(fragment, options); err != nil {
+			return err
+		}
+		fmt.Printf("%s matches the NixOS module's config format\n", n.check)
+		return nil
+	}
+
+	fragment, err := config.NixFragment(options)
+	if err != nil {
+		return err
+	}
+	fragment = append(fragment, '\n')
+
+	if n.output != "" {
+		if err := os.WriteFile(n.output, fragment, 0644); err != nil {
+			return errors.FileOperationError("Writing NixOS config fragment", n.output, "Failed to write output file", err)
+		}
+		return nil
+	}
+	_, err = os.Stdout.Write(fragment)
+	return err
+}
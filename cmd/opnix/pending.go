@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/systemd"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+type applyPendingCommand struct {
+	fs           *flag.FlagSet
+	configFile   string
+	configKey    string
+	configFormat string
+	force        bool
+	list         bool
+	dryRun       bool
+	parallel     int
+}
+
+func newApplyPendingCommand() *applyPendingCommand {
+	ac := &applyPendingCommand{
+		fs: flag.NewFlagSet("apply-pending", flag.ExitOnError),
+	}
+
+	ac.fs.StringVar(&ac.configFile, "config", "secrets.json", "Configuration whose systemdIntegration settings to use")
+	ac.fs.StringVar(&ac.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	ac.fs.StringVar(&ac.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
+	ac.fs.BoolVar(&ac.force, "force", false, "Run the deferred actions even outside every maintenance window")
+	ac.fs.BoolVar(&ac.list, "list", false, "Only show the deferred actions")
+	ac.fs.BoolVar(&ac.dryRun, "dry-run", false, "Show what would run without touching any service")
+	ac.fs.IntVar(&ac.parallel, "parallel-services", 0, "Run up to N service actions at once, overriding systemdIntegration.parallelServices")
+
+	ac.fs.Usage = func() {
+		fmt.Fprintf(ac.fs.Output(), "Usage: opnix apply-pending [options]\n\n")
+		fmt.Fprintf(ac.fs.Output(), "Run the service restarts and reloads deferred because their secrets changed\n")
+		fmt.Fprintf(ac.fs.Output(), "outside every systemdIntegration.maintenanceWindows entry. Outside a window\n")
+		fmt.Fprintf(ac.fs.Output(), "nothing runs unless -force is given.\n\n")
+		fmt.Fprintf(ac.fs.Output(), "Options:\n")
+		ac.fs.PrintDefaults()
+	}
+
+	return ac
+}
+
+func (a *applyPendingCommand) Name() string { return a.fs.Name() }
+
+func (a *applyPendingCommand) Init(args []string) error {
+	return a.fs.Parse(args)
+}
+
+func (a *applyPendingCommand) Run() error {
+	if a.parallel < 0 {
+		return fmt.Errorf("-parallel-services must not be negative")
+	}
+
+	collector := warnings.NewCollector()
+	cfg, err := config.LoadFormat(a.configFile, a.configKey, a.configFormat, collector)
+	if err != nil {
+		return err
+	}
+	if !cfg.SystemdIntegration.Enable {
+		fmt.Println("systemdIntegration is disabled; no service actions are deferred")
+		return nil
+	}
+
+	manager, err := systemd.NewManager(cfg.SystemdIntegration)
+	if err != nil {
+		return err
+	}
+	manager.SetWarnings(collector)
+	manager.SetDryRun(a.dryRun)
+	if a.parallel > 0 {
+		manager.SetParallelism(a.parallel)
+	}
+
+	if a.list {
+		pending, err := manager.PendingActions()
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Println("No deferred service actions")
+			return nil
+		}
+		for _, action := range pending {
+			kind := "reload"
+			if action.Signal != "" {
+				kind = "signal " + action.Signal
+			} else if action.Restart {
+				kind = "restart"
+			}
+			fmt.Printf("%-14s %-24s since %s  (%s)\n", kind, action.Name, action.Since.Format("2006-01-02 15:04"), strings.Join(action.Secrets, ", "))
+		}
+		return nil
+	}
+
+	err = manager.ApplyPending(a.force)
+	collector.Print(os.Stderr)
+	return err
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+	"github.com/brizzbuzz/opnix/internal/systemd"
+)
+
+// runDryRun prints the deployment plan without writing anything. Offline, no
+// token is read and references are shown as "<would resolve ...>"; online, each
+// reference is resolved to confirm access, but values are never shown.
+func (s *secretCommand) runDryRun() error {
+	if s.strictSchema {
+		if err := config.CheckSchema(s.configFile, s.configKey); err != nil {
+			return err
+		}
+	}
+
+	cfg, err := config.LoadFormat(s.configFile, s.configKey, s.configFormat, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := s.filterSecrets(cfg); err != nil {
+		return err
+	}
+
+	// Offline, clientFor stays nil and nothing is resolved
+	var clientFor func(config.Secret) (secrets.SecretClient, error)
+	if !s.offline {
+		client, err := onepass.NewClient(s.tokenFile, s.account, cfg.Network.Options())
+		if err != nil {
+			return err
+		}
+		accounts := accountClients(cfg)
+		clientFor = func(secret config.Secret) (secrets.SecretClient, error) {
+			if secret.Account == "" {
+				return client, nil
+			}
+			return accounts(secret.Account)
+		}
+	}
+
+	resolved, err := secrets.NewProcessor(nil, s.outputDir).ResolvePaths(cfg)
+	if err != nil {
+		return err
+	}
+
+	failed, err := writePlan(os.Stdout, cfg, resolved, clientFor)
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("dry run: %d reference(s) could not be resolved", failed)
+	}
+
+	log.Printf("Dry run complete, nothing was written")
+	return nil
+}
+
+// writePlan writes one entry per secret: the references it needs, where it
+// goes, how it is protected and which services it would restart. It returns
+// the number of references that failed to resolve when clients are given.
+func writePlan(w io.Writer, cfg *config.Config, resolved []secrets.ResolvedSecret, clientFor func(config.Secret) (secrets.SecretClient, error)) (int, error) {
+	failed := 0
+
+	// resolved has one entry per field of item secrets, so walk the same expansion
+	var expanded []config.Secret
+	for _, secret := range cfg.Secrets {
+		expanded = append(expanded, secret.ExpandFields()...)
+	}
+
+	for i, secret := range expanded {
+		target := resolved[i]
+
+		fmt.Fprintf(w, "%s\n", target.Path)
+		if target.Description != "" {
+			fmt.Fprintf(w, "  about:    %s\n", target.Description)
+		}
+		if secret.Account != "" {
+			fmt.Fprintf(w, "  account:  %s\n", secret.Account)
+		}
+
+		var client secrets.SecretClient
+		if clientFor != nil {
+			c, err := clientFor(secret)
+			if err != nil {
+				return failed, err
+			}
+			client = c
+		}
+
+		references := []string{secret.Reference}
+		labels := []string{""}
+		if len(secret.EnvFile) > 0 {
+			references, labels = nil, nil
+			for _, entry := range secret.EnvFile {
+				references = append(references, entry.Reference)
+				labels = append(labels, entry.Key+": ")
+			}
+		}
+		if len(secret.Bundle) > 0 {
+			references, labels = secret.Bundle, nil
+			for j := range secret.Bundle {
+				labels = append(labels, fmt.Sprintf("[%d] ", j))
+			}
+		}
+		if len(secret.SSHKeys) > 0 {
+			references, labels = secret.SSHKeys, nil
+			for j := range secret.SSHKeys {
+				labels = append(labels, fmt.Sprintf("[%d] ", j))
+			}
+		}
+		if len(secret.INI) > 0 {
+			references, labels = nil, nil
+			for _, entry := range secret.INI {
+				references = append(references, entry.Reference)
+				if entry.Section != "" {
+					labels = append(labels, fmt.Sprintf("[%s] %s: ", entry.Section, entry.Key))
+				} else {
+					labels = append(labels, entry.Key+": ")
+				}
+			}
+		}
+		for j, reference := range references {
+			status := planResolution(client, reference)
+			if strings.HasPrefix(status, "<failed") {
+				failed++
+			}
+			fmt.Fprintf(w, "  value:    %s%s\n", labels[j], status)
+		}
+
+		fmt.Fprintf(w, "  mode:     %s\n", target.Mode)
+		fmt.Fprintf(w, "  owner:    %s:%s\n", ownerOrDefault(target.Owner), ownerOrDefault(target.Group))
+		for _, link := range target.Symlinks {
+			fmt.Fprintf(w, "  symlink:  %s\n", link)
+		}
+		for _, secretCopy := range target.Copies {
+			fmt.Fprintf(w, "  copy:     %s (%s, %s, %s:%s)\n", secretCopy.Path, encodingOrRaw(secretCopy.Encoding),
+				secretCopy.Mode, ownerOrDefault(secretCopy.Owner), ownerOrDefault(secretCopy.Group))
+		}
+
+		actions, err := systemd.PlanServiceActions(cfg.SystemdIntegration, secret, target.Name)
+		if err != nil {
+			return failed, err
+		}
+		for _, action := range actions {
+			fmt.Fprintf(w, "  service:  %s %s\n", describeAction(action), action.Name)
+		}
+	}
+
+	return failed, nil
+}
+
+// planResolution describes a reference without ever including its value
+func planResolution(client secrets.SecretClient, reference string) string {
+	if client == nil {
+		return fmt.Sprintf("<would resolve %s>", reference)
+	}
+	if _, err := client.ResolveSecret(reference); err != nil {
+		return fmt.Sprintf("<failed to resolve %s: %v>", reference, err)
+	}
+	return fmt.Sprintf("<resolved %s>", reference)
+}
+
+func describeAction(action systemd.ServiceAction) string {
+	switch {
+	case action.Signal != "":
+		return "signal " + action.Signal + " to"
+	case action.Restart:
+		return "restart"
+	default:
+		return "reload"
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/onepass"
+)
+
+type resolveCommand struct {
+	fs          *flag.FlagSet
+	tokenFile   string
+	account     string
+	unsafePrint bool
+	format      string
+	reference   string
+}
+
+func newResolveCommand() *resolveCommand {
+	rc := &resolveCommand{
+		fs: flag.NewFlagSet("resolve", flag.ExitOnError),
+	}
+
+	rc.fs.StringVar(&rc.tokenFile, "token-file", defaultTokenPath, "Path to file containing 1Password service account token")
+	rc.fs.StringVar(&rc.account, "account", "", "1Password account the token must belong to, e.g. myteam or myteam.1password.com (default $OPNIX_ACCOUNT)")
+	rc.fs.BoolVar(&rc.unsafePrint, "unsafe-print", false, "Print the resolved value to stdout instead of only its length")
+	rc.fs.StringVar(&rc.format, "format", "", "Print the value in an op CLI compatible format: op-json (a field object) or op-env (LABEL=value); needs -unsafe-print")
+
+	rc.fs.Usage = func() {
+		fmt.Fprintf(rc.fs.Output(), "Usage: opnix resolve [options] <op://vault/item/field>\n\n")
+		fmt.Fprintf(rc.fs.Output(), "Resolve a single reference to check it works before adding it to a config.\n")
+		fmt.Fprintf(rc.fs.Output(), "Only the value's length is shown unless -unsafe-print is given.\n\n")
+		fmt.Fprintf(rc.fs.Output(), "Options:\n")
+		rc.fs.PrintDefaults()
+	}
+
+	return rc
+}
+
+func (r *resolveCommand) Name() string { return r.fs.Name() }
+
+func (r *resolveCommand) Init(args []string) error {
+	if err := r.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if r.fs.NArg() != 1 {
+		r.fs.Usage()
+		return fmt.Errorf("exactly one reference is required")
+	}
+	r.reference = r.fs.Arg(0)
+
+	switch r.format {
+	case "":
+	case formatOpJSON, formatOpEnv:
+		if !r.unsafePrint {
+			return fmt.Errorf("-format %s prints the value, so it needs -unsafe-print", r.format)
+		}
+	default:
+		return fmt.Errorf("unknown format: %s (expected %s or %s)", r.format, formatOpJSON, formatOpEnv)
+	}
+
+	// Catch typos before the token is read
+	ref, err := onepass.ParseReference(r.reference)
+	if err != nil {
+		return err
+	}
+	// A qualifier naming the token's own account (op://Prod@myteam/...) is
+	// checked against the token like -account
+	if ref.Account != "" {
+		if r.account != "" && r.account != ref.Account {
+			return fmt.Errorf("reference names account @%s but -account is %s", ref.Account, r.account)
+		}
+		r.account = ref.Account
+	}
+	return nil
+}
+
+func (r *resolveCommand) Run() error {
+	client, err := onepass.NewClient(r.tokenFile, r.account, onepass.NetworkOptions{})
+	if err != nil {
+		return err
+	}
+
+	value, err := client.ResolveSecret(r.reference)
+	if err != nil {
+		return err
+	}
+
+	if r.unsafePrint {
+		field := newOpField(r.reference)
+		field.Value = value
+		switch r.format {
+		case formatOpJSON:
+			return writeOpJSON(os.Stdout, field)
+		case formatOpEnv:
+			_, err := fmt.Fprintf(os.Stdout, "%s=%s\n", opEnvName(field.Label), value)
+			return err
+		}
+		_, err := fmt.Fprintln(os.Stdout, value)
+		return err
+	}
+	fmt.Printf("Resolved %s (%d bytes)\n", r.reference, len(value))
+	return nil
+}
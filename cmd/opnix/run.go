@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os/exec"
+	"syscall"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+)
+
+type runCommand struct {
+	fs         *flag.FlagSet
+	configFile string
+	configKey  string
+	tokenFile  string
+	account    string
+	command    []string
+}
+
+func newRunCommand() *runCommand {
+	rc := &runCommand{
+		fs: flag.NewFlagSet("run", flag.ExitOnError),
+	}
+
+	rc.fs.StringVar(&rc.configFile, "config", "secrets.json", "Path to configuration file with an env mapping")
+	rc.fs.StringVar(&rc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	rc.fs.StringVar(&rc.tokenFile, "token-file", defaultTokenPath, "Path to file containing 1Password service account token")
+	rc.fs.StringVar(&rc.account, "account", "", "1Password account the token must belong to, e.g. myteam or myteam.1password.com (default $OPNIX_ACCOUNT)")
+
+	rc.fs.Usage = func() {
+		fmt.Fprintf(rc.fs.Output(), "Usage: opnix run [options] -- <command> [args...]\n\n")
+		fmt.Fprintf(rc.fs.Output(), "Run a command with secrets injected as environment variables.\n")
+		fmt.Fprintf(rc.fs.Output(), "Nothing is written to disk; the config's \"env\" map assigns references to variable names.\n\n")
+		fmt.Fprintf(rc.fs.Output(), "Options:\n")
+		rc.fs.PrintDefaults()
+	}
+
+	return rc
+}
+
+func (r *runCommand) Name() string { return r.fs.Name() }
+
+func (r *runCommand) Init(args []string) error {
+	if err := r.fs.Parse(args); err != nil {
+		return err
+	}
+
+	r.command = r.fs.Args()
+	if len(r.command) == 0 {
+		r.fs.Usage()
+		return fmt.Errorf("command to run is required")
+	}
+
+	return nil
+}
+
+func (r *runCommand) Run() error {
+	cfg, err := config.LoadKey(r.configFile, r.configKey, nil)
+	if err != nil {
+		return err
+	}
+
+	if len(cfg.Env) == 0 {
+		return errors.ConfigError(
+			"Preparing environment",
+			"Configuration has no env mapping to inject",
+			nil,
+		)
+	}
+
+	binary, err := exec.LookPath(r.command[0])
+	if err != nil {
+		return errors.FileOperationError(
+			"Locating command",
+			r.command[0],
+			"Command not found in PATH",
+			err,
+		)
+	}
+
+	client, err := onepass.NewClient(r.tokenFile, r.account, cfg.Network.Options())
+	if err != nil {
+		return err
+	}
+
+	resolved, err := secrets.ResolveEnv(client, cfg.Env)
+	if err != nil {
+		return err
+	}
+
+	// Only names are logged, never values
+	log.Printf("Injecting %d environment variables into %s", len(resolved), r.command[0])
+
+	// Replace this process so the values live only in the child's environment
+	if err := syscall.Exec(binary, r.command, secrets.ChildEnvironment(resolved)); err != nil {
+		return errors.FileOperationError(
+			"Executing command",
+			binary,
+			"Failed to execute command",
+			err,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+)
+
+type exportSchemaCommand struct {
+	fs         *flag.FlagSet
+	tokenFile  string
+	account    string
+	outputFile string
+}
+
+func newExportSchemaCommand() *exportSchemaCommand {
+	ec := &exportSchemaCommand{
+		fs: flag.NewFlagSet("export-schema", flag.ExitOnError),
+	}
+
+	ec.fs.StringVar(&ec.tokenFile, "token-file", defaultTokenPath, "Path to file containing 1Password service account token")
+	ec.fs.StringVar(&ec.account, "account", "", "1Password account the token must belong to, e.g. myteam or myteam.1password.com (default $OPNIX_ACCOUNT)")
+	ec.fs.StringVar(&ec.outputFile, "output", "", "File to write the schema snapshot to (default: stdout)")
+
+	ec.fs.Usage = func() {
+		fmt.Fprintf(ec.fs.Output(), "Usage: opnix export-schema [options]\n\n")
+		fmt.Fprintf(ec.fs.Output(), "Export the names of accessible vaults, items and fields (never values)\n")
+		fmt.Fprintf(ec.fs.Output(), "for offline validation with 'opnix validate -schema'\n\n")
+		fmt.Fprintf(ec.fs.Output(), "Options:\n")
+		ec.fs.PrintDefaults()
+	}
+
+	return ec
+}
+
+func (e *exportSchemaCommand) Name() string { return e.fs.Name() }
+
+func (e *exportSchemaCommand) Init(args []string) error {
+	return e.fs.Parse(args)
+}
+
+func (e *exportSchemaCommand) Run() error {
+	client, err := onepass.NewClient(e.tokenFile, e.account, onepass.NetworkOptions{})
+	if err != nil {
+		return err
+	}
+
+	schema, err := client.ExportSchema(context.Background())
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return errors.ConfigError(
+			"Serializing vault schema",
+			"Failed to marshal schema snapshot",
+			err,
+		)
+	}
+	data = append(data, '\n')
+
+	if e.outputFile == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	if err := os.WriteFile(e.outputFile, data, 0644); err != nil {
+		return errors.FileOperationError(
+			"Writing vault schema",
+			e.outputFile,
+			"Failed to write schema snapshot",
+			err,
+		)
+	}
+
+	fmt.Fprintf(os.Stderr, "Schema snapshot written to %s\n", e.outputFile)
+	return nil
+}
@@ -1,25 +1,105 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/brizzbuzz/opnix/internal/audit"
 	"github.com/brizzbuzz/opnix/internal/config"
 	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/local"
+	"github.com/brizzbuzz/opnix/internal/lock"
 	"github.com/brizzbuzz/opnix/internal/onepass"
 	"github.com/brizzbuzz/opnix/internal/secrets"
+	"github.com/brizzbuzz/opnix/internal/systemd"
 	"github.com/brizzbuzz/opnix/internal/validation"
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
 const defaultTokenPath = "/etc/opnix-token"
 
+// Where secret values come from
+const (
+	backendOnePassword = "onepassword"
+	backendLocal       = "local"
+)
+
 type secretCommand struct {
-	fs         *flag.FlagSet
-	configFile string
-	outputDir  string
-	tokenFile  string
+	fs           *flag.FlagSet
+	configFile   string
+	configKey    string
+	configFormat string
+	outputDir    string
+	tokenFile    string
+	// Read the token from this open file descriptor instead; -1 when unset
+	tokenFD  int
+	account  string
+	only     stringSliceFlag
+	exclude  stringSliceFlag
+	tags     stringSliceFlag
+	verify   bool
+	dryRun   bool
+	offline  bool
+	auditLog string
+	auditKey string
+	// Process only the secrets the previous run did not write
+	retryFailed     bool
+	failureManifest string
+	// Every file and symlink opnix has created, for later lifecycle commands
+	managedManifest string
+	// Check access to every referenced vault before writing anything
+	preflightAccess bool
+	// Touched after a fully successful run, for staleness monitoring
+	successMarker string
+	// JSON record of outcomes and warnings, written after every run
+	summary string
+	// Prometheus text file written after every run; overrides the config's metricsFile when set
+	metricsFile string
+	// Fail the run when anything raised a warning
+	strictWarnings bool
+	// Exit cleanly when the config is missing or defines no secrets
+	allowEmpty bool
+	// Fail on config keys and value types the config schema does not declare
+	strictSchema bool
+	// What to do while another run holds the output directory's lock;
+	// override the config's lock settings when set
+	lockMode    string
+	lockTimeout time.Duration
+	// Where to POST a JSON event after each run; override the config's webhook when set
+	webhook        string
+	webhookTimeout time.Duration
+	// Resolve from 1Password, or from an encrypted local file when it is unreachable
+	backend       string
+	localFile     string
+	localIdentity string
+	// Who owns the parent directories created for secrets
+	outputOwnerDir string
+	dirOwnership   secrets.DirOwnership
+	// Collected during Run and reported once it finishes
+	warnings *warnings.Collector
+	outcomes []secrets.Outcome
+	cfg      *config.Config
+}
+
+// stringSliceFlag collects repeated (or comma-separated) flag values
+type stringSliceFlag []string
+
+func (f *stringSliceFlag) String() string { return strings.Join(*f, ",") }
+
+func (f *stringSliceFlag) Set(value string) error {
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			*f = append(*f, v)
+		}
+	}
+	return nil
 }
 
 func newSecretCommand() *secretCommand {
@@ -28,8 +108,38 @@ func newSecretCommand() *secretCommand {
 	}
 
 	sc.fs.StringVar(&sc.configFile, "config", "secrets.json", "Path to secrets configuration file")
+	sc.fs.StringVar(&sc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	sc.fs.StringVar(&sc.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
 	sc.fs.StringVar(&sc.outputDir, "output", "secrets", "Directory to store retrieved secrets")
 	sc.fs.StringVar(&sc.tokenFile, "token-file", defaultTokenPath, "Path to file containing 1Password service account token")
+	sc.fs.IntVar(&sc.tokenFD, "token-fd", -1, "Read the token from this open file descriptor, e.g. a pipe set up by a wrapper; takes precedence over OP_SERVICE_ACCOUNT_TOKEN and -token-file")
+	sc.fs.StringVar(&sc.account, "account", "", "1Password account the token must belong to, e.g. myteam or myteam.1password.com (default $OPNIX_ACCOUNT)")
+	sc.fs.Var(&sc.only, "only", "Only process secrets whose path matches this glob (repeatable)")
+	sc.fs.Var(&sc.exclude, "exclude", "Skip secrets whose path matches this glob (repeatable)")
+	sc.fs.Var(&sc.tags, "tag", "Only process secrets carrying any of these tags (repeatable)")
+	sc.fs.BoolVar(&sc.verify, "verify", false, "Re-read written files and fail if content, mode or owner drifted")
+	sc.fs.BoolVar(&sc.dryRun, "dry-run", false, "Show what would be written without writing anything")
+	sc.fs.BoolVar(&sc.offline, "offline", false, "With -dry-run, skip 1Password entirely (no token or network needed)")
+	sc.fs.StringVar(&sc.auditLog, "audit-log", "", "Append a hash-chained record of each secret's outcome to this file")
+	sc.fs.StringVar(&sc.auditKey, "audit-key", "", "File containing an HMAC key used to sign audit log entries")
+	sc.fs.BoolVar(&sc.retryFailed, "retry-failed", false, "Only process secrets the previous run failed to write")
+	sc.fs.StringVar(&sc.failureManifest, "failure-manifest", "", "Where to record failed secrets (default: OUTPUT/"+secrets.FailureManifestName+")")
+	sc.fs.StringVar(&sc.managedManifest, "managed-manifest", "", "Where to record every file and symlink opnix created, with content hashes (default: OUTPUT/"+secrets.ManagedManifestName+")")
+	sc.fs.BoolVar(&sc.preflightAccess, "preflight-access", false, "Before writing anything, check the token can access every referenced vault (one extra API call per token)")
+	sc.fs.StringVar(&sc.successMarker, "success-marker", "", "Write a timestamp and counts to this file after a fully successful run")
+	sc.fs.StringVar(&sc.metricsFile, "metrics-file", "", "Write run metrics in Prometheus text format to this file after every run, for a node-exporter textfile collector (e.g. /var/lib/node-exporter/opnix.prom)")
+	sc.fs.StringVar(&sc.summary, "summary", "", "Write each secret's outcome and all warnings as JSON to this file, whether or not the run succeeds")
+	sc.fs.BoolVar(&sc.allowEmpty, "allow-empty", false, "Exit successfully without doing anything when the config file is missing or defines no secrets")
+	sc.fs.StringVar(&sc.lockMode, "lock-mode", "", "When another run is writing the output directory: fail, wait (up to -lock-timeout, default 1m) or queue (default: lock.mode from the config, else wait)")
+	sc.fs.DurationVar(&sc.lockTimeout, "lock-timeout", 0, "How long to wait for another run to finish, e.g. 5m (default: lock.timeout from the config)")
+	sc.fs.StringVar(&sc.webhook, "webhook", "", "POST a JSON event with outcome counts, failures and affected services to this URL after each run; failures to post are only warnings (default: webhook.url from the config)")
+	sc.fs.DurationVar(&sc.webhookTimeout, "webhook-timeout", 0, "Timeout for the webhook request (default: webhook.timeout from the config, else 10s)")
+	sc.fs.StringVar(&sc.backend, "backend", backendOnePassword, "Where values come from: onepassword, or local to read -local-file without contacting 1Password")
+	sc.fs.StringVar(&sc.localFile, "local-file", "", "With -backend local, an age (.age) or gpg (.gpg, .asc) encrypted JSON object of reference -> value")
+	sc.fs.StringVar(&sc.localIdentity, "local-identity", "", "With -backend local, the age identity file, or a gpg passphrase file (gpg uses its keyring when unset)")
+	sc.fs.StringVar(&sc.outputOwnerDir, "output-owner-dir", "", "Chown parent directories opnix creates for a secret: \"secret\" to match each secret's owner and group, or OWNER[:GROUP]; existing directories are never changed")
+	sc.fs.BoolVar(&sc.strictSchema, "strict-schema", false, "Fail when the config has fields opnix does not know, such as a misspelt setting, or values of the wrong type")
+	sc.fs.BoolVar(&sc.strictWarnings, "strict-warnings", false, "Treat warnings as failures; warnings found while loading the config stop the run before anything is written")
 
 	sc.fs.Usage = func() {
 		fmt.Fprintf(sc.fs.Output(), "Usage: opnix secret [options]\n\n")
@@ -44,44 +154,514 @@ func newSecretCommand() *secretCommand {
 func (s *secretCommand) Name() string { return s.fs.Name() }
 
 func (s *secretCommand) Init(args []string) error {
-	return s.fs.Parse(args)
+	if err := s.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if s.offline && !s.dryRun {
+		return fmt.Errorf("-offline can only be used together with -dry-run")
+	}
+
+	if s.retryFailed && s.dryRun {
+		return fmt.Errorf("-retry-failed cannot be used together with -dry-run")
+	}
+
+	if s.tokenFD >= 0 {
+		if s.backend == backendLocal {
+			return fmt.Errorf("-token-fd cannot be used together with -backend local")
+		}
+		// A pipe can be read only once, so the token is read up front
+		if err := onepass.SetTokenFD(s.tokenFD); err != nil {
+			return err
+		}
+	}
+
+	if s.strictSchema && s.configFormat != config.FormatJSON {
+		return fmt.Errorf("-strict-schema only applies to JSON configs, not %s manifests", s.configFormat)
+	}
+
+	if s.failureManifest == "" {
+		s.failureManifest = filepath.Join(s.outputDir, secrets.FailureManifestName)
+	}
+	if s.managedManifest == "" {
+		s.managedManifest = filepath.Join(s.outputDir, secrets.ManagedManifestName)
+	}
+
+	if err := validation.NewValidator().ValidateLock(s.lockMode, ""); err != nil {
+		return err
+	}
+	if s.lockTimeout < 0 {
+		return fmt.Errorf("-lock-timeout must not be negative")
+	}
+	if err := validation.NewValidator().ValidateWebhook(s.webhook, ""); err != nil {
+		return err
+	}
+	if s.webhookTimeout < 0 {
+		return fmt.Errorf("-webhook-timeout must not be negative")
+	}
+	switch s.backend {
+	case backendOnePassword:
+		if s.localFile != "" || s.localIdentity != "" {
+			return fmt.Errorf("-local-file and -local-identity can only be used together with -backend local")
+		}
+	case backendLocal:
+		if s.localFile == "" {
+			return fmt.Errorf("-backend local needs -local-file")
+		}
+		if s.preflightAccess {
+			return fmt.Errorf("-preflight-access cannot be used together with -backend local")
+		}
+	default:
+		return fmt.Errorf("-backend must be %s or %s, got %q", backendOnePassword, backendLocal, s.backend)
+	}
+
+	dirOwnership, err := secrets.ParseDirOwnership(s.outputOwnerDir)
+	if err != nil {
+		return err
+	}
+	s.dirOwnership = dirOwnership
+
+	for _, pattern := range append(append([]string{}, s.only...), s.exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid path filter %q: %w", pattern, err)
+		}
+	}
+
+	return nil
 }
 
 func (s *secretCommand) Run() error {
+	if s.allowEmpty {
+		empty, err := config.IsEmptyFormat(s.configFile, s.configKey, s.configFormat)
+		if err != nil {
+			return err
+		}
+		if empty {
+			log.Printf("No secrets configured in %s, nothing to do", s.configFile)
+			return nil
+		}
+	}
+
+	if s.dryRun {
+		return s.runDryRun()
+	}
+
+	started := time.Now()
+	s.warnings = warnings.NewCollector()
+	err := s.run()
+	s.postWebhook(err, started)
+
+	// Everything not quite right is reported together, whatever the outcome
+	s.warnings.Print(os.Stderr)
+	if s.summary != "" {
+		if summaryErr := secrets.NewRunSummary(s.outcomes, s.warnings, err).Write(s.summary); summaryErr != nil && err == nil {
+			return summaryErr
+		}
+	}
+	if metricsErr := s.writeMetrics(err, started); metricsErr != nil && err == nil {
+		return metricsErr
+	}
+	return err
+}
+
+// writeMetrics writes the run's metrics file, if one is set, with the flag
+// taking precedence over the config's metricsFile
+func (s *secretCommand) writeMetrics(runErr error, started time.Time) error {
+	path := s.metricsFile
+	if path == "" && s.cfg != nil {
+		path = s.cfg.MetricsFile
+	}
+	if path == "" {
+		return nil
+	}
+
+	metrics := secrets.NewRunMetrics(s.outcomes, s.warnings, runErr, started)
+	if s.cfg != nil && s.cfg.SystemdIntegration.Enable {
+		// A broken pending file only costs the gauge, not the other metrics
+		if manager, err := systemd.NewManager(s.cfg.SystemdIntegration); err == nil {
+			if pending, err := manager.PendingActions(); err == nil {
+				count := len(pending)
+				metrics.PendingServiceActions = &count
+			}
+		}
+	}
+	return metrics.Write(path)
+}
+
+func (s *secretCommand) run() error {
 	// Pre-flight checks
 	if err := s.validatePrerequisites(); err != nil {
 		return err
 	}
 
+	if s.strictSchema {
+		if err := config.CheckSchema(s.configFile, s.configKey); err != nil {
+			return err
+		}
+	}
+
 	// Load configuration with improved error handling
-	cfg, err := config.Load(s.configFile)
+	cfg, err := config.LoadFormat(s.configFile, s.configKey, s.configFormat, s.warnings)
 	if err != nil {
 		// Error already has context from config.Load
 		return err
 	}
 
 	log.Printf("Loaded configuration with %d secrets", len(cfg.Secrets))
+	s.cfg = cfg
+
+	// Configuration warnings are known up front, so fail before writing anything
+	if s.strictWarnings {
+		if err := s.warnings.Err(); err != nil {
+			return err
+		}
+	}
 
-	// Initialize 1Password client with validation
-	client, err := onepass.NewClient(s.tokenFile)
+	// Narrow down to the requested subset of secrets, if any
+	if err := s.filterSecrets(cfg); err != nil {
+		return err
+	}
+
+	if s.retryFailed {
+		manifest, err := secrets.LoadFailureManifest(s.failureManifest)
+		if err != nil {
+			return err
+		}
+		manifest.Filter(cfg)
+		if len(cfg.Secrets) == 0 {
+			log.Printf("No failed secrets to retry")
+			return nil
+		}
+		log.Printf("Retrying %d secrets that failed in the previous run", len(cfg.Secrets))
+	}
+
+	// Only one run writes the output directory at a time
+	runLock, err := s.acquireLock(cfg)
 	if err != nil {
-		// Error already has context from onepass.NewClient
 		return err
 	}
+	defer func() { _ = runLock.Release() }()
+
+	var client secrets.SecretClient
+	if s.backend == backendLocal {
+		if client, err = local.NewClient(s.localFile, s.localIdentity); err != nil {
+			return err
+		}
+		log.Printf("Loaded local secrets file %s, resolving without 1Password", s.localFile)
+	} else {
+		if reference := firstLocalReference(cfg); reference != "" {
+			return errors.ConfigError(
+				"Initializing 1Password client",
+				fmt.Sprintf("Reference %s can only be resolved with -backend local", reference),
+				nil,
+			)
+		}
 
-	log.Printf("Initialized 1Password client successfully")
+		// Initialize 1Password client with validation
+		opClient, err := onepass.NewClient(s.tokenFile, s.account, cfg.Network.Options())
+		if err != nil {
+			// Error already has context from onepass.NewClient
+			return err
+		}
+
+		// Names the account up front, for tokens that turn out to be for another one
+		if identity, ok := opClient.Identity(); ok {
+			log.Printf("Initialized 1Password client, authenticated as %s", identity)
+		} else {
+			log.Printf("Initialized 1Password client successfully")
+		}
+
+		if s.preflightAccess {
+			if err := preflightVaultAccess(cfg, opClient); err != nil {
+				return err
+			}
+			log.Printf("Token can access every referenced vault")
+		}
+		client = opClient
+	}
 
 	// Process secrets with detailed progress
 	processor := secrets.NewProcessor(client, s.outputDir)
-	if err := processor.Process(cfg); err != nil {
+	processor.SetWarnings(s.warnings)
+	processor.SetDirOwnership(s.dirOwnership)
+	switch {
+	case s.backend == backendLocal:
+		// The local file stands in for every account
+		processor.SetAccountClients(func(string) (secrets.SecretClient, error) { return client, nil })
+	case len(cfg.Accounts) > 0:
+		processor.SetAccountClients(accountClients(cfg))
+	}
+	var cache *secrets.ResolveCache
+	// The cache is bound to a 1Password token, which a local run does not use
+	if cfg.Resolve.Cache.File != "" && s.backend != backendLocal {
+		token, err := onepass.GetToken(s.tokenFile)
+		if err != nil {
+			return err
+		}
+		if cache, err = secrets.OpenResolveCache(cfg.Resolve.Cache, token); err != nil {
+			return err
+		}
+		processor.SetResolveCache(cache)
+	}
+	processErr := processor.Process(cfg)
+	s.outcomes = processor.Outcomes()
+	// Values resolved before a failure are still worth keeping
+	if cache != nil {
+		if err := cache.Save(); err != nil {
+			s.warnings.Addf("file system", "%v", err)
+		}
+	}
+	// Partial failures must not leave links pointing at nothing
+	if err := processor.CheckSymlinks(cfg); err != nil {
+		if processErr == nil {
+			processErr = err
+		} else {
+			s.warnings.Addf("file system", "%v", err)
+		}
+	}
+	if err := secrets.NewFailureManifest(cfg, processor.Outcomes()).Write(s.failureManifest); err != nil {
+		s.warnings.Addf("file system", "%v", err)
+	}
+	// Files written before a failure are still on disk and still ours
+	if err := s.updateManagedManifest(processor.ManagedFiles()); err != nil {
+		s.warnings.Addf("file system", "%v", err)
+	}
+	if err := s.writeAuditLog(processor.Outcomes()); err != nil {
+		if processErr != nil {
+			s.warnings.Addf("audit", "%v", err)
+		} else {
+			return err
+		}
+	}
+	if processErr != nil {
 		// Error already has context from processor.Process
-		return err
+		return processErr
+	}
+
+	for _, outcome := range processor.Outcomes() {
+		if outcome.Skipped() {
+			log.Printf("Skipped %s: %s", outcome.Path, outcome.Reason)
+		}
+	}
+
+	if s.verify {
+		if err := processor.Verify(); err != nil {
+			return err
+		}
+		log.Printf("Verified all written secrets")
+	}
+
+	if s.strictWarnings {
+		if err := s.warnings.Err(); err != nil {
+			return err
+		}
+	}
+
+	if s.successMarker != "" {
+		if err := secrets.NewSuccessMarker(processor.Outcomes()).Write(s.successMarker); err != nil {
+			return err
+		}
 	}
 
 	log.Printf("Successfully processed all secrets to %s", s.outputDir)
 	return nil
 }
 
+// postWebhook reports the run to the webhook, if one is set. A failed post
+// is a warning and never fails the deploy.
+func (s *secretCommand) postWebhook(runErr error, started time.Time) {
+	url, timeout := s.webhook, s.webhookTimeout
+	if s.cfg != nil {
+		if url == "" {
+			url = s.cfg.Webhook.URL
+		}
+		if timeout == 0 && s.cfg.Webhook.Timeout != "" {
+			// Already validated while loading the config
+			timeout, _ = time.ParseDuration(s.cfg.Webhook.Timeout)
+		}
+	}
+	if url == "" {
+		return
+	}
+
+	event := secrets.NewDeployEvent(s.cfg, secrets.NewRunSummary(s.outcomes, s.warnings, runErr), started)
+	if err := event.Post(url, timeout); err != nil {
+		s.warnings.Addf("webhook", "%v", err)
+	}
+}
+
+// acquireLock takes the output directory's lock, with flags taking
+// precedence over the config's lock settings
+func (s *secretCommand) acquireLock(cfg *config.Config) (*lock.Lock, error) {
+	mode := s.lockMode
+	if mode == "" {
+		mode = cfg.Lock.Mode
+	}
+	timeout := s.lockTimeout
+	if timeout == 0 && cfg.Lock.Timeout != "" {
+		// Already validated while loading the config
+		timeout, _ = time.ParseDuration(cfg.Lock.Timeout)
+	}
+	return lock.Acquire(filepath.Join(s.outputDir, lock.FileName), mode, timeout)
+}
+
+// updateManagedManifest adds what this run wrote to the managed-file manifest
+func (s *secretCommand) updateManagedManifest(files []secrets.ManagedFile) error {
+	manifest, err := secrets.LoadManagedManifest(s.managedManifest)
+	if err != nil {
+		return err
+	}
+	manifest.Update(files)
+	return manifest.Write(s.managedManifest)
+}
+
+// firstLocalReference returns a local:// reference of the config, if any
+func firstLocalReference(cfg *config.Config) string {
+	for _, configured := range cfg.Secrets {
+		for _, secret := range configured.ExpandFields() {
+			for _, reference := range secret.References() {
+				if local.IsReference(reference) {
+					return reference
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// accountClients returns a lookup for the clients of the config's named accounts
+func accountClients(cfg *config.Config) func(string) (secrets.SecretClient, error) {
+	accounts := onepass.NewAccounts(cfg.AccountTokenFiles())
+	return func(name string) (secrets.SecretClient, error) {
+		client, err := accounts.Client(name)
+		if err != nil {
+			return nil, err
+		}
+		return client, nil
+	}
+}
+
+// preflightVaultAccess checks that each token can access the vaults its
+// secrets reference, reporting every inaccessible vault of a token at once
+func preflightVaultAccess(cfg *config.Config, client *onepass.Client) error {
+	references := make(map[string][]string)
+	for _, configured := range cfg.Secrets {
+		for _, secret := range configured.ExpandFields() {
+			references[secret.Account] = append(references[secret.Account], secret.References()...)
+		}
+	}
+
+	accounts := onepass.NewAccounts(cfg.AccountTokenFiles())
+	names := make([]string, 0, len(references))
+	for name := range references {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vaults, err := onepass.ReferencedVaults(references[name])
+		if err != nil {
+			return err
+		}
+
+		accountClient := client
+		if name != "" {
+			if accountClient, err = accounts.Client(name); err != nil {
+				return err
+			}
+		}
+		if err := accountClient.CheckVaultAccess(context.Background(), vaults); err != nil {
+			if name != "" {
+				return errors.Wrap(err, fmt.Sprintf("Checking vault access for account %s", name), "secret processing")
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeAuditLog records the outcome of this run in the audit log, if enabled
+func (s *secretCommand) writeAuditLog(outcomes []secrets.Outcome) error {
+	if s.auditLog == "" || len(outcomes) == 0 {
+		return nil
+	}
+
+	var key []byte
+	if s.auditKey != "" {
+		var err error
+		if key, err = audit.LoadKey(s.auditKey); err != nil {
+			return err
+		}
+	}
+
+	records := make([]audit.Record, len(outcomes))
+	for i, outcome := range outcomes {
+		records[i] = audit.Record{Path: outcome.Path, Description: outcome.Description, Outcome: outcome.Status}
+	}
+
+	return audit.NewLog(s.auditLog, key).Append(records...)
+}
+
+// filterSecrets applies --only and --exclude path filters and --tag
+// selectors to the loaded config
+func (s *secretCommand) filterSecrets(cfg *config.Config) error {
+	if len(s.only) == 0 && len(s.exclude) == 0 && len(s.tags) == 0 {
+		return nil
+	}
+
+	var selected []config.Secret
+	for _, secret := range cfg.Secrets {
+		included := (len(s.only) == 0 || matchesAnyPath(s.only, secret.Path)) && hasAnyTag(secret, s.tags)
+		if !included || matchesAnyPath(s.exclude, secret.Path) {
+			log.Printf("Skipping secret %s (excluded by filter)", secret.Path)
+			continue
+		}
+		selected = append(selected, secret)
+	}
+
+	if len(selected) == 0 {
+		return errors.ConfigError(
+			"Filtering secrets",
+			"No secrets left to process after applying --only/--exclude/--tag filters",
+			nil,
+		)
+	}
+
+	log.Printf("Processing %d of %d secrets after filtering", len(selected), len(cfg.Secrets))
+	cfg.Secrets = selected
+	return nil
+}
+
+// matchesAnyPath reports whether path matches any of the given glob patterns
+func matchesAnyPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if pattern == path {
+			return true
+		}
+		if matched, err := filepath.Match(pattern, path); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyTag reports whether secret carries one of tags; no tags selects
+// every secret
+func hasAnyTag(secret config.Secret, tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, tag := range secret.Tags {
+		for _, want := range tags {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // validatePrerequisites performs pre-flight checks before processing
 func (s *secretCommand) validatePrerequisites() error {
 	// Check if config file exists
@@ -99,12 +679,16 @@ func (s *secretCommand) validatePrerequisites() error {
 		return err
 	}
 
+	if s.backend == backendLocal || s.tokenFD >= 0 {
+		return nil // No token file is used
+	}
+
 	// Validate token file (but don't fail if missing - let graceful handling work)
 	validator := validation.NewValidator()
 	if err := validator.ValidateTokenFile(s.tokenFile); err != nil {
-		// For token errors, log a warning but don't fail
-		fmt.Fprintf(os.Stderr, "WARNING: %v\n", err)
-		fmt.Fprintf(os.Stderr, "INFO: Continuing with existing secrets if available\n")
+		// For token errors, record a warning but don't fail
+		s.warnings.Addf("authentication", "%v", err)
+		fmt.Fprintf(os.Stderr, "INFO: Token file check failed, continuing with existing secrets if available\n")
 	}
 
 	return nil
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestHasAnyTag(t *testing.T) {
+	tests := []struct {
+		name       string
+		secretTags []string
+		tags       []string
+		want       bool
+	}{
+		{"no selector selects untagged secrets", nil, nil, true},
+		{"no selector selects tagged secrets", []string{"tls"}, nil, true},
+		{"matching tag", []string{"tls", "prod"}, []string{"prod"}, true},
+		{"any of several selectors", []string{"db"}, []string{"tls", "db"}, true},
+		{"no matching tag", []string{"tls"}, []string{"db"}, false},
+		{"untagged secret with a selector", nil, []string{"tls"}, false},
+		{"tags are case-sensitive", []string{"TLS"}, []string{"tls"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasAnyTag(config.Secret{Tags: tt.secretTags}, tt.tags); got != tt.want {
+				t.Errorf("hasAnyTag(%q, %q) = %v, want %v", tt.secretTags, tt.tags, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterSecretsByTag(t *testing.T) {
+	secrets := []config.Secret{
+		{Path: "tls/cert.pem", Tags: []string{"tls", "prod"}},
+		{Path: "tls/key.pem", Tags: []string{"tls"}},
+		{Path: "db/password", Tags: []string{"db", "prod"}},
+		{Path: "api/token"},
+	}
+
+	tests := []struct {
+		name    string
+		only    []string
+		exclude []string
+		tags    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "no filters keeps everything", want: []string{"tls/cert.pem", "tls/key.pem", "db/password", "api/token"}},
+		{name: "one tag", tags: []string{"tls"}, want: []string{"tls/cert.pem", "tls/key.pem"}},
+		{name: "several tags select either", tags: []string{"db", "tls"}, want: []string{"tls/cert.pem", "tls/key.pem", "db/password"}},
+		{name: "tag and only must both match", only: []string{"tls/*"}, tags: []string{"prod"}, want: []string{"tls/cert.pem"}},
+		{name: "exclude removes tagged secrets", exclude: []string{"tls/key.pem"}, tags: []string{"tls"}, want: []string{"tls/cert.pem"}},
+		{name: "unknown tag selects nothing", tags: []string{"staging"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &secretCommand{only: tt.only, exclude: tt.exclude, tags: tt.tags}
+			cfg := &config.Config{Secrets: append([]config.Secret(nil), secrets...)}
+			err := cmd.filterSecrets(cfg)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected no secrets to be left, got %d", len(cfg.Secrets))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("filterSecrets() error = %v", err)
+			}
+
+			var got []string
+			for _, secret := range cfg.Secrets {
+				got = append(got, secret.Path)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Selected %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+)
+
+// templateVarFlag collects repeated name=value flags; unlike stringSliceFlag
+// it never splits on commas, which placeholder values may contain
+type templateVarFlag []string
+
+func (f *templateVarFlag) String() string { return strings.Join(*f, " ") }
+
+func (f *templateVarFlag) Set(value string) error {
+	if !strings.Contains(value, "=") {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	*f = append(*f, value)
+	return nil
+}
+
+type templateCommand struct {
+	fs     *flag.FlagSet
+	file   string
+	vars   templateVarFlag
+	json   bool
+	output string
+	input  secrets.TemplateInput
+}
+
+func newTemplateCommand() *templateCommand {
+	tc := &templateCommand{
+		fs: flag.NewFlagSet("template", flag.ExitOnError),
+	}
+
+	tc.fs.StringVar(&tc.file, "file", "", "Template file to render, or - for stdin")
+	tc.fs.Var(&tc.vars, "var", "Placeholder binding as name=value: secret, path, hostname or previous set {{ .Secret }}, {{ .Path }}, {{ .Hostname }} and {{ .Previous }}, any other name {{ .Variables.name }} (repeatable)")
+	tc.fs.BoolVar(&tc.json, "json", false, "Parse the secret as JSON for {{ .SecretJSON }}, as templateJSON does")
+	tc.fs.StringVar(&tc.output, "output", "", "Write the result to this file instead of stdout")
+
+	tc.fs.Usage = func() {
+		fmt.Fprintf(tc.fs.Output(), "Usage: opnix template -file FILE [-var name=value ...]\n\n")
+		fmt.Fprintf(tc.fs.Output(), "Render a secret template with placeholder values and print the result.\n")
+		fmt.Fprintf(tc.fs.Output(), "Templates get the same functions and data as in 'opnix secret'; nothing\n")
+		fmt.Fprintf(tc.fs.Output(), "is read from 1Password.\n\n")
+		fmt.Fprintf(tc.fs.Output(), "Example:\n")
+		fmt.Fprintf(tc.fs.Output(), "  opnix template -file db.env.tmpl -var secret=dummy -var host=db.internal\n\n")
+		fmt.Fprintf(tc.fs.Output(), "Options:\n")
+		tc.fs.PrintDefaults()
+	}
+
+	return tc
+}
+
+func (t *templateCommand) Name() string { return t.fs.Name() }
+
+func (t *templateCommand) Init(args []string) error {
+	if err := t.fs.Parse(args); err != nil {
+		return err
+	}
+	if t.file == "" {
+		return errors.ConfigError(
+			"Parsing template options",
+			"A template file is required",
+			fmt.Errorf("use -file tmpl.txt, or -file - to read the template from stdin"),
+		)
+	}
+
+	// Hostname defaults to this host's, as when the secret is written
+	t.input.Hostname, _ = os.Hostname()
+	t.input.JSON = t.json
+	t.input.Variables = make(map[string]string)
+	for _, binding := range t.vars {
+		name, value, _ := strings.Cut(binding, "=")
+		switch name {
+		case "secret":
+			t.input.Secret = value
+		case "path":
+			t.input.Path = value
+		case "hostname":
+			t.input.Hostname = value
+		case "previous":
+			t.input.Previous = value
+		default:
+			t.input.Variables[name] = value
+		}
+	}
+	return nil
+}
+
+func (t *templateCommand) Run() error {
+	var text []byte
+	var err error
+	name := t.file
+	if t.file == "-" {
+		name = "stdin"
+		text, err = io.ReadAll(os.Stdin)
+	} else {
+		text, err = os.ReadFile(t.file)
+	}
+	if err != nil {
+		return errors.FileOperationError("Reading template", t.file, "Failed to read template file", err)
+	}
+
+	rendered, err := secrets.RenderTemplate(string(text), name, t.input)
+	if err != nil {
+		return err
+	}
+
+	if t.output != "" {
+		if err := os.WriteFile(t.output, rendered, 0600); err != nil {
+			return errors.FileOperationError("Writing rendered template", t.output, "Failed to write output file", err)
+		}
+		return nil
+	}
+	_, err = os.Stdout.Write(rendered)
+	return err
+}
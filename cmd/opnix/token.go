@@ -3,19 +3,40 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"syscall"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/onepass"
 )
 
 const tokenFileMode = 0600
 
+// tokenBackupSuffix names the copy of the old token kept during rotation
+const tokenBackupSuffix = ".old"
+
+// verifyToken signs in with a replacement token; tests swap it out
+var verifyToken = onepass.VerifyToken
+
 type tokenCommand struct {
-	fs     *flag.FlagSet
-	path   string
-	action string
+	fs      *flag.FlagSet
+	path    string
+	jsonOut bool
+	action  string
+	// Read the new token from this open file descriptor instead of stdin
+	tokenFD int
+	// Config whose network settings the new token is verified through
+	configFile   string
+	configKey    string
+	configFormat string
 }
 
 func newTokenCommand() *tokenCommand {
@@ -24,12 +45,19 @@ func newTokenCommand() *tokenCommand {
 	}
 
 	tc.fs.StringVar(&tc.path, "path", defaultTokenPath, "Path to store the token file")
+	tc.fs.BoolVar(&tc.jsonOut, "json", false, "Print machine-readable JSON (get only)")
+	tc.fs.IntVar(&tc.tokenFD, "token-fd", -1, "Read the token from this open file descriptor instead of prompting on stdin (set and rotate only)")
+	tc.fs.StringVar(&tc.configFile, "config", "", "Verify the new token through this config's network proxy and CA bundle (rotate only)")
+	tc.fs.StringVar(&tc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	tc.fs.StringVar(&tc.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
 
 	tc.fs.Usage = func() {
 		fmt.Fprintf(tc.fs.Output(), "Usage: opnix token <command> [options]\n\n")
 		fmt.Fprintf(tc.fs.Output(), "Manage 1Password service account token\n\n")
 		fmt.Fprintf(tc.fs.Output(), "Commands:\n")
-		fmt.Fprintf(tc.fs.Output(), "  set     Set the service account token\n\n")
+		fmt.Fprintf(tc.fs.Output(), "  set     Set the service account token\n")
+		fmt.Fprintf(tc.fs.Output(), "  get     Show whether the token file exists, its mode and size\n")
+		fmt.Fprintf(tc.fs.Output(), "  rotate  Replace the token once the new one has proven it can sign in\n\n")
 		fmt.Fprintf(tc.fs.Output(), "Options:\n")
 		tc.fs.PrintDefaults()
 	}
@@ -50,13 +78,19 @@ func (t *tokenCommand) Init(args []string) error {
 	}
 
 	t.action = t.fs.Arg(0)
-	return nil
+
+	// Allow options after the subcommand, e.g. "opnix token get -json"
+	return t.fs.Parse(t.fs.Args()[1:])
 }
 
 func (t *tokenCommand) Run() error {
 	switch t.action {
 	case "set":
 		return t.setToken()
+	case "get":
+		return t.getToken()
+	case "rotate":
+		return t.rotateToken()
 	default:
 		return fmt.Errorf("unknown token action: %s", t.action)
 	}
@@ -95,25 +129,219 @@ func (t *tokenCommand) setToken() error {
 		return err
 	}
 
-	fmt.Fprintf(os.Stderr, "Please paste your 1Password service account token (press Enter when done):\n")
+	tokenStr, err := t.readToken("Please paste your 1Password service account token (press Enter when done):")
+	if err != nil {
+		return err
+	}
+
+	// Write token to file with secure permissions
+	if err := os.WriteFile(t.path, []byte(tokenStr), tokenFileMode); err != nil {
+		return fmt.Errorf("failed to write token file: %w", err)
+	}
+
+	fmt.Fprintf(os.Stderr, "Token successfully stored at %s\n", t.path)
+	return nil
+}
+
+// readToken reads the token from -token-fd, or prompts for it on stdin
+func (t *tokenCommand) readToken(prompt string) (string, error) {
+	if t.tokenFD >= 0 {
+		return onepass.ReadTokenFD(t.tokenFD)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", prompt)
+	return readTokenInput()
+}
 
+// readTokenInput reads one token line from stdin
+func readTokenInput() (string, error) {
 	reader := bufio.NewReader(os.Stdin)
 	token, err := reader.ReadString('\n')
 	if err != nil {
-		return fmt.Errorf("error reading input: %w", err)
+		return "", fmt.Errorf("error reading input: %w", err)
 	}
 
 	// Trim whitespace and newlines
-	tokenStr := strings.TrimSpace(token)
-	if tokenStr == "" {
-		return fmt.Errorf("token cannot be empty")
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return "", fmt.Errorf("token cannot be empty")
 	}
+	return token, nil
+}
 
-	// Write token to file with secure permissions
-	if err := os.WriteFile(t.path, []byte(tokenStr), tokenFileMode); err != nil {
-		return fmt.Errorf("failed to write token file: %w", err)
+// rotateToken installs a new token only after it has signed in. The old token
+// is kept next to the file until the new one is in place and re-read, and is
+// restored if anything fails, so a bad token never locks the host out.
+func (t *tokenCommand) rotateToken() error {
+	var network onepass.NetworkOptions
+	if t.configFile != "" {
+		cfg, err := config.LoadFormat(t.configFile, t.configKey, t.configFormat, nil)
+		if err != nil {
+			return err
+		}
+		network = cfg.Network.Options()
 	}
 
-	fmt.Fprintf(os.Stderr, "Token successfully stored at %s\n", t.path)
+	if err := t.checkWritePermissions(); err != nil {
+		return err
+	}
+
+	old, err := os.ReadFile(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot read current token file %s: %w", t.path, err)
+	}
+
+	token, err := t.readToken("Please paste the new 1Password service account token (press Enter when done):")
+	if err != nil {
+		return err
+	}
+	if token == strings.TrimSpace(string(old)) {
+		return fmt.Errorf("the new token is the same as the current one")
+	}
+
+	vaults, err := verifyToken(context.Background(), token, network)
+	if err != nil {
+		return fmt.Errorf("new token failed verification, the current token was left in place: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "New token signed in and can access %d vaults\n", vaults)
+
+	backup := t.path + tokenBackupSuffix
+	if old != nil {
+		if err := replaceTokenFile(backup, old, t.path); err != nil {
+			return fmt.Errorf("failed to back up the current token, nothing was changed: %w", err)
+		}
+	}
+
+	// The replacement is atomic, so a failed write leaves the old token in place
+	if err := replaceTokenFile(t.path, []byte(token), t.path); err != nil {
+		return fmt.Errorf("failed to write the new token, the current token was left in place (backup at %s): %w", backup, err)
+	}
+
+	written, err := os.ReadFile(t.path)
+	if err != nil || strings.TrimSpace(string(written)) != token {
+		if old != nil {
+			if restoreErr := replaceTokenFile(t.path, old, backup); restoreErr != nil {
+				return fmt.Errorf("token file does not hold the new token and restoring %s failed: %w", backup, restoreErr)
+			}
+		}
+		return fmt.Errorf("token file does not hold the new token after writing, the previous token was restored")
+	}
+
+	if old != nil {
+		_ = os.Remove(backup) // The new token is verified and in place
+	}
+	fmt.Fprintf(os.Stderr, "Token rotated at %s\n", t.path)
+	return nil
+}
+
+// replaceTokenFile atomically replaces path with data. The mode and owner are
+// copied from like, when it exists, so group access to the token survives.
+func replaceTokenFile(path string, data []byte, like string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-token-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if info, statErr := os.Stat(like); statErr == nil {
+		if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && os.Geteuid() == 0 {
+			if err := tmp.Chown(int(stat.Uid), int(stat.Gid)); err != nil {
+				_ = tmp.Close()
+				return err
+			}
+		}
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// tokenStatus describes the token file without revealing the token
+type tokenStatus struct {
+	Exists      bool   `json:"exists"`
+	Path        string `json:"path"`
+	Mode        string `json:"mode,omitempty"`
+	Size        int64  `json:"size"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Account the token authenticates to, decoded from the token itself
+	Account string `json:"account,omitempty"`
+}
+
+// inspectToken reads the token file's metadata and a fingerprint of its content
+func inspectToken(path string) (tokenStatus, error) {
+	status := tokenStatus{Path: path}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return status, nil
+	}
+	if err != nil {
+		return status, fmt.Errorf("cannot stat token file %s: %w", path, err)
+	}
+
+	status.Exists = true
+	status.Mode = fmt.Sprintf("%04o", info.Mode().Perm())
+	status.Size = info.Size()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return status, fmt.Errorf("cannot read token file %s: %w", path, err)
+	}
+	status.Fingerprint = tokenFingerprint(strings.TrimSpace(string(content)))
+	if identity, err := onepass.TokenIdentity(string(content)); err == nil {
+		status.Account = identity.String()
+	}
+
+	return status, nil
+}
+
+// tokenFingerprint identifies a token without revealing any of it. Service
+// account tokens all start with the same "ops_eyJ", so a prefix would not
+// tell them apart; a short hash does.
+func tokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:6])
+}
+
+func (t *tokenCommand) getToken() error {
+	status, err := inspectToken(t.path)
+	if err != nil {
+		return err
+	}
+
+	if t.jsonOut {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(status)
+	}
+
+	if !status.Exists {
+		fmt.Printf("Token file %s does not exist\n", status.Path)
+		return nil
+	}
+
+	fmt.Printf("Path:   %s\n", status.Path)
+	fmt.Printf("Mode:   %s\n", status.Mode)
+	fmt.Printf("Size:   %d bytes\n", status.Size)
+	fmt.Printf("Fingerprint: %s\n", status.Fingerprint)
+	if status.Account != "" {
+		fmt.Printf("Account: %s\n", status.Account)
+	}
 	return nil
 }
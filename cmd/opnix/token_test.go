@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/onepass"
+)
+
+// tokenFD returns a descriptor holding token, for -token-fd
+func tokenFD(t *testing.T, token string) int {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "new-token")
+	if err := os.WriteFile(path, []byte(token+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open token: %v", err)
+	}
+	defer file.Close()
+	// ReadTokenFD closes the descriptor it is given, so hand it a copy
+	fd, err := syscall.Dup(int(file.Fd()))
+	if err != nil {
+		t.Fatalf("Failed to duplicate descriptor: %v", err)
+	}
+	return fd
+}
+
+// stubVerifyToken replaces token verification for the test, recording the
+// network options it was given
+func stubVerifyToken(t *testing.T, err error) *onepass.NetworkOptions {
+	t.Helper()
+	var got onepass.NetworkOptions
+	original := verifyToken
+	verifyToken = func(ctx context.Context, token string, network onepass.NetworkOptions) (int, error) {
+		got = network
+		return 2, err
+	}
+	t.Cleanup(func() { verifyToken = original })
+	return &got
+}
+
+func TestRotateToken(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	if err := os.WriteFile(path, []byte("old-token"), 0640); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+	configFile := filepath.Join(dir, "secrets.json")
+	configJSON := `{
+		"secrets": [{"path": "db", "reference": "op://Vault/DB/password"}],
+		"network": {"proxy": "http://proxy.internal:3128"}
+	}`
+	if err := os.WriteFile(configFile, []byte(configJSON), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	t.Run("verifies through the config's network settings", func(t *testing.T) {
+		network := stubVerifyToken(t, nil)
+		cmd := &tokenCommand{path: path, tokenFD: tokenFD(t, "new-token"), configFile: configFile, configFormat: "json"}
+		if err := cmd.rotateToken(); err != nil {
+			t.Fatalf("rotateToken() error = %v", err)
+		}
+		if network.Proxy != "http://proxy.internal:3128" {
+			t.Errorf("Expected verification through the configured proxy, got %+v", *network)
+		}
+
+		if content, _ := os.ReadFile(path); string(content) != "new-token" {
+			t.Errorf("Expected the new token to be installed, got %q", content)
+		}
+		if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+			t.Errorf("Expected the token file to keep mode 0640, got %o", info.Mode().Perm())
+		}
+		if _, err := os.Stat(path + tokenBackupSuffix); !os.IsNotExist(err) {
+			t.Errorf("Expected the backup to be removed once the new token is in place, got %v", err)
+		}
+	})
+
+	t.Run("a token that fails verification is not installed", func(t *testing.T) {
+		stubVerifyToken(t, fmt.Errorf("unauthorized"))
+		cmd := &tokenCommand{path: path, tokenFD: tokenFD(t, "bad-token")}
+		err := cmd.rotateToken()
+		if err == nil || !strings.Contains(err.Error(), "failed verification") {
+			t.Fatalf("Expected a verification failure, got: %v", err)
+		}
+		if content, _ := os.ReadFile(path); string(content) != "new-token" {
+			t.Errorf("Expected the current token to be left in place, got %q", content)
+		}
+	})
+
+	t.Run("the same token is rejected", func(t *testing.T) {
+		stubVerifyToken(t, nil)
+		cmd := &tokenCommand{path: path, tokenFD: tokenFD(t, "new-token")}
+		if err := cmd.rotateToken(); err == nil || !strings.Contains(err.Error(), "same as the current one") {
+			t.Errorf("Expected an unchanged token to be rejected, got: %v", err)
+		}
+	})
+}
+
+func TestReplaceTokenFile(t *testing.T) {
+	dir := t.TempDir()
+	like := filepath.Join(dir, "token")
+	if err := os.WriteFile(like, []byte("current"), 0640); err != nil {
+		t.Fatalf("Failed to write token: %v", err)
+	}
+
+	backup := like + tokenBackupSuffix
+	if err := replaceTokenFile(backup, []byte("current"), like); err != nil {
+		t.Fatalf("replaceTokenFile() error = %v", err)
+	}
+	if content, _ := os.ReadFile(backup); string(content) != "current" {
+		t.Errorf("Expected the backup to hold the token, got %q", content)
+	}
+	if info, _ := os.Stat(backup); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the mode to be copied from the original, got %o", info.Mode().Perm())
+	}
+
+	// Without an original to copy, the file stays owner-only
+	fresh := filepath.Join(dir, "fresh")
+	if err := replaceTokenFile(fresh, []byte("token"), filepath.Join(dir, "missing")); err != nil {
+		t.Fatalf("replaceTokenFile() error = %v", err)
+	}
+	if info, _ := os.Stat(fresh); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected mode 0600 without an original, got %o", info.Mode().Perm())
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".opnix-token-") {
+			t.Errorf("Expected no temporary files left behind, found %s", entry.Name())
+		}
+	}
+
+	if err := replaceTokenFile(filepath.Join(dir, "missing-dir", "token"), []byte("token"), like); err == nil {
+		t.Error("Expected a write into a missing directory to fail")
+	}
+}
+
+func TestTokenFingerprint(t *testing.T) {
+	first := tokenFingerprint("ops_eyJzaWduSW5BZGRyZXNzIjoiYWNtZSJ9first")
+	second := tokenFingerprint("ops_eyJzaWduSW5BZGRyZXNzIjoiYWNtZSJ9second")
+	if first == second {
+		t.Errorf("Expected tokens sharing a prefix to get different fingerprints, both got %s", first)
+	}
+	if strings.Contains(first, "ops_") || strings.Contains(first, "eyJ") {
+		t.Errorf("Expected the fingerprint to reveal nothing of the token, got %s", first)
+	}
+	if got := tokenFingerprint("ops_eyJzaWduSW5BZGRyZXNzIjoiYWNtZSJ9first"); got != first {
+		t.Errorf("Expected the same token to get the same fingerprint, got %s and %s", first, got)
+	}
+	if got := tokenFingerprint(""); got != "" {
+		t.Errorf("Expected no fingerprint for an empty token, got %s", got)
+	}
+}
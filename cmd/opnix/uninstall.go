@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/secrets"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+type uninstallCommand struct {
+	fs              *flag.FlagSet
+	configFile      string
+	configKey       string
+	configFormat    string
+	outputDir       string
+	managedManifest string
+	all             bool
+	dryRun          bool
+	pruneDirs       bool
+}
+
+func newUninstallCommand() *uninstallCommand {
+	uc := &uninstallCommand{
+		fs: flag.NewFlagSet("uninstall", flag.ExitOnError),
+	}
+
+	uc.fs.StringVar(&uc.configFile, "config", "secrets.json", "Remove the files this configuration wrote")
+	uc.fs.StringVar(&uc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	uc.fs.StringVar(&uc.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
+	uc.fs.StringVar(&uc.outputDir, "output", "secrets", "Directory secrets are stored in")
+	uc.fs.StringVar(&uc.managedManifest, "managed-manifest", "", "Managed-file manifest written by 'opnix secret' (default: OUTPUT/"+secrets.ManagedManifestName+")")
+	uc.fs.BoolVar(&uc.all, "all", false, "Remove every file and symlink in the manifest, whatever config wrote it")
+	uc.fs.BoolVar(&uc.dryRun, "dry-run", false, "Show what would be removed without changing anything")
+	uc.fs.BoolVar(&uc.pruneDirs, "prune-dirs", false, "Also remove directories under the output directory left empty")
+
+	uc.fs.Usage = func() {
+		fmt.Fprintf(uc.fs.Output(), "Usage: opnix uninstall [options]\n\n")
+		fmt.Fprintf(uc.fs.Output(), "Remove secret files and symlinks opnix created. Only paths listed in the\n")
+		fmt.Fprintf(uc.fs.Output(), "managed-file manifest are touched, and anything changed since opnix wrote\n")
+		fmt.Fprintf(uc.fs.Output(), "it is left in place.\n\n")
+		fmt.Fprintf(uc.fs.Output(), "Options:\n")
+		uc.fs.PrintDefaults()
+	}
+
+	return uc
+}
+
+func (u *uninstallCommand) Name() string { return u.fs.Name() }
+
+func (u *uninstallCommand) Init(args []string) error {
+	if err := u.fs.Parse(args); err != nil {
+		return err
+	}
+	if u.managedManifest == "" {
+		u.managedManifest = filepath.Join(u.outputDir, secrets.ManagedManifestName)
+	}
+	return nil
+}
+
+func (u *uninstallCommand) Run() error {
+	collector := warnings.NewCollector()
+	defer collector.Print(os.Stderr)
+
+	manifest, err := secrets.LoadManagedManifest(u.managedManifest)
+	if err != nil {
+		return err
+	}
+
+	files := manifest.Files
+	if !u.all {
+		cfg, err := config.LoadFormat(u.configFile, u.configKey, u.configFormat, collector)
+		if err != nil {
+			return err
+		}
+		// The client is never used: resolving paths does not touch 1Password
+		resolved, err := secrets.NewProcessor(nil, u.outputDir).ResolvePaths(cfg)
+		if err != nil {
+			return err
+		}
+		files = manifest.Select(resolved)
+	}
+
+	if len(files) == 0 {
+		fmt.Printf("Nothing to remove: no managed files found in %s\n", u.managedManifest)
+		return nil
+	}
+
+	results, err := secrets.RemoveManaged(files, u.outputDir, u.dryRun, u.pruneDirs)
+	for _, result := range results {
+		line := fmt.Sprintf("%-12s %s", result.Status, result.Path)
+		if result.Reason != "" {
+			line += " (" + result.Reason + ")"
+		}
+		fmt.Println(line)
+	}
+
+	if u.dryRun {
+		return err
+	}
+
+	// Record removals even when a later file failed, so a retry only sees
+	// what is left
+	manifest.Remove(results)
+	if writeErr := manifest.Write(u.managedManifest); writeErr != nil {
+		if err == nil {
+			err = writeErr
+		} else {
+			collector.Addf("file system", "Failed to record the removals in %s: %v", u.managedManifest, writeErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+)
+
+type validateCommand struct {
+	fs          *flag.FlagSet
+	configFiles stringSliceFlag
+	configDir   string
+	schemaFile  string
+	// Key of a larger JSON document the config files sit under
+	configKey    string
+	configFormat string
+	// Fail on config keys and value types the config schema does not declare
+	strictSchema bool
+}
+
+func newValidateCommand() *validateCommand {
+	vc := &validateCommand{
+		fs: flag.NewFlagSet("validate", flag.ExitOnError),
+	}
+
+	vc.fs.Var(&vc.configFiles, "config", "Path to secrets configuration file (repeatable)")
+	vc.fs.StringVar(&vc.configDir, "config-dir", "", "Directory whose *.json files are merged and validated as one config, e.g. /etc/opnix/conf.d")
+	vc.fs.StringVar(&vc.configKey, "config-key", "", "Read the config from under this key of a larger JSON document, e.g. opnix or services.opnix")
+	vc.fs.StringVar(&vc.configFormat, "config-format", config.FormatJSON, "Format of the config file: json, or a csv or tsv manifest with one secret per row")
+	vc.fs.StringVar(&vc.schemaFile, "schema", "", "Vault schema snapshot from 'opnix export-schema' to check references against")
+	vc.fs.BoolVar(&vc.strictSchema, "strict-schema", false, "Fail when the config has fields opnix does not know, such as a misspelt setting, or values of the wrong type")
+
+	vc.fs.Usage = func() {
+		fmt.Fprintf(vc.fs.Output(), "Usage: opnix validate [options]\n\n")
+		fmt.Fprintf(vc.fs.Output(), "Validate configuration offline, without a token or network access\n\n")
+		fmt.Fprintf(vc.fs.Output(), "Options:\n")
+		vc.fs.PrintDefaults()
+	}
+
+	return vc
+}
+
+func (v *validateCommand) Name() string { return v.fs.Name() }
+
+func (v *validateCommand) Init(args []string) error {
+	if err := v.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if v.configDir != "" && len(v.configFiles) > 0 {
+		return fmt.Errorf("-config and -config-dir cannot be combined")
+	}
+	if v.configDir != "" && (v.configKey != "" || v.configFormat != config.FormatJSON) {
+		return fmt.Errorf("-config-key and -config-format apply to -config files, not -config-dir")
+	}
+	if v.configDir == "" && len(v.configFiles) == 0 {
+		v.configFiles = stringSliceFlag{"secrets.json"}
+	}
+
+	return nil
+}
+
+func (v *validateCommand) Run() error {
+	if v.strictSchema {
+		if err := v.checkSchema(); err != nil {
+			return err
+		}
+	}
+
+	var cfg *config.Config
+	var err error
+	if v.configDir != "" {
+		cfg, err = config.LoadDir(v.configDir, nil)
+	} else {
+		cfg, err = config.LoadMultipleFormat(v.configFiles, v.configKey, v.configFormat)
+	}
+	if err != nil {
+		return err
+	}
+
+	if v.schemaFile != "" {
+		schema, err := onepass.LoadSchema(v.schemaFile)
+		if err != nil {
+			return err
+		}
+		if err := checkReferencesAgainstSchema(cfg, schema); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Configuration is valid (%d secrets, %d environment variables)\n", len(cfg.Secrets), len(cfg.Env))
+	return nil
+}
+
+// checkSchema checks every config file, or every *.json file of the config
+// directory, against the config schema
+func (v *validateCommand) checkSchema() error {
+	paths := []string(v.configFiles)
+	if v.configDir != "" {
+		// A bad directory is reported by LoadDir
+		paths, _ = filepath.Glob(filepath.Join(v.configDir, "*.json"))
+	}
+	for _, path := range paths {
+		if err := config.CheckSchema(path, v.configKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkWithFallbacks accepts a reference when its field or any fallback field exists
+func checkWithFallbacks(schema *onepass.Schema, reference string, fallbacks []string) error {
+	err := schema.CheckReference(reference)
+	if err == nil || len(fallbacks) == 0 {
+		return err
+	}
+
+	ref, parseErr := onepass.ParseReference(reference)
+	if parseErr != nil {
+		return err
+	}
+	for _, field := range fallbacks {
+		if schema.CheckReference(ref.WithField(field).String()) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// checkReferencesAgainstSchema reports every reference missing from the snapshot at once
+func checkReferencesAgainstSchema(cfg *config.Config, schema *onepass.Schema) error {
+	var problems []string
+
+	for i, secret := range cfg.Secrets {
+		for _, entry := range secret.EnvFile {
+			if err := schema.CheckReference(entry.Reference); err != nil {
+				problems = append(problems, fmt.Sprintf("secret[%d].envFile.%s (%s): %v", i, entry.Key, entry.Reference, err))
+			}
+		}
+		for _, entry := range secret.INI {
+			placement := entry.Key
+			if entry.Section != "" {
+				placement = entry.Section + "." + entry.Key
+			}
+			if err := schema.CheckReference(entry.Reference); err != nil {
+				problems = append(problems, fmt.Sprintf("secret[%d].ini.%s (%s): %v", i, placement, entry.Reference, err))
+			}
+		}
+		for j, reference := range secret.Bundle {
+			if err := schema.CheckReference(reference); err != nil {
+				problems = append(problems, fmt.Sprintf("secret[%d].bundle[%d] (%s): %v", i, j, reference, err))
+			}
+		}
+		for j, reference := range secret.SSHKeys {
+			if err := schema.CheckReference(reference); err != nil {
+				problems = append(problems, fmt.Sprintf("secret[%d].sshKeys[%d] (%s): %v", i, j, reference, err))
+			}
+		}
+		if secret.Item != "" {
+			for _, field := range secret.ExpandFields() {
+				if err := schema.CheckReference(field.Reference); err != nil {
+					problems = append(problems, fmt.Sprintf("secret[%d] (%s): %v", i, field.Reference, err))
+				}
+			}
+			continue
+		}
+		if secret.Reference == "" {
+			continue
+		}
+		if err := checkWithFallbacks(schema, secret.Reference, secret.FieldFallbacks); err != nil {
+			problems = append(problems, fmt.Sprintf("secret[%d] (%s): %v", i, secret.Reference, err))
+		}
+	}
+
+	envKeys := make([]string, 0, len(cfg.Env))
+	for key := range cfg.Env {
+		envKeys = append(envKeys, key)
+	}
+	sort.Strings(envKeys)
+	for _, key := range envKeys {
+		if err := schema.CheckReference(cfg.Env[key]); err != nil {
+			problems = append(problems, fmt.Sprintf("env.%s (%s): %v", key, cfg.Env[key], err))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	return &errors.OpnixError{
+		Operation: "Validating references against schema snapshot",
+		Component: "configuration",
+		Issue:     fmt.Sprintf("%d reference(s) not found in schema snapshot", len(problems)),
+		Context:   strings.Join(problems, "\n    "),
+		Suggestions: []string{
+			"Check the vault, item and field names in each reference",
+			"Regenerate the snapshot if the vault contents changed: opnix export-schema",
+		},
+	}
+}
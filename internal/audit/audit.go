@@ -0,0 +1,235 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Record is one outcome to log
+type Record struct {
+	Path        string `json:"path"`
+	Description string `json:"description,omitempty"`
+	Outcome     string `json:"outcome"`
+}
+
+// Entry is a record as stored in the log
+type Entry struct {
+	Timestamp   time.Time `json:"timestamp"`
+	Path        string    `json:"path"`
+	Description string    `json:"description,omitempty"`
+	Outcome     string    `json:"outcome"`
+	PrevHash    string    `json:"prevHash"`
+	Hash        string    `json:"hash"`
+	Signature   string    `json:"signature,omitempty"`
+
+	// line is where the entry was read from, counting blank lines
+	line int
+}
+
+// Log is an append-only audit log of secret deployments. Each entry carries
+// the hash of the previous one, so removing or editing a line breaks the chain;
+// with a key, entries are also HMAC-signed. Secret values are never logged.
+type Log struct {
+	path string
+	key  []byte
+}
+
+// NewLog returns a log writing to path. A nil key disables signing.
+func NewLog(path string, key []byte) *Log {
+	return &Log{path: path, key: key}
+}
+
+// LoadKey reads an HMAC key from a file, ignoring surrounding whitespace
+func LoadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.FileOperationError(
+			"Reading audit signing key",
+			path,
+			"Failed to read audit signing key",
+			err,
+		)
+	}
+
+	key := []byte(strings.TrimSpace(string(data)))
+	if len(key) == 0 {
+		return nil, errors.ConfigError(
+			"Reading audit signing key",
+			fmt.Sprintf("Audit signing key file %s is empty", path),
+			nil,
+		)
+	}
+	return key, nil
+}
+
+// Append chains records onto the end of the log
+func (l *Log) Append(records ...Record) error {
+	entries, err := l.read()
+	if err != nil {
+		return err
+	}
+
+	prevHash := ""
+	if len(entries) > 0 {
+		prevHash = entries[len(entries)-1].Hash
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.FileOperationError(
+			"Opening audit log",
+			l.path,
+			"Failed to open audit log for appending",
+			err,
+		)
+	}
+	defer func() { _ = file.Close() }() // Ignore error - defer cleanup is best effort
+
+	now := time.Now().UTC()
+	for _, record := range records {
+		entry := Entry{
+			Timestamp:   now,
+			Path:        record.Path,
+			Description: record.Description,
+			Outcome:     record.Outcome,
+			PrevHash:    prevHash,
+		}
+		entry.Hash = entry.computeHash()
+		if l.key != nil {
+			entry.Signature = entry.sign(l.key)
+		}
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return errors.ConfigError("Encoding audit entry", "Failed to encode audit log entry", err)
+		}
+		if _, err := file.Write(append(line, '\n')); err != nil {
+			return errors.FileOperationError(
+				"Writing audit log",
+				l.path,
+				"Failed to append audit log entry",
+				err,
+			)
+		}
+
+		prevHash = entry.Hash
+	}
+
+	return nil
+}
+
+// Verify checks the hash chain and, when the log has a key, every signature.
+// It returns the number of entries verified.
+func (l *Log) Verify() (int, error) {
+	entries, err := l.read()
+	if err != nil {
+		return 0, err
+	}
+
+	prevHash := ""
+	for _, entry := range entries {
+		line := entry.line
+		if entry.PrevHash != prevHash {
+			return 0, l.tampered(line, "previous-hash link is broken (an entry was removed, reordered or inserted)")
+		}
+		if entry.Hash != entry.computeHash() {
+			return 0, l.tampered(line, "entry hash does not match its contents")
+		}
+		if l.key != nil && !hmac.Equal([]byte(entry.Signature), []byte(entry.sign(l.key))) {
+			return 0, l.tampered(line, "signature is missing or invalid")
+		}
+		prevHash = entry.Hash
+	}
+
+	return len(entries), nil
+}
+
+func (l *Log) tampered(line int, issue string) error {
+	return &errors.OpnixError{
+		Operation: "Verifying audit log",
+		Component: "audit",
+		Issue:     fmt.Sprintf("Audit log entry on line %d failed verification: %s", line, issue),
+		Context:   fmt.Sprintf("Audit log: %s", l.path),
+		Suggestions: []string{
+			"Treat the deployment history after this entry as untrusted",
+			"Compare against a backup or remote copy of the audit log",
+			"Check that the same signing key was used to write the log",
+		},
+	}
+}
+
+// read loads all entries; a missing log is empty
+func (l *Log) read() ([]Entry, error) {
+	file, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.FileOperationError(
+			"Reading audit log",
+			l.path,
+			"Failed to open audit log",
+			err,
+		)
+	}
+	defer func() { _ = file.Close() }() // Ignore error - defer cleanup is best effort
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, l.tampered(line, "entry is not valid JSON")
+		}
+		entry.line = line
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.FileOperationError(
+			"Reading audit log",
+			l.path,
+			"Failed to read audit log",
+			err,
+		)
+	}
+
+	return entries, nil
+}
+
+// canonical is the exact byte string that is hashed and signed
+func (e Entry) canonical() []byte {
+	fields := []string{
+		e.Timestamp.UTC().Format(time.RFC3339Nano),
+		e.Path,
+		e.Outcome,
+		e.PrevHash,
+	}
+	// Only present when set, so entries written before descriptions existed still verify
+	if e.Description != "" {
+		fields = append(fields, e.Description)
+	}
+	return []byte(strings.Join(fields, "\n"))
+}
+
+func (e Entry) computeHash() string {
+	sum := sha256.Sum256(e.canonical())
+	return hex.EncodeToString(sum[:])
+}
+
+func (e Entry) sign(key []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(e.canonical())
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,95 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLogAppendAndVerify(t *testing.T) {
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	log := NewLog(logPath, []byte("signing-key"))
+
+	if err := log.Append(Record{Path: "/run/secrets/db", Outcome: "written"}); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+	if err := log.Append(
+		Record{Path: "/run/secrets/api", Description: "Billing API key", Outcome: "written"},
+		Record{Path: "/run/secrets/tls", Outcome: "failed"},
+	); err != nil {
+		t.Fatalf("Failed to append: %v", err)
+	}
+
+	count, err := log.Verify()
+	if err != nil {
+		t.Fatalf("Expected intact log, got: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("Expected 3 entries, got %d", count)
+	}
+
+	if _, err := NewLog(logPath, []byte("other-key")).Verify(); err == nil {
+		t.Error("Expected verification with the wrong key to fail")
+	}
+
+	// Without a key only the hash chain is checked
+	if _, err := NewLog(logPath, nil).Verify(); err != nil {
+		t.Errorf("Expected unsigned verification to pass, got: %v", err)
+	}
+}
+
+func TestLogDetectsTampering(t *testing.T) {
+	setup := func(t *testing.T) (string, []string) {
+		logPath := filepath.Join(t.TempDir(), "audit.log")
+		log := NewLog(logPath, nil)
+		if err := log.Append(
+			Record{Path: "/run/secrets/a", Outcome: "written"},
+			Record{Path: "/run/secrets/b", Outcome: "written"},
+			Record{Path: "/run/secrets/c", Outcome: "written"},
+		); err != nil {
+			t.Fatalf("Failed to append: %v", err)
+		}
+		data, err := os.ReadFile(logPath)
+		if err != nil {
+			t.Fatalf("Failed to read log: %v", err)
+		}
+		return logPath, strings.Split(strings.TrimSpace(string(data)), "\n")
+	}
+
+	t.Run("edited entry", func(t *testing.T) {
+		logPath, lines := setup(t)
+		lines[1] = strings.Replace(lines[1], `"written"`, `"failed"`, 1)
+		writeLines(t, logPath, lines)
+
+		if _, err := NewLog(logPath, nil).Verify(); err == nil || !strings.Contains(err.Error(), "line 2") {
+			t.Errorf("Expected line 2 to fail verification, got: %v", err)
+		}
+	})
+
+	t.Run("blank lines count towards the line number", func(t *testing.T) {
+		logPath, lines := setup(t)
+		lines[2] = strings.Replace(lines[2], `"written"`, `"failed"`, 1)
+		writeLines(t, logPath, []string{lines[0], "", lines[1], "", lines[2]})
+
+		if _, err := NewLog(logPath, nil).Verify(); err == nil || !strings.Contains(err.Error(), "line 5") {
+			t.Errorf("Expected line 5 to fail verification, got: %v", err)
+		}
+	})
+
+	t.Run("removed entry", func(t *testing.T) {
+		logPath, lines := setup(t)
+		writeLines(t, logPath, append(lines[:1], lines[2:]...))
+
+		if _, err := NewLog(logPath, nil).Verify(); err == nil || !strings.Contains(err.Error(), "link is broken") {
+			t.Errorf("Expected broken chain, got: %v", err)
+		}
+	})
+}
+
+func writeLines(t *testing.T, path string, lines []string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+}
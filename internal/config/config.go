@@ -1,28 +1,312 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/onepass"
 	"github.com/brizzbuzz/opnix/internal/validation"
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
+// Credential stores systemd searches for credentials named in
+// LoadCredential= and LoadCredentialEncrypted= without a path
+const (
+	CredentialStoreDir          = "/run/credstore"
+	EncryptedCredentialStoreDir = "/run/credstore.encrypted"
+)
+
+// ModePreserve keeps an existing file's mode instead of applying Secret.Mode
+const ModePreserve = "preserve"
+
 type Secret struct {
-	Path      string            `json:"path"`
-	Reference string            `json:"reference"`
-	Owner     string            `json:"owner,omitempty"`
-	Group     string            `json:"group,omitempty"`
-	Mode      string            `json:"mode,omitempty"`
-	Symlinks  []string          `json:"symlinks,omitempty"`
-	Variables map[string]string `json:"variables,omitempty"`
-	Services  interface{}       `json:"services,omitempty"`
-	Template string             `json:"template,omitempty"`
+	Path      string   `json:"path"`
+	Reference string   `json:"reference"`
+	Owner     string   `json:"owner,omitempty"`
+	Group     string   `json:"group,omitempty"`
+	Mode      string   `json:"mode,omitempty"`
+	Symlinks  []string `json:"symlinks,omitempty"`
+	// Mode applied to each symlink's parent directory after creation; empty leaves it untouched
+	SymlinkDirMode string            `json:"symlinkDirMode,omitempty"`
+	Variables      map[string]string `json:"variables,omitempty"`
+	Services       interface{}       `json:"services,omitempty"`
+	Template       string            `json:"template,omitempty"`
+	// Parse the resolved value as JSON and expose it to the template as .SecretJSON
+	TemplateJSON bool `json:"templateJSON,omitempty"`
+	// Fields of the same item to try, in order, when the referenced field does not exist
+	FieldFallbacks []string `json:"fieldFallbacks,omitempty"`
+	// Regular expression whose first capture group replaces the resolved value,
+	// applied before templating
+	Extract string `json:"extract,omitempty"`
+	// Named steps applied in order to the resolved value, after extract and
+	// before templating, e.g. ["extract:token=(\\S+)", "base64decode", "trim"]
+	Transforms []string `json:"transforms,omitempty"`
+	// Expected type of the referenced field (password, concealed, text, file, otp, sshKey),
+	// checked against the item's metadata before writing
+	Type string `json:"type,omitempty"`
+	// Command the resolved value is piped through; its stdout is written instead
+	Filter        []string `json:"filter,omitempty"`
+	FilterTimeout string   `json:"filterTimeout,omitempty"`
+	// Command run with the written file's path appended; non-zero exit restores the previous file
+	ValidateWith    []string `json:"validateWith,omitempty"`
+	ValidateTimeout string   `json:"validateTimeout,omitempty"`
+	// Secrets sharing a transaction name are written together and all restored if any fails
+	Transaction string `json:"transaction,omitempty"`
+	// Leave an existing target untouched, without resolving, for seed-once secrets
+	SkipIfExists bool `json:"skipIfExists,omitempty"`
+	// Runtime conditions checked before resolving; when unmet the secret is skipped
+	OnlyIf *OnlyIf `json:"onlyIf,omitempty"`
+	// Per-secret override of Config.RequireNonEmpty
+	RequireNonEmpty *bool `json:"requireNonEmpty,omitempty"`
+	// Per-secret override of Config.BackupRetention; 0 keeps no backups
+	BackupRetention *int `json:"backupRetention,omitempty"`
+	// Per-secret overrides for the config-level resolve settings; a cacheTTL
+	// of 0 always resolves this secret from 1Password
+	MaxRetries *int   `json:"maxRetries,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+	CacheTTL   string `json:"cacheTTL,omitempty"`
+	// Deliver the value once through a named pipe instead of a regular file
+	FIFO        bool   `json:"fifo,omitempty"`
+	FIFOTimeout string `json:"fifoTimeout,omitempty"`
+	// Combine several references into one dotenv file instead of using Reference
+	EnvFile []EnvFileEntry `json:"envFile,omitempty"`
+	// Concatenate several PEM references, in order, into one bundle instead of using Reference
+	Bundle []string `json:"bundle,omitempty"`
+	// Assemble an authorized_keys or known_hosts file from several public key
+	// references, one per line in order, instead of using Reference
+	SSHKeys []string `json:"sshKeys,omitempty"`
+	// authorized_keys (default) or known_hosts, deciding how each line is checked
+	SSHKeysFormat string `json:"sshKeysFormat,omitempty"`
+	// Place several references as section keys of one INI file instead of using Reference
+	INI []INIEntry `json:"ini,omitempty"`
+	// Write JSON, envFile and ini output in a stable form: sorted keys and
+	// consistent indentation, so runs and hosts can be diffed
+	Canonical bool `json:"canonical,omitempty"`
+	// Further files written from the same resolved value, each in its own encoding
+	Copies []SecretCopy `json:"copies,omitempty"`
+	// Replace only a marked block of a file other tools also edit
+	Region *SecretRegion `json:"region,omitempty"`
+	// Named account from Config.Accounts to resolve with; empty uses the default token
+	Account string `json:"account,omitempty"`
+	// Human description surfaced in list, dry-run and audit output; never affects processing
+	Description string `json:"description,omitempty"`
+	// Runbook guidance added to the suggestions when this secret fails
+	OnErrorHint string `json:"onErrorHint,omitempty"`
+	// Labels for selecting secrets with --tag; never affects processing
+	Tags []string `json:"tags,omitempty"`
+	// Warn when a certificate in the value has expired or expires within this many days
+	CertExpiryWarnDays int `json:"certExpiryWarnDays,omitempty"`
+	// Fail the secret instead of warning, so an expiring certificate is never written
+	CertExpiryStrict bool `json:"certExpiryStrict,omitempty"`
+	// Name of a systemd credential; without a path the secret is written to
+	// the credential store services read with LoadCredential=NAME
+	Credential string `json:"credential,omitempty"`
+	// Encrypt with systemd-creds for LoadCredentialEncrypted= instead
+	CredentialEncrypted bool `json:"credentialEncrypted,omitempty"`
+	// Resolve only when the secret's turn comes in config order, never ahead
+	// of time in the parallel resolve.groupByItem batches
+	Serial bool `json:"serial,omitempty"`
+	// Config file the secret was loaded from, set while loading and reported
+	// in errors and run summaries
+	Source string `json:"-"`
+	// Write several fields of one item (op://Vault/Item) instead of using Reference and Path.
+	// Fields maps each field name to its own target path.
+	Item   string            `json:"item,omitempty"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// References returns every reference a single-reference, envFile, bundle,
+// sshKeys or ini secret resolves. Item secrets are covered by ExpandFields.
+func (s Secret) References() []string {
+	var references []string
+	if s.Reference != "" {
+		references = append(references, s.Reference)
+	}
+	for _, entry := range s.EnvFile {
+		references = append(references, entry.Reference)
+	}
+	references = append(references, s.Bundle...)
+	references = append(references, s.SSHKeys...)
+	for _, entry := range s.INI {
+		references = append(references, entry.Reference)
+	}
+	return references
+}
+
+// ExpandFields returns one single-reference secret per field of an item
+// secret, in field name order, or the secret itself otherwise. Expanded
+// secrets share every other setting of the original.
+func (s Secret) ExpandFields() []Secret {
+	if s.Item == "" {
+		return []Secret{s}
+	}
+
+	names := make([]string, 0, len(s.Fields))
+	for name := range s.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expanded := make([]Secret, 0, len(names))
+	for _, name := range names {
+		field := s
+		field.Item = ""
+		field.Fields = nil
+		field.Reference = strings.TrimSuffix(s.Item, "/") + "/" + name
+		field.Path = s.Fields[name]
+		expanded = append(expanded, field)
+	}
+	return expanded
+}
+
+// OnlyIf gates a secret on the host's state at run time, for staged rollouts.
+// Both conditions must hold when both are set.
+type OnlyIf struct {
+	// Path that must exist, such as a feature flag file
+	Exists string `json:"exists,omitempty"`
+	// Command that must exit 0; exit 1 skips the secret, anything else fails it
+	Command []string `json:"command,omitempty"`
+	// How long command may run (default 30s)
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// Account is a named 1Password account with its own service account token
+type Account struct {
+	TokenFile string `json:"tokenFile"`
+}
+
+// EnvFileEntry maps one KEY in an env-file secret to a 1Password reference
+type EnvFileEntry struct {
+	Key       string `json:"key"`
+	Reference string `json:"reference"`
+}
+
+// INIEntry places one 1Password reference at Key in Section of an INI secret.
+// An empty Section puts the key before the first section header.
+type INIEntry struct {
+	Section   string `json:"section,omitempty"`
+	Key       string `json:"key"`
+	Reference string `json:"reference"`
+}
+
+// SecretCopy is an extra file holding a secret's value, re-encoded when
+// Encoding is set. Copies share the secret's mode and ownership unless
+// they set their own.
+type SecretCopy struct {
+	Path string `json:"path"`
+	// raw (default), base64 or hex
+	Encoding string `json:"encoding,omitempty"`
+	Owner    string `json:"owner,omitempty"`
+	Group    string `json:"group,omitempty"`
+	Mode     string `json:"mode,omitempty"`
+	// Users that must be able to read the copy; checked against its
+	// effective owner, group and mode when the config is validated
+	ReadableBy []string `json:"readableBy,omitempty"`
+}
+
+// SecretRegion places a secret between "# BEGIN OPNIX" and "# END OPNIX"
+// lines of a file, leaving the rest of the file as other tools wrote it
+type SecretRegion struct {
+	// Appended to both markers so one file can hold several regions
+	Name string `json:"name,omitempty"`
+	// Comment prefix of the marker lines (default "#")
+	Comment string `json:"comment,omitempty"`
+}
+
+// ResolveConfig controls how references are resolved from 1Password
+type ResolveConfig struct {
+	MaxRetries int    `json:"maxRetries,omitempty"`
+	Timeout    string `json:"timeout,omitempty"`
+	// Resolve references that share a vault and item in one request per item
+	GroupByItem bool `json:"groupByItem,omitempty"`
+	// How many item groups to resolve at once when grouping (default 4)
+	Parallel int `json:"parallel,omitempty"`
+	// Case-insensitive substrings marking further errors as retryable, on
+	// top of onepass.DefaultRetryablePatterns
+	RetryableErrors []string `json:"retryableErrors,omitempty"`
+	// Serve recently resolved values from an encrypted file instead of 1Password
+	Cache ResolveCacheConfig `json:"cache,omitempty"`
+	// Most requests per second to 1Password, shared by every vault without
+	// its own limit (0, the default, is unlimited)
+	RateLimit float64 `json:"rateLimit,omitempty"`
+	// Requests per second for single vaults, by name or ID, optionally
+	// qualified by account as in references (Prod@work); each is limited on
+	// its own instead of sharing rateLimit
+	VaultRateLimits map[string]float64 `json:"vaultRateLimits,omitempty"`
+}
+
+// ResolveCacheConfig keeps resolved values in an encrypted file between runs
+type ResolveCacheConfig struct {
+	// File holding the encrypted values; caching is off when empty
+	File string `json:"file,omitempty"`
+	// How long a value is served from the cache before it is resolved again (default 5m)
+	TTL string `json:"ttl,omitempty"`
+	// File holding the encryption key; empty derives the key from the service account token
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// NetworkConfig routes requests to 1Password through an egress proxy
+type NetworkConfig struct {
+	// http(s) proxy URL for every request; empty uses HTTPS_PROXY
+	Proxy string `json:"proxy,omitempty"`
+	// PEM file of CAs trusted in addition to the system ones, e.g. for a TLS-intercepting proxy
+	CABundle string `json:"caBundle,omitempty"`
+}
+
+// Options returns the settings in the form the 1Password client takes
+func (n NetworkConfig) Options() onepass.NetworkOptions {
+	return onepass.NetworkOptions{Proxy: n.Proxy, CABundle: n.CABundle}
+}
+
+// LockConfig decides what a run does while another run holds the output
+// directory's lock
+type LockConfig struct {
+	// fail, wait (default) or queue
+	Mode string `json:"mode,omitempty"`
+	// How long to wait for the lock, e.g. 5m; wait defaults to 1m, queue to no limit
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// WebhookConfig posts a JSON event summarizing each run, never values
+type WebhookConfig struct {
+	// http(s) URL the event is POSTed to
+	URL string `json:"url,omitempty"`
+	// Request timeout, e.g. 5s (default 10s)
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ModePolicy limits the modes of secrets written under Dir. The most specific
+// policy containing a secret's resolved path applies.
+type ModePolicy struct {
+	Dir string `json:"dir"`
+	// Mode may set no permission bit outside this one, e.g. 0640 allows 0600 and 0640 but not 0644
+	MaxMode string `json:"maxMode"`
+}
+
+// PlaceholderCheck flags resolved values that look like they were never set
+type PlaceholderCheck struct {
+	// off (default), warn or strict
+	Mode string `json:"mode,omitempty"`
+	// Case-insensitive values to flag; empty uses a built-in list (CHANGEME, TODO, ...)
+	Placeholders []string `json:"placeholders,omitempty"`
+	// Flag values shorter than this many characters (0 disables)
+	MinLength int `json:"minLength,omitempty"`
+	// Flag values with less Shannon entropy than this many bits in total (0 disables)
+	MinEntropyBits float64 `json:"minEntropyBits,omitempty"`
 }
 
 type ChangeDetection struct {
 	Enable   bool   `json:"enable"`
 	HashFile string `json:"hashFile"`
+	// Octal mode of the hash file (default 0600), so it can't be edited to suppress restarts
+	HashFileMode string `json:"hashFileMode,omitempty"`
 }
 
 type ErrorHandling struct {
@@ -37,13 +321,54 @@ type SystemdIntegration struct {
 	RestartOnChange bool            `json:"restartOnChange"`
 	ChangeDetection ChangeDetection `json:"changeDetection"`
 	ErrorHandling   ErrorHandling   `json:"errorHandling"`
+	// Maximum number of independent service actions run at once (default 1)
+	ParallelServices int `json:"parallelServices,omitempty"`
+	// Unit that runs opnix on this host, used as the default After= ordering (default opnix-secrets.service)
+	UnitName string `json:"unitName,omitempty"`
+	// Absolute path of the systemctl binary; empty looks it up in PATH
+	Systemctl string `json:"systemctl,omitempty"`
+	// Local-time windows service actions may run in, e.g. "Sat 02:00-06:00";
+	// outside them actions are deferred (empty allows any time)
+	MaintenanceWindows []string `json:"maintenanceWindows,omitempty"`
+	// File recording deferred service actions (default /var/lib/opnix/pending-services.json)
+	PendingFile string `json:"pendingFile,omitempty"`
 }
 
 type Config struct {
-	Secrets            []Secret           `json:"secrets"`
-	PathTemplate       string             `json:"pathTemplate,omitempty"`
-	Defaults           map[string]string  `json:"defaults,omitempty"`
+	Include      []string          `json:"include,omitempty"`
+	Secrets      []Secret          `json:"secrets"`
+	Env          map[string]string `json:"env,omitempty"`
+	PathTemplate string            `json:"pathTemplate,omitempty"`
+	PathPrefix   string            `json:"pathPrefix,omitempty"`
+	// Every secret and symlink must resolve to a path under BaseDir, when set
+	BaseDir       string             `json:"baseDir,omitempty"`
+	Defaults      map[string]string  `json:"defaults,omitempty"`
+	AllowedVaults []string           `json:"allowedVaults,omitempty"`
+	Resolve       ResolveConfig      `json:"resolve,omitempty"`
+	Accounts      map[string]Account `json:"accounts,omitempty"`
+	// Directory of token files registered as accounts named after each file
+	AccountTokenDir string `json:"accountTokenDir,omitempty"`
+	// Per-directory limits on secret modes, checked against each resolved path
+	ModePolicies []ModePolicy `json:"modePolicies,omitempty"`
+	// What to do when a secret would land on NFS/CIFS/etc: warn (default), refuse or allow
+	NetworkFilesystem string `json:"networkFilesystem,omitempty"`
+	// Fail instead of writing a secret whose final value is empty or whitespace (default true)
+	RequireNonEmpty *bool `json:"requireNonEmpty,omitempty"`
+	// Reject secrets whose final path is relative to the output directory
+	RequireAbsolutePaths bool `json:"requireAbsolutePaths,omitempty"`
+	// Keep this many timestamped backups of each overwritten secret (default 0, none)
+	BackupRetention    int                `json:"backupRetention,omitempty"`
+	PlaceholderCheck   PlaceholderCheck   `json:"placeholderCheck,omitempty"`
+	Network            NetworkConfig      `json:"network,omitempty"`
 	SystemdIntegration SystemdIntegration `json:"systemdIntegration,omitempty"`
+	Lock               LockConfig         `json:"lock,omitempty"`
+	Webhook            WebhookConfig      `json:"webhook,omitempty"`
+	// What non-ASCII spaces and look-alike characters in references and paths cause: warn (default), error or normalize
+	UnicodeCheck string `json:"unicodeCheck,omitempty"`
+	// Prometheus text file written after each run, for a node-exporter textfile collector
+	MetricsFile string `json:"metricsFile,omitempty"`
+	// Unknown keys found while loading, reported as warnings by finish
+	ignored []string
 }
 
 // convertToValidationSecrets converts config secrets to validation format
@@ -51,23 +376,393 @@ func (c *Config) convertToValidationSecrets() []validation.SecretData {
 	secrets := make([]validation.SecretData, len(c.Secrets))
 	for i, s := range c.Secrets {
 		secrets[i] = validation.SecretData{
-			Path:         s.Path,
-			Reference:    s.Reference,
-			Owner:        s.Owner,
-			Group:        s.Group,
-			Mode:         s.Mode,
-			Symlinks:     s.Symlinks,
-			Variables:    s.Variables,
-			Services:     s.Services,
-			PathTemplate: c.PathTemplate,
-			Defaults:     c.Defaults,
+			Path:            s.Path,
+			Reference:       s.Reference,
+			FieldFallbacks:  s.FieldFallbacks,
+			Extract:         s.Extract,
+			Transforms:      s.Transforms,
+			Type:            s.Type,
+			Template:        s.Template,
+			TemplateJSON:    s.TemplateJSON,
+			Owner:           s.Owner,
+			Group:           s.Group,
+			Mode:            s.Mode,
+			Symlinks:        s.Symlinks,
+			SymlinkDirMode:  s.SymlinkDirMode,
+			Variables:       s.Variables,
+			Services:        s.Services,
+			PathTemplate:    c.PathTemplate,
+			PathPrefix:      c.PathPrefix,
+			BaseDir:         c.BaseDir,
+			Defaults:        c.Defaults,
+			AllowedVaults:   c.AllowedVaults,
+			MaxRetries:      s.MaxRetries,
+			Timeout:         s.Timeout,
+			FIFO:            s.FIFO,
+			SkipIfExists:    s.SkipIfExists,
+			OnlyIf:          s.OnlyIf != nil,
+			FIFOTimeout:     s.FIFOTimeout,
+			Transaction:     s.Transaction,
+			Filter:          s.Filter,
+			FilterTimeout:   s.FilterTimeout,
+			CacheTTL:        s.CacheTTL,
+			ValidateWith:    s.ValidateWith,
+			ValidateTimeout: s.ValidateTimeout,
+			CertExpiryDays:  s.CertExpiryWarnDays,
+			Credential:      s.Credential,
+			CredentialEnc:   s.CredentialEncrypted,
+			Account:         s.Account,
+			Bundle:          s.Bundle,
+			SSHKeys:         s.SSHKeys,
+			SSHKeysFormat:   s.SSHKeysFormat,
+			Canonical:       s.Canonical,
+			Region:          s.Region != nil,
+			Item:            s.Item,
+			Fields:          s.Fields,
+			Accounts:        c.AccountTokenFiles(),
+			Source:          s.Source,
+		}
+		if s.Region != nil {
+			secrets[i].RegionName, secrets[i].RegionComment = s.Region.Name, s.Region.Comment
+		}
+		if s.OnlyIf != nil {
+			secrets[i].OnlyIfExists, secrets[i].OnlyIfCommand, secrets[i].OnlyIfTimeout = s.OnlyIf.Exists, s.OnlyIf.Command, s.OnlyIf.Timeout
+		}
+		for _, entry := range s.EnvFile {
+			secrets[i].EnvFile = append(secrets[i].EnvFile, validation.EnvFileEntry{
+				Key:       entry.Key,
+				Reference: entry.Reference,
+			})
+		}
+		for _, entry := range s.INI {
+			secrets[i].INI = append(secrets[i].INI, validation.INIEntry{
+				Section:   entry.Section,
+				Key:       entry.Key,
+				Reference: entry.Reference,
+			})
+		}
+		for _, secretCopy := range s.Copies {
+			secrets[i].Copies = append(secrets[i].Copies, validation.SecretCopy{
+				Path:       secretCopy.Path,
+				Encoding:   secretCopy.Encoding,
+				Owner:      secretCopy.Owner,
+				Group:      secretCopy.Group,
+				Mode:       secretCopy.Mode,
+				ReadableBy: secretCopy.ReadableBy,
+			})
 		}
 	}
 	return secrets
 }
 
-// Load loads a single config file
+// AccountTokenFiles returns the token file of every named account
+func (c *Config) AccountTokenFiles() map[string]string {
+	if len(c.Accounts) == 0 {
+		return nil
+	}
+	tokenFiles := make(map[string]string, len(c.Accounts))
+	for name, account := range c.Accounts {
+		tokenFiles[name] = account.TokenFile
+	}
+	return tokenFiles
+}
+
+// Load loads a single config file, resolving any include directives
 func Load(path string) (*Config, error) {
+	return LoadWithWarnings(path, nil)
+}
+
+// LoadWithWarnings is Load, recording problems that don't fail loading in
+// collector instead of printing them
+func LoadWithWarnings(path string, collector *warnings.Collector) (*Config, error) {
+	return LoadKey(path, "", collector)
+}
+
+// LoadKey is LoadWithWarnings for a config embedded in a larger JSON document
+// under key, a dot-separated path such as "services.opnix". An empty key
+// loads the file's top level. Included files are always read at top level.
+func LoadKey(path, key string, collector *warnings.Collector) (*Config, error) {
+	return LoadFormat(path, key, FormatJSON, collector)
+}
+
+// LoadFormat is LoadKey for a config in format: FormatJSON, or a FormatCSV or
+// FormatTSV manifest of secrets, which takes no key
+func LoadFormat(path, key, format string, collector *warnings.Collector) (*Config, error) {
+	config, err := loadDocument(path, key, format)
+	if err != nil {
+		return nil, err
+	}
+	return config.finish(collector)
+}
+
+// LoadDir loads every *.json file in dir, in name order, as one config: the
+// files are merged like includes before anything is validated, so defaults
+// from one file apply to secrets in another and duplicate paths are checked
+// across all of them
+func LoadDir(dir string, collector *warnings.Collector) (*Config, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, errors.ConfigError(
+			"Loading configuration directory",
+			fmt.Sprintf("Invalid config directory: %s", dir),
+			err,
+		)
+	}
+	if len(paths) == 0 {
+		if _, statErr := os.Stat(dir); statErr != nil {
+			return nil, errors.FileOperationError(
+				"Loading configuration directory",
+				dir,
+				"Failed to read config directory",
+				statErr,
+			)
+		}
+		return nil, errors.ConfigError(
+			"Loading configuration directory",
+			fmt.Sprintf("No *.json config files found in %s", dir),
+			nil,
+		)
+	}
+	sort.Strings(paths)
+
+	merged := &Config{}
+	for _, path := range paths {
+		config, err := loadWithIncludes(path, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := mergeConfig(merged, config); err != nil {
+			return nil, err
+		}
+	}
+	return merged.finish(collector)
+}
+
+// finish expands, completes and validates a freshly loaded config
+func (c *Config) finish(collector *warnings.Collector) (*Config, error) {
+	for _, issue := range c.ignored {
+		collector.Addf("configuration", "%s", issue)
+	}
+
+	if err := c.expandReferences(); err != nil {
+		return nil, err
+	}
+	c.normalizeUnicode(collector)
+	c.applyCredentialPaths()
+
+	if err := c.registerAccountTokenDir(collector); err != nil {
+		return nil, err
+	}
+	if err := c.applyVaultQualifiers(collector); err != nil {
+		return nil, err
+	}
+
+	// Validate the loaded configuration
+	if err := c.validate(collector); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// applyCredentialPaths places credentials without a path in the store
+// systemd reads them from
+func (c *Config) applyCredentialPaths() {
+	for i := range c.Secrets {
+		secret := &c.Secrets[i]
+		if secret.Credential == "" || secret.Path != "" {
+			continue
+		}
+		dir := CredentialStoreDir
+		if secret.CredentialEncrypted {
+			dir = EncryptedCredentialStoreDir
+		}
+		secret.Path = filepath.Join(dir, secret.Credential)
+	}
+}
+
+// expandReferences substitutes {variable} placeholders in references, e.g. a
+// per-environment vault name, from each secret's variables and the defaults.
+// Env mappings have no variables of their own and use the defaults only.
+func (c *Config) expandReferences() error {
+	validator := validation.NewValidator()
+	expand := func(reference string, variables map[string]string, name string) (string, error) {
+		if !strings.Contains(reference, "{") {
+			return reference, nil
+		}
+		reference, err := expandDates(reference, name+".reference")
+		if err != nil || !strings.Contains(reference, "{") {
+			return reference, err
+		}
+		return validator.SubstituteVariables(reference, variables, c.Defaults, name)
+	}
+
+	var err error
+	for i := range c.Secrets {
+		secret := &c.Secrets[i]
+		name := fmt.Sprintf("secret[%d]", i)
+		if secret.Reference, err = expand(secret.Reference, secret.Variables, name); err != nil {
+			return err
+		}
+		if secret.Item, err = expand(secret.Item, secret.Variables, name); err != nil {
+			return err
+		}
+		// Lets a dated field fall back to the previous period's
+		for j := range secret.FieldFallbacks {
+			if secret.FieldFallbacks[j], err = expandDates(secret.FieldFallbacks[j], fmt.Sprintf("%s.fieldFallbacks[%d]", name, j)); err != nil {
+				return err
+			}
+		}
+		for j := range secret.Bundle {
+			if secret.Bundle[j], err = expand(secret.Bundle[j], secret.Variables, name); err != nil {
+				return err
+			}
+		}
+		for j := range secret.SSHKeys {
+			if secret.SSHKeys[j], err = expand(secret.SSHKeys[j], secret.Variables, name); err != nil {
+				return err
+			}
+		}
+		for j := range secret.INI {
+			if secret.INI[j].Reference, err = expand(secret.INI[j].Reference, secret.Variables, name); err != nil {
+				return err
+			}
+		}
+		for j := range secret.EnvFile {
+			if secret.EnvFile[j].Reference, err = expand(secret.EnvFile[j].Reference, secret.Variables, name); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key, reference := range c.Env {
+		if c.Env[key], err = expand(reference, nil, "env."+key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validate runs full validation; a config may consist solely of env
+// mappings, in which case an empty secrets list is allowed
+func (c *Config) validate(collector *warnings.Collector) error {
+	validator := validation.NewValidator()
+	validator.SetWarnings(collector)
+	if err := validator.ValidateUnicodeCheck(c.UnicodeCheck); err != nil {
+		return err
+	}
+	validator.SetUnicodeCheck(c.UnicodeCheck)
+	validator.SetRequireAbsolutePaths(c.RequireAbsolutePaths)
+	if len(c.Secrets) > 0 || len(c.Env) == 0 {
+		if err := validator.ValidateConfigStruct(c.convertToValidationSecrets()); err != nil {
+			return err
+		}
+	}
+
+	if len(c.Env) > 0 {
+		if err := validator.ValidateEnv(c.Env, c.AllowedVaults); err != nil {
+			return err
+		}
+	}
+
+	if err := validator.ValidateNetworkFilesystemPolicy(c.NetworkFilesystem); err != nil {
+		return err
+	}
+
+	if err := validator.ValidateHashFileMode(c.SystemdIntegration.ChangeDetection.HashFileMode); err != nil {
+		return err
+	}
+
+	if systemctl := c.SystemdIntegration.Systemctl; systemctl != "" && !filepath.IsAbs(systemctl) {
+		return errors.ConfigValidationError(
+			"systemdIntegration.systemctl",
+			systemctl,
+			"systemctl must be an absolute path",
+			[]string{"Use the binary's full path, e.g. ${pkgs.systemd}/bin/systemctl", "Leave it unset to look systemctl up in PATH"},
+		)
+	}
+
+	for _, pattern := range c.Resolve.RetryableErrors {
+		if strings.TrimSpace(pattern) == "" {
+			return errors.ConfigValidationError(
+				"resolve.retryableErrors",
+				pattern,
+				"An empty pattern would match every error",
+				[]string{"Remove the empty entry", "Use a distinctive part of the error message, e.g. \"item is locked\""},
+			)
+		}
+	}
+
+	if err := validator.ValidateResolveCache(c.Resolve.Cache.File, c.Resolve.Cache.TTL, c.Resolve.Cache.KeyFile); err != nil {
+		return err
+	}
+
+	if c.Resolve.RateLimit < 0 {
+		return errors.ValidationError("Validating resolve.rateLimit", "resolve.rateLimit", strconv.FormatFloat(c.Resolve.RateLimit, 'g', -1, 64), "requests per second, or 0 for no limit")
+	}
+	for vault, limit := range c.Resolve.VaultRateLimits {
+		if limit <= 0 {
+			return errors.ValidationError("Validating resolve.vaultRateLimits", "resolve.vaultRateLimits."+vault, strconv.FormatFloat(limit, 'g', -1, 64), "requests per second, more than 0")
+		}
+	}
+
+	if c.BackupRetention < 0 {
+		return errors.ValidationError("Validating backupRetention", "backupRetention", strconv.Itoa(c.BackupRetention), "number of backups to keep, 0 or more")
+	}
+	for i, secret := range c.Secrets {
+		if secret.BackupRetention != nil && *secret.BackupRetention < 0 {
+			return errors.ValidationError(
+				"Validating backupRetention",
+				fmt.Sprintf("secret[%d].backupRetention", i),
+				strconv.Itoa(*secret.BackupRetention),
+				"number of backups to keep, 0 or more",
+			)
+		}
+	}
+
+	seenPolicies := make(map[string]bool)
+	for i, policy := range c.ModePolicies {
+		field := fmt.Sprintf("modePolicies[%d]", i)
+		if err := validator.ValidateModePolicy(field, policy.Dir, policy.MaxMode); err != nil {
+			return err
+		}
+		dir := filepath.Clean(policy.Dir)
+		if seenPolicies[dir] {
+			return errors.ConfigValidationError(
+				field+".dir",
+				policy.Dir,
+				"Directory already has a mode policy",
+				[]string{"Merge the two entries into one"},
+			)
+		}
+		seenPolicies[dir] = true
+	}
+
+	if err := validator.ValidateMaintenanceWindows(c.SystemdIntegration.MaintenanceWindows); err != nil {
+		return err
+	}
+
+	if err := validator.ValidateLock(c.Lock.Mode, c.Lock.Timeout); err != nil {
+		return err
+	}
+
+	if err := validator.ValidateWebhook(c.Webhook.URL, c.Webhook.Timeout); err != nil {
+		return err
+	}
+
+	if c.Network.Proxy != "" {
+		if _, err := onepass.ParseProxyURL(c.Network.Proxy); err != nil {
+			return err
+		}
+	}
+
+	return validator.ValidatePlaceholderCheck(c.PlaceholderCheck.Mode, c.PlaceholderCheck.MinLength, c.PlaceholderCheck.MinEntropyBits)
+}
+
+// loadFile reads and parses a single config file without validation, taking
+// the config from under key when it is not empty
+func loadFile(path, key string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, errors.FileOperationError(
@@ -78,6 +773,12 @@ func Load(path string) (*Config, error) {
 		)
 	}
 
+	if key != "" {
+		if data, err = extractKey(data, key, path); err != nil {
+			return nil, err
+		}
+	}
+
 	var config Config
 	if err := json.Unmarshal(data, &config); err != nil {
 		return nil, errors.ConfigError(
@@ -86,18 +787,261 @@ func Load(path string) (*Config, error) {
 			err,
 		)
 	}
+	for _, issue := range ignoredFields(data) {
+		config.ignored = append(config.ignored, fmt.Sprintf("%s (in %s, ignored)", issue, path))
+	}
 
-	// Validate the loaded configuration
-	validator := validation.NewValidator()
-	if err := validator.ValidateConfigStruct(config.convertToValidationSecrets()); err != nil {
-		return nil, err
+	// Secrets keep the file they came from through includes and merges
+	for i := range config.Secrets {
+		config.Secrets[i].Source = path
 	}
 
 	return &config, nil
 }
 
+// IsEmpty reports whether the config at path is missing, blank, or defines
+// neither secrets nor env mappings once its includes are merged. A non-empty
+// key must be present in the document.
+func IsEmpty(path, key string) (bool, error) {
+	return IsEmptyFormat(path, key, FormatJSON)
+}
+
+// IsEmptyFormat is IsEmpty for a config in format, see LoadFormat
+func IsEmptyFormat(path, key, format string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, errors.FileOperationError(
+			"Loading configuration file",
+			path,
+			"Failed to read config file",
+			err,
+		)
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return true, nil
+	}
+
+	config, err := loadDocument(path, key, format)
+	if err != nil {
+		return false, err
+	}
+	return len(config.Secrets) == 0 && len(config.Env) == 0, nil
+}
+
+// loadWithIncludes loads a config file and recursively merges its includes.
+// Included files are merged first, so the including file's settings win.
+// chain holds the files currently being loaded and is used to detect cycles.
+// key only applies to path itself; its includes are plain config files.
+func loadWithIncludes(path, key string, chain []string) (*Config, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, errors.FileOperationError(
+			"Resolving configuration file path",
+			path,
+			"Failed to resolve absolute path",
+			err,
+		)
+	}
+
+	for _, seen := range chain {
+		if seen == absPath {
+			return nil, errors.ConfigError(
+				"Resolving config includes",
+				fmt.Sprintf("Include cycle detected: %s -> %s", strings.Join(chain, " -> "), absPath),
+				nil,
+			)
+		}
+	}
+	chain = append(chain, absPath)
+
+	config, err := loadFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.Include) == 0 {
+		return config, nil
+	}
+
+	merged := &Config{}
+	baseDir := filepath.Dir(absPath)
+	for _, pattern := range config.Include {
+		includePaths, err := expandInclude(baseDir, pattern, path)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, includePath := range includePaths {
+			included, err := loadWithIncludes(includePath, "", chain)
+			if err != nil {
+				return nil, err
+			}
+			if err := mergeConfig(merged, included); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := mergeConfig(merged, config); err != nil {
+		return nil, err
+	}
+
+	return merged, nil
+}
+
+// expandInclude resolves an include entry relative to the including file,
+// expanding glob patterns in sorted order
+func expandInclude(baseDir, pattern, sourcePath string) ([]string, error) {
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(baseDir, pattern)
+	}
+
+	if !strings.ContainsAny(pattern, "*?[") {
+		return []string{pattern}, nil
+	}
+
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, errors.ConfigError(
+			fmt.Sprintf("Resolving includes in %s", sourcePath),
+			fmt.Sprintf("Invalid include pattern: %s", pattern),
+			err,
+		)
+	}
+	sort.Strings(matches)
+
+	return matches, nil
+}
+
+// mergeConfig merges src into dst: secrets are appended, allowedVaults only
+// narrows, and path templates, defaults and other config-level settings
+// follow last-file-wins
+func mergeConfig(dst, src *Config) error {
+	dst.Secrets = append(dst.Secrets, src.Secrets...)
+	dst.ignored = append(dst.ignored, src.ignored...)
+
+	if len(src.Env) > 0 && dst.Env == nil {
+		dst.Env = make(map[string]string)
+	}
+	for k, v := range src.Env {
+		dst.Env[k] = v
+	}
+
+	if src.PathTemplate != "" {
+		dst.PathTemplate = src.PathTemplate
+	}
+	if src.PathPrefix != "" {
+		dst.PathPrefix = src.PathPrefix
+	}
+	if len(src.Defaults) > 0 {
+		dst.Defaults = make(map[string]string)
+		for k, v := range src.Defaults {
+			dst.Defaults[k] = v
+		}
+	}
+	if len(src.AllowedVaults) > 0 {
+		allowed, err := intersectVaults(dst.AllowedVaults, src.AllowedVaults)
+		if err != nil {
+			return err
+		}
+		dst.AllowedVaults = allowed
+	}
+	if src.Resolve.MaxRetries != 0 || src.Resolve.Timeout != "" || src.Resolve.GroupByItem ||
+		src.Resolve.Parallel != 0 || len(src.Resolve.RetryableErrors) > 0 || src.Resolve.Cache.File != "" ||
+		src.Resolve.RateLimit != 0 || len(src.Resolve.VaultRateLimits) > 0 {
+		dst.Resolve = src.Resolve
+	}
+	for name, account := range src.Accounts {
+		if dst.Accounts == nil {
+			dst.Accounts = make(map[string]Account)
+		}
+		dst.Accounts[name] = account
+	}
+	if src.AccountTokenDir != "" {
+		dst.AccountTokenDir = src.AccountTokenDir
+	}
+	if src.BaseDir != "" {
+		dst.BaseDir = src.BaseDir
+	}
+	if len(src.ModePolicies) > 0 {
+		dst.ModePolicies = src.ModePolicies
+	}
+	if src.NetworkFilesystem != "" {
+		dst.NetworkFilesystem = src.NetworkFilesystem
+	}
+	if src.PlaceholderCheck.Mode != "" {
+		dst.PlaceholderCheck = src.PlaceholderCheck
+	}
+	if src.UnicodeCheck != "" {
+		dst.UnicodeCheck = src.UnicodeCheck
+	}
+	if src.MetricsFile != "" {
+		dst.MetricsFile = src.MetricsFile
+	}
+	if src.RequireNonEmpty != nil {
+		dst.RequireNonEmpty = src.RequireNonEmpty
+	}
+	if src.RequireAbsolutePaths {
+		dst.RequireAbsolutePaths = true
+	}
+	if src.BackupRetention != 0 {
+		dst.BackupRetention = src.BackupRetention
+	}
+	if src.Network != (NetworkConfig{}) {
+		dst.Network = src.Network
+	}
+	if src.SystemdIntegration.Enable {
+		dst.SystemdIntegration = src.SystemdIntegration
+	}
+	if src.Lock != (LockConfig{}) {
+		dst.Lock = src.Lock
+	}
+	if src.Webhook != (WebhookConfig{}) {
+		dst.Webhook = src.Webhook
+	}
+	return nil
+}
+
+// intersectVaults narrows the allowed vaults to those src allows too, so a
+// later file can restrict but never widen the allowlist. An empty result
+// would allow every vault, so it is an error.
+func intersectVaults(dst, src []string) ([]string, error) {
+	if len(dst) == 0 {
+		return src, nil
+	}
+	var allowed []string
+	for _, vault := range dst {
+		for _, other := range src {
+			if vault == other {
+				allowed = append(allowed, vault)
+				break
+			}
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, errors.ConfigValidationError(
+			"allowedVaults",
+			strings.Join(src, ", "),
+			fmt.Sprintf("No vault in common with the allowedVaults already loaded (%s)", strings.Join(dst, ", ")),
+			[]string{
+				"Merged config files narrow allowedVaults to the vaults every file allows",
+				"List at least one shared vault, or drop allowedVaults from the file that should not restrict them",
+			},
+		)
+	}
+	return allowed, nil
+}
+
 // LoadMultiple loads and merges multiple config files (GitHub #3)
 func LoadMultiple(paths []string) (*Config, error) {
+	return LoadMultipleFormat(paths, "", FormatJSON)
+}
+
+// LoadMultipleFormat is LoadMultiple for config files in format, each read
+// from under key as LoadFormat does
+func LoadMultipleFormat(paths []string, key, format string) (*Config, error) {
 	if len(paths) == 0 {
 		return nil, errors.ConfigError(
 			"Loading multiple config files",
@@ -106,10 +1050,10 @@ func LoadMultiple(paths []string) (*Config, error) {
 		)
 	}
 
-	var allSecrets []Secret
+	mergedConfig := &Config{}
 
 	for _, path := range paths {
-		config, err := Load(path)
+		config, err := LoadFormat(path, key, format, nil)
 		if err != nil {
 			return nil, errors.WrapWithSuggestions(
 				err,
@@ -122,39 +1066,19 @@ func LoadMultiple(paths []string) (*Config, error) {
 				},
 			)
 		}
-		allSecrets = append(allSecrets, config.Secrets...)
-
-		// Merge path templates and defaults (last file wins)
-		// Path templates and defaults are merged (last file wins)
-		// These are handled in the merging logic below
-	}
-
-	// Use the last config's template and defaults for merged config
-	var finalPathTemplate string
-	var finalDefaults map[string]string
 
-	for _, path := range paths {
-		config, _ := Load(path) // We know this works from above
-		if config.PathTemplate != "" {
-			finalPathTemplate = config.PathTemplate
-		}
-		if len(config.Defaults) > 0 {
-			finalDefaults = make(map[string]string)
-			for k, v := range config.Defaults {
-				finalDefaults[k] = v
-			}
+		// Secrets are appended; path templates and defaults are merged (last file wins)
+		if err := mergeConfig(mergedConfig, config); err != nil {
+			return nil, err
 		}
 	}
 
-	mergedConfig := &Config{
-		Secrets:      allSecrets,
-		PathTemplate: finalPathTemplate,
-		Defaults:     finalDefaults,
+	if err := mergedConfig.expandReferences(); err != nil {
+		return nil, err
 	}
 
 	// Validate the merged configuration for cross-file conflicts
-	validator := validation.NewValidator()
-	if err := validator.ValidateConfigStruct(mergedConfig.convertToValidationSecrets()); err != nil {
+	if err := mergedConfig.validate(nil); err != nil {
 		return nil, err
 	}
 
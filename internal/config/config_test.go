@@ -1,9 +1,15 @@
 package config
 
 import (
+	"encoding/base64"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
 func TestLoad(t *testing.T) {
@@ -433,7 +439,6 @@ func TestLoadMultipleWithTemplates(t *testing.T) {
 	}
 }
 
-
 func TestSecretTemplate(t *testing.T) {
 	t.Run("secret with template", func(t *testing.T) {
 		secret := Secret{
@@ -458,4 +463,1208 @@ func TestSecretTemplate(t *testing.T) {
 			t.Errorf("Expected empty template, got %s", secret.Template)
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestLoadWithAllowedVaults(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "config.json")
+	configData := `{
+		"allowedVaults": ["Homelab"],
+		"secrets": [
+			{
+				"path": "database/password",
+				"reference": "op://Personal/Database/password"
+			}
+		]
+	}`
+
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil {
+		t.Error("Expected error for reference outside allowedVaults, got nil")
+	}
+}
+
+func TestMergeAllowedVaults(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	write := func(name, data string) string {
+		t.Helper()
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	write("shared.json", `{"allowedVaults": ["Shared", "Prod"], "secrets": []}`)
+	main := write("main.json", `{
+		"include": ["shared.json"],
+		"allowedVaults": ["Homelab", "Shared"],
+		"secrets": [{"path": "api/key", "reference": "op://Shared/Api/key"}]
+	}`)
+
+	cfg, err := Load(main)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.AllowedVaults) != 1 || cfg.AllowedVaults[0] != "Shared" {
+		t.Errorf("Expected merged allowedVaults to narrow to [Shared], got %v", cfg.AllowedVaults)
+	}
+
+	// A later file cannot widen the allowlist to a vault an earlier one left out
+	widened := write("widened.json", `{
+		"include": ["shared.json"],
+		"allowedVaults": ["Homelab", "Shared"],
+		"secrets": [{"path": "db/password", "reference": "op://Homelab/Database/password"}]
+	}`)
+	if _, err := Load(widened); err == nil {
+		t.Error("Expected a reference to a vault outside the narrowed allowlist to fail")
+	}
+
+	prod := write("prod.json", `{"allowedVaults": ["Prod"], "secrets": [{"path": "prod", "reference": "op://Prod/Api/key"}]}`)
+	homelab := write("homelab.json", `{"allowedVaults": ["Homelab"], "secrets": [{"path": "homelab", "reference": "op://Homelab/Api/key"}]}`)
+	_, err = LoadMultiple([]string{prod, homelab})
+	if err == nil || !strings.Contains(err.Error(), "No vault in common") {
+		t.Errorf("Expected allowlists with no vault in common to be rejected, got: %v", err)
+	}
+}
+
+func TestLoadWithRequireAbsolutePaths(t *testing.T) {
+	tmpDir := t.TempDir()
+	configPath := filepath.Join(tmpDir, "config.json")
+
+	write := func(pathTemplate string) {
+		t.Helper()
+		configData := fmt.Sprintf(`{
+			"requireAbsolutePaths": true,
+			"pathTemplate": %q,
+			"secrets": [
+				{"path": %q, "reference": "op://Homelab/Database/password"},
+				{"reference": "op://Homelab/Api/key", "variables": {"service": "api", "name": "key"}}
+			]
+		}`, pathTemplate, filepath.Join(tmpDir, "db/password"))
+		if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+	}
+
+	// The templated path is relative to the output directory
+	write("{service}/{name}")
+	_, err := Load(configPath)
+	if err == nil {
+		t.Fatal("Expected error for a relative pathTemplate result, got nil")
+	}
+	if !strings.Contains(err.Error(), "api/key") || !strings.Contains(err.Error(), "requireAbsolutePaths") {
+		t.Errorf("Expected the error to name the relative path, got: %v", err)
+	}
+
+	write(filepath.Join(tmpDir, "{service}/{name}"))
+	if _, err := Load(configPath); err != nil {
+		t.Errorf("Expected absolute final paths to load, got: %v", err)
+	}
+}
+
+func TestLoadWithIncludes(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, "db"), 0755); err != nil {
+		t.Fatalf("Failed to create include dir: %v", err)
+	}
+
+	files := map[string]string{
+		"common.json": `{
+			"defaults": {"service": "common"},
+			"secrets": [
+				{"path": "common/token", "reference": "op://vault/common/token"}
+			]
+		}`,
+		"db/postgres.json": `{
+			"secrets": [
+				{"path": "db/postgres", "reference": "op://vault/postgres/password"}
+			]
+		}`,
+		"db/redis.json": `{
+			"secrets": [
+				{"path": "db/redis", "reference": "op://vault/redis/password"}
+			]
+		}`,
+		"main.json": `{
+			"include": ["common.json", "db/*.json"],
+			"defaults": {"service": "main"},
+			"secrets": [
+				{"path": "main/secret", "reference": "op://vault/main/secret"}
+			]
+		}`,
+	}
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	cfg, err := Load(filepath.Join(tmpDir, "main.json"))
+	if err != nil {
+		t.Fatalf("Failed to load config with includes: %v", err)
+	}
+
+	expectedPaths := []string{"common/token", "db/postgres", "db/redis", "main/secret"}
+	if len(cfg.Secrets) != len(expectedPaths) {
+		t.Fatalf("Expected %d secrets, got %d", len(expectedPaths), len(cfg.Secrets))
+	}
+	for i, path := range expectedPaths {
+		if cfg.Secrets[i].Path != path {
+			t.Errorf("Expected secret[%d] path %s, got %s", i, path, cfg.Secrets[i].Path)
+		}
+	}
+
+	// Each secret remembers the file that defined it
+	for i, source := range []string{"common.json", "db/postgres.json", "db/redis.json", "main.json"} {
+		if want := filepath.Join(tmpDir, source); cfg.Secrets[i].Source != want {
+			t.Errorf("Expected secret[%d] source %s, got %s", i, want, cfg.Secrets[i].Source)
+		}
+	}
+
+	// The including file's defaults win over included ones
+	if cfg.Defaults["service"] != "main" {
+		t.Errorf("Expected default service 'main', got %s", cfg.Defaults["service"])
+	}
+}
+
+func TestLoadWithIncludeCycle(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	files := map[string]string{
+		"a.json": `{
+			"include": ["b.json"],
+			"secrets": [{"path": "a", "reference": "op://vault/a/field"}]
+		}`,
+		"b.json": `{
+			"include": ["a.json"],
+			"secrets": [{"path": "b", "reference": "op://vault/b/field"}]
+		}`,
+	}
+
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(tmpDir, name), []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	_, err := Load(filepath.Join(tmpDir, "a.json"))
+	if err == nil {
+		t.Fatal("Expected error for include cycle, got nil")
+	}
+	if !strings.Contains(err.Error(), "Include cycle detected") {
+		t.Errorf("Expected include cycle error, got: %v", err)
+	}
+}
+
+func TestLoadEnvOnlyConfig(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "config.json")
+	configData := `{
+		"env": {
+			"DATABASE_PASSWORD": "op://vault/db/password"
+		}
+	}`
+
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Expected env-only config to load, got: %v", err)
+	}
+	if cfg.Env["DATABASE_PASSWORD"] != "op://vault/db/password" {
+		t.Errorf("Expected env mapping to be loaded, got %v", cfg.Env)
+	}
+
+	invalidPath := filepath.Join(tmpDir, "invalid.json")
+	invalidData := `{"env": {"1INVALID": "op://vault/db/password"}}`
+	if err := os.WriteFile(invalidPath, []byte(invalidData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	if _, err := Load(invalidPath); err == nil {
+		t.Error("Expected error for invalid environment variable name")
+	}
+}
+
+func TestLoadWithTemplatedReferences(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	configPath := filepath.Join(tmpDir, "config.json")
+	configData := `{
+		"allowedVaults": ["Homelab-prod", "Homelab-staging"],
+		"defaults": {"env": "prod"},
+		"secrets": [
+			{"path": "db/password", "reference": "op://Homelab-{env}/Database/password"},
+			{
+				"path": "api/key",
+				"reference": "op://Homelab-{env}/API/key",
+				"variables": {"env": "staging"}
+			}
+		]
+	}`
+
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Secrets[0].Reference != "op://Homelab-prod/Database/password" {
+		t.Errorf("Expected default to be substituted, got %s", cfg.Secrets[0].Reference)
+	}
+	if cfg.Secrets[1].Reference != "op://Homelab-staging/API/key" {
+		t.Errorf("Expected secret variable to override default, got %s", cfg.Secrets[1].Reference)
+	}
+
+	missingPath := filepath.Join(tmpDir, "missing.json")
+	missingData := `{
+		"secrets": [
+			{"path": "db/password", "reference": "op://Homelab-{env}/Database/password"}
+		]
+	}`
+	if err := os.WriteFile(missingPath, []byte(missingData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+	if _, err := Load(missingPath); err == nil {
+		t.Error("Expected error for undefined reference variable, got nil")
+	}
+}
+
+func TestLoadWithAccountTokenDir(t *testing.T) {
+	tmpDir := t.TempDir()
+	tokenDir := filepath.Join(tmpDir, "tokens")
+	if err := os.MkdirAll(tokenDir, 0700); err != nil {
+		t.Fatalf("Failed to create token dir: %v", err)
+	}
+
+	tokens := map[string]string{
+		"homelab.token": "ops_homelab",
+		"work":          "ops_work",
+		"empty.token":   "  \n",
+		".hidden":       "ops_hidden",
+	}
+	for name, token := range tokens {
+		if err := os.WriteFile(filepath.Join(tokenDir, name), []byte(token), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	configPath := filepath.Join(tmpDir, "config.json")
+	configData := `{
+		"accountTokenDir": "` + tokenDir + `",
+		"accounts": {"work": {"tokenFile": "/etc/opnix-work-token"}},
+		"secrets": [
+			{"path": "homelab/password", "reference": "op://Homelab/Database/password", "account": "homelab"}
+		]
+	}`
+	if err := os.WriteFile(configPath, []byte(configData), 0600); err != nil {
+		t.Fatalf("Failed to write config file: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	if cfg.Accounts["homelab"].TokenFile != filepath.Join(tokenDir, "homelab.token") {
+		t.Errorf("Expected homelab to be registered from the token dir, got %+v", cfg.Accounts["homelab"])
+	}
+	if cfg.Accounts["work"].TokenFile != "/etc/opnix-work-token" {
+		t.Errorf("Expected explicit account to win, got %+v", cfg.Accounts["work"])
+	}
+	if _, exists := cfg.Accounts["empty"]; exists {
+		t.Error("Expected empty token file to be skipped")
+	}
+	if _, exists := cfg.Accounts[".hidden"]; exists {
+		t.Error("Expected hidden files to be ignored")
+	}
+}
+
+func TestLoadWithVaultQualifiers(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(data string) string {
+		path := filepath.Join(tmpDir, "config.json")
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		return path
+	}
+
+	collector := warnings.NewCollector()
+	cfg, err := LoadFormat(write(`{
+		"accounts": {"work": {"tokenFile": "/etc/opnix-work-token"}},
+		"secrets": [
+			{"path": "work/password", "reference": "op://Production@work/Database/password"},
+			{"path": "home/password", "reference": "op://Production/Database/password"},
+			{"path": "id/password", "reference": "op://Production@7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password"}
+		]
+	}`), "", FormatJSON, collector)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Secrets[0].Account != "work" || cfg.Secrets[1].Account != "" || cfg.Secrets[2].Account != "" {
+		t.Errorf("Expected only the account-qualified secret to use the account, got %+v", cfg.Secrets)
+	}
+	list := collector.List()
+	if len(list) != 1 || !strings.Contains(list[0].Message, "Vault 'Production'") || !strings.Contains(list[0].Message, "secret[1]") {
+		t.Errorf("Expected one warning about the bare Production vault, got %+v", list)
+	}
+
+	for name, data := range map[string]string{
+		"conflicting account": `{
+			"accounts": {"work": {"tokenFile": "/etc/opnix-work-token"}, "home": {"tokenFile": "/etc/opnix-home-token"}},
+			"secrets": [{"path": "p", "reference": "op://Production@work/Database/password", "account": "home"}]
+		}`,
+		"undefined account": `{"secrets": [{"path": "p", "reference": "op://Production@work/Database/password"}]}`,
+		"account in env":    `{"env": {"DB_PASSWORD": "op://Production@work/Database/password"}}`,
+	} {
+		if _, err := Load(write(data)); err == nil {
+			t.Errorf("%s: expected the config to be rejected", name)
+		}
+	}
+}
+
+func TestLoadWithUnicodeCheck(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(data string) string {
+		path := filepath.Join(tmpDir, "config.json")
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		return path
+	}
+	secrets := `"secrets": [{"path": "db\u200b/password", "reference": "op://My\u00a0Vault/Database/password"}]`
+
+	collector := warnings.NewCollector()
+	cfg, err := LoadFormat(write(`{`+secrets+`}`), "", FormatJSON, collector)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Secrets[0].Reference != "op://My\u00a0Vault/Database/password" || len(collector.List()) != 2 {
+		t.Errorf("Expected the values kept and warned about by default, got %+v, %+v", cfg.Secrets[0], collector.List())
+	}
+
+	collector = warnings.NewCollector()
+	cfg, err = LoadFormat(write(`{"unicodeCheck": "normalize", `+secrets+`}`), "", FormatJSON, collector)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Secrets[0].Reference != "op://My Vault/Database/password" || cfg.Secrets[0].Path != "db/password" {
+		t.Errorf("Expected normalized values, got %+v", cfg.Secrets[0])
+	}
+	list := collector.List()
+	if len(list) != 2 || !strings.Contains(list[0].Message, "normalized") {
+		t.Errorf("Expected a warning per normalized value, got %+v", list)
+	}
+
+	if _, err := Load(write(`{"unicodeCheck": "error", ` + secrets + `}`)); err == nil {
+		t.Error("Expected error mode to reject the config")
+	}
+	if _, err := Load(write(`{"unicodeCheck": "fix", "secrets": [{"path": "p", "reference": "op://Vault/Item/password"}]}`)); err == nil {
+		t.Error("Expected an unknown unicodeCheck to be rejected")
+	}
+}
+
+func TestLoadWithAccountAddressQualifier(t *testing.T) {
+	tmpDir := t.TempDir()
+	writeToken := func(name, address string) string {
+		path := filepath.Join(tmpDir, name)
+		token := "ops_" + base64.RawURLEncoding.EncodeToString([]byte(`{"signInAddress":"`+address+`"}`))
+		if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+			t.Fatalf("Failed to write token: %v", err)
+		}
+		return path
+	}
+	work := writeToken("work.token", "https://acme.1password.com")
+	home := writeToken("home.token", "https://family.1password.eu")
+	write := func(data string) string {
+		path := filepath.Join(tmpDir, "config.json")
+		if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+			t.Fatalf("Failed to write config file: %v", err)
+		}
+		return path
+	}
+
+	cfg, err := Load(write(`{
+		"accounts": {"work": {"tokenFile": "` + work + `"}, "home": {"tokenFile": "` + home + `"}},
+		"secrets": [
+			{"path": "a", "reference": "op://Production@acme/Database/password"},
+			{"path": "b", "reference": "op://Private@family.1password.eu/Wifi/password"},
+			{"path": "c", "reference": "op://Private@home/Wifi/password"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Secrets[0].Account != "work" || cfg.Secrets[1].Account != "home" || cfg.Secrets[2].Account != "home" {
+		t.Errorf("Expected qualifiers to select accounts by their token's address, got %+v", cfg.Secrets)
+	}
+
+	other := writeToken("other.token", "https://acme.1password.com")
+	_, err = Load(write(`{
+		"accounts": {"work": {"tokenFile": "` + work + `"}, "other": {"tokenFile": "` + other + `"}},
+		"secrets": [{"path": "a", "reference": "op://Production@acme/Database/password"}]
+	}`))
+	if err == nil || !strings.Contains(err.Error(), "other, work") {
+		t.Errorf("Expected two tokens for the same account to be ambiguous, got %v", err)
+	}
+}
+
+func TestSecretReferences(t *testing.T) {
+	secret := Secret{
+		Path:    "app/.env",
+		EnvFile: []EnvFileEntry{{Key: "TOKEN", Reference: "op://Vault/Api/token"}},
+	}
+	if refs := secret.References(); len(refs) != 1 || refs[0] != "op://Vault/Api/token" {
+		t.Errorf("Expected envFile reference, got %v", refs)
+	}
+
+	secret = Secret{Path: "ca.pem", Bundle: []string{"op://Vault/Intermediate/cert", "op://Vault/Root/cert"}}
+	if refs := secret.References(); strings.Join(refs, ",") != "op://Vault/Intermediate/cert,op://Vault/Root/cert" {
+		t.Errorf("Expected bundle references in order, got %v", refs)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		empty   bool
+		wantErr bool
+	}{
+		{name: "missing file", path: filepath.Join(tmpDir, "missing.json"), empty: true},
+		{name: "blank file", path: write("blank.json", " \n"), empty: true},
+		{name: "no secrets", path: write("none.json", `{"secrets": []}`), empty: true},
+		{name: "includes without secrets", path: write("include.json", `{"include": ["none.json"]}`), empty: true},
+		{name: "secrets", path: write("secrets.json", `{"secrets": [{"path": "a", "reference": "op://Vault/Item/a"}]}`)},
+		{name: "env only", path: write("env.json", `{"env": {"TOKEN": "op://Vault/Item/token"}}`)},
+		{name: "invalid JSON", path: write("invalid.json", `{"secrets": [`), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			empty, err := IsEmpty(tt.path, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("IsEmpty() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if empty != tt.empty {
+				t.Errorf("IsEmpty() = %v, want %v", empty, tt.empty)
+			}
+		})
+	}
+}
+
+func TestLoadWithNetwork(t *testing.T) {
+	tmpDir := t.TempDir()
+	load := func(network string) (*Config, error) {
+		path := filepath.Join(tmpDir, "config.json")
+		data := `{"secrets": [{"path": "a", "reference": "op://Vault/Item/a"}], "network": ` + network + `}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(`{"proxy": "http://proxy.internal:3128", "caBundle": "/etc/ssl/proxy-ca.pem"}`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if options := cfg.Network.Options(); options.Proxy != "http://proxy.internal:3128" || options.CABundle != "/etc/ssl/proxy-ca.pem" {
+		t.Errorf("Unexpected network options: %+v", options)
+	}
+
+	if _, err := load(`{"proxy": "proxy.internal:3128"}`); err == nil || !strings.Contains(err.Error(), "proxy") {
+		t.Errorf("Expected an invalid proxy URL to be rejected, got: %v", err)
+	}
+}
+
+func TestLoadWithLock(t *testing.T) {
+	tmpDir := t.TempDir()
+	load := func(lock string) (*Config, error) {
+		path := filepath.Join(tmpDir, "config.json")
+		data := `{"secrets": [{"path": "a", "reference": "op://Vault/Item/a"}], "lock": ` + lock + `}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(`{"mode": "queue", "timeout": "5m"}`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Lock.Mode != "queue" || cfg.Lock.Timeout != "5m" {
+		t.Errorf("Unexpected lock settings: %+v", cfg.Lock)
+	}
+
+	for _, lock := range []string{`{"mode": "block"}`, `{"timeout": "soon"}`, `{"timeout": "-1m"}`} {
+		if _, err := load(lock); err == nil {
+			t.Errorf("Expected lock settings %s to be rejected", lock)
+		}
+	}
+}
+
+func TestLoadWithMaintenanceWindows(t *testing.T) {
+	tmpDir := t.TempDir()
+	load := func(windows string) (*Config, error) {
+		path := filepath.Join(tmpDir, "config.json")
+		data := `{"secrets": [{"path": "a", "reference": "op://Vault/Item/a"}], "systemdIntegration": {"maintenanceWindows": ` + windows + `}}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(`["Sat 02:00-06:00", "Mon-Fri 22:00-06:00"]`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.SystemdIntegration.MaintenanceWindows) != 2 {
+		t.Errorf("Unexpected maintenance windows: %+v", cfg.SystemdIntegration.MaintenanceWindows)
+	}
+
+	if _, err := load(`["02:00-04:00", "weekends"]`); err == nil || !strings.Contains(err.Error(), "maintenanceWindows[1]") {
+		t.Errorf("Expected the invalid window to be rejected, got %v", err)
+	}
+}
+
+func TestLoadWithBackupRetention(t *testing.T) {
+	tmpDir := t.TempDir()
+	load := func(data string) (*Config, error) {
+		path := filepath.Join(tmpDir, "config.json")
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(`{"backupRetention": 3, "secrets": [{"path": "a", "reference": "op://Vault/Item/a", "backupRetention": 0}]}`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.BackupRetention != 3 || cfg.Secrets[0].BackupRetention == nil || *cfg.Secrets[0].BackupRetention != 0 {
+		t.Errorf("Unexpected backup retention: %d, %v", cfg.BackupRetention, cfg.Secrets[0].BackupRetention)
+	}
+
+	if _, err := load(`{"secrets": [{"path": "a", "reference": "op://Vault/Item/a", "backupRetention": -1}]}`); err == nil || !strings.Contains(err.Error(), "secret[0].backupRetention") {
+		t.Errorf("Expected a negative retention to be rejected, got %v", err)
+	}
+}
+
+func TestLoadWithCredential(t *testing.T) {
+	tmpDir := t.TempDir()
+	load := func(secrets string) (*Config, error) {
+		path := filepath.Join(tmpDir, "config.json")
+		if err := os.WriteFile(path, []byte(`{"secrets": `+secrets+`}`), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(`[
+		{"reference": "op://Vault/Db/password", "credential": "db-password"},
+		{"reference": "op://Vault/Db/password", "credential": "db-password", "credentialEncrypted": true},
+		{"reference": "op://Vault/Db/password", "credential": "db-password", "path": "/run/custom/db"}
+	]`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	for i, want := range []string{"/run/credstore/db-password", "/run/credstore.encrypted/db-password", "/run/custom/db"} {
+		if cfg.Secrets[i].Path != want {
+			t.Errorf("Expected secret %d at %s, got %s", i, want, cfg.Secrets[i].Path)
+		}
+	}
+
+	for _, secrets := range []string{
+		`[{"reference": "op://Vault/Db/password", "credential": "db/password"}]`,
+		`[{"path": "db", "reference": "op://Vault/Db/password", "credentialEncrypted": true}]`,
+		`[{"reference": "op://Vault/Db/password", "credential": "db", "credentialEncrypted": true, "copies": [{"path": "db.b64"}]}]`,
+	} {
+		if _, err := load(secrets); err == nil {
+			t.Errorf("Expected %s to be rejected", secrets)
+		}
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, "shared.json"), []byte(`{"secrets": [{"path": "shared", "reference": "op://Vault/Item/shared"}]}`), 0644); err != nil {
+		t.Fatalf("Failed to write include: %v", err)
+	}
+	path := filepath.Join(tmpDir, "provisioning.json")
+	data := `{
+		"hostname": "web-1",
+		"services": {
+			"opnix": {
+				"include": ["shared.json"],
+				"secrets": [{"path": "app", "reference": "op://Vault/Item/app"}]
+			},
+			"other": []
+		}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write document: %v", err)
+	}
+
+	cfg, err := LoadKey(path, "services.opnix", nil)
+	if err != nil {
+		t.Fatalf("Failed to load embedded config: %v", err)
+	}
+	if len(cfg.Secrets) != 2 {
+		t.Errorf("Expected the embedded secret and its include, got %+v", cfg.Secrets)
+	}
+
+	for key, want := range map[string]string{
+		"services.missing":     `"services.missing" not found`,
+		"hostname.opnix":       `"hostname" is not a JSON object`,
+		"services..opnix":      "config-key",
+		"services.other.opnix": `"services.other" is not a JSON object`,
+	} {
+		if _, err := LoadKey(path, key, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("LoadKey(%q) error = %v, want it to mention %s", key, err, want)
+		}
+	}
+
+	// Several documents are read from under the same key and merged
+	other := filepath.Join(tmpDir, "provisioning-2.json")
+	if err := os.WriteFile(other, []byte(`{"services": {"opnix": {"secrets": [{"path": "worker", "reference": "op://Vault/Item/worker"}]}}}`), 0644); err != nil {
+		t.Fatalf("Failed to write document: %v", err)
+	}
+	if merged, err := LoadMultipleFormat([]string{path, other}, "services.opnix", FormatJSON); err != nil || len(merged.Secrets) != 3 {
+		t.Errorf("LoadMultipleFormat() = %+v, %v; want both embedded configs merged", merged, err)
+	}
+
+	// The top level is still the default
+	if _, err := Load(path); err == nil {
+		t.Error("Expected the provisioning document itself to fail validation as a config")
+	}
+	if empty, err := IsEmpty(path, "services.opnix"); err != nil || empty {
+		t.Errorf("IsEmpty() = %v, %v; want the embedded config to count", empty, err)
+	}
+}
+
+func TestLoadWithModePolicies(t *testing.T) {
+	tmpDir := t.TempDir()
+	load := func(policies string) (*Config, error) {
+		path := filepath.Join(tmpDir, "config.json")
+		data := `{"secrets": [{"path": "a", "reference": "op://Vault/Item/a"}], "modePolicies": ` + policies + `}`
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(`[{"dir": "/etc/ssl", "maxMode": "0644"}, {"dir": "/run/secrets", "maxMode": "0600"}]`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if len(cfg.ModePolicies) != 2 || cfg.ModePolicies[1].MaxMode != "0600" {
+		t.Errorf("Unexpected mode policies: %+v", cfg.ModePolicies)
+	}
+
+	for _, policies := range []string{
+		`[{"dir": "run/secrets", "maxMode": "0600"}]`,
+		`[{"dir": "/run/secrets", "maxMode": "rw"}]`,
+		`[{"dir": "/run/secrets"}]`,
+		`[{"dir": "/run/secrets", "maxMode": "0600"}, {"dir": "/run/secrets/", "maxMode": "0640"}]`,
+	} {
+		if _, err := load(policies); err == nil {
+			t.Errorf("Expected mode policies %s to be rejected", policies)
+		}
+	}
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+
+	// Defaults in one file fill the variables of another
+	write("10-base.json", `{"secrets": [], "defaults": {"env": "prod"}, "pathTemplate": "/run/secrets/{name}"}`)
+	write("20-app.json", `{"secrets": [{"reference": "op://Vault-{env}/App/token", "variables": {"name": "token"}}]}`)
+	write("README.md", "not a config")
+
+	cfg, err := LoadDir(dir, nil)
+	if err != nil {
+		t.Fatalf("Failed to load config directory: %v", err)
+	}
+	if len(cfg.Secrets) != 1 || cfg.Secrets[0].Reference != "op://Vault-prod/App/token" {
+		t.Errorf("Unexpected secrets: %+v", cfg.Secrets)
+	}
+
+	// Resolved paths collide across files, and both files are named
+	write("30-db.json", `{"secrets": [{"path": "/run/secrets/token", "reference": "op://Vault/Db/password"}]}`)
+	_, err = LoadDir(dir, nil)
+	if err == nil || !strings.Contains(err.Error(), "20-app.json") || !strings.Contains(err.Error(), "30-db.json") {
+		t.Errorf("Expected a duplicate path naming both files, got %v", err)
+	}
+
+	if _, err := LoadDir(t.TempDir(), nil); err == nil {
+		t.Error("Expected a directory without configs to be rejected")
+	}
+	if _, err := LoadDir(filepath.Join(dir, "missing"), nil); err == nil {
+		t.Error("Expected a missing directory to be rejected")
+	}
+}
+
+func TestCheckSchema(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return path
+	}
+
+	valid := write("valid.json", `{
+		"secrets": [{"path": "a", "reference": "op://Vault/Item/a", "mode": "0600", "services": ["nginx"], "maxRetries": 2}],
+		"systemdIntegration": {"enable": true, "errorHandling": {"maxRetries": 3}},
+		"defaults": {"env": "prod"},
+		"accounts": {"work": {"tokenFile": "/etc/opnix/work"}}
+	}`)
+	if err := CheckSchema(valid, ""); err != nil {
+		t.Errorf("Expected the valid config to pass, got: %v", err)
+	}
+
+	write("included.json", `{"secrets": [{"path": "b", "refernce": "op://Vault/Item/b"}]}`)
+	invalid := write("invalid.json", `{
+		"include": ["included.json"],
+		"secrets": [{"path": "a", "referance": "op://Vault/Item/a", "mode": 600, "Owner": "root"}],
+		"systemdIntegration": {"maintenanceWindows": "Sat 02:00-04:00"}
+	}`)
+	err := CheckSchema(invalid, "")
+	if err == nil {
+		t.Fatal("Expected the invalid config to fail")
+	}
+	for _, want := range []string{
+		`secrets[0].referance: unknown field; did you mean "reference"?`,
+		"secrets[0].mode: expected a string, got the number 600",
+		`secrets[0].Owner: unknown field, names are case-sensitive; did you mean "owner"?`,
+		"systemdIntegration.maintenanceWindows: expected a list, got a string",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected %q in error, got: %v", want, err)
+		}
+	}
+
+	// Included files are checked too
+	fixed := write("fixed.json", `{"include": ["included.json"], "secrets": []}`)
+	if err := CheckSchema(fixed, ""); err == nil || !strings.Contains(err.Error(), "secrets[0].refernce") {
+		t.Errorf("Expected the included file to fail, got: %v", err)
+	}
+
+	embedded := write("embedded.json", `{"services": {"opnix": {"secrets": [], "pathTemplte": "x"}}}`)
+	if err := CheckSchema(embedded, "services.opnix"); err == nil || !strings.Contains(err.Error(), `did you mean "pathTemplate"`) {
+		t.Errorf("Expected the embedded config to be checked, got: %v", err)
+	}
+}
+
+func TestLoadWarnsAboutUnknownFields(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "config.json")
+	data := `{
+		"pathTemplte": "{{ .Name }}",
+		"secrets": [
+			{"path": "a", "reference": "op://Vault/Item/a", "symlink": ["/etc/a"]},
+			{"path": "b", "Reference": "op://Vault/Item/b", "variables": {"anything": "goes"}}
+		]
+	}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	collector := warnings.NewCollector()
+	if _, err := LoadWithWarnings(path, collector); err != nil {
+		t.Fatalf("Unknown fields must not fail loading: %v", err)
+	}
+	var messages []string
+	for _, warning := range collector.List() {
+		messages = append(messages, warning.Message)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 warnings, got %q", messages)
+	}
+	for _, want := range []string{`pathTemplte: unknown field; did you mean "pathTemplate"?`, `secrets[0].symlink: unknown field; did you mean "symlinks"?`} {
+		found := false
+		for _, message := range messages {
+			found = found || strings.Contains(message, want)
+		}
+		if !found {
+			t.Errorf("Expected a warning containing %q, got %q", want, messages)
+		}
+	}
+}
+
+func TestLoadWithDateReferences(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2024, 3, 31, 12, 0, 0, 0, time.Local) }
+
+	tmpDir := t.TempDir()
+	load := func(secret string) (*Config, error) {
+		path := filepath.Join(tmpDir, "config.json")
+		if err := os.WriteFile(path, []byte(`{"secrets": [`+secret+`]}`), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		return Load(path)
+	}
+
+	cfg, err := load(`{"path": "key", "reference": "op://Vault/Keys/{now:2006-01}", "fieldFallbacks": ["{now-1M:2006-01}", "{now-1y:2006-01-02}"]}`)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	if got := cfg.Secrets[0].Reference; got != "op://Vault/Keys/2024-03" {
+		t.Errorf("Reference = %q, want op://Vault/Keys/2024-03", got)
+	}
+	// Mar 31 minus a month is the last day of February, not Mar 2
+	if got := strings.Join(cfg.Secrets[0].FieldFallbacks, ","); got != "2024-02,2023-03-31" {
+		t.Errorf("FieldFallbacks = %q, want 2024-02,2023-03-31", got)
+	}
+
+	for _, reference := range []string{"op://Vault/Keys/{now:month}", "op://Vault/Keys/{now-1q:2006-01}"} {
+		if _, err := load(`{"path": "key", "reference": "` + reference + `"}`); err == nil {
+			t.Errorf("Expected %s to be rejected", reference)
+		}
+	}
+}
+
+func TestNixFragment(t *testing.T) {
+	options := []byte(`{
+		"enable": true,
+		"tokenFile": "/etc/opnix-token",
+		"secrets": {
+			"sslCert": {"reference": "op://Vault/SSL/cert", "path": "/etc/ssl/app.pem", "mode": "0644", "services": {"caddy": {"signal": "SIGHUP"}},
+				"fieldFallbacks": ["certificate"]},
+			"webEnv": {"envFile": [{"key": "API_KEY", "reference": "op://Vault/Api/credential"}]},
+			"dbPassword": {"reference": "op://Vault/DB/password", "services": ["postgresql"], "template": "PASS={{ .Secret }} && true"}
+		},
+		"systemdIntegration": {"errorHandling": {"maxRetries": 5}, "parallelServices": 4}
+	}`)
+
+	fragment, err := NixFragment(options)
+	if err != nil {
+		t.Fatalf("NixFragment failed: %v", err)
+	}
+	for _, want := range []string{
+		// Secrets in name order with the module's defaults, unescaped as builtins.toJSON writes them
+		`"secrets":[{"group":"root","mode":"0600","owner":"root","path":"dbPassword","reference":"op://Vault/DB/password","services":["postgresql"],"symlinks":[],"template":"PASS={{ .Secret }} && true","variables":{}},`,
+		`"services":{"caddy":{"after":["opnix-secrets.service"],"restart":true,"signal":"SIGHUP"}}`,
+		// Options left at null are only written when set
+		`{"fieldFallbacks":["certificate"],"group":"root","mode":"0644"`,
+		`{"envFile":[{"key":"API_KEY","reference":"op://Vault/Api/credential"}],"group":"root","mode":"0600","owner":"root","path":"webEnv","services":[]`,
+		`"errorHandling":{"continueOnError":true,"maxRetries":5,"rollbackOnFailure":false}`,
+		`"pathTemplate":null`,
+		`"parallelServices":4`,
+		`"unitName":"opnix-secrets.service"`,
+	} {
+		if !strings.Contains(string(fragment), want) {
+			t.Errorf("Expected fragment to contain %s, got:\n%s", want, fragment)
+		}
+	}
+
+	// The runtime must accept everything the module writes
+	path := filepath.Join(t.TempDir(), "fragment.json")
+	if err := os.WriteFile(path, fragment, 0644); err != nil {
+		t.Fatalf("Failed to write fragment: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Expected the fragment to load, got: %v", err)
+	}
+	if len(cfg.Secrets) != 3 || cfg.Secrets[1].Path != "/etc/ssl/app.pem" || cfg.SystemdIntegration.ErrorHandling.MaxRetries != 5 ||
+		cfg.SystemdIntegration.ParallelServices != 4 {
+		t.Errorf("Unexpected config loaded from the fragment: %+v", cfg)
+	}
+
+	if err := CheckNixFragment(fragment, options); err != nil {
+		t.Errorf("Expected the fragment to match its options, got: %v", err)
+	}
+	drifted := strings.Replace(string(fragment), `"mode":"0644"`, `"mode":"0640"`, 1)
+	if err := CheckNixFragment([]byte(drifted), options); err == nil || !strings.Contains(err.Error(), `secrets[1].mode: expected "0644", got "0640"`) {
+		t.Errorf("Expected the changed mode to be reported, got: %v", err)
+	}
+	extra := strings.Replace(string(fragment), `"pathTemplate":null`, `"pathTemplate":null,"pathPrefix":"/run"`, 1)
+	if err := CheckNixFragment([]byte(extra), nil); err == nil {
+		t.Error("Expected a field the module never writes to be rejected")
+	}
+
+	for name, bad := range map[string]string{
+		"key name":              `{"secrets": {"db-password": {"reference": "op://V/I/f"}}}`,
+		"mode":                  `{"secrets": {"db": {"reference": "op://V/I/f", "mode": "rw"}}}`,
+		"reference":             `{"secrets": {"db": {"path": "/etc/db"}}}`,
+		"option":                `{"secrets": {"db": {"reference": "op://V/I/f", "owners": "root"}}}`,
+		"parallel":              `{"systemdIntegration": {"parallelServices": 0}}`,
+		"enum":                  `{"networkFilesystem": "ignore"}`,
+		"placeholder mode":      `{"placeholderCheck": {"mode": "loud"}}`,
+		"resolve parallel":      `{"resolve": {"parallel": 0}}`,
+		"type":                  `{"secrets": {"db": {"reference": "op://V/I/f", "type": "secret"}}}`,
+		"copy encoding":         `{"secrets": {"db": {"reference": "op://V/I/f", "copies": [{"path": "db.txt", "encoding": "gzip"}]}}}`,
+		"lock mode":             `{"lock": {"mode": "block"}}`,
+		"unknown sshKeysFormat": `{"secrets": {"keys": {"sshKeys": ["op://V/I/f"], "sshKeysFormat": "pem"}}}`,
+		"unknown unicodeCheck":  `{"unicodeCheck": "fix", "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+	} {
+		if _, err := NixFragment([]byte(bad)); err == nil {
+			t.Errorf("Expected an invalid %s to be rejected", name)
+		}
+	}
+}
+
+func TestNixFragmentOptionalSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		options string
+		want    []string
+	}{
+		{
+			name:    "named accounts",
+			options: `{"accounts": {"team": {"tokenFile": "/etc/opnix-team-token"}}, "secrets": {"db": {"reference": "op://V/I/f", "account": "team"}}}`,
+			want:    []string{`{"account":"team","group":"root"`, `{"accounts":{"team":{"tokenFile":"/etc/opnix-team-token"}},"defaults":{}`},
+		},
+		{
+			name:    "network filesystems",
+			options: `{"networkFilesystem": "refuse", "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"defaults":{},"networkFilesystem":"refuse","pathTemplate":null`},
+		},
+		{
+			name:    "descriptions",
+			options: `{"secrets": {"db": {"reference": "op://V/I/f", "description": "Reporting database"}}}`,
+			want:    []string{`{"description":"Reporting database","group":"root"`},
+		},
+		{
+			name:    "base directories",
+			options: `{"baseDir": "/var/lib/opnix", "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`{"baseDir":"/var/lib/opnix","defaults":{}`},
+		},
+		{
+			name:    "symlink directory modes",
+			options: `{"secrets": {"db": {"reference": "op://V/I/f", "symlinks": ["/etc/app/db"], "symlinkDirMode": "2750"}}}`,
+			want:    []string{`"symlinkDirMode":"2750","symlinks":["/etc/app/db"]`},
+		},
+		{
+			name:    "non-empty values",
+			options: `{"requireNonEmpty": false, "secrets": {"db": {"reference": "op://V/I/f", "requireNonEmpty": true}}}`,
+			want:    []string{`"reference":"op://V/I/f","requireNonEmpty":true,"services":[]`, `"pathTemplate":null,"requireNonEmpty":false,"secrets"`},
+		},
+		{
+			name:    "transactions",
+			options: `{"secrets": {"cert": {"reference": "op://V/TLS/cert", "transaction": "tls"}, "key": {"reference": "op://V/TLS/key", "transaction": "tls"}}}`,
+			want:    []string{`"template":"","transaction":"tls","variables":{}`},
+		},
+		{
+			name:    "validation commands",
+			options: `{"secrets": {"cert": {"reference": "op://V/TLS/cert", "validateWith": ["/run/current-system/sw/bin/openssl", "x509", "-noout", "-in"], "validateTimeout": "1m"}}}`,
+			want:    []string{`"validateTimeout":"1m","validateWith":["/run/current-system/sw/bin/openssl","x509","-noout","-in"]`},
+		},
+		{
+			name:    "placeholder checks",
+			options: `{"placeholderCheck": {"mode": "strict", "minLength": 12}, "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"pathTemplate":null,"placeholderCheck":{"minLength":12,"mode":"strict"},"secrets"`},
+		},
+		{
+			name:    "resolve settings",
+			options: `{"resolve": {"groupByItem": true, "parallel": 8, "cache": {"file": "/var/lib/opnix/resolve-cache"}, "vaultRateLimits": {"Legacy": 2}}, "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"pathTemplate":null,"resolve":{"cache":{"file":"/var/lib/opnix/resolve-cache"},"groupByItem":true,"parallel":8,"vaultRateLimits":{"Legacy":2}},"secrets"`},
+		},
+		{
+			name:    "bundles",
+			options: `{"secrets": {"caBundle": {"mode": "0644", "bundle": ["op://Vault/Intermediate CA/cert", "op://Vault/Root CA/cert"]}}}`,
+			want:    []string{`[{"bundle":["op://Vault/Intermediate CA/cert","op://Vault/Root CA/cert"],"group":"root","mode":"0644","owner":"root","path":"caBundle","services":[]`},
+		},
+		{
+			name:    "ini files",
+			options: `{"secrets": {"appIni": {"ini": [{"key": "environment", "reference": "op://Vault/App/environment"}, {"section": "database", "key": "password", "reference": "op://Vault/Database/password"}]}}}`,
+			want:    []string{`{"group":"root","ini":[{"key":"environment","reference":"op://Vault/App/environment"},{"key":"password","reference":"op://Vault/Database/password","section":"database"}],"mode":"0600"`},
+		},
+		{
+			name:    "field types",
+			options: `{"secrets": {"db": {"reference": "op://V/I/password", "type": "password"}}}`,
+			want:    []string{`"template":"","type":"password","variables":{}`},
+		},
+		{
+			name:    "JSON templates",
+			options: `{"secrets": {"db": {"reference": "op://V/I/f", "template": "{{ .SecretJSON.host }}", "templateJSON": true}}}`,
+			want:    []string{`"template":"{{ .SecretJSON.host }}","templateJSON":true,"variables":{}`},
+		},
+		{
+			name:    "seeded secrets",
+			options: `{"secrets": {"key": {"reference": "op://V/I/f", "skipIfExists": true}}}`,
+			want:    []string{`"services":[],"skipIfExists":true,"symlinks":[]`},
+		},
+		{
+			name:    "copies",
+			options: `{"secrets": {"tlsKey": {"reference": "op://Vault/TLS/private-key", "copies": [{"path": "tls/key.pem.b64", "encoding": "base64"}, {"path": "/etc/nginx/key.pem", "group": "root", "mode": "0640"}]}}}`,
+			want:    []string{`[{"copies":[{"encoding":"base64","path":"tls/key.pem.b64"},{"group":"root","mode":"0640","path":"/etc/nginx/key.pem"}],"group":"root"`},
+		},
+		{
+			name:    "network settings",
+			options: `{"network": {"proxy": "http://proxy.internal:3128"}, "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"defaults":{},"network":{"proxy":"http://proxy.internal:3128"},"pathTemplate":null`},
+		},
+		{
+			name:    "tags",
+			options: `{"secrets": {"cert": {"reference": "op://V/TLS/cert", "tags": ["tls", "prod"]}}}`,
+			want:    []string{`"symlinks":[],"tags":["tls","prod"],"template":""`},
+		},
+		{
+			name:    "lock settings",
+			options: `{"lock": {"mode": "queue", "timeout": "10m"}, "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"defaults":{},"lock":{"mode":"queue","timeout":"10m"},"pathTemplate":null`},
+		},
+		{
+			name:    "certificate expiry",
+			options: `{"secrets": {"cert": {"reference": "op://V/TLS/cert", "certExpiryWarnDays": 30, "certExpiryStrict": true}}}`,
+			want:    []string{`[{"certExpiryStrict":true,"certExpiryWarnDays":30,"group":"root"`},
+		},
+		{
+			name:    "webhooks",
+			options: `{"webhook": {"url": "https://hooks.example.com/opnix", "timeout": "5s"}, "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"systemdIntegration":{`, `"webhook":{"timeout":"5s","url":"https://hooks.example.com/opnix"}}`},
+		},
+		{
+			name:    "credentials",
+			options: `{"secrets": {"dbPassword": {"reference": "op://V/DB/password", "credential": "db-password", "credentialEncrypted": true}}}`,
+			want:    []string{`[{"credential":"db-password","credentialEncrypted":true,"group":"root","mode":"0600","owner":"root","reference":"op://V/DB/password"`},
+		},
+		{
+			name:    "serially resolved secrets",
+			options: `{"secrets": {"rotated": {"reference": "op://V/I/f", "serial": true}}}`,
+			want:    []string{`"reference":"op://V/I/f","serial":true`},
+		},
+		{
+			name:    "ssh key files",
+			options: `{"secrets": {"knownHosts": {"mode": "0644", "sshKeys": ["op://Fleet/Git Host/public key"], "sshKeysFormat": "known_hosts"}}}`,
+			want:    []string{`"sshKeys":["op://Fleet/Git Host/public key"],"sshKeysFormat":"known_hosts"`},
+		},
+		{
+			name:    "filtered secrets",
+			options: `{"secrets": {"db": {"reference": "op://V/I/f", "filter": ["/bin/jq", "-r", ".password"], "filterTimeout": "5s"}}}`,
+			want:    []string{`"filter":["/bin/jq","-r",".password"],"filterTimeout":"5s"`},
+		},
+		{
+			name:    "canonical output",
+			options: `{"secrets": {"settings": {"reference": "op://V/I/f", "canonical": true}}}`,
+			want:    []string{`[{"canonical":true,"group":"root"`},
+		},
+		{
+			name:    "per-secret cache lifetimes",
+			options: `{"secrets": {"token": {"reference": "op://V/I/f", "cacheTTL": "1h"}}}`,
+			want:    []string{`[{"cacheTTL":"1h","group":"root"`},
+		},
+		{
+			name:    "regions of shared files",
+			options: `{"secrets": {"appConf": {"reference": "op://V/I/f", "path": "/etc/app/app.conf", "region": {"name": "db"}}}}`,
+			want:    []string{`"reference":"op://V/I/f","region":{"name":"db"},"services":[]`},
+		},
+		{
+			name:    "runbook hints",
+			options: `{"secrets": {"db": {"reference": "op://V/I/f", "onErrorHint": "Page the DBA"}}}`,
+			want:    []string{`"mode":"0600","onErrorHint":"Page the DBA","owner":"root"`},
+		},
+		{
+			name:    "backups",
+			options: `{"backupRetention": 2, "secrets": {"db": {"reference": "op://V/I/f", "backupRetention": 5}}}`,
+			want:    []string{`{"backupRetention":2,`, `[{"backupRetention":5,"group":"root"`},
+		},
+		{
+			name:    "extracted values",
+			options: `{"secrets": {"hook": {"reference": "op://V/I/f", "extract": "token=([^&]+)"}}}`,
+			want:    []string{`[{"extract":"token=([^&]+)","group":"root"`},
+		},
+		{
+			name:    "conditional secrets",
+			options: `{"secrets": {"db": {"reference": "op://V/I/f", "onlyIf": {"exists": "/etc/flags/db", "command": ["/bin/test", "-f", "/etc/flags/ready"], "timeout": "5s"}}}}`,
+			want:    []string{`"onlyIf":{"command":["/bin/test","-f","/etc/flags/ready"],"exists":"/etc/flags/db","timeout":"5s"}`},
+		},
+		{
+			name:    "mode policies",
+			options: `{"modePolicies": [{"dir": "/run/secrets", "maxMode": "0600"}], "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"modePolicies":[{"dir":"/run/secrets","maxMode":"0600"}]`},
+		},
+		{
+			name:    "unicode checks",
+			options: `{"unicodeCheck": "normalize", "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"unicodeCheck":"normalize"`},
+		},
+		{
+			name:    "metrics",
+			options: `{"metricsFile": "/var/lib/node-exporter/opnix.prom", "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`"metricsFile":"/var/lib/node-exporter/opnix.prom"`},
+		},
+		{
+			name:    "absolute paths only",
+			options: `{"requireAbsolutePaths": true, "secrets": {"db": {"reference": "op://V/I/f", "path": "/run/db"}}}`,
+			want:    []string{`"requireAbsolutePaths":true`},
+		},
+		{
+			name:    "transformed values",
+			options: `{"secrets": {"hook": {"reference": "op://V/I/f", "transforms": ["base64decode", "trimNewline"]}}}`,
+			want:    []string{`"symlinks":[],"template":"","transforms":["base64decode","trimNewline"]`},
+		},
+		{
+			name:    "item secrets",
+			options: `{"secrets": {"database": {"item": "op://V/Database", "fields": {"username": "db/username", "password": "db/password"}}}}`,
+			want:    []string{`[{"fields":{"password":"db/password","username":"db/username"},"group":"root","item":"op://V/Database","mode":"0600","owner":"root","services":[]`},
+		},
+		{
+			name:    "account token directories",
+			options: `{"accountTokenDir": "{tmp}", "secrets": {"db": {"reference": "op://V/I/f"}}}`,
+			want:    []string{`{"accountTokenDir":"{tmp}",`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// {tmp} stands for a directory that must exist when the fragment loads
+			tmp := t.TempDir()
+			options := strings.ReplaceAll(tt.options, "{tmp}", tmp)
+			fragment, err := NixFragment([]byte(options))
+			if err != nil {
+				t.Fatalf("NixFragment failed: %v", err)
+			}
+			for _, want := range tt.want {
+				want = strings.ReplaceAll(want, "{tmp}", tmp)
+				if !strings.Contains(string(fragment), want) {
+					t.Errorf("Expected fragment to contain %s, got:\n%s", want, fragment)
+				}
+			}
+
+			// The runtime must accept what the module writes for the option
+			path := filepath.Join(t.TempDir(), "fragment.json")
+			if err := os.WriteFile(path, fragment, 0644); err != nil {
+				t.Fatalf("Failed to write fragment: %v", err)
+			}
+			if _, err := Load(path); err != nil {
+				t.Errorf("Expected the fragment to load, got: %v", err)
+			}
+			if err := CheckNixFragment(fragment, []byte(options)); err != nil {
+				t.Errorf("Expected the fragment to match its options, got: %v", err)
+			}
+		})
+	}
+}
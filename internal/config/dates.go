@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// now is the time date placeholders are expanded against
+var now = time.Now
+
+// datePlaceholder matches {now:LAYOUT} and {now+N<unit>:LAYOUT}, where unit
+// is h, d, w, M or y and LAYOUT is a Go time layout such as 2006-01
+var datePlaceholder = regexp.MustCompile(`\{now(?:([+-])(\d+)([hdwMy]))?:([^}]+)\}`)
+
+// expandDates replaces date placeholders in s with the host's local date,
+// for items keeping one field per period, such as op://Vault/Keys/{now:2006-01}
+func expandDates(s, field string) (string, error) {
+	if !strings.Contains(s, "{now") {
+		return s, nil
+	}
+
+	var expandErr error
+	expanded := datePlaceholder.ReplaceAllStringFunc(s, func(placeholder string) string {
+		match := datePlaceholder.FindStringSubmatch(placeholder)
+		t := now()
+		if match[1] != "" {
+			n, _ := strconv.Atoi(match[2])
+			if match[1] == "-" {
+				n = -n
+			}
+			t = shiftDate(t, n, match[3])
+		}
+		layout := match[4]
+		formatted := t.Format(layout)
+		if formatted == layout && expandErr == nil {
+			expandErr = errors.ConfigValidationError(
+				field,
+				s,
+				fmt.Sprintf("Date placeholder %s has no date elements in its layout", placeholder),
+				[]string{"Use Go's reference date in the layout: 2006 for the year, 01 for the month, 02 for the day, e.g. {now:2006-01}"},
+			)
+		}
+		return formatted
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	if strings.Contains(expanded, "{now") {
+		return "", errors.ConfigValidationError(
+			field,
+			s,
+			"Invalid date placeholder",
+			[]string{
+				"Write {now:LAYOUT} with a Go time layout, e.g. {now:2006-01} for the current month",
+				"Shift the date with a signed offset in h, d, w, M or y, e.g. {now-1M:2006-01} for the previous month",
+			},
+		)
+	}
+	return expanded, nil
+}
+
+// shiftDate moves t by n units. Months and years keep the day where the
+// target month has it and otherwise use its last day, so Mar 31 - 1M is
+// Feb 28 rather than Mar 3.
+func shiftDate(t time.Time, n int, unit string) time.Time {
+	switch unit {
+	case "h":
+		return t.Add(time.Duration(n) * time.Hour)
+	case "d":
+		return t.AddDate(0, 0, n)
+	case "w":
+		return t.AddDate(0, 0, 7*n)
+	}
+
+	months := n
+	if unit == "y" {
+		months = 12 * n
+	}
+	shifted := t.AddDate(0, months, 0)
+	if shifted.Day() != t.Day() {
+		// Overflowed into the next month; step back to the last day of the target
+		shifted = shifted.AddDate(0, 0, -shifted.Day())
+	}
+	return shifted
+}
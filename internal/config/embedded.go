@@ -0,0 +1,51 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// extractKey returns the JSON found under key, a dot-separated path of object
+// keys, in a larger document such as the output of a provisioning tool
+func extractKey(data []byte, key, path string) ([]byte, error) {
+	current := json.RawMessage(data)
+	walked := []string{}
+	for _, part := range strings.Split(key, ".") {
+		if part == "" {
+			return nil, errors.ValidationError(
+				"Parsing configuration file",
+				"config-key",
+				key,
+				"dot-separated object keys, e.g. opnix or services.opnix",
+			)
+		}
+
+		var object map[string]json.RawMessage
+		if err := json.Unmarshal(current, &object); err != nil || object == nil {
+			location := "the top level"
+			if len(walked) > 0 {
+				location = fmt.Sprintf("%q", strings.Join(walked, "."))
+			}
+			return nil, errors.ConfigError(
+				"Parsing configuration file",
+				fmt.Sprintf("Cannot look up %q in %s: %s is not a JSON object", key, path, location),
+				err,
+			)
+		}
+
+		walked = append(walked, part)
+		value, ok := object[part]
+		if !ok {
+			return nil, errors.ConfigError(
+				"Parsing configuration file",
+				fmt.Sprintf("Key %q not found in %s", strings.Join(walked, "."), path),
+				nil,
+			)
+		}
+		current = value
+	}
+	return current, nil
+}
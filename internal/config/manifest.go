@@ -0,0 +1,208 @@
+package config
+
+import (
+	"bytes"
+	"encoding/csv"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Config file formats accepted by LoadFormat
+const (
+	FormatJSON = "json"
+	// One secret per row under a header naming the columns, see manifestColumns
+	FormatCSV = "csv"
+	FormatTSV = "tsv"
+)
+
+// manifestColumns maps each column a CSV/TSV manifest may have to the secret
+// setting it fills. List settings hold several values separated by ';'.
+var manifestColumns = map[string]func(*Secret, string){
+	"path":        func(s *Secret, v string) { s.Path = v },
+	"reference":   func(s *Secret, v string) { s.Reference = v },
+	"owner":       func(s *Secret, v string) { s.Owner = v },
+	"group":       func(s *Secret, v string) { s.Group = v },
+	"mode":        func(s *Secret, v string) { s.Mode = v },
+	"symlinks":    func(s *Secret, v string) { s.Symlinks = splitManifestList(v) },
+	"type":        func(s *Secret, v string) { s.Type = v },
+	"account":     func(s *Secret, v string) { s.Account = v },
+	"transaction": func(s *Secret, v string) { s.Transaction = v },
+	"credential":  func(s *Secret, v string) { s.Credential = v },
+	"description": func(s *Secret, v string) { s.Description = v },
+	"tags":        func(s *Secret, v string) { s.Tags = splitManifestList(v) },
+}
+
+// loadDocument reads the config at path in format without validation
+func loadDocument(path, key, format string) (*Config, error) {
+	switch format {
+	case "", FormatJSON:
+		return loadWithIncludes(path, key, nil)
+	case FormatCSV, FormatTSV:
+		if key != "" {
+			return nil, errors.ConfigError(
+				"Loading configuration file",
+				fmt.Sprintf("-config-key only applies to JSON configs, not %s manifests", format),
+				nil,
+			)
+		}
+		return loadManifest(path, format)
+	default:
+		return nil, errors.ValidationError(
+			"Loading configuration file",
+			"config-format",
+			format,
+			"json, csv or tsv",
+		)
+	}
+}
+
+// loadManifest reads a CSV or TSV manifest of secrets. The first row names
+// the columns; path and reference are required, the rest are optional.
+func loadManifest(path, format string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.FileOperationError(
+			"Loading configuration file",
+			path,
+			"Failed to read config file",
+			err,
+		)
+	}
+
+	secrets, err := parseManifest(data, format, path)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{Secrets: secrets}, nil
+}
+
+// parseManifest turns the rows of a manifest into secrets. Blank lines and
+// lines starting with '#' are skipped.
+func parseManifest(data []byte, format, path string) ([]Secret, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.Comment = '#'
+	reader.TrimLeadingSpace = true
+	if format == FormatTSV {
+		reader.Comma = '\t'
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		issue := fmt.Sprintf("Missing header row in %s", path)
+		if !stderrors.Is(err, io.EOF) {
+			issue = manifestIssue(path, err)
+		}
+		return nil, errors.ConfigError("Parsing configuration file", issue, nil)
+	}
+
+	headerLine, _ := reader.FieldPos(0)
+	columns, err := manifestHeader(header, path, headerLine)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []Secret
+	for {
+		record, err := reader.Read()
+		if stderrors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errors.ConfigError("Parsing configuration file", manifestIssue(path, err), nil)
+		}
+		line, _ := reader.FieldPos(0)
+
+		secret := Secret{Source: path}
+		for i, value := range record {
+			manifestColumns[columns[i]](&secret, strings.TrimSpace(value))
+		}
+		if secret.Reference == "" {
+			return nil, errors.ConfigError(
+				"Parsing configuration file",
+				fmt.Sprintf("%s line %d: reference is empty", path, line),
+				nil,
+			)
+		}
+		if secret.Path == "" && secret.Credential == "" {
+			return nil, errors.ConfigError(
+				"Parsing configuration file",
+				fmt.Sprintf("%s line %d: path is empty", path, line),
+				nil,
+			)
+		}
+		secrets = append(secrets, secret)
+	}
+
+	return secrets, nil
+}
+
+// manifestHeader checks the header row and returns its column names
+func manifestHeader(header []string, path string, line int) ([]string, error) {
+	columns := make([]string, len(header))
+	seen := make(map[string]bool)
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, ok := manifestColumns[name]; !ok {
+			supported := make([]string, 0, len(manifestColumns))
+			for column := range manifestColumns {
+				supported = append(supported, column)
+			}
+			sort.Strings(supported)
+			return nil, errors.ConfigValidationError(
+				"header",
+				header[i],
+				fmt.Sprintf("Unknown column in %s line %d", path, line),
+				[]string{"Supported columns: " + strings.Join(supported, ", ")},
+			)
+		}
+		if seen[name] {
+			return nil, errors.ConfigError(
+				"Parsing configuration file",
+				fmt.Sprintf("%s line %d: column %q appears twice", path, line, name),
+				nil,
+			)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+
+	for _, required := range []string{"path", "reference"} {
+		if !seen[required] {
+			return nil, errors.ConfigError(
+				"Parsing configuration file",
+				fmt.Sprintf("%s line %d: header is missing the required %q column", path, line, required),
+				nil,
+			)
+		}
+	}
+	return columns, nil
+}
+
+// manifestIssue describes a row the CSV reader rejected, by line number
+func manifestIssue(path string, err error) string {
+	var parseErr *csv.ParseError
+	if stderrors.As(err, &parseErr) {
+		if stderrors.Is(parseErr.Err, csv.ErrFieldCount) {
+			return fmt.Sprintf("%s line %d: row has a different number of columns than the header", path, parseErr.StartLine)
+		}
+		return fmt.Sprintf("%s line %d: %v", path, parseErr.Line, parseErr.Err)
+	}
+	return fmt.Sprintf("%s: %v", path, err)
+}
+
+// splitManifestList splits a ';'-separated cell, dropping empty entries
+func splitManifestList(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ";") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name, data string) string {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("Failed to write manifest: %v", err)
+		}
+		return path
+	}
+
+	path := write("secrets.csv", `# Database credentials
+path,reference,mode,owner,tags,symlinks
+db/password,op://Vault/Db/password,0600,root,db;prod,/etc/db/password
+
+"api,key",op://Vault/Api/credential,,,,
+`)
+	cfg, err := LoadFormat(path, "", FormatCSV, nil)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(cfg.Secrets) != 2 {
+		t.Fatalf("Expected 2 secrets, got %d", len(cfg.Secrets))
+	}
+	first := cfg.Secrets[0]
+	if first.Path != "db/password" || first.Reference != "op://Vault/Db/password" || first.Mode != "0600" ||
+		first.Owner != "root" || strings.Join(first.Tags, ",") != "db,prod" ||
+		len(first.Symlinks) != 1 || first.Source != path {
+		t.Errorf("Unexpected first secret: %+v", first)
+	}
+	if cfg.Secrets[1].Path != "api,key" || cfg.Secrets[1].Tags != nil {
+		t.Errorf("Unexpected second secret: %+v", cfg.Secrets[1])
+	}
+
+	tsv := write("secrets.tsv", "Reference\tPath\nop://Vault/Item/field\tout/field\n")
+	if cfg, err := LoadFormat(tsv, "", FormatTSV, nil); err != nil || cfg.Secrets[0].Path != "out/field" {
+		t.Errorf("Failed to load TSV manifest: %v", err)
+	}
+
+	if empty, err := IsEmptyFormat(write("header.csv", "path,reference\n"), "", FormatCSV); err != nil || !empty {
+		t.Errorf("IsEmptyFormat() = %v, %v; want a header-only manifest to be empty", empty, err)
+	}
+
+	tests := []struct {
+		name     string
+		manifest string
+		want     string
+	}{
+		{"no header", "", "Missing header row"},
+		{"unknown column", "path,reference,colour\n", "Unknown column"},
+		{"missing column", "path,mode\n", `"reference" column`},
+		{"duplicate column", "path,reference,path\n", "appears twice"},
+		{"short row", "path,reference\na,op://Vault/Item/a\nb\n", "line 3: row has a different number of columns"},
+		{"empty reference", "path,reference\n\na,\n", "line 3: reference is empty"},
+		{"bad quoting", "path,reference\na,\"op://Vault\"x\n", "line 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFormat(write("bad.csv", tt.manifest), "", FormatCSV, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+
+	if _, err := LoadFormat(path, "opnix", FormatCSV, nil); err == nil {
+		t.Error("Expected a key to be rejected for a manifest")
+	}
+	if _, err := LoadFormat(path, "", "yaml", nil); err == nil {
+		t.Error("Expected an unknown format to be rejected")
+	}
+}
@@ -0,0 +1,721 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// The NixOS module (nix/module.nix) turns services.onepassword-secrets into
+// a config file with builtins.toJSON. NixFragment does the same from those
+// options as JSON, defaults included, so modules built outside Nix and the
+// checks below agree with it byte for byte.
+
+// nixSecretKey is the module's rule for secret names
+var nixSecretKey = regexp.MustCompile(`^[a-z][a-zA-Z0-9]*$`)
+
+// nixMode is the module's assertion on secret modes
+var nixMode = regexp.MustCompile(`^[0-7]{3,4}$`)
+
+// nixOptions are the services.onepassword-secrets options, as from
+// nix eval --json. Options the module does not put in the config are
+// accepted and ignored.
+type nixOptions struct {
+	Secrets              map[string]nixSecretOptions `json:"secrets"`
+	PathTemplate         *string                     `json:"pathTemplate"`
+	Defaults             map[string]string           `json:"defaults"`
+	SystemdIntegration   nixSystemdOptions           `json:"systemdIntegration"`
+	AccountTokenDir      *string                     `json:"accountTokenDir"`
+	RequireAbsolutePaths *bool                       `json:"requireAbsolutePaths"`
+	MetricsFile          *string                     `json:"metricsFile"`
+	UnicodeCheck         *string                     `json:"unicodeCheck"`
+	ModePolicies         *[]nixModePolicy            `json:"modePolicies"`
+	BackupRetention      *int                        `json:"backupRetention"`
+	Webhook              *nixWebhook                 `json:"webhook"`
+	Lock                 *nixLock                    `json:"lock"`
+	Network              *nixNetwork                 `json:"network"`
+	Resolve              *nixResolve                 `json:"resolve"`
+	PlaceholderCheck     *nixPlaceholderCheck        `json:"placeholderCheck"`
+	RequireNonEmpty      *bool                       `json:"requireNonEmpty"`
+	BaseDir              *string                     `json:"baseDir"`
+	NetworkFilesystem    *string                     `json:"networkFilesystem"`
+	Accounts             *map[string]nixAccount      `json:"accounts"`
+
+	Enable                     json.RawMessage `json:"enable"`
+	TokenFile                  json.RawMessage `json:"tokenFile"`
+	UpdateTokenFilePermissions json.RawMessage `json:"updateTokenFilePermissions"`
+	ConfigFiles                json.RawMessage `json:"configFiles"`
+	OutputDir                  json.RawMessage `json:"outputDir"`
+	Users                      json.RawMessage `json:"users"`
+	SecretPaths                json.RawMessage `json:"secretPaths"`
+}
+
+type nixAccount struct {
+	TokenFile string `json:"tokenFile"`
+}
+
+type nixModePolicy struct {
+	Dir     string `json:"dir"`
+	MaxMode string `json:"maxMode"`
+}
+
+type nixResolve struct {
+	Cache           *nixResolveCache    `json:"cache,omitempty"`
+	GroupByItem     *bool               `json:"groupByItem,omitempty"`
+	MaxRetries      *int                `json:"maxRetries,omitempty"`
+	Parallel        *int                `json:"parallel,omitempty"`
+	RateLimit       *float64            `json:"rateLimit,omitempty"`
+	RetryableErrors *[]string           `json:"retryableErrors,omitempty"`
+	Timeout         *string             `json:"timeout,omitempty"`
+	VaultRateLimits *map[string]float64 `json:"vaultRateLimits,omitempty"`
+}
+
+type nixResolveCache struct {
+	File    *string `json:"file,omitempty"`
+	KeyFile *string `json:"keyFile,omitempty"`
+	TTL     *string `json:"ttl,omitempty"`
+}
+
+type nixNetwork struct {
+	CABundle *string `json:"caBundle,omitempty"`
+	Proxy    *string `json:"proxy,omitempty"`
+}
+
+type nixLock struct {
+	Mode    *string `json:"mode,omitempty"`
+	Timeout *string `json:"timeout,omitempty"`
+}
+
+type nixWebhook struct {
+	Timeout *string `json:"timeout,omitempty"`
+	URL     *string `json:"url,omitempty"`
+}
+
+type nixPlaceholderCheck struct {
+	MinEntropyBits *float64  `json:"minEntropyBits,omitempty"`
+	MinLength      *int      `json:"minLength,omitempty"`
+	Mode           *string   `json:"mode,omitempty"`
+	Placeholders   *[]string `json:"placeholders,omitempty"`
+}
+
+// Options that default to null in the module are pointers, and are left out
+// of the fragment when unset
+type nixSecretOptions struct {
+	Reference *string           `json:"reference"`
+	Path      *string           `json:"path"`
+	Symlinks  []string          `json:"symlinks"`
+	Variables map[string]string `json:"variables"`
+	Owner     *string           `json:"owner"`
+	Group     *string           `json:"group"`
+	Mode      *string           `json:"mode"`
+	Template  *string           `json:"template"`
+	Services  json.RawMessage   `json:"services"`
+
+	FieldFallbacks      *[]string          `json:"fieldFallbacks"`
+	EnvFile             *[]nixEnvFileEntry `json:"envFile"`
+	Account             *string            `json:"account"`
+	Description         *string            `json:"description"`
+	SymlinkDirMode      *string            `json:"symlinkDirMode"`
+	RequireNonEmpty     *bool              `json:"requireNonEmpty"`
+	Transaction         *string            `json:"transaction"`
+	ValidateWith        *[]string          `json:"validateWith"`
+	ValidateTimeout     *string            `json:"validateTimeout"`
+	Bundle              *[]string          `json:"bundle"`
+	INI                 *[]nixINIEntry     `json:"ini"`
+	Type                *string            `json:"type"`
+	TemplateJSON        *bool              `json:"templateJSON"`
+	SkipIfExists        *bool              `json:"skipIfExists"`
+	Copies              *[]nixCopy         `json:"copies"`
+	Tags                *[]string          `json:"tags"`
+	CertExpiryWarnDays  *int               `json:"certExpiryWarnDays"`
+	CertExpiryStrict    *bool              `json:"certExpiryStrict"`
+	Credential          *string            `json:"credential"`
+	CredentialEncrypted *bool              `json:"credentialEncrypted"`
+	Serial              *bool              `json:"serial"`
+	SSHKeys             *[]string          `json:"sshKeys"`
+	SSHKeysFormat       *string            `json:"sshKeysFormat"`
+	Filter              *[]string          `json:"filter"`
+	FilterTimeout       *string            `json:"filterTimeout"`
+	Canonical           *bool              `json:"canonical"`
+	CacheTTL            *string            `json:"cacheTTL"`
+	Region              *nixRegion         `json:"region"`
+	OnErrorHint         *string            `json:"onErrorHint"`
+	BackupRetention     *int               `json:"backupRetention"`
+	Extract             *string            `json:"extract"`
+	OnlyIf              *nixOnlyIf         `json:"onlyIf"`
+	Transforms          *[]string          `json:"transforms"`
+	Item                *string            `json:"item"`
+	Fields              *map[string]string `json:"fields"`
+}
+
+type nixEnvFileEntry struct {
+	Key       string `json:"key"`
+	Reference string `json:"reference"`
+}
+
+type nixCopy struct {
+	Encoding   *string   `json:"encoding,omitempty"`
+	Group      *string   `json:"group,omitempty"`
+	Mode       *string   `json:"mode,omitempty"`
+	Owner      *string   `json:"owner,omitempty"`
+	Path       string    `json:"path"`
+	ReadableBy *[]string `json:"readableBy,omitempty"`
+}
+
+type nixOnlyIf struct {
+	Command *[]string `json:"command,omitempty"`
+	Exists  *string   `json:"exists,omitempty"`
+	Timeout *string   `json:"timeout,omitempty"`
+}
+
+type nixRegion struct {
+	Comment *string `json:"comment,omitempty"`
+	Name    *string `json:"name,omitempty"`
+}
+
+type nixINIEntry struct {
+	Key       string  `json:"key"`
+	Reference string  `json:"reference"`
+	Section   *string `json:"section,omitempty"`
+}
+
+type nixServiceOptions struct {
+	Restart *bool    `json:"restart"`
+	Signal  *string  `json:"signal"`
+	After   []string `json:"after"`
+}
+
+type nixSystemdOptions struct {
+	Enable          *bool    `json:"enable"`
+	Services        []string `json:"services"`
+	RestartOnChange *bool    `json:"restartOnChange"`
+	ChangeDetection struct {
+		Enable       *bool   `json:"enable"`
+		HashFile     *string `json:"hashFile"`
+		HashFileMode *string `json:"hashFileMode"`
+	} `json:"changeDetection"`
+	Systemctl          *string  `json:"systemctl"`
+	UnitName           *string  `json:"unitName"`
+	MaintenanceWindows []string `json:"maintenanceWindows"`
+	ParallelServices   *int     `json:"parallelServices"`
+	PendingFile        *string  `json:"pendingFile"`
+	ErrorHandling      struct {
+		RollbackOnFailure *bool `json:"rollbackOnFailure"`
+		ContinueOnError   *bool `json:"continueOnError"`
+		MaxRetries        *int  `json:"maxRetries"`
+	} `json:"errorHandling"`
+}
+
+// The fragment types list their fields in name order, as builtins.toJSON
+// sorts attribute names
+
+type nixFragment struct {
+	Accounts             *map[string]nixAccount `json:"accounts,omitempty"`
+	AccountTokenDir      *string                `json:"accountTokenDir,omitempty"`
+	BackupRetention      *int                   `json:"backupRetention,omitempty"`
+	BaseDir              *string                `json:"baseDir,omitempty"`
+	Defaults             map[string]string      `json:"defaults"`
+	Lock                 *nixLock               `json:"lock,omitempty"`
+	MetricsFile          *string                `json:"metricsFile,omitempty"`
+	ModePolicies         *[]nixModePolicy       `json:"modePolicies,omitempty"`
+	Network              *nixNetwork            `json:"network,omitempty"`
+	NetworkFilesystem    *string                `json:"networkFilesystem,omitempty"`
+	PathTemplate         *string                `json:"pathTemplate"`
+	PlaceholderCheck     *nixPlaceholderCheck   `json:"placeholderCheck,omitempty"`
+	RequireAbsolutePaths *bool                  `json:"requireAbsolutePaths,omitempty"`
+	RequireNonEmpty      *bool                  `json:"requireNonEmpty,omitempty"`
+	Resolve              *nixResolve            `json:"resolve,omitempty"`
+	Secrets              []nixSecretFragment    `json:"secrets"`
+	SystemdIntegration   nixSystemdFragment     `json:"systemdIntegration"`
+	UnicodeCheck         *string                `json:"unicodeCheck,omitempty"`
+	Webhook              *nixWebhook            `json:"webhook,omitempty"`
+}
+
+type nixSecretFragment struct {
+	Account             *string            `json:"account,omitempty"`
+	BackupRetention     *int               `json:"backupRetention,omitempty"`
+	Bundle              *[]string          `json:"bundle,omitempty"`
+	CacheTTL            *string            `json:"cacheTTL,omitempty"`
+	Canonical           *bool              `json:"canonical,omitempty"`
+	CertExpiryStrict    *bool              `json:"certExpiryStrict,omitempty"`
+	CertExpiryWarnDays  *int               `json:"certExpiryWarnDays,omitempty"`
+	Copies              *[]nixCopy         `json:"copies,omitempty"`
+	Credential          *string            `json:"credential,omitempty"`
+	CredentialEncrypted *bool              `json:"credentialEncrypted,omitempty"`
+	Description         *string            `json:"description,omitempty"`
+	EnvFile             *[]nixEnvFileEntry `json:"envFile,omitempty"`
+	Extract             *string            `json:"extract,omitempty"`
+	FieldFallbacks      *[]string          `json:"fieldFallbacks,omitempty"`
+	Fields              *map[string]string `json:"fields,omitempty"`
+	Filter              *[]string          `json:"filter,omitempty"`
+	FilterTimeout       *string            `json:"filterTimeout,omitempty"`
+	Group               string             `json:"group"`
+	INI                 *[]nixINIEntry     `json:"ini,omitempty"`
+	Item                *string            `json:"item,omitempty"`
+	Mode                string             `json:"mode"`
+	OnErrorHint         *string            `json:"onErrorHint,omitempty"`
+	OnlyIf              *nixOnlyIf         `json:"onlyIf,omitempty"`
+	Owner               string             `json:"owner"`
+	Path                *string            `json:"path,omitempty"`
+	Reference           *string            `json:"reference,omitempty"`
+	Region              *nixRegion         `json:"region,omitempty"`
+	RequireNonEmpty     *bool              `json:"requireNonEmpty,omitempty"`
+	Serial              *bool              `json:"serial,omitempty"`
+	Services            interface{}        `json:"services"`
+	SkipIfExists        *bool              `json:"skipIfExists,omitempty"`
+	SSHKeys             *[]string          `json:"sshKeys,omitempty"`
+	SSHKeysFormat       *string            `json:"sshKeysFormat,omitempty"`
+	SymlinkDirMode      *string            `json:"symlinkDirMode,omitempty"`
+	Symlinks            []string           `json:"symlinks"`
+	Tags                *[]string          `json:"tags,omitempty"`
+	Template            string             `json:"template"`
+	TemplateJSON        *bool              `json:"templateJSON,omitempty"`
+	Transaction         *string            `json:"transaction,omitempty"`
+	Transforms          *[]string          `json:"transforms,omitempty"`
+	Type                *string            `json:"type,omitempty"`
+	ValidateTimeout     *string            `json:"validateTimeout,omitempty"`
+	ValidateWith        *[]string          `json:"validateWith,omitempty"`
+	Variables           map[string]string  `json:"variables"`
+}
+
+type nixServiceFragment struct {
+	After   []string `json:"after"`
+	Restart bool     `json:"restart"`
+	Signal  *string  `json:"signal"`
+}
+
+type nixSystemdFragment struct {
+	ChangeDetection    nixChangeDetectionFragment `json:"changeDetection"`
+	Enable             bool                       `json:"enable"`
+	ErrorHandling      nixErrorHandlingFragment   `json:"errorHandling"`
+	MaintenanceWindows []string                   `json:"maintenanceWindows"`
+	ParallelServices   int                        `json:"parallelServices"`
+	PendingFile        string                     `json:"pendingFile"`
+	RestartOnChange    bool                       `json:"restartOnChange"`
+	Services           []string                   `json:"services"`
+	Systemctl          *string                    `json:"systemctl"`
+	UnitName           string                     `json:"unitName"`
+}
+
+type nixChangeDetectionFragment struct {
+	Enable       bool   `json:"enable"`
+	HashFile     string `json:"hashFile"`
+	HashFileMode string `json:"hashFileMode"`
+}
+
+type nixErrorHandlingFragment struct {
+	ContinueOnError   bool `json:"continueOnError"`
+	MaxRetries        int  `json:"maxRetries"`
+	RollbackOnFailure bool `json:"rollbackOnFailure"`
+}
+
+// NixFragment renders the config the NixOS module writes for the given
+// services.onepassword-secrets options, checking them as the module does
+func NixFragment(options []byte) ([]byte, error) {
+	var opts nixOptions
+	if err := decodeStrict(options, &opts); err != nil {
+		return nil, errors.ConfigError("Parsing NixOS module options", "Options do not match services.onepassword-secrets", err)
+	}
+
+	fragment := nixFragment{
+		Accounts:             opts.Accounts,
+		AccountTokenDir:      opts.AccountTokenDir,
+		BackupRetention:      opts.BackupRetention,
+		BaseDir:              opts.BaseDir,
+		Defaults:             nonNilMap(opts.Defaults),
+		Lock:                 opts.Lock,
+		MetricsFile:          opts.MetricsFile,
+		ModePolicies:         opts.ModePolicies,
+		Network:              opts.Network,
+		NetworkFilesystem:    opts.NetworkFilesystem,
+		PathTemplate:         opts.PathTemplate,
+		PlaceholderCheck:     opts.PlaceholderCheck,
+		RequireAbsolutePaths: opts.RequireAbsolutePaths,
+		RequireNonEmpty:      opts.RequireNonEmpty,
+		Resolve:              opts.Resolve,
+		Secrets:              []nixSecretFragment{},
+		UnicodeCheck:         opts.UnicodeCheck,
+		Webhook:              opts.Webhook,
+		SystemdIntegration: nixSystemdFragment{
+			ChangeDetection: nixChangeDetectionFragment{
+				Enable:       boolOr(opts.SystemdIntegration.ChangeDetection.Enable, true),
+				HashFile:     stringOr(opts.SystemdIntegration.ChangeDetection.HashFile, "/var/lib/opnix/secret-hashes.json"),
+				HashFileMode: stringOr(opts.SystemdIntegration.ChangeDetection.HashFileMode, "0600"),
+			},
+			Enable: boolOr(opts.SystemdIntegration.Enable, true),
+			ErrorHandling: nixErrorHandlingFragment{
+				ContinueOnError:   boolOr(opts.SystemdIntegration.ErrorHandling.ContinueOnError, true),
+				MaxRetries:        3,
+				RollbackOnFailure: boolOr(opts.SystemdIntegration.ErrorHandling.RollbackOnFailure, false),
+			},
+			MaintenanceWindows: nonNilSlice(opts.SystemdIntegration.MaintenanceWindows),
+			ParallelServices:   1,
+			PendingFile:        stringOr(opts.SystemdIntegration.PendingFile, "/var/lib/opnix/pending-services.json"),
+			RestartOnChange:    boolOr(opts.SystemdIntegration.RestartOnChange, true),
+			Services:           nonNilSlice(opts.SystemdIntegration.Services),
+			Systemctl:          opts.SystemdIntegration.Systemctl,
+			UnitName:           stringOr(opts.SystemdIntegration.UnitName, "opnix-secrets.service"),
+		},
+	}
+	if parallel := opts.SystemdIntegration.ParallelServices; parallel != nil {
+		if *parallel < 1 {
+			return nil, errors.ConfigValidationError(
+				"systemdIntegration.parallelServices",
+				strconv.Itoa(*parallel),
+				"Must be a positive integer",
+				[]string{"Use 1 to run service actions one at a time"},
+			)
+		}
+		fragment.SystemdIntegration.ParallelServices = *parallel
+	}
+	if err := nixEnum("networkFilesystem", opts.NetworkFilesystem, "warn", "refuse", "allow"); err != nil {
+		return nil, err
+	}
+	if err := nixEnum("unicodeCheck", opts.UnicodeCheck, "warn", "error", "normalize"); err != nil {
+		return nil, err
+	}
+	if lock := opts.Lock; lock != nil {
+		if err := nixEnum("lock.mode", lock.Mode, "fail", "wait", "queue"); err != nil {
+			return nil, err
+		}
+	}
+	if check := opts.PlaceholderCheck; check != nil {
+		if err := nixEnum("placeholderCheck.mode", check.Mode, "off", "warn", "strict"); err != nil {
+			return nil, err
+		}
+		if check.MinLength != nil && *check.MinLength < 0 {
+			return nil, errors.ConfigValidationError("placeholderCheck.minLength", strconv.Itoa(*check.MinLength), "Must not be negative", nil)
+		}
+	}
+	if resolve := opts.Resolve; resolve != nil {
+		if resolve.MaxRetries != nil && *resolve.MaxRetries < 0 {
+			return nil, errors.ConfigValidationError("resolve.maxRetries", strconv.Itoa(*resolve.MaxRetries), "Must not be negative", nil)
+		}
+		if resolve.Parallel != nil && *resolve.Parallel < 1 {
+			return nil, errors.ConfigValidationError("resolve.parallel", strconv.Itoa(*resolve.Parallel), "Must be a positive integer", nil)
+		}
+	}
+	if maxRetries := opts.SystemdIntegration.ErrorHandling.MaxRetries; maxRetries != nil {
+		fragment.SystemdIntegration.ErrorHandling.MaxRetries = *maxRetries
+	}
+
+	names := make([]string, 0, len(opts.Secrets))
+	for name := range opts.Secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var invalid []string
+	for _, name := range names {
+		if !nixSecretKey.MatchString(name) {
+			invalid = append(invalid, name)
+		}
+	}
+	if len(invalid) > 0 {
+		return nil, errors.ConfigValidationError(
+			"secrets",
+			strings.Join(invalid, ", "),
+			"Invalid secret key names",
+			[]string{"Use camelCase names like databasePassword, not path-like strings such as database/password"},
+		)
+	}
+
+	for _, name := range names {
+		secret, err := nixSecret(name, opts.Secrets[name])
+		if err != nil {
+			return nil, err
+		}
+		fragment.Secrets = append(fragment.Secrets, secret)
+	}
+
+	return marshalNix(fragment)
+}
+
+// nixSecret renders one declarative secret
+func nixSecret(name string, opts nixSecretOptions) (nixSecretFragment, error) {
+	field := fmt.Sprintf("secrets.%s", name)
+	if opts.Reference == nil && opts.EnvFile == nil && opts.Bundle == nil && opts.INI == nil && opts.SSHKeys == nil && opts.Item == nil {
+		return nixSecretFragment{}, errors.ConfigValidationError(field+".reference", "", "One of reference, envFile, bundle, ini, sshKeys or item must be set", []string{
+			"Set reference to a 1Password reference, e.g. op://Vault/Item/field",
+		})
+	}
+
+	secret := nixSecretFragment{
+		Account:             opts.Account,
+		BackupRetention:     opts.BackupRetention,
+		Bundle:              opts.Bundle,
+		CacheTTL:            opts.CacheTTL,
+		Canonical:           opts.Canonical,
+		CertExpiryStrict:    opts.CertExpiryStrict,
+		CertExpiryWarnDays:  opts.CertExpiryWarnDays,
+		Copies:              opts.Copies,
+		Credential:          opts.Credential,
+		CredentialEncrypted: opts.CredentialEncrypted,
+		Description:         opts.Description,
+		EnvFile:             opts.EnvFile,
+		Extract:             opts.Extract,
+		FieldFallbacks:      opts.FieldFallbacks,
+		Fields:              opts.Fields,
+		Filter:              opts.Filter,
+		FilterTimeout:       opts.FilterTimeout,
+		Group:               stringOr(opts.Group, "root"),
+		INI:                 opts.INI,
+		Item:                opts.Item,
+		Mode:                stringOr(opts.Mode, "0600"),
+		OnErrorHint:         opts.OnErrorHint,
+		OnlyIf:              opts.OnlyIf,
+		Owner:               stringOr(opts.Owner, "root"),
+		Path:                nixSecretPath(name, opts),
+		Reference:           opts.Reference,
+		Region:              opts.Region,
+		RequireNonEmpty:     opts.RequireNonEmpty,
+		Serial:              opts.Serial,
+		Services:            []string{},
+		SkipIfExists:        opts.SkipIfExists,
+		SSHKeys:             opts.SSHKeys,
+		SSHKeysFormat:       opts.SSHKeysFormat,
+		SymlinkDirMode:      opts.SymlinkDirMode,
+		Symlinks:            nonNilSlice(opts.Symlinks),
+		Tags:                opts.Tags,
+		Template:            stringOr(opts.Template, ""),
+		TemplateJSON:        opts.TemplateJSON,
+		Transaction:         opts.Transaction,
+		Transforms:          opts.Transforms,
+		Type:                opts.Type,
+		ValidateTimeout:     opts.ValidateTimeout,
+		ValidateWith:        opts.ValidateWith,
+		Variables:           nonNilMap(opts.Variables),
+	}
+	if err := nixEnum(field+".sshKeysFormat", opts.SSHKeysFormat, "authorized_keys", "known_hosts"); err != nil {
+		return nixSecretFragment{}, err
+	}
+	if err := nixEnum(field+".type", opts.Type, "password", "concealed", "text", "file", "otp", "sshKey"); err != nil {
+		return nixSecretFragment{}, err
+	}
+	if opts.CertExpiryWarnDays != nil && *opts.CertExpiryWarnDays < 0 {
+		return nixSecretFragment{}, errors.ConfigValidationError(field+".certExpiryWarnDays", strconv.Itoa(*opts.CertExpiryWarnDays), "Must not be negative", nil)
+	}
+	if opts.Copies != nil {
+		for i, entry := range *opts.Copies {
+			if err := nixEnum(fmt.Sprintf("%s.copies[%d].encoding", field, i), entry.Encoding, "raw", "base64", "hex"); err != nil {
+				return nixSecretFragment{}, err
+			}
+		}
+	}
+	if !nixMode.MatchString(secret.Mode) {
+		return nixSecretFragment{}, errors.ConfigValidationError(field+".mode", secret.Mode, "Mode is not a valid octal permission", []string{
+			"Use three or four octal digits, e.g. 0600 or 0644",
+		})
+	}
+
+	services := bytes.TrimSpace(opts.Services)
+	switch {
+	case len(services) == 0 || bytes.Equal(services, []byte("null")):
+	case services[0] == '[':
+		var list []string
+		if err := decodeStrict(services, &list); err != nil {
+			return nixSecretFragment{}, errors.ConfigError("Parsing NixOS module options", fmt.Sprintf("%s.services must be a list of service names or an attribute set", field), err)
+		}
+		secret.Services = nonNilSlice(list)
+	default:
+		var byName map[string]nixServiceOptions
+		if err := decodeStrict(services, &byName); err != nil {
+			return nixSecretFragment{}, errors.ConfigError("Parsing NixOS module options", fmt.Sprintf("%s.services must be a list of service names or an attribute set", field), err)
+		}
+		rendered := make(map[string]nixServiceFragment, len(byName))
+		for service, options := range byName {
+			after := options.After
+			if after == nil {
+				after = []string{"opnix-secrets.service"}
+			}
+			rendered[service] = nixServiceFragment{
+				After:   after,
+				Restart: boolOr(options.Restart, true),
+				Signal:  options.Signal,
+			}
+		}
+		secret.Services = rendered
+	}
+	return secret, nil
+}
+
+// nixSecretPath is the path the module writes for a secret: credentials
+// without a path are left for opnix to place in the credential store, and
+// item secrets give each field its own path
+func nixSecretPath(name string, opts nixSecretOptions) *string {
+	if opts.Path != nil || (opts.Credential == nil && opts.Item == nil) {
+		path := stringOr(opts.Path, name)
+		return &path
+	}
+	return nil
+}
+
+// CheckNixFragment checks that fragment has the shape the NixOS module
+// writes, with every field one the runtime config knows. With options, it
+// must also equal what the module writes for them.
+func CheckNixFragment(fragment, options []byte) error {
+	var parsed nixFragment
+	if err := decodeStrict(fragment, &parsed); err != nil {
+		return errors.ConfigError("Checking NixOS config fragment", "The fragment does not have the shape the NixOS module writes", err)
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(fragment))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return errors.ConfigError("Checking NixOS config fragment", "Invalid JSON format in fragment", err)
+	}
+	if issues := schemaIssues(document, reflect.TypeOf(Config{}), "", true); len(issues) > 0 {
+		return &errors.OpnixError{
+			Operation: "Checking NixOS config fragment",
+			Component: "configuration",
+			Issue:     "The fragment has fields the opnix config does not accept",
+			Context:   strings.Join(issues, "\n    "),
+			Suggestions: []string{
+				"The NixOS module and this opnix build disagree; update one to match the other",
+			},
+		}
+	}
+
+	if options == nil {
+		return nil
+	}
+	expected, err := NixFragment(options)
+	if err != nil {
+		return err
+	}
+	var want, got interface{}
+	_ = json.Unmarshal(expected, &want)
+	_ = json.Unmarshal(fragment, &got)
+	if differences := jsonDifferences(want, got, ""); len(differences) > 0 {
+		return &errors.OpnixError{
+			Operation: "Checking NixOS config fragment",
+			Component: "configuration",
+			Issue:     fmt.Sprintf("%d fields differ from what the NixOS module writes for these options", len(differences)),
+			Context:   strings.Join(differences, "\n    "),
+			Suggestions: []string{
+				"Regenerate the fragment: opnix nix-config -options options.json",
+			},
+		}
+	}
+	return nil
+}
+
+// jsonDifferences lists where got differs from want, by field
+func jsonDifferences(want, got interface{}, field string) []string {
+	wantObject, wantIsObject := want.(map[string]interface{})
+	gotObject, gotIsObject := got.(map[string]interface{})
+	if wantIsObject && gotIsObject {
+		keys := make(map[string]interface{})
+		for key := range wantObject {
+			keys[key] = nil
+		}
+		for key := range gotObject {
+			keys[key] = nil
+		}
+		var differences []string
+		for _, key := range sortedKeys(keys) {
+			wantValue, inWant := wantObject[key]
+			gotValue, inGot := gotObject[key]
+			switch {
+			case !inGot:
+				differences = append(differences, fmt.Sprintf("%s: missing", joinField(field, key)))
+			case !inWant:
+				differences = append(differences, fmt.Sprintf("%s: not written by the module", joinField(field, key)))
+			default:
+				differences = append(differences, jsonDifferences(wantValue, gotValue, joinField(field, key))...)
+			}
+		}
+		return differences
+	}
+
+	wantList, wantIsList := want.([]interface{})
+	gotList, gotIsList := got.([]interface{})
+	if wantIsList && gotIsList && len(wantList) == len(gotList) {
+		var differences []string
+		for i := range wantList {
+			differences = append(differences, jsonDifferences(wantList[i], gotList[i], fmt.Sprintf("%s[%d]", field, i))...)
+		}
+		return differences
+	}
+
+	if reflect.DeepEqual(want, got) {
+		return nil
+	}
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if field == "" {
+		field = "(fragment)"
+	}
+	return []string{fmt.Sprintf("%s: expected %s, got %s", field, wantJSON, gotJSON)}
+}
+
+// decodeStrict decodes a single JSON value, rejecting unknown fields as the
+// module system rejects undeclared options
+func decodeStrict(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
+// marshalNix encodes v compactly and without HTML escaping, as
+// builtins.toJSON does
+func marshalNix(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		return nil, errors.ConfigError("Rendering NixOS config fragment", "Failed to encode the fragment", err)
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// nixEnum checks an enum option the module declares as nullable
+func nixEnum(field string, value *string, allowed ...string) error {
+	if value == nil {
+		return nil
+	}
+	for _, option := range allowed {
+		if *value == option {
+			return nil
+		}
+	}
+	return errors.ConfigValidationError(field, *value, "Not one of the values the option accepts", []string{
+		fmt.Sprintf("Use one of: %s", strings.Join(allowed, ", ")),
+	})
+}
+
+func stringOr(value *string, fallback string) string {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+func boolOr(value *bool, fallback bool) bool {
+	if value == nil {
+		return fallback
+	}
+	return *value
+}
+
+func nonNilSlice(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func nonNilMap(values map[string]string) map[string]string {
+	if values == nil {
+		return map[string]string{}
+	}
+	return values
+}
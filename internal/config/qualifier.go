@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+// applyVaultQualifiers routes secrets whose references name an account
+// (op://Vault@account/...) to that account, as if account were set on the
+// secret. The qualifier is an account name from accounts, or the sign-in
+// address or shorthand of the 1Password account one account's token belongs
+// to. Bare vault names used with more than one account are reported, since
+// the default token may see a different vault of the same name.
+func (c *Config) applyVaultQualifiers(collector *warnings.Collector) error {
+	for key, reference := range c.Env {
+		if ref, err := onepass.ParseReference(reference); err == nil && ref.Account != "" {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("env.%s", key),
+				reference,
+				"Environment references are resolved with the default token and cannot name an account",
+				[]string{fmt.Sprintf("Qualify the vault by ID instead: op://%s@<vault-id>/...", ref.Vault)},
+			)
+		}
+	}
+
+	var identities map[string]onepass.Identity
+	for i := range c.Secrets {
+		secret := &c.Secrets[i]
+		name := fmt.Sprintf("secret[%d]", i)
+		for _, expanded := range secret.ExpandFields() {
+			for _, reference := range expanded.References() {
+				ref, err := onepass.ParseReference(reference)
+				if err != nil || ref.Account == "" {
+					continue
+				}
+				if _, named := c.Accounts[ref.Account]; !named {
+					if identities == nil {
+						identities = c.accountIdentities()
+					}
+					if ref.Account, err = matchAccountIdentity(identities, ref.Account, name, reference); err != nil {
+						return err
+					}
+				}
+				if secret.Account != "" && secret.Account != ref.Account {
+					return errors.ConfigValidationError(
+						fmt.Sprintf("%s.reference", name),
+						reference,
+						fmt.Sprintf("Reference names account '%s' but the secret resolves with account '%s'", ref.Account, secret.Account),
+						[]string{
+							"All references of a secret resolve with one account",
+							"Remove the qualifier, or split the secret in two",
+						},
+					)
+				}
+				secret.Account = ref.Account
+			}
+		}
+	}
+
+	if len(c.Accounts) > 0 {
+		c.warnAmbiguousVaults(collector)
+	}
+	return nil
+}
+
+// accountIdentities returns the 1Password account of each named account's
+// token. Tokens that can't be read or decoded are left out; they fail with
+// a clearer error when the account is used.
+func (c *Config) accountIdentities() map[string]onepass.Identity {
+	identities := make(map[string]onepass.Identity, len(c.Accounts))
+	for name, account := range c.Accounts {
+		if identity, err := onepass.TokenFileIdentity(account.TokenFile); err == nil {
+			identities[name] = identity
+		}
+	}
+	return identities
+}
+
+// matchAccountIdentity returns the named account whose token belongs to the
+// 1Password account qualifier names, or qualifier unchanged when none does
+func matchAccountIdentity(identities map[string]onepass.Identity, qualifier, secretName, reference string) (string, error) {
+	var matches []string
+	for name, identity := range identities {
+		if identity.Matches(qualifier) {
+			matches = append(matches, name)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return qualifier, nil
+	case 1:
+		return matches[0], nil
+	}
+	sort.Strings(matches)
+	return "", errors.ConfigValidationError(
+		fmt.Sprintf("%s.reference", secretName),
+		reference,
+		fmt.Sprintf("Accounts %s all have tokens for %s", strings.Join(matches, ", "), qualifier),
+		[]string{"Qualify the vault with the account name instead, e.g. op://Vault@" + matches[0] + "/..."},
+	)
+}
+
+// warnAmbiguousVaults reports vault names referenced bare with the default
+// token while other secrets use the same name with a named account
+func (c *Config) warnAmbiguousVaults(collector *warnings.Collector) {
+	accounts := make(map[string]map[string]bool)
+	bare := make(map[string][]string)
+	for i, configured := range c.Secrets {
+		for _, secret := range configured.ExpandFields() {
+			for _, reference := range secret.References() {
+				ref, err := onepass.ParseReference(reference)
+				if err != nil || ref.VaultIsID || ref.VaultID != "" {
+					continue
+				}
+				if accounts[ref.Vault] == nil {
+					accounts[ref.Vault] = make(map[string]bool)
+				}
+				accounts[ref.Vault][secret.Account] = true
+				if secret.Account == "" {
+					bare[ref.Vault] = append(bare[ref.Vault], fmt.Sprintf("secret[%d]", i))
+				}
+			}
+		}
+	}
+
+	vaults := make([]string, 0, len(bare))
+	for vault := range bare {
+		vaults = append(vaults, vault)
+	}
+	sort.Strings(vaults)
+	for _, vault := range vaults {
+		var others []string
+		for account := range accounts[vault] {
+			if account != "" {
+				others = append(others, account)
+			}
+		}
+		if len(others) == 0 {
+			continue
+		}
+		sort.Strings(others)
+		collector.Addf("configuration",
+			"Vault '%s' is used with account %s and bare with the default token by %s; qualify it as op://%s@<account>/... or op://%s@<vault-id>/... to pick one",
+			vault, strings.Join(others, ", "), strings.Join(dedupe(bare[vault]), ", "), vault, vault)
+	}
+}
+
+// dedupe drops repeated names, keeping the first of each
+func dedupe(names []string) []string {
+	seen := make(map[string]bool, len(names))
+	var unique []string
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			unique = append(unique, name)
+		}
+	}
+	return unique
+}
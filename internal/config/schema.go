@@ -0,0 +1,295 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// CheckSchema checks the raw JSON config at path, and every file it
+// includes, against the fields Config declares. encoding/json ignores
+// unknown keys and Load then never sees a misspelt setting; this reports
+// them, and values of the wrong type, by field before anything is loaded.
+// key selects the config inside a larger document, as for LoadKey.
+func CheckSchema(path, key string) error {
+	return checkSchemaFile(path, key, make(map[string]bool))
+}
+
+// checkSchemaFile checks one file and its includes; seen stops include cycles,
+// which loading reports
+func checkSchemaFile(path, key string, seen map[string]bool) error {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return errors.FileOperationError("Resolving configuration file path", path, "Failed to resolve absolute path", err)
+	}
+	if seen[absPath] {
+		return nil
+	}
+	seen[absPath] = true
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.FileOperationError("Loading configuration file", path, "Failed to read config file", err)
+	}
+	if key != "" {
+		if data, err = extractKey(data, key, path); err != nil {
+			return err
+		}
+	}
+
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return errors.ConfigError("Parsing configuration file", "Invalid JSON format in config file", err)
+	}
+
+	if issues := schemaIssues(document, reflect.TypeOf(Config{}), "", true); len(issues) > 0 {
+		return &errors.OpnixError{
+			Operation: "Checking configuration schema",
+			Component: "configuration",
+			Issue:     fmt.Sprintf("%d fields in %s do not match the config schema", len(issues), path),
+			Context:   strings.Join(issues, "\n    "),
+			Suggestions: []string{
+				"Without -strict-schema unknown fields are ignored, so the setting does nothing",
+				"Check the names and types against docs/configuration-reference.md",
+			},
+		}
+	}
+
+	// Includes are validated as plain config files, as they are loaded
+	object, _ := document.(map[string]interface{})
+	includes, _ := object["include"].([]interface{})
+	for _, include := range includes {
+		pattern, _ := include.(string)
+		includePaths, err := expandInclude(filepath.Dir(absPath), pattern, path)
+		if err != nil {
+			return err
+		}
+		for _, includePath := range includePaths {
+			if err := checkSchemaFile(includePath, "", seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ignoredFields lists the keys of a config document encoding/json drops
+// without a word, for a warning while loading
+func ignoredFields(data []byte) []string {
+	var document interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&document); err != nil {
+		return nil
+	}
+	return schemaIssues(document, reflect.TypeOf(Config{}), "", false)
+}
+
+// schemaIssues describes where value, found at field, does not fit t. null
+// fits everything, as encoding/json leaves the field unset. Unless strict,
+// only unknown keys are reported, and not those encoding/json matches
+// case-insensitively.
+func schemaIssues(value interface{}, t reflect.Type, field string, strict bool) []string {
+	if value == nil {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaIssues(value, t.Elem(), field, strict)
+	case reflect.Interface:
+		return nil
+	case reflect.Struct:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return typeIssues(strict, field, "an object", value)
+		}
+		fields := schemaFields(t)
+		var issues []string
+		for _, name := range sortedKeys(object) {
+			fieldType, known := fields[name]
+			if !known {
+				if folded, ok := foldedField(name, fields); ok && !strict {
+					// encoding/json fills the field anyway
+					issues = append(issues, schemaIssues(object[name], fields[folded], joinField(field, name), strict)...)
+					continue
+				}
+				issues = append(issues, unknownFieldIssue(joinField(field, name), name, fields))
+				continue
+			}
+			issues = append(issues, schemaIssues(object[name], fieldType, joinField(field, name), strict)...)
+		}
+		return issues
+	case reflect.Map:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return typeIssues(strict, field, "an object", value)
+		}
+		var issues []string
+		for _, name := range sortedKeys(object) {
+			issues = append(issues, schemaIssues(object[name], t.Elem(), joinField(field, name), strict)...)
+		}
+		return issues
+	case reflect.Slice, reflect.Array:
+		array, ok := value.([]interface{})
+		if !ok {
+			return typeIssues(strict, field, "a list", value)
+		}
+		var issues []string
+		for i, element := range array {
+			issues = append(issues, schemaIssues(element, t.Elem(), fmt.Sprintf("%s[%d]", field, i), strict)...)
+		}
+		return issues
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return typeIssues(strict, field, "a string", value)
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return typeIssues(strict, field, "true or false", value)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, ok := value.(json.Number)
+		if _, err := number.Int64(); !ok || err != nil {
+			return typeIssues(strict, field, "a whole number", value)
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(json.Number)
+		if n, err := number.Int64(); !ok || err != nil || n < 0 {
+			return typeIssues(strict, field, "a whole number, 0 or more", value)
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := value.(json.Number); !ok {
+			return typeIssues(strict, field, "a number", value)
+		}
+	}
+	return nil
+}
+
+// typeIssues reports a value of the wrong type when strict; otherwise
+// encoding/json has already rejected it
+func typeIssues(strict bool, field, expected string, value interface{}) []string {
+	if !strict {
+		return nil
+	}
+	return []string{typeIssue(field, expected, value)}
+}
+
+// foldedField finds the field encoding/json matches name to case-insensitively
+func foldedField(name string, fields map[string]reflect.Type) (string, bool) {
+	for known := range fields {
+		if strings.EqualFold(known, name) {
+			return known, true
+		}
+	}
+	return "", false
+}
+
+// schemaFields maps the JSON names of t's fields to their types
+func schemaFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		switch {
+		case name == "-", field.PkgPath != "" && !field.Anonymous:
+			continue
+		case field.Anonymous && name == "":
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			for embeddedName, embeddedType := range schemaFields(embedded) {
+				fields[embeddedName] = embeddedType
+			}
+			continue
+		case name == "":
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// unknownFieldIssue reports an unknown key, suggesting the closest known name
+func unknownFieldIssue(field, name string, fields map[string]reflect.Type) string {
+	if known, ok := foldedField(name, fields); ok {
+		return fmt.Sprintf("%s: unknown field, names are case-sensitive; did you mean %q?", field, known)
+	}
+	best, bestDistance := "", 3
+	for known := range fields {
+		if distance := editDistance(strings.ToLower(known), strings.ToLower(name)); distance < bestDistance || (distance == bestDistance && known < best) {
+			best, bestDistance = known, distance
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("%s: unknown field; did you mean %q?", field, best)
+	}
+	return fmt.Sprintf("%s: unknown field", field)
+}
+
+// typeIssue reports a value of the wrong JSON type
+func typeIssue(field, expected string, value interface{}) string {
+	var got string
+	switch value.(type) {
+	case map[string]interface{}:
+		got = "an object"
+	case []interface{}:
+		got = "a list"
+	case string:
+		got = "a string"
+	case bool:
+		got = "a boolean"
+	default:
+		got = fmt.Sprintf("the number %v", value)
+	}
+	return fmt.Sprintf("%s: expected %s, got %s", field, expected, got)
+}
+
+// joinField appends name to a dotted field path
+func joinField(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// sortedKeys returns the keys of object in order, for stable reports
+func sortedKeys(object map[string]interface{}) []string {
+	keys := make([]string, 0, len(object))
+	for key := range object {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current := make([]int, len(b)+1)
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous = current
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/validation"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+// tokenFileSuffix is stripped from token file names to get the account name
+const tokenFileSuffix = ".token"
+
+// registerAccountTokenDir adds an account for every token file in
+// AccountTokenDir, named after the file (homelab.token -> homelab). Accounts
+// defined explicitly keep their token file. Empty or unreadable files are
+// reported and skipped so one bad token doesn't hide the others.
+func (c *Config) registerAccountTokenDir(collector *warnings.Collector) error {
+	if c.AccountTokenDir == "" {
+		return nil
+	}
+
+	tokenFiles, problems, err := ScanTokenDir(c.AccountTokenDir)
+	if err != nil {
+		return err
+	}
+	for _, problem := range problems {
+		collector.Addf("configuration", "Skipping account token: %v", problem)
+	}
+
+	for name, tokenFile := range tokenFiles {
+		if _, exists := c.Accounts[name]; exists {
+			continue
+		}
+		if c.Accounts == nil {
+			c.Accounts = make(map[string]Account)
+		}
+		c.Accounts[name] = Account{TokenFile: tokenFile}
+	}
+
+	return nil
+}
+
+// ScanTokenDir returns an account name -> token file map for the regular
+// files in dir, plus an error for each file that failed validation. Hidden
+// files are ignored.
+func ScanTokenDir(dir string) (map[string]string, []error, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, errors.FileOperationError(
+			"Reading account token directory",
+			dir,
+			"Failed to list token files",
+			err,
+		)
+	}
+
+	validator := validation.NewValidator()
+	tokenFiles := make(map[string]string)
+	var problems []error
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if err := validator.ValidateTokenFile(path); err != nil {
+			problems = append(problems, err)
+			continue
+		}
+
+		name := strings.TrimSuffix(entry.Name(), tokenFileSuffix)
+		if existing, exists := tokenFiles[name]; exists {
+			problems = append(problems, errors.ConfigError(
+				"Reading account token directory",
+				fmt.Sprintf("Token files %s and %s both name account '%s'", existing, path, name),
+				nil,
+			))
+			continue
+		}
+		tokenFiles[name] = path
+	}
+
+	return tokenFiles, problems, nil
+}
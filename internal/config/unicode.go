@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/brizzbuzz/opnix/internal/validation"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+// normalizeUnicode replaces non-ASCII spaces and drops invisible characters
+// in references and paths when unicodeCheck is "normalize", reporting each
+// value it changed. Other suspicious characters are left for validation.
+func (c *Config) normalizeUnicode(collector *warnings.Collector) {
+	if c.UnicodeCheck != validation.UnicodeCheckNormalize {
+		return
+	}
+	normalize := func(value *string, field string) {
+		if normalized := validation.NormalizeUnicode(*value); normalized != *value {
+			collector.Addf("configuration", "%s: normalized %q to %q", field, *value, normalized)
+			*value = normalized
+		}
+	}
+
+	for i := range c.Secrets {
+		secret := &c.Secrets[i]
+		name := fmt.Sprintf("secret[%d]", i)
+		normalize(&secret.Reference, name+".reference")
+		normalize(&secret.Item, name+".item")
+		normalize(&secret.Path, name+".path")
+		for j := range secret.Bundle {
+			normalize(&secret.Bundle[j], fmt.Sprintf("%s.bundle[%d]", name, j))
+		}
+		for j := range secret.SSHKeys {
+			normalize(&secret.SSHKeys[j], fmt.Sprintf("%s.sshKeys[%d]", name, j))
+		}
+		for j := range secret.INI {
+			normalize(&secret.INI[j].Reference, fmt.Sprintf("%s.ini[%d].reference", name, j))
+		}
+		for j := range secret.EnvFile {
+			normalize(&secret.EnvFile[j].Reference, fmt.Sprintf("%s.envFile[%d].reference", name, j))
+		}
+		for j := range secret.Symlinks {
+			normalize(&secret.Symlinks[j], fmt.Sprintf("%s.symlinks[%d]", name, j))
+		}
+		for j := range secret.Copies {
+			normalize(&secret.Copies[j].Path, fmt.Sprintf("%s.copies[%d].path", name, j))
+		}
+	}
+
+	for key, reference := range c.Env {
+		normalize(&reference, "env."+key)
+		c.Env[key] = reference
+	}
+}
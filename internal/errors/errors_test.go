@@ -245,6 +245,27 @@ func TestOnePasswordError(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("Proxy failures", func(t *testing.T) {
+		err := OnePasswordError("Resolving 1Password secret", "Failed to resolve reference: op://Vault/Item/field",
+			fmt.Errorf("proxyconnect tcp: dial tcp 10.0.0.1:3128: connect: connection refused"))
+		if len(err.Suggestions) == 0 || !strings.Contains(err.Suggestions[0], "network.proxy") {
+			t.Errorf("Expected proxy suggestions, got %v", err.Suggestions)
+		}
+
+		err = OnePasswordError("Resolving 1Password secret", "Failed to resolve reference: op://Vault/Item/field",
+			fmt.Errorf("tls: failed to verify certificate: x509: certificate signed by unknown authority"))
+		if len(err.Suggestions) == 0 || !strings.Contains(err.Suggestions[0], "network.caBundle") {
+			t.Errorf("Expected CA bundle suggestions, got %v", err.Suggestions)
+		}
+	})
+
+	t.Run("Nil cause", func(t *testing.T) {
+		err := OnePasswordError("Resolving secret", "no value returned", nil)
+		if err.Cause != nil {
+			t.Errorf("Expected nil cause, got %v", err.Cause)
+		}
+	})
 }
 
 func TestUserGroupError(t *testing.T) {
@@ -275,6 +296,29 @@ func TestUserGroupError(t *testing.T) {
 	}
 }
 
+func TestTemplateError(t *testing.T) {
+	cause := fmt.Errorf("error calling call: non-function %%!d(string=hunter2) of type string")
+	err := TemplateError("Executing template", "{{ .Secret }}", cause, "hunter2")
+
+	if err.Component != "template" {
+		t.Errorf("Expected component 'template', got %q", err.Component)
+	}
+
+	if strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected secret value to be redacted, got: %s", err.Error())
+	}
+
+	if !strings.Contains(err.Error(), "<redacted>") {
+		t.Errorf("Expected redacted marker in error, got: %s", err.Error())
+	}
+
+	// Causes without sensitive content are kept as-is
+	plain := fmt.Errorf("unexpected EOF")
+	if got := TemplateError("Parsing template", "{{", plain, "hunter2"); got.Cause != plain {
+		t.Errorf("Expected cause to be preserved when nothing was redacted")
+	}
+}
+
 func TestValidationError(t *testing.T) {
 	err := ValidationError("Field validation", "mode", "777", "3-4 digit octal")
 
@@ -321,6 +365,31 @@ func TestTokenError(t *testing.T) {
 	}
 }
 
+func TestUnauthorizedError(t *testing.T) {
+	err := UnauthorizedError("Resolving 1Password secret", fmt.Errorf("invalid bearer token"))
+
+	if err.Component != "authentication" {
+		t.Errorf("Expected component 'authentication', got %q", err.Component)
+	}
+	if !IsUnauthorized(err) {
+		t.Error("Expected IsUnauthorized to match the error")
+	}
+	if !strings.Contains(err.Error(), "invalid bearer token") || !strings.Contains(err.Error(), "opnix token rotate") {
+		t.Errorf("Expected the cause and re-issue advice in the message, got:\n%s", err.Error())
+	}
+
+	wrapped := fmt.Errorf("failed to initialize secret: %w", OnePasswordError("Resolving secret", "Failed to resolve", err))
+	if found, ok := AsUnauthorized(wrapped); !ok || found != err {
+		t.Errorf("Expected AsUnauthorized to find the error through wrapping, got %v", found)
+	}
+	if _, ok := AsUnauthorized(OnePasswordError("Resolving secret", "not found", fmt.Errorf("no item matched"))); ok {
+		t.Error("Expected AsUnauthorized to ignore other failures")
+	}
+	if IsUnauthorized(nil) {
+		t.Error("Expected IsUnauthorized(nil) to be false")
+	}
+}
+
 func TestWrap(t *testing.T) {
 	originalErr := fmt.Errorf("original error")
 	wrappedErr := Wrap(originalErr, "Test operation", "test component")
@@ -0,0 +1,115 @@
+// Package local resolves secrets from an encrypted file on disk, for running
+// a config while 1Password is unreachable
+package local
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Scheme prefixes references that only exist in the local file
+const Scheme = "local://"
+
+// Client serves references from a decrypted secrets file. The file holds a
+// JSON object mapping names to values; local://name reads the name, and any
+// other reference is looked up verbatim, so op:// references of a config
+// resolve offline when the file has a copy of them.
+type Client struct {
+	file   string
+	values map[string]string
+}
+
+// NewClient decrypts file with the age or gpg command, chosen by its
+// extension. An age file needs identity, the age identity file; for gpg,
+// identity is an optional passphrase file, and the keyring is used without
+// one.
+func NewClient(file, identity string) (*Client, error) {
+	data, err := decrypt(file, identity)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		for i := range data {
+			data[i] = 0
+		}
+	}()
+
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		// The decoder's message can quote the plaintext, so leave it out
+		return nil, errors.FileOperationError(
+			"Loading local secrets file",
+			file,
+			"Decrypted content is not a JSON object of string values",
+			nil,
+		)
+	}
+	return &Client{file: file, values: values}, nil
+}
+
+// decryptCommand returns the command that prints file's plaintext
+func decryptCommand(file, identity string) (*exec.Cmd, error) {
+	switch filepath.Ext(file) {
+	case ".age":
+		if identity == "" {
+			return nil, errors.ConfigError(
+				"Loading local secrets file",
+				"An age file needs an identity file to decrypt it (-local-identity)",
+				nil,
+			)
+		}
+		return exec.Command("age", "--decrypt", "--identity", identity, file), nil
+	case ".gpg", ".asc":
+		args := []string{"--batch", "--quiet", "--decrypt"}
+		if identity != "" {
+			args = append(args, "--pinentry-mode", "loopback", "--passphrase-file", identity)
+		}
+		return exec.Command("gpg", append(args, file)...), nil
+	default:
+		return nil, errors.ValidationError("Loading local secrets file", "local-file", file, "a file ending in .age, .gpg or .asc")
+	}
+}
+
+func decrypt(file, identity string) ([]byte, error) {
+	cmd, err := decryptCommand(file, identity)
+	if err != nil {
+		return nil, err
+	}
+	data, err := cmd.Output()
+	if err != nil {
+		issue := fmt.Sprintf("%s could not decrypt the file", cmd.Args[0])
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			issue = fmt.Sprintf("%s: %s", issue, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, errors.FileOperationError("Decrypting local secrets file", file, issue, err)
+	}
+	return data, nil
+}
+
+// ResolveSecret returns the value stored for reference
+func (c *Client) ResolveSecret(reference string) (string, error) {
+	key := strings.TrimPrefix(reference, Scheme)
+	if value, ok := c.values[key]; ok {
+		return value, nil
+	}
+	return "", &errors.OpnixError{
+		Operation: fmt.Sprintf("Resolving %s", reference),
+		Component: "local backend",
+		Issue:     "Reference is not in the local secrets file",
+		Context:   fmt.Sprintf("File: %s", c.file),
+		Suggestions: []string{
+			fmt.Sprintf("Add %q to the file's JSON object", key),
+			"Run with the default 1Password backend once it is reachable again",
+		},
+	}
+}
+
+// IsReference reports whether reference names a secret of the local file
+func IsReference(reference string) bool {
+	return strings.HasPrefix(reference, Scheme)
+}
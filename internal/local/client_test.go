@@ -0,0 +1,101 @@
+package local
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDecryptCommand(t *testing.T) {
+	cmd, err := decryptCommand("secrets.age", "key.txt")
+	if err != nil || strings.Join(cmd.Args, " ") != "age --decrypt --identity key.txt secrets.age" {
+		t.Errorf("Unexpected age command %v, %v", cmd, err)
+	}
+	if _, err := decryptCommand("secrets.age", ""); err == nil {
+		t.Error("Expected an age file without identity to be rejected")
+	}
+
+	cmd, err = decryptCommand("secrets.gpg", "")
+	if err != nil || strings.Join(cmd.Args, " ") != "gpg --batch --quiet --decrypt secrets.gpg" {
+		t.Errorf("Unexpected gpg command %v, %v", cmd, err)
+	}
+	cmd, err = decryptCommand("secrets.asc", "pass.txt")
+	if err != nil || !strings.Contains(strings.Join(cmd.Args, " "), "--passphrase-file pass.txt") {
+		t.Errorf("Expected a passphrase file for gpg, got %v, %v", cmd, err)
+	}
+
+	if _, err := decryptCommand("secrets.json", ""); err == nil {
+		t.Error("Expected an unencrypted file to be rejected")
+	}
+}
+
+func TestClientResolveSecret(t *testing.T) {
+	client := &Client{file: "secrets.age", values: map[string]string{
+		"db-password":           "hunter2",
+		"op://Vault/Api/token":  "api-token",
+		"op://Vault/Api/unused": "",
+	}}
+
+	tests := []struct {
+		reference string
+		want      string
+		wantErr   bool
+	}{
+		{reference: "local://db-password", want: "hunter2"},
+		{reference: "op://Vault/Api/token", want: "api-token"},
+		{reference: "op://Vault/Api/unused", want: ""},
+		{reference: "local://missing", wantErr: true},
+		{reference: "op://Vault/Api/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := client.ResolveSecret(tt.reference)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ResolveSecret(%q) = %q, %v; want %q, error %v", tt.reference, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestNewClientGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	tmpDir := t.TempDir()
+	t.Setenv("GNUPGHOME", tmpDir)
+	if err := os.Chmod(tmpDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// The agent gpg starts would otherwise outlive the test
+	t.Cleanup(func() { _ = exec.Command("gpgconf", "--kill", "gpg-agent").Run() })
+
+	passphrase := filepath.Join(tmpDir, "passphrase")
+	plaintext := filepath.Join(tmpDir, "secrets.json")
+	encrypted := filepath.Join(tmpDir, "secrets.gpg")
+	if err := os.WriteFile(passphrase, []byte("correct horse"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(plaintext, []byte(`{"db-password": "hunter2"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	encrypt := exec.Command("gpg", "--batch", "--quiet", "--pinentry-mode", "loopback", "--passphrase-file", passphrase,
+		"--symmetric", "--output", encrypted, plaintext)
+	if output, err := encrypt.CombinedOutput(); err != nil {
+		t.Skipf("gpg cannot encrypt here: %v: %s", err, output)
+	}
+
+	client, err := NewClient(encrypted, passphrase)
+	if err != nil {
+		t.Fatalf("Failed to load local secrets: %v", err)
+	}
+	if value, err := client.ResolveSecret("local://db-password"); err != nil || value != "hunter2" {
+		t.Errorf("Expected the decrypted value, got %q, %v", value, err)
+	}
+
+	if err := os.WriteFile(passphrase, []byte("wrong"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewClient(encrypted, passphrase); err == nil {
+		t.Error("Expected a wrong passphrase to fail")
+	}
+}
@@ -0,0 +1,153 @@
+// Package lock keeps concurrent opnix runs (timers, manual runs, watchers)
+// from writing the same output directory at once
+package lock
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// FileName is the lock file kept in the output directory
+const FileName = ".opnix.lock"
+
+// What to do when another run holds the lock
+const (
+	// ModeFail gives up immediately
+	ModeFail = "fail"
+	// ModeWait waits up to the timeout (DefaultTimeout when unset), then fails
+	ModeWait = "wait"
+	// ModeQueue waits until the lock is free, or up to the timeout when set.
+	// Queued runs take the lock in the order they started waiting.
+	ModeQueue = "queue"
+)
+
+// DefaultTimeout bounds ModeWait when no timeout is configured
+const DefaultTimeout = time.Minute
+
+// pollInterval is how often a waiting run retries the lock
+var pollInterval = 100 * time.Millisecond
+
+// Lock is a held exclusive lock on a file
+type Lock struct {
+	file *os.File
+}
+
+// Acquire takes the exclusive lock at path. A zero timeout means the mode's
+// default. Waiting is logged so a slow start is explained.
+func Acquire(path, mode string, timeout time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.FileOperationError("Acquiring run lock", path, "Failed to create lock directory", err)
+	}
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.FileOperationError("Acquiring run lock", path, "Failed to open lock file", err)
+	}
+
+	if mode == "" {
+		mode = ModeWait
+	}
+	if timeout == 0 && mode == ModeWait {
+		timeout = DefaultTimeout
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	if mode == ModeQueue {
+		place, err := joinQueue(path)
+		if err != nil {
+			_ = file.Close()
+			return nil, err
+		}
+		// Holding the place until the lock is taken keeps later runs behind
+		defer place.leave()
+		if !place.waitTurn(deadline) {
+			holder := holderPID(file)
+			_ = file.Close()
+			return nil, heldError(path, holder, mode, timeout)
+		}
+	}
+
+	for waiting := false; ; waiting = true {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if err != syscall.EWOULDBLOCK {
+			_ = file.Close()
+			return nil, errors.FileOperationError("Acquiring run lock", path, "Failed to lock file", err)
+		}
+
+		if mode == ModeFail || (!deadline.IsZero() && time.Now().After(deadline)) {
+			holder := holderPID(file)
+			_ = file.Close()
+			return nil, heldError(path, holder, mode, timeout)
+		}
+		if !waiting {
+			log.Printf("Waiting for another opnix run (%s) to release %s", describeHolder(holderPID(file)), path)
+		}
+		time.Sleep(pollInterval)
+	}
+
+	// Record who holds the lock for anyone who has to wait on it
+	if err := file.Truncate(0); err == nil {
+		_, _ = file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &Lock{file: file}, nil
+}
+
+// Release gives the lock to the next run
+func (l *Lock) Release() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	// Closing the file drops the lock
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// holderPID reads the PID the holding run recorded, or 0 when unknown
+func holderPID(file *os.File) int {
+	data := make([]byte, 32)
+	n, _ := file.ReadAt(data, 0)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data[:n])))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func describeHolder(pid int) string {
+	if pid == 0 {
+		return "unknown PID"
+	}
+	return fmt.Sprintf("PID %d", pid)
+}
+
+func heldError(path string, pid int, mode string, timeout time.Duration) error {
+	issue := fmt.Sprintf("Another opnix run (%s) holds %s", describeHolder(pid), path)
+	if mode != ModeFail {
+		issue = fmt.Sprintf("%s and did not release it within %s", issue, timeout)
+	}
+	return &errors.OpnixError{
+		Operation: "Acquiring run lock",
+		Component: "file system",
+		Issue:     issue,
+		Suggestions: []string{
+			"Wait for the other run to finish and try again",
+			"Use -lock-mode queue to wait for it instead of failing",
+			"Raise -lock-timeout if runs regularly take longer",
+		},
+	}
+}
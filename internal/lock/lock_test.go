@@ -0,0 +1,123 @@
+package lock
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	pollInterval = 10 * time.Millisecond
+	path := filepath.Join(t.TempDir(), "secrets", FileName)
+
+	held, err := Acquire(path, ModeFail, 0)
+	if err != nil {
+		t.Fatalf("Failed to acquire a free lock: %v", err)
+	}
+
+	// flock locks belong to the open file, so a second open contends even
+	// within one process
+	_, err = Acquire(path, ModeFail, 0)
+	if err == nil || !strings.Contains(err.Error(), "Another opnix run") {
+		t.Fatalf("Expected fail mode to give up at once, got %v", err)
+	}
+
+	start := time.Now()
+	_, err = Acquire(path, ModeWait, 50*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "did not release it within 50ms") {
+		t.Fatalf("Expected wait mode to time out, got %v", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("Expected wait mode to wait for the timeout, gave up after %s", time.Since(start))
+	}
+
+	// A queued run proceeds once the holder releases the lock
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		_ = held.Release()
+	}()
+	queued, err := Acquire(path, ModeQueue, 0)
+	if err != nil {
+		t.Fatalf("Expected the queued run to get the lock after release: %v", err)
+	}
+	if err := queued.Release(); err != nil {
+		t.Errorf("Failed to release lock: %v", err)
+	}
+}
+
+func TestAcquireQueueOrder(t *testing.T) {
+	pollInterval = 5 * time.Millisecond
+	path := filepath.Join(t.TempDir(), FileName)
+
+	held, err := Acquire(path, ModeFail, 0)
+	if err != nil {
+		t.Fatalf("Failed to acquire a free lock: %v", err)
+	}
+
+	// A ticket nobody holds is left by a run that died, and must not block
+	stale := filepath.Join(path+queueSuffix, "00000000000000000001-1")
+	if err := os.MkdirAll(filepath.Dir(stale), 0700); err != nil {
+		t.Fatalf("Failed to create queue directory: %v", err)
+	}
+	if err := os.WriteFile(stale, nil, 0600); err != nil {
+		t.Fatalf("Failed to write stale ticket: %v", err)
+	}
+
+	order := make(chan int, 3)
+	var wg sync.WaitGroup
+	for run := 1; run <= 3; run++ {
+		wg.Add(1)
+		go func(run int) {
+			defer wg.Done()
+			queued, err := Acquire(path, ModeQueue, 5*time.Second)
+			if err != nil {
+				t.Errorf("Queued run %d failed: %v", run, err)
+				return
+			}
+			order <- run
+			time.Sleep(20 * time.Millisecond)
+			_ = queued.Release()
+		}(run)
+		// Let each run take its place before the next one starts
+		waitForTickets(t, path, run)
+	}
+
+	_ = held.Release()
+	wg.Wait()
+	close(order)
+
+	var got []int
+	for run := range order {
+		got = append(got, run)
+	}
+	if len(got) != 3 || got[0] != 1 || got[1] != 2 || got[2] != 3 {
+		t.Errorf("Expected queued runs to take the lock in order 1 2 3, got %v", got)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("Expected the stale ticket to be removed, got %v", err)
+	}
+	if entries, _ := os.ReadDir(path + queueSuffix); len(entries) != 0 {
+		t.Errorf("Expected every ticket to be removed, found %d", len(entries))
+	}
+}
+
+// waitForTickets waits until count live tickets are queued at path
+func waitForTickets(t *testing.T, path string, count int) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		entries, _ := os.ReadDir(path + queueSuffix)
+		live := 0
+		for _, entry := range entries {
+			if !strings.HasPrefix(entry.Name(), ".") && !strings.HasPrefix(entry.Name(), "00000000000000000001") {
+				live++
+			}
+		}
+		if live == count {
+			return
+		}
+	}
+	t.Fatalf("Expected %d queued runs", count)
+}
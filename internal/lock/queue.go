@@ -0,0 +1,108 @@
+package lock
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Queued runs line up as ticket files in a directory next to the lock file,
+// named so they sort in the order the runs joined. Each run holds a lock on
+// its own ticket while it waits, so the ticket of a run that died is free
+// and is removed by the next run that finds it.
+
+// queueSuffix names the ticket directory of a lock file
+const queueSuffix = ".queue"
+
+// ticket is a run's place in the queue
+type ticket struct {
+	file *os.File
+	path string
+}
+
+// joinQueue takes a place at the end of the queue of the lock at path
+func joinQueue(path string) (*ticket, error) {
+	dir := path + queueSuffix
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.FileOperationError("Acquiring run lock", dir, "Failed to create lock queue directory", err)
+	}
+
+	// The ticket is locked under a hidden name before it is given its place,
+	// so no other run can take it for the ticket of a run that died
+	file, err := os.CreateTemp(dir, ".ticket-")
+	if err != nil {
+		return nil, errors.FileOperationError("Acquiring run lock", dir, "Failed to create queue ticket", err)
+	}
+	if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, errors.FileOperationError("Acquiring run lock", file.Name(), "Failed to lock queue ticket", err)
+	}
+
+	name := filepath.Join(dir, fmt.Sprintf("%020d-%d", time.Now().UnixNano(), os.Getpid()))
+	if err := os.Rename(file.Name(), name); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return nil, errors.FileOperationError("Acquiring run lock", name, "Failed to place queue ticket", err)
+	}
+	return &ticket{file: file, path: name}, nil
+}
+
+// waitTurn waits until no live run is ahead in the queue, or reports false
+// once deadline passes
+func (t *ticket) waitTurn(deadline time.Time) bool {
+	for waiting := false; ; waiting = true {
+		ahead := t.ahead()
+		if ahead == 0 {
+			return true
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return false
+		}
+		if !waiting {
+			log.Printf("Waiting behind %d queued opnix runs", ahead)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// ahead counts the live runs that joined the queue before t, removing the
+// tickets of runs that died
+func (t *ticket) ahead() int {
+	entries, err := os.ReadDir(filepath.Dir(t.path))
+	if err != nil {
+		return 0
+	}
+
+	count := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || name >= filepath.Base(t.path) {
+			continue
+		}
+		other := filepath.Join(filepath.Dir(t.path), name)
+		file, err := os.Open(other)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+			_ = os.Remove(other)
+		} else {
+			count++
+		}
+		_ = file.Close()
+	}
+	return count
+}
+
+// leave gives up the place in the queue
+func (t *ticket) leave() {
+	_ = os.Remove(t.path)
+	_ = t.file.Close()
+}
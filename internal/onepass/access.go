@@ -0,0 +1,105 @@
+package onepass
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// ReferencedVaults returns the distinct vaults named by references, sorted
+func ReferencedVaults(references []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var vaults []string
+	for _, reference := range references {
+		ref, err := ParseReference(reference)
+		if err != nil {
+			return nil, err
+		}
+		if vault := ref.VaultKey(); !seen[vault] {
+			seen[vault] = true
+			vaults = append(vaults, vault)
+		}
+	}
+	sort.Strings(vaults)
+	return vaults, nil
+}
+
+// VerifyToken confirms token can sign in and list its vaults, returning how
+// many it can access. It reads no token file or environment token, so a
+// replacement token can be checked before it is installed. network routes the
+// check as a run would be routed.
+func VerifyToken(ctx context.Context, token string, network NetworkOptions) (int, error) {
+	if err := applyNetworkOptions(network); err != nil {
+		return 0, err
+	}
+
+	client, err := newClientWithToken(token)
+	if err != nil {
+		return 0, err
+	}
+
+	vaults, err := client.client.Vaults().List(ctx)
+	if err != nil {
+		return 0, onePasswordError(
+			"Verifying service account token",
+			"Token signed in but could not list vaults",
+			err,
+		)
+	}
+	return len(vaults), nil
+}
+
+// CheckVaultAccess verifies the token can access every vault, given by name
+// or ID, with a single vault list call. All inaccessible vaults are reported
+// together.
+func (c *Client) CheckVaultAccess(ctx context.Context, vaults []string) error {
+	if len(vaults) == 0 {
+		return nil
+	}
+
+	accessible, err := c.client.Vaults().List(ctx)
+	if err != nil {
+		return onePasswordError(
+			"Checking vault access",
+			"Failed to list vaults accessible to the service account token",
+			err,
+		)
+	}
+
+	var titles []string
+	for _, vault := range accessible {
+		titles = append(titles, vault.Title)
+	}
+
+	var missing []string
+	for _, name := range vaults {
+		found := false
+		for _, vault := range accessible {
+			if vault.ID == name || strings.EqualFold(vault.Title, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	sort.Strings(titles)
+	return &errors.OpnixError{
+		Operation: "Checking vault access",
+		Component: "1Password integration",
+		Issue:     fmt.Sprintf("Token cannot access %d of %d referenced vaults: %s", len(missing), len(vaults), strings.Join(missing, ", ")),
+		Context:   fmt.Sprintf("Accessible vaults: %s", strings.Join(titles, ", ")),
+		Suggestions: []string{
+			"Grant the service account access to the listed vaults",
+			"Check the vault names in the references for typos",
+		},
+	}
+}
@@ -0,0 +1,171 @@
+package onepass
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Accounts manages one client per named 1Password account. Clients are
+// created on first use, so accounts a run doesn't touch never need a token.
+type Accounts struct {
+	tokenFiles map[string]string
+
+	mu      sync.Mutex
+	clients map[string]*Client
+}
+
+// NewAccounts returns a client set for the given account name -> token file map
+func NewAccounts(tokenFiles map[string]string) *Accounts {
+	return &Accounts{
+		tokenFiles: tokenFiles,
+		clients:    make(map[string]*Client),
+	}
+}
+
+// Client returns the client for a named account. Account tokens are only read
+// from their token file: OP_SERVICE_ACCOUNT_TOKEN belongs to the default account.
+func (a *Accounts) Client(name string) (*Client, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if client, exists := a.clients[name]; exists {
+		return client, nil
+	}
+
+	tokenFile, exists := a.tokenFiles[name]
+	if !exists {
+		return nil, errors.ConfigError(
+			"Selecting 1Password account",
+			fmt.Sprintf("Account '%s' is not defined in accounts", name),
+			nil,
+		)
+	}
+
+	token, err := readTokenFile(tokenFile)
+	if err != nil {
+		return nil, errors.Wrap(err, fmt.Sprintf("Loading token for account %s", name), "onepass")
+	}
+
+	client, err := newClientWithToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if identity, ok := client.Identity(); ok {
+		log.Printf("Account %s authenticated as %s", name, identity)
+	}
+
+	a.clients[name] = client
+	return client, nil
+}
+
+// CheckTokenAccount verifies that a service account token belongs to account,
+// given as a sign-in address (myteam.1password.com) or its shorthand (myteam).
+// Service account tokens are bound to a single account, so this selects the
+// account by refusing tokens for any other.
+func CheckTokenAccount(token, account string) error {
+	identity, err := TokenIdentity(token)
+	if err != nil {
+		return errors.OnePasswordError(
+			"Selecting 1Password account",
+			fmt.Sprintf("Cannot determine which account the service account token belongs to (wanted %s)", account),
+			err,
+		)
+	}
+
+	if identity.Matches(account) {
+		return nil
+	}
+
+	opErr := errors.OnePasswordError(
+		"Selecting 1Password account",
+		fmt.Sprintf("Service account token belongs to %s, not %s", identity.SignInAddress, account),
+		nil,
+	)
+	opErr.Suggestions = []string{
+		fmt.Sprintf("Use a service account token created in the %s account", account),
+		"Check the --account flag and OPNIX_ACCOUNT environment variable",
+		"Omit the account to use whichever account the token belongs to",
+	}
+	return opErr
+}
+
+// Identity is the 1Password account a service account token authenticates
+// to, as encoded in the token itself
+type Identity struct {
+	// Sign-in address of the account, e.g. myteam.1password.com
+	SignInAddress string
+	// The service account's own address; empty in tokens that don't carry it
+	Email string
+}
+
+// String describes the identity for logs, e.g. "sa@... on myteam.1password.com"
+func (i Identity) String() string {
+	address := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(i.SignInAddress, "https://"), "http://"), "/")
+	if i.Email == "" {
+		return address
+	}
+	return fmt.Sprintf("%s on %s", i.Email, address)
+}
+
+// Matches reports whether account, a sign-in address or its shorthand,
+// names this identity's account
+func (i Identity) Matches(account string) bool {
+	return accountMatches(i.SignInAddress, account)
+}
+
+// TokenIdentity decodes the account an ops_ service account token belongs
+// to. Only the address fields are read from the token payload.
+func TokenIdentity(token string) (Identity, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(token), "ops_")
+	if !ok {
+		return Identity{}, fmt.Errorf("token is not a service account token")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		if data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(encoded, "=")); err != nil {
+			return Identity{}, fmt.Errorf("token payload is not valid base64")
+		}
+	}
+
+	var payload struct {
+		SignInAddress string `json:"signInAddress"`
+		Email         string `json:"email"`
+	}
+	if err := json.Unmarshal(data, &payload); err != nil || payload.SignInAddress == "" {
+		return Identity{}, fmt.Errorf("token payload has no sign-in address")
+	}
+
+	return Identity{SignInAddress: payload.SignInAddress, Email: payload.Email}, nil
+}
+
+// TokenFileIdentity decodes the account of the token in tokenFile
+func TokenFileIdentity(tokenFile string) (Identity, error) {
+	token, err := readTokenFile(tokenFile)
+	if err != nil {
+		return Identity{}, err
+	}
+	return TokenIdentity(token)
+}
+
+// accountMatches compares a sign-in address with an address or shorthand
+func accountMatches(address, account string) bool {
+	normalize := func(s string) string {
+		s = strings.ToLower(strings.TrimSpace(s))
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
+		return strings.TrimSuffix(s, "/")
+	}
+
+	address, account = normalize(address), normalize(account)
+	if address == account {
+		return true
+	}
+	shorthand, _, _ := strings.Cut(address, ".")
+	return !strings.Contains(account, ".") && shorthand == account
+}
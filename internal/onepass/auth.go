@@ -0,0 +1,55 @@
+package onepass
+
+import (
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// unauthorizedPatterns match the wording the SDK uses when the token itself
+// is refused. Like retries, the SDK's errors carry no type to go on.
+var unauthorizedPatterns = []string{
+	"unauthorized",
+	"unauthenticated",
+	"forbidden",
+	"invalid bearer token",
+	"invalid service account token",
+	"token is invalid",
+	"token has been revoked",
+	"token is expired",
+	"token has expired",
+	"status 401",
+	"status 403",
+	"(401)",
+	"(403)",
+}
+
+// IsUnauthorizedError reports whether err means 1Password rejected the
+// token, rather than a missing item or a network failure
+func IsUnauthorizedError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.IsUnauthorized(err) {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, pattern := range unauthorizedPatterns {
+		if strings.Contains(message, pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// onePasswordError is errors.OnePasswordError for failed SDK calls, except
+// that a rejected token becomes an errors.UnauthorizedError
+func onePasswordError(operation, issue string, cause error) *errors.OpnixError {
+	if IsUnauthorizedError(cause) {
+		if authErr, ok := errors.AsUnauthorized(cause); ok {
+			return authErr
+		}
+		return errors.UnauthorizedError(operation, cause)
+	}
+	return errors.OnePasswordError(operation, issue, cause)
+}
@@ -0,0 +1,48 @@
+package onepass
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+func TestIsUnauthorizedError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{err: fmt.Errorf("invalid service account token, please make sure you provide a valid token"), want: true},
+		{err: fmt.Errorf("http error: unexpected status 401 Unauthorized"), want: true},
+		{err: fmt.Errorf("request failed (403)"), want: true},
+		{err: errors.UnauthorizedError("Resolving 1Password secret", fmt.Errorf("denied")), want: true},
+		{err: fmt.Errorf("error resolving secret reference: no item matched the secret reference query"), want: false},
+		{err: fmt.Errorf("dial tcp: lookup my.1password.com: no such host"), want: false},
+		{err: fmt.Errorf("rate limit exceeded"), want: false},
+		{err: nil, want: false},
+	} {
+		if got := IsUnauthorizedError(tt.err); got != tt.want {
+			t.Errorf("IsUnauthorizedError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestOnePasswordErrorClassifiesUnauthorized(t *testing.T) {
+	err := onePasswordError("Resolving 1Password secret", "Failed to resolve reference: op://v/i/f", fmt.Errorf("Unauthorized"))
+	if err.Component != "authentication" || !errors.IsUnauthorized(err) {
+		t.Errorf("Expected an unauthorized authentication error, got %v", err)
+	}
+	// Already classified errors are passed on rather than wrapped again
+	if again := onePasswordError("Verifying service account token", "", err); again != err {
+		t.Errorf("Expected the existing error to be returned, got %v", again)
+	}
+
+	err = onePasswordError("Resolving 1Password secret", "Failed to resolve reference: op://v/i/f", fmt.Errorf("no item matched"))
+	if err.Component != "1Password integration" || errors.IsUnauthorized(err) {
+		t.Errorf("Expected a 1Password integration error, got %v", err)
+	}
+
+	if NewRetryClassifier([]string{"unauthorized"}).Retryable(fmt.Errorf("401 Unauthorized")) {
+		t.Error("Expected a rejected token never to be retried")
+	}
+}
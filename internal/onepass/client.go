@@ -12,34 +12,25 @@ import (
 
 type Client struct {
 	client *onepassword.Client
+	// identity is the account the token belongs to, when the token says
+	identity *Identity
 }
 
-// GetToken retrieves token from environment or file
+// GetToken retrieves token from a descriptor set with SetTokenFD, the
+// environment or file
 func GetToken(tokenFile string) (string, error) {
-	// First try environment variable
+	if fdToken != "" {
+		return fdToken, nil
+	}
+
+	// Then try environment variable
 	if token := os.Getenv("OP_SERVICE_ACCOUNT_TOKEN"); token != "" {
 		return token, nil
 	}
 
 	// Then try token file
 	if tokenFile != "" {
-		data, err := os.ReadFile(tokenFile)
-		if err != nil {
-			return "", errors.TokenError(
-				fmt.Sprintf("Failed to read token file: %s", err.Error()),
-				tokenFile,
-				err,
-			)
-		}
-		token := strings.TrimSpace(string(data))
-		if len(token) == 0 {
-			return "", errors.TokenError(
-				"Token file is empty",
-				tokenFile,
-				nil,
-			)
-		}
-		return token, nil
+		return readTokenFile(tokenFile)
 	}
 
 	return "", errors.TokenError(
@@ -49,36 +40,214 @@ func GetToken(tokenFile string) (string, error) {
 	)
 }
 
-func NewClient(tokenFile string) (*Client, error) {
+// readTokenFile reads a service account token from a file
+func readTokenFile(tokenFile string) (string, error) {
+	data, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return "", errors.TokenError(
+			fmt.Sprintf("Failed to read token file: %s", err.Error()),
+			tokenFile,
+			err,
+		)
+	}
+	token := strings.TrimSpace(string(data))
+	if len(token) == 0 {
+		return "", errors.TokenError(
+			"Token file is empty",
+			tokenFile,
+			nil,
+		)
+	}
+	return token, nil
+}
+
+// NewClient creates a client for the service account token. When account is
+// set (or OPNIX_ACCOUNT is), the token must belong to that 1Password account.
+// The network options apply to every client in the process.
+func NewClient(tokenFile, account string, network NetworkOptions) (*Client, error) {
+	if err := applyNetworkOptions(network); err != nil {
+		return nil, err
+	}
+
 	token, err := GetToken(tokenFile)
 	if err != nil {
 		return nil, err
 	}
 
+	if account == "" {
+		account = os.Getenv("OPNIX_ACCOUNT")
+	}
+	if account != "" {
+		if err := CheckTokenAccount(token, account); err != nil {
+			return nil, err
+		}
+	}
+
+	return newClientWithToken(token)
+}
+
+func newClientWithToken(token string) (*Client, error) {
 	client, err := onepassword.NewClient(
 		context.Background(),
 		onepassword.WithServiceAccountToken(token),
 		onepassword.WithIntegrationInfo("NixOS Secrets Integration", "v1.0.0"),
 	)
 	if err != nil {
-		return nil, errors.OnePasswordError(
+		return nil, onePasswordError(
 			"Initializing 1Password client",
 			"Failed to create 1Password SDK client - check token validity",
 			err,
 		)
 	}
 
-	return &Client{client: client}, nil
+	c := &Client{client: client}
+	if identity, err := TokenIdentity(token); err == nil {
+		c.identity = &identity
+	}
+	return c, nil
+}
+
+// Identity returns the account the client's token authenticates to. It is
+// read from the token, so it is only known for service account tokens.
+func (c *Client) Identity() (Identity, bool) {
+	if c == nil || c.identity == nil {
+		return Identity{}, false
+	}
+	return *c.identity, true
 }
 
 func (c *Client) ResolveSecret(reference string) (string, error) {
-	secret, err := c.client.Secrets().Resolve(context.Background(), reference)
+	return c.ResolveSecretContext(context.Background(), reference)
+}
+
+// ResolveSecretContext resolves a reference, aborting when ctx is done
+func (c *Client) ResolveSecretContext(ctx context.Context, reference string) (string, error) {
+	ref, indexed := indexedReference(reference)
+	reference = sdkReference(reference)
+
+	secret, err := c.client.Secrets().Resolve(ctx, reference)
 	if err != nil {
-		return "", errors.OnePasswordError(
+		return "", onePasswordError(
 			"Resolving 1Password secret",
 			fmt.Sprintf("Failed to resolve reference: %s", reference),
 			err,
 		)
 	}
+	if indexed {
+		return ref.SelectEntry(secret)
+	}
 	return secret, nil
 }
+
+// indexedReference parses a reference with a list index. The SDK does not
+// understand indices, so such references resolve the whole field and select
+// the entry afterwards.
+func indexedReference(reference string) (Reference, bool) {
+	ref, err := ParseReference(reference)
+	if err != nil || !ref.Indexed {
+		return Reference{}, false
+	}
+	return ref, true
+}
+
+// ResolveSecrets resolves several references in a single request, failing if
+// any of them cannot be resolved
+func (c *Client) ResolveSecrets(references []string) (map[string]string, error) {
+	return c.ResolveSecretsContext(context.Background(), references)
+}
+
+// ResolveSecretsContext resolves several references in one request, aborting
+// when ctx is done
+func (c *Client) ResolveSecretsContext(ctx context.Context, references []string) (map[string]string, error) {
+	// Indexed references are requested as their whole field
+	requested := make([]string, len(references))
+	for i, reference := range references {
+		requested[i] = sdkReference(reference)
+	}
+
+	response, err := c.client.Secrets().ResolveAll(ctx, requested)
+	if IsUnauthorizedError(err) {
+		return nil, onePasswordError("Resolving 1Password secrets", "", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(references))
+	for i, reference := range references {
+		individual, exists := response.IndividualResponses[requested[i]]
+		if !exists || individual.Content == nil {
+			reason := "no value returned"
+			if exists && individual.Error != nil {
+				reason = string(individual.Error.Type)
+			}
+			return nil, fmt.Errorf("failed to resolve %s: %s", reference, reason)
+		}
+
+		value := individual.Content.Secret
+		if ref, indexed := indexedReference(reference); indexed {
+			if value, err = ref.SelectEntry(value); err != nil {
+				return nil, err
+			}
+		}
+		values[reference] = value
+	}
+
+	return values, nil
+}
+
+// ResolveSecretWithFallback resolves reference, trying each fallback field of
+// the same item in order when the referenced field does not exist. Any other
+// failure is returned immediately.
+func (c *Client) ResolveSecretWithFallback(ctx context.Context, reference string, fallbacks []string) (string, error) {
+	ref, err := ParseReference(reference)
+	if err != nil {
+		return "", err
+	}
+
+	// An index applies to whichever field is found
+	whole := ref.Resolvable().Unindexed()
+	candidates := []string{whole.String()}
+	for _, field := range fallbacks {
+		candidates = append(candidates, whole.WithField(field).String())
+	}
+
+	for _, candidate := range candidates {
+		response, err := c.client.Secrets().ResolveAll(ctx, []string{candidate})
+		if err != nil {
+			return "", onePasswordError(
+				"Resolving 1Password secret",
+				fmt.Sprintf("Failed to resolve reference: %s", candidate),
+				err,
+			)
+		}
+
+		individual, exists := response.IndividualResponses[candidate]
+		if exists && individual.Content != nil {
+			if ref.Indexed {
+				return ref.SelectEntry(individual.Content.Secret)
+			}
+			return individual.Content.Secret, nil
+		}
+		if exists && individual.Error != nil && individual.Error.Type == onepassword.ResolveReferenceErrorTypeVariantFieldNotFound {
+			continue
+		}
+
+		reason := "no value returned"
+		if exists && individual.Error != nil {
+			reason = string(individual.Error.Type)
+		}
+		return "", onePasswordError(
+			"Resolving 1Password secret",
+			fmt.Sprintf("Failed to resolve reference: %s", candidate),
+			fmt.Errorf("%s", reason),
+		)
+	}
+
+	fields := append([]string{ref.Field}, fallbacks...)
+	return "", onePasswordError(
+		"Resolving 1Password secret",
+		fmt.Sprintf("None of the fields %s exist in item %s", strings.Join(fields, ", "), ref.Item),
+		nil,
+	)
+}
@@ -0,0 +1,156 @@
+package onepass
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/1password/onepassword-sdk-go"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// CheckFieldType reads the referenced item and verifies the referenced field
+// has the expected type. The SDK has no metadata-only read, so the whole item
+// is downloaded, values included; only the field types are looked at.
+func (c *Client) CheckFieldType(ctx context.Context, reference, expected string) error {
+	ref, err := ParseReference(reference)
+	if err != nil {
+		return err
+	}
+
+	item, err := c.lookupItem(ctx, ref)
+	if err != nil {
+		return err
+	}
+
+	actual, found := referencedFieldType(item, ref)
+	if !found {
+		return onePasswordError(
+			"Checking field type",
+			fmt.Sprintf("Field '%s' not found in item metadata for %s", ref.Field, reference),
+			nil,
+		)
+	}
+	if !fieldTypeMatches(actual, expected) {
+		return &errors.OpnixError{
+			Operation: "Checking field type",
+			Component: "1Password integration",
+			Issue:     fmt.Sprintf("Reference %s points at a %s field, expected %s", reference, actual, expected),
+			Suggestions: []string{
+				"Check that the reference names the intended field",
+				"Update type if the field type is intended",
+			},
+		}
+	}
+	return nil
+}
+
+// lookupItem finds the referenced item by ID or title and reads it, through
+// the item cache carried by ctx if there is one
+func (c *Client) lookupItem(ctx context.Context, ref Reference) (onepassword.Item, error) {
+	key := itemKey{client: c, vault: ref.VaultKey(), item: ref.Item}
+	return itemCacheFrom(ctx).get(key, func() (onepassword.Item, error) {
+		return c.fetchItem(ctx, ref)
+	})
+}
+
+// fetchItem lists the vaults and the vault's items to find the referenced
+// item, then reads it
+func (c *Client) fetchItem(ctx context.Context, ref Reference) (onepassword.Item, error) {
+	vaults, err := c.client.Vaults().List(ctx)
+	if err != nil {
+		return onepassword.Item{}, onePasswordError("Checking field type", "Failed to list vaults", err)
+	}
+
+	vaultID := ""
+	for _, vault := range vaults {
+		if vault.ID == ref.VaultKey() || (ref.VaultID == "" && strings.EqualFold(vault.Title, ref.Vault)) {
+			vaultID = vault.ID
+			break
+		}
+	}
+	if vaultID == "" {
+		return onepassword.Item{}, onePasswordError("Checking field type", fmt.Sprintf("Vault '%s' not found", ref.Vault), nil)
+	}
+
+	overviews, err := c.client.Items().List(ctx, vaultID)
+	if err != nil {
+		return onepassword.Item{}, onePasswordError("Checking field type", fmt.Sprintf("Failed to list items in vault: %s", ref.Vault), err)
+	}
+	for _, overview := range overviews {
+		if overview.ID == ref.Item || strings.EqualFold(overview.Title, ref.Item) {
+			item, err := c.client.Items().Get(ctx, vaultID, overview.ID)
+			if err != nil {
+				return onepassword.Item{}, onePasswordError("Checking field type", fmt.Sprintf("Failed to read item: %s/%s", ref.Vault, ref.Item), err)
+			}
+			return item, nil
+		}
+	}
+
+	return onepassword.Item{}, onePasswordError("Checking field type", fmt.Sprintf("Item '%s' not found in vault '%s'", ref.Item, ref.Vault), nil)
+}
+
+// referencedFieldType returns the type of the field or file a reference
+// points at: an SDK field type, "Password" for the built-in password field,
+// or "File" for attachments and documents
+func referencedFieldType(item onepassword.Item, ref Reference) (string, bool) {
+	sections := make(map[string]string, len(item.Sections))
+	for _, section := range item.Sections {
+		sections[section.ID] = section.Title
+	}
+
+	for _, field := range item.Fields {
+		if field.ID != ref.Field && !strings.EqualFold(field.Title, ref.Field) {
+			continue
+		}
+		if ref.Section != "" {
+			if field.SectionID == nil || (*field.SectionID != ref.Section && !strings.EqualFold(sections[*field.SectionID], ref.Section)) {
+				continue
+			}
+		}
+		if field.FieldType == onepassword.ItemFieldTypeConcealed && field.ID == "password" {
+			return "Password", true
+		}
+		return string(field.FieldType), true
+	}
+
+	if _, found := referencedFile(item, ref); found {
+		return "File", true
+	}
+
+	return "", false
+}
+
+// referencedFile returns the attachment or document a reference points at
+func referencedFile(item onepassword.Item, ref Reference) (onepassword.FileAttributes, bool) {
+	for _, file := range item.Files {
+		if file.Attributes.ID == ref.Field || strings.EqualFold(file.Attributes.Name, ref.Field) {
+			return file.Attributes, true
+		}
+	}
+	if item.Document != nil && (item.Document.ID == ref.Field || strings.EqualFold(item.Document.Name, ref.Field)) {
+		return *item.Document, true
+	}
+
+	return onepassword.FileAttributes{}, false
+}
+
+// fieldTypeMatches reports whether an actual field type satisfies a declared
+// one. The built-in password field is also concealed.
+func fieldTypeMatches(actual, expected string) bool {
+	switch expected {
+	case "password":
+		return actual == "Password"
+	case "concealed":
+		return actual == "Password" || actual == string(onepassword.ItemFieldTypeConcealed)
+	case "text":
+		return actual == string(onepassword.ItemFieldTypeText)
+	case "file":
+		return actual == "File"
+	case "otp":
+		return actual == string(onepassword.ItemFieldTypeTOTP)
+	case "sshKey":
+		return actual == string(onepassword.ItemFieldTypeSSHKey)
+	}
+	return false
+}
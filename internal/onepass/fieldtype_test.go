@@ -0,0 +1,57 @@
+package onepass
+
+import (
+	"testing"
+
+	"github.com/1password/onepassword-sdk-go"
+)
+
+func TestReferencedFieldType(t *testing.T) {
+	sectionID := "sec1"
+	item := onepassword.Item{
+		Fields: []onepassword.ItemField{
+			{ID: "password", Title: "password", FieldType: onepassword.ItemFieldTypeConcealed},
+			{ID: "username", Title: "username", FieldType: onepassword.ItemFieldTypeText},
+			{ID: "f1", Title: "api key", SectionID: &sectionID, FieldType: onepassword.ItemFieldTypeConcealed},
+			{ID: "f2", Title: "one-time password", FieldType: onepassword.ItemFieldTypeTOTP},
+		},
+		Sections: []onepassword.ItemSection{{ID: sectionID, Title: "Api"}},
+		Files:    []onepassword.ItemFile{{Attributes: onepassword.FileAttributes{ID: "file1", Name: "cert.pem"}}},
+	}
+
+	tests := []struct {
+		reference string
+		expected  string
+		matches   bool
+	}{
+		{"op://Vault/Item/password", "password", true},
+		{"op://Vault/Item/password", "concealed", true},
+		{"op://Vault/Item/username", "password", false},
+		{"op://Vault/Item/username", "text", true},
+		{"op://Vault/Item/Api/api key", "concealed", true},
+		{"op://Vault/Item/Api/api key", "password", false},
+		{"op://Vault/Item/one-time password", "otp", true},
+		{"op://Vault/Item/cert.pem", "file", true},
+		{"op://Vault/Item/cert.pem", "text", false},
+	}
+
+	for _, tt := range tests {
+		ref, err := ParseReference(tt.reference)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tt.reference, err)
+		}
+		actual, found := referencedFieldType(item, ref)
+		if !found {
+			t.Errorf("Expected %s to be found", tt.reference)
+			continue
+		}
+		if got := fieldTypeMatches(actual, tt.expected); got != tt.matches {
+			t.Errorf("%s (%s) as %s: got match %v, expected %v", tt.reference, actual, tt.expected, got, tt.matches)
+		}
+	}
+
+	ref, _ := ParseReference("op://Vault/Item/Other/api key")
+	if _, found := referencedFieldType(item, ref); found {
+		t.Error("Expected a field in another section not to match")
+	}
+}
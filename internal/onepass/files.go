@@ -0,0 +1,54 @@
+package onepass
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// OpenFile opens the file attachment or document a reference points at.
+// found is false when the reference names a regular field, which should be
+// resolved as usual. This does not stream: the SDK reads the whole file into
+// memory before returning, whatever its size, and the reader serves that
+// buffer. It only saves the string copies a resolve makes, and Close wipes
+// the buffer.
+func (c *Client) OpenFile(ctx context.Context, reference string) (io.ReadCloser, bool, error) {
+	ref, err := ParseReference(reference)
+	if err != nil {
+		return nil, false, err
+	}
+
+	item, err := c.lookupItem(ctx, ref)
+	if err != nil {
+		return nil, false, err
+	}
+
+	attributes, found := referencedFile(item, ref)
+	if !found {
+		return nil, false, nil
+	}
+
+	content, err := c.client.Items().Files().Read(ctx, item.VaultID, item.ID, attributes)
+	if err != nil {
+		return nil, true, onePasswordError(
+			"Reading file attachment",
+			fmt.Sprintf("Failed to read file for reference: %s", reference),
+			err,
+		)
+	}
+	return &fileReader{Reader: bytes.NewReader(content), content: content}, true, nil
+}
+
+// fileReader reads a file's content and wipes it once closed
+type fileReader struct {
+	*bytes.Reader
+	content []byte
+}
+
+func (f *fileReader) Close() error {
+	clear(f.content)
+	runtime.KeepAlive(f.content)
+	return nil
+}
@@ -0,0 +1,71 @@
+package onepass
+
+import (
+	"context"
+	"sync"
+
+	"github.com/1password/onepassword-sdk-go"
+)
+
+// ItemCache keeps the items looked up to check field types and read file
+// attachments, so secrets sharing an item list its vault and read it once.
+// Items carry their field values, so a cache should not outlive the run that
+// filled it.
+type ItemCache struct {
+	mu    sync.Mutex
+	items map[itemKey]onepassword.Item
+}
+
+// itemKey names an item as a reference does, per client so accounts with
+// vaults of the same name do not share entries
+type itemKey struct {
+	client *Client
+	vault  string
+	item   string
+}
+
+type itemCacheContextKey struct{}
+
+// NewItemCache creates an empty item cache
+func NewItemCache() *ItemCache {
+	return &ItemCache{items: make(map[itemKey]onepassword.Item)}
+}
+
+// WithItemCache returns a context whose item lookups go through cache. A nil
+// cache leaves every lookup uncached.
+func WithItemCache(ctx context.Context, cache *ItemCache) context.Context {
+	if cache == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, itemCacheContextKey{}, cache)
+}
+
+// itemCacheFrom returns the cache carried by ctx, or nil
+func itemCacheFrom(ctx context.Context) *ItemCache {
+	cache, _ := ctx.Value(itemCacheContextKey{}).(*ItemCache)
+	return cache
+}
+
+// get returns the cached item for key, calling fetch on a miss. Failed
+// lookups are not cached, so a retry asks 1Password again.
+func (c *ItemCache) get(key itemKey, fetch func() (onepassword.Item, error)) (onepassword.Item, error) {
+	if c == nil {
+		return fetch()
+	}
+
+	c.mu.Lock()
+	item, ok := c.items[key]
+	c.mu.Unlock()
+	if ok {
+		return item, nil
+	}
+
+	item, err := fetch()
+	if err != nil {
+		return item, err
+	}
+	c.mu.Lock()
+	c.items[key] = item
+	c.mu.Unlock()
+	return item, nil
+}
@@ -0,0 +1,54 @@
+package onepass
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/1password/onepassword-sdk-go"
+)
+
+func TestItemCache(t *testing.T) {
+	fetches := 0
+	fetch := func() (onepassword.Item, error) {
+		fetches++
+		return onepassword.Item{ID: fmt.Sprintf("item%d", fetches)}, nil
+	}
+	database := itemKey{vault: "Vault", item: "Database"}
+
+	cache := itemCacheFrom(WithItemCache(context.Background(), NewItemCache()))
+	if cache == nil {
+		t.Fatal("Expected the context to carry the cache")
+	}
+	first, _ := cache.get(database, fetch)
+	second, _ := cache.get(database, fetch)
+	if fetches != 1 || first.ID != second.ID {
+		t.Errorf("Expected one fetch for repeated lookups, got %d (%s, %s)", fetches, first.ID, second.ID)
+	}
+
+	_, _ = cache.get(itemKey{vault: "Vault", item: "Api"}, fetch)
+	if fetches != 2 {
+		t.Errorf("Expected another item to be fetched, got %d fetches", fetches)
+	}
+
+	failing := itemKey{vault: "Vault", item: "Flaky"}
+	_, err := cache.get(failing, func() (onepassword.Item, error) { return onepassword.Item{}, fmt.Errorf("timed out") })
+	if err == nil {
+		t.Fatal("Expected the failed lookup to be returned")
+	}
+	_, _ = cache.get(failing, fetch)
+	if fetches != 3 {
+		t.Errorf("Expected a failed lookup to be fetched again, got %d fetches", fetches)
+	}
+
+	// Without a cache every lookup fetches
+	var none *ItemCache
+	if itemCacheFrom(WithItemCache(context.Background(), none)) != nil {
+		t.Error("Expected no cache in the context")
+	}
+	_, _ = none.get(database, fetch)
+	_, _ = none.get(database, fetch)
+	if fetches != 5 {
+		t.Errorf("Expected uncached lookups to fetch every time, got %d fetches", fetches)
+	}
+}
@@ -0,0 +1,100 @@
+package onepass
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// NetworkOptions route requests to 1Password through a proxy and trust extra
+// certificate authorities, for networks where all egress is intercepted
+type NetworkOptions struct {
+	// Proxy is an http:// or https:// URL used for every request; empty
+	// keeps HTTPS_PROXY and NO_PROXY from the environment
+	Proxy string
+	// CABundle is a PEM file of CAs trusted in addition to the system pool;
+	// empty falls back to OPNIX_CA_BUNDLE
+	CABundle string
+}
+
+// ParseProxyURL checks that proxy is an absolute http(s) URL
+func ParseProxyURL(proxy string) (*url.URL, error) {
+	parsed, err := url.Parse(proxy)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, errors.ValidationError(
+			"Parsing proxy URL",
+			"proxy",
+			proxy,
+			"http:// or https:// URL with a host, e.g. http://proxy.internal:3128",
+		)
+	}
+	return parsed, nil
+}
+
+// applyNetworkOptions installs the options on net/http's default client,
+// which the SDK sends all of its requests through. Without options the
+// default transport is left alone and still honors HTTPS_PROXY.
+func applyNetworkOptions(options NetworkOptions) error {
+	if options.CABundle == "" {
+		options.CABundle = os.Getenv("OPNIX_CA_BUNDLE")
+	}
+	if options.Proxy == "" && options.CABundle == "" {
+		return nil
+	}
+
+	base, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return errors.OnePasswordError(
+			"Configuring network access",
+			"The default HTTP transport was replaced, so proxy and CA settings cannot be applied",
+			nil,
+		)
+	}
+	transport := base.Clone()
+
+	if options.Proxy != "" {
+		proxy, err := ParseProxyURL(options.Proxy)
+		if err != nil {
+			return err
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if options.CABundle != "" {
+		pool, err := caPool(options.CABundle)
+		if err != nil {
+			return err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+
+	http.DefaultClient.Transport = transport
+	return nil
+}
+
+// caPool returns the system roots plus every certificate in bundle
+func caPool(bundle string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(bundle)
+	if err != nil {
+		return nil, errors.FileOperationError("Loading CA bundle", bundle, "Failed to read CA bundle", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.FileOperationError(
+			"Loading CA bundle",
+			bundle,
+			fmt.Sprintf("No PEM certificates found in %s", bundle),
+			nil,
+		)
+	}
+	return pool, nil
+}
@@ -0,0 +1,95 @@
+package onepass
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCA writes a self-signed CA certificate as PEM
+func writeTestCA(t *testing.T, path string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Proxy CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+}
+
+func TestParseProxyURL(t *testing.T) {
+	for proxy, valid := range map[string]bool{
+		"http://proxy.internal:3128":      true,
+		"https://user:pw@proxy.internal":  true,
+		"proxy.internal:3128":             false,
+		"socks5://proxy.internal:1080":    false,
+		"http://":                         false,
+		"http://proxy.internal:3128/%zz?": false,
+	} {
+		if _, err := ParseProxyURL(proxy); (err == nil) != valid {
+			t.Errorf("ParseProxyURL(%q) error = %v, want valid %v", proxy, err, valid)
+		}
+	}
+}
+
+func TestApplyNetworkOptions(t *testing.T) {
+	original := http.DefaultClient.Transport
+	t.Cleanup(func() { http.DefaultClient.Transport = original })
+	t.Setenv("OPNIX_CA_BUNDLE", "")
+
+	if err := applyNetworkOptions(NetworkOptions{}); err != nil || http.DefaultClient.Transport != original {
+		t.Fatalf("Expected no options to leave the transport alone, got %v", err)
+	}
+
+	bundle := filepath.Join(t.TempDir(), "proxy-ca.pem")
+	writeTestCA(t, bundle)
+	t.Setenv("OPNIX_CA_BUNDLE", bundle)
+
+	if err := applyNetworkOptions(NetworkOptions{Proxy: "http://proxy.internal:3128"}); err != nil {
+		t.Fatalf("Failed to apply network options: %v", err)
+	}
+	transport, ok := http.DefaultClient.Transport.(*http.Transport)
+	if !ok {
+		t.Fatalf("Expected an *http.Transport, got %T", http.DefaultClient.Transport)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, "https://my.1password.com", nil)
+	proxy, err := transport.Proxy(request)
+	if err != nil || proxy == nil || proxy.Host != "proxy.internal:3128" {
+		t.Errorf("Expected requests to use the configured proxy, got %v, %v", proxy, err)
+	}
+	if transport.TLSClientConfig == nil || transport.TLSClientConfig.RootCAs == nil {
+		t.Error("Expected the CA bundle from OPNIX_CA_BUNDLE to be trusted")
+	}
+
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate\n"), 0644); err != nil {
+		t.Fatalf("Failed to write bundle: %v", err)
+	}
+	if err := applyNetworkOptions(NetworkOptions{CABundle: empty}); err == nil {
+		t.Error("Expected a bundle without certificates to be rejected")
+	}
+}
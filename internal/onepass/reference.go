@@ -0,0 +1,208 @@
+package onepass
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// idPattern matches the 26 character identifiers 1Password assigns to vaults and items
+var idPattern = regexp.MustCompile(`^[a-z0-9]{26}$`)
+
+// indexPattern matches a field with a list index, e.g. recoveryCodes[2]
+var indexPattern = regexp.MustCompile(`^(.+)\[([0-9]+)\]$`)
+
+// Reference is a parsed op://Vault/Item/[Section/]field secret reference.
+// The vault segment may carry a qualifier after '@' for vault names that are
+// not unique: a vault ID (op://Prod@<id>/...) or a named account from the
+// config (op://Prod@work/...).
+type Reference struct {
+	Vault   string
+	Item    string
+	Section string
+	Field   string
+	Query   string
+	// VaultID and Account hold the vault segment's qualifier; at most one is set
+	VaultID string
+	Account string
+	// Set when the vault or item segment is an ID rather than a name
+	VaultIsID bool
+	ItemIsID  bool
+	// Index selects one entry of a list field, e.g. recoveryCodes[2], and
+	// only applies when Indexed is set
+	Index   int
+	Indexed bool
+}
+
+// IsID reports whether a reference segment is shaped like a 1Password vault or item ID.
+// IDs are rename-proof, so name-based lookups and suggestions don't apply to them.
+func IsID(segment string) bool {
+	return idPattern.MatchString(segment)
+}
+
+// ParseReference splits a 1Password secret reference into its components
+func ParseReference(reference string) (Reference, error) {
+	invalid := errors.ValidationError(
+		"Parsing 1Password reference",
+		"reference",
+		reference,
+		"op://Vault/Item/field or op://Vault/Item/Section/field",
+	)
+
+	if !strings.HasPrefix(reference, "op://") {
+		return Reference{}, invalid
+	}
+
+	rest := strings.TrimPrefix(reference, "op://")
+	var query string
+	if idx := strings.Index(rest, "?"); idx != -1 {
+		rest, query = rest[:idx], rest[idx+1:]
+	}
+
+	parts := strings.Split(rest, "/")
+	if len(parts) < 3 {
+		return Reference{}, invalid
+	}
+
+	vault, qualifier := splitVaultQualifier(parts[0])
+	ref := Reference{
+		Vault: vault,
+		Item:  parts[1],
+		Field: parts[len(parts)-1],
+		Query: query,
+
+		VaultIsID: IsID(vault),
+		ItemIsID:  IsID(parts[1]),
+	}
+	if IsID(qualifier) {
+		ref.VaultID = qualifier
+	} else {
+		ref.Account = qualifier
+	}
+	if len(parts) > 3 {
+		ref.Section = strings.Join(parts[2:len(parts)-1], "/")
+	}
+	if match := indexPattern.FindStringSubmatch(ref.Field); match != nil {
+		index, err := strconv.Atoi(match[2])
+		if err != nil {
+			return Reference{}, invalid
+		}
+		ref.Field, ref.Index, ref.Indexed = match[1], index, true
+	}
+
+	if ref.Vault == "" || ref.Item == "" || ref.Field == "" {
+		return Reference{}, invalid
+	}
+
+	return ref, nil
+}
+
+// splitVaultQualifier splits "name@qualifier" at the last '@'. A segment
+// without one, or with nothing on either side of it, is a plain vault name.
+func splitVaultQualifier(segment string) (string, string) {
+	idx := strings.LastIndex(segment, "@")
+	if idx <= 0 || idx == len(segment)-1 {
+		return segment, ""
+	}
+	return segment[:idx], segment[idx+1:]
+}
+
+// VaultKey returns the most precise identifier of the vault: the qualifying
+// ID when there is one, otherwise the vault segment's name or ID
+func (r Reference) VaultKey() string {
+	if r.VaultID != "" {
+		return r.VaultID
+	}
+	return r.Vault
+}
+
+// Resolvable returns the reference in the form 1Password resolves, with the
+// qualifying vault ID in place of the name and the qualifier dropped
+func (r Reference) Resolvable() Reference {
+	r.Vault = r.VaultKey()
+	r.VaultIsID = IsID(r.Vault)
+	r.VaultID, r.Account = "", ""
+	return r
+}
+
+// sdkReference returns reference as the SDK resolves it: without vault
+// qualifier or list index. Unparseable references are passed on unchanged
+// for 1Password to reject.
+func sdkReference(reference string) string {
+	ref, err := ParseReference(reference)
+	if err != nil {
+		return reference
+	}
+	return ref.Resolvable().Unindexed().String()
+}
+
+// String formats the reference back into op:// form
+func (r Reference) String() string {
+	vault := r.Vault
+	if qualifier := r.VaultID + r.Account; qualifier != "" {
+		vault += "@" + qualifier
+	}
+	parts := []string{vault, r.Item}
+	if r.Section != "" {
+		parts = append(parts, r.Section)
+	}
+	if r.Indexed {
+		parts = append(parts, fmt.Sprintf("%s[%d]", r.Field, r.Index))
+	} else {
+		parts = append(parts, r.Field)
+	}
+
+	s := "op://" + strings.Join(parts, "/")
+	if r.Query != "" {
+		s += "?" + r.Query
+	}
+	return s
+}
+
+// WithField returns a copy of the reference pointing at another field of the same item
+func (r Reference) WithField(field string) Reference {
+	r.Field = field
+	return r
+}
+
+// Unindexed returns the reference to the whole list field, which is what
+// 1Password resolves
+func (r Reference) Unindexed() Reference {
+	r.Index, r.Indexed = 0, false
+	return r
+}
+
+// SelectEntry picks the indexed entry from a list field's value. Entries are
+// separated by newlines or commas, trimmed of surrounding whitespace and
+// counted from 0; blank entries are skipped. Spaces inside an entry are kept,
+// so recovery phrases survive whole.
+func (r Reference) SelectEntry(value string) (string, error) {
+	var entries []string
+	for _, entry := range strings.FieldsFunc(value, func(c rune) bool {
+		return c == ',' || c == '\n'
+	}) {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	if r.Index >= len(entries) {
+		suggestion := "The field is empty"
+		if len(entries) > 0 {
+			suggestion = fmt.Sprintf("Use an index from 0 to %d", len(entries)-1)
+		}
+		return "", &errors.OpnixError{
+			Operation: "Selecting list entry",
+			Component: "1Password integration",
+			Issue: fmt.Sprintf("Index %d is out of range: %s holds %d entries",
+				r.Index, r.Unindexed().String(), len(entries)),
+			Suggestions: []string{
+				suggestion,
+				"Entries are separated by newlines or commas",
+			},
+		}
+	}
+	return entries[r.Index], nil
+}
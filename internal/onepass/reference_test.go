@@ -0,0 +1,193 @@
+package onepass
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name      string
+		reference string
+		want      Reference
+		wantError bool
+	}{
+		{
+			name:      "simple reference",
+			reference: "op://Homelab/Database/password",
+			want:      Reference{Vault: "Homelab", Item: "Database", Field: "password"},
+		},
+		{
+			name:      "sectioned reference",
+			reference: "op://Homelab/Cloudflare/rgbr.ink/cert",
+			want:      Reference{Vault: "Homelab", Item: "Cloudflare", Section: "rgbr.ink", Field: "cert"},
+		},
+		{
+			name:      "reference with query",
+			reference: "op://Homelab/SSH/private key?ssh-format=openssh",
+			want:      Reference{Vault: "Homelab", Item: "SSH", Field: "private key", Query: "ssh-format=openssh"},
+		},
+		{
+			name:      "vault ID reference",
+			reference: "op://abcdefghijklmnopqrstuvwxyz/Database/password",
+			want:      Reference{Vault: "abcdefghijklmnopqrstuvwxyz", Item: "Database", Field: "password", VaultIsID: true},
+		},
+		{
+			name:      "vault and item ID reference",
+			reference: "op://7xkq2mzv4bnlpd3rtyw8hc5ej6/q4n7ro2yjv5xk3bm8tz6wpa9lc/password",
+			want: Reference{
+				Vault: "7xkq2mzv4bnlpd3rtyw8hc5ej6", Item: "q4n7ro2yjv5xk3bm8tz6wpa9lc", Field: "password",
+				VaultIsID: true, ItemIsID: true,
+			},
+		},
+		{
+			name:      "name that is not ID shaped",
+			reference: "op://Production-Vault-Name-2024/Database/password",
+			want:      Reference{Vault: "Production-Vault-Name-2024", Item: "Database", Field: "password"},
+		},
+		{
+			name:      "indexed list field",
+			reference: "op://Homelab/GitHub/recoveryCodes[2]",
+			want:      Reference{Vault: "Homelab", Item: "GitHub", Field: "recoveryCodes", Index: 2, Indexed: true},
+		},
+		{
+			name:      "brackets that are not an index",
+			reference: "op://Homelab/GitHub/codes[old]",
+			want:      Reference{Vault: "Homelab", Item: "GitHub", Field: "codes[old]"},
+		},
+		{
+			name:      "vault qualified by ID",
+			reference: "op://Production@7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password",
+			want:      Reference{Vault: "Production", VaultID: "7xkq2mzv4bnlpd3rtyw8hc5ej6", Item: "Database", Field: "password"},
+		},
+		{
+			name:      "vault qualified by account",
+			reference: "op://Production@work/Database/password",
+			want:      Reference{Vault: "Production", Account: "work", Item: "Database", Field: "password"},
+		},
+		{
+			name:      "trailing at is part of the name",
+			reference: "op://Production@/Database/password",
+			want:      Reference{Vault: "Production@", Item: "Database", Field: "password"},
+		},
+		{
+			name:      "missing prefix",
+			reference: "Homelab/Database/password",
+			wantError: true,
+		},
+		{
+			name:      "too few parts",
+			reference: "op://Homelab/Database",
+			wantError: true,
+		},
+		{
+			name:      "empty field",
+			reference: "op://Homelab/Database/",
+			wantError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReference(tt.reference)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %+v, got %+v", tt.want, got)
+			}
+			if got.String() != tt.reference {
+				t.Errorf("Expected round-trip %q, got %q", tt.reference, got.String())
+			}
+		})
+	}
+}
+
+func TestReferenceResolvable(t *testing.T) {
+	tests := map[string]string{
+		"op://Production@7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password": "op://7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password",
+		"op://Production@work/Database/password":                       "op://Production/Database/password",
+		"op://Production@work/GitHub/recoveryCodes[2]":                 "op://Production/GitHub/recoveryCodes",
+		"op://Homelab/Database/password":                               "op://Homelab/Database/password",
+		"not a reference":                                              "not a reference",
+	}
+	for reference, want := range tests {
+		if got := sdkReference(reference); got != want {
+			t.Errorf("sdkReference(%q) = %q, want %q", reference, got, want)
+		}
+	}
+
+	ref, _ := ParseReference("op://Production@7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password")
+	if ref.VaultKey() != "7xkq2mzv4bnlpd3rtyw8hc5ej6" {
+		t.Errorf("Expected the qualifying ID to be the vault key, got %q", ref.VaultKey())
+	}
+}
+
+func TestReferenceWithField(t *testing.T) {
+	ref, err := ParseReference("op://Homelab/Cloudflare/rgbr.ink/credential?attribute=otp")
+	if err != nil {
+		t.Fatalf("ParseReference() error = %v", err)
+	}
+
+	got := ref.WithField("password").String()
+	want := "op://Homelab/Cloudflare/rgbr.ink/password?attribute=otp"
+	if got != want {
+		t.Errorf("WithField() = %q, want %q", got, want)
+	}
+	if ref.Field != "credential" {
+		t.Errorf("WithField() modified the original reference: %q", ref.Field)
+	}
+}
+
+func TestReferenceSelectEntry(t *testing.T) {
+	ref, err := ParseReference("op://Homelab/GitHub/recoveryCodes[2]")
+	if err != nil {
+		t.Fatalf("ParseReference() error = %v", err)
+	}
+	if got := ref.Unindexed().String(); got != "op://Homelab/GitHub/recoveryCodes" {
+		t.Errorf("Unindexed() = %q", got)
+	}
+
+	for _, value := range []string{"aaaa-1111\nbbbb-2222\ncccc-3333\n", "aaaa-1111, bbbb-2222, cccc-3333"} {
+		if got, err := ref.SelectEntry(value); err != nil || got != "cccc-3333" {
+			t.Errorf("SelectEntry(%q) = %q, %v, want the third entry", value, got, err)
+		}
+	}
+
+	// Spaces inside an entry belong to it
+	if got, err := ref.SelectEntry("red apple\r\nblue sky\r\n\r\ngreen leaf tree\r\n"); err != nil || got != "green leaf tree" {
+		t.Errorf("SelectEntry() = %q, %v, want the whole third phrase", got, err)
+	}
+
+	_, err = ref.SelectEntry("aaaa-1111\nbbbb-2222")
+	if err == nil || !strings.Contains(err.Error(), "Index 2 is out of range") || !strings.Contains(err.Error(), "holds 2 entries") {
+		t.Errorf("Expected an out-of-range error naming the entry count, got %v", err)
+	}
+	if err != nil && strings.Contains(err.Error(), "aaaa-1111") {
+		t.Errorf("Out-of-range error must not include values: %v", err)
+	}
+}
+
+func TestReferencedVaults(t *testing.T) {
+	vaults, err := ReferencedVaults([]string{
+		"op://Homelab/Database/password",
+		"op://Backups/S3/key",
+		"op://Homelab/Api/credential",
+	})
+	if err != nil {
+		t.Fatalf("Failed to collect vaults: %v", err)
+	}
+	if strings.Join(vaults, ",") != "Backups,Homelab" {
+		t.Errorf("Expected distinct sorted vaults, got %v", vaults)
+	}
+
+	if _, err := ReferencedVaults([]string{"Homelab/Database/password"}); err == nil {
+		t.Error("Expected an invalid reference to fail")
+	}
+}
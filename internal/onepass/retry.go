@@ -0,0 +1,59 @@
+package onepass
+
+import "strings"
+
+// DefaultRetryablePatterns match the wording of failures that usually clear
+// up on their own: rate limiting, timeouts and dropped connections
+var DefaultRetryablePatterns = []string{
+	"rate limit",
+	"too many requests",
+	"timeout",
+	"timed out",
+	"deadline exceeded",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"unexpected eof",
+	"temporary failure",
+	"service unavailable",
+	"bad gateway",
+}
+
+// RetryClassifier decides whether a failed request is worth retrying by
+// matching its message against case-insensitive substrings. The SDK's error
+// types vary between versions, so the wording is all there is to go on.
+type RetryClassifier struct {
+	patterns []string
+}
+
+// NewRetryClassifier classifies with the defaults plus extra patterns, so
+// operators can follow changes in 1Password's error wording
+func NewRetryClassifier(extra []string) RetryClassifier {
+	patterns := make([]string, 0, len(DefaultRetryablePatterns)+len(extra))
+	for _, pattern := range append(append([]string{}, DefaultRetryablePatterns...), extra...) {
+		if pattern = strings.ToLower(strings.TrimSpace(pattern)); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return RetryClassifier{patterns: patterns}
+}
+
+// Retryable reports whether err matches any pattern. A zero classifier
+// uses the defaults. A rejected token is never retried.
+func (c RetryClassifier) Retryable(err error) bool {
+	if err == nil || IsUnauthorizedError(err) {
+		return false
+	}
+	patterns := c.patterns
+	if patterns == nil {
+		patterns = DefaultRetryablePatterns
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, pattern := range patterns {
+		if strings.Contains(message, pattern) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,41 @@
+package onepass
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestRetryClassifier(t *testing.T) {
+	defaults := NewRetryClassifier(nil)
+	tuned := NewRetryClassifier([]string{"  Item Is Locked  ", ""})
+
+	for _, tt := range []struct {
+		err          error
+		retryable    bool
+		tunedRetries bool
+	}{
+		{err: fmt.Errorf("error resolving secret reference: rate limit exceeded"), retryable: true, tunedRetries: true},
+		{err: fmt.Errorf("429 Too Many Requests"), retryable: true, tunedRetries: true},
+		{err: fmt.Errorf("Get \"https://my.1password.com/api/v1/vaults\": dial tcp 1.2.3.4:443: i/o timeout"), retryable: true, tunedRetries: true},
+		{err: fmt.Errorf("read tcp 10.0.0.2:51234->1.2.3.4:443: read: connection reset by peer"), retryable: true, tunedRetries: true},
+		{err: fmt.Errorf("dial tcp: lookup my.1password.com: Temporary failure in name resolution"), retryable: true, tunedRetries: true},
+		{err: fmt.Errorf("resolve failed: %w", context.DeadlineExceeded), retryable: true, tunedRetries: true},
+		{err: fmt.Errorf("503 Service Unavailable"), retryable: true, tunedRetries: true},
+		{err: fmt.Errorf("error resolving secret reference: no item matched the secret reference query"), retryable: false},
+		{err: fmt.Errorf("invalid service account token, please make sure you provide a valid token"), retryable: false},
+		{err: fmt.Errorf("ITEM IS LOCKED by another session"), retryable: false, tunedRetries: true},
+		{err: nil, retryable: false},
+	} {
+		if got := defaults.Retryable(tt.err); got != tt.retryable {
+			t.Errorf("default Retryable(%v) = %v, want %v", tt.err, got, tt.retryable)
+		}
+		if got := tuned.Retryable(tt.err); got != tt.tunedRetries {
+			t.Errorf("tuned Retryable(%v) = %v, want %v", tt.err, got, tt.tunedRetries)
+		}
+	}
+
+	if !(RetryClassifier{}).Retryable(fmt.Errorf("rate limit")) {
+		t.Error("Expected the zero classifier to use the defaults")
+	}
+}
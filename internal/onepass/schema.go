@@ -0,0 +1,162 @@
+package onepass
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Schema is a names-only snapshot of the vaults, items and fields a token can
+// access. It never contains field values and allows offline validation.
+type Schema struct {
+	GeneratedAt time.Time     `json:"generatedAt"`
+	Vaults      []SchemaVault `json:"vaults"`
+}
+
+// SchemaVault describes a vault in a schema snapshot
+type SchemaVault struct {
+	ID    string       `json:"id"`
+	Title string       `json:"title"`
+	Items []SchemaItem `json:"items"`
+}
+
+// SchemaItem describes an item in a schema snapshot
+type SchemaItem struct {
+	ID     string        `json:"id"`
+	Title  string        `json:"title"`
+	Fields []SchemaField `json:"fields"`
+}
+
+// SchemaField describes a field in a schema snapshot
+type SchemaField struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Section string `json:"section,omitempty"`
+}
+
+// ExportSchema walks every accessible vault and item and records their names
+func (c *Client) ExportSchema(ctx context.Context) (*Schema, error) {
+	vaults, err := c.client.Vaults().List(ctx)
+	if err != nil {
+		return nil, onePasswordError(
+			"Exporting vault schema",
+			"Failed to list vaults accessible to the service account token",
+			err,
+		)
+	}
+
+	schema := &Schema{GeneratedAt: time.Now().UTC()}
+	for _, vault := range vaults {
+		overviews, err := c.client.Items().List(ctx, vault.ID)
+		if err != nil {
+			return nil, onePasswordError(
+				"Exporting vault schema",
+				fmt.Sprintf("Failed to list items in vault: %s", vault.Title),
+				err,
+			)
+		}
+
+		schemaVault := SchemaVault{ID: vault.ID, Title: vault.Title}
+		for _, overview := range overviews {
+			item, err := c.client.Items().Get(ctx, vault.ID, overview.ID)
+			if err != nil {
+				return nil, onePasswordError(
+					"Exporting vault schema",
+					fmt.Sprintf("Failed to read item metadata: %s/%s", vault.Title, overview.Title),
+					err,
+				)
+			}
+
+			sections := make(map[string]string, len(item.Sections))
+			for _, section := range item.Sections {
+				sections[section.ID] = section.Title
+			}
+
+			schemaItem := SchemaItem{ID: item.ID, Title: item.Title}
+			for _, field := range item.Fields {
+				schemaField := SchemaField{ID: field.ID, Title: field.Title}
+				if field.SectionID != nil {
+					schemaField.Section = sections[*field.SectionID]
+				}
+				schemaItem.Fields = append(schemaItem.Fields, schemaField)
+			}
+			schemaVault.Items = append(schemaVault.Items, schemaItem)
+		}
+		schema.Vaults = append(schema.Vaults, schemaVault)
+	}
+
+	return schema, nil
+}
+
+// LoadSchema reads a schema snapshot written by ExportSchema
+func LoadSchema(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.FileOperationError(
+			"Loading vault schema",
+			path,
+			"Failed to read schema snapshot",
+			err,
+		)
+	}
+
+	var schema Schema
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, errors.ConfigError(
+			"Parsing vault schema",
+			"Invalid JSON format in schema snapshot",
+			err,
+		)
+	}
+
+	return &schema, nil
+}
+
+// CheckReference verifies that the vault, item and field named by reference
+// exist in the snapshot. Names and IDs are both accepted.
+func (s *Schema) CheckReference(reference string) error {
+	ref, err := ParseReference(reference)
+	if err != nil {
+		return err
+	}
+
+	var vault *SchemaVault
+	for i := range s.Vaults {
+		if s.Vaults[i].ID == ref.VaultKey() || (ref.VaultID == "" && s.Vaults[i].Title == ref.Vault) {
+			vault = &s.Vaults[i]
+			break
+		}
+	}
+	if vault == nil {
+		return fmt.Errorf("vault %q not found in schema snapshot", ref.Vault)
+	}
+
+	var item *SchemaItem
+	for i := range vault.Items {
+		if vault.Items[i].Title == ref.Item || vault.Items[i].ID == ref.Item {
+			item = &vault.Items[i]
+			break
+		}
+	}
+	if item == nil {
+		return fmt.Errorf("item %q not found in vault %q", ref.Item, vault.Title)
+	}
+
+	for _, field := range item.Fields {
+		if field.Title != ref.Field && field.ID != ref.Field {
+			continue
+		}
+		if ref.Section == "" || field.Section == ref.Section {
+			return nil
+		}
+	}
+
+	if ref.Section != "" {
+		return fmt.Errorf("field %q not found in section %q of item %q", ref.Field, ref.Section, item.Title)
+	}
+	return fmt.Errorf("field %q not found in item %q", ref.Field, item.Title)
+}
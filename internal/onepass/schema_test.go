@@ -0,0 +1,93 @@
+package onepass
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testSchema() *Schema {
+	return &Schema{
+		Vaults: []SchemaVault{
+			{
+				ID:    "vault-id-1",
+				Title: "Homelab",
+				Items: []SchemaItem{
+					{
+						ID:    "item-id-1",
+						Title: "Database",
+						Fields: []SchemaField{
+							{ID: "username", Title: "username"},
+							{ID: "password", Title: "password"},
+						},
+					},
+					{
+						ID:    "item-id-2",
+						Title: "Cloudflare",
+						Fields: []SchemaField{
+							{ID: "f1", Title: "cert", Section: "rgbr.ink"},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestSchemaCheckReference(t *testing.T) {
+	schema := testSchema()
+
+	tests := []struct {
+		name      string
+		reference string
+		errorText string
+	}{
+		{name: "existing field", reference: "op://Homelab/Database/password"},
+		{name: "ids instead of names", reference: "op://vault-id-1/item-id-1/password"},
+		{name: "sectioned field", reference: "op://Homelab/Cloudflare/rgbr.ink/cert"},
+		{name: "missing vault", reference: "op://Personal/Database/password", errorText: "vault"},
+		{name: "missing item", reference: "op://Homelab/Redis/password", errorText: "item"},
+		{name: "missing field", reference: "op://Homelab/Database/token", errorText: "field"},
+		{name: "wrong section", reference: "op://Homelab/Cloudflare/other.ink/cert", errorText: "section"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.CheckReference(tt.reference)
+			if tt.errorText == "" {
+				if err != nil {
+					t.Errorf("Expected no error, got: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorText) {
+				t.Errorf("Expected error mentioning %q, got: %v", tt.errorText, err)
+			}
+		})
+	}
+}
+
+func TestLoadSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schema.json")
+	data, err := json.Marshal(testSchema())
+	if err != nil {
+		t.Fatalf("Failed to marshal schema: %v", err)
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatalf("Failed to write schema: %v", err)
+	}
+
+	schema, err := LoadSchema(path)
+	if err != nil {
+		t.Fatalf("Failed to load schema: %v", err)
+	}
+	if len(schema.Vaults) != 1 || len(schema.Vaults[0].Items) != 2 {
+		t.Errorf("Unexpected schema contents: %+v", schema)
+	}
+
+	if _, err := LoadSchema(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for missing schema file")
+	}
+}
@@ -0,0 +1,73 @@
+package onepass
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// maxTokenSize bounds what is read from a token descriptor; service account
+// tokens are a few kilobytes
+const maxTokenSize = 64 * 1024
+
+// fdToken is the token read by SetTokenFD, which GetToken prefers
+var fdToken string
+
+// SetTokenFD reads the service account token from the open file descriptor
+// fd, such as a pipe a wrapper set up before exec'ing opnix, so it never
+// appears in the environment or arguments. GetToken returns it ahead of
+// OP_SERVICE_ACCOUNT_TOKEN and the token file for the rest of the process.
+func SetTokenFD(fd int) error {
+	token, err := ReadTokenFD(fd)
+	if err != nil {
+		return err
+	}
+	fdToken = token
+	return nil
+}
+
+// ReadTokenFD reads a token from fd up to EOF and closes it
+func ReadTokenFD(fd int) (string, error) {
+	source := fmt.Sprintf("file descriptor %d", fd)
+	tokenFDError := func(issue string, cause error) error {
+		return &errors.OpnixError{
+			Operation: "Reading token from " + source,
+			Component: "authentication",
+			Issue:     issue,
+			Cause:     cause,
+			Suggestions: []string{
+				"Open the descriptor for reading before starting opnix, e.g. opnix secret -token-fd 3 3< <(get-token)",
+				"Write the token and close the writing end, so opnix sees the end of input",
+			},
+		}
+	}
+
+	if fd < 0 {
+		return "", tokenFDError("A file descriptor cannot be negative", nil)
+	}
+	file := os.NewFile(uintptr(fd), source)
+	if file == nil {
+		return "", tokenFDError("Not a valid file descriptor", nil)
+	}
+	defer file.Close()
+	if _, err := file.Stat(); err != nil {
+		return "", tokenFDError("The file descriptor is not open", err)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxTokenSize+1))
+	if err != nil {
+		return "", tokenFDError("Failed to read the token; the descriptor must be open for reading", err)
+	}
+	if len(data) > maxTokenSize {
+		return "", tokenFDError(fmt.Sprintf("More than %d bytes were sent, which is not a service account token", maxTokenSize), nil)
+	}
+
+	token := strings.TrimSpace(string(data))
+	if token == "" {
+		return "", tokenFDError("The token is empty", nil)
+	}
+	return token, nil
+}
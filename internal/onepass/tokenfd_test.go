@@ -0,0 +1,57 @@
+package onepass
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestReadTokenFD(t *testing.T) {
+	// pipe returns a descriptor of a pipe holding data; ReadTokenFD closes it
+	pipe := func(data string) int {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatalf("Failed to create pipe: %v", err)
+		}
+		go func() {
+			_, _ = w.WriteString(data)
+			_ = w.Close()
+		}()
+		fd, err := syscall.Dup(int(r.Fd()))
+		if err != nil {
+			t.Fatalf("Failed to duplicate descriptor: %v", err)
+		}
+		_ = r.Close()
+		return fd
+	}
+
+	token, err := ReadTokenFD(pipe("ops_token\n"))
+	if err != nil {
+		t.Fatalf("ReadTokenFD() error = %v", err)
+	}
+	if token != "ops_token" {
+		t.Errorf("ReadTokenFD() = %q, want ops_token", token)
+	}
+
+	if _, err := ReadTokenFD(pipe(" \n")); err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("Expected an empty token to fail, got %v", err)
+	}
+	if _, err := ReadTokenFD(-1); err == nil {
+		t.Error("Expected a negative descriptor to fail")
+	}
+	if _, err := ReadTokenFD(pipe(strings.Repeat("x", maxTokenSize+1))); err == nil {
+		t.Error("Expected an oversized token to fail")
+	}
+}
+
+func TestGetTokenPrefersTokenFD(t *testing.T) {
+	t.Setenv("OP_SERVICE_ACCOUNT_TOKEN", "from-env")
+	defer func() { fdToken = "" }()
+
+	fdToken = "from-fd"
+	token, err := GetToken("")
+	if err != nil || token != "from-fd" {
+		t.Errorf("GetToken() = %q, %v; want the descriptor's token", token, err)
+	}
+}
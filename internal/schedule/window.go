@@ -0,0 +1,146 @@
+// Package schedule parses the maintenance windows service restarts are
+// allowed in
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Window is a weekly recurring time range in local time, written as
+// "[days ]HH:MM-HH:MM": "02:00-04:00", "Sat 02:00-06:00", "Mon-Fri 22:00-06:00"
+// or "Sat,Sun 00:00-24:00". A range ending before it starts crosses midnight
+// and belongs to the day it starts on.
+type Window struct {
+	spec string
+	// days the window opens on, indexed by time.Weekday
+	days [7]bool
+	// minutes after midnight
+	start, end int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Parse parses a window spec
+func Parse(spec string) (Window, error) {
+	w := Window{spec: spec}
+	fields := strings.Fields(spec)
+	var times string
+	switch len(fields) {
+	case 1:
+		times = fields[0]
+		for day := range w.days {
+			w.days[day] = true
+		}
+	case 2:
+		if err := w.parseDays(fields[0]); err != nil {
+			return Window{}, err
+		}
+		times = fields[1]
+	default:
+		return Window{}, fmt.Errorf("expected \"[days ]HH:MM-HH:MM\", got %q", spec)
+	}
+
+	from, to, ok := strings.Cut(times, "-")
+	if !ok {
+		return Window{}, fmt.Errorf("expected a time range like 02:00-04:00, got %q", times)
+	}
+	var err error
+	if w.start, err = parseClock(from, false); err != nil {
+		return Window{}, err
+	}
+	if w.end, err = parseClock(to, true); err != nil {
+		return Window{}, err
+	}
+	if w.start == w.end {
+		return Window{}, fmt.Errorf("%q is empty; use 00:00-24:00 for a whole day", times)
+	}
+	return w, nil
+}
+
+// parseDays reads comma-separated days and day ranges such as "Mon-Fri,Sun";
+// a range may wrap around the week ("Fri-Mon")
+func (w *Window) parseDays(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdays[strings.ToLower(from)]
+		if !ok {
+			return fmt.Errorf("unknown day %q, expected Mon, Tue, Wed, Thu, Fri, Sat or Sun", from)
+		}
+		last := first
+		if isRange {
+			if last, ok = weekdays[strings.ToLower(to)]; !ok {
+				return fmt.Errorf("unknown day %q, expected Mon, Tue, Wed, Thu, Fri, Sat or Sun", to)
+			}
+		}
+		for day := first; ; day = (day + 1) % 7 {
+			w.days[day] = true
+			if day == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// parseClock reads HH:MM as minutes after midnight; 24:00 is allowed as an end
+func parseClock(clock string, end bool) (int, error) {
+	hours, minutes, ok := strings.Cut(clock, ":")
+	h, herr := strconv.Atoi(hours)
+	m, merr := strconv.Atoi(minutes)
+	if !ok || herr != nil || merr != nil || len(minutes) != 2 || h < 0 || m < 0 || m > 59 ||
+		h > 24 || (h == 24 && (m != 0 || !end)) {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM between 00:00 and 24:00", clock)
+	}
+	return h*60 + m, nil
+}
+
+// String returns the spec the window was parsed from
+func (w Window) String() string {
+	return w.spec
+}
+
+// Contains reports whether t falls inside the window
+func (w Window) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	// Crosses midnight: either the evening part or yesterday's morning part
+	if minute >= w.start {
+		return w.days[day]
+	}
+	return minute < w.end && w.days[(day+6)%7]
+}
+
+// Open reports whether t falls inside any of windows; no windows means always
+func Open(windows []Window, t time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, w := range windows {
+		if w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the first minute after t at which a window is open, or
+// false when none opens within a week
+func NextOpen(windows []Window, t time.Time) (time.Time, bool) {
+	next := t.Truncate(time.Minute)
+	for i := 0; i <= 7*24*60; i++ {
+		next = next.Add(time.Minute)
+		if Open(windows, next) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,87 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+// at returns the given weekday and clock time in the week of 2024-01-01 (a Monday)
+func at(day time.Weekday, hour, minute int) time.Time {
+	return time.Date(2024, 1, 1+(int(day)+6)%7, hour, minute, 0, 0, time.Local)
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		spec string
+		in   []time.Time
+		out  []time.Time
+	}{
+		{
+			spec: "02:00-04:00",
+			in:   []time.Time{at(time.Monday, 2, 0), at(time.Sunday, 3, 59)},
+			out:  []time.Time{at(time.Monday, 4, 0), at(time.Monday, 1, 59)},
+		},
+		{
+			spec: "Sat 02:00-06:00",
+			in:   []time.Time{at(time.Saturday, 5, 30)},
+			out:  []time.Time{at(time.Sunday, 5, 30), at(time.Saturday, 6, 0)},
+		},
+		{
+			spec: "Mon-Fri 22:00-06:00",
+			in:   []time.Time{at(time.Friday, 23, 0), at(time.Saturday, 5, 0), at(time.Tuesday, 1, 0)},
+			out:  []time.Time{at(time.Monday, 1, 0), at(time.Saturday, 23, 0), at(time.Wednesday, 12, 0)},
+		},
+		{
+			spec: "fri-mon,wed 00:00-24:00",
+			in:   []time.Time{at(time.Sunday, 12, 0), at(time.Wednesday, 23, 59), at(time.Monday, 0, 0)},
+			out:  []time.Time{at(time.Tuesday, 12, 0), at(time.Thursday, 0, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		w, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.spec, err)
+		}
+		for _, when := range tt.in {
+			if !w.Contains(when) {
+				t.Errorf("Expected %q to contain %s", tt.spec, when.Format("Mon 15:04"))
+			}
+		}
+		for _, when := range tt.out {
+			if w.Contains(when) {
+				t.Errorf("Expected %q not to contain %s", tt.spec, when.Format("Mon 15:04"))
+			}
+		}
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{"", "02:00", "Someday 02:00-04:00", "2:00-25:00", "02:60-03:00", "24:00-02:00", "03:00-03:00", "Sat 02:00-04:00 UTC"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestNextOpen(t *testing.T) {
+	sat, _ := Parse("Sat 02:00-04:00")
+	nights, _ := Parse("Mon-Fri 22:00-06:00")
+	windows := []Window{sat, nights}
+
+	if !Open(nil, at(time.Tuesday, 12, 0)) {
+		t.Error("Expected no windows to mean always open")
+	}
+	if Open(windows, at(time.Saturday, 12, 0)) {
+		t.Error("Expected Saturday noon to be outside both windows")
+	}
+
+	next, ok := NextOpen(windows, at(time.Saturday, 12, 30))
+	if !ok || !next.Equal(at(time.Monday, 22, 0).AddDate(0, 0, 7)) {
+		t.Errorf("Expected the next window to open Monday 22:00, got %s, %v", next, ok)
+	}
+	next, ok = NextOpen(windows, at(time.Friday, 12, 0))
+	if !ok || !next.Equal(at(time.Friday, 22, 0)) {
+		t.Errorf("Expected the next window to open Friday 22:00, got %s, %v", next, ok)
+	}
+}
@@ -0,0 +1,186 @@
+package secrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// FileSecretClient is implemented by clients that can read file attachments
+// and documents as a reader instead of a string. found is false when the
+// reference names a regular field.
+type FileSecretClient interface {
+	OpenFile(ctx context.Context, reference string) (rc io.ReadCloser, found bool, err error)
+}
+
+// writesAttachment reports whether a secret can be copied from its
+// attachment's reader to disk: it is declared as a file and nothing needs the
+// value as a string
+func writesAttachment(secret config.Secret) bool {
+	return secret.Type == "file" &&
+		secret.Reference != "" &&
+		secret.Template == "" &&
+		!secret.FIFO &&
+		len(secret.Copies) == 0 &&
+		len(secret.ValidateWith) == 0 &&
+		len(secret.Filter) == 0 &&
+		!secret.Canonical &&
+		secret.Region == nil &&
+		len(secret.FieldFallbacks) == 0 &&
+		secret.Extract == "" &&
+		len(secret.Transforms) == 0 &&
+		secret.CertExpiryWarnDays == 0 &&
+		!secret.CertExpiryStrict &&
+		!secret.CredentialEncrypted
+}
+
+// writeAttachment writes a file secret by copying its attachment into a
+// temporary file that is renamed into place, so documents are never turned
+// into strings. The client still holds the whole file in memory. Ownership
+// is applied once the file is in place. It reports false, without error,
+// when the secret has to take the in-memory path instead.
+func (p *Processor) writeAttachment(secret config.Secret, secretName string) (bool, error) {
+	client, err := p.clientFor(secret, secretName)
+	if err != nil {
+		return true, err
+	}
+	files, ok := client.(FileSecretClient)
+	if !ok {
+		return false, nil
+	}
+
+	maxRetries, timeout, err := p.resolveSettings(secret, secretName)
+	if err != nil {
+		return true, err
+	}
+
+	var reader io.ReadCloser
+	var found bool
+	err = p.withRetry(secret, secretName, maxRetries, func() error {
+		var err error
+		reader, found, err = p.openOnce(files, secret.Reference, timeout)
+		return err
+	})
+	if err != nil {
+		return true, errors.OnePasswordError(
+			fmt.Sprintf("Resolving secret %s", secretName),
+			fmt.Sprintf("Failed to read 1Password file reference: %s", secret.Reference),
+			err,
+		)
+	}
+	if !found {
+		// A regular field; the in-memory path reports the type mismatch
+		return false, nil
+	}
+	defer reader.Close()
+
+	outputPath, err := p.prepareOutputPath(secret, secretName)
+	if err != nil {
+		return true, err
+	}
+	fileMode, err := p.parseFileMode(secret, outputPath, secretName)
+	if err != nil {
+		return true, err
+	}
+	if err := p.checkModePolicy(outputPath, os.FileMode(fileMode), secretName); err != nil {
+		return true, err
+	}
+
+	hash, err := p.copyToFile(secret, reader, outputPath, os.FileMode(fileMode), secretName)
+	if err != nil {
+		return true, err
+	}
+
+	if secret.Owner != "" || secret.Group != "" {
+		if err := p.setOwnership(outputPath, secret.Owner, secret.Group, secretName); err != nil {
+			return true, err
+		}
+	}
+
+	if err := p.createSymlinks(outputPath, secret.Symlinks, secret.SymlinkDirMode, secretName); err != nil {
+		return true, err
+	}
+
+	p.recordHash(secret, outputPath, hash, os.FileMode(fileMode), secretName)
+	return true, nil
+}
+
+// openOnce opens a file reference, bounding the request by timeout. The
+// returned reader is in memory and outlives the request.
+func (p *Processor) openOnce(files FileSecretClient, reference string, timeout time.Duration) (io.ReadCloser, bool, error) {
+	ctx, cancel := p.lookupContext(timeout)
+	defer cancel()
+	return files.OpenFile(ctx, reference)
+}
+
+// copyToFile copies reader into a temporary file next to path and renames it
+// into place, returning the content hash for Verify
+func (p *Processor) copyToFile(secret config.Secret, reader io.Reader, path string, mode os.FileMode, secretName string) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-write-*")
+	if err != nil {
+		return "", errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", secretName),
+			path,
+			"Failed to create temporary file",
+			err,
+		)
+	}
+	defer os.Remove(tmp.Name())
+
+	// Restrict the file before any content lands in it
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return "", errors.FileOperationError(
+			fmt.Sprintf("Setting permissions for %s", secretName),
+			path,
+			fmt.Sprintf("Failed to set file mode %04o", mode),
+			err,
+		)
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), reader)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", secretName),
+			path,
+			"Failed to write secret to file",
+			err,
+		)
+	}
+
+	if size == 0 {
+		if err := p.checkNonEmpty(secret, nil, secretName); err != nil {
+			return "", err
+		}
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if err := p.backupBeforeAttachment(secret, path, hash, secretName); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", secretName),
+			path,
+			"Failed to move secret file into place",
+			err,
+		)
+	}
+	return hash, nil
+}
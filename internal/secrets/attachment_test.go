@@ -0,0 +1,203 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+// fileClient serves attachments through OpenFile and counts string resolves
+type fileClient struct {
+	countingClient
+	files  map[string][]byte
+	closed int
+	// failures is how many opens fail with a retryable error first
+	failures int
+	opens    int
+}
+
+type closeCounter struct {
+	io.Reader
+	client *fileClient
+}
+
+func (c closeCounter) Close() error {
+	c.client.closed++
+	return nil
+}
+
+func (c *fileClient) OpenFile(ctx context.Context, reference string) (io.ReadCloser, bool, error) {
+	c.opens++
+	if c.opens <= c.failures {
+		return nil, false, fmt.Errorf("connection reset by peer")
+	}
+	content, ok := c.files[reference]
+	if !ok {
+		return nil, false, nil
+	}
+	return closeCounter{Reader: bytes.NewReader(content), client: c}, true, nil
+}
+
+func (c *fileClient) CheckFieldType(ctx context.Context, reference, expected string) error {
+	return nil
+}
+
+func TestProcessorWritesAttachments(t *testing.T) {
+	archive := bytes.Repeat([]byte("backup-chunk\x00"), 1<<16)
+	client := &fileClient{
+		countingClient: countingClient{
+			mockClient: mockClient{secrets: map[string]string{
+				"op://vault/db/backup.tar": string(archive),
+				"op://vault/db/notes":      "plain text",
+			}},
+			calls: make(map[string]int),
+		},
+		files: map[string][]byte{"op://vault/db/backup.tar": archive},
+	}
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "db/backup.tar", Reference: "op://vault/db/backup.tar", Type: "file", Mode: "0640"},
+		// Templated files need the whole value and take the in-memory path
+		{Path: "db/wrapped", Reference: "op://vault/db/backup.tar", Type: "file", Template: "{{ len .Secret }}"},
+		{Path: "db/notes", Reference: "op://vault/db/notes"},
+	}}
+
+	processor := NewProcessor(client, tmpDir)
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "db/backup.tar"))
+	if err != nil || !bytes.Equal(content, archive) {
+		t.Fatalf("Expected the written file to match the attachment (%d bytes), got %d bytes, %v", len(archive), len(content), err)
+	}
+	info, _ := os.Stat(filepath.Join(tmpDir, "db/backup.tar"))
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("Expected mode 0640, got %o", info.Mode().Perm())
+	}
+	if client.closed != 1 {
+		t.Errorf("Expected the attachment reader to be closed once, got %d", client.closed)
+	}
+	if wrapped, _ := os.ReadFile(filepath.Join(tmpDir, "db/wrapped")); string(wrapped) != "851968" {
+		t.Errorf("Expected the templated file to be rendered from the resolved value, got %q", wrapped)
+	}
+	if client.calls["op://vault/db/backup.tar"] != 1 || client.calls["op://vault/db/notes"] != 1 {
+		t.Errorf("Expected only the templated file and text field to be resolved, got %v", client.calls)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(tmpDir, "db"))
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".opnix-write-") {
+			t.Errorf("Expected no temporary files left behind, found %s", entry.Name())
+		}
+	}
+
+	if err := processor.Verify(); err != nil {
+		t.Errorf("Expected the written file to verify, got: %v", err)
+	}
+}
+
+func TestProcessorAttachmentRejectsEmptyFile(t *testing.T) {
+	client := &fileClient{
+		countingClient: countingClient{mockClient: mockClient{}, calls: make(map[string]int)},
+		files:          map[string][]byte{"op://vault/db/empty": {}},
+	}
+
+	tmpDir := t.TempDir()
+	processor := NewProcessor(client, tmpDir)
+	processor.requireNonEmpty = true
+	err := processor.Process(&config.Config{Secrets: []config.Secret{
+		{Path: "empty", Reference: "op://vault/db/empty", Type: "file"},
+	}})
+	if err == nil || !strings.Contains(err.Error(), "empty") {
+		t.Errorf("Expected an empty attachment to be rejected, got: %v", err)
+	}
+	if _, statErr := os.Stat(filepath.Join(tmpDir, "empty")); !os.IsNotExist(statErr) {
+		t.Errorf("Expected nothing to be written, got %v", statErr)
+	}
+}
+
+func TestProcessorAttachmentBackupRetention(t *testing.T) {
+	client := &fileClient{
+		countingClient: countingClient{mockClient: mockClient{}, calls: make(map[string]int)},
+		files:          map[string][]byte{"op://vault/tls/bundle.p12": []byte("bundle-1")},
+	}
+
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "bundle.p12")
+	retention := 1
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "bundle.p12", Reference: "op://vault/tls/bundle.p12", Type: "file", Mode: "0640", BackupRetention: &retention},
+	}}
+	processor := NewProcessor(client, tmpDir)
+
+	// A new file and an unchanged attachment leave no backup
+	for i := 0; i < 2; i++ {
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+	if backups, _ := listBackups(path); len(backups) != 0 {
+		t.Fatalf("Expected no backups before the attachment changes, got %v", backups)
+	}
+
+	for _, content := range []string{"bundle-2", "bundle-3"} {
+		client.files["op://vault/tls/bundle.p12"] = []byte(content)
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	backups, err := listBackups(path)
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("Expected 1 backup, got %v, %v", backups, err)
+	}
+	if content, _ := os.ReadFile(backups[0]); string(content) != "bundle-2" {
+		t.Errorf("Expected the newest backup to hold the replaced attachment, got %q", content)
+	}
+	if info, _ := os.Stat(backups[0]); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the backup to keep mode 0640, got %o", info.Mode().Perm())
+	}
+	if current, _ := os.ReadFile(path); string(current) != "bundle-3" {
+		t.Errorf("Expected the file to hold the latest attachment, got %q", current)
+	}
+	if len(client.calls) != 0 {
+		t.Errorf("Expected the attachment to be written from its reader, got string resolves %v", client.calls)
+	}
+}
+
+func TestProcessorAttachmentRetries(t *testing.T) {
+	client := &fileClient{
+		countingClient: countingClient{mockClient: mockClient{}, calls: make(map[string]int)},
+		files:          map[string][]byte{"op://vault/tls/bundle.p12": []byte("bundle")},
+		failures:       2,
+	}
+
+	tmpDir := t.TempDir()
+	processor := NewProcessor(client, tmpDir)
+	processor.retryDelay = 0
+	cfg := &config.Config{
+		Resolve: config.ResolveConfig{MaxRetries: 2},
+		Secrets: []config.Secret{{Path: "bundle.p12", Reference: "op://vault/tls/bundle.p12", Type: "file"}},
+	}
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Expected the third attempt to succeed, got: %v", err)
+	}
+	if client.opens != 3 {
+		t.Errorf("Expected 3 opens, got %d", client.opens)
+	}
+	if content, _ := os.ReadFile(filepath.Join(tmpDir, "bundle.p12")); string(content) != "bundle" {
+		t.Errorf("Expected the attachment to be written, got %q", content)
+	}
+	if warnings := processor.warnings.List(); len(warnings) != 0 {
+		t.Errorf("Expected retries that succeed not to warn, got %v", warnings)
+	}
+}
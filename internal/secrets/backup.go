@@ -0,0 +1,162 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Overwritten secrets can be kept as <path>.YYYYMMDDHHMMSS.bak next to the
+// file, newest N only, as a short history to roll back a bad rotation.
+
+// backupTimeFormat is the timestamp in backup names; it sorts by age
+const backupTimeFormat = "20060102150405"
+
+// backupSuffix ends every backup name
+const backupSuffix = ".bak"
+
+// backupRetention returns how many backups of secret to keep; 0 keeps none
+func (p *Processor) backupRetention(secret config.Secret) int {
+	if secret.BackupRetention != nil {
+		return *secret.BackupRetention
+	}
+	return p.backupRetentionDefault
+}
+
+// backupBeforeWrite copies the file at path aside before data replaces it,
+// then prunes backups beyond the secret's retention. Missing files,
+// non-regular files and unchanged content are not backed up.
+func (p *Processor) backupBeforeWrite(secret config.Secret, path string, data []byte, secretName string) error {
+	return p.backupUnless(secret, path, secretName, func(current []byte) bool {
+		return bytes.Equal(current, data)
+	})
+}
+
+// backupBeforeAttachment is backupBeforeWrite for attachments written from
+// their reader, which are compared by the SHA-256 of the content replacing them
+func (p *Processor) backupBeforeAttachment(secret config.Secret, path, hash, secretName string) error {
+	return p.backupUnless(secret, path, secretName, func(current []byte) bool {
+		sum := sha256.Sum256(current)
+		return hex.EncodeToString(sum[:]) == hash
+	})
+}
+
+// backupUnless backs up the file at path unless unchanged reports its
+// content is what is about to be written
+func (p *Processor) backupUnless(secret config.Secret, path, secretName string, unchanged func([]byte) bool) error {
+	keep := p.backupRetention(secret)
+	if keep <= 0 {
+		return nil
+	}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		return nil
+	}
+	if err != nil {
+		return errors.FileOperationError(fmt.Sprintf("Backing up %s", secretName), path, "Failed to inspect the current file", err)
+	}
+	current, err := os.ReadFile(path)
+	if err != nil {
+		return errors.FileOperationError(fmt.Sprintf("Backing up %s", secretName), path, "Failed to read the current file", err)
+	}
+	defer wipe(current)
+	if unchanged(current) {
+		return nil
+	}
+
+	backup, err := writeBackup(path, current, info, time.Now())
+	if err != nil {
+		return errors.FileOperationError(fmt.Sprintf("Backing up %s", secretName), backup, "Failed to write backup", err)
+	}
+
+	return p.pruneBackups(path, keep, secretName)
+}
+
+// writeBackup stores content as the backup of path taken at now, with the
+// original's mode and owner. It is created 0600 and only opened up to the
+// original's mode once owned like it. A name already taken moves to the
+// next second, so backups never overwrite each other.
+func writeBackup(path string, content []byte, original os.FileInfo, now time.Time) (string, error) {
+	var backup string
+	var file *os.File
+	var err error
+	for {
+		backup = fmt.Sprintf("%s.%s%s", path, now.Format(backupTimeFormat), backupSuffix)
+		file, err = os.OpenFile(backup, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			break
+		}
+		now = now.Add(time.Second)
+	}
+	if err != nil {
+		return backup, err
+	}
+
+	_, err = file.Write(content)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		if stat, ok := original.Sys().(*syscall.Stat_t); ok && os.Geteuid() == 0 {
+			err = os.Chown(backup, int(stat.Uid), int(stat.Gid))
+		}
+	}
+	if err == nil {
+		err = os.Chmod(backup, original.Mode().Perm())
+	}
+	if err != nil {
+		_ = os.Remove(backup)
+	}
+	return backup, err
+}
+
+// listBackups returns the backups of path, newest first
+func listBackups(path string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	prefix := filepath.Base(path) + "."
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), backupSuffix)
+		if _, err := time.Parse(backupTimeFormat, stamp); err != nil || len(stamp) != len(backupTimeFormat) {
+			continue
+		}
+		backups = append(backups, filepath.Join(filepath.Dir(path), name))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// pruneBackups removes all but the newest keep backups of path
+func (p *Processor) pruneBackups(path string, keep int, secretName string) error {
+	backups, err := listBackups(path)
+	if err != nil {
+		return errors.FileOperationError(fmt.Sprintf("Pruning backups of %s", secretName), filepath.Dir(path), "Failed to list backups", err)
+	}
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(backups[i]); err != nil && !os.IsNotExist(err) {
+			return errors.FileOperationError(fmt.Sprintf("Pruning backups of %s", secretName), backups[i], "Failed to remove old backup", err)
+		}
+	}
+	return nil
+}
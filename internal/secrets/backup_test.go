@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorBackupRetention(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "token")
+	mock := &mockClient{secrets: map[string]string{"op://vault/item/field": "v0"}}
+	cfg := &config.Config{
+		BackupRetention: 2,
+		Secrets:         []config.Secret{{Path: "token", Reference: "op://vault/item/field", Mode: "0640"}},
+	}
+	processor := NewProcessor(mock, tempDir)
+
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if backups, _ := listBackups(path); len(backups) != 0 {
+		t.Fatalf("A new file must not be backed up, got %v", backups)
+	}
+
+	// Unchanged values leave no backup
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if backups, _ := listBackups(path); len(backups) != 0 {
+		t.Fatalf("An unchanged file must not be backed up, got %v", backups)
+	}
+
+	for _, value := range []string{"v1", "v2", "v3"} {
+		mock.secrets["op://vault/item/field"] = value
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Process() error = %v", err)
+		}
+	}
+
+	backups, err := listBackups(path)
+	if err != nil {
+		t.Fatalf("listBackups() error = %v", err)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("Expected 2 backups, got %v", backups)
+	}
+	for i, want := range []string{"v2", "v1"} {
+		content, _ := os.ReadFile(backups[i])
+		if string(content) != want {
+			t.Errorf("Backup %d = %q, want %q", i, content, want)
+		}
+		info, _ := os.Stat(backups[i])
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("Backup %d has mode %o, want 0640", i, info.Mode().Perm())
+		}
+	}
+
+	// A per-secret 0 disables backups
+	none := 0
+	cfg.Secrets[0].BackupRetention = &none
+	mock.secrets["op://vault/item/field"] = "v4"
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+	if after, _ := listBackups(path); len(after) != 2 || after[0] != backups[0] {
+		t.Errorf("Disabled retention must leave backups alone, got %v", after)
+	}
+}
+
+func TestWriteBackupNameCollision(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	first, err := writeBackup(path, []byte("a"), info, now)
+	if err != nil {
+		t.Fatalf("writeBackup() error = %v", err)
+	}
+	second, err := writeBackup(path, []byte("b"), info, now)
+	if err != nil {
+		t.Fatalf("writeBackup() error = %v", err)
+	}
+	if first != path+".20240501120000.bak" || second != path+".20240501120001.bak" {
+		t.Errorf("Unexpected backup names %q and %q", first, second)
+	}
+}
@@ -0,0 +1,74 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// resolveBundle resolves every reference of a bundle secret and concatenates
+// them in configuration order. Each part must be PEM and nothing else, so a
+// stray field never ends up inside a trust store.
+func (p *Processor) resolveBundle(secret config.Secret, secretName string) (string, error) {
+	var sb strings.Builder
+	for i, reference := range secret.Bundle {
+		partSecret := secret
+		partSecret.Reference = reference
+		partName := fmt.Sprintf("%s.bundle[%d]", secretName, i)
+
+		value, err := p.resolveWithRetry(partSecret, secretName)
+		if err != nil {
+			return "", errors.OnePasswordError(
+				fmt.Sprintf("Resolving %s", partName),
+				fmt.Sprintf("Failed to resolve 1Password reference: %s", reference),
+				err,
+			)
+		}
+
+		blocks, err := pemBlocks([]byte(value))
+		if err != nil {
+			return "", errors.ValidationError(
+				fmt.Sprintf("Validating %s (%s)", partName, reference),
+				"bundle",
+				err.Error(),
+				"one or more PEM blocks (-----BEGIN ...-----) and nothing else",
+			)
+		}
+		sb.Write(blocks)
+	}
+
+	return sb.String(), nil
+}
+
+// pemBlocks re-encodes the PEM blocks in data, rejecting input that is empty
+// or has anything but whitespace around the blocks
+func pemBlocks(data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	rest := bytes.TrimSpace(data)
+	for len(rest) > 0 {
+		// pem.Decode skips leading text, which must not be silently dropped
+		var block *pem.Block
+		remaining := rest
+		if bytes.HasPrefix(rest, []byte("-----BEGIN ")) {
+			block, remaining = pem.Decode(rest)
+		}
+		if block == nil {
+			if out.Len() == 0 {
+				return nil, fmt.Errorf("value is not PEM encoded")
+			}
+			return nil, fmt.Errorf("value has non-PEM content between or after its PEM blocks")
+		}
+		if err := pem.Encode(&out, block); err != nil {
+			return nil, err
+		}
+		rest = bytes.TrimSpace(remaining)
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("value is empty")
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,67 @@
+package secrets
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func testCert(name string) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte(name)}))
+}
+
+func TestProcessorBundle(t *testing.T) {
+	root, intermediate := testCert("root"), testCert("intermediate")
+	mock := &mockClient{
+		secrets: map[string]string{
+			// Values stored without a trailing newline still get separated
+			"op://vault/Root CA/cert":         strings.TrimSpace(root),
+			"op://vault/Intermediate CA/cert": "\n" + intermediate + "\n\n",
+			"op://vault/Api/key":              "not a certificate",
+			"op://vault/Mixed/cert":           "note\n" + root,
+		},
+	}
+
+	t.Run("concatenated in order", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path:   "ca.pem",
+			Bundle: []string{"op://vault/Intermediate CA/cert", "op://vault/Root CA/cert"},
+		}}}
+		if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+			t.Fatalf("Failed to process bundle: %v", err)
+		}
+
+		content, err := os.ReadFile(filepath.Join(tmpDir, "ca.pem"))
+		if err != nil {
+			t.Fatalf("Failed to read bundle: %v", err)
+		}
+		if string(content) != intermediate+root {
+			t.Errorf("Expected intermediate then root, got:\n%s", content)
+		}
+	})
+
+	for name, reference := range map[string]string{
+		"not PEM":           "op://vault/Api/key",
+		"text before block": "op://vault/Mixed/cert",
+	} {
+		t.Run(name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			cfg := &config.Config{Secrets: []config.Secret{{
+				Path:   "ca.pem",
+				Bundle: []string{"op://vault/Root CA/cert", reference},
+			}}}
+			err := NewProcessor(mock, tmpDir).Process(cfg)
+			if err == nil || !strings.Contains(err.Error(), "bundle[1]") {
+				t.Errorf("Expected error naming bundle[1], got: %v", err)
+			}
+			if _, statErr := os.Stat(filepath.Join(tmpDir, "ca.pem")); !os.IsNotExist(statErr) {
+				t.Errorf("Expected no bundle to be written, got %v", statErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,232 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// DefaultCacheTTL is how long a cached value is served when resolve.cache.ttl is unset
+const DefaultCacheTTL = 5 * time.Minute
+
+// cacheFileVersion is bumped whenever the cache file layout changes; files
+// of another version are discarded rather than read
+const cacheFileVersion = 1
+
+// ResolveCache keeps resolved values between runs in a file encrypted with
+// AES-256-GCM. The file records a fingerprint of the token that filled it,
+// and a different token starts from an empty cache.
+type ResolveCache struct {
+	path    string
+	key     []byte
+	tokenID string
+	ttl     time.Duration
+	entries map[string]cacheEntry
+	// used marks the entries read or written this run; only those are saved
+	used map[string]bool
+	now  func() time.Time
+}
+
+type cacheEntry struct {
+	Value      string    `json:"value"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// cacheFile is the on-disk form; Data is the sealed JSON of the entries
+type cacheFile struct {
+	Version int    `json:"version"`
+	Token   string `json:"token"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// OpenResolveCache loads the cache described by settings for token. A
+// missing, unreadable-as-cache or foreign file yields an empty cache, which
+// replaces it on Save.
+func OpenResolveCache(settings config.ResolveCacheConfig, token string) (*ResolveCache, error) {
+	ttl := DefaultCacheTTL
+	if settings.TTL != "" {
+		parsed, err := time.ParseDuration(settings.TTL)
+		if err != nil || parsed <= 0 {
+			return nil, errors.ValidationError("Opening resolve cache", "resolve.cache.ttl", settings.TTL, "positive duration (e.g., 5m, 1h)")
+		}
+		ttl = parsed
+	}
+
+	secret := token
+	if settings.KeyFile != "" {
+		data, err := os.ReadFile(settings.KeyFile)
+		if err != nil {
+			return nil, errors.FileOperationError("Opening resolve cache", settings.KeyFile, "Failed to read cache key file", err)
+		}
+		secret = strings.TrimSpace(string(data))
+		if secret == "" {
+			return nil, errors.FileOperationError("Opening resolve cache", settings.KeyFile, "Cache key file is empty", nil)
+		}
+	}
+
+	key := sha256.Sum256([]byte("opnix resolve cache key\x00" + secret))
+	tokenID := sha256.Sum256([]byte("opnix resolve cache token\x00" + token))
+	cache := &ResolveCache{
+		path:    settings.File,
+		key:     key[:],
+		tokenID: hex.EncodeToString(tokenID[:16]),
+		ttl:     ttl,
+		entries: make(map[string]cacheEntry),
+		used:    make(map[string]bool),
+		now:     time.Now,
+	}
+
+	data, err := os.ReadFile(settings.File)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, errors.FileOperationError("Opening resolve cache", settings.File, "Failed to read cache file", err)
+	}
+	cache.load(data)
+	return cache, nil
+}
+
+// load decrypts data into the cache, leaving it empty when the file belongs
+// to another token or key, or cannot be decrypted at all
+func (c *ResolveCache) load(data []byte) {
+	var file cacheFile
+	if err := json.Unmarshal(data, &file); err != nil || file.Version != cacheFileVersion || file.Token != c.tokenID {
+		return
+	}
+
+	gcm, err := c.cipher()
+	if err != nil || len(file.Nonce) != gcm.NonceSize() {
+		return
+	}
+	plaintext, err := gcm.Open(nil, file.Nonce, file.Data, []byte(file.Token))
+	if err != nil {
+		return
+	}
+
+	entries := make(map[string]cacheEntry)
+	if err := json.Unmarshal(plaintext, &entries); err == nil {
+		c.entries = entries
+	}
+}
+
+func (c *ResolveCache) cipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// get returns the value cached under key when it is younger than ttl
+func (c *ResolveCache) get(key string, ttl time.Duration) (string, bool) {
+	entry, ok := c.entries[key]
+	if !ok || c.now().Sub(entry.ResolvedAt) >= ttl {
+		return "", false
+	}
+	c.used[key] = true
+	return entry.Value, true
+}
+
+// put records a freshly resolved value under key
+func (c *ResolveCache) put(key, value string) {
+	c.entries[key] = cacheEntry{Value: value, ResolvedAt: c.now()}
+	c.used[key] = true
+}
+
+// Save writes the entries used this run to the cache file with mode 0600.
+// Entries of secrets no longer resolved through the cache are dropped.
+func (c *ResolveCache) Save() error {
+	entries := make(map[string]cacheEntry, len(c.used))
+	for key := range c.used {
+		entries[key] = c.entries[key]
+	}
+	plaintext, err := json.Marshal(entries)
+	if err != nil {
+		return errors.ConfigError("Saving resolve cache", "Failed to encode cache entries", err)
+	}
+
+	gcm, err := c.cipher()
+	if err != nil {
+		return errors.ConfigError("Saving resolve cache", "Failed to initialize cache encryption", err)
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.ConfigError("Saving resolve cache", "Failed to generate nonce", err)
+	}
+	file := cacheFile{
+		Version: cacheFileVersion,
+		Token:   c.tokenID,
+		Nonce:   nonce,
+		Data:    gcm.Seal(nil, nonce, plaintext, []byte(c.tokenID)),
+	}
+	data, err := json.Marshal(file)
+	if err != nil {
+		return errors.ConfigError("Saving resolve cache", "Failed to encode cache file", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return errors.FileOperationError("Saving resolve cache", filepath.Dir(c.path), "Failed to create cache directory", err)
+	}
+	if err := writeFileAtomic(c.path, data, 0600); err != nil {
+		return errors.FileOperationError("Saving resolve cache", c.path, "Failed to write cache file", err)
+	}
+	return nil
+}
+
+// SetResolveCache serves values resolved by earlier runs from cache while
+// they are younger than their TTL, and records every value resolved now
+func (p *Processor) SetResolveCache(cache *ResolveCache) {
+	p.cache = cache
+}
+
+// cacheTTL returns how long a secret's value may be served from the cache,
+// or 0 when it is always resolved. Secrets of other accounts use tokens the
+// cache file is not bound to, so they are never cached.
+func (p *Processor) cacheTTL(secret config.Secret) time.Duration {
+	if p.cache == nil || secret.Account != "" {
+		return 0
+	}
+	if secret.CacheTTL != "" {
+		ttl, _ := time.ParseDuration(secret.CacheTTL)
+		return ttl
+	}
+	return p.cache.ttl
+}
+
+// cacheKey identifies a secret's value in the cache; fallbacks are part of
+// it since they can change which field the value comes from
+func cacheKey(secret config.Secret) string {
+	if len(secret.FieldFallbacks) == 0 {
+		return secret.Reference
+	}
+	return fmt.Sprintf("%s|%s", secret.Reference, strings.Join(secret.FieldFallbacks, ","))
+}
+
+// cached returns a secret's value from the cache if it is still fresh
+func (p *Processor) cached(secret config.Secret) (string, bool) {
+	ttl := p.cacheTTL(secret)
+	if ttl <= 0 {
+		return "", false
+	}
+	return p.cache.get(cacheKey(secret), ttl)
+}
+
+// remember stores a resolved value for secrets that use the cache
+func (p *Processor) remember(secret config.Secret, value string) {
+	if p.cacheTTL(secret) > 0 {
+		p.cache.put(cacheKey(secret), value)
+	}
+}
@@ -0,0 +1,87 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestResolveCache(t *testing.T) {
+	settings := config.ResolveCacheConfig{File: filepath.Join(t.TempDir(), "cache", "resolve-cache"), TTL: "1h"}
+	cfg := func() *config.Config {
+		return &config.Config{Secrets: []config.Secret{
+			{Path: "cached", Reference: "op://vault/item/cached"},
+			{Path: "uncached", Reference: "op://vault/item/uncached", CacheTTL: "0"},
+		}}
+	}
+	mock := &mockClient{secrets: map[string]string{
+		"op://vault/item/cached":   "first-value",
+		"op://vault/item/uncached": "first-value",
+	}}
+
+	// run processes the config with a cache opened for token and returns
+	// what each secret's file holds afterwards
+	run := func(token string, adjust func(*ResolveCache)) map[string]string {
+		t.Helper()
+		cache, err := OpenResolveCache(settings, token)
+		if err != nil {
+			t.Fatalf("Failed to open cache: %v", err)
+		}
+		if adjust != nil {
+			adjust(cache)
+		}
+		tmpDir := t.TempDir()
+		processor := NewProcessor(mock, tmpDir)
+		processor.SetResolveCache(cache)
+		if err := processor.Process(cfg()); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+		if err := cache.Save(); err != nil {
+			t.Fatalf("Failed to save cache: %v", err)
+		}
+		written := make(map[string]string)
+		for _, name := range []string{"cached", "uncached"} {
+			content, _ := os.ReadFile(filepath.Join(tmpDir, name))
+			written[name] = string(content)
+		}
+		return written
+	}
+
+	run("token-a", nil)
+	info, err := os.Stat(settings.File)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a 0600 cache file, got %v, %v", info, err)
+	}
+	data, _ := os.ReadFile(settings.File)
+	if strings.Contains(string(data), "first-value") {
+		t.Error("Cache file holds a value in plain text")
+	}
+
+	mock.secrets["op://vault/item/cached"] = "second-value"
+	mock.secrets["op://vault/item/uncached"] = "second-value"
+
+	if written := run("token-a", nil); written["cached"] != "first-value" || written["uncached"] != "second-value" {
+		t.Errorf("Expected the cached value within the TTL and cacheTTL 0 to resolve, got %v", written)
+	}
+
+	expired := func(cache *ResolveCache) { cache.now = func() time.Time { return time.Now().Add(2 * time.Hour) } }
+	if written := run("token-a", expired); written["cached"] != "second-value" {
+		t.Errorf("Expected an expired value to be resolved again, got %q", written["cached"])
+	}
+
+	mock.secrets["op://vault/item/cached"] = "third-value"
+	if written := run("token-b", nil); written["cached"] != "third-value" {
+		t.Errorf("Expected a new token to ignore the cache, got %q", written["cached"])
+	}
+
+	if err := os.WriteFile(settings.File, []byte("not a cache"), 0600); err != nil {
+		t.Fatalf("Failed to corrupt cache: %v", err)
+	}
+	if written := run("token-b", nil); written["cached"] != "third-value" {
+		t.Errorf("Expected a corrupt cache to be replaced, got %q", written["cached"])
+	}
+}
@@ -0,0 +1,61 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// canonicalJSON re-encodes a JSON document with sorted object keys, two-space
+// indentation and a trailing newline, so equal documents are byte-identical.
+// Numbers keep their original text.
+func canonicalJSON(data []byte, secretName string) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil || decoder.More() {
+		// The decoder's message quotes the offending character, so it is not passed on
+		return nil, &errors.OpnixError{
+			Operation: fmt.Sprintf("Canonicalizing %s", secretName),
+			Component: "secret processing",
+			Issue:     "canonical is set but the value is not a single JSON document",
+			Suggestions: []string{
+				"Remove canonical from secrets that are not JSON",
+				"canonical sorts envFile and ini secrets without parsing their values",
+			},
+		}
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(document); err != nil {
+		return nil, errors.ConfigError(fmt.Sprintf("Canonicalizing %s", secretName), "Failed to encode JSON value", nil)
+	}
+	return out.Bytes(), nil
+}
+
+// canonicalEnvFile returns an envFile secret's entries sorted by key
+func canonicalEnvFile(entries []config.EnvFileEntry) []config.EnvFileEntry {
+	sorted := append([]config.EnvFileEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	return sorted
+}
+
+// canonicalINI returns an ini secret's entries sorted by section, then key,
+// so keys without a section still come first
+func canonicalINI(entries []config.INIEntry) []config.INIEntry {
+	sorted := append([]config.INIEntry{}, entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].Section != sorted[j].Section {
+			return sorted[i].Section < sorted[j].Section
+		}
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
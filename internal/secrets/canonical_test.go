@@ -0,0 +1,58 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorCanonical(t *testing.T) {
+	mock := &mockClient{secrets: map[string]string{
+		"op://vault/App/json": `{"zeta":1.50,"alpha":{"b":[true,null],"a":"<x>"}}`,
+		"op://vault/App/text": "not json",
+		"op://vault/App/user": "admin",
+		"op://vault/App/pass": "hunter2",
+	}}
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "app.json", Reference: "op://vault/App/json", Canonical: true},
+		{Path: "app.env", Canonical: true, EnvFile: []config.EnvFileEntry{
+			{Key: "USER", Reference: "op://vault/App/user"},
+			{Key: "PASS", Reference: "op://vault/App/pass"},
+		}},
+		{Path: "app.ini", Canonical: true, INI: []config.INIEntry{
+			{Section: "db", Key: "user", Reference: "op://vault/App/user"},
+			{Section: "api", Key: "key", Reference: "op://vault/App/pass"},
+			{Key: "name", Reference: "op://vault/App/user"},
+			{Section: "db", Key: "pass", Reference: "op://vault/App/pass"},
+		}},
+	}}
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Failed to process canonical secrets: %v", err)
+	}
+
+	expected := map[string]string{
+		"app.json": "{\n  \"alpha\": {\n    \"a\": \"<x>\",\n    \"b\": [\n      true,\n      null\n    ]\n  },\n  \"zeta\": 1.50\n}\n",
+		"app.env":  "PASS=\"hunter2\"\nUSER=\"admin\"\n",
+		"app.ini":  "name = admin\n\n[api]\nkey = hunter2\n\n[db]\npass = hunter2\nuser = admin\n",
+	}
+	for name, want := range expected {
+		content, _ := os.ReadFile(filepath.Join(tmpDir, name))
+		if string(content) != want {
+			t.Errorf("Unexpected %s:\n%s\nexpected:\n%s", name, content, want)
+		}
+	}
+
+	cfg = &config.Config{Secrets: []config.Secret{{Path: "text", Reference: "op://vault/App/text", Canonical: true}}}
+	err := NewProcessor(mock, t.TempDir()).Process(cfg)
+	if err == nil || !strings.Contains(err.Error(), "not a single JSON document") {
+		t.Fatalf("Expected a non-JSON value to be rejected, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "not json") {
+		t.Errorf("Error exposes the value: %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// checkCertExpiry warns, or fails with certExpiryStrict, when a certificate
+// in the value has expired or expires within certExpiryWarnDays. Values that
+// hold no certificate are not checked.
+func (p *Processor) checkCertExpiry(secret config.Secret, value, secretName string) error {
+	if secret.CertExpiryWarnDays == 0 && !secret.CertExpiryStrict {
+		return nil
+	}
+
+	cert := soonestExpiring(parseCertificates([]byte(value)))
+	if cert == nil {
+		return nil
+	}
+
+	now := time.Now()
+	if !cert.NotAfter.Before(now.AddDate(0, 0, secret.CertExpiryWarnDays)) {
+		return nil
+	}
+
+	var issue string
+	if cert.NotAfter.Before(now) {
+		issue = fmt.Sprintf("Certificate %q expired on %s", cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.DateOnly))
+	} else {
+		days := int(cert.NotAfter.Sub(now).Hours() / 24)
+		issue = fmt.Sprintf("Certificate %q expires on %s, in %d days (certExpiryWarnDays is %d)",
+			cert.Subject.CommonName, cert.NotAfter.UTC().Format(time.DateOnly), days, secret.CertExpiryWarnDays)
+	}
+
+	if !secret.CertExpiryStrict {
+		p.warnings.Addf("secret processing", "%s: %s", secretName, issue)
+		return nil
+	}
+
+	return &errors.OpnixError{
+		Operation: fmt.Sprintf("Checking certificate expiry of %s", secretName),
+		Component: "secret processing",
+		Issue:     issue,
+		Suggestions: []string{
+			"Renew the certificate and update it in 1Password",
+			"Lower certExpiryWarnDays or unset certExpiryStrict to deploy it anyway",
+		},
+	}
+}
+
+// parseCertificates returns the certificates in PEM data, or the single
+// certificate of DER data
+func parseCertificates(data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+
+	if len(certs) == 0 {
+		if cert, err := x509.ParseCertificate(data); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// soonestExpiring returns the certificate that expires first, so a bundle is
+// only as good as its weakest link
+func soonestExpiring(certs []*x509.Certificate) *x509.Certificate {
+	var soonest *x509.Certificate
+	for _, cert := range certs {
+		if soonest == nil || cert.NotAfter.Before(soonest.NotAfter) {
+			soonest = cert
+		}
+	}
+	return soonest
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// defaultValidateTimeout bounds a validateWith command when no timeout is configured
+const defaultValidateTimeout = 30 * time.Second
+
+// maxValidateOutput caps how much command output is kept for error messages
+const maxValidateOutput = 2048
+
+// runValidateWith runs the secret's validateWith command against the written
+// file. The command gets the path as its last argument, never the value: its
+// environment is reduced to PATH, stdin is closed, and when opnix runs as root
+// it runs as the secret's owner.
+func (p *Processor) runValidateWith(secret config.Secret, path string, data []byte, secretName string) error {
+	timeout := defaultValidateTimeout
+	if secret.ValidateTimeout != "" {
+		parsed, err := time.ParseDuration(secret.ValidateTimeout)
+		if err != nil {
+			return errors.ValidationError(
+				fmt.Sprintf("Parsing validate timeout for %s", secretName),
+				"validateTimeout",
+				secret.ValidateTimeout,
+				"positive duration (e.g., 10s, 1m)",
+			)
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	args := append(append([]string{}, secret.ValidateWith[1:]...), path)
+	cmd := exec.CommandContext(ctx, secret.ValidateWith[0], args...)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	cmd.Dir = "/"
+	cmd.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := p.runAsOwner(cmd, secret, secretName); err != nil {
+		return err
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return nil
+	}
+
+	issue := fmt.Sprintf("Validation command %s rejected the secret", secret.ValidateWith[0])
+	if ctx.Err() == context.DeadlineExceeded {
+		issue = fmt.Sprintf("Validation command %s timed out after %s", secret.ValidateWith[0], timeout)
+	}
+
+	return &errors.OpnixError{
+		Operation: fmt.Sprintf("Validating written secret %s", secretName),
+		Component: "secret processing",
+		Issue:     issue,
+		Context:   fmt.Sprintf("Target path: %s\nOutput: %s", path, validateOutput(output.Bytes(), string(data))),
+		Suggestions: []string{
+			"The previous file was restored; check the value in 1Password",
+			fmt.Sprintf("Run the command by hand: %s %s", strings.Join(secret.ValidateWith, " "), path),
+			"Increase validateTimeout if the command needs longer",
+		},
+		Cause: err,
+	}
+}
+
+// runAsOwner makes cmd run as the secret's owner and group when opnix runs
+// as root, so a helper command gets no more access than the secret's reader
+func (p *Processor) runAsOwner(cmd *exec.Cmd, secret config.Secret, secretName string) error {
+	if os.Geteuid() != 0 || (secret.Owner == "" && secret.Group == "") {
+		return nil
+	}
+	uid, gid, err := p.lookupOwnership(secret.Owner, secret.Group, secretName)
+	if err != nil {
+		return err
+	}
+	if uid == -1 {
+		uid = 0
+	}
+	if gid == -1 {
+		gid = 0
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+	}
+	return nil
+}
+
+// validateOutput trims command output for error messages, hiding the value
+// in case the command echoed it
+func validateOutput(output []byte, value string) string {
+	text := strings.TrimSpace(string(output))
+	if value = strings.TrimSpace(value); value != "" {
+		text = strings.ReplaceAll(text, value, "<redacted>")
+	}
+	if len(text) > maxValidateOutput {
+		text = text[:maxValidateOutput] + "..."
+	}
+	if text == "" {
+		return "<none>"
+	}
+	return text
+}
@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+// writeScript creates an executable shell script for validateWith tests
+func writeScript(t *testing.T, dir, body string) string {
+	t.Helper()
+	path := filepath.Join(dir, "check.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+		t.Fatalf("Failed to write script: %v", err)
+	}
+	return path
+}
+
+func TestProcessorValidateWith(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/good": "good-value",
+			"op://vault/item/bad":  "bad-value",
+		},
+	}
+
+	scriptDir := t.TempDir()
+	check := writeScript(t, scriptDir, `grep -q good "$1"`)
+
+	t.Run("accepted value is kept", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path: "cert", Reference: "op://vault/item/good", ValidateWith: []string{check},
+		}}}
+		if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+			t.Fatalf("Expected validation to pass, got: %v", err)
+		}
+	})
+
+	t.Run("rejected value restores previous file", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		target := filepath.Join(tmpDir, "cert")
+		if err := os.WriteFile(target, []byte("previous-value"), 0600); err != nil {
+			t.Fatalf("Failed to write previous file: %v", err)
+		}
+
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path: "cert", Reference: "op://vault/item/bad", ValidateWith: []string{check},
+		}}}
+		err := NewProcessor(mock, tmpDir).Process(cfg)
+		if err == nil {
+			t.Fatal("Expected validation to fail")
+		}
+		if strings.Contains(err.Error(), "bad-value") {
+			t.Errorf("Error must not contain the secret value: %v", err)
+		}
+
+		content, readErr := os.ReadFile(target)
+		if readErr != nil || string(content) != "previous-value" {
+			t.Errorf("Expected previous file to be restored, got %q, %v", string(content), readErr)
+		}
+	})
+
+	t.Run("rejected new file is removed", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path: "cert", Reference: "op://vault/item/bad", ValidateWith: []string{check},
+		}}}
+		if err := NewProcessor(mock, tmpDir).Process(cfg); err == nil {
+			t.Fatal("Expected validation to fail")
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "cert")); !os.IsNotExist(err) {
+			t.Errorf("Expected rejected file to be removed, got %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		slow := writeScript(t, t.TempDir(), "sleep 5")
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path: "cert", Reference: "op://vault/item/good", ValidateWith: []string{slow}, ValidateTimeout: "100ms",
+		}}}
+		err := NewProcessor(mock, t.TempDir()).Process(cfg)
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected timeout error, got: %v", err)
+		}
+	})
+}
+
+func TestValidateOutput(t *testing.T) {
+	if got := validateOutput([]byte("unable to load s3cret\n"), "s3cret"); got != "unable to load <redacted>" {
+		t.Errorf("Expected value to be redacted, got %q", got)
+	}
+	if got := validateOutput(nil, "s3cret"); got != "<none>" {
+		t.Errorf("Expected <none> for empty output, got %q", got)
+	}
+}
@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// encodeCopy returns data in a copy's encoding. Raw copies share data itself.
+func encodeCopy(data []byte, encoding string) []byte {
+	switch encoding {
+	case "base64":
+		encoded := make([]byte, base64.StdEncoding.EncodedLen(len(data)))
+		base64.StdEncoding.Encode(encoded, data)
+		return encoded
+	case "hex":
+		encoded := make([]byte, hex.EncodedLen(len(data)))
+		hex.Encode(encoded, data)
+		return encoded
+	default:
+		return data
+	}
+}
+
+// copyPath resolves where a copy is written, like the secret's own path
+func (p *Processor) copyPath(secret config.Secret, secretCopy config.SecretCopy, copyName string) (string, error) {
+	return p.resolveSecretPathWithTemplate(config.Secret{Path: secretCopy.Path, Variables: secret.Variables}, copyName)
+}
+
+// copySecret returns secret as a copy is written: with the copy's own owner,
+// group and mode where set, and without the secret's symlinks, which point
+// at its own file
+func copySecret(secret config.Secret, secretCopy config.SecretCopy) config.Secret {
+	if secretCopy.Owner != "" {
+		secret.Owner = secretCopy.Owner
+	}
+	if secretCopy.Group != "" {
+		secret.Group = secretCopy.Group
+	}
+	if secretCopy.Mode != "" {
+		secret.Mode = secretCopy.Mode
+	}
+	secret.Symlinks = nil
+	return secret
+}
+
+// writeCopies writes every copy of a secret from its already resolved value,
+// with the secret's mode and ownership unless the copy sets its own
+func (p *Processor) writeCopies(secret config.Secret, data []byte, fileMode os.FileMode, secretName string) error {
+	for i, secretCopy := range secret.Copies {
+		copyName := fmt.Sprintf("%s.copies[%d]", secretName, i)
+		if err := p.writeCopy(secret, secretCopy, data, fileMode, copyName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *Processor) writeCopy(secret config.Secret, secretCopy config.SecretCopy, data []byte, fileMode os.FileMode, copyName string) error {
+	path, err := p.copyPath(secret, secretCopy, copyName)
+	if err != nil {
+		return err
+	}
+	// Validation creates the parent directory, so note what is missing first
+	parentDir := filepath.Dir(path)
+	created := missingDirs(parentDir)
+	if err := p.validateSecretPath(path, copyName); err != nil {
+		return err
+	}
+	owner := copySecret(secret, secretCopy)
+	if secretCopy.Mode != "" {
+		mode, err := p.parseFileMode(owner, path, copyName)
+		if err != nil {
+			return err
+		}
+		fileMode = os.FileMode(mode)
+	}
+	if err := p.checkModePolicy(path, fileMode, copyName); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(parentDir, 0755); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Creating parent directory for %s", copyName),
+			parentDir,
+			"Failed to create parent directory",
+			err,
+		)
+	}
+	if err := p.chownCreatedDirs(created, owner, copyName); err != nil {
+		return err
+	}
+
+	encoded := encodeCopy(data, secretCopy.Encoding)
+
+	// Copies are replaced atomically like the secret, with the mode applied
+	// before the content is visible
+	if err := writeFileAtomic(path, encoded, fileMode); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", copyName),
+			path,
+			"Failed to write secret copy to file",
+			err,
+		)
+	}
+	// A full disk can truncate a write without reporting an error
+	if err := checkWrittenSize(path, len(encoded)); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", copyName),
+			path,
+			writtenSizeIssue("Secret copy", err),
+			err,
+		)
+	}
+
+	if owner.Owner != "" || owner.Group != "" {
+		if err := p.setOwnership(path, owner.Owner, owner.Group, copyName); err != nil {
+			return err
+		}
+	}
+
+	p.recordWrite(owner, path, encoded, fileMode, copyName)
+	return nil
+}
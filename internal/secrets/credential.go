@@ -0,0 +1,31 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// restrictCredentialStore makes systemd's credential stores accessible to
+// root only, as systemd expects, when opnix creates a credential in them
+func restrictCredentialStore(secret config.Secret, dir, secretName string) error {
+	if secret.Credential == "" {
+		return nil
+	}
+	dir = filepath.Clean(dir)
+	if dir != config.CredentialStoreDir && dir != config.EncryptedCredentialStoreDir {
+		return nil
+	}
+	if err := os.Chmod(dir, 0700); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Setting permissions on credential store for %s", secretName),
+			dir,
+			"Failed to set directory mode 0700",
+			err,
+		)
+	}
+	return nil
+}
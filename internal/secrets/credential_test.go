@@ -0,0 +1,50 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorCredential(t *testing.T) {
+	// A stand-in for systemd-creds that checks its arguments and marks its output
+	bin := t.TempDir()
+	script := "#!/bin/sh\n[ \"$1 $2 $3 $4\" = \"encrypt --name=db-password - -\" ] || exit 1\nprintf 'ENCRYPTED:'\ncat\n"
+	if err := os.WriteFile(filepath.Join(bin, "systemd-creds"), []byte(script), 0755); err != nil {
+		t.Fatalf("Failed to write fake systemd-creds: %v", err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	mock := &mockClient{secrets: map[string]string{"op://vault/db/password": "hunter2"}}
+	tmpDir := t.TempDir()
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "plain/db-password", Reference: "op://vault/db/password", Credential: "db-password"},
+		{Path: "encrypted/db-password", Reference: "op://vault/db/password", Credential: "db-password", CredentialEncrypted: true},
+	}}
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Failed to process credentials: %v", err)
+	}
+
+	plain, _ := os.ReadFile(filepath.Join(tmpDir, "plain/db-password"))
+	if string(plain) != "hunter2" {
+		t.Errorf("Expected the plain credential to hold the value, got %q", plain)
+	}
+	encrypted, _ := os.ReadFile(filepath.Join(tmpDir, "encrypted/db-password"))
+	if string(encrypted) != "ENCRYPTED:hunter2" {
+		t.Errorf("Expected the value encrypted by systemd-creds, got %q", encrypted)
+	}
+
+	// A failing systemd-creds fails the secret without writing anything
+	cfg.Secrets[1].Credential = "other"
+	cfg.Secrets[1].Path = "encrypted/other"
+	err := NewProcessor(mock, tmpDir).Process(cfg)
+	if err == nil || !strings.Contains(err.Error(), "systemd-creds failed to encrypt credential other") {
+		t.Fatalf("Expected the encryption failure to be reported, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "encrypted/other")); !os.IsNotExist(err) {
+		t.Errorf("Expected no credential file after a failed encryption: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// CheckSymlinks fails when a configured symlink exists but its target does
+// not, e.g. because an earlier run left a link behind for a secret that has
+// since failed. Symlinks are only created once their secret is written, so
+// this catches links left over from outside the current run.
+func (p *Processor) CheckSymlinks(cfg *config.Config) error {
+	resolved, err := p.ResolvePaths(cfg)
+	if err != nil {
+		return err
+	}
+
+	var dangling []string
+	for _, secret := range resolved {
+		for _, link := range secret.Symlinks {
+			info, err := os.Lstat(link)
+			if err != nil || info.Mode()&os.ModeSymlink == 0 {
+				continue
+			}
+			if _, err := os.Stat(link); os.IsNotExist(err) {
+				target, _ := os.Readlink(link)
+				dangling = append(dangling, fmt.Sprintf("%s -> %s (%s)", link, target, secret.Name))
+			}
+		}
+	}
+	if len(dangling) == 0 {
+		return nil
+	}
+
+	return &errors.OpnixError{
+		Operation: "Checking symlinks",
+		Component: "file system",
+		Issue:     fmt.Sprintf("%d symlinks point at files that do not exist", len(dangling)),
+		Context:   "Dangling symlinks:\n  " + strings.Join(dangling, "\n  "),
+		Suggestions: []string{
+			"Fix the secrets these links belong to and re-run opnix",
+			"Remove links that are no longer configured",
+		},
+	}
+}
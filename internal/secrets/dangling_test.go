@@ -0,0 +1,41 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestCheckSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	mock := &mockClient{secrets: map[string]string{"op://vault/item/ok": "value"}}
+	okLink := filepath.Join(tmpDir, "links", "ok")
+	failedLink := filepath.Join(tmpDir, "links", "failed")
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "ok", Reference: "op://vault/item/ok", Symlinks: []string{okLink}},
+		{Path: "failed", Reference: "op://vault/item/missing", Symlinks: []string{failedLink}},
+	}}
+
+	processor := NewProcessor(mock, tmpDir)
+	if err := processor.Process(cfg); err == nil {
+		t.Fatal("Expected the missing reference to fail")
+	}
+	if _, err := os.Lstat(failedLink); !os.IsNotExist(err) {
+		t.Errorf("Expected no symlink for a secret that was not written, got %v", err)
+	}
+	if err := processor.CheckSymlinks(cfg); err != nil {
+		t.Errorf("Expected no dangling symlinks, got %v", err)
+	}
+
+	// A link left behind by an earlier run whose target is gone
+	if err := os.Symlink(filepath.Join(tmpDir, "failed"), failedLink); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+	err := processor.CheckSymlinks(cfg)
+	if err == nil || !strings.Contains(err.Error(), failedLink) || strings.Contains(err.Error(), okLink) {
+		t.Errorf("Expected only the dangling link to be reported, got %v", err)
+	}
+}
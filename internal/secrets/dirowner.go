@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// DirOwnerSecret makes created parent directories take each secret's own
+// owner and group
+const DirOwnerSecret = "secret"
+
+// DirOwnership decides who owns the parent directories opnix creates for
+// secrets. The zero value leaves them owned by the user running opnix.
+type DirOwnership struct {
+	// MatchSecret takes each secret's owner and group; Owner and Group are ignored
+	MatchSecret bool
+	Owner       string
+	Group       string
+}
+
+// ParseDirOwnership parses "secret" or "OWNER[:GROUP]"; an empty spec turns
+// directory ownership off
+func ParseDirOwnership(spec string) (DirOwnership, error) {
+	if spec == "" {
+		return DirOwnership{}, nil
+	}
+	if spec == DirOwnerSecret {
+		return DirOwnership{MatchSecret: true}, nil
+	}
+	owner, group, _ := strings.Cut(spec, ":")
+	if owner == "" && group == "" || strings.Contains(group, ":") {
+		return DirOwnership{}, errors.ValidationError("Parsing directory owner", "output-owner-dir", spec, fmt.Sprintf("%q or OWNER[:GROUP]", DirOwnerSecret))
+	}
+	return DirOwnership{Owner: owner, Group: group}, nil
+}
+
+// SetDirOwnership chowns the parent directories created for secrets from
+// now on. Directories that already exist are never touched.
+func (p *Processor) SetDirOwnership(ownership DirOwnership) {
+	p.dirOwnership = ownership
+}
+
+// missingDirs returns dir and those of its parents that do not exist yet,
+// outermost first, so they can be chowned once MkdirAll created them
+func missingDirs(dir string) []string {
+	var missing []string
+	for dir = filepath.Clean(dir); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); err == nil || !os.IsNotExist(err) {
+			break
+		}
+		missing = append([]string{dir}, missing...)
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	return missing
+}
+
+// chownCreatedDirs gives directories created for a secret the configured
+// ownership
+func (p *Processor) chownCreatedDirs(created []string, secret config.Secret, secretName string) error {
+	owner, group := p.dirOwnership.Owner, p.dirOwnership.Group
+	if p.dirOwnership.MatchSecret {
+		owner, group = secret.Owner, secret.Group
+	}
+	if owner == "" && group == "" {
+		return nil
+	}
+	for _, dir := range created {
+		if err := p.setOwnership(dir, owner, group, secretName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
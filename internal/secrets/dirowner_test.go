@@ -0,0 +1,92 @@
+package secrets
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestParseDirOwnership(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    DirOwnership
+		wantErr bool
+	}{
+		{spec: "", want: DirOwnership{}},
+		{spec: "secret", want: DirOwnership{MatchSecret: true}},
+		{spec: "app", want: DirOwnership{Owner: "app"}},
+		{spec: "app:web", want: DirOwnership{Owner: "app", Group: "web"}},
+		{spec: ":web", want: DirOwnership{Group: "web"}},
+		{spec: ":", wantErr: true},
+		{spec: "app:web:extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseDirOwnership(tt.spec)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("ParseDirOwnership(%q) = %+v, %v; want %+v, error %v", tt.spec, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMissingDirs(t *testing.T) {
+	tmpDir := t.TempDir()
+	got := missingDirs(filepath.Join(tmpDir, "a", "b"))
+	want := []string{filepath.Join(tmpDir, "a"), filepath.Join(tmpDir, "a", "b")}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("missingDirs() = %v, want %v", got, want)
+	}
+	if got := missingDirs(tmpDir); len(got) != 0 {
+		t.Errorf("Expected no missing directories for an existing one, got %v", got)
+	}
+}
+
+func TestProcessorDirOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing ownership needs root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("No 'nobody' user to own directories")
+	}
+	uid, _ := strconv.Atoi(nobody.Uid)
+
+	tmpDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tmpDir, "app"), 0755); err != nil {
+		t.Fatalf("Failed to create existing directory: %v", err)
+	}
+	client := &mockClient{secrets: map[string]string{"op://vault/app/token": "token"}}
+	cfg := &config.Config{Secrets: []config.Secret{{
+		Path:      "app/nested/token",
+		Reference: "op://vault/app/token",
+		Owner:     "nobody",
+		Copies:    []config.SecretCopy{{Path: "copies/token", Owner: "root"}},
+	}}}
+
+	processor := NewProcessor(client, tmpDir)
+	processor.SetDirOwnership(DirOwnership{MatchSecret: true})
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	ownerOf := func(path string) int {
+		info, err := os.Stat(filepath.Join(tmpDir, path))
+		if err != nil {
+			t.Fatalf("Failed to stat %s: %v", path, err)
+		}
+		return int(info.Sys().(*syscall.Stat_t).Uid)
+	}
+	if got := ownerOf("app/nested"); got != uid {
+		t.Errorf("Expected created directory to be owned by nobody, got uid %d", got)
+	}
+	if got := ownerOf("app"); got != 0 {
+		t.Errorf("Expected existing directory to keep its owner, got uid %d", got)
+	}
+	if got := ownerOf("copies"); got != 0 {
+		t.Errorf("Expected a copy's directory to follow the copy's owner, got uid %d", got)
+	}
+}
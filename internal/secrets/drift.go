@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/systemd"
+)
+
+// Drift is how a secret's file differs from what the config would write.
+// Problems never include values.
+type Drift struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Problems []string `json:"problems"`
+}
+
+// SetCheckOnly makes Process compare each secret with its file instead of
+// writing it. Values are resolved and rendered as usual, but nothing on disk
+// is created or changed; the differences are returned by Drift.
+func (p *Processor) SetCheckOnly(checkOnly bool) {
+	p.checkOnly = checkOnly
+}
+
+// Drift returns what the last Process call found out of sync in check mode
+func (p *Processor) Drift() []Drift {
+	return p.drift
+}
+
+// compareWithDisk records how the file at path differs from the rendered
+// content, mode and ownership of secret, and which of its symlinks are
+// missing or point elsewhere
+func (p *Processor) compareWithDisk(secret config.Secret, path string, data []byte, mode os.FileMode, secretName string) error {
+	// Credentials are encrypted with a fresh nonce on every write, and a
+	// .Previous template renders whatever the file held before, so only
+	// their mode and owner can be compared
+	hash := ""
+	if !secret.CredentialEncrypted && !usesPrevious(secret) {
+		hash = systemd.HashContent(data)
+	}
+
+	problems, err := p.fileProblems(path, hash, mode, secret.Owner, secret.Group, secretName)
+	if err != nil {
+		return err
+	}
+	problems = append(problems, symlinkProblems(path, secret.Symlinks)...)
+	if len(problems) > 0 {
+		p.drift = append(p.drift, Drift{Name: secretName, Path: path, Problems: problems})
+	}
+	return nil
+}
+
+// compareCopies records how each copy of secret differs from what
+// writeCopies would write
+func (p *Processor) compareCopies(secret config.Secret, data []byte, fileMode os.FileMode, secretName string) error {
+	for i, secretCopy := range secret.Copies {
+		copyName := fmt.Sprintf("%s.copies[%d]", secretName, i)
+		path, err := p.copyPath(secret, secretCopy, copyName)
+		if err != nil {
+			return err
+		}
+		owner := copySecret(secret, secretCopy)
+		mode := fileMode
+		if secretCopy.Mode != "" {
+			parsed, err := p.parseFileMode(owner, path, copyName)
+			if err != nil {
+				return err
+			}
+			mode = os.FileMode(parsed)
+		}
+
+		encoded := encodeCopy(data, secretCopy.Encoding)
+		if err := p.compareWithDisk(owner, path, encoded, mode, copyName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// symlinkProblems lists the symlinks that do not point at target
+func symlinkProblems(target string, symlinks []string) []string {
+	var problems []string
+	for _, symlink := range symlinks {
+		info, err := os.Lstat(symlink)
+		if os.IsNotExist(err) {
+			problems = append(problems, fmt.Sprintf("symlink %s is missing", symlink))
+			continue
+		}
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot stat symlink %s: %v", symlink, err))
+			continue
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			problems = append(problems, fmt.Sprintf("%s is not a symlink", symlink))
+			continue
+		}
+		dest, err := os.Readlink(symlink)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("cannot read symlink %s: %v", symlink, err))
+		} else if dest != target {
+			problems = append(problems, fmt.Sprintf("symlink %s points to %s, expected %s", symlink, dest, target))
+		}
+	}
+	return problems
+}
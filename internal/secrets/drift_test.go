@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorCheckOnly(t *testing.T) {
+	tmpDir := t.TempDir()
+	mock := &mockClient{secrets: map[string]string{
+		"op://vault/db/password": "hunter2",
+		"op://vault/api/key":     "api-key",
+		"op://vault/tls/key":     "tls-key",
+	}}
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "db/password", Reference: "op://vault/db/password"},
+		{Path: "api/key", Reference: "op://vault/api/key", Mode: "0640"},
+		{Path: "tls/key", Reference: "op://vault/tls/key", Template: "KEY={{ .Secret }}"},
+	}}
+
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	checker := NewProcessor(mock, tmpDir)
+	checker.SetCheckOnly(true)
+	if err := checker.Process(cfg); err != nil {
+		t.Fatalf("Process() in check mode error = %v", err)
+	}
+	if drift := checker.Drift(); len(drift) != 0 {
+		t.Fatalf("Expected freshly written files to match, got %+v", drift)
+	}
+
+	// A rotated value, a loosened mode and a deleted file are all drift
+	mock.secrets["op://vault/db/password"] = "rotated"
+	if err := os.Chmod(filepath.Join(tmpDir, "api/key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(tmpDir, "tls")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checker.Process(cfg); err != nil {
+		t.Fatalf("Process() in check mode error = %v", err)
+	}
+	drift := checker.Drift()
+	if len(drift) != 3 {
+		t.Fatalf("Expected 3 drifted secrets, got %+v", drift)
+	}
+	for i, want := range []string{"content hash differs", "mode is 0644, expected 0640", "file is missing"} {
+		if !strings.Contains(strings.Join(drift[i].Problems, "; "), want) {
+			t.Errorf("Drift %d = %+v, want a problem containing %q", i, drift[i], want)
+		}
+	}
+
+	// Nothing was written: the old value and the missing directory stay as they are
+	if content, _ := os.ReadFile(filepath.Join(tmpDir, "db/password")); string(content) != "hunter2" {
+		t.Errorf("Check mode must not rewrite files, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "tls")); !os.IsNotExist(err) {
+		t.Errorf("Check mode must not create directories, got %v", err)
+	}
+	for _, d := range drift {
+		if strings.Contains(strings.Join(d.Problems, " "), "rotated") {
+			t.Errorf("Drift must never include values: %+v", d)
+		}
+	}
+}
+
+func TestProcessorCheckOnlyPreviousTemplate(t *testing.T) {
+	tmpDir := t.TempDir()
+	mock := &mockClient{secrets: map[string]string{"op://vault/db/password": "hunter2"}}
+	cfg := &config.Config{Secrets: []config.Secret{{
+		Path:      "db/env",
+		Reference: "op://vault/db/password",
+		Mode:      "0640",
+		Template:  "OLD={{ .Previous }}\nNEW={{ .Secret }}",
+	}}}
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	// Rendering again would carry the file into itself, so only mode and
+	// owner are compared
+	checker := NewProcessor(mock, tmpDir)
+	checker.SetCheckOnly(true)
+	if err := checker.Process(cfg); err != nil {
+		t.Fatalf("Process() in check mode error = %v", err)
+	}
+	if drift := checker.Drift(); len(drift) != 0 {
+		t.Fatalf("Expected a .Previous template not to report content drift, got %+v", drift)
+	}
+
+	if err := os.Chmod(filepath.Join(tmpDir, "db/env"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Process(cfg); err != nil {
+		t.Fatalf("Process() in check mode error = %v", err)
+	}
+	if drift := checker.Drift(); len(drift) != 1 || !strings.Contains(strings.Join(drift[0].Problems, "; "), "mode is 0644") {
+		t.Errorf("Expected the mode to still be compared, got %+v", drift)
+	}
+}
+
+func TestProcessorCheckOnlyCopiesAndSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	link := filepath.Join(tmpDir, "links/key.pem")
+	mock := &mockClient{secrets: map[string]string{"op://vault/tls/key": "tls-key"}}
+	cfg := &config.Config{Secrets: []config.Secret{{
+		Path:      "tls/key.pem",
+		Reference: "op://vault/tls/key",
+		Symlinks:  []string{link},
+		Copies: []config.SecretCopy{
+			{Path: "tls/key.b64", Encoding: "base64"},
+			{Path: "private/key.pem", Mode: "0640"},
+		},
+	}}}
+
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	checker := NewProcessor(mock, tmpDir)
+	checker.SetCheckOnly(true)
+	if err := checker.Process(cfg); err != nil {
+		t.Fatalf("Process() in check mode error = %v", err)
+	}
+	if drift := checker.Drift(); len(drift) != 0 {
+		t.Fatalf("Expected freshly written copies and symlinks to match, got %+v", drift)
+	}
+
+	// An edited copy, a loosened copy mode and a repointed symlink are all drift
+	if err := os.WriteFile(filepath.Join(tmpDir, "tls/key.b64"), []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(tmpDir, "private/key.pem"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(tmpDir, "private/key.pem"), link); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checker.Process(cfg); err != nil {
+		t.Fatalf("Process() in check mode error = %v", err)
+	}
+	drift := checker.Drift()
+	if len(drift) != 3 {
+		t.Fatalf("Expected the secret and both copies to drift, got %+v", drift)
+	}
+	for i, want := range []string{"symlink " + link + " points to", "content hash differs", "mode is 0644, expected 0640"} {
+		if !strings.Contains(strings.Join(drift[i].Problems, "; "), want) {
+			t.Errorf("Drift %d = %+v, want a problem containing %q", i, drift[i], want)
+		}
+	}
+	if drift[1].Name != "secret[0]:tls/key.pem.copies[0]" || drift[1].Path != filepath.Join(tmpDir, "tls/key.b64") {
+		t.Errorf("Expected the copy to be reported by its own name and path, got %+v", drift[1])
+	}
+
+	// A plain file where the symlink should be is drift too
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(link, []byte("tls-key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := checker.Process(cfg); err != nil {
+		t.Fatalf("Process() in check mode error = %v", err)
+	}
+	if drift := checker.Drift(); len(drift) == 0 || !strings.Contains(strings.Join(drift[0].Problems, "; "), "is not a symlink") {
+		t.Errorf("Expected a regular file in place of the symlink to be reported, got %+v", drift)
+	}
+}
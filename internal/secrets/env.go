@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// tokenEnvVar is never passed on to child processes
+const tokenEnvVar = "OP_SERVICE_ACCOUNT_TOKEN"
+
+// ResolveEnv resolves an ENV_NAME -> reference map into KEY=value pairs,
+// sorted by key. Errors only ever name the variable and reference.
+func ResolveEnv(client SecretClient, env map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value, err := client.ResolveSecret(env[key])
+		if err != nil {
+			return nil, errors.OnePasswordError(
+				fmt.Sprintf("Resolving environment variable %s", key),
+				fmt.Sprintf("Failed to resolve 1Password reference: %s", env[key]),
+				err,
+			)
+		}
+		pairs = append(pairs, key+"="+value)
+	}
+
+	return pairs, nil
+}
+
+// ChildEnvironment builds the environment for a child process: the current
+// environment without the service account token, overlaid with resolved
+func ChildEnvironment(resolved []string) []string {
+	overridden := make(map[string]bool, len(resolved))
+	for _, pair := range resolved {
+		overridden[strings.SplitN(pair, "=", 2)[0]] = true
+	}
+
+	var environ []string
+	for _, pair := range os.Environ() {
+		key := strings.SplitN(pair, "=", 2)[0]
+		if key == tokenEnvVar || overridden[key] {
+			continue
+		}
+		environ = append(environ, pair)
+	}
+
+	return append(environ, resolved...)
+}
+
+// resolveEnvFile resolves every entry of an env-file secret and renders them
+// as KEY="value" lines in configuration order, or by key when canonical
+func (p *Processor) resolveEnvFile(secret config.Secret, secretName string) (string, error) {
+	entries := secret.EnvFile
+	if secret.Canonical {
+		entries = canonicalEnvFile(entries)
+	}
+
+	var sb strings.Builder
+	for _, entry := range entries {
+		entrySecret := secret
+		entrySecret.Reference = entry.Reference
+
+		value, err := p.resolveWithRetry(entrySecret, secretName)
+		if err != nil {
+			return "", errors.OnePasswordError(
+				fmt.Sprintf("Resolving %s for secret %s", entry.Key, secretName),
+				fmt.Sprintf("Failed to resolve 1Password reference: %s", entry.Reference),
+				err,
+			)
+		}
+		if err := p.checkPlaceholder(value, fmt.Sprintf("%s.envFile.%s", secretName, entry.Key)); err != nil {
+			return "", err
+		}
+
+		sb.WriteString(entry.Key)
+		sb.WriteString("=")
+		sb.WriteString(quoteEnvValue(value))
+		sb.WriteString("\n")
+	}
+
+	return sb.String(), nil
+}
+
+// quoteEnvValue double-quotes a value so it survives both dotenv parsers and
+// shells that source the file
+func quoteEnvValue(value string) string {
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"$", `\$`,
+		"`", "\\`",
+		"\n", `\n`,
+	)
+	return `"` + replacer.Replace(value) + `"`
+}
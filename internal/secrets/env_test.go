@@ -0,0 +1,106 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestResolveEnv(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/db/password": "db-pass",
+			"op://vault/api/key":     "api-key",
+		},
+	}
+
+	pairs, err := ResolveEnv(mock, map[string]string{
+		"DB_PASSWORD": "op://vault/db/password",
+		"API_KEY":     "op://vault/api/key",
+	})
+	if err != nil {
+		t.Fatalf("Failed to resolve env: %v", err)
+	}
+
+	expected := []string{"API_KEY=api-key", "DB_PASSWORD=db-pass"}
+	if len(pairs) != len(expected) {
+		t.Fatalf("Expected %d pairs, got %d", len(expected), len(pairs))
+	}
+	for i := range expected {
+		if pairs[i] != expected[i] {
+			t.Errorf("Expected pair %q, got %q", expected[i], pairs[i])
+		}
+	}
+
+	if _, err := ResolveEnv(mock, map[string]string{"MISSING": "op://vault/missing/field"}); err == nil {
+		t.Error("Expected error for unresolvable reference")
+	}
+}
+
+func TestChildEnvironment(t *testing.T) {
+	t.Setenv("OP_SERVICE_ACCOUNT_TOKEN", "ops_secret_token")
+	t.Setenv("API_KEY", "stale")
+	t.Setenv("OPNIX_TEST_KEEP", "kept")
+
+	environ := ChildEnvironment([]string{"API_KEY=fresh"})
+
+	seen := make(map[string]string)
+	for _, pair := range environ {
+		parts := strings.SplitN(pair, "=", 2)
+		if _, exists := seen[parts[0]]; exists {
+			t.Errorf("Duplicate environment key %s", parts[0])
+		}
+		seen[parts[0]] = parts[1]
+	}
+
+	if _, exists := seen["OP_SERVICE_ACCOUNT_TOKEN"]; exists {
+		t.Error("Service account token must not be passed to the child process")
+	}
+	if seen["API_KEY"] != "fresh" {
+		t.Errorf("Expected resolved value to override environment, got %q", seen["API_KEY"])
+	}
+	if seen["OPNIX_TEST_KEEP"] != "kept" {
+		t.Errorf("Expected unrelated variables to be kept, got %q", seen["OPNIX_TEST_KEEP"])
+	}
+}
+
+func TestProcessorEnvFile(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/db/password": `pa"ss$word`,
+			"op://vault/api/key":     "api-key",
+		},
+	}
+
+	tmpDir := t.TempDir()
+	processor := NewProcessor(mock, tmpDir)
+
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{
+				Path: "app.env",
+				EnvFile: []config.EnvFileEntry{
+					{Key: "DB_PASSWORD", Reference: "op://vault/db/password"},
+					{Key: "API_KEY", Reference: "op://vault/api/key"},
+				},
+			},
+		},
+	}
+
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process env-file secret: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "app.env"))
+	if err != nil {
+		t.Fatalf("Failed to read env file: %v", err)
+	}
+
+	expected := "DB_PASSWORD=\"pa\\\"ss\\$word\"\nAPI_KEY=\"api-key\"\n"
+	if string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+}
@@ -0,0 +1,82 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+// PathStep is one step in deriving a secret's final path
+type PathStep struct {
+	Step string `json:"step"`
+	Path string `json:"path"`
+}
+
+// PathExplanation traces how one secret's final path is derived
+type PathExplanation struct {
+	Name  string     `json:"name"`
+	Steps []PathStep `json:"steps"`
+	Path  string     `json:"path,omitempty"`
+	Error string     `json:"error,omitempty"`
+}
+
+// pathTrace collects path steps; a nil trace ignores them
+type pathTrace func(step, path string)
+
+func (t pathTrace) step(step, path string) {
+	if t != nil {
+		t(step, path)
+	}
+}
+
+// ExplainPaths traces the final path of every secret in cfg accepted by
+// match, as a run would compute it, without contacting 1Password. A path
+// that fails to resolve is explained up to the failing step.
+func (p *Processor) ExplainPaths(cfg *config.Config, match func(index int, secret config.Secret) bool) []PathExplanation {
+	p.applyConfig(cfg)
+
+	var explanations []PathExplanation
+	for i, configured := range cfg.Secrets {
+		if !match(i, configured) {
+			continue
+		}
+		for _, secret := range configured.ExpandFields() {
+			explanation := PathExplanation{Name: fmt.Sprintf("secret[%d]:%s", i, secret.Path)}
+			if configured.Item != "" {
+				explanation.Steps = append(explanation.Steps, PathStep{
+					Step: fmt.Sprintf("Field %s of item %s maps to path %q", strings.TrimPrefix(secret.Reference, strings.TrimSuffix(configured.Item, "/")+"/"), configured.Item, secret.Path),
+					Path: secret.Path,
+				})
+			}
+			path, err := p.tracePath(secret, explanation.Name, func(step, path string) {
+				explanation.Steps = append(explanation.Steps, PathStep{Step: step, Path: path})
+			})
+			if err != nil {
+				explanation.Error = err.Error()
+			} else {
+				explanation.Path = path
+			}
+			explanations = append(explanations, explanation)
+		}
+	}
+	return explanations
+}
+
+// variableSource names where a {name} or {name:-default} placeholder's
+// value came from, mirroring validation.LookupVariable
+func variableSource(placeholder string, variables, defaults map[string]string) string {
+	name, _, hasDefault := strings.Cut(strings.Trim(placeholder, "{}"), ":-")
+	value, inVariables := variables[name]
+	if !inVariables {
+		value = defaults[name]
+	}
+	switch {
+	case hasDefault && value == "":
+		return "the placeholder's inline default"
+	case inVariables:
+		return "the secret's variables"
+	default:
+		return "the config's defaults"
+	}
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestExplainPaths(t *testing.T) {
+	cfg := &config.Config{
+		PathTemplate: "{service}/{env:-dev}/{name}",
+		PathPrefix:   "app",
+		Defaults:     map[string]string{"service": "web"},
+		Secrets: []config.Secret{
+			{Reference: "op://Vault/Item/a", Variables: map[string]string{"name": "db"}},
+			{Path: "/etc/{service}/token", Reference: "op://Vault/Item/b"},
+			{Item: "op://Vault/Item", Fields: map[string]string{"cert": "tls/cert.pem"}},
+			{Reference: "op://Vault/Item/c"},
+		},
+	}
+
+	processor := NewProcessor(nil, "/var/lib/opnix/secrets")
+	explanations := processor.ExplainPaths(cfg, func(i int, secret config.Secret) bool { return true })
+	if len(explanations) != 4 {
+		t.Fatalf("Expected 4 explanations, got %+v", explanations)
+	}
+
+	templated := explanations[0]
+	if templated.Path != "/var/lib/opnix/secrets/app/web/dev/db" {
+		t.Errorf("Expected the templated path, got %q", templated.Path)
+	}
+	var steps []string
+	for _, step := range templated.Steps {
+		steps = append(steps, step.Step)
+	}
+	for i, want := range []string{
+		`using pathTemplate "{service}/{env:-dev}/{name}"`,
+		`{service} with "web" from the config's defaults`,
+		`{env:-dev} with "dev" from the placeholder's inline default`,
+		`{name} with "db" from the secret's variables`,
+		`outputDir "/var/lib/opnix/secrets" and pathPrefix "app"`,
+	} {
+		if i >= len(steps) || !strings.Contains(steps[i], want) {
+			t.Errorf("Expected step %d to mention %s, got %v", i+1, want, steps)
+		}
+	}
+
+	if absolute := explanations[1]; absolute.Path != "/etc/web/token" || !strings.Contains(absolute.Steps[len(absolute.Steps)-1].Step, "absolute") {
+		t.Errorf("Expected the absolute path to skip outputDir, got %+v", absolute)
+	}
+	if field := explanations[2]; field.Path != "/var/lib/opnix/secrets/app/tls/cert.pem" || !strings.Contains(field.Steps[0].Step, "Field cert of item op://Vault/Item") {
+		t.Errorf("Expected the item field's path to be explained, got %+v", field)
+	}
+	if failed := explanations[3]; failed.Path != "" || !strings.Contains(failed.Error, "{name}") {
+		t.Errorf("Expected the missing variable to be reported, got %+v", failed)
+	}
+
+	// The trace leaves the resolved paths unchanged
+	resolved, err := processor.resolveSecretPathWithTemplate(cfg.Secrets[0], "secret[0]")
+	if err != nil || resolved != templated.Path {
+		t.Errorf("Expected %q from resolveSecretPathWithTemplate, got %q, %v", templated.Path, resolved, err)
+	}
+}
@@ -0,0 +1,47 @@
+package secrets
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// extractValue returns the first capture group of pattern's first match in
+// value. The pattern was checked when the config was loaded. Errors never
+// include the value.
+func extractValue(pattern, value, secretName string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", errors.ConfigValidationError(
+			fmt.Sprintf("%s.extract", secretName),
+			pattern,
+			fmt.Sprintf("Invalid regular expression: %v", err),
+			nil,
+		)
+	}
+
+	group, ok := firstGroup(re, value)
+	if !ok {
+		return "", &errors.OpnixError{
+			Operation: fmt.Sprintf("Extracting value of %s", secretName),
+			Component: "secret processing",
+			Issue:     fmt.Sprintf("The resolved value does not match extract pattern %q", pattern),
+			Suggestions: []string{
+				"Nothing was written; the previous file is unchanged",
+				"Check the field in 1Password still holds the expected format",
+				"Test the pattern against a sample value of the same format",
+			},
+		}
+	}
+	return group, nil
+}
+
+// firstGroup returns the first capture group of re's first match in value
+func firstGroup(re *regexp.Regexp, value string) (string, bool) {
+	match := re.FindStringSubmatchIndex(value)
+	if match == nil || len(match) < 4 || match[2] < 0 {
+		return "", false
+	}
+	return value[match[2]:match[3]], true
+}
@@ -0,0 +1,170 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// defaultFIFOTimeout bounds how long we wait for a reader to open a FIFO
+const defaultFIFOTimeout = 30 * time.Second
+
+// fifoPollInterval is how often we retry opening a FIFO without a reader
+const fifoPollInterval = 50 * time.Millisecond
+
+// deliverFIFO writes a secret value once to a named pipe so it never touches
+// persistent storage. Ownership and symlinks are applied before the write,
+// since the reader needs access to the FIFO before it can open it.
+func (p *Processor) deliverFIFO(secret config.Secret, outputPath string, data []byte, mode os.FileMode, secretName string) error {
+	if err := ensureFIFO(outputPath, mode, secretName); err != nil {
+		return err
+	}
+
+	if secret.Owner != "" || secret.Group != "" {
+		if err := p.setOwnership(outputPath, secret.Owner, secret.Group, secretName); err != nil {
+			return err
+		}
+	}
+
+	if err := p.createSymlinks(outputPath, secret.Symlinks, secret.SymlinkDirMode, secretName); err != nil {
+		return err
+	}
+
+	timeout := defaultFIFOTimeout
+	if secret.FIFOTimeout != "" {
+		parsed, err := time.ParseDuration(secret.FIFOTimeout)
+		if err != nil {
+			return errors.ValidationError(
+				fmt.Sprintf("Parsing FIFO timeout for %s", secretName),
+				"fifoTimeout",
+				secret.FIFOTimeout,
+				"positive duration (e.g., 30s, 5m)",
+			)
+		}
+		timeout = parsed
+	}
+
+	return writeFIFO(outputPath, data, timeout, secretName)
+}
+
+// ensureFIFO creates the named pipe if needed and refuses to replace
+// anything that is not already a FIFO
+func ensureFIFO(path string, mode os.FileMode, secretName string) error {
+	info, err := os.Lstat(path)
+	if err == nil {
+		if info.Mode()&os.ModeNamedPipe == 0 {
+			return errors.FileOperationError(
+				fmt.Sprintf("Preparing FIFO for %s", secretName),
+				path,
+				"Target exists and is not a named pipe",
+				nil,
+			)
+		}
+		return chmodFIFO(path, mode, secretName)
+	}
+	if !os.IsNotExist(err) {
+		return errors.FileOperationError(
+			fmt.Sprintf("Preparing FIFO for %s", secretName),
+			path,
+			"Failed to inspect FIFO target",
+			err,
+		)
+	}
+
+	if err := syscall.Mkfifo(path, uint32(mode.Perm())); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Creating FIFO for %s", secretName),
+			path,
+			"Failed to create named pipe",
+			err,
+		)
+	}
+
+	// Mkfifo is subject to the umask, so set the exact mode explicitly
+	return chmodFIFO(path, mode, secretName)
+}
+
+// chmodFIFO sets the named pipe's exact mode
+func chmodFIFO(path string, mode os.FileMode, secretName string) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Setting permissions for FIFO %s", secretName),
+			path,
+			fmt.Sprintf("Failed to set file mode %04o", mode),
+			err,
+		)
+	}
+	return nil
+}
+
+// writeFIFO waits up to timeout for a reader and writes data to it once.
+// Opening a FIFO for writing blocks until a reader appears, so the open is
+// polled in non-blocking mode to keep the wait bounded. The write gets the
+// same timeout again, so a reader that stops reading cannot hang the run.
+func writeFIFO(path string, data []byte, timeout time.Duration, secretName string) error {
+	deadline := time.Now().Add(timeout)
+
+	var fd int
+	for {
+		var err error
+		fd, err = syscall.Open(path, syscall.O_WRONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+		if err == nil {
+			break
+		}
+		if err != syscall.ENXIO {
+			return errors.FileOperationError(
+				fmt.Sprintf("Opening FIFO for %s", secretName),
+				path,
+				"Failed to open named pipe for writing",
+				err,
+			)
+		}
+		if time.Now().After(deadline) {
+			return errors.FileOperationError(
+				fmt.Sprintf("Opening FIFO for %s", secretName),
+				path,
+				fmt.Sprintf("No reader opened the named pipe within %s", timeout),
+				nil,
+			)
+		}
+		time.Sleep(fifoPollInterval)
+	}
+
+	// The descriptor stays non-blocking, so the runtime poller waits for the
+	// reader to drain the pipe and values larger than its buffer are still
+	// delivered fully, within the deadline
+	file := os.NewFile(uintptr(fd), path)
+	defer func() { _ = file.Close() }() // Closing signals EOF to the reader
+
+	if err := file.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Configuring FIFO for %s", secretName),
+			path,
+			"Failed to set a write deadline on the named pipe",
+			err,
+		)
+	}
+
+	if _, err := file.Write(data); err != nil {
+		if os.IsTimeout(err) {
+			return errors.FileOperationError(
+				fmt.Sprintf("Writing FIFO for %s", secretName),
+				path,
+				fmt.Sprintf("The reader did not read the secret within %s", timeout),
+				err,
+			)
+		}
+		return errors.FileOperationError(
+			fmt.Sprintf("Writing FIFO for %s", secretName),
+			path,
+			"Failed to write secret to named pipe",
+			err,
+		)
+	}
+
+	return nil
+}
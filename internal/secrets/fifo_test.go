@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorFIFO(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/field": "fifo-secret-value",
+		},
+	}
+
+	tmpDir := t.TempDir()
+	fifoPath := filepath.Join(tmpDir, "pipe")
+	processor := NewProcessor(mock, tmpDir)
+
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{
+				Path:        "pipe",
+				Reference:   "op://vault/item/field",
+				FIFO:        true,
+				FIFOTimeout: "5s",
+			},
+		},
+	}
+
+	// Act as the consuming service: wait for the FIFO, then read it once
+	received := make(chan string, 1)
+	go func() {
+		for {
+			if info, err := os.Lstat(fifoPath); err == nil && info.Mode()&os.ModeNamedPipe != 0 {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		f, err := os.Open(fifoPath)
+		if err != nil {
+			received <- "open error: " + err.Error()
+			return
+		}
+		defer f.Close()
+		data, _ := io.ReadAll(f)
+		received <- string(data)
+	}()
+
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process FIFO secret: %v", err)
+	}
+
+	select {
+	case got := <-received:
+		if got != "fifo-secret-value" {
+			t.Errorf("Expected FIFO to deliver secret value, got %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for FIFO reader")
+	}
+
+	info, err := os.Lstat(fifoPath)
+	if err != nil {
+		t.Fatalf("Failed to stat FIFO: %v", err)
+	}
+	if info.Mode()&os.ModeNamedPipe == 0 {
+		t.Errorf("Expected %s to be a named pipe, got mode %v", fifoPath, info.Mode())
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected FIFO mode 0600, got %o", info.Mode().Perm())
+	}
+}
+
+func TestProcessorFIFOTimeout(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/field": "fifo-secret-value",
+		},
+	}
+
+	processor := NewProcessor(mock, t.TempDir())
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{
+				Path:        "pipe",
+				Reference:   "op://vault/item/field",
+				FIFO:        true,
+				FIFOTimeout: "100ms",
+			},
+		},
+	}
+
+	err := processor.Process(cfg)
+	if err == nil {
+		t.Fatal("Expected timeout error without a reader, got nil")
+	}
+	if !contains(err.Error(), "No reader opened the named pipe") {
+		t.Errorf("Expected FIFO timeout error, got: %v", err)
+	}
+}
+
+func TestEnsureFIFORejectsRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regular")
+	if err := os.WriteFile(path, []byte("data"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := ensureFIFO(path, 0600, "test-secret"); err == nil {
+		t.Error("Expected error when target is a regular file, got nil")
+	}
+}
+
+func TestWriteFIFOStalledReader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pipe")
+	if err := ensureFIFO(path, 0600, "test-secret"); err != nil {
+		t.Fatalf("ensureFIFO() error = %v", err)
+	}
+
+	// A reader that opens the pipe but never reads from it
+	reader, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		t.Fatalf("Failed to open FIFO for reading: %v", err)
+	}
+	defer reader.Close()
+
+	// Larger than the pipe buffer, so the write cannot finish
+	data := make([]byte, 1<<20)
+	done := make(chan error, 1)
+	go func() { done <- writeFIFO(path, data, 100*time.Millisecond, "test-secret") }()
+
+	select {
+	case err := <-done:
+		if err == nil || !contains(err.Error(), "did not read the secret") {
+			t.Errorf("Expected the stalled write to time out, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the write to a stalled reader to time out, but it blocked")
+	}
+}
@@ -0,0 +1,76 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// filesystemKind groups filesystem types by how safe they are for secrets
+type filesystemKind int
+
+const (
+	filesystemLocal filesystemKind = iota
+	// Network filesystems may cache, share or expose secrets beyond this host
+	filesystemNetwork
+)
+
+// filesystemStat classifies an existing path; overridden in tests
+var filesystemStat = statFilesystem
+
+// filesystemInfo is the classification of the filesystem holding a path
+type filesystemInfo struct {
+	Kind filesystemKind
+	Name string
+}
+
+// classifyFilesystem classifies the filesystem a path lives on. Paths that do
+// not exist yet are classified by their nearest existing ancestor.
+func classifyFilesystem(path string) (filesystemInfo, error) {
+	existing := path
+	for {
+		if _, err := os.Stat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+
+	return filesystemStat(existing)
+}
+
+// checkNetworkFilesystem applies the networkFilesystem policy to the directory
+// a secret is about to be written into
+func (p *Processor) checkNetworkFilesystem(dir, secretName string) error {
+	if p.networkFilesystem == "allow" {
+		return nil
+	}
+
+	info, err := classifyFilesystem(dir)
+	if err != nil || info.Kind != filesystemNetwork {
+		// Classification is best effort, an unknown filesystem never blocks a write
+		return nil
+	}
+
+	if p.networkFilesystem == "refuse" {
+		err := errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", secretName),
+			dir,
+			fmt.Sprintf("Refusing to write secret to a network filesystem (%s)", info.Name),
+			nil,
+		)
+		err.Suggestions = append(err.Suggestions,
+			"Write secrets to local storage such as /run or /var/lib instead",
+			"Set networkFilesystem = \"warn\" or \"allow\" if this mount is intended",
+		)
+		return err
+	}
+
+	p.warnings.Addf("file system", "%s is written to a network filesystem (%s) at %s", secretName, info.Name, dir)
+	return nil
+}
@@ -0,0 +1,33 @@
+package secrets
+
+import (
+	"syscall"
+)
+
+// Filesystem type names reported by statfs(2) on macOS
+var filesystemNames = map[string]filesystemKind{
+	"nfs":    filesystemNetwork,
+	"smbfs":  filesystemNetwork,
+	"afpfs":  filesystemNetwork,
+	"webdav": filesystemNetwork,
+}
+
+func statFilesystem(path string) (filesystemInfo, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return filesystemInfo{}, err
+	}
+
+	var name []byte
+	for _, c := range stat.Fstypename {
+		if c == 0 {
+			break
+		}
+		name = append(name, byte(c))
+	}
+
+	if kind, ok := filesystemNames[string(name)]; ok {
+		return filesystemInfo{Kind: kind, Name: string(name)}, nil
+	}
+	return filesystemInfo{Kind: filesystemLocal, Name: string(name)}, nil
+}
@@ -0,0 +1,29 @@
+package secrets
+
+import (
+	"syscall"
+)
+
+// Filesystem magic numbers from statfs(2)
+var filesystemMagic = map[int64]filesystemInfo{
+	0x6969:     {filesystemNetwork, "nfs"},
+	0x517b:     {filesystemNetwork, "smb"},
+	0xff534d42: {filesystemNetwork, "cifs"},
+	0xfe534d42: {filesystemNetwork, "smb2"},
+	0x73757245: {filesystemNetwork, "coda"},
+	0x5346414f: {filesystemNetwork, "afs"},
+	0x00c36400: {filesystemNetwork, "ceph"},
+	0x01021997: {filesystemNetwork, "9p"},
+}
+
+func statFilesystem(path string) (filesystemInfo, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return filesystemInfo{}, err
+	}
+
+	if info, ok := filesystemMagic[int64(stat.Type)]; ok {
+		return info, nil
+	}
+	return filesystemInfo{Kind: filesystemLocal, Name: "local"}, nil
+}
@@ -0,0 +1,83 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestClassifyFilesystemMissingPath(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	want, err := classifyFilesystem(tmpDir)
+	if err != nil {
+		t.Fatalf("Failed to classify temp dir: %v", err)
+	}
+
+	got, err := classifyFilesystem(filepath.Join(tmpDir, "does", "not", "exist"))
+	if err != nil {
+		t.Fatalf("Failed to classify missing path: %v", err)
+	}
+	if got != want {
+		t.Errorf("Expected missing path to be classified like its ancestor (%+v), got %+v", want, got)
+	}
+}
+
+func TestProcessorNetworkFilesystemPolicy(t *testing.T) {
+	original := filesystemStat
+	filesystemStat = func(path string) (filesystemInfo, error) {
+		return filesystemInfo{Kind: filesystemNetwork, Name: "nfs"}, nil
+	}
+	defer func() { filesystemStat = original }()
+
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/field": "secret-value",
+		},
+	}
+
+	tests := []struct {
+		policy    string
+		wantError bool
+	}{
+		{policy: "", wantError: false},
+		{policy: "warn", wantError: false},
+		{policy: "allow", wantError: false},
+		{policy: "refuse", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run("policy "+tt.policy, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			processor := NewProcessor(mock, tmpDir)
+
+			cfg := &config.Config{
+				NetworkFilesystem: tt.policy,
+				Secrets: []config.Secret{
+					{Path: "secret", Reference: "op://vault/item/field"},
+				},
+			}
+
+			err := processor.Process(cfg)
+			_, statErr := os.Stat(filepath.Join(tmpDir, "secret"))
+
+			if tt.wantError {
+				if err == nil || !contains(err.Error(), "network filesystem") {
+					t.Errorf("Expected network filesystem error, got: %v", err)
+				}
+				if statErr == nil {
+					t.Error("Expected no secret file to be written")
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("Expected no error, got: %v", err)
+			}
+			if statErr != nil {
+				t.Errorf("Expected secret file to be written: %v", statErr)
+			}
+		})
+	}
+}
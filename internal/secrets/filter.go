@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// defaultFilterTimeout bounds a filter command when no timeout is configured
+const defaultFilterTimeout = 30 * time.Second
+
+// runFilter pipes a resolved value through the secret's filter command and
+// returns its stdout as the value to write. Like validateWith, the command
+// runs with only PATH in its environment and as the secret's owner when opnix
+// runs as root; the value reaches it on stdin only.
+func (p *Processor) runFilter(secret config.Secret, value, secretName string) (string, error) {
+	timeout := defaultFilterTimeout
+	if secret.FilterTimeout != "" {
+		parsed, err := time.ParseDuration(secret.FilterTimeout)
+		if err != nil {
+			return "", errors.ValidationError(
+				fmt.Sprintf("Parsing filter timeout for %s", secretName),
+				"filterTimeout",
+				secret.FilterTimeout,
+				"positive duration (e.g., 10s, 1m)",
+			)
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, secret.Filter[0], secret.Filter[1:]...)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	cmd.Dir = "/"
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = strings.NewReader(value)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := p.runAsOwner(cmd, secret, secretName); err != nil {
+		return "", err
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return stdout.String(), nil
+	}
+
+	issue := fmt.Sprintf("Filter command %s failed", secret.Filter[0])
+	if ctx.Err() == context.DeadlineExceeded {
+		issue = fmt.Sprintf("Filter command %s timed out after %s", secret.Filter[0], timeout)
+	}
+
+	// Only stderr is reported; stdout holds the transformed value
+	return "", &errors.OpnixError{
+		Operation: fmt.Sprintf("Filtering secret %s", secretName),
+		Component: "secret processing",
+		Issue:     issue,
+		Context:   fmt.Sprintf("Stderr: %s", validateOutput(stderr.Bytes(), value)),
+		Suggestions: []string{
+			"Nothing was written; the previous file is unchanged",
+			fmt.Sprintf("Run the command by hand with the value on stdin: %s", strings.Join(secret.Filter, " ")),
+			"Increase filterTimeout if the command needs longer",
+		},
+		Cause: err,
+	}
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorFilter(t *testing.T) {
+	t.Setenv("OPNIX_FILTER_TEST", "leaked")
+	mock := &mockClient{secrets: map[string]string{"op://vault/item/field": `{"password": "s3cret"}`}}
+
+	t.Run("stdout is written", func(t *testing.T) {
+		filter := writeScript(t, t.TempDir(), `tr -d '{}" ' | cut -d: -f2; printf '%s' "$OPNIX_FILTER_TEST"`)
+		tmpDir := t.TempDir()
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path: "password", Reference: "op://vault/item/field", Filter: []string{filter},
+		}}}
+		if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+			t.Fatalf("Failed to process secret: %v", err)
+		}
+		content, _ := os.ReadFile(filepath.Join(tmpDir, "password"))
+		if string(content) != "s3cret\n" {
+			t.Errorf("Expected the filtered value without the environment, got %q", content)
+		}
+	})
+
+	t.Run("non-zero exit", func(t *testing.T) {
+		filter := writeScript(t, t.TempDir(), `cat; echo "cannot decrypt" >&2; exit 3`)
+		tmpDir := t.TempDir()
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path: "password", Reference: "op://vault/item/field", Filter: []string{filter},
+		}}}
+		err := NewProcessor(mock, tmpDir).Process(cfg)
+		if err == nil || !strings.Contains(err.Error(), "cannot decrypt") {
+			t.Fatalf("Expected the filter's stderr in the error, got: %v", err)
+		}
+		if strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("Error exposes the value: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "password")); !os.IsNotExist(err) {
+			t.Error("Expected nothing to be written")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		slow := writeScript(t, t.TempDir(), "sleep 5")
+		cfg := &config.Config{Secrets: []config.Secret{{
+			Path: "password", Reference: "op://vault/item/field", Filter: []string{slow}, FilterTimeout: "100ms",
+		}}}
+		err := NewProcessor(mock, t.TempDir()).Process(cfg)
+		if err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected timeout error, got: %v", err)
+		}
+	})
+}
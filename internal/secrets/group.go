@@ -0,0 +1,108 @@
+package secrets
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+// defaultGroupParallel bounds concurrent item batches when resolve.parallel is unset
+const defaultGroupParallel = 4
+
+// groupable reports whether a secret resolves a single reference with the
+// default client, so its value can be fetched ahead of time with its item.
+// Attachments written from their reader are left out so they are never held
+// as strings, serial secrets so they are read no earlier than their place in
+// the config, and skipIfExists and onlyIf secrets so nothing is resolved
+// before their condition is checked.
+func groupable(secret config.Secret) bool {
+	return secret.Reference != "" && secret.Item == "" && len(secret.EnvFile) == 0 &&
+		secret.Account == "" && len(secret.FieldFallbacks) == 0 && !writesAttachment(secret) &&
+		!secret.Serial && !secret.SkipIfExists && secret.OnlyIf == nil
+}
+
+// itemKey returns the vault/item part of an op:// reference
+func itemKey(reference string) (string, bool) {
+	parts := strings.Split(strings.TrimPrefix(reference, "op://"), "/")
+	if !strings.HasPrefix(reference, "op://") || len(parts) < 3 {
+		return "", false
+	}
+	return parts[0] + "/" + parts[1], true
+}
+
+// prefetchByItem resolves the config's references one batch per item, in
+// parallel, when resolve.groupByItem is set. Items referenced only once gain
+// nothing from grouping and are left to the per-reference path, as are
+// batches that fail, so their errors and retries surface per secret.
+func (p *Processor) prefetchByItem(cfg *config.Config) {
+	p.prefetched = nil
+	if !p.resolve.GroupByItem {
+		return
+	}
+	batch, ok := p.client.(BatchSecretClient)
+	if !ok {
+		return
+	}
+
+	groups := make(map[string][]string)
+	var order []string
+	seen := make(map[string]bool)
+	for _, configured := range cfg.Secrets {
+		for _, secret := range configured.ExpandFields() {
+			if !groupable(secret) || seen[secret.Reference] {
+				continue
+			}
+			// Fresh cached values need no request at all
+			if _, ok := p.cached(secret); ok {
+				continue
+			}
+			key, ok := itemKey(secret.Reference)
+			if !ok {
+				continue
+			}
+			seen[secret.Reference] = true
+			if _, exists := groups[key]; !exists {
+				order = append(order, key)
+			}
+			groups[key] = append(groups[key], secret.Reference)
+		}
+	}
+
+	parallel := p.resolve.Parallel
+	if parallel <= 0 {
+		parallel = defaultGroupParallel
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	p.prefetched = make(map[string]string)
+	for _, key := range order {
+		references := groups[key]
+		if len(references) < 2 {
+			continue
+		}
+
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(key string, references []string) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			// One request per item, paced like any other
+			p.rateLimits.wait("", references[0])
+			values, err := batch.ResolveSecrets(references)
+			if err != nil {
+				p.warnings.Addf("1Password integration", "Grouped resolve of %s failed, resolving its fields one by one: %v", key, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			for reference, value := range values {
+				p.prefetched[reference] = value
+			}
+		}(key, references)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,169 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+// groupClient records each batch and single resolve; batches may run concurrently
+type groupClient struct {
+	mockClient
+	mu      sync.Mutex
+	batches []string
+	singles []string
+}
+
+func (g *groupClient) ResolveSecret(reference string) (string, error) {
+	g.mu.Lock()
+	g.singles = append(g.singles, reference)
+	g.mu.Unlock()
+	return g.mockClient.ResolveSecret(reference)
+}
+
+func (g *groupClient) ResolveSecrets(references []string) (map[string]string, error) {
+	sorted := append([]string{}, references...)
+	sort.Strings(sorted)
+	g.mu.Lock()
+	g.batches = append(g.batches, strings.Join(sorted, ","))
+	g.mu.Unlock()
+
+	values := make(map[string]string, len(references))
+	for _, reference := range references {
+		value, err := g.mockClient.ResolveSecret(reference)
+		if err != nil {
+			return nil, err
+		}
+		values[reference] = value
+	}
+	return values, nil
+}
+
+func TestProcessorGroupByItem(t *testing.T) {
+	values := map[string]string{
+		"op://vault/Database/username": "admin",
+		"op://vault/Database/password": "hunter2",
+		"op://vault/Api/key":           "api-key",
+		"op://vault/Cache/username":    "cache",
+		"op://vault/Cache/password":    "cache-pass",
+	}
+	secrets := []config.Secret{
+		{Path: "db/user", Reference: "op://vault/Database/username"},
+		{Path: "db/password", Reference: "op://vault/Database/password"},
+		{Path: "api/key", Reference: "op://vault/Api/key"},
+		{Path: "cache/user", Reference: "op://vault/Cache/username"},
+		{Path: "cache/password", Reference: "op://vault/Cache/password"},
+	}
+
+	t.Run("grouped", func(t *testing.T) {
+		client := &groupClient{mockClient: mockClient{secrets: values}}
+		tmpDir := t.TempDir()
+		cfg := &config.Config{Secrets: secrets, Resolve: config.ResolveConfig{GroupByItem: true, Parallel: 2}}
+		if err := NewProcessor(client, tmpDir).Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+
+		sort.Strings(client.batches)
+		expected := []string{
+			"op://vault/Cache/password,op://vault/Cache/username",
+			"op://vault/Database/password,op://vault/Database/username",
+		}
+		if strings.Join(client.batches, ";") != strings.Join(expected, ";") {
+			t.Errorf("Expected one batch per item, got %v", client.batches)
+		}
+		// A lone reference is not worth a batch
+		if len(client.singles) != 1 || client.singles[0] != "op://vault/Api/key" {
+			t.Errorf("Expected only the ungrouped reference to resolve singly, got %v", client.singles)
+		}
+
+		content, err := os.ReadFile(filepath.Join(tmpDir, "db/password"))
+		if err != nil || string(content) != "hunter2" {
+			t.Errorf("Expected grouped value to be written, got %q, %v", string(content), err)
+		}
+	})
+
+	t.Run("serial secrets resolve in turn", func(t *testing.T) {
+		client := &groupClient{mockClient: mockClient{secrets: values}}
+		serial := append([]config.Secret{}, secrets...)
+		serial[1].Serial = true
+		cfg := &config.Config{Secrets: serial, Resolve: config.ResolveConfig{GroupByItem: true}}
+		if err := NewProcessor(client, t.TempDir()).Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+
+		if strings.Join(client.batches, ";") != "op://vault/Cache/password,op://vault/Cache/username" {
+			t.Errorf("Expected the serial secret to leave its item ungrouped, got %v", client.batches)
+		}
+		expected := "op://vault/Database/username,op://vault/Database/password,op://vault/Api/key"
+		if strings.Join(client.singles, ",") != expected {
+			t.Errorf("Expected single resolves in config order %s, got %v", expected, client.singles)
+		}
+	})
+
+	t.Run("existing skipIfExists files are never resolved", func(t *testing.T) {
+		// The staged field does not exist yet; resolving it would fail the batch
+		staged := map[string]string{"op://vault/Database/username": "admin"}
+		client := &groupClient{mockClient: mockClient{secrets: staged}}
+		tmpDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(tmpDir, "db"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tmpDir, "db/password"), []byte("seeded"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		cfg := &config.Config{
+			Secrets: []config.Secret{
+				{Path: "db/user", Reference: "op://vault/Database/username"},
+				{Path: "db/password", Reference: "op://vault/Database/password", SkipIfExists: true},
+			},
+			Resolve: config.ResolveConfig{GroupByItem: true},
+		}
+		collector := warnings.NewCollector()
+		processor := NewProcessor(client, tmpDir)
+		processor.SetWarnings(collector)
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+
+		if len(client.batches) != 0 || strings.Join(client.singles, ",") != "op://vault/Database/username" {
+			t.Errorf("Expected only the other field to be resolved, got batches %v and singles %v", client.batches, client.singles)
+		}
+		if list := collector.List(); len(list) != 0 {
+			t.Errorf("Expected no warnings, got %+v", list)
+		}
+	})
+
+	t.Run("failed batch falls back per reference", func(t *testing.T) {
+		partial := map[string]string{
+			"op://vault/Database/username": "admin",
+			"op://vault/Database/password": "hunter2",
+			"op://vault/Api/key":           "api-key",
+			"op://vault/Cache/username":    "cache",
+		}
+		client := &groupClient{mockClient: mockClient{secrets: partial}}
+		cfg := &config.Config{Secrets: secrets, Resolve: config.ResolveConfig{GroupByItem: true}}
+		err := NewProcessor(client, t.TempDir()).Process(cfg)
+		if err == nil {
+			t.Fatal("Expected the missing reference to fail")
+		}
+		if !strings.Contains(err.Error(), "secret[4]") {
+			t.Errorf("Expected the failure to be reported for the missing secret, got: %v", err)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		client := &groupClient{mockClient: mockClient{secrets: values}}
+		if err := NewProcessor(client, t.TempDir()).Process(&config.Config{Secrets: secrets}); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+		if len(client.batches) != 0 || len(client.singles) != len(secrets) {
+			t.Errorf("Expected per-reference resolution, got %d batches and %d singles", len(client.batches), len(client.singles))
+		}
+	})
+}
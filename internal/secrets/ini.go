@@ -0,0 +1,85 @@
+package secrets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// resolveINI resolves every entry of an ini secret and renders them as an INI
+// document. Keys without a section come first, then each section in the
+// order it first appears, with its keys in configuration order. Canonical
+// secrets sort sections and keys by name instead.
+func (p *Processor) resolveINI(secret config.Secret, secretName string) (string, error) {
+	entries := secret.INI
+	if secret.Canonical {
+		entries = canonicalINI(entries)
+	}
+
+	var sections []string
+	keys := make(map[string][]string)
+	for _, entry := range entries {
+		if _, seen := keys[entry.Section]; !seen && entry.Section != "" {
+			sections = append(sections, entry.Section)
+		}
+
+		entrySecret := secret
+		entrySecret.Reference = entry.Reference
+
+		value, err := p.resolveWithRetry(entrySecret, secretName)
+		if err != nil {
+			return "", errors.OnePasswordError(
+				fmt.Sprintf("Resolving %s for secret %s", iniPlacement(entry), secretName),
+				fmt.Sprintf("Failed to resolve 1Password reference: %s", entry.Reference),
+				err,
+			)
+		}
+		if err := p.checkPlaceholder(value, fmt.Sprintf("%s.ini.%s", secretName, iniPlacement(entry))); err != nil {
+			return "", err
+		}
+
+		keys[entry.Section] = append(keys[entry.Section], entry.Key+" = "+quoteINIValue(value))
+	}
+
+	var sb strings.Builder
+	for _, line := range keys[""] {
+		sb.WriteString(line + "\n")
+	}
+	for i, section := range sections {
+		if i > 0 || len(keys[""]) > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString("[" + section + "]\n")
+		for _, line := range keys[section] {
+			sb.WriteString(line + "\n")
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// iniPlacement names an entry as section.key, or key outside any section
+func iniPlacement(entry config.INIEntry) string {
+	if entry.Section == "" {
+		return entry.Key
+	}
+	return entry.Section + "." + entry.Key
+}
+
+// quoteINIValue leaves plain values bare and double-quotes anything an INI
+// parser could misread: surrounding whitespace, comment characters, quotes,
+// backslashes and line breaks
+func quoteINIValue(value string) string {
+	if value != "" && value == strings.TrimSpace(value) && !strings.ContainsAny(value, ";#\"'\\\n\r=[]") {
+		return value
+	}
+	replacer := strings.NewReplacer(
+		`\`, `\\`,
+		`"`, `\"`,
+		"\n", `\n`,
+		"\r", `\r`,
+	)
+	return `"` + replacer.Replace(value) + `"`
+}
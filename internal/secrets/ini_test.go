@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorINI(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/Database/password": "p;ss\"word",
+			"op://vault/Database/username": "admin",
+			"op://vault/Api/key":           "  padded",
+			"op://vault/App/name":          "demo",
+		},
+	}
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{Secrets: []config.Secret{{
+		Path: "app.ini",
+		INI: []config.INIEntry{
+			{Section: "database", Key: "user", Reference: "op://vault/Database/username"},
+			{Section: "api", Key: "key", Reference: "op://vault/Api/key"},
+			{Key: "name", Reference: "op://vault/App/name"},
+			{Section: "database", Key: "password", Reference: "op://vault/Database/password"},
+		},
+	}}}
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Failed to process ini secret: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tmpDir, "app.ini"))
+	if err != nil {
+		t.Fatalf("Failed to read ini file: %v", err)
+	}
+
+	expected := `name = demo
+
+[database]
+user = admin
+password = "p;ss\"word"
+
+[api]
+key = "  padded"
+`
+	if string(content) != expected {
+		t.Errorf("Unexpected ini file:\n%s\nexpected:\n%s", content, expected)
+	}
+}
+
+func TestQuoteINIValue(t *testing.T) {
+	tests := map[string]string{
+		"plain":      "plain",
+		"":           `""`,
+		"a # b":      `"a # b"`,
+		"line\nnext": `"line\nnext"`,
+		`back\slash`: `"back\\slash"`,
+		"trailing ":  `"trailing "`,
+	}
+	for value, expected := range tests {
+		if got := quoteINIValue(value); got != expected {
+			t.Errorf("quoteINIValue(%q) = %s, expected %s", value, got, expected)
+		}
+	}
+}
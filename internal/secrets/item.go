@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// processItemFields writes several fields of one item, each to its own path,
+// resolving them together when the client supports batching
+func (p *Processor) processItemFields(secret config.Secret, secretName string) error {
+	var fields []config.Secret
+	for _, field := range secret.ExpandFields() {
+		// Seed-once fields that already exist are neither resolved nor written
+		if secret.SkipIfExists && p.targetExists(field, secretName) {
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	values, err := p.resolveItemFields(secret, fields, secretName)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		fieldName := fmt.Sprintf("%s.fields.%s", secretName, path.Base(field.Reference))
+		if err := p.checkPlaceholder(values[field.Reference], fieldName); err != nil {
+			return err
+		}
+	}
+
+	for _, field := range fields {
+		fieldName := fmt.Sprintf("%s.fields.%s", secretName, path.Base(field.Reference))
+		if err := p.writeSecret(field, fieldName, values[field.Reference]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveItemFields resolves every field reference of an item secret
+func (p *Processor) resolveItemFields(secret config.Secret, fields []config.Secret, secretName string) (map[string]string, error) {
+	client, err := p.clientFor(secret, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(fields))
+
+	batch, ok := client.(BatchSecretClient)
+	if !ok {
+		for _, field := range fields {
+			value, err := p.resolveWithRetry(field, secretName)
+			if err != nil {
+				return nil, errors.OnePasswordError(
+					fmt.Sprintf("Resolving secret %s", secretName),
+					fmt.Sprintf("Failed to resolve 1Password reference: %s", field.Reference),
+					err,
+				)
+			}
+			values[field.Reference] = value
+		}
+		return values, nil
+	}
+
+	maxRetries, timeout, err := p.resolveSettings(secret, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Fields with a fresh cached value are left out of the batch
+	var pending []config.Secret
+	var references []string
+	for _, field := range fields {
+		if value, ok := p.cached(field); ok {
+			values[field.Reference] = value
+			continue
+		}
+		pending = append(pending, field)
+		references = append(references, field.Reference)
+	}
+	if len(references) == 0 {
+		return values, nil
+	}
+
+	// The batch is paced and retried as one request against the item's vault
+	paced := secret
+	paced.Reference = references[0]
+	var resolved map[string]string
+	err = p.withRetry(paced, secretName, maxRetries, func() error {
+		var err error
+		resolved, err = p.resolveBatchOnce(batch, references, timeout)
+		return err
+	})
+	if err != nil {
+		return nil, errors.OnePasswordError(
+			fmt.Sprintf("Resolving secret %s", secretName),
+			fmt.Sprintf("Failed to resolve fields of 1Password item: %s", secret.Item),
+			err,
+		)
+	}
+	for _, field := range pending {
+		value, exists := resolved[field.Reference]
+		if !exists {
+			return nil, errors.OnePasswordError(
+				fmt.Sprintf("Resolving secret %s", secretName),
+				fmt.Sprintf("No value returned for 1Password reference: %s", field.Reference),
+				nil,
+			)
+		}
+		values[field.Reference] = value
+		p.remember(field, value)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// ManagedManifestName is the default managed-file manifest name inside the output directory
+const ManagedManifestName = ".opnix-managed.json"
+
+// Managed file types
+const (
+	managedFile    = "file"
+	managedSymlink = "symlink"
+)
+
+// ManagedFile is one file or symlink opnix created
+type ManagedFile struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	// Target is where a symlink points
+	Target string `json:"target,omitempty"`
+	// Hash is the SHA-256 of a file's content as written
+	Hash   string `json:"hash,omitempty"`
+	Secret string `json:"secret"`
+	Source string `json:"source,omitempty"`
+}
+
+// ManagedManifest lists every file and symlink opnix has created, so later
+// runs and commands know what they own. It holds hashes, never values.
+type ManagedManifest struct {
+	Files []ManagedFile `json:"files"`
+}
+
+// ManagedFiles returns the files and symlinks the last Process call left on
+// disk. Transactions that were rolled back are not included, nor are files
+// holding a region, which other tools own and uninstall must never remove.
+func (p *Processor) ManagedFiles() []ManagedFile {
+	var files []ManagedFile
+	for _, written := range p.written {
+		if !written.shared {
+			files = append(files, ManagedFile{
+				Path:   written.path,
+				Type:   managedFile,
+				Hash:   written.hash,
+				Secret: written.name,
+				Source: written.source,
+			})
+		}
+		for _, symlink := range written.symlinks {
+			files = append(files, ManagedFile{
+				Path:   symlink,
+				Type:   managedSymlink,
+				Target: written.path,
+				Secret: written.name,
+				Source: written.source,
+			})
+		}
+	}
+	return files
+}
+
+// Update records files written by a run, replacing earlier entries for the
+// same paths. Entries for files the run did not touch are kept, since opnix
+// still owns them.
+func (m *ManagedManifest) Update(files []ManagedFile) {
+	byPath := make(map[string]ManagedFile, len(m.Files)+len(files))
+	for _, file := range append(append([]ManagedFile{}, m.Files...), files...) {
+		byPath[filepath.Clean(file.Path)] = file
+	}
+
+	m.Files = make([]ManagedFile, 0, len(byPath))
+	for _, file := range byPath {
+		m.Files = append(m.Files, file)
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+}
+
+// Write replaces the manifest at path, readable by its owner only
+func (m ManagedManifest) Write(path string) error {
+	if m.Files == nil {
+		m.Files = []ManagedFile{}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.FileOperationError("Writing managed-file manifest", path, "Failed to encode managed-file manifest", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.FileOperationError("Writing managed-file manifest", path, "Failed to create managed-file manifest directory", err)
+	}
+
+	// Write then rename so a crash never leaves a truncated manifest behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-managed-*")
+	if err != nil {
+		return errors.FileOperationError("Writing managed-file manifest", path, "Failed to create managed-file manifest", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return errors.FileOperationError("Writing managed-file manifest", path, "Failed to write managed-file manifest", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.FileOperationError("Writing managed-file manifest", path, "Failed to write managed-file manifest", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.FileOperationError("Writing managed-file manifest", path, "Failed to replace managed-file manifest", err)
+	}
+	return nil
+}
+
+// LoadManagedManifest reads the manifest kept by previous runs. A missing
+// manifest means opnix manages nothing yet.
+func LoadManagedManifest(path string) (ManagedManifest, error) {
+	var manifest ManagedManifest
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, errors.FileOperationError("Reading managed-file manifest", path, "Failed to read managed-file manifest", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, errors.FileOperationError("Reading managed-file manifest", path, "Managed-file manifest is not valid JSON", err)
+	}
+	return manifest, nil
+}
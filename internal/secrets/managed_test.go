@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestManagedManifest(t *testing.T) {
+	tmpDir := t.TempDir()
+	mock := &mockClient{secrets: map[string]string{
+		"op://vault/tls/key":   "private-key",
+		"op://vault/app/token": "token",
+	}}
+	link := filepath.Join(tmpDir, "links", "key.pem")
+	cfg := &config.Config{Secrets: []config.Secret{
+		{
+			Path:      "tls/key.pem",
+			Reference: "op://vault/tls/key",
+			Symlinks:  []string{link},
+			Copies:    []config.SecretCopy{{Path: "tls/key.b64", Encoding: "base64"}},
+			Source:    "/etc/opnix/tls.json",
+		},
+		{Path: "app/token", Reference: "op://vault/app/token"},
+	}}
+
+	processor := NewProcessor(mock, tmpDir)
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	files := processor.ManagedFiles()
+	types := make(map[string]string)
+	for _, file := range files {
+		types[file.Path] = file.Type
+		if file.Type == managedFile && len(file.Hash) != 64 {
+			t.Errorf("Expected a SHA-256 hash for %s, got %q", file.Path, file.Hash)
+		}
+	}
+	for path, want := range map[string]string{
+		filepath.Join(tmpDir, "tls/key.pem"): managedFile,
+		filepath.Join(tmpDir, "tls/key.b64"): managedFile,
+		filepath.Join(tmpDir, "app/token"):   managedFile,
+		link:                                 managedSymlink,
+	} {
+		if types[path] != want {
+			t.Errorf("Expected %s to be managed as a %s, got %q", path, want, types[path])
+		}
+	}
+	if len(files) != 4 || files[0].Source != "/etc/opnix/tls.json" {
+		t.Errorf("Expected 4 managed entries carrying their config file, got %+v", files)
+	}
+
+	// A later run replaces entries it touched and keeps the rest
+	manifestPath := filepath.Join(tmpDir, ManagedManifestName)
+	manifest := ManagedManifest{Files: []ManagedFile{
+		{Path: "/var/lib/old/secret", Type: managedFile, Hash: "old", Secret: "secret[0]:old"},
+		{Path: filepath.Join(tmpDir, "app/./token"), Type: managedFile, Hash: "stale", Secret: "secret[1]:app/token"},
+	}}
+	manifest.Update(files)
+	if err := manifest.Write(manifestPath); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	info, err := os.Stat(manifestPath)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected the manifest to be readable by its owner only, got %v, %v", info, err)
+	}
+	data, _ := os.ReadFile(manifestPath)
+	if strings.Contains(string(data), "private-key") || strings.Contains(string(data), "stale") {
+		t.Errorf("Expected the manifest to hold fresh hashes and no values, got %s", data)
+	}
+
+	loaded, err := LoadManagedManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(loaded.Files) != 5 || loaded.Files[len(loaded.Files)-1].Path != "/var/lib/old/secret" {
+		t.Errorf("Expected the untouched entry to be kept alongside this run's, got %+v", loaded.Files)
+	}
+
+	if missing, err := LoadManagedManifest(filepath.Join(tmpDir, "missing.json")); err != nil || len(missing.Files) != 0 {
+		t.Errorf("Expected a missing manifest to be empty, got %+v, %v", missing, err)
+	}
+}
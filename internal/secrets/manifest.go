@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// FailureManifestName is the default manifest file name inside the output directory
+const FailureManifestName = ".opnix-failed.json"
+
+// FailureManifest lists the secrets a run did not write, so the next run can
+// retry only those. It holds paths and references, never values.
+type FailureManifest struct {
+	Failed []ManifestEntry `json:"failed"`
+}
+
+// ManifestEntry identifies one configured secret
+type ManifestEntry struct {
+	Path      string `json:"path,omitempty"`
+	Reference string `json:"reference,omitempty"`
+	Item      string `json:"item,omitempty"`
+}
+
+func manifestEntry(secret config.Secret) ManifestEntry {
+	return ManifestEntry{Path: secret.Path, Reference: secret.Reference, Item: secret.Item}
+}
+
+// NewFailureManifest lists every configured secret without a written or
+// skipped outcome, including those never attempted because processing
+// stopped early
+func NewFailureManifest(cfg *config.Config, outcomes []Outcome) FailureManifest {
+	written := make(map[string]bool, len(outcomes))
+	for _, outcome := range outcomes {
+		if outcome.Status == statusWritten || outcome.Status == statusSkipped {
+			written[outcome.Name] = true
+		}
+	}
+
+	manifest := FailureManifest{Failed: []ManifestEntry{}}
+	for i, secret := range cfg.Secrets {
+		if !written[fmt.Sprintf("secret[%d]:%s", i, secret.Path)] {
+			manifest.Failed = append(manifest.Failed, manifestEntry(secret))
+		}
+	}
+	return manifest
+}
+
+// Filter keeps only the configured secrets listed in the manifest
+func (m FailureManifest) Filter(cfg *config.Config) {
+	listed := make(map[ManifestEntry]bool, len(m.Failed))
+	for _, entry := range m.Failed {
+		listed[entry] = true
+	}
+
+	var selected []config.Secret
+	for _, secret := range cfg.Secrets {
+		if listed[manifestEntry(secret)] {
+			selected = append(selected, secret)
+		}
+	}
+	cfg.Secrets = selected
+}
+
+// Write saves the manifest readable by its owner only, or removes it when
+// nothing failed
+func (m FailureManifest) Write(path string) error {
+	if len(m.Failed) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.FileOperationError("Removing failure manifest", path, "Failed to remove stale failure manifest", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.FileOperationError("Writing failure manifest", path, "Failed to encode failure manifest", err)
+	}
+
+	// Write then rename so a crash never leaves a truncated manifest behind
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-failed-*")
+	if err != nil {
+		return errors.FileOperationError("Writing failure manifest", path, "Failed to create failure manifest", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return errors.FileOperationError("Writing failure manifest", path, "Failed to write failure manifest", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.FileOperationError("Writing failure manifest", path, "Failed to write failure manifest", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.FileOperationError("Writing failure manifest", path, "Failed to replace failure manifest", err)
+	}
+	return nil
+}
+
+// LoadFailureManifest reads the manifest written by a previous run. A missing
+// manifest means the previous run had no failures.
+func LoadFailureManifest(path string) (FailureManifest, error) {
+	var manifest FailureManifest
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return manifest, errors.FileOperationError("Reading failure manifest", path, "Failed to read failure manifest", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, errors.FileOperationError("Reading failure manifest", path, "Failure manifest is not valid JSON", err)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestFailureManifest(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/first": "first-value",
+			"op://vault/item/third": "third-value",
+		},
+	}
+
+	tmpDir := t.TempDir()
+	manifestPath := filepath.Join(tmpDir, FailureManifestName)
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{Path: "first", Reference: "op://vault/item/first"},
+			{Path: "second", Reference: "op://vault/item/missing"},
+			{Path: "third", Reference: "op://vault/item/third"},
+		},
+	}
+
+	processor := NewProcessor(mock, filepath.Join(tmpDir, "out"))
+	if err := processor.Process(cfg); err == nil {
+		t.Fatal("Expected the missing reference to fail")
+	}
+
+	// The failed secret and the one never reached are both recorded
+	if err := NewFailureManifest(cfg, processor.Outcomes()).Write(manifestPath); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+
+	info, err := os.Stat(manifestPath)
+	if err != nil {
+		t.Fatalf("Failed to stat manifest: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected manifest mode 0600, got %o", info.Mode().Perm())
+	}
+	data, _ := os.ReadFile(manifestPath)
+	if strings.Contains(string(data), "first-value") || strings.Contains(string(data), "third-value") {
+		t.Error("Manifest must never contain secret values")
+	}
+
+	manifest, err := LoadFailureManifest(manifestPath)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	manifest.Filter(cfg)
+	if len(cfg.Secrets) != 2 || cfg.Secrets[0].Path != "second" || cfg.Secrets[1].Path != "third" {
+		t.Fatalf("Expected only second and third to be retried, got %+v", cfg.Secrets)
+	}
+
+	// A clean run removes the manifest
+	mock.secrets["op://vault/item/missing"] = "second-value"
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process retried secrets: %v", err)
+	}
+	if err := NewFailureManifest(cfg, processor.Outcomes()).Write(manifestPath); err != nil {
+		t.Fatalf("Failed to write manifest: %v", err)
+	}
+	if _, err := os.Stat(manifestPath); !os.IsNotExist(err) {
+		t.Errorf("Expected manifest to be removed after a clean run, got %v", err)
+	}
+
+	// No manifest means nothing to retry
+	manifest, err = LoadFailureManifest(manifestPath)
+	if err != nil || len(manifest.Failed) != 0 {
+		t.Errorf("Expected empty manifest, got %+v, %v", manifest, err)
+	}
+}
@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// SuccessMarker records when a run last completed without failures, for
+// monitoring to alert on when it goes stale. It never holds secret values.
+type SuccessMarker struct {
+	Timestamp time.Time `json:"timestamp"`
+	Secrets   int       `json:"secrets"`
+	Written   int       `json:"written"`
+}
+
+// NewSuccessMarker summarizes a successful run's outcomes
+func NewSuccessMarker(outcomes []Outcome) SuccessMarker {
+	marker := SuccessMarker{Timestamp: time.Now().UTC(), Secrets: len(outcomes)}
+	for _, outcome := range outcomes {
+		if outcome.Status == statusWritten {
+			marker.Written++
+		}
+	}
+	return marker
+}
+
+// Write replaces the marker at path. It is world-readable so health checks
+// need no access to the secrets themselves.
+func (m SuccessMarker) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return errors.FileOperationError("Writing success marker", path, "Failed to encode success marker", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.FileOperationError("Writing success marker", path, "Failed to create success marker directory", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-success-*")
+	if err != nil {
+		return errors.FileOperationError("Writing success marker", path, "Failed to create success marker", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return errors.FileOperationError("Writing success marker", path, "Failed to write success marker", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return errors.FileOperationError("Writing success marker", path, "Failed to set success marker permissions", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.FileOperationError("Writing success marker", path, "Failed to write success marker", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.FileOperationError("Writing success marker", path, "Failed to replace success marker", err)
+	}
+	return nil
+}
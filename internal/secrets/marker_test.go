@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSuccessMarker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "last-success.json")
+	outcomes := []Outcome{
+		{Name: "secret[0]:a", Status: statusWritten},
+		{Name: "secret[1]:b", Status: statusWritten},
+	}
+
+	before := time.Now().UTC().Add(-time.Second)
+	if err := NewSuccessMarker(outcomes).Write(path); err != nil {
+		t.Fatalf("Failed to write marker: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat marker: %v", err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("Expected marker mode 0644, got %o", info.Mode().Perm())
+	}
+
+	data, _ := os.ReadFile(path)
+	var marker SuccessMarker
+	if err := json.Unmarshal(data, &marker); err != nil {
+		t.Fatalf("Marker is not valid JSON: %v", err)
+	}
+	if marker.Secrets != 2 || marker.Written != 2 {
+		t.Errorf("Expected 2 secrets written, got %+v", marker)
+	}
+	if marker.Timestamp.Before(before) {
+		t.Errorf("Expected a current timestamp, got %s", marker.Timestamp)
+	}
+	if strings.Contains(string(data), "secret[") {
+		t.Error("Marker must only contain a timestamp and counts")
+	}
+}
@@ -0,0 +1,142 @@
+package secrets
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+// lastSuccessMetric carries over from earlier runs until a run succeeds
+const lastSuccessMetric = "opnix_last_success_timestamp_seconds"
+
+// metricStatuses are the secret outcomes reported, in exposition order
+var metricStatuses = []string{statusWritten, statusFailed, statusRolledBack, statusSkipped}
+
+// RunMetrics are the gauges written for a node-exporter textfile collector
+// after each run. Like RunSummary, they never hold secret values.
+type RunMetrics struct {
+	Started  time.Time
+	Finished time.Time
+	Success  bool
+	Outcomes []Outcome
+	Warnings int
+	// Service actions waiting for a maintenance window; nil when unknown
+	PendingServiceActions *int
+}
+
+// NewRunMetrics describes a run that began at started and ended with runErr
+func NewRunMetrics(outcomes []Outcome, collector *warnings.Collector, runErr error, started time.Time) RunMetrics {
+	return RunMetrics{
+		Started:  started,
+		Finished: time.Now(),
+		Success:  runErr == nil,
+		Outcomes: outcomes,
+		Warnings: len(collector.List()),
+	}
+}
+
+// Format renders the metrics in the Prometheus text exposition format.
+// lastSuccess is the previous success timestamp, kept when this run failed.
+func (m RunMetrics) Format(lastSuccess string) []byte {
+	var buf bytes.Buffer
+	gauge := func(name, help string) {
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+
+	gauge("opnix_last_run_timestamp_seconds", "Unix time the last opnix run finished.")
+	fmt.Fprintf(&buf, "opnix_last_run_timestamp_seconds %d\n", m.Finished.Unix())
+
+	success := 0
+	if m.Success {
+		success = 1
+		lastSuccess = fmt.Sprintf("%d", m.Finished.Unix())
+	}
+	gauge("opnix_last_run_success", "Whether the last opnix run succeeded.")
+	fmt.Fprintf(&buf, "opnix_last_run_success %d\n", success)
+
+	if lastSuccess != "" {
+		gauge(lastSuccessMetric, "Unix time of the last successful opnix run.")
+		fmt.Fprintf(&buf, "%s %s\n", lastSuccessMetric, lastSuccess)
+	}
+
+	gauge("opnix_last_run_duration_seconds", "How long the last opnix run took.")
+	fmt.Fprintf(&buf, "opnix_last_run_duration_seconds %.3f\n", m.Finished.Sub(m.Started).Seconds())
+
+	counts := make(map[string]int)
+	for _, outcome := range m.Outcomes {
+		counts[outcome.Status]++
+	}
+	gauge("opnix_secrets", "Secrets by outcome in the last opnix run.")
+	for _, status := range metricStatuses {
+		fmt.Fprintf(&buf, "opnix_secrets{status=%q} %d\n", status, counts[status])
+	}
+
+	gauge("opnix_warnings", "Warnings raised by the last opnix run.")
+	fmt.Fprintf(&buf, "opnix_warnings %d\n", m.Warnings)
+
+	if m.PendingServiceActions != nil {
+		gauge("opnix_pending_service_actions", "Service restarts and reloads deferred to a maintenance window.")
+		fmt.Fprintf(&buf, "opnix_pending_service_actions %d\n", *m.PendingServiceActions)
+	}
+
+	return buf.Bytes()
+}
+
+// Write atomically replaces the metrics file at path. It is world-readable,
+// as the node exporter rarely runs as root.
+func (m RunMetrics) Write(path string) error {
+	data := m.Format(previousLastSuccess(path))
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.FileOperationError("Writing metrics", path, "Failed to create metrics directory", err)
+	}
+
+	// The collector ignores files not ending in .prom, so the temporary
+	// file is never scraped half-written
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-metrics-*")
+	if err != nil {
+		return errors.FileOperationError("Writing metrics", path, "Failed to create metrics file", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return errors.FileOperationError("Writing metrics", path, "Failed to write metrics file", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		_ = tmp.Close()
+		return errors.FileOperationError("Writing metrics", path, "Failed to set metrics file permissions", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.FileOperationError("Writing metrics", path, "Failed to write metrics file", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.FileOperationError("Writing metrics", path, "Failed to replace metrics file", err)
+	}
+	return nil
+}
+
+// previousLastSuccess reads the last success timestamp from an earlier
+// metrics file, or returns "" when there is none
+func previousLastSuccess(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if value, found := strings.CutPrefix(scanner.Text(), lastSuccessMetric+" "); found {
+			return strings.TrimSpace(value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunMetricsWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "textfile", "opnix.prom")
+	started := time.Unix(1700000000, 0)
+	outcomes := []Outcome{
+		{Name: "a", Path: "/run/a", Status: statusWritten},
+		{Name: "b", Path: "/run/b", Status: statusWritten},
+		{Name: "c", Path: "/run/c", Status: statusFailed},
+	}
+	pending := 2
+
+	ok := RunMetrics{Started: started, Finished: started.Add(1500 * time.Millisecond), Success: true, Outcomes: outcomes[:2], PendingServiceActions: &pending}
+	if err := ok.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	content, _ := os.ReadFile(path)
+	for _, want := range []string{
+		"# TYPE opnix_last_run_success gauge\nopnix_last_run_success 1\n",
+		"opnix_last_success_timestamp_seconds 1700000001\n",
+		"opnix_last_run_duration_seconds 1.500\n",
+		"opnix_secrets{status=\"written\"} 2\n",
+		"opnix_secrets{status=\"failed\"} 0\n",
+		"opnix_pending_service_actions 2\n",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Metrics missing %q:\n%s", want, content)
+		}
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Errorf("Metrics file has mode %o, want 0644", info.Mode().Perm())
+	}
+
+	// A failed run keeps the last success timestamp
+	failed := RunMetrics{Started: started.Add(time.Hour), Finished: started.Add(time.Hour), Outcomes: outcomes}
+	if err := failed.Write(path); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	content, _ = os.ReadFile(path)
+	for _, want := range []string{
+		"opnix_last_run_success 0\n",
+		"opnix_last_success_timestamp_seconds 1700000001\n",
+		fmt.Sprintf("opnix_last_run_timestamp_seconds %d\n", started.Add(time.Hour).Unix()),
+		"opnix_secrets{status=\"failed\"} 1\n",
+	} {
+		if !strings.Contains(string(content), want) {
+			t.Errorf("Metrics missing %q:\n%s", want, content)
+		}
+	}
+	if strings.Contains(string(content), "opnix_pending_service_actions") {
+		t.Errorf("Unknown pending actions must be left out:\n%s", content)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the metrics file, found %d entries", len(entries))
+	}
+}
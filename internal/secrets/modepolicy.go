@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/validation"
+)
+
+// modePolicyFor returns the most specific modePolicies entry containing
+// path, and its index, or -1 when none applies
+func (p *Processor) modePolicyFor(path string) (config.ModePolicy, int) {
+	found := -1
+	for i, policy := range p.modePolicies {
+		if !validation.IsWithinDir(path, policy.Dir) {
+			continue
+		}
+		if found < 0 || len(filepath.Clean(policy.Dir)) > len(filepath.Clean(p.modePolicies[found].Dir)) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return config.ModePolicy{}, -1
+	}
+	return p.modePolicies[found], found
+}
+
+// checkModePolicy fails when fileMode sets a permission bit the policy for
+// path's directory does not allow
+func (p *Processor) checkModePolicy(path string, fileMode os.FileMode, secretName string) error {
+	if len(p.modePolicies) == 0 {
+		return nil
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Validating path for %s", secretName),
+			path,
+			"Failed to resolve absolute path",
+			err,
+		)
+	}
+
+	policy, index := p.modePolicyFor(absPath)
+	if index < 0 {
+		return nil
+	}
+	maxMode, _ := strconv.ParseUint(policy.MaxMode, 8, 32)
+	if extra := uint64(fileMode.Perm()) &^ maxMode; extra != 0 {
+		return &errors.OpnixError{
+			Operation: fmt.Sprintf("Checking mode policy for %s", secretName),
+			Component: "file system",
+			Issue:     fmt.Sprintf("Mode %04o is not allowed under %s by modePolicies[%d] (maxMode %s)", fileMode.Perm(), policy.Dir, index, policy.MaxMode),
+			Context:   fmt.Sprintf("Target path: %s", absPath),
+			Suggestions: []string{
+				fmt.Sprintf("Use a mode within %s for this secret", policy.MaxMode),
+				fmt.Sprintf("Write the secret outside %s", policy.Dir),
+			},
+		}
+	}
+	return nil
+}
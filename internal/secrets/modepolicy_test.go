@@ -0,0 +1,46 @@
+package secrets
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestModePolicies(t *testing.T) {
+	tmpDir := t.TempDir()
+	mock := &mockClient{secrets: map[string]string{"op://vault/item/field": "value"}}
+	policies := []config.ModePolicy{
+		{Dir: tmpDir, MaxMode: "0644"},
+		{Dir: filepath.Join(tmpDir, "private"), MaxMode: "0600"},
+	}
+
+	tests := []struct {
+		name    string
+		secret  config.Secret
+		wantErr string
+	}{
+		{"within the outer policy", config.Secret{Path: "certs/cert.pem", Mode: "0644"}, ""},
+		{"within the inner policy", config.Secret{Path: "private/key", Mode: "0600"}, ""},
+		{"default mode", config.Secret{Path: "private/token"}, ""},
+		{"inner policy wins", config.Secret{Path: "private/key.pem", Mode: "0644"}, "modePolicies[1]"},
+		{"outer policy", config.Secret{Path: "certs/shared", Mode: "0664"}, "modePolicies[0]"},
+		{"copy outside the allowed mode", config.Secret{Path: "certs/key", Mode: "0640",
+			Copies: []config.SecretCopy{{Path: "private/key.b64", Encoding: "base64"}}}, "modePolicies[1]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := tt.secret
+			secret.Reference = "op://vault/item/field"
+			processor := NewProcessor(mock, tmpDir)
+			err := processor.Process(&config.Config{Secrets: []config.Secret{secret}, ModePolicies: policies})
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Expected the secret to be written, got %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Expected a violation of %s, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
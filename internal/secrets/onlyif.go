@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// defaultOnlyIfTimeout bounds an onlyIf command when no timeout is configured
+const defaultOnlyIfTimeout = 30 * time.Second
+
+// checkOnlyIf evaluates a secret's onlyIf guard before anything is resolved.
+// It returns why the secret is skipped, or "" when it should be written. A
+// guard that cannot be evaluated is an error, never a silent skip.
+func (p *Processor) checkOnlyIf(secret config.Secret, secretName string) (string, error) {
+	guard := secret.OnlyIf
+	if guard == nil {
+		return "", nil
+	}
+
+	if guard.Exists != "" {
+		if _, err := os.Stat(guard.Exists); os.IsNotExist(err) {
+			return fmt.Sprintf("%s does not exist (onlyIf.exists)", guard.Exists), nil
+		} else if err != nil {
+			return "", errors.FileOperationError(fmt.Sprintf("Checking onlyIf for %s", secretName), guard.Exists, "Failed to check the guard path", err)
+		}
+	}
+
+	if len(guard.Command) == 0 {
+		return "", nil
+	}
+
+	timeout := defaultOnlyIfTimeout
+	if guard.Timeout != "" {
+		parsed, err := time.ParseDuration(guard.Timeout)
+		if err != nil {
+			return "", errors.ValidationError(
+				fmt.Sprintf("Parsing onlyIf timeout for %s", secretName),
+				"onlyIf.timeout",
+				guard.Timeout,
+				"positive duration (e.g., 10s, 1m)",
+			)
+		}
+		timeout = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Run like validateWith: no value exists yet, but the command still gets
+	// no more than it needs
+	cmd := exec.CommandContext(ctx, guard.Command[0], guard.Command[1:]...)
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH")}
+	cmd.Dir = "/"
+	cmd.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := p.runAsOwner(cmd, secret, secretName); err != nil {
+		return "", err
+	}
+
+	err := cmd.Run()
+	if err == nil {
+		return "", nil
+	}
+
+	var exitErr *exec.ExitError
+	if ctx.Err() == nil && stderrors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return fmt.Sprintf("%s exited 1 (onlyIf.command)", guard.Command[0]), nil
+	}
+
+	issue := fmt.Sprintf("Guard command %s failed instead of answering yes (exit 0) or no (exit 1)", guard.Command[0])
+	if ctx.Err() == context.DeadlineExceeded {
+		issue = fmt.Sprintf("Guard command %s timed out after %s", guard.Command[0], timeout)
+	}
+	return "", &errors.OpnixError{
+		Operation: fmt.Sprintf("Checking onlyIf for %s", secretName),
+		Component: "secret processing",
+		Issue:     issue,
+		Context:   fmt.Sprintf("Output: %s", validateOutput(output.Bytes(), "")),
+		Suggestions: []string{
+			fmt.Sprintf("Run the command by hand: %s", strings.Join(guard.Command, " ")),
+			"Exit 1 to skip the secret; any other failure stops it so a broken guard is noticed",
+			"Increase onlyIf.timeout if the command needs longer",
+		},
+		Cause: err,
+	}
+}
@@ -0,0 +1,79 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorOnlyIf(t *testing.T) {
+	mock := &mockClient{secrets: map[string]string{"op://vault/item/field": "value"}}
+	scriptDir := t.TempDir()
+	flag := filepath.Join(scriptDir, "flag")
+	guard := writeScript(t, scriptDir, `exit "$1"`)
+
+	for _, tt := range []struct {
+		name    string
+		onlyIf  config.OnlyIf
+		written bool
+		reason  string
+		wantErr string
+	}{
+		{name: "missing flag file skips", onlyIf: config.OnlyIf{Exists: flag}, reason: "does not exist (onlyIf.exists)"},
+		{name: "command exiting 0 writes", onlyIf: config.OnlyIf{Command: []string{guard, "0"}}, written: true},
+		{name: "command exiting 1 skips", onlyIf: config.OnlyIf{Command: []string{guard, "1"}}, reason: "exited 1 (onlyIf.command)"},
+		{name: "command failing otherwise is an error", onlyIf: config.OnlyIf{Command: []string{guard, "2"}}, wantErr: "failed instead of answering"},
+		{name: "command that hangs is an error", onlyIf: config.OnlyIf{Command: []string{"/bin/sleep", "5"}, Timeout: "50ms"}, wantErr: "timed out after 50ms"},
+		{name: "both conditions must hold", onlyIf: config.OnlyIf{Exists: scriptDir, Command: []string{guard, "1"}}, reason: "exited 1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			onlyIf := tt.onlyIf
+			cfg := &config.Config{Secrets: []config.Secret{{Path: "key", Reference: "op://vault/item/field", OnlyIf: &onlyIf}}}
+			processor := NewProcessor(mock, tmpDir)
+			err := processor.Process(cfg)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Expected an error containing %q, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+
+			_, statErr := os.Stat(filepath.Join(tmpDir, "key"))
+			if written := statErr == nil; written != tt.written {
+				t.Errorf("Expected written=%v, got %v", tt.written, written)
+			}
+			outcome := processor.Outcomes()[0]
+			if tt.written != !outcome.Skipped() || !strings.Contains(outcome.Reason, tt.reason) {
+				t.Errorf("Unexpected outcome %+v", outcome)
+			}
+		})
+	}
+}
+
+func TestProcessorOnlyIfGroupByItem(t *testing.T) {
+	// The guarded field does not exist; resolving it ahead of time would fail the batch
+	client := &groupClient{mockClient: mockClient{secrets: map[string]string{"op://vault/item/user": "admin"}}}
+	onlyIf := config.OnlyIf{Exists: filepath.Join(t.TempDir(), "flag")}
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{Path: "user", Reference: "op://vault/item/user"},
+			{Path: "key", Reference: "op://vault/item/key", OnlyIf: &onlyIf},
+		},
+		Resolve: config.ResolveConfig{GroupByItem: true},
+	}
+
+	if err := NewProcessor(client, t.TempDir()).Process(cfg); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(client.batches) != 0 || strings.Join(client.singles, ",") != "op://vault/item/user" {
+		t.Errorf("Expected the guarded secret never to be resolved, got batches %v and singles %v", client.batches, client.singles)
+	}
+}
@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// PermissionFinding is a deployed secret file that is missing, readable by
+// every user, or whose mode or ownership differs from the configuration
+type PermissionFinding struct {
+	Name     string   `json:"name"`
+	Path     string   `json:"path"`
+	Source   string   `json:"source,omitempty"`
+	Problems []string `json:"problems"`
+	// Fixed is set when every mode and ownership problem was reconciled
+	Fixed bool `json:"fixed,omitempty"`
+}
+
+// AuditPermissions checks every file the configuration deploys, copies
+// included, against its configured mode and ownership. File contents are
+// never read. With fix, mode and ownership are reconciled in place; missing
+// files are only reported, since writing them needs 1Password.
+func (p *Processor) AuditPermissions(cfg *config.Config, fix bool) ([]PermissionFinding, error) {
+	p.applyConfig(cfg)
+
+	var findings []PermissionFinding
+	for i, configured := range cfg.Secrets {
+		// Pipes only exist while a reader is being served
+		if configured.FIFO {
+			continue
+		}
+
+		for _, secret := range configured.ExpandFields() {
+			secretName := fmt.Sprintf("secret[%d]:%s", i, secret.Path)
+			path, err := p.resolveSecretPathWithTemplate(secret, secretName)
+			if err != nil {
+				return nil, err
+			}
+			names, paths, owners := []string{secretName}, []string{path}, []config.Secret{secret}
+			for j, secretCopy := range secret.Copies {
+				copyName := fmt.Sprintf("%s.copies[%d]", secretName, j)
+				copyPath, err := p.copyPath(secret, secretCopy, copyName)
+				if err != nil {
+					return nil, err
+				}
+				names, paths = append(names, copyName), append(paths, copyPath)
+				owners = append(owners, copySecret(secret, secretCopy))
+			}
+
+			for j, name := range names {
+				finding, err := p.auditFile(owners[j], paths[j], name, fix)
+				if err != nil {
+					return nil, err
+				}
+				if finding != nil {
+					findings = append(findings, *finding)
+				}
+			}
+		}
+	}
+
+	return findings, nil
+}
+
+// auditFile checks one deployed file, returning nil when it is in order
+func (p *Processor) auditFile(secret config.Secret, path, secretName string, fix bool) (*PermissionFinding, error) {
+	finding := &PermissionFinding{Name: secretName, Path: path, Source: secret.Source}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		finding.Problems = []string{"missing (run opnix secret to deploy it)"}
+		return finding, nil
+	}
+	if err != nil {
+		finding.Problems = []string{fmt.Sprintf("cannot stat file: %v", err)}
+		return finding, nil
+	}
+	if !info.Mode().IsRegular() {
+		finding.Problems = []string{fmt.Sprintf("not a regular file (%s)", info.Mode().Type())}
+		return finding, nil
+	}
+
+	fileMode, err := p.parseFileMode(secret, path, secretName)
+	if err != nil {
+		return nil, err
+	}
+	expected := os.FileMode(fileMode).Perm()
+
+	problems, err := p.permissionProblems(info, expected, secret, secretName)
+	if err != nil {
+		return nil, err
+	}
+
+	if fix && len(problems) > 0 {
+		if err := os.Chmod(path, expected); err != nil {
+			return nil, errors.FileOperationError(
+				fmt.Sprintf("Setting permissions for %s", secretName),
+				path,
+				fmt.Sprintf("Failed to set file mode %04o", expected),
+				err,
+			)
+		}
+		if secret.Owner != "" || secret.Group != "" {
+			if err := p.setOwnership(path, secret.Owner, secret.Group, secretName); err != nil {
+				return nil, err
+			}
+		}
+
+		if info, err = os.Lstat(path); err != nil {
+			return nil, errors.FileOperationError(
+				fmt.Sprintf("Checking permissions for %s", secretName),
+				path,
+				"Failed to stat file after fixing it",
+				err,
+			)
+		}
+		remaining, err := p.permissionProblems(info, expected, secret, secretName)
+		if err != nil {
+			return nil, err
+		}
+		finding.Fixed = len(remaining) == 0
+		if !finding.Fixed {
+			problems = remaining
+		}
+	}
+	finding.Problems = problems
+
+	if info.Mode().Perm()&0004 != 0 {
+		problem := "readable by every user"
+		if expected&0004 != 0 {
+			problem += " (as configured)"
+		}
+		finding.Problems = append(finding.Problems, problem)
+	}
+
+	if len(finding.Problems) == 0 {
+		return nil, nil
+	}
+	return finding, nil
+}
+
+// permissionProblems lists how a file's mode and ownership differ from what
+// the secret configures
+func (p *Processor) permissionProblems(info os.FileInfo, expected os.FileMode, secret config.Secret, secretName string) ([]string, error) {
+	var problems []string
+	if info.Mode().Perm() != expected {
+		problems = append(problems, fmt.Sprintf("mode is %04o, expected %04o", info.Mode().Perm(), expected))
+	}
+
+	ownership, err := p.ownershipProblems(info, secret.Owner, secret.Group, secretName)
+	if err != nil {
+		return nil, err
+	}
+	return append(problems, ownership...), nil
+}
@@ -0,0 +1,88 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestAuditPermissions(t *testing.T) {
+	tmpDir := t.TempDir()
+	write := func(name string, mode os.FileMode) {
+		path := filepath.Join(tmpDir, name)
+		if err := os.WriteFile(path, []byte("value"), mode); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatalf("Failed to chmod %s: %v", name, err)
+		}
+	}
+	write("ok", 0600)
+	write("loose", 0644)
+	write("public", 0644)
+	write("key.pem", 0600)
+	write("key.b64", 0640)
+
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "ok", Reference: "op://vault/item/ok"},
+		{Path: "loose", Reference: "op://vault/item/loose", Mode: "0600", Source: "/etc/opnix/app.json"},
+		{Path: "public", Reference: "op://vault/item/public", Mode: "0644"},
+		{Path: "missing", Reference: "op://vault/item/missing"},
+		{Path: "key.pem", Reference: "op://vault/item/key", Copies: []config.SecretCopy{{Path: "key.b64", Encoding: "base64"}}},
+		{Path: "pipe", Reference: "op://vault/item/pipe", FIFO: true},
+	}}
+
+	processor := NewProcessor(nil, tmpDir)
+	findings, err := processor.AuditPermissions(cfg, false)
+	if err != nil {
+		t.Fatalf("Failed to audit permissions: %v", err)
+	}
+
+	problems := make(map[string]string)
+	for _, finding := range findings {
+		problems[filepath.Base(finding.Path)] = strings.Join(finding.Problems, "; ")
+		if finding.Fixed {
+			t.Errorf("Expected nothing to be fixed without fix, got %+v", finding)
+		}
+	}
+	for name, want := range map[string]string{
+		"loose":   "mode is 0644, expected 0600; readable by every user",
+		"public":  "readable by every user (as configured)",
+		"missing": "missing",
+		"key.b64": "mode is 0640, expected 0600",
+	} {
+		if !strings.HasPrefix(problems[name], want) {
+			t.Errorf("Expected %s to be reported as %q, got %q", name, want, problems[name])
+		}
+	}
+	if len(findings) != 4 {
+		t.Errorf("Expected 4 findings, got %+v", findings)
+	}
+	if findings[0].Source != "/etc/opnix/app.json" {
+		t.Errorf("Expected findings to name their config file, got %+v", findings[0])
+	}
+
+	// Fixing reconciles mode but cannot create missing files
+	findings, err = processor.AuditPermissions(cfg, true)
+	if err != nil {
+		t.Fatalf("Failed to fix permissions: %v", err)
+	}
+	for _, finding := range findings {
+		name := filepath.Base(finding.Path)
+		wantFixed := name == "loose" || name == "key.b64"
+		if finding.Fixed != wantFixed {
+			t.Errorf("Expected %s fixed=%v, got %+v", name, wantFixed, finding)
+		}
+	}
+	if info, _ := os.Stat(filepath.Join(tmpDir, "loose")); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected loose to be reset to 0600, got %04o", info.Mode().Perm())
+	}
+
+	findings, _ = processor.AuditPermissions(cfg, false)
+	if len(findings) != 2 {
+		t.Errorf("Expected only the missing and public files to remain, got %+v", findings)
+	}
+}
@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// defaultPlaceholders are values commonly left in a field before the real secret is set
+var defaultPlaceholders = []string{
+	"changeme", "change-me", "change_me", "replaceme", "replace-me",
+	"placeholder", "todo", "tbd", "fixme", "dummy", "example",
+	"password", "secret", "xxx", "xxxx", "none", "null",
+}
+
+// checkPlaceholder flags a resolved value that looks unset. The value itself
+// never appears in the warning or error.
+func (p *Processor) checkPlaceholder(value, secretName string) error {
+	check := p.placeholderCheck
+	if check.Mode == "" || check.Mode == "off" {
+		return nil
+	}
+
+	reason := placeholderReason(value, check)
+	if reason == "" {
+		return nil
+	}
+
+	if check.Mode != "strict" {
+		p.warnings.Addf("secret processing", "Value of %s looks like a placeholder: %s", secretName, reason)
+		return nil
+	}
+
+	return &errors.OpnixError{
+		Operation: fmt.Sprintf("Checking value of %s", secretName),
+		Component: "secret processing",
+		Issue:     fmt.Sprintf("Value looks like a placeholder: %s", reason),
+		Suggestions: []string{
+			"Set the real value in 1Password",
+			"Adjust placeholderCheck if this value is intentional",
+		},
+	}
+}
+
+// placeholderReason explains why a value looks unset, or returns ""
+func placeholderReason(value string, check config.PlaceholderCheck) string {
+	trimmed := strings.TrimSpace(value)
+
+	placeholders := check.Placeholders
+	if len(placeholders) == 0 {
+		placeholders = defaultPlaceholders
+	}
+	for _, placeholder := range placeholders {
+		if strings.EqualFold(trimmed, placeholder) {
+			return "matches a known placeholder"
+		}
+	}
+
+	if check.MinLength > 0 && len(trimmed) < check.MinLength {
+		return fmt.Sprintf("shorter than %d characters", check.MinLength)
+	}
+
+	if check.MinEntropyBits > 0 {
+		if bits := entropyBits(trimmed); bits < check.MinEntropyBits {
+			return fmt.Sprintf("only %.0f bits of entropy, expected at least %.0f", bits, check.MinEntropyBits)
+		}
+	}
+
+	return ""
+}
+
+// entropyBits estimates the total Shannon entropy of s from its character frequencies
+func entropyBits(s string) float64 {
+	if s == "" {
+		return 0
+	}
+
+	counts := make(map[rune]int)
+	total := 0
+	for _, r := range s {
+		counts[r]++
+		total++
+	}
+
+	perChar := 0.0
+	for _, count := range counts {
+		p := float64(count) / float64(total)
+		perChar -= p * math.Log2(p)
+	}
+	return perChar * float64(total)
+}
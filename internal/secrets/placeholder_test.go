@@ -0,0 +1,64 @@
+package secrets
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+func TestPlaceholderReason(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		check   config.PlaceholderCheck
+		flagged bool
+	}{
+		{name: "default placeholder", value: "CHANGEME\n", check: config.PlaceholderCheck{Mode: "warn"}, flagged: true},
+		{name: "real value", value: "x7Qp2mVz9LkR4tW8", check: config.PlaceholderCheck{Mode: "warn"}},
+		{name: "custom placeholder", value: "fill-me-in", check: config.PlaceholderCheck{Placeholders: []string{"FILL-ME-IN"}}, flagged: true},
+		{name: "custom list replaces defaults", value: "changeme", check: config.PlaceholderCheck{Placeholders: []string{"fill-me-in"}}},
+		{name: "too short", value: "abc", check: config.PlaceholderCheck{MinLength: 8}, flagged: true},
+		{name: "low entropy", value: "aaaaaaaaaaaaaaaa", check: config.PlaceholderCheck{MinEntropyBits: 32}, flagged: true},
+		{name: "enough entropy", value: "x7Qp2mVz9LkR4tW8", check: config.PlaceholderCheck{MinEntropyBits: 32}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := placeholderReason(tt.value, tt.check)
+			if (reason != "") != tt.flagged {
+				t.Errorf("placeholderReason() = %q, want flagged %v", reason, tt.flagged)
+			}
+		})
+	}
+}
+
+func TestProcessorPlaceholderCheck(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{"op://vault/item/field": "changeme"},
+	}
+	secretsCfg := []config.Secret{{Path: "token", Reference: "op://vault/item/field"}}
+
+	for _, mode := range []string{"", "warn"} {
+		cfg := &config.Config{Secrets: secretsCfg, PlaceholderCheck: config.PlaceholderCheck{Mode: mode}}
+		processor := NewProcessor(mock, t.TempDir())
+		collector := warnings.NewCollector()
+		processor.SetWarnings(collector)
+		if err := processor.Process(cfg); err != nil {
+			t.Errorf("Mode %q should not fail, got: %v", mode, err)
+		}
+		if collected := len(collector.List()); collected != map[string]int{"": 0, "warn": 1}[mode] {
+			t.Errorf("Mode %q collected %d warnings", mode, collected)
+		}
+	}
+
+	cfg := &config.Config{Secrets: secretsCfg, PlaceholderCheck: config.PlaceholderCheck{Mode: "strict"}}
+	err := NewProcessor(mock, t.TempDir()).Process(cfg)
+	if err == nil || !strings.Contains(err.Error(), "placeholder") {
+		t.Fatalf("Expected strict mode to fail, got: %v", err)
+	}
+	if strings.Contains(strings.ReplaceAll(err.Error(), "placeholderCheck", ""), "changeme") {
+		t.Errorf("Error must not contain the value: %v", err)
+	}
+}
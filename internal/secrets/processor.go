@@ -2,34 +2,136 @@ package secrets
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"log"
 	"os"
 	"os/user"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"syscall"
-	"text/template"
+	"time"
 
 	"github.com/brizzbuzz/opnix/internal/config"
 	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+	"github.com/brizzbuzz/opnix/internal/systemd"
+	"github.com/brizzbuzz/opnix/internal/validation"
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
 type SecretClient interface {
 	ResolveSecret(reference string) (string, error)
 }
 
+// BatchSecretClient is implemented by clients that can resolve several
+// references in a single request
+type BatchSecretClient interface {
+	ResolveSecrets(references []string) (map[string]string, error)
+}
+
+// ContextBatchSecretClient is implemented by batching clients that can abort
+// a batch early, which lets per-secret timeouts cancel the underlying request
+type ContextBatchSecretClient interface {
+	ResolveSecretsContext(ctx context.Context, references []string) (map[string]string, error)
+}
+
+// ContextSecretClient is implemented by clients that can abort a resolution
+// early, which lets per-secret timeouts cancel the underlying request
+type ContextSecretClient interface {
+	ResolveSecretContext(ctx context.Context, reference string) (string, error)
+}
+
+// FallbackSecretClient is implemented by clients that can try other fields of
+// the same item when the referenced field does not exist
+type FallbackSecretClient interface {
+	ResolveSecretWithFallback(ctx context.Context, reference string, fallbacks []string) (string, error)
+}
+
+// FieldTypeClient is implemented by clients that can read item metadata to
+// check the type of a referenced field
+type FieldTypeClient interface {
+	CheckFieldType(ctx context.Context, reference, expected string) error
+}
+
+// fallbackClient applies a secret's field fallbacks to every resolution attempt
+type fallbackClient struct {
+	client    FallbackSecretClient
+	fallbacks []string
+}
+
+func (f fallbackClient) ResolveSecret(reference string) (string, error) {
+	return f.client.ResolveSecretWithFallback(context.Background(), reference, f.fallbacks)
+}
+
+func (f fallbackClient) ResolveSecretContext(ctx context.Context, reference string) (string, error) {
+	return f.client.ResolveSecretWithFallback(ctx, reference, f.fallbacks)
+}
+
 type Processor struct {
 	client       SecretClient
 	outputDir    string
 	pathTemplate string
+	pathPrefix   string
+	baseDir      string
+	modePolicies []config.ModePolicy
 	defaults     map[string]string
+	resolve      config.ResolveConfig
+	retryDelay   time.Duration
+	// networkFilesystem is the warn/refuse/allow policy for network mounts
+	networkFilesystem string
+	// requireNonEmpty rejects empty values unless a secret overrides it
+	requireNonEmpty bool
+	// placeholderCheck flags resolved values that look unset
+	placeholderCheck config.PlaceholderCheck
+	// backupRetentionDefault is how many backups secrets keep unless they override it
+	backupRetentionDefault int
+	// written records what the last Process call left on disk, for Verify
+	written []writtenSecret
+	// accountClient returns the client for a named account, see SetAccountClients
+	accountClient func(account string) (SecretClient, error)
+	// outcomes records the result of every secret the last Process call attempted
+	outcomes []Outcome
+	// prefetched holds values resolved ahead of time by item, see prefetchByItem
+	prefetched map[string]string
+	// warnings receives problems that don't fail the run, see SetWarnings
+	warnings *warnings.Collector
+	// retryable decides which resolve failures are retried
+	retryable onepass.RetryClassifier
+	// cache serves values resolved by earlier runs, see SetResolveCache
+	cache *ResolveCache
+	// dirOwnership chowns the parent directories opnix creates, see SetDirOwnership
+	dirOwnership DirOwnership
+	// rateLimits paces requests per vault, see resolve.rateLimit
+	rateLimits *rateLimits
+	// items caches the items read during a run, see lookupContext
+	items *onepass.ItemCache
+	// credentials encrypts credentialEncrypted secrets with systemd-creds
+	credentials *systemd.CredentialEncrypter
+	// checkOnly compares with the files instead of writing, see SetCheckOnly
+	checkOnly bool
+	drift     []Drift
+}
+
+// Outcome is the result of processing one secret, for run summaries
+type Outcome struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Path        string `json:"path"`
+	Transaction string `json:"transaction,omitempty"`
+	Status      string `json:"status"`
+	// Reason says why a secret was skipped
+	Reason string `json:"reason,omitempty"`
+	// Source is the config file that defined the secret
+	Source string `json:"source,omitempty"`
 }
 
 func NewProcessor(client SecretClient, outputDir string) *Processor {
 	return &Processor{
-		client:    client,
-		outputDir: outputDir,
+		client:     client,
+		outputDir:  outputDir,
+		retryDelay: time.Second,
 	}
 }
 
@@ -39,147 +141,872 @@ func NewProcessorWithConfig(client SecretClient, outputDir, pathTemplate string,
 		outputDir:    outputDir,
 		pathTemplate: pathTemplate,
 		defaults:     defaults,
+		retryDelay:   time.Second,
 	}
 }
 
-func (p *Processor) Process(cfg *config.Config) error {
-	// Update processor with config-level settings
+// ResolvedSecret describes where a configured secret will be written,
+// without ever resolving its value
+type ResolvedSecret struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Path        string   `json:"path"`
+	Reference   string   `json:"reference"`
+	Owner       string   `json:"owner,omitempty"`
+	Group       string   `json:"group,omitempty"`
+	Mode        string   `json:"mode"`
+	Symlinks    []string `json:"symlinks,omitempty"`
+	// Copies lists each copy with its resolved path, mode and ownership
+	Copies []config.SecretCopy `json:"copies,omitempty"`
+}
+
+// applyConfig updates the processor with config-level settings
+func (p *Processor) applyConfig(cfg *config.Config) {
 	if cfg.PathTemplate != "" {
 		p.pathTemplate = cfg.PathTemplate
 	}
+	if cfg.PathPrefix != "" {
+		p.pathPrefix = cfg.PathPrefix
+	}
 	if len(cfg.Defaults) > 0 {
 		p.defaults = cfg.Defaults
 	}
+	p.resolve = cfg.Resolve
+	p.retryable = onepass.NewRetryClassifier(cfg.Resolve.RetryableErrors)
+	p.rateLimits = newRateLimits(cfg.Resolve)
+	p.baseDir = cfg.BaseDir
+	p.modePolicies = cfg.ModePolicies
+	p.networkFilesystem = cfg.NetworkFilesystem
+	p.requireNonEmpty = cfg.RequireNonEmpty == nil || *cfg.RequireNonEmpty
+	p.placeholderCheck = cfg.PlaceholderCheck
+	p.backupRetentionDefault = cfg.BackupRetention
+	p.credentials = systemd.NewCredentialEncrypter(cfg.SystemdIntegration)
+}
 
-	if err := os.MkdirAll(p.outputDir, 0755); err != nil {
-		return errors.FileOperationError(
-			"Creating output directory",
-			p.outputDir,
-			"Failed to create output directory",
-			err,
-		)
+// ResolvePaths computes the final output path of every configured secret
+// without contacting 1Password
+func (p *Processor) ResolvePaths(cfg *config.Config) ([]ResolvedSecret, error) {
+	p.applyConfig(cfg)
+
+	resolved := make([]ResolvedSecret, 0, len(cfg.Secrets))
+	for i, configured := range cfg.Secrets {
+		for _, secret := range configured.ExpandFields() {
+			entry, err := p.resolvePath(secret, fmt.Sprintf("secret[%d]:%s", i, secret.Path))
+			if err != nil {
+				return nil, err
+			}
+			resolved = append(resolved, entry)
+		}
 	}
 
-	for i, secret := range cfg.Secrets {
-		secretName := fmt.Sprintf("secret[%d]:%s", i, secret.Path)
-		if err := p.processSecret(secret, secretName); err != nil {
-			return errors.WrapWithSuggestions(
+	return resolved, nil
+}
+
+// resolvePath describes where a single-reference secret will be written
+func (p *Processor) resolvePath(secret config.Secret, secretName string) (ResolvedSecret, error) {
+	outputPath, err := p.resolveSecretPathWithTemplate(secret, secretName)
+	if err != nil {
+		return ResolvedSecret{}, err
+	}
+
+	mode := secret.Mode
+	if mode == "" {
+		mode = defaultMode(secret)
+	}
+
+	resolved := ResolvedSecret{
+		Name:        secretName,
+		Description: secret.Description,
+		Path:        outputPath,
+		Reference:   secret.Reference,
+		Owner:       secret.Owner,
+		Group:       secret.Group,
+		Mode:        mode,
+		Symlinks:    secret.Symlinks,
+	}
+	for i, secretCopy := range secret.Copies {
+		path, err := p.copyPath(secret, secretCopy, fmt.Sprintf("%s.copies[%d]", secretName, i))
+		if err != nil {
+			return ResolvedSecret{}, err
+		}
+		effective := copySecret(secret, secretCopy)
+		secretCopy.Path, secretCopy.Owner, secretCopy.Group = path, effective.Owner, effective.Group
+		if secretCopy.Mode == "" {
+			secretCopy.Mode = mode
+		}
+		resolved.Copies = append(resolved.Copies, secretCopy)
+	}
+	return resolved, nil
+}
+
+func (p *Processor) Process(cfg *config.Config) error {
+	// Update processor with config-level settings
+	p.applyConfig(cfg)
+	// Items looked up for type checks and attachments are kept for this run only
+	p.items = onepass.NewItemCache()
+	defer func() { p.items = nil }()
+	p.written = nil
+	p.outcomes = nil
+	p.drift = nil
+
+	// Checking leaves the filesystem alone
+	if !p.checkOnly {
+		if err := os.MkdirAll(p.outputDir, 0755); err != nil {
+			return errors.FileOperationError(
+				"Creating output directory",
+				p.outputDir,
+				"Failed to create output directory",
 				err,
-				fmt.Sprintf("Processing %s", secretName),
-				"secret processing",
-				[]string{
-					"Check the secret configuration for errors",
-					"Verify 1Password reference is correct",
-					"Ensure target directory permissions are correct",
-				},
 			)
 		}
 	}
 
+	p.prefetchByItem(cfg)
+
+	// Transaction members are processed together when the first one is reached
+	started := make(map[string]bool)
+	for i, secret := range cfg.Secrets {
+		// Checking writes nothing, so there is nothing to roll back
+		if secret.Transaction == "" || p.checkOnly {
+			if err := p.processOne(secret, i); err != nil {
+				return err
+			}
+			continue
+		}
+		if started[secret.Transaction] {
+			continue
+		}
+		started[secret.Transaction] = true
+		if err := p.processTransaction(cfg.Secrets, secret.Transaction); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (p *Processor) processSecret(secret config.Secret, secretName string) error {
-	// Resolve the secret value from 1Password
-	value, err := p.client.ResolveSecret(secret.Reference)
+// processOne processes the i-th configured secret and records its outcome
+func (p *Processor) processOne(secret config.Secret, i int) error {
+	secretName := fmt.Sprintf("secret[%d]:%s", i, secret.Path)
+	outputPath, pathErr := p.resolveSecretPathWithTemplate(secret, secretName)
+	if pathErr != nil {
+		outputPath = secret.Path
+	}
+	if secret.Item != "" {
+		outputPath = secret.Item
+	}
+	outcome := Outcome{
+		Name:        secretName,
+		Description: secret.Description,
+		Path:        outputPath,
+		Transaction: secret.Transaction,
+		Status:      statusWritten,
+		Source:      secret.Source,
+	}
+
+	skipReason, err := p.checkOnlyIf(secret, secretName)
+	if err == nil && skipReason == "" && secret.SkipIfExists && p.targetsExist(secret, secretName) {
+		skipReason = "file already exists (skipIfExists)"
+	}
+	if skipReason != "" {
+		outcome.Status = statusSkipped
+		outcome.Reason = skipReason
+		p.outcomes = append(p.outcomes, outcome)
+		return nil
+	}
+
+	if err == nil {
+		err = p.processSecret(secret, secretName)
+	}
 	if err != nil {
-		return errors.OnePasswordError(
-			fmt.Sprintf("Resolving secret %s", secretName),
-			fmt.Sprintf("Failed to resolve 1Password reference: %s", secret.Reference),
-			err,
-		)
+		outcome.Status = statusFailed
+		p.outcomes = append(p.outcomes, outcome)
+		// A rejected token fails every secret alike; the per-secret advice
+		// would only point away from it
+		if authErr, ok := errors.AsUnauthorized(err); ok {
+			return authErr
+		}
+		wrapped := &errors.OpnixError{
+			Operation: fmt.Sprintf("Processing %s", secretName),
+			Component: "secret processing",
+			Issue:     err.Error(),
+			Suggestions: []string{
+				"Check the secret configuration for errors",
+				"Verify 1Password reference is correct",
+				"Ensure target directory permissions are correct",
+			},
+			Cause: err,
+		}
+		if secret.Source != "" {
+			wrapped.Context = fmt.Sprintf("Defined in config file: %s", secret.Source)
+		}
+		// The config's author knows the real fix better than the generic advice
+		if secret.OnErrorHint != "" {
+			wrapped.Suggestions = append(wrapped.Suggestions, secret.OnErrorHint)
+		}
+		return wrapped
 	}
 
-	if secret.Template != "" {
-		tmpl, err := template.New("value").Parse(secret.Template)
+	p.outcomes = append(p.outcomes, outcome)
+	return nil
+}
+
+// targetsExist reports whether every file a secret writes is already present
+func (p *Processor) targetsExist(secret config.Secret, secretName string) bool {
+	for _, target := range secret.ExpandFields() {
+		if !p.targetExists(target, secretName) {
+			return false
+		}
+	}
+	return true
+}
+
+// targetExists reports whether a single-reference secret's file is present
+func (p *Processor) targetExists(secret config.Secret, secretName string) bool {
+	outputPath, err := p.resolveSecretPathWithTemplate(secret, secretName)
+	if err != nil {
+		return false
+	}
+	_, err = os.Lstat(outputPath)
+	return err == nil
+}
+
+// Skipped reports whether the secret was left untouched by skipIfExists
+func (o Outcome) Skipped() bool {
+	return o.Status == statusSkipped
+}
+
+// Outcomes returns the result of every secret attempted by the last Process call
+func (p *Processor) Outcomes() []Outcome {
+	return p.outcomes
+}
+
+func (p *Processor) processSecret(secret config.Secret, secretName string) error {
+	if secret.Item != "" {
+		return p.processItemFields(secret, secretName)
+	}
+
+	// Resolve the secret value from 1Password
+	var value string
+	var err error
+	if len(secret.EnvFile) > 0 {
+		value, err = p.resolveEnvFile(secret, secretName)
 		if err != nil {
-			return errors.TemplateError(
-				fmt.Sprintf("Parsing template for %s", secretName),
-				secret.Template,
+			return err
+		}
+	} else if len(secret.Bundle) > 0 {
+		value, err = p.resolveBundle(secret, secretName)
+		if err != nil {
+			return err
+		}
+	} else if len(secret.SSHKeys) > 0 {
+		value, err = p.resolveSSHKeys(secret, secretName)
+		if err != nil {
+			return err
+		}
+	} else if len(secret.INI) > 0 {
+		value, err = p.resolveINI(secret, secretName)
+		if err != nil {
+			return err
+		}
+	} else {
+		// Attachments are written from the client's buffer without string
+		// copies; text fields stay in memory
+		if writesAttachment(secret) && !p.checkOnly {
+			if written, err := p.writeAttachment(secret, secretName); written || err != nil {
+				return err
+			}
+		}
+
+		value, err = p.resolveWithRetry(secret, secretName)
+		if err != nil {
+			return errors.OnePasswordError(
+				fmt.Sprintf("Resolving secret %s", secretName),
+				fmt.Sprintf("Failed to resolve 1Password reference: %s", secret.Reference),
 				err,
 			)
 		}
-		buf := new(bytes.Buffer)
-		err = tmpl.Execute(buf, struct {
-					Secret  string
-				}{
-					Secret: value,
-				},
-			)
+		if err := p.checkFieldType(secret, secretName); err != nil {
+			return err
+		}
+		if secret.Extract != "" {
+			if value, err = extractValue(secret.Extract, value, secretName); err != nil {
+				return err
+			}
+		}
+		if len(secret.Transforms) > 0 {
+			if value, err = applyTransforms(secret.Transforms, value, secretName); err != nil {
+				return err
+			}
+		}
+		if err := p.checkPlaceholder(value, secretName); err != nil {
+			return err
+		}
+	}
+
+	return p.writeSecret(secret, secretName, value)
+}
+
+// writeSecret renders, places and protects an already resolved value
+func (p *Processor) writeSecret(secret config.Secret, secretName, value string) error {
+	var err error
+	if len(secret.Filter) > 0 {
+		if value, err = p.runFilter(secret, value, secretName); err != nil {
+			return err
+		}
+	}
+
+	if err := p.checkCertExpiry(secret, value, secretName); err != nil {
+		return err
+	}
+
+	var data []byte
+	if secret.Template != "" {
+		input := TemplateInput{
+			Secret:    value,
+			JSON:      secret.TemplateJSON,
+			Variables: p.templateVariables(secret.Variables),
+		}
+		if input.Path, err = p.resolveSecretPathWithTemplate(secret, secretName); err != nil {
+			return err
+		}
+		// An unknown hostname renders as empty rather than failing the secret
+		input.Hostname, _ = os.Hostname()
+		// The old value is only read into memory for templates that use it.
+		// Check mode cannot compare their content, so it never reads it.
+		if usesPrevious(secret) && !p.checkOnly {
+			if input.Previous, err = readPrevious(input.Path, secretName); err != nil {
+				return err
+			}
+		}
+		if data, err = RenderTemplate(secret.Template, secretName, input); err != nil {
+			return err
+		}
+	} else {
+		data = []byte(value)
+	}
+
+	// envFile and ini secrets are already rendered in canonical order
+	if secret.Canonical && len(secret.EnvFile) == 0 && len(secret.INI) == 0 {
+		canonical, err := canonicalJSON(data, secretName)
 		if err != nil {
-			return errors.TemplateError(
-				fmt.Sprintf("Executing template for %s", secretName),
-				secret.Template,
+			return err
+		}
+		data = canonical
+	}
+
+	if err := p.checkNonEmpty(secret, data, secretName); err != nil {
+		return err
+	}
+
+	if secret.CredentialEncrypted {
+		if data, err = p.credentials.Encrypt(secret.Credential, data); err != nil {
+			return err
+		}
+	}
+
+	outputPath, err := p.prepareOutputPath(secret, secretName)
+	if err != nil {
+		return err
+	}
+
+	fileMode, err := p.parseFileMode(secret, outputPath, secretName)
+	if err != nil {
+		return err
+	}
+	if err := p.checkModePolicy(outputPath, os.FileMode(fileMode), secretName); err != nil {
+		return err
+	}
+
+	// Named pipes receive the value once and never hit persistent storage
+	if secret.FIFO {
+		if p.checkOnly {
+			return nil
+		}
+		return p.deliverFIFO(secret, outputPath, data, os.FileMode(fileMode), secretName)
+	}
+
+	// A region secret owns only its marked block of the file
+	fileData := data
+	if secret.Region != nil {
+		if fileData, err = p.spliceRegionFile(outputPath, data, *secret.Region, secretName); err != nil {
+			return err
+		}
+		defer wipe(fileData)
+	}
+
+	if p.checkOnly {
+		if err := p.compareWithDisk(secret, outputPath, fileData, os.FileMode(fileMode), secretName); err != nil {
+			return err
+		}
+		return p.compareCopies(secret, data, os.FileMode(fileMode), secretName)
+	}
+
+	// Keep the previous file so a rejected value or, with backups enabled, a
+	// truncated write can be rolled back
+	var previous fileSnapshot
+	keepPrevious := p.backupRetention(secret) > 0
+	if len(secret.ValidateWith) > 0 || keepPrevious {
+		if previous, err = snapshotPath(outputPath); err != nil {
+			return errors.FileOperationError(
+				fmt.Sprintf("Preparing validation for %s", secretName),
+				outputPath,
+				"Failed to record the current file for rollback",
 				err,
 			)
 		}
-		value = buf.String()
 	}
 
+	if err := p.backupBeforeWrite(secret, outputPath, fileData, secretName); err != nil {
+		return err
+	}
+
+	// Write file with specified permissions; bundles, key files, INI files and
+	// shared files are replaced atomically so readers never see a partial document
+	write := writeFile
+	if len(secret.Bundle) > 0 || len(secret.SSHKeys) > 0 || len(secret.INI) > 0 || secret.Region != nil {
+		write = writeFileAtomic
+	}
+	if err := write(outputPath, fileData, os.FileMode(fileMode)); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", secretName),
+			outputPath,
+			"Failed to write secret to file",
+			err,
+		)
+	}
+
+	// A full disk can truncate a write without reporting an error, and
+	// another writer can leave more behind
+	if err := checkWrittenSize(outputPath, len(fileData)); err != nil {
+		issue := writtenSizeIssue("Secret file", err)
+		if keepPrevious {
+			if restoreErr := previous.restore(); restoreErr != nil {
+				p.warnings.Addf("secret processing", "Failed to restore %s after a bad write: %v", outputPath, restoreErr)
+			} else {
+				issue += "; the previous version was restored"
+			}
+		}
+		return errors.FileOperationError(
+			fmt.Sprintf("Writing secret file for %s", secretName),
+			outputPath,
+			issue,
+			err,
+		)
+	}
+
+	// WriteFile only applies the mode to new files, and is subject to umask
+	if err := os.Chmod(outputPath, os.FileMode(fileMode)); err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Setting permissions for %s", secretName),
+			outputPath,
+			fmt.Sprintf("Failed to set file mode %04o", fileMode),
+			err,
+		)
+	}
+
+	// Set ownership if specified
+	if secret.Owner != "" || secret.Group != "" {
+		if err := p.setOwnership(outputPath, secret.Owner, secret.Group, secretName); err != nil {
+			return err
+		}
+	}
+
+	if len(secret.ValidateWith) > 0 {
+		if err := p.runValidateWith(secret, outputPath, data, secretName); err != nil {
+			if restoreErr := previous.restore(); restoreErr != nil {
+				p.warnings.Addf("secret processing", "Failed to restore %s after validation failed: %v", outputPath, restoreErr)
+			}
+			return err
+		}
+	}
+
+	// Create symlinks if specified
+	if err := p.createSymlinks(outputPath, secret.Symlinks, secret.SymlinkDirMode, secretName); err != nil {
+		return err
+	}
+
+	p.recordWrite(secret, outputPath, fileData, os.FileMode(fileMode), secretName)
+
+	// Copies reuse the resolved value, so each representation costs no extra lookup
+	return p.writeCopies(secret, data, os.FileMode(fileMode), secretName)
+}
+
+// prepareOutputPath resolves and validates where a secret is written and
+// creates its parent directory
+func (p *Processor) prepareOutputPath(secret config.Secret, secretName string) (string, error) {
 	// Determine output path with enhanced path management
 	outputPath, err := p.resolveSecretPathWithTemplate(secret, secretName)
 	if err != nil {
-		return err
+		return "", err
 	}
+	// A missing directory shows up as a missing file
+	if p.checkOnly {
+		return outputPath, nil
+	}
+
+	// Validation creates the parent directory, so note what is missing first
+	parentDir := filepath.Dir(outputPath)
+	created := missingDirs(parentDir)
 
 	// Validate the resolved path for security
 	if err := p.validateSecretPath(outputPath, secretName); err != nil {
-		return err
+		return "", err
 	}
 
 	// Create parent directory if needed (validation already ensured it's writable)
-	parentDir := filepath.Dir(outputPath)
 	if err := os.MkdirAll(parentDir, 0755); err != nil {
-		return errors.FileOperationError(
+		return "", errors.FileOperationError(
 			fmt.Sprintf("Creating parent directory for %s", secretName),
 			parentDir,
 			"Failed to create parent directory",
 			err,
 		)
 	}
+	if err := p.chownCreatedDirs(created, secret, secretName); err != nil {
+		return "", err
+	}
+	if err := restrictCredentialStore(secret, parentDir, secretName); err != nil {
+		return "", err
+	}
+
+	if err := p.checkNetworkFilesystem(parentDir, secretName); err != nil {
+		return "", err
+	}
 
-	// Parse file permissions
+	return outputPath, nil
+}
+
+// defaultMode is the mode of a secret without one: 0600, except for
+// authorized_keys files, which hold public keys sshd may read as the user
+func defaultMode(secret config.Secret) string {
+	if len(secret.SSHKeys) > 0 && (secret.SSHKeysFormat == "" || secret.SSHKeysFormat == SSHKeysAuthorizedKeys) {
+		return "0644"
+	}
+	return "0600"
+}
+
+// parseFileMode returns the mode a secret is written with
+func (p *Processor) parseFileMode(secret config.Secret, outputPath, secretName string) (uint64, error) {
 	mode := secret.Mode
 	if mode == "" {
-		mode = "0600" // Default secure permissions
+		mode = defaultMode(secret)
+	}
+	if mode == config.ModePreserve {
+		// Keep a deliberately set mode; new files get the secure default
+		if info, err := os.Stat(outputPath); err == nil {
+			return uint64(info.Mode().Perm()), nil
+		}
+		return 0600, nil
 	}
+
 	fileMode, err := strconv.ParseUint(mode, 8, 32)
 	if err != nil {
-		return errors.ValidationError(
+		return 0, errors.ValidationError(
 			fmt.Sprintf("Parsing file mode for %s", secretName),
 			"mode",
 			mode,
-			"3-4 digit octal number (e.g., 0600, 0644)",
+			"3-4 digit octal number (e.g., 0600, 0644) or \"preserve\"",
 		)
 	}
+	return fileMode, nil
+}
 
-	// Write file with specified permissions
-	if err := os.WriteFile(outputPath, []byte(value), os.FileMode(fileMode)); err != nil {
-		return errors.FileOperationError(
-			fmt.Sprintf("Writing secret file for %s", secretName),
-			outputPath,
-			"Failed to write secret to file",
-			err,
+// SetWarnings collects warnings instead of printing them as they occur
+func (p *Processor) SetWarnings(collector *warnings.Collector) {
+	p.warnings = collector
+}
+
+// SetAccountClients sets how clients for named accounts are obtained.
+// Secrets without an account keep using the processor's default client.
+func (p *Processor) SetAccountClients(clientFor func(account string) (SecretClient, error)) {
+	p.accountClient = clientFor
+}
+
+// clientFor returns the client a secret resolves with
+func (p *Processor) clientFor(secret config.Secret, secretName string) (SecretClient, error) {
+	if secret.Account == "" {
+		return p.client, nil
+	}
+	if p.accountClient == nil {
+		return nil, errors.ConfigError(
+			fmt.Sprintf("Selecting account for %s", secretName),
+			fmt.Sprintf("Secret uses account '%s' but no account clients are configured", secret.Account),
+			nil,
 		)
 	}
+	return p.accountClient(secret.Account)
+}
 
-	// Set ownership if specified
-	if secret.Owner != "" || secret.Group != "" {
-		if err := p.setOwnership(outputPath, secret.Owner, secret.Group, secretName); err != nil {
+// resolveWithRetry resolves a secret's reference, honoring per-secret retry
+// and timeout overrides and falling back to the config-level resolve settings
+func (p *Processor) resolveWithRetry(secret config.Secret, secretName string) (string, error) {
+	maxRetries, timeout, err := p.resolveSettings(secret, secretName)
+	if err != nil {
+		return "", err
+	}
+
+	if value, ok := p.cached(secret); ok {
+		return value, nil
+	}
+
+	if value, ok := p.prefetched[secret.Reference]; ok && groupable(secret) {
+		p.remember(secret, value)
+		return value, nil
+	}
+
+	client, err := p.clientFor(secret, secretName)
+	if err != nil {
+		return "", err
+	}
+
+	if len(secret.FieldFallbacks) > 0 {
+		withFallback, ok := client.(FallbackSecretClient)
+		if !ok {
+			return "", errors.ConfigError(
+				fmt.Sprintf("Resolving secret %s", secretName),
+				"The 1Password client in use does not support fieldFallbacks",
+				nil,
+			)
+		}
+		client = fallbackClient{client: withFallback, fallbacks: secret.FieldFallbacks}
+	}
+
+	var value string
+	err = p.withRetry(secret, secretName, maxRetries, func() error {
+		var err error
+		value, err = p.resolveOnce(client, secret.Reference, timeout)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	p.remember(secret, value)
+	return value, nil
+}
+
+// withRetry calls attempt, within the rate limit, until it succeeds or has
+// been retried maxRetries times, waiting longer before each retry. Missing
+// items and bad tokens fail the same way every time, so errors the retry
+// classifier rejects end it at once.
+func (p *Processor) withRetry(secret config.Secret, secretName string, maxRetries int, attempt func() error) error {
+	var err error
+	for i := 0; i <= maxRetries; i++ {
+		if i > 0 {
+			log.Printf("Failed to resolve %s, retrying (attempt %d/%d): %v", secretName, i+1, maxRetries+1, err)
+			time.Sleep(time.Duration(i) * p.retryDelay)
+		}
+		p.rateLimits.wait(secret.Account, secret.Reference)
+		if err = attempt(); err == nil || !p.retryable.Retryable(err) {
 			return err
 		}
 	}
+	return err
+}
 
-	// Create symlinks if specified
-	if err := p.createSymlinks(outputPath, secret.Symlinks, secretName); err != nil {
+// resolveSettings returns a secret's retry count and per-attempt timeout,
+// falling back to the config-level resolve settings
+func (p *Processor) resolveSettings(secret config.Secret, secretName string) (int, time.Duration, error) {
+	maxRetries := p.resolve.MaxRetries
+	if secret.MaxRetries != nil {
+		maxRetries = *secret.MaxRetries
+	}
+
+	timeoutSpec := p.resolve.Timeout
+	if secret.Timeout != "" {
+		timeoutSpec = secret.Timeout
+	}
+	var timeout time.Duration
+	if timeoutSpec != "" {
+		parsed, err := time.ParseDuration(timeoutSpec)
+		if err != nil {
+			return 0, 0, errors.ValidationError(
+				fmt.Sprintf("Parsing resolve timeout for %s", secretName),
+				"timeout",
+				timeoutSpec,
+				"positive duration (e.g., 10s, 1m30s)",
+			)
+		}
+		timeout = parsed
+	}
+
+	return maxRetries, timeout, nil
+}
+
+// checkFieldType verifies the referenced field has the secret's declared type
+func (p *Processor) checkFieldType(secret config.Secret, secretName string) error {
+	if secret.Type == "" {
+		return nil
+	}
+
+	client, err := p.clientFor(secret, secretName)
+	if err != nil {
 		return err
 	}
+	checker, ok := client.(FieldTypeClient)
+	if !ok {
+		return errors.ConfigError(
+			fmt.Sprintf("Checking field type of %s", secretName),
+			"The 1Password client in use cannot read item metadata to check type",
+			nil,
+		)
+	}
 
-	return nil
+	maxRetries, timeout, err := p.resolveSettings(secret, secretName)
+	if err != nil {
+		return err
+	}
+	return p.withRetry(secret, secretName, maxRetries, func() error {
+		ctx, cancel := p.lookupContext(timeout)
+		defer cancel()
+		return checker.CheckFieldType(ctx, secret.Reference, secret.Type)
+	})
+}
+
+// lookupContext bounds one 1Password request by timeout, when there is one,
+// and lets it share the run's item lookups
+func (p *Processor) lookupContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx := onepass.WithItemCache(context.Background(), p.items)
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// usesPrevious reports whether a secret's template renders the file's old
+// content, so its output depends on what is on disk
+func usesPrevious(secret config.Secret) bool {
+	return strings.Contains(secret.Template, ".Previous")
+}
+
+// readPrevious returns what the file at path holds before it is
+// overwritten, or "" when there is no regular file there yet
+func readPrevious(path, secretName string) (string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && !info.Mode().IsRegular()) {
+		return "", nil
+	}
+	if err != nil {
+		return "", errors.FileOperationError(fmt.Sprintf("Reading previous value of %s", secretName), path, "Failed to inspect the current file", err)
+	}
+	previous, err := os.ReadFile(path)
+	if err != nil {
+		return "", errors.FileOperationError(fmt.Sprintf("Reading previous value of %s", secretName), path, "Failed to read the current file for {{ .Previous }}", err)
+	}
+	return string(previous), nil
+}
+
+// checkNonEmpty rejects an empty final value unless the secret allows it
+func (p *Processor) checkNonEmpty(secret config.Secret, data []byte, secretName string) error {
+	required := p.requireNonEmpty
+	if secret.RequireNonEmpty != nil {
+		required = *secret.RequireNonEmpty
+	}
+	if !required || len(bytes.TrimSpace(data)) > 0 {
+		return nil
+	}
+
+	return &errors.OpnixError{
+		Operation: fmt.Sprintf("Writing secret %s", secretName),
+		Component: "secret processing",
+		Issue:     "Secret value is empty after templating",
+		Context:   fmt.Sprintf("Reference: %s", secret.Reference),
+		Suggestions: []string{
+			"Check that the referenced 1Password field has a value",
+			"Check that the template does not elide the whole output",
+			"Set requireNonEmpty to false on this secret if an empty file is expected",
+		},
+	}
+}
+
+// resolveOnce performs a single resolution attempt bounded by timeout
+func (p *Processor) resolveOnce(client SecretClient, reference string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return client.ResolveSecret(reference)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if ctxClient, ok := client.(ContextSecretClient); ok {
+		return ctxClient.ResolveSecretContext(ctx, reference)
+	}
+
+	type result struct {
+		value string
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		value, err := client.ResolveSecret(reference)
+		done <- result{value: value, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.value, r.err
+	case <-ctx.Done():
+		return "", fmt.Errorf("timed out after %s resolving %s", timeout, reference)
+	}
+}
+
+// resolveBatchOnce performs a single batch resolution attempt bounded by
+// timeout, like resolveOnce
+func (p *Processor) resolveBatchOnce(batch BatchSecretClient, references []string, timeout time.Duration) (map[string]string, error) {
+	if timeout <= 0 {
+		return batch.ResolveSecrets(references)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if ctxBatch, ok := batch.(ContextBatchSecretClient); ok {
+		return ctxBatch.ResolveSecretsContext(ctx, references)
+	}
+
+	type result struct {
+		values map[string]string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		values, err := batch.ResolveSecrets(references)
+		done <- result{values: values, err: err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.values, r.err
+	case <-ctx.Done():
+		return nil, fmt.Errorf("timed out after %s resolving %d references", timeout, len(references))
+	}
 }
 
 // setOwnership sets the file ownership based on owner and group names
 func (p *Processor) setOwnership(path, owner, group, secretName string) error {
+	uid, gid, err := p.lookupOwnership(owner, group, secretName)
+	if err != nil {
+		return err
+	}
+
+	// Set ownership
+	if uid != -1 || gid != -1 {
+		if err := syscall.Chown(path, uid, gid); err != nil {
+			return errors.FileOperationError(
+				fmt.Sprintf("Setting ownership for %s", secretName),
+				path,
+				fmt.Sprintf("Failed to change ownership to %s:%s", owner, group),
+				err,
+			)
+		}
+	}
+
+	return nil
+}
+
+// lookupOwnership resolves owner and group names to a UID and GID, returning
+// -1 for whichever is unset
+func (p *Processor) lookupOwnership(owner, group, secretName string) (int, int, error) {
 	var uid, gid = -1, -1
 
 	// Resolve owner to UID
@@ -191,7 +1018,7 @@ func (p *Processor) setOwnership(path, owner, group, secretName string) error {
 			if err != nil {
 				// Get available users for suggestions
 				availableUsers := p.getAvailableUsers()
-				return errors.UserGroupError(
+				return -1, -1, errors.UserGroupError(
 					fmt.Sprintf("Setting ownership for %s", secretName),
 					owner,
 					"user",
@@ -200,7 +1027,7 @@ func (p *Processor) setOwnership(path, owner, group, secretName string) error {
 			}
 			parsedUID, err := strconv.Atoi(u.Uid)
 			if err != nil {
-				return errors.ConfigError(
+				return -1, -1, errors.ConfigError(
 					fmt.Sprintf("Parsing UID for user %s", owner),
 					fmt.Sprintf("Invalid UID format: %s", u.Uid),
 					err,
@@ -219,7 +1046,7 @@ func (p *Processor) setOwnership(path, owner, group, secretName string) error {
 			if err != nil {
 				// Get available groups for suggestions
 				availableGroups := p.getAvailableGroups()
-				return errors.UserGroupError(
+				return -1, -1, errors.UserGroupError(
 					fmt.Sprintf("Setting ownership for %s", secretName),
 					group,
 					"group",
@@ -228,7 +1055,7 @@ func (p *Processor) setOwnership(path, owner, group, secretName string) error {
 			}
 			parsedGID, err := strconv.Atoi(g.Gid)
 			if err != nil {
-				return errors.ConfigError(
+				return -1, -1, errors.ConfigError(
 					fmt.Sprintf("Parsing GID for group %s", group),
 					fmt.Sprintf("Invalid GID format: %s", g.Gid),
 					err,
@@ -238,19 +1065,7 @@ func (p *Processor) setOwnership(path, owner, group, secretName string) error {
 		}
 	}
 
-	// Set ownership
-	if uid != -1 || gid != -1 {
-		if err := syscall.Chown(path, uid, gid); err != nil {
-			return errors.FileOperationError(
-				fmt.Sprintf("Setting ownership for %s", secretName),
-				path,
-				fmt.Sprintf("Failed to change ownership to %s:%s", owner, group),
-				err,
-			)
-		}
-	}
-
-	return nil
+	return uid, gid, nil
 }
 
 // getAvailableUsers returns a list of common system users for error suggestions
@@ -292,23 +1107,25 @@ func (p *Processor) resolveSecretPath(secretPath, secretName string) string {
 		return secretPath
 	}
 
-	// For relative paths, combine with outputDir (backward compatibility)
-	return filepath.Join(p.outputDir, secretPath)
+	// For relative paths, combine with outputDir and the config-level prefix
+	return filepath.Join(p.outputDir, p.pathPrefix, secretPath)
 }
 
 // resolveSecretPathWithTemplate resolves the final path for a secret with template support
 func (p *Processor) resolveSecretPathWithTemplate(secret config.Secret, secretName string) (string, error) {
-	// If path is explicitly set, use it with variable substitution
-	if secret.Path != "" {
-		resolvedPath, err := p.substituteVariables(secret.Path, secret.Variables, secretName)
-		if err != nil {
-			return "", err
-		}
-		return p.resolveSecretPath(resolvedPath, secretName), nil
-	}
+	return p.tracePath(secret, secretName, nil)
+}
 
-	// If no path template is configured, return error
-	if p.pathTemplate == "" {
+// tracePath resolves a secret's final path, reporting each step to trace
+// when it is not nil
+func (p *Processor) tracePath(secret config.Secret, secretName string, trace pathTrace) (string, error) {
+	template := secret.Path
+	if template != "" {
+		trace.step(fmt.Sprintf("Using the secret's path %q", secret.Path), secret.Path)
+	} else if p.pathTemplate != "" {
+		template = p.pathTemplate
+		trace.step(fmt.Sprintf("The secret has no path; using pathTemplate %q", p.pathTemplate), p.pathTemplate)
+	} else {
 		return "", errors.ConfigError(
 			fmt.Sprintf("Resolving path for %s", secretName),
 			"No path specified and no pathTemplate configured",
@@ -316,13 +1133,18 @@ func (p *Processor) resolveSecretPathWithTemplate(secret config.Secret, secretNa
 		)
 	}
 
-	// Use template with variable substitution
-	resolvedPath, err := p.substituteVariables(p.pathTemplate, secret.Variables, secretName)
+	resolvedPath, err := p.traceVariables(template, secret.Variables, secretName, trace)
 	if err != nil {
 		return "", err
 	}
 
-	return p.resolveSecretPath(resolvedPath, secretName), nil
+	finalPath := p.resolveSecretPath(resolvedPath, secretName)
+	if filepath.IsAbs(resolvedPath) {
+		trace.step("The path is absolute, so outputDir and pathPrefix do not apply", finalPath)
+	} else {
+		trace.step(fmt.Sprintf("Joined the relative path with outputDir %q and pathPrefix %q, and cleaned it", p.outputDir, p.pathPrefix), finalPath)
+	}
+	return finalPath, nil
 }
 
 // validateSecretPath validates that the resolved path is secure and accessible
@@ -338,20 +1160,30 @@ func (p *Processor) validateSecretPath(resolvedPath, secretName string) error {
 	}
 
 	// Check for potentially dangerous system locations
-	dangerousPaths := []string{
-		"/bin", "/sbin", "/usr/bin", "/usr/sbin",
-		"/boot", "/dev", "/proc", "/sys",
-		"/etc/passwd", "/etc/shadow", "/etc/group",
+	if dangerous := validation.DangerousPathPrefix(resolvedPath); dangerous != "" {
+		return errors.FileOperationError(
+			fmt.Sprintf("Validating path for %s", secretName),
+			resolvedPath,
+			fmt.Sprintf("Path targets potentially dangerous system location: %s", dangerous),
+			nil,
+		)
 	}
 
-	for _, dangerous := range dangerousPaths {
-		if strings.HasPrefix(resolvedPath, dangerous) {
-			return errors.FileOperationError(
-				fmt.Sprintf("Validating path for %s", secretName),
-				resolvedPath,
-				fmt.Sprintf("Path targets potentially dangerous system location: %s", dangerous),
-				nil,
-			)
+	if err := p.checkBaseDir(resolvedPath, secretName); err != nil {
+		return err
+	}
+
+	// Re-check the real location in case a parent directory is a symlink
+	if absPath, err := filepath.Abs(resolvedPath); err == nil {
+		if realPath, err := validation.EvalParentSymlinks(absPath); err == nil {
+			if dangerous := validation.DangerousPathPrefix(realPath); dangerous != "" {
+				return errors.FileOperationError(
+					fmt.Sprintf("Validating path for %s", secretName),
+					resolvedPath,
+					fmt.Sprintf("Parent directory is a symlink into potentially dangerous system location: %s (real path: %s)", dangerous, realPath),
+					nil,
+				)
+			}
 		}
 	}
 
@@ -369,6 +1201,49 @@ func (p *Processor) validateSecretPath(resolvedPath, secretName string) error {
 	return nil
 }
 
+// checkBaseDir enforces the configured baseDir on a resolved path, both as
+// written and after following any symlinked parent directories
+func (p *Processor) checkBaseDir(resolvedPath, secretName string) error {
+	if p.baseDir == "" {
+		return nil
+	}
+
+	absPath, err := filepath.Abs(resolvedPath)
+	if err != nil {
+		return errors.FileOperationError(
+			fmt.Sprintf("Validating path for %s", secretName),
+			resolvedPath,
+			"Failed to resolve absolute path",
+			err,
+		)
+	}
+
+	outside := !validation.IsWithinDir(absPath, p.baseDir)
+	if !outside {
+		realPath, realErr := validation.EvalParentSymlinks(absPath)
+		realBase, baseErr := validation.EvalParentSymlinks(p.baseDir)
+		if realErr == nil && baseErr == nil && !validation.IsWithinDir(realPath, realBase) {
+			absPath, outside = realPath, true
+		}
+	}
+
+	if outside {
+		return &errors.OpnixError{
+			Operation: fmt.Sprintf("Validating path for %s", secretName),
+			Component: "file system",
+			Issue:     fmt.Sprintf("Path is outside the approved base directory %s", p.baseDir),
+			Context:   fmt.Sprintf("Target path: %s", absPath),
+			Suggestions: []string{
+				fmt.Sprintf("Move the path under %s", p.baseDir),
+				"Check the output directory and pathPrefix for relative paths",
+				"Check for symlinked directories pointing outside the base directory",
+			},
+		}
+	}
+
+	return nil
+}
+
 // ensureDirectoryWritable ensures a directory exists and is writable
 func (p *Processor) ensureDirectoryWritable(dir string) error {
 	// Try to create the directory if it doesn't exist
@@ -387,8 +1262,35 @@ func (p *Processor) ensureDirectoryWritable(dir string) error {
 	return nil
 }
 
+// dirFileMode converts an octal directory mode, carrying the setgid and sticky
+// bits over to their os.FileMode flags; os.FileMode(parsed) would drop them
+func dirFileMode(parsed uint64) os.FileMode {
+	mode := os.FileMode(parsed).Perm()
+	if parsed&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if parsed&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
 // createSymlinks creates symlinks for a secret file
-func (p *Processor) createSymlinks(targetPath string, symlinks []string, secretName string) error {
+func (p *Processor) createSymlinks(targetPath string, symlinks []string, dirMode, secretName string) error {
+	var parentMode os.FileMode
+	if dirMode != "" {
+		parsed, err := strconv.ParseUint(dirMode, 8, 32)
+		if err != nil {
+			return errors.ValidationError(
+				fmt.Sprintf("Parsing symlink directory mode for %s", secretName),
+				"symlinkDirMode",
+				dirMode,
+				"3-4 digit octal number (e.g., 0700, 0755)",
+			)
+		}
+		parentMode = dirFileMode(parsed)
+	}
+
 	for i, symlinkPath := range symlinks {
 		symlinkName := fmt.Sprintf("%s.symlinks[%d]", secretName, i)
 
@@ -408,6 +1310,18 @@ func (p *Processor) createSymlinks(targetPath string, symlinks []string, secretN
 			)
 		}
 
+		// MkdirAll skips existing directories and is subject to umask, so set the mode explicitly
+		if dirMode != "" {
+			if err := os.Chmod(parentDir, parentMode); err != nil {
+				return errors.FileOperationError(
+					fmt.Sprintf("Setting permissions on symlink directory for %s", symlinkName),
+					parentDir,
+					fmt.Sprintf("Failed to set directory mode %s", dirMode),
+					err,
+				)
+			}
+		}
+
 		// Remove existing symlink or file if it exists
 		if err := os.Remove(symlinkPath); err != nil && !os.IsNotExist(err) {
 			return errors.FileOperationError(
@@ -432,8 +1346,26 @@ func (p *Processor) createSymlinks(targetPath string, symlinks []string, secretN
 	return nil
 }
 
+// templateVariables merges the configured defaults with a secret's own variables
+func (p *Processor) templateVariables(variables map[string]string) map[string]string {
+	merged := make(map[string]string, len(p.defaults)+len(variables))
+	for k, v := range p.defaults {
+		merged[k] = v
+	}
+	for k, v := range variables {
+		merged[k] = v
+	}
+	return merged
+}
+
 // substituteVariables replaces template variables in a path
 func (p *Processor) substituteVariables(template string, variables map[string]string, secretName string) (string, error) {
+	return p.traceVariables(template, variables, secretName, nil)
+}
+
+// traceVariables substitutes template variables, reporting each one to trace
+// when it is not nil
+func (p *Processor) traceVariables(template string, variables map[string]string, secretName string, trace pathTrace) (string, error) {
 	result := template
 
 	// Create combined variable map (secret variables override defaults)
@@ -455,9 +1387,7 @@ func (p *Processor) substituteVariables(template string, variables map[string]st
 		end += start
 
 		placeholder := result[start : end+1] // {varname}
-		varName := result[start+1 : end]     // varname
-
-		value, exists := allVars[varName]
+		varName, value, exists := validation.LookupVariable(result[start+1:end], allVars)
 		if !exists {
 			return "", errors.ConfigError(
 				fmt.Sprintf("Processing template variable for %s", secretName),
@@ -472,6 +1402,7 @@ func (p *Processor) substituteVariables(template string, variables map[string]st
 		}
 
 		result = strings.ReplaceAll(result, placeholder, value)
+		trace.step(fmt.Sprintf("Substituted %s with %q from %s", placeholder, value, variableSource(placeholder, variables, p.defaults)), result)
 	}
 
 	return result, nil
@@ -489,3 +1420,65 @@ func (p *Processor) validateVariableValue(value, varName, secretName string) err
 
 	return nil
 }
+
+// writeFile writes a secret in place; overridden in tests
+var writeFile = os.WriteFile
+
+// writtenSizeError reports a file that does not hold the bytes just written
+type writtenSizeError struct {
+	wrote, holds int64
+}
+
+func (e *writtenSizeError) Error() string {
+	return fmt.Sprintf("wrote %d bytes but the file holds %d", e.wrote, e.holds)
+}
+
+// checkWrittenSize confirms the file at path holds exactly size bytes
+func checkWrittenSize(path string, size int) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() != int64(size) {
+		return &writtenSizeError{wrote: int64(size), holds: info.Size()}
+	}
+	return nil
+}
+
+// writtenSizeIssue describes a failed checkWrittenSize of what from the
+// comparison it made: a short file points at a full disk, a long one at
+// another writer
+func writtenSizeIssue(what string, err error) string {
+	mismatch, ok := err.(*writtenSizeError)
+	switch {
+	case !ok:
+		return fmt.Sprintf("Failed to check the size of the %s", strings.ToLower(what))
+	case mismatch.holds < mismatch.wrote:
+		return what + " is shorter than the value written; the disk may be full"
+	default:
+		return what + " is longer than the value written; something else may be writing to it"
+	}
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers see either the old or the new content
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-write-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
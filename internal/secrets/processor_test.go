@@ -1,13 +1,17 @@
 package secrets
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
 )
 
 // Mock client for testing
@@ -152,6 +156,55 @@ func TestProcessorWithOwnership(t *testing.T) {
 	}
 }
 
+func TestProcessorModeApplication(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{"op://vault/item/field": "test-value"},
+	}
+
+	tests := []struct {
+		name     string
+		mode     string
+		existing os.FileMode // 0 means the file does not exist yet
+		want     os.FileMode
+	}{
+		{name: "configured mode replaces existing", mode: "0600", existing: 0644, want: 0600},
+		{name: "preserve keeps existing", mode: "preserve", existing: 0640, want: 0640},
+		{name: "preserve defaults new files", mode: "preserve", want: 0600},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			target := filepath.Join(tmpDir, "secret")
+			if tt.existing != 0 {
+				if err := os.WriteFile(target, []byte("old"), tt.existing); err != nil {
+					t.Fatalf("Failed to create existing file: %v", err)
+				}
+				if err := os.Chmod(target, tt.existing); err != nil {
+					t.Fatalf("Failed to chmod existing file: %v", err)
+				}
+			}
+
+			cfg := &config.Config{Secrets: []config.Secret{{Path: "secret", Reference: "op://vault/item/field", Mode: tt.mode}}}
+			processor := NewProcessor(mock, tmpDir)
+			if err := processor.Process(cfg); err != nil {
+				t.Fatalf("Failed to process secrets: %v", err)
+			}
+
+			info, err := os.Stat(target)
+			if err != nil {
+				t.Fatalf("Failed to stat secret: %v", err)
+			}
+			if info.Mode().Perm() != tt.want {
+				t.Errorf("Expected mode %04o, got %04o", tt.want, info.Mode().Perm())
+			}
+			if err := processor.Verify(); err != nil {
+				t.Errorf("Expected Verify to accept the applied mode, got: %v", err)
+			}
+		})
+	}
+}
+
 func TestProcessorModeValidation(t *testing.T) {
 	// Create mock client
 	mock := &mockClient{
@@ -322,7 +375,6 @@ func containsAtIndex(s, substr string) bool {
 	return false
 }
 
-
 func TestProcessorWithTemplate(t *testing.T) {
 	// Create mock client
 	mock := &mockClient{
@@ -340,7 +392,7 @@ func TestProcessorWithTemplate(t *testing.T) {
 
 	// Create processor
 	processor := NewProcessor(mock, tmpDir)
-	
+
 	t.Run("Valid template", func(t *testing.T) {
 		// Create test config
 		cfg := &config.Config{
@@ -370,7 +422,6 @@ func TestProcessorWithTemplate(t *testing.T) {
 		}
 	})
 
-	
 	t.Run("Invalid template", func(t *testing.T) {
 		// Create test config
 		cfg := &config.Config{
@@ -382,9 +433,9 @@ func TestProcessorWithTemplate(t *testing.T) {
 				},
 			},
 		}
-		
+
 		err := processor.Process(cfg)
-		
+
 		if err == nil {
 			t.Error("Expected error with invalid template, got nil")
 		}
@@ -392,4 +443,927 @@ func TestProcessorWithTemplate(t *testing.T) {
 			t.Errorf("Expected 'could not be parsed' error, got: %v", err)
 		}
 	})
+	t.Run("Template execution error does not expose secret", func(t *testing.T) {
+		cfg := &config.Config{
+			Secrets: []config.Secret{
+				{
+					Path:      "test/secret",
+					Reference: "op://vault/item/field",
+					// printf echoes the value into the execution error message
+					Template: "{{ printf \"%d\" .Secret | call }}",
+				},
+			},
+		}
+
+		err := processor.Process(cfg)
+		if err == nil {
+			t.Fatal("Expected error with failing template, got nil")
+		}
+		if contains(err.Error(), "test-secret-value") {
+			t.Errorf("Template error exposes secret value: %v", err)
+		}
+		if !contains(err.Error(), "<redacted>") {
+			t.Errorf("Expected redacted marker in error, got: %v", err)
+		}
+	})
+}
+
+func TestProcessorResolvePaths(t *testing.T) {
+	// Resolving paths must never touch the client
+	processor := NewProcessor(nil, "/var/lib/opnix/secrets")
+
+	cfg := &config.Config{
+		PathTemplate: "/run/secrets/{service}/{name}",
+		Secrets: []config.Secret{
+			{
+				Reference: "op://vault/db/password",
+				Variables: map[string]string{"service": "postgres", "name": "password"},
+				Symlinks:  []string{"/etc/postgres/password"},
+			},
+			{
+				Path:        "api/key",
+				Reference:   "op://vault/api/key",
+				Mode:        "0640",
+				Description: "Billing API key, rotated quarterly",
+			},
+		},
+	}
+
+	resolved, err := processor.ResolvePaths(cfg)
+	if err != nil {
+		t.Fatalf("Failed to resolve paths: %v", err)
+	}
+
+	if len(resolved) != 2 {
+		t.Fatalf("Expected 2 resolved secrets, got %d", len(resolved))
+	}
+
+	if resolved[0].Path != "/run/secrets/postgres/password" {
+		t.Errorf("Expected templated path, got %s", resolved[0].Path)
+	}
+	if resolved[0].Mode != "0600" {
+		t.Errorf("Expected default mode 0600, got %s", resolved[0].Mode)
+	}
+	if len(resolved[0].Symlinks) != 1 {
+		t.Errorf("Expected symlinks to be carried over, got %v", resolved[0].Symlinks)
+	}
+
+	if resolved[1].Description != "Billing API key, rotated quarterly" {
+		t.Errorf("Expected description to be carried over, got %q", resolved[1].Description)
+	}
+
+	if resolved[1].Path != "/var/lib/opnix/secrets/api/key" {
+		t.Errorf("Expected relative path under output dir, got %s", resolved[1].Path)
+	}
+	if resolved[1].Mode != "0640" {
+		t.Errorf("Expected mode 0640, got %s", resolved[1].Mode)
+	}
+}
+
+func TestProcessorRejectsSymlinkedParent(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/field": "test-secret-value",
+		},
+	}
+
+	tmpDir := t.TempDir()
+	link := filepath.Join(tmpDir, "secrets")
+	if err := os.Symlink("/proc", link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	processor := NewProcessor(mock, tmpDir)
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{
+				Path:      filepath.Join(link, "token"),
+				Reference: "op://vault/item/field",
+			},
+		},
+	}
+
+	err := processor.Process(cfg)
+	if err == nil {
+		t.Fatal("Expected error for symlinked parent directory, got nil")
+	}
+	if !contains(err.Error(), "dangerous system location") {
+		t.Errorf("Expected dangerous location error, got: %v", err)
+	}
+}
+
+// flakyClient fails a fixed number of times before resolving
+type flakyClient struct {
+	failures int
+	calls    int
+}
+
+func (f *flakyClient) ResolveSecret(reference string) (string, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return "", fmt.Errorf("temporary failure")
+	}
+	return "flaky-value", nil
+}
+
+func TestProcessorPerSecretRetryOverride(t *testing.T) {
+	tmpDir := t.TempDir()
+	retries := 3
+
+	t.Run("per-secret override allows more retries", func(t *testing.T) {
+		client := &flakyClient{failures: 2}
+		processor := NewProcessor(client, tmpDir)
+		processor.retryDelay = 0
+
+		cfg := &config.Config{
+			Secrets: []config.Secret{
+				{
+					Path:       "flaky/secret",
+					Reference:  "op://vault/item/field",
+					MaxRetries: &retries,
+				},
+			},
+		}
+
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Expected retries to succeed, got: %v", err)
+		}
+		if client.calls != 3 {
+			t.Errorf("Expected 3 resolve calls, got %d", client.calls)
+		}
+	})
+
+	t.Run("global default applies without override", func(t *testing.T) {
+		client := &flakyClient{failures: 2}
+		processor := NewProcessor(client, tmpDir)
+		processor.retryDelay = 0
+
+		cfg := &config.Config{
+			Resolve: config.ResolveConfig{MaxRetries: 1},
+			Secrets: []config.Secret{
+				{
+					Path:      "flaky/secret",
+					Reference: "op://vault/item/field",
+				},
+			},
+		}
+
+		if err := processor.Process(cfg); err == nil {
+			t.Fatal("Expected failure with only one retry, got nil")
+		}
+		if client.calls != 2 {
+			t.Errorf("Expected 2 resolve calls, got %d", client.calls)
+		}
+	})
+}
+
+// failingClient always fails with the same error
+type failingClient struct {
+	err   error
+	calls int
+}
+
+func (f *failingClient) ResolveSecret(reference string) (string, error) {
+	f.calls++
+	return "", f.err
+}
+
+func TestProcessorRetriesOnlyRetryableErrors(t *testing.T) {
+	notFound := fmt.Errorf("error resolving secret reference: no item matched the secret reference query")
+
+	for _, tt := range []struct {
+		name      string
+		retryable []string
+		calls     int
+	}{
+		{name: "permanent errors fail fast", calls: 1},
+		{name: "configured patterns are retried", retryable: []string{"No Item Matched"}, calls: 3},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &failingClient{err: notFound}
+			processor := NewProcessor(client, t.TempDir())
+			processor.retryDelay = 0
+
+			cfg := &config.Config{
+				Resolve: config.ResolveConfig{MaxRetries: 2, RetryableErrors: tt.retryable},
+				Secrets: []config.Secret{{Path: "missing", Reference: "op://vault/item/field"}},
+			}
+			if err := processor.Process(cfg); err == nil {
+				t.Fatal("Expected the missing item to fail")
+			}
+			if client.calls != tt.calls {
+				t.Errorf("Expected %d resolve calls, got %d", tt.calls, client.calls)
+			}
+		})
+	}
+}
+
+// slowClient blocks longer than any reasonable test timeout
+type slowClient struct{}
+
+func (s *slowClient) ResolveSecret(reference string) (string, error) {
+	time.Sleep(time.Second)
+	return "slow-value", nil
+}
+
+func TestProcessorPerSecretTimeout(t *testing.T) {
+	processor := NewProcessor(&slowClient{}, t.TempDir())
+
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{
+				Path:      "slow/secret",
+				Reference: "op://vault/item/field",
+				Timeout:   "10ms",
+			},
+		},
+	}
+
+	err := processor.Process(cfg)
+	if err == nil {
+		t.Fatal("Expected timeout error, got nil")
+	}
+	if !contains(err.Error(), "timed out") {
+		t.Errorf("Expected timeout error, got: %v", err)
+	}
+}
+
+func TestProcessorPathPrefix(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/field": "prefixed-value",
+		},
+	}
+
+	tmpDir := t.TempDir()
+	absDir := t.TempDir()
+	processor := NewProcessor(mock, tmpDir)
+
+	cfg := &config.Config{
+		PathPrefix: "myapp/prod",
+		Secrets: []config.Secret{
+			{
+				Path:      "database/password",
+				Reference: "op://vault/item/field",
+			},
+			{
+				// Absolute paths ignore the prefix
+				Path:      filepath.Join(absDir, "token"),
+				Reference: "op://vault/item/field",
+			},
+		},
+	}
+
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(tmpDir, "myapp/prod/database/password")); err != nil {
+		t.Errorf("Expected prefixed secret file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(absDir, "token")); err != nil {
+		t.Errorf("Expected absolute secret file without prefix: %v", err)
+	}
+}
+
+func TestProcessorAccountClients(t *testing.T) {
+	defaultClient := &mockClient{
+		secrets: map[string]string{"op://vault/item/field": "default-value"},
+	}
+	teamClient := &mockClient{
+		secrets: map[string]string{"op://vault/item/field": "team-value"},
+	}
+
+	tmpDir := t.TempDir()
+	processor := NewProcessor(defaultClient, tmpDir)
+
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{Path: "default", Reference: "op://vault/item/field"},
+			{Path: "team", Reference: "op://vault/item/field", Account: "team"},
+		},
+	}
+
+	// Without account clients, a secret with an account cannot be resolved
+	if err := processor.Process(cfg); err == nil {
+		t.Fatal("Expected error when no account clients are configured")
+	}
+
+	processor.SetAccountClients(func(account string) (SecretClient, error) {
+		if account == "team" {
+			return teamClient, nil
+		}
+		return nil, fmt.Errorf("unknown account %s", account)
+	})
+
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	for path, expected := range map[string]string{"default": "default-value", "team": "team-value"} {
+		content, err := os.ReadFile(filepath.Join(tmpDir, path))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if string(content) != expected {
+			t.Errorf("Expected %s to contain %q, got %q", path, expected, string(content))
+		}
+	}
+}
+
+// batchClient counts batch calls so tests can assert fields were resolved together
+type batchClient struct {
+	mockClient
+	batches int
+	// failures is how many batches fail with a transient error first
+	failures int
+}
+
+func (b *batchClient) ResolveSecrets(references []string) (map[string]string, error) {
+	b.batches++
+	if b.batches <= b.failures {
+		return nil, fmt.Errorf("rate limit exceeded")
+	}
+	values := make(map[string]string, len(references))
+	for _, reference := range references {
+		value, err := b.ResolveSecret(reference)
+		if err != nil {
+			return nil, err
+		}
+		values[reference] = value
+	}
+	return values, nil
+}
+
+func TestProcessorItemFields(t *testing.T) {
+	secrets := map[string]string{
+		"op://vault/Database/username": "admin",
+		"op://vault/Database/password": "hunter2",
+	}
+	cfg := &config.Config{
+		Secrets: []config.Secret{{
+			Item:   "op://vault/Database",
+			Fields: map[string]string{"username": "db/user", "password": "db/password"},
+		}},
+	}
+
+	check := func(t *testing.T, dir string) {
+		for path, expected := range map[string]string{"db/user": "admin", "db/password": "hunter2"} {
+			content, err := os.ReadFile(filepath.Join(dir, path))
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", path, err)
+			}
+			if string(content) != expected {
+				t.Errorf("Expected %s to contain %q, got %q", path, expected, string(content))
+			}
+		}
+	}
+
+	t.Run("batch client", func(t *testing.T) {
+		client := &batchClient{mockClient: mockClient{secrets: secrets}}
+		tmpDir := t.TempDir()
+		if err := NewProcessor(client, tmpDir).Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+		if client.batches != 1 {
+			t.Errorf("Expected a single batch resolve, got %d", client.batches)
+		}
+		check(t, tmpDir)
+	})
+
+	t.Run("batch retried", func(t *testing.T) {
+		client := &batchClient{mockClient: mockClient{secrets: secrets}, failures: 1}
+		tmpDir := t.TempDir()
+		processor := NewProcessor(client, tmpDir)
+		processor.retryDelay = 0
+		retried := *cfg
+		retried.Resolve.MaxRetries = 1
+		if err := processor.Process(&retried); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+		if client.batches != 2 {
+			t.Errorf("Expected the failed batch to be retried once, got %d batches", client.batches)
+		}
+		check(t, tmpDir)
+	})
+
+	t.Run("per-field fallback", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		if err := NewProcessor(&mockClient{secrets: secrets}, tmpDir).Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+		check(t, tmpDir)
+	})
+}
+
+func TestProcessorSymlinkDirMode(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{"op://vault/item/field": "secret-value"},
+	}
+
+	tmpDir := t.TempDir()
+	linkDir := filepath.Join(tmpDir, "links", "app")
+	processor := NewProcessor(mock, filepath.Join(tmpDir, "out"))
+
+	cfg := &config.Config{
+		Secrets: []config.Secret{{
+			Path:           "token",
+			Reference:      "op://vault/item/field",
+			Symlinks:       []string{filepath.Join(linkDir, "token")},
+			SymlinkDirMode: "0700",
+		}},
+	}
+
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	info, err := os.Stat(linkDir)
+	if err != nil {
+		t.Fatalf("Failed to stat symlink directory: %v", err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("Expected symlink directory mode 0700, got %o", info.Mode().Perm())
+	}
+
+	// setgid and sticky are carried over for group-shared directories
+	cfg.Secrets[0].SymlinkDirMode = "3750"
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+	if info, err = os.Stat(linkDir); err != nil {
+		t.Fatalf("Failed to stat symlink directory: %v", err)
+	}
+	if info.Mode()&(os.ModeSetgid|os.ModeSticky) != os.ModeSetgid|os.ModeSticky || info.Mode().Perm() != 0750 {
+		t.Errorf("Expected symlink directory mode 3750, got %v", info.Mode())
+	}
+}
+
+// fallbackMock resolves the first existing field, like the 1Password client does
+type fallbackMock struct {
+	mockClient
+}
+
+func (f *fallbackMock) ResolveSecretWithFallback(ctx context.Context, reference string, fallbacks []string) (string, error) {
+	candidates := []string{reference}
+	base := reference[:strings.LastIndex(reference, "/")+1]
+	for _, field := range fallbacks {
+		candidates = append(candidates, base+field)
+	}
+	for _, candidate := range candidates {
+		if value, ok := f.secrets[candidate]; ok {
+			return value, nil
+		}
+	}
+	return "", fmt.Errorf("field not found")
+}
+
+func TestProcessorFieldFallbacks(t *testing.T) {
+	client := &fallbackMock{mockClient{
+		secrets: map[string]string{"op://vault/item/password": "fallback-value"},
+	}}
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Secrets: []config.Secret{{
+			Path:           "token",
+			Reference:      "op://vault/item/credential",
+			FieldFallbacks: []string{"password"},
+		}},
+	}
+
+	if err := NewProcessor(client, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "token"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if string(content) != "fallback-value" {
+		t.Errorf("Expected fallback value, got %q", string(content))
+	}
+
+	// Clients without fallback support must not silently ignore the setting
+	plain := &mockClient{secrets: client.secrets}
+	if err := NewProcessor(plain, t.TempDir()).Process(cfg); err == nil {
+		t.Error("Expected error for client without fallback support")
+	}
+}
+
+func TestProcessorExtract(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{"op://vault/item/url": "https://api.example.com/hook?token=s3cr3t&v=2"},
+	}
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Secrets: []config.Secret{{
+			Path:      "token",
+			Reference: "op://vault/item/url",
+			Extract:   `token=([^&]+)`,
+			Template:  "TOKEN={{ .Secret }}",
+		}},
+	}
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "token"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if string(content) != "TOKEN=s3cr3t" {
+		t.Errorf("Expected the extracted value to be templated, got %q", string(content))
+	}
+
+	cfg.Secrets[0].Extract = `secret=([^&]+)`
+	err = NewProcessor(mock, t.TempDir()).Process(cfg)
+	if err == nil || !strings.Contains(err.Error(), "does not match extract pattern") {
+		t.Fatalf("Expected a non-matching pattern to fail, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "s3cr3t") {
+		t.Errorf("Error must not contain the value: %v", err)
+	}
+}
+
+func TestProcessorTransforms(t *testing.T) {
+	// A base64 token inside a URL, with a stray trailing newline once decoded
+	mock := &mockClient{
+		secrets: map[string]string{"op://vault/item/url": "https://api.example.com/hook?token=czNjcjN0Cg==&v=2"},
+	}
+
+	tmpDir := t.TempDir()
+	cfg := &config.Config{
+		Secrets: []config.Secret{{
+			Path:       "token",
+			Reference:  "op://vault/item/url",
+			Transforms: []string{`extract:token=([^&]+)`, "base64decode", "trimNewline"},
+			Template:   "TOKEN={{ .Secret }}",
+		}},
+	}
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(tmpDir, "token"))
+	if err != nil {
+		t.Fatalf("Failed to read output: %v", err)
+	}
+	if string(content) != "TOKEN=s3cr3t" {
+		t.Errorf("Expected the transformed value to be templated, got %q", string(content))
+	}
+
+	// Decoding before extracting fails at the first step that cannot apply
+	cfg.Secrets[0].Transforms = []string{"base64decode", `extract:token=([^&]+)`}
+	err = NewProcessor(mock, t.TempDir()).Process(cfg)
+	if err == nil || !strings.Contains(err.Error(), "Transform 1 (base64decode) failed") {
+		t.Fatalf("Expected the error to name the failing step, got: %v", err)
+	}
+	if strings.Contains(err.Error(), "czNjcjN0") {
+		t.Errorf("Error must not contain the value: %v", err)
+	}
+}
+
+func TestProcessorRequireNonEmpty(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{"op://vault/item/empty": "  \n"},
+	}
+	allow := false
+
+	tests := []struct {
+		name    string
+		cfg     *config.Config
+		wantErr bool
+	}{
+		{
+			name:    "empty value rejected by default",
+			cfg:     &config.Config{Secrets: []config.Secret{{Path: "empty", Reference: "op://vault/item/empty"}}},
+			wantErr: true,
+		},
+		{
+			name: "template eliding everything rejected",
+			cfg: &config.Config{Secrets: []config.Secret{{
+				Path:      "empty",
+				Reference: "op://vault/item/empty",
+				Template:  "{{ if eq .Secret \"x\" }}{{ .Secret }}{{ end }}",
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "per-secret override",
+			cfg: &config.Config{Secrets: []config.Secret{{
+				Path:            "empty",
+				Reference:       "op://vault/item/empty",
+				RequireNonEmpty: &allow,
+			}}},
+		},
+		{
+			name: "config-level override",
+			cfg: &config.Config{
+				RequireNonEmpty: &allow,
+				Secrets:         []config.Secret{{Path: "empty", Reference: "op://vault/item/empty"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewProcessor(mock, t.TempDir()).Process(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Process() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProcessorBaseDir(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{"op://vault/item/field": "secret-value"},
+	}
+
+	baseDir := t.TempDir()
+	outside := t.TempDir()
+
+	t.Run("relative path under base", func(t *testing.T) {
+		processor := NewProcessor(mock, filepath.Join(baseDir, "out"))
+		cfg := &config.Config{
+			BaseDir: baseDir,
+			Secrets: []config.Secret{{Path: "token", Reference: "op://vault/item/field"}},
+		}
+		if err := processor.Process(cfg); err != nil {
+			t.Errorf("Expected path under baseDir to be accepted, got: %v", err)
+		}
+	})
+
+	t.Run("output dir outside base", func(t *testing.T) {
+		processor := NewProcessor(mock, outside)
+		cfg := &config.Config{
+			BaseDir: baseDir,
+			Secrets: []config.Secret{{Path: "token", Reference: "op://vault/item/field"}},
+		}
+		err := processor.Process(cfg)
+		if err == nil || !contains(err.Error(), "outside the approved base directory") {
+			t.Errorf("Expected baseDir error, got: %v", err)
+		}
+	})
+
+	t.Run("symlinked directory escaping base", func(t *testing.T) {
+		link := filepath.Join(baseDir, "escape")
+		if err := os.Symlink(outside, link); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+
+		processor := NewProcessor(mock, baseDir)
+		cfg := &config.Config{
+			BaseDir: baseDir,
+			Secrets: []config.Secret{{Path: "escape/token", Reference: "op://vault/item/field"}},
+		}
+		err := processor.Process(cfg)
+		if err == nil || !contains(err.Error(), "outside the approved base directory") {
+			t.Errorf("Expected baseDir error for symlinked parent, got: %v", err)
+		}
+		if _, statErr := os.Stat(filepath.Join(outside, "token")); statErr == nil {
+			t.Error("Expected no file to be written outside baseDir")
+		}
+	})
+}
+
+// typedClient reports a fixed field type for every reference
+type typedClient struct {
+	mockClient
+	fieldType string
+	// failures is how many checks fail with a transient error first
+	failures int
+	checks   int
+	// deadline records whether the last check was bounded by a timeout
+	deadline bool
+}
+
+func (c *typedClient) CheckFieldType(ctx context.Context, reference, expected string) error {
+	c.checks++
+	_, c.deadline = ctx.Deadline()
+	if c.checks <= c.failures {
+		return fmt.Errorf("connection reset by peer")
+	}
+	if expected != c.fieldType {
+		return fmt.Errorf("reference %s points at a %s field, expected %s", reference, c.fieldType, expected)
+	}
+	return nil
+}
+
+func TestProcessorFieldType(t *testing.T) {
+	client := &typedClient{
+		mockClient: mockClient{secrets: map[string]string{"op://vault/item/username": "admin"}},
+		fieldType:  "text",
+	}
+
+	t.Run("matching type", func(t *testing.T) {
+		cfg := &config.Config{Secrets: []config.Secret{{Path: "user", Reference: "op://vault/item/username", Type: "text"}}}
+		if err := NewProcessor(client, t.TempDir()).Process(cfg); err != nil {
+			t.Fatalf("Expected matching type to pass, got: %v", err)
+		}
+	})
+
+	t.Run("mismatched type is not written", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfg := &config.Config{Secrets: []config.Secret{{Path: "user", Reference: "op://vault/item/username", Type: "password"}}}
+		if err := NewProcessor(client, tmpDir).Process(cfg); err == nil {
+			t.Fatal("Expected type mismatch to fail")
+		}
+		if _, err := os.Stat(filepath.Join(tmpDir, "user")); !os.IsNotExist(err) {
+			t.Errorf("Expected nothing to be written, got %v", err)
+		}
+	})
+
+	t.Run("transient failures are retried within the timeout", func(t *testing.T) {
+		flaky := &typedClient{mockClient: client.mockClient, fieldType: "text", failures: 1}
+		processor := NewProcessor(flaky, t.TempDir())
+		processor.retryDelay = 0
+		cfg := &config.Config{
+			Secrets: []config.Secret{{Path: "user", Reference: "op://vault/item/username", Type: "text"}},
+			Resolve: config.ResolveConfig{MaxRetries: 1, Timeout: "5s"},
+		}
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Expected the check to succeed on retry, got: %v", err)
+		}
+		if flaky.checks != 2 {
+			t.Errorf("Expected the failed check to be retried once, got %d checks", flaky.checks)
+		}
+		if !flaky.deadline {
+			t.Error("Expected the check to be bounded by the resolve timeout")
+		}
+	})
+
+	t.Run("client without metadata support", func(t *testing.T) {
+		cfg := &config.Config{Secrets: []config.Secret{{Path: "user", Reference: "op://vault/item/username", Type: "text"}}}
+		if err := NewProcessor(&client.mockClient, t.TempDir()).Process(cfg); err == nil {
+			t.Fatal("Expected an error when the client cannot check types")
+		}
+	})
+}
+
+func TestProcessorSkipIfExists(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/admin/password":    "from-1password",
+			"op://vault/Database/username": "admin",
+			"op://vault/Database/password": "hunter2",
+		},
+	}
+
+	tmpDir := t.TempDir()
+	seeded := filepath.Join(tmpDir, "admin-password")
+	if err := os.WriteFile(seeded, []byte("rotated-in-place"), 0600); err != nil {
+		t.Fatalf("Failed to write seeded file: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "db"), 0755); err != nil {
+		t.Fatalf("Failed to create db dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "db/user"), []byte("seeded-user"), 0600); err != nil {
+		t.Fatalf("Failed to write seeded field: %v", err)
+	}
+
+	cfg := &config.Config{Secrets: []config.Secret{
+		// The reference is never resolved for an existing file
+		{Path: "admin-password", Reference: "op://vault/missing/password", SkipIfExists: true},
+		{Path: "new-password", Reference: "op://vault/admin/password", SkipIfExists: true},
+		{
+			Item:         "op://vault/Database",
+			Fields:       map[string]string{"username": "db/user", "password": "db/password"},
+			SkipIfExists: true,
+		},
+	}}
+
+	processor := NewProcessor(mock, tmpDir)
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	for path, expected := range map[string]string{
+		"admin-password": "rotated-in-place",
+		"new-password":   "from-1password",
+		"db/user":        "seeded-user",
+		"db/password":    "hunter2",
+	} {
+		content, err := os.ReadFile(filepath.Join(tmpDir, path))
+		if err != nil || string(content) != expected {
+			t.Errorf("Expected %s to contain %q, got %q, %v", path, expected, string(content), err)
+		}
+	}
+
+	outcomes := processor.Outcomes()
+	if !outcomes[0].Skipped() || outcomes[1].Skipped() || outcomes[2].Skipped() {
+		t.Errorf("Expected only the existing secret to be skipped, got %+v", outcomes)
+	}
+	if manifest := NewFailureManifest(cfg, outcomes); len(manifest.Failed) != 0 {
+		t.Errorf("Expected skipped secrets not to count as failures, got %+v", manifest.Failed)
+	}
+}
+
+func TestProcessorReportsSource(t *testing.T) {
+	mock := &mockClient{secrets: map[string]string{"op://vault/app/token": "token"}}
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "app/token", Reference: "op://vault/app/token", Source: "/etc/opnix/conf.d/app.json"},
+		{Path: "db/password", Reference: "op://vault/db/missing", Source: "/etc/opnix/conf.d/db.json"},
+	}}
+
+	processor := NewProcessor(mock, t.TempDir())
+	err := processor.Process(cfg)
+	if err == nil || !strings.Contains(err.Error(), "Defined in config file: /etc/opnix/conf.d/db.json") {
+		t.Errorf("Expected the failure to name the defining config file, got: %v", err)
+	}
+
+	outcomes := processor.Outcomes()
+	if len(outcomes) != 2 || outcomes[0].Source != "/etc/opnix/conf.d/app.json" || outcomes[1].Source != "/etc/opnix/conf.d/db.json" {
+		t.Errorf("Expected outcomes to carry their config file, got %+v", outcomes)
+	}
+}
+
+func TestProcessorErrorHint(t *testing.T) {
+	mock := &mockClient{secrets: map[string]string{}}
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "db/password", Reference: "op://vault/db/missing", OnErrorHint: "Ask the DBA on call to rotate the db credentials"},
+	}}
+
+	err := NewProcessor(mock, t.TempDir()).Process(cfg)
+	opnixErr, ok := err.(*errors.OpnixError)
+	if !ok {
+		t.Fatalf("Expected an OpnixError, got: %v", err)
+	}
+	if last := opnixErr.Suggestions[len(opnixErr.Suggestions)-1]; last != "Ask the DBA on call to rotate the db credentials" {
+		t.Errorf("Expected the hint as the last suggestion, got %q", opnixErr.Suggestions)
+	}
+}
+
+func TestProcessorInlineDefaults(t *testing.T) {
+	processor := NewProcessor(nil, "/var/lib/opnix/secrets")
+	processor.defaults = map[string]string{"environment": "prod"}
+
+	tests := []struct {
+		template  string
+		variables map[string]string
+		want      string
+		wantErr   bool
+	}{
+		{"{service:-web}/{name}", map[string]string{"name": "token"}, "web/token", false},
+		{"{service:-web}/{name}", map[string]string{"service": "api", "name": "token"}, "api/token", false},
+		{"{service:-web}/token", map[string]string{"service": ""}, "web/token", false},
+		{"{environment:-dev}/token", nil, "prod/token", false},
+		{"{suffix:-}token", nil, "token", false},
+		{"{service}/token", nil, "", true},
+		{"{service:-..}/token", nil, "", true},
+	}
+	for _, tt := range tests {
+		got, err := processor.substituteVariables(tt.template, tt.variables, "secret[0]")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("substituteVariables(%q) = %q, %v; want %q, error %v", tt.template, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestProcessorDetectsTruncatedWrite(t *testing.T) {
+	tempDir := t.TempDir()
+	path := filepath.Join(tempDir, "token")
+	mock := &mockClient{secrets: map[string]string{"op://vault/item/field": "old-value"}}
+	cfg := &config.Config{
+		BackupRetention: 1,
+		Secrets:         []config.Secret{{Path: "token", Reference: "op://vault/item/field"}},
+	}
+	processor := NewProcessor(mock, tempDir)
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Process() error = %v", err)
+	}
+
+	// Simulate a full disk that drops the tail of the write without an error
+	original := writeFile
+	writeFile = func(name string, data []byte, perm os.FileMode) error {
+		return original(name, data[:len(data)/2], perm)
+	}
+	defer func() { writeFile = original }()
+
+	mock.secrets["op://vault/item/field"] = "new-value"
+	err := processor.Process(cfg)
+	if err == nil {
+		t.Fatal("Expected a truncated write to fail")
+	}
+	if !strings.Contains(err.Error(), "shorter than the value written") {
+		t.Errorf("Expected the error to report the truncation, got: %v", err)
+	}
+	if content, _ := os.ReadFile(path); string(content) != "old-value" {
+		t.Errorf("Expected the previous version to be restored, got %q", content)
+	}
+
+	// Without backups the error still surfaces
+	none := 0
+	cfg.Secrets[0].BackupRetention = &none
+	if err := processor.Process(cfg); err == nil {
+		t.Error("Expected a truncated write to fail without backups")
+	}
+
+	// A file left longer than the write is not blamed on the disk
+	writeFile = func(name string, data []byte, perm os.FileMode) error {
+		return original(name, append(append([]byte{}, data...), '\n'), perm)
+	}
+	err = processor.Process(cfg)
+	if err == nil || !strings.Contains(err.Error(), "longer than the value written") || strings.Contains(err.Error(), "disk may be full") {
+		t.Errorf("Expected the error to report the extra content, got: %v", err)
+	}
 }
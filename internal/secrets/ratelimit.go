@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"sync"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+)
+
+// rateLimits paces requests to 1Password. Vaults with a limit of their own
+// get their own limiter, so a rate-sensitive vault never slows the others;
+// every other vault shares the global one. Safe for concurrent use.
+type rateLimits struct {
+	global   *rateLimiter
+	perVault map[string]float64
+
+	mu     sync.Mutex
+	vaults map[string]*rateLimiter
+}
+
+// newRateLimits returns the limits from the resolve settings, or nil when
+// nothing is limited
+func newRateLimits(resolve config.ResolveConfig) *rateLimits {
+	if resolve.RateLimit <= 0 && len(resolve.VaultRateLimits) == 0 {
+		return nil
+	}
+	limits := &rateLimits{
+		perVault: resolve.VaultRateLimits,
+		vaults:   make(map[string]*rateLimiter),
+	}
+	if resolve.RateLimit > 0 {
+		limits.global = newRateLimiter(resolve.RateLimit)
+	}
+	return limits
+}
+
+// wait blocks until a request for reference, resolved with account's
+// client, may be sent
+func (r *rateLimits) wait(account, reference string) {
+	if r == nil {
+		return
+	}
+	r.limiterFor(account, reference).wait()
+}
+
+// limiterFor finds the most specific limit for the reference's vault:
+// qualified by account, then by vault ID, then by name or ID alone
+func (r *rateLimits) limiterFor(account, reference string) *rateLimiter {
+	ref, err := onepass.ParseReference(reference)
+	if err != nil {
+		return r.global
+	}
+	if account == "" {
+		account = ref.Account
+	}
+
+	var candidates []string
+	if account != "" {
+		candidates = append(candidates, ref.Vault+"@"+account)
+	}
+	if ref.VaultID != "" {
+		candidates = append(candidates, ref.Vault+"@"+ref.VaultID, ref.VaultID)
+	}
+	candidates = append(candidates, ref.Vault)
+
+	for _, key := range candidates {
+		limit, ok := r.perVault[key]
+		if !ok {
+			continue
+		}
+		// Vaults of the same name in different accounts are limited separately
+		id := account + "/" + key
+		r.mu.Lock()
+		limiter, exists := r.vaults[id]
+		if !exists {
+			limiter = newRateLimiter(limit)
+			r.vaults[id] = limiter
+		}
+		r.mu.Unlock()
+		return limiter
+	}
+	return r.global
+}
+
+// rateLimiter spaces requests evenly at a fixed rate, without bursts
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait reserves the next free slot and sleeps until it; a nil limiter
+// never waits
+func (l *rateLimiter) wait() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"testing"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestRateLimits(t *testing.T) {
+	if newRateLimits(config.ResolveConfig{}) != nil {
+		t.Fatal("Expected no limits when none are configured")
+	}
+
+	limits := newRateLimits(config.ResolveConfig{
+		RateLimit: 1,
+		VaultRateLimits: map[string]float64{
+			"Shared":    100,
+			"Prod@work": 50,
+		},
+	})
+
+	global := limits.limiterFor("", "op://Other/item/field")
+	if global != limits.global {
+		t.Error("Expected a vault without its own limit to use the global limiter")
+	}
+	if limits.limiterFor("", "not a reference") != limits.global {
+		t.Error("Expected an unparsable reference to use the global limiter")
+	}
+
+	shared := limits.limiterFor("", "op://Shared/item/field")
+	if shared == global || shared.interval != 10*time.Millisecond {
+		t.Errorf("Expected Shared to get its own 100/s limiter, got %+v", shared)
+	}
+	if limits.limiterFor("", "op://Shared/other/field") != shared {
+		t.Error("Expected references to the same vault to share a limiter")
+	}
+	if limits.limiterFor("home", "op://Shared/item/field") == shared {
+		t.Error("Expected the same vault in another account to be limited separately")
+	}
+
+	// Account-qualified keys match the secret's account or the reference's qualifier
+	work := limits.limiterFor("work", "op://Prod/item/field")
+	if work == global || work.interval != 20*time.Millisecond {
+		t.Errorf("Expected Prod@work to get its own 50/s limiter, got %+v", work)
+	}
+	if limits.limiterFor("", "op://Prod@work/item/field") != work {
+		t.Error("Expected a reference qualified by account to match Prod@work")
+	}
+	if limits.limiterFor("home", "op://Prod/item/field") != global {
+		t.Error("Expected Prod in another account to use the global limiter")
+	}
+}
+
+func TestRateLimiterPaces(t *testing.T) {
+	limiter := newRateLimiter(50)
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.wait()
+	}
+	// The first request goes at once, the next four 20ms apart
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond {
+		t.Errorf("Expected 5 requests at 50/s to take at least 80ms, took %s", elapsed)
+	}
+
+	var unlimited *rateLimiter
+	start = time.Now()
+	unlimited.wait()
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("Expected a nil limiter not to wait, took %s", elapsed)
+	}
+}
+
+func TestProcessorRateLimitsVaults(t *testing.T) {
+	tempDir := t.TempDir()
+	client := &mockClient{secrets: map[string]string{
+		"op://Slow/a/field": "a",
+		"op://Slow/b/field": "b",
+		"op://Slow/c/field": "c",
+	}}
+	processor := NewProcessor(client, tempDir)
+
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{Path: "a", Reference: "op://Slow/a/field"},
+			{Path: "b", Reference: "op://Slow/b/field"},
+			{Path: "c", Reference: "op://Slow/c/field"},
+		},
+		Resolve: config.ResolveConfig{VaultRateLimits: map[string]float64{"Slow": 20}},
+	}
+
+	start := time.Now()
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected 3 resolves from a 20/s vault to take at least 100ms, took %s", elapsed)
+	}
+}
@@ -0,0 +1,125 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// defaultRegionComment prefixes the marker lines unless region.comment is set
+const defaultRegionComment = "#"
+
+// regionMarkers returns a region's BEGIN and END lines, without line endings
+func regionMarkers(region config.SecretRegion) (string, string) {
+	comment := region.Comment
+	if comment == "" {
+		comment = defaultRegionComment
+	}
+	suffix := ""
+	if region.Name != "" {
+		suffix = " " + region.Name
+	}
+	return comment + " BEGIN OPNIX" + suffix, comment + " END OPNIX" + suffix
+}
+
+// spliceRegionFile returns the file at path with its marked region replaced
+// by content. A file without the markers, or no file at all, gets the region
+// appended. The file's previous content is wiped here, and the caller wipes
+// the result, since both hold whatever else the file keeps.
+func (p *Processor) spliceRegionFile(path string, content []byte, region config.SecretRegion, secretName string) ([]byte, error) {
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.FileOperationError(
+			fmt.Sprintf("Reading %s to update its region", secretName),
+			path,
+			"Failed to read the file holding the region",
+			err,
+		)
+	}
+	defer wipe(existing)
+
+	begin, end := regionMarkers(region)
+	spliced, issue := spliceRegion(existing, content, begin, end)
+	if issue != "" {
+		return nil, &errors.OpnixError{
+			Operation: fmt.Sprintf("Updating region of %s", secretName),
+			Component: "file system",
+			Issue:     issue,
+			Context:   fmt.Sprintf("File: %s", path),
+			Suggestions: []string{
+				fmt.Sprintf("Each region needs exactly one %q line followed by one %q line", begin, end),
+				"Remove the stray marker lines by hand; nothing was written",
+			},
+		}
+	}
+	return spliced, nil
+}
+
+// spliceRegion replaces the lines between begin and end in existing with
+// content, or appends a new region when the markers are absent. It returns
+// an issue instead of guessing when the markers are unbalanced or content
+// holds a marker line of its own.
+func spliceRegion(existing, content []byte, begin, end string) ([]byte, string) {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if isMarker(line, begin) || isMarker(line, end) {
+			return nil, "Secret content contains the region's marker line"
+		}
+	}
+
+	lines := bytes.SplitAfter(existing, []byte("\n"))
+	beginAt, endAt := -1, -1
+	for i, line := range lines {
+		switch {
+		case isMarker(line, begin):
+			if beginAt != -1 {
+				return nil, fmt.Sprintf("%q appears more than once", begin)
+			}
+			beginAt = i
+		case isMarker(line, end):
+			if beginAt == -1 || endAt != -1 {
+				return nil, fmt.Sprintf("%q appears without a matching %q before it", end, begin)
+			}
+			endAt = i
+		}
+	}
+	if beginAt != -1 && endAt == -1 {
+		return nil, fmt.Sprintf("%q is never closed by %q", begin, end)
+	}
+
+	var out bytes.Buffer
+	writeRegion := func() {
+		out.WriteString(begin + "\n")
+		out.Write(content)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		out.WriteString(end + "\n")
+	}
+
+	if beginAt == -1 {
+		out.Write(existing)
+		if len(existing) > 0 && existing[len(existing)-1] != '\n' {
+			out.WriteByte('\n')
+		}
+		writeRegion()
+		return out.Bytes(), ""
+	}
+
+	for _, line := range lines[:beginAt] {
+		out.Write(line)
+	}
+	writeRegion()
+	for _, line := range lines[endAt+1:] {
+		out.Write(line)
+	}
+	return out.Bytes(), ""
+}
+
+// isMarker reports whether line is the marker, ignoring trailing whitespace
+// and line endings
+func isMarker(line []byte, marker string) bool {
+	return string(bytes.TrimRight(line, " \t\r\n")) == marker
+}
@@ -0,0 +1,123 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestSpliceRegion(t *testing.T) {
+	begin, end := regionMarkers(config.SecretRegion{})
+	tests := []struct {
+		name     string
+		existing string
+		content  string
+		want     string
+		issue    string
+	}{
+		{
+			name:    "new file",
+			content: "token=abc",
+			want:    "# BEGIN OPNIX\ntoken=abc\n# END OPNIX\n",
+		},
+		{
+			name:     "markers absent",
+			existing: "user=admin",
+			content:  "token=abc\n",
+			want:     "user=admin\n# BEGIN OPNIX\ntoken=abc\n# END OPNIX\n",
+		},
+		{
+			name:     "region replaced",
+			existing: "user=admin\n# BEGIN OPNIX\ntoken=old\nextra=old\n# END OPNIX  \r\nport=80\n",
+			content:  "token=new\n",
+			want:     "user=admin\n# BEGIN OPNIX\ntoken=new\n# END OPNIX\nport=80\n",
+		},
+		{
+			name:     "unclosed region",
+			existing: "# BEGIN OPNIX\ntoken=old\n",
+			issue:    "never closed",
+		},
+		{
+			name:     "end before begin",
+			existing: "# END OPNIX\n# BEGIN OPNIX\n",
+			issue:    "without a matching",
+		},
+		{
+			name:     "duplicate begin",
+			existing: "# BEGIN OPNIX\n# END OPNIX\n# BEGIN OPNIX\n# END OPNIX\n",
+			issue:    "more than once",
+		},
+		{
+			name:    "marker in content",
+			content: "a\n# END OPNIX\nb",
+			issue:   "contains the region's marker",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, issue := spliceRegion([]byte(tt.existing), []byte(tt.content), begin, end)
+			if tt.issue != "" {
+				if !strings.Contains(issue, tt.issue) {
+					t.Errorf("Expected issue containing %q, got %q", tt.issue, issue)
+				}
+				return
+			}
+			if issue != "" || string(got) != tt.want {
+				t.Errorf("spliceRegion() = %q, %q; want %q", got, issue, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessorRegion(t *testing.T) {
+	mock := &mockClient{secrets: map[string]string{
+		"op://vault/Db/password": "s3cret",
+		"op://vault/Api/token":   "t0ken",
+	}}
+
+	tmpDir := t.TempDir()
+	shared := filepath.Join(tmpDir, "app.conf")
+	if err := os.WriteFile(shared, []byte("// managed by another tool\nport = 80\n"), 0644); err != nil {
+		t.Fatalf("Failed to write shared file: %v", err)
+	}
+
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: shared, Reference: "op://vault/Db/password", Template: "db_password = {{ .Secret }}",
+			Region: &config.SecretRegion{Name: "db", Comment: "//"}},
+		{Path: shared, Reference: "op://vault/Api/token", Template: "api_token = {{ .Secret }}",
+			Region: &config.SecretRegion{Name: "api", Comment: "//"}},
+	}}
+	processor := NewProcessor(mock, tmpDir)
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process region secrets: %v", err)
+	}
+	if err := processor.Verify(); err != nil {
+		t.Errorf("Expected both regions to verify, got: %v", err)
+	}
+	if files := processor.ManagedFiles(); len(files) != 0 {
+		t.Errorf("Expected the shared file not to be managed, got %+v", files)
+	}
+
+	mock.secrets["op://vault/Db/password"] = "rotated"
+	if err := NewProcessor(mock, tmpDir).Process(cfg); err != nil {
+		t.Fatalf("Failed to update region secrets: %v", err)
+	}
+
+	content, _ := os.ReadFile(shared)
+	expected := `// managed by another tool
+port = 80
+// BEGIN OPNIX db
+db_password = rotated
+// END OPNIX db
+// BEGIN OPNIX api
+api_token = t0ken
+// END OPNIX api
+`
+	if string(content) != expected {
+		t.Errorf("Unexpected shared file:\n%s\nexpected:\n%s", content, expected)
+	}
+}
@@ -0,0 +1,125 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// SSH key file formats an sshKeys secret is assembled as
+const (
+	SSHKeysAuthorizedKeys = "authorized_keys"
+	SSHKeysKnownHosts     = "known_hosts"
+)
+
+// sshKeyTypes are the public key algorithms accepted in a key line
+var sshKeyTypes = map[string]bool{
+	"ssh-ed25519":                        true,
+	"ssh-rsa":                            true,
+	"ssh-dss":                            true,
+	"ecdsa-sha2-nistp256":                true,
+	"ecdsa-sha2-nistp384":                true,
+	"ecdsa-sha2-nistp521":                true,
+	"sk-ssh-ed25519@openssh.com":         true,
+	"sk-ecdsa-sha2-nistp256@openssh.com": true,
+}
+
+// resolveSSHKeys resolves every reference of an sshKeys secret and writes
+// their key lines one per line, in configuration order. Each line must be a
+// public key in the secret's format, so a private key is never published.
+func (p *Processor) resolveSSHKeys(secret config.Secret, secretName string) (string, error) {
+	format := secret.SSHKeysFormat
+	if format == "" {
+		format = SSHKeysAuthorizedKeys
+	}
+
+	var sb strings.Builder
+	for i, reference := range secret.SSHKeys {
+		partSecret := secret
+		partSecret.Reference = reference
+		partName := fmt.Sprintf("%s.sshKeys[%d]", secretName, i)
+
+		value, err := p.resolveWithRetry(partSecret, secretName)
+		if err != nil {
+			return "", errors.OnePasswordError(
+				fmt.Sprintf("Resolving %s", partName),
+				fmt.Sprintf("Failed to resolve 1Password reference: %s", reference),
+				err,
+			)
+		}
+
+		lines, err := sshKeyLines(value, format)
+		if err != nil {
+			return "", errors.ValidationError(
+				fmt.Sprintf("Validating %s (%s)", partName, reference),
+				"sshKeys",
+				err.Error(),
+				fmt.Sprintf("one or more %s lines with a public key, e.g. ssh-ed25519 AAAA... comment", format),
+			)
+		}
+		for _, line := range lines {
+			sb.WriteString(line)
+			sb.WriteByte('\n')
+		}
+	}
+
+	return sb.String(), nil
+}
+
+// sshKeyLines returns the non-blank lines of value, rejecting a value without
+// lines or with a line that is not a public key in format
+func sshKeyLines(value, format string) ([]string, error) {
+	var lines []string
+	for n, line := range strings.Split(value, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if !isSSHKeyLine(line, format) {
+			// The line itself is not repeated, in case it is a private key
+			return nil, fmt.Errorf("line %d is not a %s public key line", n+1, format)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("value is empty")
+	}
+	return lines, nil
+}
+
+// isSSHKeyLine reports whether line holds a key type followed by a matching
+// base64 key, after authorized_keys options or known_hosts host patterns
+func isSSHKeyLine(line, format string) bool {
+	fields := strings.Fields(line)
+	for i := 0; i+1 < len(fields); i++ {
+		if !sshKeyTypes[fields[i]] {
+			continue
+		}
+		if format == SSHKeysKnownHosts {
+			// [@marker] hostpatterns keytype key [comment]
+			if i != 1 && (i != 2 || !strings.HasPrefix(fields[0], "@")) {
+				return false
+			}
+		}
+		return sshKeyBlobMatches(fields[i+1], fields[i])
+	}
+	return false
+}
+
+// sshKeyBlobMatches reports whether encoded is a base64 public key blob whose
+// embedded algorithm name is keyType
+func sshKeyBlobMatches(encoded, keyType string) bool {
+	blob, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(blob) < 4 {
+		return false
+	}
+	length := binary.BigEndian.Uint32(blob)
+	if uint64(length) > uint64(len(blob)-4) {
+		return false
+	}
+	return string(blob[4:4+length]) == keyType
+}
@@ -0,0 +1,66 @@
+package secrets
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+// RunSummary describes one run for tooling: what happened to each secret and
+// every warning raised along the way. It never holds secret values.
+type RunSummary struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Outcomes  []Outcome          `json:"outcomes"`
+	Warnings  []warnings.Warning `json:"warnings"`
+	Error     string             `json:"error,omitempty"`
+}
+
+// NewRunSummary summarizes a run that ended with runErr, which may be nil
+func NewRunSummary(outcomes []Outcome, collector *warnings.Collector, runErr error) RunSummary {
+	summary := RunSummary{
+		Timestamp: time.Now().UTC(),
+		Outcomes:  append([]Outcome{}, outcomes...),
+		Warnings:  collector.List(),
+	}
+	if summary.Warnings == nil {
+		summary.Warnings = []warnings.Warning{}
+	}
+	if runErr != nil {
+		summary.Error = runErr.Error()
+	}
+	return summary
+}
+
+// Write replaces the summary at path, readable by its owner only
+func (s RunSummary) Write(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return errors.FileOperationError("Writing run summary", path, "Failed to encode run summary", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return errors.FileOperationError("Writing run summary", path, "Failed to create run summary directory", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".opnix-summary-*")
+	if err != nil {
+		return errors.FileOperationError("Writing run summary", path, "Failed to create run summary", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		_ = tmp.Close()
+		return errors.FileOperationError("Writing run summary", path, "Failed to write run summary", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.FileOperationError("Writing run summary", path, "Failed to write run summary", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.FileOperationError("Writing run summary", path, "Failed to replace run summary", err)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+func TestRunSummary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "summary.json")
+	collector := warnings.NewCollector()
+	collector.Addf("validation", "secret[0].mode 0644 makes the secret readable by every user")
+	outcomes := []Outcome{
+		{Name: "secret[0]:a", Path: "/run/secrets/a", Status: statusWritten},
+		{Name: "secret[1]:b", Path: "/run/secrets/b", Status: statusFailed},
+	}
+
+	if err := NewRunSummary(outcomes, collector, fmt.Errorf("resolve failed")).Write(path); err != nil {
+		t.Fatalf("Failed to write summary: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat summary: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected summary mode 0600, got %o", info.Mode().Perm())
+	}
+
+	data, _ := os.ReadFile(path)
+	var summary RunSummary
+	if err := json.Unmarshal(data, &summary); err != nil {
+		t.Fatalf("Summary is not valid JSON: %v", err)
+	}
+	if len(summary.Outcomes) != 2 || summary.Outcomes[1].Status != statusFailed {
+		t.Errorf("Expected both outcomes, got %+v", summary.Outcomes)
+	}
+	if len(summary.Warnings) != 1 || summary.Warnings[0].Component != "validation" {
+		t.Errorf("Expected the collected warning, got %+v", summary.Warnings)
+	}
+	if summary.Error != "resolve failed" {
+		t.Errorf("Expected the run error, got %q", summary.Error)
+	}
+
+	// A clean run still lists an empty warnings array
+	data, _ = json.Marshal(NewRunSummary(nil, nil, nil))
+	var clean map[string]interface{}
+	_ = json.Unmarshal(data, &clean)
+	if _, ok := clean["warnings"].([]interface{}); !ok {
+		t.Errorf("Expected an empty warnings array, got %s", data)
+	}
+	if _, ok := clean["error"]; ok {
+		t.Errorf("Expected no error field for a clean run, got %s", data)
+	}
+}
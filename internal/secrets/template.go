@@ -0,0 +1,258 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// templateData is the data available to secret templates
+type templateData struct {
+	Secret string
+	// The value parsed as JSON when templateJSON is set, e.g. {{ .SecretJSON.host }}
+	SecretJSON interface{}
+	Variables  map[string]string
+	// Non-secret context: where the rendered file is written and the host
+	// rendering it, for configs that refer to themselves
+	Path     string
+	Hostname string
+	// What the file held before this write, for templates that carry the
+	// old value through a rotation; empty when there is no file
+	Previous string
+}
+
+// TemplateInput is what a template is rendered with: the resolved value and
+// the non-secret context processSecret provides
+type TemplateInput struct {
+	Secret string
+	// Parse Secret as JSON for {{ .SecretJSON }}, as templateJSON does
+	JSON      bool
+	Variables map[string]string
+	Path      string
+	Hostname  string
+	Previous  string
+}
+
+// RenderTemplate renders text with the functions and data secret templates
+// get. Errors never include the value.
+func RenderTemplate(text, secretName string, input TemplateInput) ([]byte, error) {
+	tmpl, err := template.New("value").Funcs(templateFuncs()).Parse(text)
+	if err != nil {
+		return nil, errors.TemplateError(
+			fmt.Sprintf("Parsing template for %s", secretName),
+			text,
+			err,
+			input.Secret,
+			input.Previous,
+		)
+	}
+
+	data := templateData{
+		Secret:    input.Secret,
+		Variables: input.Variables,
+		Path:      input.Path,
+		Hostname:  input.Hostname,
+		Previous:  input.Previous,
+	}
+	if input.JSON {
+		if data.SecretJSON, err = parseSecretJSON(input.Secret); err != nil {
+			return nil, errors.TemplateError(
+				fmt.Sprintf("Parsing JSON value for %s", secretName),
+				text,
+				err,
+				input.Secret,
+			)
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		// Never surface the partially rendered buffer, it may contain the secret
+		return nil, errors.TemplateError(
+			fmt.Sprintf("Executing template for %s", secretName),
+			text,
+			err,
+			input.Secret,
+			input.Previous,
+		)
+	}
+	return buf.Bytes(), nil
+}
+
+// parseSecretJSON decodes a JSON value for templates. Decoder errors can quote
+// parts of the input, so only the byte offset is ever reported.
+func parseSecretJSON(value string) (interface{}, error) {
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(value), &parsed); err != nil {
+		var syntaxErr *json.SyntaxError
+		if stderrors.As(err, &syntaxErr) {
+			return nil, fmt.Errorf("value is not valid JSON (error at byte %d)", syntaxErr.Offset)
+		}
+		return nil, fmt.Errorf("value is not valid JSON")
+	}
+	return parsed, nil
+}
+
+// templateFuncs is the curated set of functions available to secret templates.
+// Argument order follows sprig so templates read the same, e.g. {{ .Secret | default "x" }}.
+// Conditionals use the built-in if/else/with/range actions.
+func templateFuncs() template.FuncMap {
+	return template.FuncMap{
+		// Defaults and conditionals
+		"default":  defaultValue,
+		"empty":    isEmpty,
+		"coalesce": coalesce,
+		"ternary":  ternary,
+
+		// Strings
+		"hasPrefix":  func(prefix, s string) bool { return strings.HasPrefix(s, prefix) },
+		"hasSuffix":  func(suffix, s string) bool { return strings.HasSuffix(s, suffix) },
+		"contains":   func(substr, s string) bool { return strings.Contains(s, substr) },
+		"trim":       strings.TrimSpace,
+		"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+		"trimSuffix": func(suffix, s string) string { return strings.TrimSuffix(s, suffix) },
+		"upper":      strings.ToUpper,
+		"lower":      strings.ToLower,
+		"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+		"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+		"join":       func(sep string, elems []string) string { return strings.Join(elems, sep) },
+		"quote":      func(s string) string { return strconv.Quote(s) },
+		"squote":     func(s string) string { return "'" + s + "'" },
+		"indent":     indent,
+		"nindent":    func(spaces int, s string) string { return "\n" + indent(spaces, s) },
+		"b64enc":     func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+		"b64dec":     b64dec,
+
+		// Integer arithmetic
+		"atoi": atoi,
+		"add":  func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x + y }) },
+		"sub":  func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x - y }) },
+		"mul":  func(a, b interface{}) (int64, error) { return intOp(a, b, func(x, y int64) int64 { return x * y }) },
+		"div":  divide,
+		"mod":  modulo,
+	}
+}
+
+// defaultValue returns given unless it is empty, in which case it returns def
+func defaultValue(def interface{}, given ...interface{}) interface{} {
+	if len(given) == 0 || isEmpty(given[0]) {
+		return def
+	}
+	return given[0]
+}
+
+// isEmpty reports whether a value is nil, zero or has no elements
+func isEmpty(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return rv.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return rv.IsZero()
+	}
+}
+
+// coalesce returns the first non-empty argument
+func coalesce(values ...interface{}) interface{} {
+	for _, v := range values {
+		if !isEmpty(v) {
+			return v
+		}
+	}
+	return nil
+}
+
+// ternary returns trueVal when cond is true and falseVal otherwise
+func ternary(trueVal, falseVal interface{}, cond bool) interface{} {
+	if cond {
+		return trueVal
+	}
+	return falseVal
+}
+
+// indent prefixes every line of s with the given number of spaces
+func indent(spaces int, s string) string {
+	pad := strings.Repeat(" ", spaces)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
+func b64dec(s string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("b64dec: %w", err)
+	}
+	return string(decoded), nil
+}
+
+func atoi(s string) (int64, error) {
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("atoi: %q is not an integer", s)
+	}
+	return n, nil
+}
+
+// toInt converts template arguments (ints or numeric strings) to int64
+func toInt(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	case int32:
+		return int64(n), nil
+	case uint:
+		return int64(n), nil
+	case string:
+		return atoi(n)
+	default:
+		return 0, fmt.Errorf("cannot use %T as an integer", v)
+	}
+}
+
+func intOp(a, b interface{}, op func(x, y int64) int64) (int64, error) {
+	x, err := toInt(a)
+	if err != nil {
+		return 0, err
+	}
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	return op(x, y), nil
+}
+
+func divide(a, b interface{}) (int64, error) {
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("div: division by zero")
+	}
+	return intOp(a, y, func(x, y int64) int64 { return x / y })
+}
+
+func modulo(a, b interface{}) (int64, error) {
+	y, err := toInt(b)
+	if err != nil {
+		return 0, err
+	}
+	if y == 0 {
+		return 0, fmt.Errorf("mod: division by zero")
+	}
+	return intOp(a, y, func(x, y int64) int64 { return x % y })
+}
@@ -0,0 +1,179 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Outcome statuses
+const (
+	statusWritten    = "written"
+	statusFailed     = "failed"
+	statusRolledBack = "rolled back"
+	statusSkipped    = "skipped"
+)
+
+// fileSnapshot is the state of a path before a transaction touched it
+type fileSnapshot struct {
+	path    string
+	existed bool
+	link    string // symlink target, when the path was a symlink
+	content []byte
+	mode    os.FileMode
+	uid     int
+	gid     int
+}
+
+// processTransaction writes every secret in the named group, restoring the
+// previous state of all of their paths if any member fails
+func (p *Processor) processTransaction(secrets []config.Secret, name string) error {
+	var members []int
+	for i, secret := range secrets {
+		if secret.Transaction == name {
+			members = append(members, i)
+		}
+	}
+
+	var snapshots []fileSnapshot
+	for _, i := range members {
+		for _, path := range p.transactionPaths(secrets[i], fmt.Sprintf("secret[%d]:%s", i, secrets[i].Path)) {
+			snapshot, err := snapshotPath(path)
+			if err != nil {
+				return errors.FileOperationError(
+					fmt.Sprintf("Starting transaction %s", name),
+					path,
+					"Failed to record the current state for rollback",
+					err,
+				)
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+
+	firstOutcome, firstWritten := len(p.outcomes), len(p.written)
+	for _, i := range members {
+		err := p.processOne(secrets[i], i)
+		if err == nil {
+			continue
+		}
+
+		for j := firstOutcome; j < len(p.outcomes); j++ {
+			if p.outcomes[j].Status == statusWritten {
+				p.outcomes[j].Status = statusRolledBack
+			}
+		}
+		p.written = p.written[:firstWritten]
+
+		if restoreErr := restoreSnapshots(snapshots); restoreErr != nil {
+			return &errors.OpnixError{
+				Operation: fmt.Sprintf("Rolling back transaction %s", name),
+				Component: "secret processing",
+				Issue:     "Failed to restore the previous state of every member",
+				Context:   fmt.Sprintf("Rollback was triggered by: %v", err),
+				Suggestions: []string{
+					"Inspect the member paths and restore them manually",
+					"Re-run opnix once the original failure is fixed",
+				},
+				Cause: restoreErr,
+			}
+		}
+
+		p.warnings.Addf("secret processing", "Transaction %s rolled back, restored %d paths", name, len(snapshots))
+		return err
+	}
+
+	return nil
+}
+
+// transactionPaths lists every path a secret may write, including symlinks
+// and copies
+func (p *Processor) transactionPaths(secret config.Secret, secretName string) []string {
+	var paths []string
+	for _, expanded := range secret.ExpandFields() {
+		if path, err := p.resolveSecretPathWithTemplate(expanded, secretName); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	for _, secretCopy := range secret.Copies {
+		if path, err := p.copyPath(secret, secretCopy, secretName); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return append(paths, secret.Symlinks...)
+}
+
+// snapshotPath records a path's current content, mode and ownership
+func snapshotPath(path string) (fileSnapshot, error) {
+	snapshot := fileSnapshot{path: path, uid: -1, gid: -1}
+
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return snapshot, nil
+	}
+	if err != nil {
+		return snapshot, err
+	}
+
+	snapshot.existed = true
+	snapshot.mode = info.Mode().Perm()
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		snapshot.uid, snapshot.gid = int(stat.Uid), int(stat.Gid)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		snapshot.link, err = os.Readlink(path)
+		return snapshot, err
+	}
+	if !info.Mode().IsRegular() {
+		return snapshot, fmt.Errorf("%s is not a regular file or symlink", path)
+	}
+
+	snapshot.content, err = os.ReadFile(path)
+	return snapshot, err
+}
+
+// restoreSnapshots puts every path back the way it was, newest first
+func restoreSnapshots(snapshots []fileSnapshot) error {
+	var failed []error
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		if err := snapshots[i].restore(); err != nil {
+			failed = append(failed, fmt.Errorf("%s: %w", snapshots[i].path, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d path(s) could not be restored: %v", len(failed), failed)
+	}
+	return nil
+}
+
+func (s fileSnapshot) restore() error {
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if !s.existed {
+		return nil
+	}
+
+	if s.link != "" {
+		if err := os.Symlink(s.link, s.path); err != nil {
+			return err
+		}
+	} else {
+		if err := os.WriteFile(s.path, s.content, s.mode); err != nil {
+			return err
+		}
+		// WriteFile is subject to umask
+		if err := os.Chmod(s.path, s.mode); err != nil {
+			return err
+		}
+	}
+
+	if s.uid != -1 && (s.uid != os.Geteuid() || s.gid != os.Getegid()) {
+		return os.Lchown(s.path, s.uid, s.gid)
+	}
+	return nil
+}
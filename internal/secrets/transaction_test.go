@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorTransaction(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/tls/cert":    "new-cert",
+			"op://vault/tls/key":     "new-key",
+			"op://vault/other/token": "token",
+		},
+	}
+
+	transactionConfig := func(chainReference string) *config.Config {
+		return &config.Config{
+			Secrets: []config.Secret{
+				{Path: "cert.pem", Reference: "op://vault/tls/cert", Transaction: "tls"},
+				{Path: "token", Reference: "op://vault/other/token"},
+				{Path: "key.pem", Reference: "op://vault/tls/key", Transaction: "tls"},
+				{Path: "chain.pem", Reference: chainReference, Transaction: "tls"},
+			},
+		}
+	}
+
+	t.Run("all members written", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		cfg := transactionConfig("op://vault/tls/cert")
+		cfg.Secrets[2].Symlinks = []string{filepath.Join(tmpDir, "key-link")}
+
+		processor := NewProcessor(mock, tmpDir)
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+		for _, outcome := range processor.Outcomes() {
+			if outcome.Status != statusWritten {
+				t.Errorf("Expected %s to be written, got %s", outcome.Name, outcome.Status)
+			}
+		}
+	})
+
+	t.Run("failure restores previous state", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		certPath := filepath.Join(tmpDir, "cert.pem")
+		linkPath := filepath.Join(tmpDir, "key-link")
+		if err := os.WriteFile(certPath, []byte("old-cert"), 0644); err != nil {
+			t.Fatalf("Failed to write existing cert: %v", err)
+		}
+		cfg := transactionConfig("op://vault/tls/missing")
+		cfg.Secrets[2].Symlinks = []string{linkPath}
+
+		processor := NewProcessor(mock, tmpDir)
+		if err := processor.Process(cfg); err == nil {
+			t.Fatal("Expected transaction to fail")
+		}
+
+		content, err := os.ReadFile(certPath)
+		if err != nil {
+			t.Fatalf("Failed to read restored cert: %v", err)
+		}
+		if string(content) != "old-cert" {
+			t.Errorf("Expected cert to be restored, got %q", string(content))
+		}
+		if info, _ := os.Stat(certPath); info.Mode().Perm() != 0644 {
+			t.Errorf("Expected restored cert mode 0644, got %o", info.Mode().Perm())
+		}
+		for _, path := range []string{filepath.Join(tmpDir, "key.pem"), linkPath} {
+			if _, err := os.Lstat(path); !os.IsNotExist(err) {
+				t.Errorf("Expected %s to be removed on rollback, got %v", path, err)
+			}
+		}
+
+		// The failing member stops processing, so secrets after the group are not reached
+		want := map[string]string{
+			"secret[0]:cert.pem":  statusRolledBack,
+			"secret[2]:key.pem":   statusRolledBack,
+			"secret[3]:chain.pem": statusFailed,
+		}
+		outcomes := processor.Outcomes()
+		if len(outcomes) != len(want) {
+			t.Fatalf("Expected %d outcomes, got %+v", len(want), outcomes)
+		}
+		for _, outcome := range outcomes {
+			if outcome.Status != want[outcome.Name] || outcome.Transaction != "tls" {
+				t.Errorf("Unexpected outcome %+v", outcome)
+			}
+		}
+	})
+}
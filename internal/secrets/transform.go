@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// applyTransforms runs a secret's transforms on value, in order. The steps
+// were checked when the config was loaded; errors name the failing step,
+// never the value.
+func applyTransforms(transforms []string, value, secretName string) (string, error) {
+	for i, step := range transforms {
+		next, err := transformStep(step, value)
+		if err != nil {
+			return "", &errors.OpnixError{
+				Operation: fmt.Sprintf("Transforming value of %s", secretName),
+				Component: "secret processing",
+				Issue:     fmt.Sprintf("Transform %d (%s) failed: %v", i+1, step, err),
+				Suggestions: []string{
+					"Nothing was written; the previous file is unchanged",
+					"Check the field in 1Password still holds the expected format",
+					"Each step gets the output of the one before it; check the order of transforms",
+				},
+			}
+		}
+		value = next
+	}
+	return value, nil
+}
+
+// transformStep applies one named transform, e.g. "trim" or "extract:<regex>"
+func transformStep(step, value string) (string, error) {
+	name, arg, _ := strings.Cut(step, ":")
+	switch name {
+	case "extract":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return "", fmt.Errorf("invalid regular expression: %v", err)
+		}
+		group, ok := firstGroup(re, value)
+		if !ok {
+			return "", fmt.Errorf("the value does not match the pattern")
+		}
+		return group, nil
+	case "base64decode":
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+		if err != nil {
+			return "", fmt.Errorf("the value is not valid base64: %v", err)
+		}
+		return string(decoded), nil
+	case "base64encode":
+		return base64.StdEncoding.EncodeToString([]byte(value)), nil
+	case "trim":
+		return strings.TrimSpace(value), nil
+	case "trimNewline":
+		return strings.TrimRight(value, "\r\n"), nil
+	}
+	return "", fmt.Errorf("unknown transform %q", name)
+}
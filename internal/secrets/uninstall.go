@@ -0,0 +1,202 @@
+package secrets
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/systemd"
+)
+
+// Uninstall statuses
+const (
+	uninstallRemoved     = "removed"
+	uninstallWouldRemove = "would-remove"
+	uninstallMissing     = "missing"
+	uninstallKept        = "kept"
+)
+
+// UninstallResult is what happened to one managed file or symlink
+type UninstallResult struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// Gone reports whether the entry no longer exists on disk, so it can be
+// dropped from the manifest
+func (r UninstallResult) Gone() bool {
+	return r.Status == uninstallRemoved || r.Status == uninstallMissing
+}
+
+// RemoveManaged deletes managed files and symlinks. Files are overwritten
+// with zeros before removal. Anything that changed since opnix wrote it, a
+// file with a different hash or a symlink pointing elsewhere, is kept. With
+// pruneDirs, parent directories left empty are removed too, but only below
+// root. Nothing is changed with dryRun.
+func RemoveManaged(files []ManagedFile, root string, dryRun, pruneDirs bool) ([]UninstallResult, error) {
+	// Symlinks go first so none is left dangling if a file removal fails
+	ordered := append([]ManagedFile{}, files...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Type == managedSymlink && ordered[j].Type != managedSymlink
+	})
+
+	results := make([]UninstallResult, 0, len(ordered))
+	for _, file := range ordered {
+		result, err := removeManaged(file, dryRun)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+
+		if pruneDirs && !dryRun && result.Status == uninstallRemoved {
+			pruneEmptyDirs(filepath.Dir(file.Path), root)
+		}
+	}
+	return results, nil
+}
+
+func removeManaged(file ManagedFile, dryRun bool) (UninstallResult, error) {
+	result := UninstallResult{Path: file.Path, Type: file.Type}
+
+	info, err := os.Lstat(file.Path)
+	if os.IsNotExist(err) {
+		result.Status = uninstallMissing
+		return result, nil
+	}
+	if err != nil {
+		return result, errors.FileOperationError("Uninstalling secret", file.Path, "Failed to stat managed file", err)
+	}
+
+	if reason := changedSince(file, info); reason != "" {
+		result.Status = uninstallKept
+		result.Reason = reason
+		return result, nil
+	}
+
+	if dryRun {
+		result.Status = uninstallWouldRemove
+		return result, nil
+	}
+
+	if file.Type == managedFile {
+		if err := overwriteWithZeros(file.Path, info.Size()); err != nil {
+			return result, errors.FileOperationError("Uninstalling secret", file.Path, "Failed to overwrite secret before removal", err)
+		}
+	}
+	if err := os.Remove(file.Path); err != nil {
+		return result, errors.FileOperationError("Uninstalling secret", file.Path, "Failed to remove managed file", err)
+	}
+
+	result.Status = uninstallRemoved
+	return result, nil
+}
+
+// changedSince explains why a path no longer holds what opnix left there,
+// or returns "" when it does
+func changedSince(file ManagedFile, info os.FileInfo) string {
+	switch file.Type {
+	case managedSymlink:
+		if info.Mode()&os.ModeSymlink == 0 {
+			return "no longer a symlink"
+		}
+		if target, err := os.Readlink(file.Path); err != nil || target != file.Target {
+			return fmt.Sprintf("symlink no longer points at %s", file.Target)
+		}
+	case managedFile:
+		if !info.Mode().IsRegular() {
+			return "no longer a regular file"
+		}
+		if hash, err := systemd.HashFile(file.Path); err != nil || hash != file.Hash {
+			return "content changed since opnix wrote it"
+		}
+	default:
+		return fmt.Sprintf("unknown managed file type %q", file.Type)
+	}
+	return ""
+}
+
+// overwriteWithZeros replaces a file's content in place before it is
+// unlinked. Journaling and copy-on-write filesystems may keep old blocks, so
+// this only shortens how long the value stays readable.
+func overwriteWithZeros(path string, size int64) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, zeroReader{}, size); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// zeroReader yields an endless stream of zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// pruneEmptyDirs removes dir and its parents while they are empty, stopping
+// at root, which is never removed. Directories outside root are left alone.
+func pruneEmptyDirs(dir, root string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			// Not empty, or not ours to remove
+			return
+		}
+	}
+}
+
+// Remove drops entries whose files are gone
+func (m *ManagedManifest) Remove(results []UninstallResult) {
+	gone := make(map[string]bool, len(results))
+	for _, result := range results {
+		if result.Gone() {
+			gone[filepath.Clean(result.Path)] = true
+		}
+	}
+
+	kept := m.Files[:0]
+	for _, file := range m.Files {
+		if !gone[filepath.Clean(file.Path)] {
+			kept = append(kept, file)
+		}
+	}
+	m.Files = kept
+}
+
+// Select returns the entries for paths a config writes: each secret's file,
+// its symlinks and its copies
+func (m ManagedManifest) Select(resolved []ResolvedSecret) []ManagedFile {
+	configured := make(map[string]bool)
+	for _, secret := range resolved {
+		configured[filepath.Clean(secret.Path)] = true
+		for _, symlink := range secret.Symlinks {
+			configured[filepath.Clean(symlink)] = true
+		}
+		for _, secretCopy := range secret.Copies {
+			configured[filepath.Clean(secretCopy.Path)] = true
+		}
+	}
+
+	var selected []ManagedFile
+	for _, file := range m.Files {
+		if configured[filepath.Clean(file.Path)] {
+			selected = append(selected, file)
+		}
+	}
+	return selected
+}
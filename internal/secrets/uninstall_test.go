@@ -0,0 +1,94 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestRemoveManaged(t *testing.T) {
+	tmpDir := t.TempDir()
+	mock := &mockClient{secrets: map[string]string{
+		"op://vault/tls/key":   "private-key",
+		"op://vault/app/token": "token",
+	}}
+	link := filepath.Join(tmpDir, "links", "key.pem")
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "tls/key.pem", Reference: "op://vault/tls/key", Symlinks: []string{link}},
+		{Path: "app/token", Reference: "op://vault/app/token"},
+	}}
+
+	processor := NewProcessor(mock, tmpDir)
+	if err := processor.Process(cfg); err != nil {
+		t.Fatalf("Failed to process secrets: %v", err)
+	}
+
+	// A file opnix never wrote sits next to the managed ones
+	unmanaged := filepath.Join(tmpDir, "tls", "ca.pem")
+	if err := os.WriteFile(unmanaged, []byte("ca"), 0644); err != nil {
+		t.Fatalf("Failed to write unmanaged file: %v", err)
+	}
+	// The token was replaced by hand after opnix wrote it
+	token := filepath.Join(tmpDir, "app", "token")
+	if err := os.WriteFile(token, []byte("edited"), 0600); err != nil {
+		t.Fatalf("Failed to edit token: %v", err)
+	}
+
+	var manifest ManagedManifest
+	manifest.Update(processor.ManagedFiles())
+	resolved, err := processor.ResolvePaths(cfg)
+	if err != nil {
+		t.Fatalf("Failed to resolve paths: %v", err)
+	}
+	files := manifest.Select(resolved)
+	if len(files) != 3 {
+		t.Fatalf("Expected the config's 3 managed entries, got %+v", files)
+	}
+
+	results, err := RemoveManaged(files, tmpDir, true, true)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if results[0].Path != link || results[0].Status != uninstallWouldRemove {
+		t.Errorf("Expected the symlink to be handled first and only reported, got %+v", results)
+	}
+	if _, err := os.Lstat(link); err != nil {
+		t.Errorf("Expected a dry run to leave the symlink alone: %v", err)
+	}
+
+	results, err = RemoveManaged(files, tmpDir, false, true)
+	if err != nil {
+		t.Fatalf("Failed to remove managed files: %v", err)
+	}
+	status := make(map[string]string)
+	for _, result := range results {
+		status[result.Path] = result.Status
+	}
+	for path, want := range map[string]string{
+		link:                                 uninstallRemoved,
+		filepath.Join(tmpDir, "tls/key.pem"): uninstallRemoved,
+		token:                                uninstallKept,
+	} {
+		if status[path] != want {
+			t.Errorf("Expected %s to be %s, got %q", path, want, status[path])
+		}
+	}
+
+	for _, path := range []string{link, filepath.Join(tmpDir, "links"), filepath.Join(tmpDir, "tls/key.pem")} {
+		if _, err := os.Lstat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", path, err)
+		}
+	}
+	for _, path := range []string{unmanaged, token, tmpDir} {
+		if _, err := os.Lstat(path); err != nil {
+			t.Errorf("Expected %s to be left in place: %v", path, err)
+		}
+	}
+
+	manifest.Remove(results)
+	if len(manifest.Files) != 1 || manifest.Files[0].Path != token {
+		t.Errorf("Expected only the kept token to stay in the manifest, got %+v", manifest.Files)
+	}
+}
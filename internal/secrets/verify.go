@@ -0,0 +1,154 @@
+package secrets
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/systemd"
+)
+
+// writtenSecret is what processSecret intended to leave on disk. Only the
+// content hash is kept, never the value itself.
+type writtenSecret struct {
+	name  string
+	path  string
+	hash  string
+	mode  os.FileMode
+	owner string
+	group string
+	// source and symlinks are kept for the managed-file manifest
+	source   string
+	symlinks []string
+	// shared files hold a region next to content opnix does not own
+	shared bool
+}
+
+// recordWrite remembers a written secret so Verify can check it later
+func (p *Processor) recordWrite(secret config.Secret, path string, data []byte, mode os.FileMode, secretName string) {
+	p.recordHash(secret, path, systemd.HashContent(data), mode, secretName)
+}
+
+// recordHash is recordWrite for content that was hashed while it was written
+func (p *Processor) recordHash(secret config.Secret, path, hash string, mode os.FileMode, secretName string) {
+	// Another region of a shared file changes what the earlier ones left
+	if secret.Region != nil {
+		for i := range p.written {
+			if p.written[i].shared && p.written[i].path == path {
+				p.written[i].hash = hash
+			}
+		}
+	}
+	p.written = append(p.written, writtenSecret{
+		name:  secretName,
+		path:  path,
+		hash:  hash,
+		mode:  mode.Perm(),
+		owner: secret.Owner,
+		group: secret.Group,
+
+		source:   secret.Source,
+		symlinks: secret.Symlinks,
+		shared:   secret.Region != nil,
+	})
+}
+
+// Verify re-reads every file written by the last Process call and confirms its
+// content hash, mode and ownership still match the configuration. All drift is
+// reported at once, e.g. when a concurrent writer touched a file.
+func (p *Processor) Verify() error {
+	var drift []string
+
+	for _, written := range p.written {
+		problems, err := p.verifyWritten(written)
+		if err != nil {
+			return err
+		}
+		for _, problem := range problems {
+			drift = append(drift, fmt.Sprintf("%s (%s): %s", written.name, written.path, problem))
+		}
+	}
+
+	if len(drift) == 0 {
+		return nil
+	}
+
+	return &errors.OpnixError{
+		Operation: "Verifying written secrets",
+		Component: "file system",
+		Issue:     fmt.Sprintf("%d secret file(s) do not match the configuration", len(drift)),
+		Context:   strings.Join(drift, "\n    "),
+		Suggestions: []string{
+			"Check for other processes writing to the same paths",
+			"Re-run opnix to rewrite the affected secrets",
+		},
+	}
+}
+
+// verifyWritten compares one file against what was intended
+func (p *Processor) verifyWritten(written writtenSecret) ([]string, error) {
+	return p.fileProblems(written.path, written.hash, written.mode, written.owner, written.group, written.name)
+}
+
+// fileProblems compares the file at path with the content hash, mode and
+// ownership it should have; an empty hash leaves the content unchecked
+func (p *Processor) fileProblems(path, hash string, mode os.FileMode, owner, group, secretName string) ([]string, error) {
+	var problems []string
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return []string{"file is missing"}, nil
+	}
+	if err != nil {
+		return []string{fmt.Sprintf("cannot stat file: %v", err)}, nil
+	}
+
+	if hash != "" {
+		actual, err := systemd.HashFile(path)
+		if err != nil {
+			return []string{fmt.Sprintf("cannot read file: %v", err)}, nil
+		}
+		if actual != hash {
+			problems = append(problems, "content hash differs from the resolved value")
+		}
+	}
+
+	if info.Mode().Perm() != mode.Perm() {
+		problems = append(problems, fmt.Sprintf("mode is %04o, expected %04o", info.Mode().Perm(), mode.Perm()))
+	}
+
+	ownership, err := p.ownershipProblems(info, owner, group, secretName)
+	if err != nil {
+		return nil, err
+	}
+	return append(problems, ownership...), nil
+}
+
+// ownershipProblems compares a file's owner and group with the configured
+// names; unset names are not checked
+func (p *Processor) ownershipProblems(info os.FileInfo, owner, group, secretName string) ([]string, error) {
+	if owner == "" && group == "" {
+		return nil, nil
+	}
+
+	uid, gid, err := p.lookupOwnership(owner, group, secretName)
+	if err != nil {
+		return nil, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, nil
+	}
+
+	var problems []string
+	if uid != -1 && int(stat.Uid) != uid {
+		problems = append(problems, fmt.Sprintf("owner UID is %d, expected %d (%s)", stat.Uid, uid, owner))
+	}
+	if gid != -1 && int(stat.Gid) != gid {
+		problems = append(problems, fmt.Sprintf("group GID is %d, expected %d (%s)", stat.Gid, gid, group))
+	}
+	return problems, nil
+}
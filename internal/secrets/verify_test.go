@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestProcessorVerify(t *testing.T) {
+	mock := &mockClient{
+		secrets: map[string]string{
+			"op://vault/item/password": "secret-one",
+			"op://vault/item/token":    "secret-two",
+		},
+	}
+
+	cfg := &config.Config{
+		Secrets: []config.Secret{
+			{Path: "password", Reference: "op://vault/item/password"},
+			{Path: "token", Reference: "op://vault/item/token", Mode: "0640"},
+		},
+	}
+
+	t.Run("untouched files verify", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		processor := NewProcessor(mock, tmpDir)
+
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+		if err := processor.Verify(); err != nil {
+			t.Errorf("Expected verification to pass, got: %v", err)
+		}
+	})
+
+	t.Run("content and mode drift detected", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		processor := NewProcessor(mock, tmpDir)
+
+		if err := processor.Process(cfg); err != nil {
+			t.Fatalf("Failed to process secrets: %v", err)
+		}
+
+		// Simulate a concurrent writer
+		if err := os.WriteFile(filepath.Join(tmpDir, "password"), []byte("tampered"), 0600); err != nil {
+			t.Fatalf("Failed to modify file: %v", err)
+		}
+		if err := os.Chmod(filepath.Join(tmpDir, "token"), 0644); err != nil {
+			t.Fatalf("Failed to chmod file: %v", err)
+		}
+
+		err := processor.Verify()
+		if err == nil {
+			t.Fatal("Expected verification to fail")
+		}
+		if !contains(err.Error(), "content hash differs") {
+			t.Errorf("Expected content drift in error, got: %v", err)
+		}
+		if !contains(err.Error(), "mode is 0644, expected 0640") {
+			t.Errorf("Expected mode drift in error, got: %v", err)
+		}
+		if contains(err.Error(), "secret-one") || contains(err.Error(), "tampered") {
+			t.Errorf("Verification error must not contain file contents: %v", err)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/systemd"
+)
+
+// DefaultWebhookTimeout bounds a webhook request when no timeout is configured
+const DefaultWebhookTimeout = 10 * time.Second
+
+// DeployEvent is posted to a webhook after a run: the run summary plus run
+// metadata. Like the summary, it never holds secret values.
+type DeployEvent struct {
+	RunSummary
+	Hostname        string         `json:"hostname"`
+	DurationSeconds float64        `json:"durationSeconds"`
+	Counts          map[string]int `json:"counts"`
+	// Services configured on the secrets this run wrote, which the systemd
+	// integration restarts or reloads
+	Services []string `json:"services"`
+}
+
+// NewDeployEvent describes a run that started at started. cfg may be nil
+// when the run failed before its config was loaded.
+func NewDeployEvent(cfg *config.Config, summary RunSummary, started time.Time) DeployEvent {
+	event := DeployEvent{
+		RunSummary:      summary,
+		DurationSeconds: time.Since(started).Seconds(),
+		Counts:          make(map[string]int),
+		Services:        []string{},
+	}
+	event.Hostname, _ = os.Hostname()
+
+	written := make(map[string]bool)
+	for _, outcome := range summary.Outcomes {
+		event.Counts[outcome.Status]++
+		if outcome.Status == statusWritten {
+			written[outcome.Name] = true
+		}
+	}
+
+	if cfg == nil {
+		return event
+	}
+	seen := make(map[string]bool)
+	for i, secret := range cfg.Secrets {
+		name := fmt.Sprintf("secret[%d]:%s", i, secret.Path)
+		if !written[name] {
+			continue
+		}
+		// Invalid service settings were rejected while loading the config
+		actions, _ := systemd.PlanServiceActions(cfg.SystemdIntegration, secret, name)
+		for _, action := range actions {
+			if !seen[action.Name] {
+				seen[action.Name] = true
+				event.Services = append(event.Services, action.Name)
+			}
+		}
+	}
+	sort.Strings(event.Services)
+	return event
+}
+
+// Post sends the event as JSON to url. Any response other than 2xx is an error.
+func (e DeployEvent) Post(url string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = DefaultWebhookTimeout
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return webhookError(url, "Failed to encode deployment event", err)
+	}
+
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return webhookError(url, "Failed to reach webhook", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return webhookError(url, fmt.Sprintf("Webhook answered %s", response.Status), nil)
+	}
+	return nil
+}
+
+// webhookError reports a failed post. Webhook URLs often embed a token, so
+// only the host is named.
+func webhookError(url, issue string, cause error) error {
+	host := "<invalid URL>"
+	if parsed, err := neturl.Parse(url); err == nil {
+		host = parsed.Host
+	}
+	if urlErr, ok := cause.(*neturl.Error); ok {
+		// url.Error repeats the full URL
+		cause = urlErr.Err
+	}
+	return &errors.OpnixError{
+		Operation: "Posting deployment event",
+		Component: "webhook",
+		Issue:     issue,
+		Context:   fmt.Sprintf("Webhook host: %s", host),
+		Cause:     cause,
+		Suggestions: []string{
+			"Check the webhook URL and that the endpoint accepts JSON POST requests",
+			"Raise the webhook timeout if the endpoint is slow",
+		},
+	}
+}
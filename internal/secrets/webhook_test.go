@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestDeployEvent(t *testing.T) {
+	cfg := &config.Config{Secrets: []config.Secret{
+		{Path: "a", Reference: "op://vault/item/a", Services: []interface{}{"nginx", "postgresql"}},
+		{Path: "b", Reference: "op://vault/item/b", Services: []interface{}{"redis"}},
+		{Path: "c", Reference: "op://vault/item/c", Services: []interface{}{"nginx"}},
+	}}
+	outcomes := []Outcome{
+		{Name: "secret[0]:a", Path: "/run/secrets/a", Status: statusWritten},
+		{Name: "secret[1]:b", Path: "/run/secrets/b", Status: statusFailed},
+		{Name: "secret[2]:c", Path: "/run/secrets/c", Status: statusWritten},
+	}
+
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &received); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	event := NewDeployEvent(cfg, NewRunSummary(outcomes, nil, fmt.Errorf("b failed")), time.Now().Add(-time.Second))
+	if err := event.Post(server.URL, 0); err != nil {
+		t.Fatalf("Failed to post event: %v", err)
+	}
+
+	if received["hostname"] == "" || received["durationSeconds"].(float64) < 1 {
+		t.Errorf("Expected run metadata, got %v", received)
+	}
+	counts, _ := json.Marshal(received["counts"])
+	if string(counts) != `{"failed":1,"written":2}` {
+		t.Errorf("Expected outcome counts, got %s", counts)
+	}
+	services, _ := json.Marshal(received["services"])
+	if string(services) != `["nginx","postgresql"]` {
+		t.Errorf("Expected the services of written secrets, got %s", services)
+	}
+	if received["error"] != "b failed" || len(received["outcomes"].([]interface{})) != 3 {
+		t.Errorf("Expected the run summary to be included, got %v", received)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+	err := event.Post(failing.URL+"/hook/T0KEN", time.Second)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("Expected a non-2xx answer to fail, got %v", err)
+	}
+	if strings.Contains(err.Error(), "T0KEN") {
+		t.Errorf("Expected the webhook path to be left out of errors: %v", err)
+	}
+}
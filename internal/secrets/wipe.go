@@ -0,0 +1,15 @@
+package secrets
+
+import "runtime"
+
+// wipe overwrites a buffer read from disk, such as the file a backup is
+// taken of, so its content does not linger in reusable heap memory.
+//
+// Resolved values are not wiped: the 1Password SDK returns them as strings,
+// which cannot be cleared, so zeroing a buffer made from one would leave the
+// string behind and only look safer. wipe is for content that never becomes
+// a string, and even then the runtime may have copied it first.
+func wipe(buf []byte) {
+	clear(buf)
+	runtime.KeepAlive(buf)
+}
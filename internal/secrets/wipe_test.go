@@ -0,0 +1,14 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWipe(t *testing.T) {
+	buf := []byte("hunter2")
+	wipe(buf)
+	if !bytes.Equal(buf, make([]byte, len("hunter2"))) {
+		t.Errorf("Expected buffer to be zeroed, got %q", buf)
+	}
+}
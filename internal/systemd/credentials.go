@@ -0,0 +1,63 @@
+package systemd
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// CredentialEncrypter encrypts systemd credentials with systemd-creds
+type CredentialEncrypter struct {
+	systemdCreds string
+	pinned       bool
+	runner       CommandRunner
+}
+
+// NewCredentialEncrypter creates an encrypter that runs the systemd-creds
+// next to the systemctl pinned in the config, so both come from the same
+// systemd, or the one found in PATH
+func NewCredentialEncrypter(cfg config.SystemdIntegration) *CredentialEncrypter {
+	return NewCredentialEncrypterWithRunner(cfg, execRunner{})
+}
+
+// NewCredentialEncrypterWithRunner creates an encrypter that issues
+// systemd-creds commands through runner instead of executing them directly
+func NewCredentialEncrypterWithRunner(cfg config.SystemdIntegration, runner CommandRunner) *CredentialEncrypter {
+	if cfg.Systemctl == "" {
+		return &CredentialEncrypter{systemdCreds: "systemd-creds", runner: runner}
+	}
+	return &CredentialEncrypter{
+		systemdCreds: filepath.Join(filepath.Dir(cfg.Systemctl), "systemd-creds"),
+		pinned:       true,
+		runner:       runner,
+	}
+}
+
+// Encrypt encrypts data as the credential name, for services to load with
+// LoadCredentialEncrypted=. The plaintext is passed on stdin, never as an
+// argument or a file.
+func (e *CredentialEncrypter) Encrypt(name string, data []byte) ([]byte, error) {
+	stdout, stderr, err := e.runner.RunWithInput(data, e.systemdCreds, "encrypt", "--name="+name, "-", "-")
+	if err != nil {
+		suggestions := []string{
+			"Check that systemd-creds is installed (systemd 250 or newer)",
+			"Run opnix as root so systemd-creds can read the host key",
+			"Set credentialEncrypted = false to store the credential unencrypted",
+		}
+		if e.pinned {
+			suggestions[0] = fmt.Sprintf("systemd-creds is run from the directory of the pinned systemctl; check that %s exists", e.systemdCreds)
+		}
+		return nil, &errors.OpnixError{
+			Operation:   "Encrypting systemd credential",
+			Component:   "systemd service",
+			Issue:       fmt.Sprintf("systemd-creds failed to encrypt credential %s", name),
+			Context:     strings.TrimSpace(string(stderr)),
+			Cause:       err,
+			Suggestions: suggestions,
+		}
+	}
+	return stdout, nil
+}
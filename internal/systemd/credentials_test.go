@@ -0,0 +1,59 @@
+package systemd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+)
+
+func TestCredentialEncrypter(t *testing.T) {
+	tests := []struct {
+		name      string
+		systemctl string
+		want      string
+	}{
+		{name: "from PATH", want: "systemd-creds encrypt --name=db-password - -"},
+		{
+			name:      "next to the pinned systemctl",
+			systemctl: "/nix/store/abc-systemd/bin/systemctl",
+			want:      "/nix/store/abc-systemd/bin/systemd-creds encrypt --name=db-password - -",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeSystemctl{}
+			encrypter := NewCredentialEncrypterWithRunner(config.SystemdIntegration{Systemctl: tt.systemctl}, fake)
+
+			encrypted, err := encrypter.Encrypt("db-password", []byte("hunter2"))
+			if err != nil {
+				t.Fatalf("Encrypt() error = %v", err)
+			}
+			if string(encrypted) != "encrypted:hunter2" {
+				t.Errorf("Expected the command's stdout, got %q", encrypted)
+			}
+			if len(fake.commands) != 1 || fake.commands[0] != tt.want {
+				t.Errorf("Expected command %q, got %v", tt.want, fake.commands)
+			}
+			if len(fake.inputs) != 1 || fake.inputs[0] != "hunter2" {
+				t.Errorf("Expected the plaintext on stdin, got %v", fake.inputs)
+			}
+		})
+	}
+
+	t.Run("failure names the pinned binary", func(t *testing.T) {
+		fake := &fakeSystemctl{failing: map[string]bool{"db-password": true}}
+		encrypter := NewCredentialEncrypterWithRunner(config.SystemdIntegration{Systemctl: "/opt/systemd/bin/systemctl"}, fake)
+
+		_, err := encrypter.Encrypt("db-password", []byte("hunter2"))
+		if err == nil {
+			t.Fatal("Expected the failed encryption to be reported")
+		}
+		for _, want := range []string{"db-password", "Failed to determine local credential key", "/opt/systemd/bin/systemd-creds"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected the error to mention %q, got: %v", want, err)
+			}
+		}
+	})
+}
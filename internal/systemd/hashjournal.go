@@ -0,0 +1,153 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/lock"
+)
+
+// The hash file is only replaced as a whole by save. Every hash recorded in
+// between is appended to a journal next to it first, so the hashes of
+// secrets already handled survive a run that is interrupted before saving.
+// A lock file next to both keeps overlapping runs from interleaving.
+
+// journalPath is where recorded hashes wait for the next save
+func (hs *HashStore) journalPath() string {
+	return hs.filePath + ".journal"
+}
+
+// lock takes the store's lock, waiting for other runs up to lock.DefaultTimeout
+func (hs *HashStore) lock() (*lock.Lock, error) {
+	return lock.Acquire(hs.filePath+".lock", lock.ModeWait, 0)
+}
+
+// readDisk returns the saved hashes with the journal replayed over them.
+// Lines a crash left half written are skipped.
+func (hs *HashStore) readDisk() (map[string]SecretHash, error) {
+	stored := struct {
+		Hashes map[string]SecretHash `json:"hashes"`
+	}{}
+	data, err := os.ReadFile(hs.filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.FileOperationError(
+			"Loading hash store",
+			hs.filePath,
+			"Failed to read hash store file",
+			err,
+		)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, errors.ConfigError(
+				"Parsing hash store",
+				"Invalid JSON format in hash store file",
+				err,
+			)
+		}
+	}
+	if stored.Hashes == nil {
+		stored.Hashes = make(map[string]SecretHash)
+	}
+
+	journal, err := os.ReadFile(hs.journalPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.FileOperationError(
+			"Loading hash store",
+			hs.journalPath(),
+			"Failed to read hash store journal",
+			err,
+		)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(journal))
+	scanner.Buffer(nil, len(journal)+1)
+	for scanner.Scan() {
+		var entry SecretHash
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil && entry.Path != "" {
+			stored.Hashes[entry.Path] = entry
+		}
+	}
+	return stored.Hashes, nil
+}
+
+// put records a file's new hash, appending it to the journal before the
+// in-memory store so it is durable once put returns
+func (hs *HashStore) put(entry SecretHash) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return errors.ConfigError("Recording secret hash", "Failed to marshal hash entry", err)
+	}
+
+	held, err := hs.lock()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = held.Release() }()
+
+	file, err := os.OpenFile(hs.journalPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, hs.fileMode)
+	if err != nil {
+		return errors.FileOperationError(
+			"Recording secret hash",
+			hs.journalPath(),
+			"Failed to open hash store journal",
+			err,
+		)
+	}
+	defer func() { _ = file.Close() }()
+
+	// One write per line, so a crash leaves at most the last line torn
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.FileOperationError("Recording secret hash", hs.journalPath(), "Failed to append to hash store journal", err)
+	}
+	if err := file.Sync(); err != nil {
+		return errors.FileOperationError("Recording secret hash", hs.journalPath(), "Failed to flush hash store journal", err)
+	}
+
+	hs.Hashes[entry.Path] = entry
+	return nil
+}
+
+// writeFile replaces the hash file with data
+func (hs *HashStore) writeFile(data []byte) error {
+	return writeAtomic("Saving hash store", hs.filePath, hs.fileMode, data)
+}
+
+// writeAtomic replaces path with data in one rename, so readers see either
+// the old or the new file
+func writeAtomic(operation, path string, mode os.FileMode, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return errors.FileOperationError(operation, path, "Failed to create temporary file", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.FileOperationError(operation, path, "Failed to write file", err)
+	}
+
+	// CreateTemp uses 0600 whatever the requested mode
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return errors.FileOperationError(
+			operation,
+			path,
+			fmt.Sprintf("Failed to set file mode %04o", mode),
+			err,
+		)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return errors.FileOperationError(operation, path, "Failed to replace file", err)
+	}
+	return nil
+}
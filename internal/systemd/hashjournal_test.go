@@ -0,0 +1,138 @@
+package systemd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSecrets writes each path's content under dir
+func writeSecrets(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+	for name, content := range contents {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+}
+
+func TestHashStoreSurvivesInterruptedRun(t *testing.T) {
+	tempDir := t.TempDir()
+	hashFile := filepath.Join(tempDir, "hashes.json")
+	writeSecrets(t, tempDir, map[string]string{"a": "a-v1", "b": "b-v1", "c": "c-v1"})
+	a, b, c := filepath.Join(tempDir, "a"), filepath.Join(tempDir, "b"), filepath.Join(tempDir, "c")
+
+	store, err := NewHashStore(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to create hash store: %v", err)
+	}
+	for _, path := range []string{a, b, c} {
+		if _, err := store.hasChanged(path); err != nil {
+			t.Fatalf("Failed to check %s: %v", path, err)
+		}
+	}
+	if err := store.save(); err != nil {
+		t.Fatalf("Failed to save hash store: %v", err)
+	}
+
+	// The next run handles a and b, then dies before saving
+	writeSecrets(t, tempDir, map[string]string{"a": "a-v2", "b": "b-v2"})
+	store, err = NewHashStore(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to load hash store: %v", err)
+	}
+	for _, path := range []string{a, b} {
+		if changed, err := store.hasChanged(path); err != nil || !changed {
+			t.Fatalf("Expected %s to have changed, got %v, %v", path, changed, err)
+		}
+	}
+
+	// A crash in the middle of the next append leaves a torn line behind
+	journal, err := os.OpenFile(hashFile+".journal", os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("Expected a journal of the interrupted run: %v", err)
+	}
+	if _, err := journal.WriteString(`{"path":"` + c + `","hash":"de`); err != nil {
+		t.Fatalf("Failed to tear journal: %v", err)
+	}
+	_ = journal.Close()
+
+	// The rerun does not restart services for secrets already handled
+	store, err = NewHashStore(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to load hash store after the interruption: %v", err)
+	}
+	for _, path := range []string{a, b, c} {
+		if changed, err := store.hasChanged(path); err != nil || changed {
+			t.Errorf("Expected %s to be unchanged after the rerun, got %v, %v", path, changed, err)
+		}
+	}
+
+	if err := store.save(); err != nil {
+		t.Fatalf("Failed to save hash store: %v", err)
+	}
+	if _, err := os.Stat(hashFile + ".journal"); !os.IsNotExist(err) {
+		t.Errorf("Expected save to clear the journal, got %v", err)
+	}
+	saved, err := NewHashStore(hashFile)
+	if err != nil || len(saved.Hashes) != 3 {
+		t.Errorf("Expected 3 saved hashes, got %+v, %v", saved, err)
+	}
+}
+
+func TestHashStoreOverlappingRuns(t *testing.T) {
+	tempDir := t.TempDir()
+	hashFile := filepath.Join(tempDir, "hashes.json")
+	writeSecrets(t, tempDir, map[string]string{"a": "a-v1", "b": "b-v1"})
+	a, b := filepath.Join(tempDir, "a"), filepath.Join(tempDir, "b")
+
+	seed, err := NewHashStore(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to create hash store: %v", err)
+	}
+	for _, path := range []string{a, b} {
+		if _, err := seed.hasChanged(path); err != nil {
+			t.Fatalf("Failed to check %s: %v", path, err)
+		}
+	}
+	if err := seed.save(); err != nil {
+		t.Fatalf("Failed to save hash store: %v", err)
+	}
+
+	// Both runs load the same state; each changes one secret
+	first, err := NewHashStore(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to load hash store: %v", err)
+	}
+	second, err := NewHashStore(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to load hash store: %v", err)
+	}
+
+	writeSecrets(t, tempDir, map[string]string{"a": "a-v2"})
+	if changed, err := first.hasChanged(a); err != nil || !changed {
+		t.Fatalf("Expected a to have changed, got %v, %v", changed, err)
+	}
+	if err := first.save(); err != nil {
+		t.Fatalf("Failed to save first run: %v", err)
+	}
+
+	writeSecrets(t, tempDir, map[string]string{"b": "b-v2"})
+	if changed, err := second.hasChanged(b); err != nil || !changed {
+		t.Fatalf("Expected b to have changed, got %v, %v", changed, err)
+	}
+	if err := second.save(); err != nil {
+		t.Fatalf("Failed to save second run: %v", err)
+	}
+
+	// The second save keeps the first run's hash of a instead of its stale copy
+	final, err := NewHashStore(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to load hash store: %v", err)
+	}
+	for _, path := range []string{a, b} {
+		if changed, err := final.hasChanged(path); err != nil || changed {
+			t.Errorf("Expected %s to be recorded by its run, got changed=%v, %v", path, changed, err)
+		}
+	}
+}
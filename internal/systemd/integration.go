@@ -1,6 +1,7 @@
 package systemd
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
@@ -9,11 +10,16 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/brizzbuzz/opnix/internal/config"
 	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/schedule"
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
 // ServiceAction defines how to handle a service when secrets change
@@ -24,61 +30,223 @@ type ServiceAction struct {
 	After   []string
 }
 
-// SecretHash represents a stored hash of a secret's content
+// SecretHash represents a stored hash of a secret's content together with
+// its mode and ownership, so a policy change alone also counts as a change
 type SecretHash struct {
 	Path         string    `json:"path"`
 	Hash         string    `json:"hash"`
 	LastModified time.Time `json:"lastModified"`
+	// Empty in stores written before descriptors were recorded
+	Mode string `json:"mode,omitempty"`
+	UID  int    `json:"uid"`
+	GID  int    `json:"gid"`
 }
 
-// HashStore manages secret content hashes for change detection
+// newSecretHash describes a deployed file by content hash, mode and owner
+func newSecretHash(filePath, hash string, info os.FileInfo) SecretHash {
+	entry := SecretHash{
+		Path:         filePath,
+		Hash:         hash,
+		LastModified: info.ModTime(),
+		Mode:         fmt.Sprintf("%04o", info.Mode().Perm()),
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		entry.UID = int(stat.Uid)
+		entry.GID = int(stat.Gid)
+	}
+	return entry
+}
+
+// sameState reports whether two descriptors describe the same deployed state.
+// Entries from older stores carry no descriptor and are compared by content.
+func (h SecretHash) sameState(other SecretHash) bool {
+	if h.Hash != other.Hash {
+		return false
+	}
+	if h.Mode == "" || other.Mode == "" {
+		return true
+	}
+	return h.Mode == other.Mode && h.UID == other.UID && h.GID == other.GID
+}
+
+// DefaultHashFileMode keeps the hash file writable and readable by its owner only
+const DefaultHashFileMode os.FileMode = 0600
+
+// HashStore manages secret content hashes for change detection. Changed
+// hashes are journaled as they are found and merged into the file on save,
+// see hashjournal.go.
 type HashStore struct {
 	Hashes   map[string]SecretHash `json:"hashes"`
 	filePath string
+	fileMode os.FileMode
+	// base is what the store held when loaded; entries still equal to it
+	// were not changed by this run and take other runs' updates on save
+	base map[string]SecretHash
 }
 
 // Manager handles systemd service integration and change detection
 type Manager struct {
-	config    config.SystemdIntegration
-	hashStore *HashStore
-	dryRun    bool
-	systemctl string
+	config      config.SystemdIntegration
+	hashStore   *HashStore
+	dryRun      bool
+	systemctl   string
+	parallelism int
+	runner      CommandRunner
+	// warnings receives problems tolerated under continueOnError, see SetWarnings
+	warnings *warnings.Collector
+	// windows service actions may run in; outside them actions are deferred, see pending.go
+	windows []schedule.Window
+	now     func() time.Time
+}
+
+// CommandRunner runs external commands such as systemctl. Tests substitute a
+// fake to assert the exact commands issued without a real systemd.
+type CommandRunner interface {
+	// Run executes name with args and returns its combined output. A non-zero
+	// exit status is reported as an error with an ExitCode() int method.
+	Run(name string, args ...string) ([]byte, error)
+	// RunWithInput executes name with args, passing input on stdin, and
+	// returns its stdout and stderr apart, for commands whose output is data
+	RunWithInput(input []byte, name string, args ...string) (stdout, stderr []byte, err error)
+}
+
+// execRunner runs commands with os/exec
+type execRunner struct{}
+
+func (execRunner) Run(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+func (execRunner) RunWithInput(input []byte, name string, args ...string) ([]byte, []byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	err := cmd.Run()
+	return stdout.Bytes(), stderr.Bytes(), err
 }
 
-// NewManager creates a new systemd integration manager
+// NewManager creates a new systemd integration manager. It runs the
+// systemctl binary pinned in the config, or the one found in PATH.
 func NewManager(cfg config.SystemdIntegration) (*Manager, error) {
-	// Find systemctl binary
-	systemctl, err := exec.LookPath("systemctl")
+	systemctl, err := findSystemctl(cfg.Systemctl)
 	if err != nil {
-		return nil, errors.FileOperationError(
+		return nil, err
+	}
+
+	return newManager(cfg, systemctl, execRunner{})
+}
+
+// findSystemctl checks that a pinned systemctl path is an executable file,
+// falling back to a PATH lookup when none is pinned
+func findSystemctl(path string) (string, error) {
+	if path == "" {
+		systemctl, err := exec.LookPath("systemctl")
+		if err != nil {
+			return "", errors.FileOperationError(
+				"Finding systemctl binary",
+				"systemctl",
+				"systemctl not found in PATH - systemd integration requires systemd",
+				err,
+			)
+		}
+		return systemctl, nil
+	}
+
+	if !filepath.IsAbs(path) {
+		return "", errors.ValidationError(
+			"Finding systemctl binary",
+			"systemdIntegration.systemctl",
+			path,
+			"absolute path, e.g. /run/current-system/sw/bin/systemctl",
+		)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", errors.FileOperationError(
 			"Finding systemctl binary",
-			"systemctl",
-			"systemctl not found in PATH - systemd integration requires systemd",
+			path,
+			"Configured systemctl binary does not exist",
 			err,
 		)
 	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return "", errors.FileOperationError(
+			"Finding systemctl binary",
+			path,
+			"Configured systemctl binary is not an executable file",
+			nil,
+		)
+	}
+	return path, nil
+}
+
+// NewManagerWithRunner creates a manager that issues systemctl commands
+// through runner instead of executing them directly
+func NewManagerWithRunner(cfg config.SystemdIntegration, runner CommandRunner) (*Manager, error) {
+	return newManager(cfg, "systemctl", runner)
+}
 
+func newManager(cfg config.SystemdIntegration, systemctl string, runner CommandRunner) (*Manager, error) {
 	// Initialize hash store if change detection is enabled
 	var hashStore *HashStore
 	if cfg.ChangeDetection.Enable {
-		hashStore, err = NewHashStore(cfg.ChangeDetection.HashFile)
+		var err error
+		mode := DefaultHashFileMode
+		if cfg.ChangeDetection.HashFileMode != "" {
+			parsed, err := strconv.ParseUint(cfg.ChangeDetection.HashFileMode, 8, 32)
+			if err != nil {
+				return nil, errors.ValidationError(
+					"Parsing hash file mode",
+					"hashFileMode",
+					cfg.ChangeDetection.HashFileMode,
+					"3-4 digit octal number (e.g., 0600, 0644)",
+				)
+			}
+			mode = os.FileMode(parsed)
+		}
+		hashStore, err = NewHashStoreWithMode(cfg.ChangeDetection.HashFile, mode)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	windows := make([]schedule.Window, 0, len(cfg.MaintenanceWindows))
+	for _, spec := range cfg.MaintenanceWindows {
+		window, err := schedule.Parse(spec)
+		if err != nil {
+			return nil, errors.ValidationError(
+				"Parsing maintenance window",
+				"maintenanceWindows",
+				spec,
+				"[days ]HH:MM-HH:MM (e.g., 02:00-04:00, Sat 02:00-06:00, Mon-Fri 22:00-06:00)",
+			)
+		}
+		windows = append(windows, window)
+	}
+
 	return &Manager{
-		config:    cfg,
-		hashStore: hashStore,
-		systemctl: systemctl,
+		config:      cfg,
+		hashStore:   hashStore,
+		systemctl:   systemctl,
+		parallelism: cfg.ParallelServices,
+		runner:      runner,
+		windows:     windows,
+		now:         time.Now,
 	}, nil
 }
 
 // NewHashStore creates or loads a hash store from disk
 func NewHashStore(filePath string) (*HashStore, error) {
+	return NewHashStoreWithMode(filePath, DefaultHashFileMode)
+}
+
+// NewHashStoreWithMode creates or loads a hash store whose file is kept at mode
+func NewHashStoreWithMode(filePath string, mode os.FileMode) (*HashStore, error) {
 	store := &HashStore{
 		Hashes:   make(map[string]SecretHash),
 		filePath: filePath,
+		fileMode: mode,
 	}
 
 	// Create parent directory if it doesn't exist
@@ -91,41 +259,61 @@ func NewHashStore(filePath string) (*HashStore, error) {
 		)
 	}
 
-	// Load existing hashes if file exists
+	// Load saved and journaled hashes, tightening a file left at an older mode
+	if err := store.load(); err != nil {
+		return nil, err
+	}
 	if _, err := os.Stat(filePath); err == nil {
-		if err := store.load(); err != nil {
-			return nil, err
+		if err := os.Chmod(filePath, mode); err != nil {
+			return nil, errors.FileOperationError(
+				"Setting hash store permissions",
+				filePath,
+				fmt.Sprintf("Failed to set file mode %04o", mode),
+				err,
+			)
 		}
 	}
 
 	return store, nil
 }
 
-// load reads the hash store from disk
+// load reads the hash store and its journal from disk
 func (hs *HashStore) load() error {
-	data, err := os.ReadFile(hs.filePath)
+	held, err := hs.lock()
 	if err != nil {
-		return errors.FileOperationError(
-			"Loading hash store",
-			hs.filePath,
-			"Failed to read hash store file",
-			err,
-		)
+		return err
 	}
+	defer func() { _ = held.Release() }()
 
-	if err := json.Unmarshal(data, hs); err != nil {
-		return errors.ConfigError(
-			"Parsing hash store",
-			"Invalid JSON format in hash store file",
-			err,
-		)
+	hashes, err := hs.readDisk()
+	if err != nil {
+		return err
 	}
-
+	hs.Hashes = hashes
+	hs.base = copyHashes(hashes)
 	return nil
 }
 
-// save writes the hash store to disk
+// save merges the hash store into the file on disk and clears the journal.
+// Hashes this run changed win; any other entry takes the value another run
+// saved or journaled in the meantime.
 func (hs *HashStore) save() error {
+	held, err := hs.lock()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = held.Release() }()
+
+	disk, err := hs.readDisk()
+	if err != nil {
+		return err
+	}
+	for path, entry := range disk {
+		if current, ok := hs.Hashes[path]; !ok || current == hs.base[path] {
+			hs.Hashes[path] = entry
+		}
+	}
+
 	data, err := json.MarshalIndent(hs, "", "  ")
 	if err != nil {
 		return errors.ConfigError(
@@ -134,21 +322,38 @@ func (hs *HashStore) save() error {
 			err,
 		)
 	}
+	if err := hs.writeFile(data); err != nil {
+		return err
+	}
 
-	if err := os.WriteFile(hs.filePath, data, 0644); err != nil {
+	// Everything journaled is in the file now
+	if err := os.Remove(hs.journalPath()); err != nil && !os.IsNotExist(err) {
 		return errors.FileOperationError(
 			"Saving hash store",
-			hs.filePath,
-			"Failed to write hash store file",
+			hs.journalPath(),
+			"Failed to clear hash store journal",
 			err,
 		)
 	}
-
+	hs.base = copyHashes(hs.Hashes)
 	return nil
 }
 
+func copyHashes(hashes map[string]SecretHash) map[string]SecretHash {
+	copied := make(map[string]SecretHash, len(hashes))
+	for path, entry := range hashes {
+		copied[path] = entry
+	}
+	return copied
+}
+
 // calculateHash calculates SHA-256 hash of a file's content
 func (hs *HashStore) calculateHash(filePath string) (string, error) {
+	return HashFile(filePath)
+}
+
+// HashFile calculates the hex-encoded SHA-256 hash of a file's content
+func HashFile(filePath string) (string, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return "", errors.FileOperationError(
@@ -173,7 +378,15 @@ func (hs *HashStore) calculateHash(filePath string) (string, error) {
 	return hex.EncodeToString(hasher.Sum(nil)), nil
 }
 
-// HasChanged checks if a secret has changed since last deployment
+// HashContent calculates the hex-encoded SHA-256 hash of in-memory content,
+// matching HashFile for the same bytes
+func HashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// hasChanged checks if a secret's content, mode or ownership has changed
+// since last deployment
 func (hs *HashStore) hasChanged(filePath string) (bool, error) {
 	// Calculate current hash
 	currentHash, err := hs.calculateHash(filePath)
@@ -192,33 +405,50 @@ func (hs *HashStore) hasChanged(filePath string) (bool, error) {
 		)
 	}
 
+	current := newSecretHash(filePath, currentHash, fileInfo)
+
 	// Check if we have a previous hash
 	previousHash, exists := hs.Hashes[filePath]
 	if !exists {
 		// First time seeing this file - it's "changed"
-		hs.Hashes[filePath] = SecretHash{
-			Path:         filePath,
-			Hash:         currentHash,
-			LastModified: fileInfo.ModTime(),
-		}
-		return true, nil
+		return true, hs.put(current)
 	}
 
-	// Compare hashes
-	if previousHash.Hash != currentHash {
-		// Content changed - update stored hash
-		hs.Hashes[filePath] = SecretHash{
-			Path:         filePath,
-			Hash:         currentHash,
-			LastModified: fileInfo.ModTime(),
-		}
-		return true, nil
+	// Compare content, mode and ownership
+	if !previousHash.sameState(current) {
+		return true, hs.put(current)
+	}
+
+	// Record the descriptor for entries from older stores
+	if previousHash.Mode == "" {
+		hs.Hashes[filePath] = current
 	}
 
 	// No change detected
 	return false, nil
 }
 
+// PlanServiceActions returns the service actions a secret change would trigger,
+// without requiring systemctl, for dry-run previews
+func PlanServiceActions(cfg config.SystemdIntegration, secret config.Secret, secretName string) ([]ServiceAction, error) {
+	return (&Manager{config: cfg}).ExtractServiceActions(secret, secretName)
+}
+
+// DefaultUnitName is the unit the NixOS module runs opnix as
+const DefaultUnitName = "opnix-secrets.service"
+
+// opnixUnit returns the configured opnix unit that services are ordered after
+func (m *Manager) opnixUnit() string {
+	name := m.config.UnitName
+	if name == "" {
+		return DefaultUnitName
+	}
+	if !strings.Contains(name, ".") {
+		name += ".service"
+	}
+	return name
+}
+
 // ExtractServiceActions extracts service actions from secret configuration
 func (m *Manager) ExtractServiceActions(secret config.Secret, secretName string) ([]ServiceAction, error) {
 	if secret.Services == nil {
@@ -235,7 +465,7 @@ func (m *Manager) ExtractServiceActions(secret config.Secret, secretName string)
 				actions = append(actions, ServiceAction{
 					Name:    serviceName,
 					Restart: m.config.RestartOnChange,
-					After:   []string{"opnix-secrets.service"},
+					After:   []string{m.opnixUnit()},
 				})
 			}
 		}
@@ -246,7 +476,7 @@ func (m *Manager) ExtractServiceActions(secret config.Secret, secretName string)
 			action := ServiceAction{
 				Name:    serviceName,
 				Restart: m.config.RestartOnChange,
-				After:   []string{"opnix-secrets.service"},
+				After:   []string{m.opnixUnit()},
 			}
 
 			// Parse service configuration
@@ -318,7 +548,7 @@ func (m *Manager) ProcessSecretChanges(secrets []config.Secret, secretPaths map[
 			hasChanged, err = m.hashStore.hasChanged(secretPath)
 			if err != nil {
 				if m.config.ErrorHandling.ContinueOnError {
-					fmt.Fprintf(os.Stderr, "WARNING: Failed to check changes for %s: %v\n", secretName, err)
+					m.warnings.Addf("systemd service", "Failed to check changes for %s: %v", secretName, err)
 					continue
 				}
 				return err
@@ -332,7 +562,7 @@ func (m *Manager) ProcessSecretChanges(secrets []config.Secret, secretPaths map[
 			actions, err := m.ExtractServiceActions(secret, secretName)
 			if err != nil {
 				if m.config.ErrorHandling.ContinueOnError {
-					fmt.Fprintf(os.Stderr, "WARNING: Failed to extract service actions for %s: %v\n", secretName, err)
+					m.warnings.Addf("systemd service", "Failed to extract service actions for %s: %v", secretName, err)
 					continue
 				}
 				return err
@@ -345,21 +575,67 @@ func (m *Manager) ProcessSecretChanges(secrets []config.Secret, secretPaths map[
 	// Save hash store if we have changes and change detection is enabled
 	if len(changedSecrets) > 0 && m.config.ChangeDetection.Enable && m.hashStore != nil {
 		if err := m.hashStore.save(); err != nil {
-			fmt.Fprintf(os.Stderr, "WARNING: Failed to save hash store: %v\n", err)
+			m.warnings.Addf("systemd service", "Failed to save hash store: %v", err)
+		}
+	}
+
+	// Outside every maintenance window the files are written but the
+	// services are left alone until a later run inside one
+	if !m.inWindow() {
+		if len(allServiceActions) == 0 {
+			fmt.Printf("INFO: No secret changes detected, skipping service restarts\n")
+			return nil
+		}
+		return m.deferServiceActions(allServiceActions, changedSecrets)
+	}
+
+	var pending []PendingAction
+	if len(m.windows) > 0 && !m.dryRun {
+		var err error
+		if pending, err = m.takePending(); err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			fmt.Printf("INFO: Applying %d service actions deferred to this maintenance window\n", len(pending))
 		}
 	}
 
 	// Process service actions if we have changes
-	if len(allServiceActions) > 0 {
-		fmt.Printf("INFO: Processing %d changed secrets: %v\n", len(changedSecrets), changedSecrets)
-		return m.processServiceActions(allServiceActions)
+	if len(allServiceActions) > 0 || len(pending) > 0 {
+		if len(allServiceActions) > 0 {
+			fmt.Printf("INFO: Processing %d changed secrets: %v\n", len(changedSecrets), changedSecrets)
+		}
+		return m.runWithPending(allServiceActions, pending)
 	}
 
 	fmt.Printf("INFO: No secret changes detected, skipping service restarts\n")
 	return nil
 }
 
-// processServiceActions executes the required service actions
+// deferServiceActions records actions for the next maintenance window and
+// reports each one
+func (m *Manager) deferServiceActions(actions []ServiceAction, changedSecrets []string) error {
+	fmt.Printf("INFO: Secrets changed outside the %s\n", m.windowNote())
+	for _, action := range actions {
+		prefix := "INFO: Deferred"
+		if m.dryRun {
+			prefix = "DRY-RUN: Would defer"
+		}
+		fmt.Printf("%s %s until the next maintenance window\n", prefix, describeAction(action))
+	}
+	if m.dryRun {
+		return nil
+	}
+	if err := m.deferActions(actions, changedSecrets); err != nil {
+		return err
+	}
+	m.warnings.Addf("systemd service", "%d service actions deferred to the next maintenance window (%s); run 'opnix apply-pending' inside a window, or with -force, to apply them", len(actions), m.windowNote())
+	return nil
+}
+
+// processServiceActions executes the required service actions. Actions are
+// ordered by their After constraints; actions with no ordering relationship
+// run concurrently, up to the configured parallelism.
 func (m *Manager) processServiceActions(actions []ServiceAction) error {
 	// Group actions by service to avoid duplicate operations
 	serviceActions := make(map[string]ServiceAction)
@@ -374,75 +650,258 @@ func (m *Manager) processServiceActions(actions []ServiceAction) error {
 		}
 	}
 
-	// Execute actions with retry logic
+	pending, dependents, err := buildActionGraph(serviceActions)
+	if err != nil {
+		return err
+	}
+
+	parallelism := m.parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	names := sortedActionNames(serviceActions)
+	var ready []string
+	for _, name := range names {
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+	// Concurrent actions would interleave their lines
+	output := newOrderedOutput(os.Stdout, serialOrder(names, pending, dependents))
+	defer output.flush()
+
+	type actionResult struct {
+		name string
+		err  error
+	}
+	results := make(chan actionResult)
+
 	var failures []string
-	for serviceName, action := range serviceActions {
-		if err := m.executeServiceAction(action); err != nil {
-			failures = append(failures, fmt.Sprintf("%s: %v", serviceName, err))
+	var failedNames []string
+	running := 0
+	stopped := false
+
+	// Actions ordered after a failed one never run, even with continueOnError
+	skipped := make(map[string]bool)
+	var skipDependents func(name, failed string)
+	skipDependents = func(name, failed string) {
+		for _, dependent := range dependents[name] {
+			if skipped[dependent] {
+				continue
+			}
+			skipped[dependent] = true
+			output.finish(dependent)
+			failures = append(failures, fmt.Sprintf("%s: skipped, ordered after failed %s", dependent, failed))
+			skipDependents(dependent, failed)
+		}
+	}
+
+	for len(ready) > 0 || running > 0 {
+		// Start as many ready actions as the parallelism allows
+		for !stopped && running < parallelism && len(ready) > 0 {
+			action := serviceActions[ready[0]]
+			ready = ready[1:]
+			running++
+			go func(action ServiceAction, out io.Writer) {
+				results <- actionResult{name: action.Name, err: m.runServiceAction(action, out)}
+			}(action, output.start(action.Name))
+		}
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		output.finish(result.name)
 
+		if result.err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", result.name, result.err))
+			failedNames = append(failedNames, result.name)
+			// Let in-flight actions finish, but start nothing new
 			if !m.config.ErrorHandling.ContinueOnError {
-				return errors.ServiceError(
-					fmt.Sprintf("Executing service action for %s", serviceName),
-					serviceName,
-					"restart/reload",
-					err,
-				)
+				stopped = true
+			}
+			skipDependents(result.name, result.name)
+			continue
+		}
+
+		for _, dependent := range dependents[result.name] {
+			pending[dependent]--
+			if pending[dependent] == 0 && !skipped[dependent] {
+				ready = append(ready, dependent)
 			}
 		}
 	}
 
-	if len(failures) > 0 {
-		fmt.Fprintf(os.Stderr, "WARNING: Some service actions failed: %v\n", failures)
+	if len(failures) == 0 {
+		return nil
 	}
 
+	if !m.config.ErrorHandling.ContinueOnError {
+		return errors.ServiceError(
+			fmt.Sprintf("Executing service action for %s", strings.Join(failedNames, ", ")),
+			failedNames[0],
+			"restart/reload",
+			fmt.Errorf("%s", strings.Join(failures, "; ")),
+		)
+	}
+
+	m.warnings.Addf("systemd service", "Some service actions failed: %v", failures)
 	return nil
 }
 
+// SetWarnings collects warnings instead of printing them as they occur
+func (m *Manager) SetWarnings(collector *warnings.Collector) {
+	m.warnings = collector
+}
+
+// SetParallelism sets how many independent service actions may run at once
+func (m *Manager) SetParallelism(n int) {
+	m.parallelism = n
+}
+
+// buildActionGraph returns, for each action, the number of other actions it
+// must wait for and the actions waiting on it. After entries naming units that
+// have no action here (e.g. opnix-secrets.service) impose no ordering.
+func buildActionGraph(serviceActions map[string]ServiceAction) (map[string]int, map[string][]string, error) {
+	byUnit := make(map[string]string, len(serviceActions))
+	for name := range serviceActions {
+		byUnit[unitName(name)] = name
+	}
+
+	pending := make(map[string]int, len(serviceActions))
+	dependents := make(map[string][]string)
+	for _, name := range sortedActionNames(serviceActions) {
+		pending[name] = 0
+		seen := make(map[string]bool)
+		for _, after := range serviceActions[name].After {
+			dep, exists := byUnit[unitName(after)]
+			if !exists || dep == name || seen[dep] {
+				continue
+			}
+			seen[dep] = true
+			pending[name]++
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+
+	// Reject cycles up front rather than deadlocking mid-deploy
+	remaining := make(map[string]int, len(pending))
+	var queue []string
+	for name, count := range pending {
+		remaining[name] = count
+		if count == 0 {
+			queue = append(queue, name)
+		}
+	}
+	visited := 0
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+		visited++
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	if visited < len(pending) {
+		var cyclic []string
+		for name, count := range remaining {
+			if count > 0 {
+				cyclic = append(cyclic, name)
+			}
+		}
+		sort.Strings(cyclic)
+		return nil, nil, errors.ConfigError(
+			"Ordering service actions",
+			fmt.Sprintf("Services have circular 'after' dependencies: %s", strings.Join(cyclic, ", ")),
+			nil,
+		)
+	}
+
+	return pending, dependents, nil
+}
+
+// unitName normalizes a service name so "caddy" and "caddy.service" match
+func unitName(name string) string {
+	return strings.TrimSuffix(name, ".service")
+}
+
+func sortedActionNames(serviceActions map[string]ServiceAction) []string {
+	names := make([]string, 0, len(serviceActions))
+	for name := range serviceActions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // executeServiceAction executes a single service action with retry logic
 func (m *Manager) executeServiceAction(action ServiceAction) error {
+	return m.runServiceAction(action, os.Stdout)
+}
+
+// runServiceAction executes a service action, printing its progress to out
+func (m *Manager) runServiceAction(action ServiceAction, out io.Writer) error {
 	var cmd string
 	var args []string
 
 	if action.Signal != "" {
-		// Send custom signal
-		cmd = "kill"
-		args = []string{"-" + action.Signal, fmt.Sprintf("$(systemctl show -p MainPID --value %s)", action.Name)}
-		fmt.Printf("INFO: Sending %s signal to service %s\n", action.Signal, action.Name)
+		// Send custom signal to the main process; no shell is involved, so let
+		// systemd look up the PID
+		cmd = m.systemctl
+		args = []string{"kill", "--kill-whom=main", "--signal=" + action.Signal, action.Name}
+		fmt.Fprintf(out, "INFO: Sending %s signal to service %s\n", action.Signal, action.Name)
 	} else if action.Restart {
 		// Restart service
 		cmd = m.systemctl
 		args = []string{"restart", action.Name}
-		fmt.Printf("INFO: Restarting service %s\n", action.Name)
+		fmt.Fprintf(out, "INFO: Restarting service %s\n", action.Name)
 	} else {
 		// Reload service
 		cmd = m.systemctl
 		args = []string{"reload", action.Name}
-		fmt.Printf("INFO: Reloading service %s\n", action.Name)
+		fmt.Fprintf(out, "INFO: Reloading service %s\n", action.Name)
+	}
+
+	// Execute with retry logic; always make at least one attempt
+	attempts := m.config.ErrorHandling.MaxRetries
+	if attempts < 1 {
+		attempts = 1
 	}
 
-	// Execute with retry logic
 	var lastErr error
-	for attempt := 0; attempt < m.config.ErrorHandling.MaxRetries; attempt++ {
+	for attempt := 0; attempt < attempts; attempt++ {
 		if attempt > 0 {
-			fmt.Printf("INFO: Retrying service action for %s (attempt %d/%d)\n",
-				action.Name, attempt+1, m.config.ErrorHandling.MaxRetries)
+			fmt.Fprintf(out, "INFO: Retrying service action for %s (attempt %d/%d)\n",
+				action.Name, attempt+1, attempts)
 			time.Sleep(time.Duration(attempt) * time.Second)
 		}
 
 		if m.dryRun {
-			fmt.Printf("DRY-RUN: Would execute: %s %s\n", cmd, strings.Join(args, " "))
+			fmt.Fprintf(out, "DRY-RUN: Would execute: %s %s\n", cmd, strings.Join(args, " "))
 			return nil
 		}
 
-		execCmd := exec.Command(cmd, args...)
-		output, err := execCmd.CombinedOutput()
+		output, err := m.runner.Run(cmd, args...)
 		if err != nil {
+			// A masked or missing unit fails the same way every time, so
+			// explain that instead of retrying
+			if attempt == 0 {
+				if loadErr := m.checkLoadState("Executing service action", action.Name); loadErr != nil {
+					return loadErr
+				}
+			}
 			lastErr = fmt.Errorf("command failed: %v, output: %s", err, string(output))
 			continue
 		}
 
 		// Success
-		fmt.Printf("INFO: Successfully executed service action for %s\n", action.Name)
+		fmt.Fprintf(out, "INFO: Successfully executed service action for %s\n", action.Name)
 		return nil
 	}
 
@@ -456,15 +915,13 @@ func (m *Manager) SetDryRun(dryRun bool) {
 
 // IsServiceRunning checks if a systemd service is currently running
 func (m *Manager) IsServiceRunning(serviceName string) (bool, error) {
-	cmd := exec.Command(m.systemctl, "is-active", "--quiet", serviceName)
-	err := cmd.Run()
-
+	_, err := m.runner.Run(m.systemctl, "is-active", "--quiet", serviceName)
 	if err == nil {
 		return true, nil
 	}
 
 	// Check if it's an exit status error (service not running) vs other error
-	if exitError, ok := err.(*exec.ExitError); ok {
+	if exitError, ok := err.(interface{ ExitCode() int }); ok {
 		// systemctl is-active returns exit code 3 for inactive services
 		if exitError.ExitCode() == 3 {
 			return false, nil
@@ -484,8 +941,7 @@ func (m *Manager) IsServiceRunning(serviceName string) (bool, error) {
 func (m *Manager) ValidateServices(services []string) error {
 	for _, serviceName := range services {
 		// Check if service unit exists
-		cmd := exec.Command(m.systemctl, "cat", serviceName)
-		if err := cmd.Run(); err != nil {
+		if _, err := m.runner.Run(m.systemctl, "cat", serviceName); err != nil {
 			return errors.ServiceError(
 				"Validating service configuration",
 				serviceName,
@@ -493,7 +949,27 @@ func (m *Manager) ValidateServices(services []string) error {
 				fmt.Errorf("service unit not found or not accessible"),
 			)
 		}
+
+		// systemctl cat succeeds for masked units, which can never be restarted
+		if err := m.checkLoadState("Validating service configuration", serviceName); err != nil {
+			return err
+		}
 	}
 
 	return nil
 }
+
+// checkLoadState returns an error when systemd reports the unit as masked or
+// not found. Any other state, or failing to query it, is left to the caller.
+func (m *Manager) checkLoadState(operation, serviceName string) error {
+	output, err := m.runner.Run(m.systemctl, "show", "--property=LoadState", "--value", serviceName)
+	if err != nil {
+		return nil
+	}
+
+	switch state := strings.TrimSpace(string(output)); state {
+	case "masked", "not-found":
+		return errors.ServiceError(operation, serviceName, state, fmt.Errorf("systemd reports LoadState=%s", state))
+	}
+	return nil
+}
@@ -2,12 +2,16 @@ package systemd
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
 // mockSystemdIntegration creates a test systemd integration config
@@ -52,6 +56,33 @@ func TestNewManager(t *testing.T) {
 	}
 }
 
+func TestNewManagerSystemctlPath(t *testing.T) {
+	tmpDir := t.TempDir()
+	systemctl := filepath.Join(tmpDir, "systemctl")
+	if err := os.WriteFile(systemctl, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("Failed to write systemctl: %v", err)
+	}
+	notExecutable := filepath.Join(tmpDir, "not-executable")
+	if err := os.WriteFile(notExecutable, []byte("#!/bin/sh\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	cfg := config.SystemdIntegration{Systemctl: systemctl}
+	manager, err := NewManager(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	if manager.systemctl != systemctl {
+		t.Errorf("Expected the pinned systemctl %s, got %s", systemctl, manager.systemctl)
+	}
+
+	for _, path := range []string{"bin/systemctl", filepath.Join(tmpDir, "missing"), notExecutable, tmpDir} {
+		if _, err := NewManager(config.SystemdIntegration{Systemctl: path}); err == nil {
+			t.Errorf("Expected systemctl %s to be rejected", path)
+		}
+	}
+}
+
 func TestHashStore(t *testing.T) {
 	tempDir := t.TempDir()
 	hashFile := filepath.Join(tempDir, "test-hashes.json")
@@ -152,6 +183,36 @@ func TestHashStoreChangeDetection(t *testing.T) {
 	if !changed {
 		t.Error("Expected change to be detected for modified content")
 	}
+
+	// Same content with a new mode is a change too
+	if err := os.Chmod(testFile, 0640); err != nil {
+		t.Fatalf("Failed to change mode: %v", err)
+	}
+	changed, err = store.hasChanged(testFile)
+	if err != nil {
+		t.Fatalf("Failed to check for changes: %v", err)
+	}
+	if !changed {
+		t.Error("Expected change to be detected for modified mode")
+	}
+	if store.Hashes[testFile].Mode != "0640" {
+		t.Errorf("Expected stored mode 0640, got %s", store.Hashes[testFile].Mode)
+	}
+
+	// Entries from older stores have no descriptor and compare by content only
+	legacy := store.Hashes[testFile]
+	legacy.Mode = ""
+	store.Hashes[testFile] = legacy
+	changed, err = store.hasChanged(testFile)
+	if err != nil {
+		t.Fatalf("Failed to check for changes: %v", err)
+	}
+	if changed {
+		t.Error("Expected no change for an entry without a recorded descriptor")
+	}
+	if store.Hashes[testFile].Mode == "" {
+		t.Error("Expected the descriptor to be recorded for the legacy entry")
+	}
 }
 
 func TestExtractServiceActions(t *testing.T) {
@@ -254,6 +315,51 @@ func TestExtractServiceActions(t *testing.T) {
 	}
 }
 
+func TestExtractServiceActionsUnitName(t *testing.T) {
+	secret := config.Secret{
+		Path:      "test/secret",
+		Reference: "op://vault/item/field",
+		Services: map[string]interface{}{
+			"caddy":    map[string]interface{}{"restart": true},
+			"postgres": map[string]interface{}{"after": []interface{}{"network.target"}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		unitName string
+		want     string
+	}{
+		{name: "default", unitName: "", want: DefaultUnitName},
+		{name: "configured", unitName: "opnix-system.service", want: "opnix-system.service"},
+		{name: "suffix added", unitName: "onepassword-secrets", want: "onepassword-secrets.service"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := mockSystemdIntegration()
+			cfg.UnitName = tt.unitName
+			actions, err := (&Manager{config: cfg}).ExtractServiceActions(secret, "test-secret")
+			if err != nil {
+				t.Fatalf("Failed to extract service actions: %v", err)
+			}
+
+			for _, action := range actions {
+				if action.Name == "postgres" {
+					// Explicit after lists replace the default
+					if len(action.After) != 1 || action.After[0] != "network.target" {
+						t.Errorf("Expected explicit after to be kept, got %v", action.After)
+					}
+					continue
+				}
+				if len(action.After) != 1 || action.After[0] != tt.want {
+					t.Errorf("Expected After=[%s], got %v", tt.want, action.After)
+				}
+			}
+		})
+	}
+}
+
 func TestServiceActionConfiguration(t *testing.T) {
 	cfg := mockSystemdIntegration()
 	manager := &Manager{config: cfg}
@@ -439,6 +545,40 @@ func TestHashStoreFileOperations(t *testing.T) {
 	if len(parsed.Hashes) != 1 {
 		t.Errorf("Expected 1 hash in file, got %d", len(parsed.Hashes))
 	}
+
+	info, err := os.Stat(hashFile)
+	if err != nil {
+		t.Fatalf("Failed to stat hash file: %v", err)
+	}
+	if info.Mode().Perm() != DefaultHashFileMode {
+		t.Errorf("Expected hash file mode %04o, got %04o", DefaultHashFileMode, info.Mode().Perm())
+	}
+}
+
+func TestHashStoreFileMode(t *testing.T) {
+	hashFile := filepath.Join(t.TempDir(), "hashes.json")
+	if err := os.WriteFile(hashFile, []byte(`{"hashes": {}}`), 0666); err != nil {
+		t.Fatalf("Failed to write hash file: %v", err)
+	}
+	if err := os.Chmod(hashFile, 0666); err != nil {
+		t.Fatalf("Failed to chmod hash file: %v", err)
+	}
+
+	// An existing file is tightened on load and kept at the mode on save
+	store, err := NewHashStoreWithMode(hashFile, 0640)
+	if err != nil {
+		t.Fatalf("Failed to create hash store: %v", err)
+	}
+	if info, _ := os.Stat(hashFile); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected existing hash file to be set to 0640, got %04o", info.Mode().Perm())
+	}
+
+	if err := store.save(); err != nil {
+		t.Fatalf("Failed to save hash store: %v", err)
+	}
+	if info, _ := os.Stat(hashFile); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected saved hash file mode 0640, got %04o", info.Mode().Perm())
+	}
 }
 
 func TestCalculateHash(t *testing.T) {
@@ -491,3 +631,334 @@ func TestCalculateHash(t *testing.T) {
 		t.Error("Expected different hash after file modification")
 	}
 }
+
+// fakeExitError mimics *exec.ExitError for the fake runner
+type fakeExitError struct{ code int }
+
+func (e *fakeExitError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+func (e *fakeExitError) ExitCode() int { return e.code }
+
+// fakeSystemctl records every command instead of talking to systemd
+type fakeSystemctl struct {
+	mu       sync.Mutex
+	commands []string
+	// inputs holds the stdin of each RunWithInput
+	inputs []string
+	// failing units exit 1 for every command
+	failing map[string]bool
+	// inactive units exit 3 for is-active
+	inactive map[string]bool
+	// masked units fail every action and report LoadState=masked
+	masked map[string]bool
+	delay  time.Duration
+
+	running    int
+	maxRunning int
+}
+
+func (f *fakeSystemctl) Run(name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	f.commands = append(f.commands, name+" "+strings.Join(args, " "))
+	f.running++
+	if f.running > f.maxRunning {
+		f.maxRunning = f.running
+	}
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running--
+
+	unit := args[len(args)-1]
+	if f.masked[unit] {
+		switch args[0] {
+		case "show":
+			return []byte("masked\n"), nil
+		case "cat":
+			return []byte("# /etc/systemd/system/" + unit + ".service\n"), nil
+		}
+		return []byte("Failed to restart " + unit + ".service: Unit " + unit + ".service is masked."), &fakeExitError{code: 1}
+	}
+	if f.failing[unit] {
+		return []byte("Job for " + unit + " failed"), &fakeExitError{code: 1}
+	}
+	if args[0] == "is-active" && f.inactive[unit] {
+		return nil, &fakeExitError{code: 3}
+	}
+	return nil, nil
+}
+
+// RunWithInput stands in for systemd-creds, recording the command and the
+// plaintext it was given; credentials named "failing" are refused
+func (f *fakeSystemctl) RunWithInput(input []byte, name string, args ...string) ([]byte, []byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands = append(f.commands, name+" "+strings.Join(args, " "))
+	f.inputs = append(f.inputs, string(input))
+	if f.failing[strings.TrimPrefix(args[1], "--name=")] {
+		return nil, []byte("Failed to determine local credential key"), &fakeExitError{code: 1}
+	}
+	return []byte("encrypted:" + string(input)), nil, nil
+}
+
+func newFakeManager(t *testing.T, fake *fakeSystemctl, continueOnError bool, parallelism int) *Manager {
+	t.Helper()
+	manager, err := NewManagerWithRunner(config.SystemdIntegration{
+		Enable:           true,
+		ParallelServices: parallelism,
+		ErrorHandling: config.ErrorHandling{
+			ContinueOnError: continueOnError,
+			MaxRetries:      1,
+		},
+	}, fake)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	return manager
+}
+
+func TestExecuteServiceActionCommands(t *testing.T) {
+	fake := &fakeSystemctl{}
+	manager := newFakeManager(t, fake, true, 1)
+
+	actions := []ServiceAction{
+		{Name: "caddy", Restart: true},
+		{Name: "nginx", Restart: false},
+		{Name: "haproxy", Signal: "SIGHUP"},
+	}
+	for _, action := range actions {
+		if err := manager.executeServiceAction(action); err != nil {
+			t.Fatalf("executeServiceAction(%s) failed: %v", action.Name, err)
+		}
+	}
+
+	expected := []string{
+		"systemctl restart caddy",
+		"systemctl reload nginx",
+		"systemctl kill --kill-whom=main --signal=SIGHUP haproxy",
+	}
+	if strings.Join(fake.commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected commands %v, got %v", expected, fake.commands)
+	}
+}
+
+func TestProcessServiceActionsOrdering(t *testing.T) {
+	fake := &fakeSystemctl{delay: 20 * time.Millisecond}
+	manager := newFakeManager(t, fake, true, 4)
+
+	actions := []ServiceAction{
+		{Name: "postgresql", Restart: true, After: []string{"opnix-secrets.service"}},
+		{Name: "app", Restart: true, After: []string{"postgresql.service"}},
+		{Name: "caddy", Restart: true},
+		{Name: "redis", Restart: true},
+	}
+
+	if err := manager.processServiceActions(actions); err != nil {
+		t.Fatalf("processServiceActions failed: %v", err)
+	}
+
+	if len(fake.commands) != 4 {
+		t.Fatalf("Expected 4 commands, got %v", fake.commands)
+	}
+
+	position := make(map[string]int)
+	for i, command := range fake.commands {
+		position[command] = i
+	}
+	if position["systemctl restart app"] < position["systemctl restart postgresql"] {
+		t.Errorf("Expected app to restart after postgresql, got %v", fake.commands)
+	}
+
+	if fake.maxRunning < 2 {
+		t.Errorf("Expected independent actions to run concurrently, max concurrency was %d", fake.maxRunning)
+	}
+}
+
+func TestProcessServiceActionsSequentialByDefault(t *testing.T) {
+	fake := &fakeSystemctl{delay: 5 * time.Millisecond}
+	manager := newFakeManager(t, fake, true, 0)
+
+	err := manager.processServiceActions([]ServiceAction{
+		{Name: "b", Restart: true},
+		{Name: "a", Restart: true},
+		{Name: "c", Restart: false},
+		{Name: "a", Restart: false},
+	})
+	if err != nil {
+		t.Fatalf("processServiceActions failed: %v", err)
+	}
+
+	// Duplicates collapse (restart wins) and independent actions run in name order
+	expected := []string{"systemctl restart a", "systemctl restart b", "systemctl reload c"}
+	if strings.Join(fake.commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected commands %v, got %v", expected, fake.commands)
+	}
+	if fake.maxRunning != 1 {
+		t.Errorf("Expected sequential execution, max concurrency was %d", fake.maxRunning)
+	}
+}
+
+func TestProcessServiceActionsFailures(t *testing.T) {
+	t.Run("aggregates failures", func(t *testing.T) {
+		fake := &fakeSystemctl{failing: map[string]bool{"broken": true, "flaky": true}}
+		manager := newFakeManager(t, fake, false, 2)
+
+		err := manager.processServiceActions([]ServiceAction{
+			{Name: "broken", Restart: true},
+			{Name: "flaky", Restart: true},
+		})
+		if err == nil {
+			t.Fatal("Expected error from failing actions")
+		}
+		if !strings.Contains(err.Error(), "broken") || !strings.Contains(err.Error(), "flaky") {
+			t.Errorf("Expected both failures in error, got: %v", err)
+		}
+	})
+
+	t.Run("stops scheduling after failure", func(t *testing.T) {
+		fake := &fakeSystemctl{failing: map[string]bool{"db": true}}
+		manager := newFakeManager(t, fake, false, 1)
+
+		err := manager.processServiceActions([]ServiceAction{
+			{Name: "db", Restart: true},
+			{Name: "app", Restart: true, After: []string{"db.service"}},
+		})
+		if err == nil {
+			t.Fatal("Expected error from failing action")
+		}
+		for _, command := range fake.commands {
+			if command == "systemctl restart app" {
+				t.Errorf("Expected dependent action not to run after failure, got %v", fake.commands)
+			}
+		}
+	})
+
+	t.Run("continue on error", func(t *testing.T) {
+		fake := &fakeSystemctl{failing: map[string]bool{"broken": true}}
+		manager := newFakeManager(t, fake, true, 1)
+		collector := warnings.NewCollector()
+		manager.SetWarnings(collector)
+
+		err := manager.processServiceActions([]ServiceAction{
+			{Name: "broken", Restart: true},
+			{Name: "caddy", Restart: true},
+		})
+		if err != nil {
+			t.Errorf("Expected failures to be reported as warnings, got: %v", err)
+		}
+		restarts := 0
+		for _, command := range fake.commands {
+			if strings.HasPrefix(command, "systemctl restart ") {
+				restarts++
+			}
+		}
+		if restarts != 2 {
+			t.Errorf("Expected both actions to run, got %v", fake.commands)
+		}
+		if list := collector.List(); len(list) != 1 || list[0].Component != "systemd service" || !strings.Contains(list[0].Message, "broken") {
+			t.Errorf("Expected the failure to be collected as a warning, got %+v", list)
+		}
+	})
+
+	t.Run("continue on error skips dependents", func(t *testing.T) {
+		fake := &fakeSystemctl{failing: map[string]bool{"db": true}}
+		manager := newFakeManager(t, fake, true, 2)
+		collector := warnings.NewCollector()
+		manager.SetWarnings(collector)
+
+		err := manager.processServiceActions([]ServiceAction{
+			{Name: "db", Restart: true},
+			{Name: "app", Restart: true, After: []string{"db.service"}},
+			{Name: "worker", Restart: true, After: []string{"app.service"}},
+			{Name: "caddy", Restart: true},
+		})
+		if err != nil {
+			t.Errorf("Expected failures to be reported as warnings, got: %v", err)
+		}
+		for _, command := range fake.commands {
+			if command == "systemctl restart app" || command == "systemctl restart worker" {
+				t.Errorf("Expected actions ordered after the failed one not to run, got %v", fake.commands)
+			}
+		}
+		if !strings.Contains(strings.Join(fake.commands, "\n"), "systemctl restart caddy") {
+			t.Errorf("Expected the unrelated action to run, got %v", fake.commands)
+		}
+		list := collector.List()
+		if len(list) != 1 {
+			t.Fatalf("Expected one aggregated warning, got %+v", list)
+		}
+		for _, want := range []string{"app: skipped, ordered after failed db", "worker: skipped, ordered after failed db"} {
+			if !strings.Contains(list[0].Message, want) {
+				t.Errorf("Expected the warning to report %q, got %q", want, list[0].Message)
+			}
+		}
+	})
+
+	t.Run("cycle rejected", func(t *testing.T) {
+		fake := &fakeSystemctl{}
+		manager := newFakeManager(t, fake, true, 1)
+
+		err := manager.processServiceActions([]ServiceAction{
+			{Name: "a", After: []string{"b.service"}},
+			{Name: "b", After: []string{"a"}},
+		})
+		if err == nil || !strings.Contains(err.Error(), "circular") {
+			t.Errorf("Expected circular dependency error, got: %v", err)
+		}
+		if len(fake.commands) != 0 {
+			t.Errorf("Expected no commands for cyclic actions, got %v", fake.commands)
+		}
+	})
+}
+
+func TestServiceQueries(t *testing.T) {
+	fake := &fakeSystemctl{
+		inactive: map[string]bool{"stopped": true},
+		failing:  map[string]bool{"missing": true},
+	}
+	manager := newFakeManager(t, fake, true, 1)
+
+	if running, err := manager.IsServiceRunning("caddy"); err != nil || !running {
+		t.Errorf("Expected caddy to be running, got %v (err: %v)", running, err)
+	}
+	if running, err := manager.IsServiceRunning("stopped"); err != nil || running {
+		t.Errorf("Expected stopped to be inactive, got %v (err: %v)", running, err)
+	}
+	if _, err := manager.IsServiceRunning("missing"); err == nil {
+		t.Error("Expected error for failing is-active")
+	}
+
+	if err := manager.ValidateServices([]string{"caddy", "stopped"}); err != nil {
+		t.Errorf("Expected services to validate, got: %v", err)
+	}
+	if err := manager.ValidateServices([]string{"caddy", "missing"}); err == nil {
+		t.Error("Expected error for missing service unit")
+	}
+
+	if fake.commands[0] != "systemctl is-active --quiet caddy" {
+		t.Errorf("Unexpected is-active command: %s", fake.commands[0])
+	}
+}
+
+func TestMaskedServices(t *testing.T) {
+	fake := &fakeSystemctl{masked: map[string]bool{"legacy": true}}
+	manager := newFakeManager(t, fake, false, 1)
+	manager.config.ErrorHandling.MaxRetries = 3
+
+	err := manager.ValidateServices([]string{"caddy", "legacy"})
+	if err == nil || !strings.Contains(err.Error(), "is masked") || !strings.Contains(err.Error(), "systemctl unmask legacy") {
+		t.Errorf("Expected a masked unit error from validation, got: %v", err)
+	}
+
+	fake.commands = nil
+	err = manager.executeServiceAction(ServiceAction{Name: "legacy", Restart: true})
+	if err == nil || !strings.Contains(err.Error(), "is masked") {
+		t.Errorf("Expected a masked unit error from restart, got: %v", err)
+	}
+	expected := []string{"systemctl restart legacy", "systemctl show --property=LoadState --value legacy"}
+	if strings.Join(fake.commands, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected no retries for a masked unit, got %v", fake.commands)
+	}
+}
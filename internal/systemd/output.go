@@ -0,0 +1,92 @@
+package systemd
+
+import (
+	"bytes"
+	"io"
+)
+
+// orderedOutput buffers what concurrent service actions print and releases
+// each action's lines as one block, in the order a serial run would have
+// run them. Parallel restarts then log the same way from run to run, and
+// with a parallelism of 1 every block is released as soon as it finishes.
+//
+// Only the goroutine running an action writes to its buffer, and the
+// coordinator calls finish after receiving that action's result, so no
+// locking is needed.
+type orderedOutput struct {
+	w       io.Writer
+	order   []string
+	buffers map[string]*bytes.Buffer
+	done    map[string]bool
+	next    int
+}
+
+func newOrderedOutput(w io.Writer, order []string) *orderedOutput {
+	return &orderedOutput{
+		w:       w,
+		order:   order,
+		buffers: make(map[string]*bytes.Buffer, len(order)),
+		done:    make(map[string]bool, len(order)),
+	}
+}
+
+// start returns the writer for an action's output
+func (o *orderedOutput) start(name string) io.Writer {
+	buf := &bytes.Buffer{}
+	o.buffers[name] = buf
+	return buf
+}
+
+// finish marks an action done and releases every block whose turn has come
+func (o *orderedOutput) finish(name string) {
+	o.done[name] = true
+	for o.next < len(o.order) && o.done[o.order[o.next]] {
+		o.release(o.order[o.next])
+		o.next++
+	}
+}
+
+// flush releases the remaining finished blocks, in order, for actions
+// that ran although one before them never started
+func (o *orderedOutput) flush() {
+	for ; o.next < len(o.order); o.next++ {
+		if o.done[o.order[o.next]] {
+			o.release(o.order[o.next])
+		}
+	}
+}
+
+func (o *orderedOutput) release(name string) {
+	if buf, ok := o.buffers[name]; ok {
+		_, _ = o.w.Write(buf.Bytes())
+		delete(o.buffers, name)
+	}
+}
+
+// serialOrder is the order processServiceActions starts actions in with a
+// parallelism of 1: ready actions by name, then their dependents as they
+// become ready
+func serialOrder(names []string, pending map[string]int, dependents map[string][]string) []string {
+	remaining := make(map[string]int, len(pending))
+	var ready []string
+	for _, name := range names {
+		remaining[name] = pending[name]
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	order := make([]string, 0, len(names))
+	for len(ready) > 0 {
+		name := ready[0]
+		ready = ready[1:]
+		order = append(order, name)
+		for _, dependent := range dependents[name] {
+			remaining[dependent]--
+			if remaining[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	return order
+}
@@ -0,0 +1,62 @@
+package systemd
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestOrderedOutput(t *testing.T) {
+	actions := map[string]ServiceAction{
+		"postgresql": {Name: "postgresql", Restart: true},
+		"app":        {Name: "app", Restart: true, After: []string{"postgresql.service"}},
+		"caddy":      {Name: "caddy", Restart: true},
+	}
+	pending, dependents, err := buildActionGraph(actions)
+	if err != nil {
+		t.Fatalf("buildActionGraph failed: %v", err)
+	}
+	order := serialOrder(sortedActionNames(actions), pending, dependents)
+	if fmt.Sprint(order) != "[caddy postgresql app]" {
+		t.Fatalf("Expected serial order [caddy postgresql app], got %v", order)
+	}
+
+	var out bytes.Buffer
+	output := newOrderedOutput(&out, order)
+	writers := make(map[string]*bytes.Buffer)
+	for _, name := range order {
+		writers[name] = output.start(name).(*bytes.Buffer)
+	}
+
+	// Finish out of order, writing in interleaved pieces
+	fmt.Fprintf(writers["postgresql"], "INFO: Restarting service postgresql\n")
+	fmt.Fprintf(writers["caddy"], "INFO: Restarting service caddy\n")
+	fmt.Fprintf(writers["postgresql"], "INFO: Successfully executed service action for postgresql\n")
+	output.finish("postgresql")
+	if out.Len() != 0 {
+		t.Errorf("Expected postgresql to wait for caddy, got:\n%s", out.String())
+	}
+	fmt.Fprintf(writers["app"], "INFO: Restarting service app\n")
+	output.finish("app")
+	fmt.Fprintf(writers["caddy"], "INFO: Successfully executed service action for caddy\n")
+	output.finish("caddy")
+
+	expected := "INFO: Restarting service caddy\n" +
+		"INFO: Successfully executed service action for caddy\n" +
+		"INFO: Restarting service postgresql\n" +
+		"INFO: Successfully executed service action for postgresql\n" +
+		"INFO: Restarting service app\n"
+	if out.String() != expected {
+		t.Errorf("Expected blocks in serial order:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	// Blocks behind an action that never started are released by flush
+	out.Reset()
+	output = newOrderedOutput(&out, order)
+	fmt.Fprintf(output.start("postgresql"), "INFO: Restarting service postgresql\n")
+	output.finish("postgresql")
+	output.flush()
+	if out.String() != "INFO: Restarting service postgresql\n" {
+		t.Errorf("Expected flush to release the finished block, got:\n%s", out.String())
+	}
+}
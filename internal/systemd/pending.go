@@ -0,0 +1,276 @@
+package systemd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/lock"
+	"github.com/brizzbuzz/opnix/internal/schedule"
+)
+
+// Service actions triggered outside every maintenance window are recorded in
+// the pending file instead of run. The next run inside a window, or
+// `opnix apply-pending`, runs them along with its own.
+
+// DefaultPendingFile records deferred service actions when pendingFile is unset
+const DefaultPendingFile = "/var/lib/opnix/pending-services.json"
+
+// PendingAction is a service action deferred to a maintenance window
+type PendingAction struct {
+	Name    string   `json:"name"`
+	Restart bool     `json:"restart"`
+	Signal  string   `json:"signal,omitempty"`
+	After   []string `json:"after,omitempty"`
+	// Secrets whose changes triggered the action
+	Secrets []string `json:"secrets"`
+	// When the action was first deferred
+	Since time.Time `json:"since"`
+}
+
+// pendingFile returns the configured pending file
+func (m *Manager) pendingFile() string {
+	if m.config.PendingFile != "" {
+		return m.config.PendingFile
+	}
+	return DefaultPendingFile
+}
+
+// inWindow reports whether service actions may run now
+func (m *Manager) inWindow() bool {
+	return schedule.Open(m.windows, m.now())
+}
+
+// windowNote describes the configured windows and when the next one opens
+func (m *Manager) windowNote() string {
+	specs := make([]string, len(m.windows))
+	for i, w := range m.windows {
+		specs[i] = w.String()
+	}
+	note := fmt.Sprintf("maintenance windows: %s", strings.Join(specs, ", "))
+	if next, ok := schedule.NextOpen(m.windows, m.now()); ok {
+		note += fmt.Sprintf("; next opens %s", next.Format("Mon 2006-01-02 15:04 MST"))
+	}
+	return note
+}
+
+// PendingActions returns the service actions waiting for a maintenance window
+func (m *Manager) PendingActions() ([]PendingAction, error) {
+	held, err := lock.Acquire(m.pendingFile()+".lock", lock.ModeWait, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = held.Release() }()
+	return m.readPending()
+}
+
+// readPending reads the pending file; a missing file means nothing is pending
+func (m *Manager) readPending() ([]PendingAction, error) {
+	data, err := os.ReadFile(m.pendingFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.FileOperationError(
+			"Loading deferred service actions",
+			m.pendingFile(),
+			"Failed to read pending file",
+			err,
+		)
+	}
+	var stored struct {
+		Actions []PendingAction `json:"actions"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, errors.ConfigError(
+			"Loading deferred service actions",
+			"Invalid JSON format in pending file",
+			err,
+		)
+	}
+	return stored.Actions, nil
+}
+
+// writePending replaces the pending file, removing it when nothing is pending
+func (m *Manager) writePending(actions []PendingAction) error {
+	if len(actions) == 0 {
+		if err := os.Remove(m.pendingFile()); err != nil && !os.IsNotExist(err) {
+			return errors.FileOperationError(
+				"Clearing deferred service actions",
+				m.pendingFile(),
+				"Failed to remove pending file",
+				err,
+			)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Actions []PendingAction `json:"actions"`
+	}{actions}, "", "  ")
+	if err != nil {
+		return errors.ConfigError("Saving deferred service actions", "Failed to marshal pending actions", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.pendingFile()), 0755); err != nil {
+		return errors.FileOperationError(
+			"Saving deferred service actions",
+			filepath.Dir(m.pendingFile()),
+			"Failed to create directory for pending file",
+			err,
+		)
+	}
+	return writeAtomic("Saving deferred service actions", m.pendingFile(), 0600, data)
+}
+
+// deferActions records actions triggered by secrets for the next window
+func (m *Manager) deferActions(actions []ServiceAction, secrets []string) error {
+	added := make([]PendingAction, len(actions))
+	for i, action := range actions {
+		added[i] = PendingAction{
+			Name:    action.Name,
+			Restart: action.Restart,
+			Signal:  action.Signal,
+			After:   action.After,
+			Secrets: secrets,
+			Since:   m.now(),
+		}
+	}
+	return m.storePending(added)
+}
+
+// storePending adds actions to the pending file, merging them with actions
+// already waiting for the same service
+func (m *Manager) storePending(added []PendingAction) error {
+	held, err := lock.Acquire(m.pendingFile()+".lock", lock.ModeWait, 0)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = held.Release() }()
+
+	pending, err := m.readPending()
+	if err != nil {
+		return err
+	}
+	return m.writePending(mergePending(pending, added))
+}
+
+// takePending removes and returns every deferred action
+func (m *Manager) takePending() ([]PendingAction, error) {
+	held, err := lock.Acquire(m.pendingFile()+".lock", lock.ModeWait, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = held.Release() }()
+
+	pending, err := m.readPending()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	return pending, m.writePending(nil)
+}
+
+// mergePending adds actions to pending, one entry per service: a restart
+// wins over a reload, as in processServiceActions, the triggering secrets are
+// combined and the earliest deferral is kept
+func mergePending(pending, added []PendingAction) []PendingAction {
+	byName := make(map[string]int, len(pending))
+	for i, action := range pending {
+		byName[action.Name] = i
+	}
+	for _, action := range added {
+		i, exists := byName[action.Name]
+		if !exists {
+			byName[action.Name] = len(pending)
+			action.Secrets = append([]string(nil), action.Secrets...)
+			pending = append(pending, action)
+			continue
+		}
+		existing := &pending[i]
+		if action.Restart && !existing.Restart {
+			existing.Restart, existing.Signal, existing.After = true, action.Signal, action.After
+		}
+		if action.Since.Before(existing.Since) {
+			existing.Since = action.Since
+		}
+	secrets:
+		for _, secret := range action.Secrets {
+			for _, known := range existing.Secrets {
+				if known == secret {
+					continue secrets
+				}
+			}
+			existing.Secrets = append(existing.Secrets, secret)
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].Name < pending[j].Name })
+	return pending
+}
+
+// pendingServiceActions turns deferred actions back into service actions
+func pendingServiceActions(pending []PendingAction) []ServiceAction {
+	actions := make([]ServiceAction, len(pending))
+	for i, action := range pending {
+		actions[i] = ServiceAction{Name: action.Name, Restart: action.Restart, Signal: action.Signal, After: action.After}
+	}
+	return actions
+}
+
+// describeAction names what an action does to its service
+func describeAction(action ServiceAction) string {
+	switch {
+	case action.Signal != "":
+		return fmt.Sprintf("signal %s to %s", action.Signal, action.Name)
+	case action.Restart:
+		return "restart " + action.Name
+	default:
+		return "reload " + action.Name
+	}
+}
+
+// ApplyPending runs the deferred service actions. Outside every maintenance
+// window it does nothing unless force is set.
+func (m *Manager) ApplyPending(force bool) error {
+	if !force && !m.inWindow() {
+		fmt.Printf("INFO: Outside the %s; leaving deferred service actions pending\n", m.windowNote())
+		return nil
+	}
+
+	if m.dryRun {
+		pending, err := m.PendingActions()
+		if err != nil {
+			return err
+		}
+		for _, action := range pendingServiceActions(pending) {
+			fmt.Printf("DRY-RUN: Would %s (deferred)\n", describeAction(action))
+		}
+		return nil
+	}
+
+	pending, err := m.takePending()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Printf("INFO: No deferred service actions\n")
+		return nil
+	}
+	fmt.Printf("INFO: Applying %d deferred service actions\n", len(pending))
+	return m.runWithPending(nil, pending)
+}
+
+// runWithPending runs actions together with previously deferred ones. If
+// they fail, the deferred ones are recorded again for the next try.
+func (m *Manager) runWithPending(actions []ServiceAction, pending []PendingAction) error {
+	err := m.processServiceActions(append(actions, pendingServiceActions(pending)...))
+	if err != nil && len(pending) > 0 {
+		if storeErr := m.storePending(pending); storeErr != nil {
+			m.warnings.Addf("systemd service", "Failed to keep deferred service actions: %v", storeErr)
+		}
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+package systemd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/brizzbuzz/opnix/internal/config"
+	"github.com/brizzbuzz/opnix/internal/warnings"
+)
+
+func TestMaintenanceWindowDefersActions(t *testing.T) {
+	tempDir := t.TempDir()
+	pendingFile := filepath.Join(tempDir, "pending.json")
+	fake := &fakeSystemctl{failing: map[string]bool{}}
+	manager, err := NewManagerWithRunner(config.SystemdIntegration{
+		Enable:             true,
+		RestartOnChange:    true,
+		ChangeDetection:    config.ChangeDetection{Enable: true, HashFile: filepath.Join(tempDir, "hashes.json")},
+		ErrorHandling:      config.ErrorHandling{MaxRetries: 1},
+		MaintenanceWindows: []string{"Sat 02:00-04:00"},
+		PendingFile:        pendingFile,
+	}, fake)
+	if err != nil {
+		t.Fatalf("Failed to create manager: %v", err)
+	}
+	collector := warnings.NewCollector()
+	manager.SetWarnings(collector)
+
+	secretPath := filepath.Join(tempDir, "db-password")
+	secrets := []config.Secret{{Path: secretPath, Reference: "op://Vault/Db/password", Services: []interface{}{"app", "worker"}}}
+	write := func(content string) {
+		if err := os.WriteFile(secretPath, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write secret: %v", err)
+		}
+	}
+
+	// Tuesday noon: the secret is recorded, the restarts wait
+	manager.now = func() time.Time { return time.Date(2024, 1, 2, 12, 0, 0, 0, time.Local) }
+	write("v1")
+	if err := manager.ProcessSecretChanges(secrets, nil); err != nil {
+		t.Fatalf("ProcessSecretChanges failed: %v", err)
+	}
+	if len(fake.commands) != 0 {
+		t.Errorf("Expected no service actions outside the window, got %v", fake.commands)
+	}
+	pending, err := manager.PendingActions()
+	if err != nil || len(pending) != 2 || pending[0].Name != "app" || !pending[0].Restart {
+		t.Fatalf("Expected app and worker to be pending, got %+v, %v", pending, err)
+	}
+	if list := collector.List(); len(list) != 1 || !strings.Contains(list[0].Message, "deferred to the next maintenance window") {
+		t.Errorf("Expected a warning about the deferred actions, got %+v", list)
+	}
+
+	// A second change keeps one entry per service and the first deferral time
+	manager.now = func() time.Time { return time.Date(2024, 1, 3, 12, 0, 0, 0, time.Local) }
+	write("v2")
+	if err := manager.ProcessSecretChanges(secrets, nil); err != nil {
+		t.Fatalf("ProcessSecretChanges failed: %v", err)
+	}
+	pending, _ = manager.PendingActions()
+	if len(pending) != 2 || pending[0].Since.Day() != 2 || len(pending[0].Secrets) != 1 {
+		t.Errorf("Expected the pending actions to be merged, got %+v", pending)
+	}
+
+	// apply-pending leaves them alone outside the window
+	if err := manager.ApplyPending(false); err != nil || len(fake.commands) != 0 {
+		t.Fatalf("Expected nothing to run outside the window, got %v, %v", fake.commands, err)
+	}
+
+	// Saturday 03:00, nothing changed: the deferred restarts run, a failure
+	// keeps its action pending
+	manager.now = func() time.Time { return time.Date(2024, 1, 6, 3, 0, 0, 0, time.Local) }
+	fake.failing["worker"] = true
+	if err := manager.ProcessSecretChanges(secrets, nil); err == nil {
+		t.Fatal("Expected the failed restart to be reported")
+	}
+	if len(fake.commands) == 0 || fake.commands[0] != "systemctl restart app" {
+		t.Errorf("Expected the deferred restarts to run, got %v", fake.commands)
+	}
+	if pending, _ = manager.PendingActions(); len(pending) != 2 {
+		t.Errorf("Expected the deferred actions to be kept after a failure, got %+v", pending)
+	}
+
+	// apply-pending -force runs them at any time and clears the file
+	manager.now = func() time.Time { return time.Date(2024, 1, 8, 12, 0, 0, 0, time.Local) }
+	fake.failing["worker"] = false
+	fake.commands = nil
+	if err := manager.ApplyPending(true); err != nil {
+		t.Fatalf("ApplyPending failed: %v", err)
+	}
+	if strings.Join(fake.commands, ",") != "systemctl restart app,systemctl restart worker" {
+		t.Errorf("Expected both deferred restarts, got %v", fake.commands)
+	}
+	if _, err := os.Stat(pendingFile); !os.IsNotExist(err) {
+		t.Errorf("Expected the pending file to be removed, got %v", err)
+	}
+}
+
+func TestNewManagerInvalidMaintenanceWindow(t *testing.T) {
+	_, err := NewManagerWithRunner(config.SystemdIntegration{MaintenanceWindows: []string{"Someday 02:00-04:00"}}, &fakeSystemctl{})
+	if err == nil {
+		t.Error("Expected an invalid maintenance window to be rejected")
+	}
+}
@@ -0,0 +1,61 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// warnDifferingProcessing warns when secrets resolving the same reference
+// change its value in different ways, e.g. one base64-decodes it and another
+// writes it as is. That is usually a copy-paste slip, but can be meant, so
+// it never fails validation.
+func (v *Validator) warnDifferingProcessing(secrets []SecretData) {
+	var order []string
+	writers := make(map[string][]string)
+	processing := make(map[string]map[string]bool)
+	for i, secret := range secrets {
+		if secret.Reference == "" {
+			continue
+		}
+		if _, seen := writers[secret.Reference]; !seen {
+			order = append(order, secret.Reference)
+			processing[secret.Reference] = make(map[string]bool)
+		}
+		description := describeProcessing(secret)
+		writers[secret.Reference] = append(writers[secret.Reference], fmt.Sprintf("secret[%d] (%s)", i, description))
+		processing[secret.Reference][description] = true
+	}
+
+	for _, reference := range order {
+		if len(processing[reference]) < 2 {
+			continue
+		}
+		v.warnings.Addf("validation", "%s is written with different processing by %s; check this is intended, or use copies to write one value in several encodings",
+			reference, strings.Join(writers[reference], ", "))
+	}
+}
+
+// describeProcessing summarizes what a secret does to its resolved value,
+// in the order the steps run
+func describeProcessing(secret SecretData) string {
+	var steps []string
+	if secret.Extract != "" {
+		steps = append(steps, "extract "+secret.Extract)
+	}
+	if len(secret.Transforms) > 0 {
+		steps = append(steps, "transforms "+strings.Join(secret.Transforms, ", "))
+	}
+	if len(secret.Filter) > 0 {
+		steps = append(steps, "filter "+strings.Join(secret.Filter, " "))
+	}
+	if secret.Template != "" {
+		steps = append(steps, fmt.Sprintf("template %q", secret.Template))
+	}
+	if secret.Canonical {
+		steps = append(steps, "canonical")
+	}
+	if len(steps) == 0 {
+		return "as resolved"
+	}
+	return strings.Join(steps, "; ")
+}
@@ -0,0 +1,150 @@
+package validation
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// What validation does about suspicious characters in references and paths
+const (
+	// UnicodeCheckWarn reports them as warnings (default)
+	UnicodeCheckWarn = "warn"
+	// UnicodeCheckError fails validation
+	UnicodeCheckError = "error"
+	// UnicodeCheckNormalize replaces non-ASCII spaces and drops invisible
+	// characters before validation, and warns about the rest
+	UnicodeCheckNormalize = "normalize"
+)
+
+// runeNames names the characters copy-paste most often brings along
+var runeNames = map[rune]string{
+	'\u00a0': "a non-breaking space",
+	'\u202f': "a narrow non-breaking space",
+	'\u3000': "an ideographic space",
+	'\u200b': "a zero-width space",
+	'\u200c': "a zero-width non-joiner",
+	'\u200d': "a zero-width joiner",
+	'\u2060': "a word joiner",
+	'\ufeff': "a byte order mark",
+	'\u00ad': "a soft hyphen",
+	'\u2010': "a Unicode hyphen",
+	'\u2011': "a non-breaking hyphen",
+	'\u2012': "a figure dash",
+	'\u2013': "an en dash",
+	'\u2014': "an em dash",
+	'\u2212': "a minus sign",
+	'\u2018': "a curly quote",
+	'\u2019': "a curly apostrophe",
+	'\u201c': "a curly quote",
+	'\u201d': "a curly quote",
+}
+
+// SetUnicodeCheck sets what happens to suspicious characters in references
+// and paths: warn (default), error or normalize
+func (v *Validator) SetUnicodeCheck(mode string) {
+	v.unicodeCheck = mode
+}
+
+// ValidateUnicodeCheck checks the unicodeCheck setting
+func (v *Validator) ValidateUnicodeCheck(mode string) error {
+	switch mode {
+	case "", UnicodeCheckWarn, UnicodeCheckError, UnicodeCheckNormalize:
+		return nil
+	default:
+		return errors.ValidationError("Validating unicodeCheck", "unicodeCheck", mode, "warn, error or normalize")
+	}
+}
+
+// NormalizeUnicode replaces non-ASCII spaces with a plain space and drops
+// invisible formatting characters, which are never meant to be part of a
+// vault, item or file name. Other suspicious characters are left for
+// validation to report.
+func NormalizeUnicode(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r > unicode.MaxASCII && unicode.IsSpace(r):
+			return ' '
+		case unicode.Is(unicode.Cf, r):
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// checkUnicode reports the first suspicious character of value, failing
+// validation in error mode and warning otherwise
+func (v *Validator) checkUnicode(value, field string) error {
+	issue := unicodeIssue(value)
+	if issue == "" {
+		return nil
+	}
+	if v.unicodeCheck == UnicodeCheckError {
+		return errors.ConfigValidationError(
+			field,
+			value,
+			fmt.Sprintf("Contains %s, which often makes a name look right but not match", issue),
+			[]string{
+				"Retype the name instead of pasting it",
+				"Set unicodeCheck to \"normalize\" to replace non-ASCII spaces and drop invisible characters automatically",
+			},
+		)
+	}
+	v.warnings.Addf("validation", "%s contains %s, which often makes a name look right but not match: %q", field, issue, value)
+	return nil
+}
+
+// unicodeIssue describes the first character of s that is likely a
+// copy-paste accident, with its position, or returns ""
+func unicodeIssue(s string) string {
+	position := 0
+	for _, r := range s {
+		position++
+		if r <= unicode.MaxASCII {
+			continue
+		}
+		name, known := runeNames[r]
+		switch {
+		case known:
+		case unicode.IsSpace(r):
+			name = "a non-ASCII space"
+		case unicode.Is(unicode.Cf, r):
+			name = "an invisible formatting character"
+		case unicode.Is(unicode.Mn, r):
+			name = "a separate combining accent (the text is not NFC normalized)"
+		case r >= '\uff01' && r <= '\uff5e':
+			name = fmt.Sprintf("a full-width '%c'", r-0xfee0)
+		default:
+			continue
+		}
+		return fmt.Sprintf("%s (U+%04X) at character %d", name, r, position)
+	}
+	return mixedScriptIssue(s)
+}
+
+// mixedScriptIssue finds a word mixing Latin letters with Cyrillic or Greek
+// ones, the usual homoglyphs (Cyrillic "а" for Latin "a")
+func mixedScriptIssue(s string) string {
+	for _, word := range strings.FieldsFunc(s, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		var latin bool
+		var foreign rune
+		for _, r := range word {
+			switch {
+			case unicode.Is(unicode.Latin, r):
+				latin = true
+			case foreign == 0 && (unicode.Is(unicode.Cyrillic, r) || unicode.Is(unicode.Greek, r)):
+				foreign = r
+			}
+		}
+		if latin && foreign != 0 {
+			script := "Greek"
+			if unicode.Is(unicode.Cyrillic, foreign) {
+				script = "Cyrillic"
+			}
+			return fmt.Sprintf("a %s '%c' (U+%04X) among Latin letters in %q", script, foreign, foreign, word)
+		}
+	}
+	return ""
+}
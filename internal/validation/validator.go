@@ -2,92 +2,1607 @@ package validation
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"os/exec"
 	"os/user"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/local"
+	"github.com/brizzbuzz/opnix/internal/onepass"
+	"github.com/brizzbuzz/opnix/internal/schedule"
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
 // Validator provides comprehensive validation with helpful error messages
-type Validator struct{}
+type Validator struct {
+	// warnings receives problems that don't fail validation, see SetWarnings
+	warnings *warnings.Collector
+	// unicodeCheck decides what suspicious characters cause, see SetUnicodeCheck
+	unicodeCheck string
+	// requireAbsolutePaths rejects relative final paths, see SetRequireAbsolutePaths
+	requireAbsolutePaths bool
+}
 
 // NewValidator creates a new validator instance
 func NewValidator() *Validator {
 	return &Validator{}
 }
 
-// Secret represents a secret for validation
-type SecretData struct {
-	Path         string
-	Reference    string
-	Owner        string
-	Group        string
-	Mode         string
-	Symlinks     []string
-	Variables    map[string]string
-	Services     interface{} // Can be []string or map[string]ServiceConfig
-	PathTemplate string
-	Defaults     map[string]string
+// SetWarnings collects non-fatal findings instead of printing them as they occur
+func (v *Validator) SetWarnings(collector *warnings.Collector) {
+	v.warnings = collector
+}
+
+// SetRequireAbsolutePaths makes every secret's final path, after pathTemplate
+// and pathPrefix, have to be absolute instead of relative to the output directory
+func (v *Validator) SetRequireAbsolutePaths(require bool) {
+	v.requireAbsolutePaths = require
+}
+
+// Secret represents a secret for validation
+type SecretData struct {
+	Path            string
+	Reference       string
+	FieldFallbacks  []string
+	Extract         string
+	Transforms      []string
+	Type            string
+	Template        string
+	TemplateJSON    bool
+	Owner           string
+	Group           string
+	Mode            string
+	Symlinks        []string
+	SymlinkDirMode  string
+	Variables       map[string]string
+	Services        interface{} // Can be []string or map[string]ServiceConfig
+	PathTemplate    string
+	PathPrefix      string
+	BaseDir         string
+	Defaults        map[string]string
+	AllowedVaults   []string
+	MaxRetries      *int
+	Timeout         string
+	FIFO            bool
+	SkipIfExists    bool
+	OnlyIf          bool
+	OnlyIfExists    string
+	OnlyIfCommand   []string
+	OnlyIfTimeout   string
+	FIFOTimeout     string
+	Transaction     string
+	Filter          []string
+	FilterTimeout   string
+	CacheTTL        string
+	ValidateWith    []string
+	ValidateTimeout string
+	CertExpiryDays  int
+	Credential      string
+	CredentialEnc   bool
+	EnvFile         []EnvFileEntry
+	Bundle          []string
+	SSHKeys         []string
+	SSHKeysFormat   string
+	Canonical       bool
+	Region          bool
+	RegionName      string
+	RegionComment   string
+	INI             []INIEntry
+	Copies          []SecretCopy
+	Account         string
+	Item            string            // op://Vault/Item for item secrets
+	Fields          map[string]string // Field name -> path for item secrets
+	Accounts        map[string]string // Defined account name -> token file
+	Source          string            // Config file the secret was loaded from, for error messages
+}
+
+// EnvFileEntry is one KEY -> reference line of an env-file secret
+type EnvFileEntry struct {
+	Key       string
+	Reference string
+}
+
+// INIEntry places one reference at a section key of an INI secret
+type INIEntry struct {
+	Section   string
+	Key       string
+	Reference string
+}
+
+// SecretCopy is an extra file written from a secret's value
+type SecretCopy struct {
+	Path       string
+	Encoding   string
+	Owner      string
+	Group      string
+	Mode       string
+	ReadableBy []string
+}
+
+// ValidateConfigStruct validates a config with slice of SecretData
+func (v *Validator) ValidateConfigStruct(secrets []SecretData) error {
+	if len(secrets) == 0 {
+		return errors.ConfigError(
+			"Configuration validation",
+			"No secrets defined in configuration",
+			nil,
+		)
+	}
+
+	// Track seen paths to detect duplicates
+	seenPaths := make(map[string]string)
+
+	for i, secret := range secrets {
+		secretName := fmt.Sprintf("secret[%d]", i)
+		if err := v.validateSecret(secret, secretName, seenPaths); err != nil {
+			return withSource(err, secret.Source)
+		}
+	}
+
+	v.warnDifferingProcessing(secrets)
+	return nil
+}
+
+// withSource notes the config file a failing secret came from, so problems
+// in a merged directory of configs point at the right file
+func withSource(err error, source string) error {
+	opnixErr, ok := err.(*errors.OpnixError)
+	if !ok || source == "" {
+		return err
+	}
+	wrapped := *opnixErr
+	if wrapped.Context != "" {
+		wrapped.Context += "\n"
+	}
+	wrapped.Context += fmt.Sprintf("Defined in config file: %s", source)
+	return &wrapped
+}
+
+// noteSource adds the config file a secret came from to the paths it just
+// claimed in seenPaths, so a duplicate across files names both files
+func noteSource(seenPaths map[string]string, source string, paths ...string) {
+	if source == "" {
+		return
+	}
+	for _, path := range paths {
+		if owner, ok := seenPaths[pathKey(path)]; ok {
+			seenPaths[pathKey(path)] = fmt.Sprintf("%s in %s", owner, source)
+		}
+	}
+}
+
+// envKeyPattern matches valid shell identifiers for environment variables
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// ValidateEnv validates an ENV_NAME -> reference mapping
+func (v *Validator) ValidateEnv(env map[string]string, allowedVaults []string) error {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !envKeyPattern.MatchString(key) {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("env.%s", key),
+				key,
+				"Environment variable name is not a valid shell identifier",
+				[]string{
+					"Use letters, digits and underscores only",
+					"Names must not start with a digit",
+					"Example: DATABASE_PASSWORD",
+				},
+			)
+		}
+
+		if err := v.validateReference(env[key], allowedVaults, fmt.Sprintf("env.%s", key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidateNetworkFilesystemPolicy validates the networkFilesystem setting
+func (v *Validator) ValidateNetworkFilesystemPolicy(policy string) error {
+	switch policy {
+	case "", "warn", "refuse", "allow":
+		return nil
+	default:
+		return errors.ConfigValidationError(
+			"networkFilesystem",
+			policy,
+			"Unknown network filesystem policy",
+			[]string{
+				"Use 'warn' to log a warning (default)",
+				"Use 'refuse' to fail instead of writing secrets to a network mount",
+				"Use 'allow' to write without a warning",
+			},
+		)
+	}
+}
+
+// validateCredential checks the systemd credential settings of a secret
+func (v *Validator) validateCredential(secret SecretData, secretName string) error {
+	if secret.Credential == "" {
+		if secret.CredentialEnc {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.credentialEncrypted", secretName),
+				"true",
+				"credentialEncrypted needs a credential name",
+				[]string{"Set credential to the name services load it by, e.g. credential = \"db-password\""},
+			)
+		}
+		return nil
+	}
+
+	if strings.ContainsRune(secret.Credential, '/') || secret.Credential == "." || secret.Credential == ".." {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.credential", secretName),
+			secret.Credential,
+			"Credential names are file names and cannot contain '/'",
+			[]string{"Use a plain name such as db-password"},
+		)
+	}
+
+	if secret.FIFO {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.credential", secretName),
+			secret.Credential,
+			"systemd reads credentials from regular files, not named pipes",
+			[]string{"Remove fifo or credential from this secret"},
+		)
+	}
+
+	if secret.CredentialEnc && len(secret.Copies) > 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.copies", secretName),
+			fmt.Sprintf("%d copies", len(secret.Copies)),
+			"Copies would hold the value unencrypted next to an encrypted credential",
+			[]string{"Remove copies, or define a separate secret for the plain copy"},
+		)
+	}
+
+	return nil
+}
+
+// ValidateLock checks the run lock settings
+func (v *Validator) ValidateLock(mode, timeout string) error {
+	switch mode {
+	case "", "fail", "wait", "queue":
+	default:
+		return errors.ConfigValidationError(
+			"lock.mode",
+			mode,
+			"Unknown lock mode",
+			[]string{
+				"Use 'fail' to stop when another run holds the lock",
+				"Use 'wait' to wait up to lock.timeout, then fail (default)",
+				"Use 'queue' to wait for the other run to finish",
+			},
+		)
+	}
+
+	if timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			return errors.ValidationError("Validating lock.timeout", "timeout", timeout, "positive duration (e.g., 30s, 5m)")
+		}
+	}
+
+	return nil
+}
+
+// ValidateMaintenanceWindows checks the windows service actions may run in
+func (v *Validator) ValidateMaintenanceWindows(windows []string) error {
+	for i, spec := range windows {
+		if _, err := schedule.Parse(spec); err != nil {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("systemdIntegration.maintenanceWindows[%d]", i),
+				spec,
+				fmt.Sprintf("Invalid maintenance window: %v", err),
+				[]string{
+					"Use \"HH:MM-HH:MM\" for every day, e.g. \"02:00-04:00\"",
+					"Prefix days or day ranges, e.g. \"Sat 02:00-06:00\" or \"Mon-Fri 22:00-06:00\"",
+				},
+			)
+		}
+	}
+	return nil
+}
+
+// ValidateWebhook checks the deployment webhook settings
+func (v *Validator) ValidateWebhook(webhook, timeout string) error {
+	if webhook != "" {
+		parsed, err := url.Parse(webhook)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			// The URL may embed a token, so it is not echoed back
+			return errors.ConfigValidationError(
+				"webhook.url",
+				"<redacted>",
+				"Webhook URL must be an http:// or https:// URL with a host",
+				[]string{"Example: https://hooks.example.com/opnix"},
+			)
+		}
+	}
+
+	if timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			return errors.ValidationError("Validating webhook.timeout", "timeout", timeout, "positive duration (e.g., 5s, 30s)")
+		}
+	}
+
+	return nil
+}
+
+// ValidateModePolicy checks one entry of modePolicies, named by field
+func (v *Validator) ValidateModePolicy(field, dir, maxMode string) error {
+	if !filepath.IsAbs(dir) {
+		return errors.ConfigValidationError(
+			field+".dir",
+			dir,
+			"Mode policy directory must be an absolute path",
+			[]string{"Example: /run/secrets"},
+		)
+	}
+	if !regexp.MustCompile(`^[0-7]{3,4}$`).MatchString(maxMode) {
+		return errors.ValidationError(
+			fmt.Sprintf("Validating %s.maxMode", field),
+			"maxMode",
+			maxMode,
+			"3-4 digit octal number (e.g., 0600, 0644)",
+		)
+	}
+	return nil
+}
+
+// ValidatePlaceholderCheck checks the placeholder detection settings
+func (v *Validator) ValidatePlaceholderCheck(mode string, minLength int, minEntropyBits float64) error {
+	switch mode {
+	case "", "off", "warn", "strict":
+	default:
+		return errors.ConfigValidationError(
+			"placeholderCheck.mode",
+			mode,
+			"Unknown placeholder check mode",
+			[]string{
+				"Use 'off' to skip the check (default)",
+				"Use 'warn' to log a warning for suspicious values",
+				"Use 'strict' to fail instead of writing them",
+			},
+		)
+	}
+
+	if minLength < 0 {
+		return errors.ValidationError("Validating placeholderCheck.minLength", "minLength", fmt.Sprintf("%d", minLength), "non-negative integer")
+	}
+	if minEntropyBits < 0 {
+		return errors.ValidationError("Validating placeholderCheck.minEntropyBits", "minEntropyBits", fmt.Sprintf("%g", minEntropyBits), "non-negative number")
+	}
+
+	return nil
+}
+
+// ValidateHashFileMode checks the change detection hash file mode. Only the
+// owner may write it, otherwise anyone could suppress service restarts.
+func (v *Validator) ValidateHashFileMode(mode string) error {
+	if mode == "" {
+		return nil // The hash file defaults to 0600
+	}
+
+	if !regexp.MustCompile(`^[0-7]{3,4}$`).MatchString(mode) {
+		return errors.ValidationError(
+			"Validating systemdIntegration.changeDetection.hashFileMode",
+			"hashFileMode",
+			mode,
+			"3-4 digit octal number (e.g., 0600, 0644)",
+		)
+	}
+
+	parsed, _ := strconv.ParseUint(mode, 8, 32)
+	if parsed&0022 != 0 {
+		return errors.ConfigValidationError(
+			"systemdIntegration.changeDetection.hashFileMode",
+			mode,
+			"Hash file must only be writable by its owner",
+			[]string{
+				"Use 0600 (default) or 0644 if other users need to read it",
+				"A writable hash file lets others suppress restarts after secret changes",
+			},
+		)
+	}
+
+	return nil
+}
+
+// fieldTypes are the values a secret's type may declare
+var fieldTypes = []string{"password", "concealed", "text", "file", "otp", "sshKey"}
+
+// validateFieldType checks the declared field type of a single-reference secret
+func (v *Validator) validateFieldType(secret SecretData, secretName string) error {
+	if secret.Type == "" {
+		return nil
+	}
+
+	if secret.Item != "" || len(secret.EnvFile) > 0 || len(secret.Bundle) > 0 || len(secret.SSHKeys) > 0 || len(secret.INI) > 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.type", secretName),
+			secret.Type,
+			"type only applies to secrets with a single reference",
+			[]string{"Remove type from item, envFile, bundle, sshKeys and ini secrets"},
+		)
+	}
+
+	for _, fieldType := range fieldTypes {
+		if secret.Type == fieldType {
+			return nil
+		}
+	}
+	return errors.ValidationError(
+		fmt.Sprintf("Validating %s.type", secretName),
+		"type",
+		secret.Type,
+		strings.Join(fieldTypes, ", "),
+	)
+}
+
+// copyEncodings are the encodings a secret copy may use
+var copyEncodings = []string{"raw", "base64", "hex"}
+
+// validateCopies checks the extra files written from a secret's value
+func (v *Validator) validateCopies(secret SecretData, secretName string, seenPaths map[string]string) error {
+	if len(secret.Copies) > 0 && secret.FIFO {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.copies", secretName),
+			fmt.Sprintf("%d copies", len(secret.Copies)),
+			"A named pipe delivers its value once, so it cannot be copied to other files",
+			[]string{"Use a regular file for secrets with copies"},
+		)
+	}
+
+	for i, secretCopy := range secret.Copies {
+		copyName := fmt.Sprintf("%s.copies[%d]", secretName, i)
+
+		valid := secretCopy.Encoding == ""
+		for _, encoding := range copyEncodings {
+			valid = valid || secretCopy.Encoding == encoding
+		}
+		if !valid {
+			return errors.ValidationError(
+				fmt.Sprintf("Validating %s.encoding", copyName),
+				"encoding",
+				secretCopy.Encoding,
+				strings.Join(copyEncodings, ", "),
+			)
+		}
+
+		path, err := v.resolvePath(secretCopy.Path, "", secret.Variables, secret.Defaults, copyName)
+		if err != nil {
+			return err
+		}
+		if path, err = v.applyPathPrefix(path, secret.PathPrefix, copyName); err != nil {
+			return err
+		}
+		if err := v.validatePath(path, copyName, seenPaths); err != nil {
+			return err
+		}
+		noteSource(seenPaths, secret.Source, path)
+
+		// Unset fields inherit the secret's, which is validated on its own
+		if err := v.validateOwnership(secretCopy.Owner, secretCopy.Group, copyName); err != nil {
+			return err
+		}
+		if err := v.validateMode(secretCopy.Mode, copyName); err != nil {
+			return err
+		}
+		if err := v.validateReadableBy(secret, secretCopy, copyName); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateReadableBy checks that every user a copy declares as its reader
+// can read it with the copy's effective owner, group and mode. Files are
+// written by root, so an unset owner or group means root.
+func (v *Validator) validateReadableBy(secret SecretData, secretCopy SecretCopy, copyName string) error {
+	if len(secretCopy.ReadableBy) == 0 {
+		return nil
+	}
+
+	owner := firstNonEmpty(secretCopy.Owner, secret.Owner, "root")
+	group := firstNonEmpty(secretCopy.Group, secret.Group, "root")
+	mode := firstNonEmpty(secretCopy.Mode, secret.Mode)
+	if mode == "" {
+		mode = "0600"
+		if len(secret.SSHKeys) > 0 && (secret.SSHKeysFormat == "" || secret.SSHKeysFormat == "authorized_keys") {
+			mode = "0644"
+		}
+	}
+	// A preserved mode is only known once the file exists
+	perm, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil && mode != "preserve" {
+		return nil // Reported by validateMode
+	}
+
+	gid := "0"
+	if group != "root" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return nil // Reported by validateOwnership
+		}
+		gid = g.Gid
+	}
+
+	for _, reader := range secretCopy.ReadableBy {
+		if reader == "root" {
+			continue // root reads everything
+		}
+		u, err := user.Lookup(reader)
+		if err != nil {
+			return errors.UserGroupError(
+				fmt.Sprintf("Validating %s.readableBy", copyName),
+				reader,
+				"user",
+				v.getAvailableUsers(),
+			)
+		}
+		if mode == "preserve" {
+			continue
+		}
+
+		readable := perm&0004 != 0 || (u.Username == owner && perm&0400 != 0)
+		if !readable && perm&0040 != 0 {
+			readable = u.Gid == gid
+			gids, _ := u.GroupIds()
+			for _, member := range gids {
+				readable = readable || member == gid
+			}
+		}
+		if !readable {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.readableBy", copyName),
+				reader,
+				fmt.Sprintf("Copy is owned by %s:%s with mode %s, which %s cannot read", owner, group, mode, reader),
+				[]string{
+					fmt.Sprintf("Set the copy's owner to %s", reader),
+					fmt.Sprintf("Set the copy's group to one %s belongs to and its mode to 0640", reader),
+				},
+			)
+		}
+	}
+	return nil
+}
+
+// firstNonEmpty returns the first of values that is set
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// validateExtract checks that a secret's extract pattern compiles and has a
+// capture group for the value
+func (v *Validator) validateExtract(secret SecretData, secretName string) error {
+	if secret.Extract == "" {
+		return nil
+	}
+
+	if secret.Item != "" || len(secret.EnvFile) > 0 || len(secret.Bundle) > 0 || len(secret.SSHKeys) > 0 || len(secret.INI) > 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.extract", secretName),
+			secret.Extract,
+			"extract only applies to secrets with a single reference",
+			[]string{"Remove extract from item, envFile, bundle, sshKeys and ini secrets"},
+		)
+	}
+
+	return validateExtractPattern(secret.Extract, fmt.Sprintf("%s.extract", secretName))
+}
+
+// validateExtractPattern checks that an extract pattern compiles and has a
+// capture group for the value
+func validateExtractPattern(pattern, field string) error {
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return errors.ConfigValidationError(
+			field,
+			pattern,
+			fmt.Sprintf("Invalid regular expression: %v", err),
+			[]string{"Use Go regular expression syntax, e.g. \"token=([^&]+)\""},
+		)
+	}
+	if compiled.NumSubexp() == 0 {
+		return errors.ConfigValidationError(
+			field,
+			pattern,
+			"The pattern has no capture group; the first group is what gets written",
+			[]string{"Wrap the part to keep in parentheses, e.g. \"token=([^&]+)\""},
+		)
+	}
+	return nil
+}
+
+// validateTransforms checks each step of a secret's transforms by name
+func (v *Validator) validateTransforms(secret SecretData, secretName string) error {
+	if len(secret.Transforms) == 0 {
+		return nil
+	}
+
+	if secret.Item != "" || len(secret.EnvFile) > 0 || len(secret.Bundle) > 0 || len(secret.SSHKeys) > 0 || len(secret.INI) > 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.transforms", secretName),
+			strings.Join(secret.Transforms, ", "),
+			"transforms only apply to secrets with a single reference",
+			[]string{"Remove transforms from item, envFile, bundle, sshKeys and ini secrets"},
+		)
+	}
+
+	for i, step := range secret.Transforms {
+		field := fmt.Sprintf("%s.transforms[%d]", secretName, i)
+		name, arg, hasArg := strings.Cut(step, ":")
+		switch name {
+		case "extract":
+			if err := validateExtractPattern(arg, field); err != nil {
+				return err
+			}
+		case "base64decode", "base64encode", "trim", "trimNewline":
+			if hasArg {
+				return errors.ConfigValidationError(
+					field,
+					step,
+					fmt.Sprintf("The %s transform takes no argument", name),
+					[]string{fmt.Sprintf("Use %q on its own", name)},
+				)
+			}
+		default:
+			return errors.ConfigValidationError(
+				field,
+				step,
+				"Unknown transform",
+				[]string{"Use extract:<regex>, base64decode, base64encode, trim or trimNewline"},
+			)
+		}
+	}
+	return nil
+}
+
+// validateFieldFallbacks checks the fallback field names of a single-reference secret
+func (v *Validator) validateFieldFallbacks(secret SecretData, secretName string) error {
+	if len(secret.FieldFallbacks) == 0 {
+		return nil
+	}
+
+	if secret.Item != "" || len(secret.EnvFile) > 0 || len(secret.Bundle) > 0 || len(secret.SSHKeys) > 0 || len(secret.INI) > 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.fieldFallbacks", secretName),
+			strings.Join(secret.FieldFallbacks, ", "),
+			"fieldFallbacks only applies to secrets with a single reference",
+			[]string{"Remove fieldFallbacks from item, envFile, bundle, sshKeys and ini secrets"},
+		)
+	}
+
+	seen := make(map[string]bool, len(secret.FieldFallbacks))
+	for i, field := range secret.FieldFallbacks {
+		if field == "" || strings.ContainsAny(field, "/?") {
+			return errors.ValidationError(
+				fmt.Sprintf("Validating %s.fieldFallbacks[%d]", secretName, i),
+				"fieldFallbacks",
+				field,
+				"field name without '/' or '?' (e.g., credential, password)",
+			)
+		}
+		if seen[field] {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.fieldFallbacks[%d]", secretName, i),
+				field,
+				fmt.Sprintf("Fallback field '%s' is listed more than once", field),
+				[]string{"List each fallback field once, in the order to try them"},
+			)
+		}
+		seen[field] = true
+	}
+
+	return nil
+}
+
+// validateFilter checks the command a secret's value is piped through
+func (v *Validator) validateFilter(command []string, timeout string, secretName string) error {
+	if len(command) == 0 {
+		if timeout != "" {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.filterTimeout", secretName),
+				timeout,
+				"filterTimeout is only meaningful when filter is set",
+				[]string{"Set filter or remove filterTimeout"},
+			)
+		}
+		return nil
+	}
+
+	if !filepath.IsAbs(command[0]) {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.filter", secretName),
+			command[0],
+			"Filter command must be an absolute path so PATH cannot change what runs",
+			[]string{fmt.Sprintf("Use the full path, e.g. /run/current-system/sw/bin/%s", filepath.Base(command[0]))},
+		)
+	}
+
+	if timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			return errors.ValidationError(
+				fmt.Sprintf("Validating %s.filterTimeout", secretName),
+				"filterTimeout",
+				timeout,
+				"positive duration (e.g., 10s, 1m)",
+			)
+		}
+	}
+
+	return nil
+}
+
+// validateValidateWith checks a secret's post-write validation command
+func (v *Validator) validateValidateWith(command []string, timeout string, fifo bool, secretName string) error {
+	if len(command) == 0 {
+		if timeout != "" {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.validateTimeout", secretName),
+				timeout,
+				"validateTimeout is only meaningful when validateWith is set",
+				[]string{"Set validateWith or remove validateTimeout"},
+			)
+		}
+		return nil
+	}
+
+	if !filepath.IsAbs(command[0]) {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.validateWith", secretName),
+			command[0],
+			"Validation command must be an absolute path so PATH cannot change what runs",
+			[]string{fmt.Sprintf("Use the full path, e.g. /run/current-system/sw/bin/%s", filepath.Base(command[0]))},
+		)
+	}
+
+	if fifo {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.validateWith", secretName),
+			command[0],
+			"Named pipes deliver the value once, so they cannot be validated after writing",
+			[]string{"Remove fifo or validateWith from this secret"},
+		)
+	}
+
+	if timeout != "" {
+		if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+			return errors.ValidationError(
+				fmt.Sprintf("Validating %s.validateTimeout", secretName),
+				"validateTimeout",
+				timeout,
+				"positive duration (e.g., 10s, 1m)",
+			)
+		}
+	}
+
+	return nil
+}
+
+// validateOnlyIf checks a secret's run-time guard
+func (v *Validator) validateOnlyIf(secret SecretData, secretName string) error {
+	if !secret.OnlyIf {
+		return nil
+	}
+	if secret.OnlyIfExists == "" && len(secret.OnlyIfCommand) == 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.onlyIf", secretName),
+			"{}",
+			"onlyIf needs a condition",
+			[]string{"Set onlyIf.exists to a path, onlyIf.command to a command, or both"},
+		)
+	}
+	if secret.OnlyIfExists != "" && !filepath.IsAbs(secret.OnlyIfExists) {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.onlyIf.exists", secretName),
+			secret.OnlyIfExists,
+			"Guard path must be absolute, so it does not depend on the working directory",
+			[]string{"Use the full path, e.g. /etc/opnix/flags/new-database"},
+		)
+	}
+	if len(secret.OnlyIfCommand) == 0 {
+		if secret.OnlyIfTimeout != "" {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.onlyIf.timeout", secretName),
+				secret.OnlyIfTimeout,
+				"onlyIf.timeout is only meaningful when onlyIf.command is set",
+				[]string{"Set onlyIf.command or remove onlyIf.timeout"},
+			)
+		}
+		return nil
+	}
+	if !filepath.IsAbs(secret.OnlyIfCommand[0]) {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.onlyIf.command", secretName),
+			secret.OnlyIfCommand[0],
+			"Guard command must be an absolute path so PATH cannot change what runs",
+			[]string{fmt.Sprintf("Use the full path, e.g. /run/current-system/sw/bin/%s", filepath.Base(secret.OnlyIfCommand[0]))},
+		)
+	}
+	if secret.OnlyIfTimeout != "" {
+		if d, err := time.ParseDuration(secret.OnlyIfTimeout); err != nil || d <= 0 {
+			return errors.ValidationError(
+				fmt.Sprintf("Validating %s.onlyIf.timeout", secretName),
+				"onlyIf.timeout",
+				secret.OnlyIfTimeout,
+				"positive duration (e.g., 10s, 1m)",
+			)
+		}
+	}
+	return nil
+}
+
+// validateTransaction checks a secret's transaction group name
+func (v *Validator) validateTransaction(transaction string, fifo bool, secretName string) error {
+	if transaction == "" {
+		return nil
+	}
+
+	if !regexp.MustCompile(`^[A-Za-z0-9_.-]+$`).MatchString(transaction) {
+		return errors.ValidationError(
+			fmt.Sprintf("Validating %s.transaction", secretName),
+			"transaction",
+			transaction,
+			"name of letters, digits, '.', '_' or '-' (e.g., tls-bundle)",
+		)
+	}
+
+	if fifo {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.transaction", secretName),
+			transaction,
+			"Named pipes cannot be rolled back, so FIFO secrets cannot join a transaction",
+			[]string{"Remove fifo or transaction from this secret"},
+		)
+	}
+
+	return nil
+}
+
+// validateAccount checks that a secret's account is defined and has a token file
+func (v *Validator) validateAccount(account string, accounts map[string]string, secretName string) error {
+	if account == "" {
+		return nil
+	}
+
+	names := make([]string, 0, len(accounts))
+	for name := range accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tokenFile, exists := accounts[account]
+	if !exists {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.account", secretName),
+			account,
+			fmt.Sprintf("Account '%s' is not defined", account),
+			[]string{
+				fmt.Sprintf("Defined accounts: %v", names),
+				fmt.Sprintf("Add it under accounts: \"%s\": {\"tokenFile\": \"/etc/opnix-%s-token\"}", account, account),
+				"Or remove account to use the default token",
+			},
+		)
+	}
+
+	if tokenFile == "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("accounts.%s.tokenFile", account),
+			"<empty>",
+			fmt.Sprintf("Account '%s' has no tokenFile", account),
+			[]string{
+				"Set tokenFile to a file containing the account's service account token",
+			},
+		)
+	}
+
+	return nil
+}
+
+// validateBaseDir checks that absolute secret and symlink paths stay under baseDir
+func (v *Validator) validateBaseDir(baseDir, path string, symlinks []string, secretName string) error {
+	if baseDir == "" {
+		return nil
+	}
+
+	if !filepath.IsAbs(baseDir) || strings.Contains(baseDir, "..") {
+		return errors.ConfigValidationError(
+			"baseDir",
+			baseDir,
+			"baseDir must be an absolute path without '..'",
+			[]string{
+				"Example: baseDir = \"/run/secrets\"",
+			},
+		)
+	}
+
+	check := func(field, candidate string) error {
+		if !filepath.IsAbs(candidate) || IsWithinDir(candidate, baseDir) {
+			return nil
+		}
+		return errors.ConfigValidationError(
+			field,
+			candidate,
+			fmt.Sprintf("Path is outside the approved base directory %s", baseDir),
+			[]string{
+				fmt.Sprintf("Move the path under %s", baseDir),
+				"Or use a relative path, which is placed under the output directory",
+			},
+		)
+	}
+
+	if err := check(fmt.Sprintf("%s.path", secretName), path); err != nil {
+		return err
+	}
+	for i, symlink := range symlinks {
+		if err := check(fmt.Sprintf("%s.symlinks[%d]", secretName, i), symlink); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// IsWithinDir reports whether path is dir itself or one of its descendants,
+// comparing cleaned paths so "/run/secrets-old" is not under "/run/secrets"
+func IsWithinDir(path, dir string) bool {
+	path, dir = filepath.Clean(path), filepath.Clean(dir)
+	if path == dir || dir == "/" {
+		return true
+	}
+	return strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// validateItemFields validates an item secret by validating each field as
+// its own secret, so every field path is checked and de-duplicated
+func (v *Validator) validateItemFields(secret SecretData, secretName string, seenPaths map[string]string) error {
+	if secret.Item == "" || len(secret.Fields) == 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.item", secretName),
+			secret.Item,
+			"Item secrets need both item and fields",
+			[]string{
+				"Example: item = \"op://Vault/Database Login\"; fields = { username = \"db/user\"; password = \"db/password\"; }",
+			},
+		)
+	}
+
+	if secret.Reference != "" || secret.Path != "" || len(secret.EnvFile) > 0 || len(secret.Bundle) > 0 || len(secret.SSHKeys) > 0 || len(secret.INI) > 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.item", secretName),
+			secret.Item,
+			"Item secrets cannot also set reference, path, envFile, bundle, sshKeys or ini",
+			[]string{
+				"Give each field its target path in fields",
+				"Or use a separate secret for single references",
+			},
+		)
+	}
+
+	if strings.Count(strings.TrimPrefix(strings.TrimSuffix(secret.Item, "/"), "op://"), "/") != 1 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.item", secretName),
+			secret.Item,
+			"Item must be a reference to an item without a field: op://Vault/Item",
+			[]string{
+				"Example: op://Homelab/Database Login",
+			},
+		)
+	}
+
+	names := make([]string, 0, len(secret.Fields))
+	for name := range secret.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		field := secret
+		field.Item = ""
+		field.Fields = nil
+		field.Reference = strings.TrimSuffix(secret.Item, "/") + "/" + name
+		field.Path = secret.Fields[name]
+		if err := v.validateSecret(field, fmt.Sprintf("%s.fields.%s", secretName, name), seenPaths); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateEnvFile validates the entries of an env-file secret. Keys must be
+// shell identifiers and unique, otherwise the dotenv output is parser-dependent.
+func (v *Validator) validateEnvFile(entries []EnvFileEntry, reference string, allowedVaults []string, secretName string) error {
+	if reference != "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.envFile", secretName),
+			reference,
+			"Secret sets both reference and envFile",
+			[]string{
+				"Use reference for a single value or envFile for a combined dotenv file",
+				"Move the reference into envFile with its own key",
+			},
+		)
+	}
+
+	seenKeys := make(map[string]string, len(entries))
+	for i, entry := range entries {
+		field := fmt.Sprintf("%s.envFile[%d]", secretName, i)
+
+		if !envKeyPattern.MatchString(entry.Key) {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.key", field),
+				entry.Key,
+				"Environment variable name is not a valid shell identifier",
+				[]string{
+					"Use letters, digits and underscores only",
+					"Names must not start with a digit",
+					"Example: DATABASE_PASSWORD",
+				},
+			)
+		}
+
+		if existing, exists := seenKeys[entry.Key]; exists {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.key", field),
+				entry.Key,
+				fmt.Sprintf("Duplicate environment variable %s: mapped to both %s and %s", entry.Key, existing, entry.Reference),
+				[]string{
+					"Each key may only appear once in an env-file secret",
+					"Rename one of the keys or remove the duplicate entry",
+				},
+			)
+		}
+		seenKeys[entry.Key] = entry.Reference
+
+		if err := v.validateReference(entry.Reference, allowedVaults, field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateBundle validates the ordered references of a PEM bundle secret
+func (v *Validator) validateBundle(references []string, reference string, allowedVaults []string, secretName string) error {
+	if reference != "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.bundle", secretName),
+			reference,
+			"Secret sets both reference and bundle",
+			[]string{
+				"Use reference for a single value or bundle for concatenated certificates",
+				"Move the reference into bundle at the position it should appear",
+			},
+		)
+	}
+
+	for i, ref := range references {
+		if err := v.validateReference(ref, allowedVaults, fmt.Sprintf("%s.bundle[%d]", secretName, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateSSHKeys validates the ordered public key references of an sshKeys secret
+func (v *Validator) validateSSHKeys(references []string, reference string, allowedVaults []string, secretName string) error {
+	if reference != "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.sshKeys", secretName),
+			reference,
+			"Secret sets both reference and sshKeys",
+			[]string{
+				"Use reference for a single value or sshKeys for an authorized_keys or known_hosts file",
+				"Move the reference into sshKeys at the position it should appear",
+			},
+		)
+	}
+
+	for i, ref := range references {
+		if err := v.validateReference(ref, allowedVaults, fmt.Sprintf("%s.sshKeys[%d]", secretName, i)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateSSHKeysFormat checks sshKeysFormat, which only applies to sshKeys secrets
+func (v *Validator) validateSSHKeysFormat(secret SecretData, secretName string) error {
+	if secret.SSHKeysFormat == "" {
+		return nil
+	}
+	if len(secret.SSHKeys) == 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.sshKeysFormat", secretName),
+			secret.SSHKeysFormat,
+			"sshKeysFormat only applies to sshKeys secrets",
+			[]string{"Remove sshKeysFormat, or list the public key references in sshKeys"},
+		)
+	}
+	if secret.SSHKeysFormat != "authorized_keys" && secret.SSHKeysFormat != "known_hosts" {
+		return errors.ValidationError(
+			fmt.Sprintf("Validating %s.sshKeysFormat", secretName),
+			"sshKeysFormat",
+			secret.SSHKeysFormat,
+			"authorized_keys or known_hosts",
+		)
+	}
+	return nil
+}
+
+// iniNamePattern matches section names and keys every INI parser accepts
+var iniNamePattern = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.\-]*$`)
+
+// validateINI validates the entries of an ini secret. Sections and keys are
+// restricted to names that need no quoting, and each key may appear once per
+// section.
+func (v *Validator) validateINI(entries []INIEntry, reference string, allowedVaults []string, secretName string) error {
+	if reference != "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.ini", secretName),
+			reference,
+			"Secret sets both reference and ini",
+			[]string{
+				"Use reference for a single value or ini for a combined INI file",
+				"Move the reference into ini with its own section and key",
+			},
+		)
+	}
+
+	seen := make(map[string]string, len(entries))
+	for i, entry := range entries {
+		field := fmt.Sprintf("%s.ini[%d]", secretName, i)
+
+		if entry.Section != "" && !iniNamePattern.MatchString(entry.Section) {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.section", field),
+				entry.Section,
+				"INI section name contains unsupported characters",
+				[]string{"Use letters, digits, '_', '.' and '-' only", "Example: database"},
+			)
+		}
+		if !iniNamePattern.MatchString(entry.Key) {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.key", field),
+				entry.Key,
+				"INI key contains unsupported characters",
+				[]string{"Use letters, digits, '_', '.' and '-' only", "Example: password"},
+			)
+		}
+
+		placement := entry.Key
+		if entry.Section != "" {
+			placement = entry.Section + "." + entry.Key
+		}
+		if existing, exists := seen[placement]; exists {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.key", field),
+				placement,
+				fmt.Sprintf("Duplicate INI key %s: mapped to both %s and %s", placement, existing, entry.Reference),
+				[]string{"Each key may only appear once per section"},
+			)
+		}
+		seen[placement] = entry.Reference
+
+		if err := v.validateReference(entry.Reference, allowedVaults, field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateSecret validates individual secret configuration
+func (v *Validator) validateSecret(secret SecretData, secretName string, seenPaths map[string]string) error {
+	if err := v.validateFieldFallbacks(secret, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateExtract(secret, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateTransforms(secret, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateFieldType(secret, secretName); err != nil {
+		return err
+	}
+
+	if secret.TemplateJSON && secret.Template == "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.templateJSON", secretName),
+			"true",
+			"templateJSON only applies to secrets with a template",
+			[]string{"Add a template that uses .SecretJSON, e.g. {{ .SecretJSON.host }}"},
+		)
+	}
+
+	if secret.Item != "" || len(secret.Fields) > 0 {
+		if len(secret.Copies) > 0 {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.copies", secretName),
+				secret.Item,
+				"copies only apply to secrets with a single output file",
+				[]string{"Map the extra paths as further fields, or use a separate secret per field"},
+			)
+		}
+		return v.validateItemFields(secret, secretName, seenPaths)
+	}
+
+	// Validate reference, or every reference of an env-file, bundle, sshKeys or ini secret
+	var kinds []string
+	for kind, used := range map[string]bool{"envFile": len(secret.EnvFile) > 0, "bundle": len(secret.Bundle) > 0, "sshKeys": len(secret.SSHKeys) > 0, "ini": len(secret.INI) > 0} {
+		if used {
+			kinds = append(kinds, kind)
+		}
+	}
+	if len(kinds) > 1 {
+		sort.Strings(kinds)
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.%s", secretName, kinds[0]),
+			strings.Join(kinds, ", "),
+			fmt.Sprintf("Secret combines %s", strings.Join(kinds, " and ")),
+			[]string{"Use a separate secret for each output format"},
+		)
+	}
+	if len(secret.EnvFile) > 0 {
+		if err := v.validateEnvFile(secret.EnvFile, secret.Reference, secret.AllowedVaults, secretName); err != nil {
+			return err
+		}
+	} else if len(secret.Bundle) > 0 {
+		if err := v.validateBundle(secret.Bundle, secret.Reference, secret.AllowedVaults, secretName); err != nil {
+			return err
+		}
+	} else if len(secret.SSHKeys) > 0 {
+		if err := v.validateSSHKeys(secret.SSHKeys, secret.Reference, secret.AllowedVaults, secretName); err != nil {
+			return err
+		}
+	} else if len(secret.INI) > 0 {
+		if err := v.validateINI(secret.INI, secret.Reference, secret.AllowedVaults, secretName); err != nil {
+			return err
+		}
+	} else if err := v.validateReference(secret.Reference, secret.AllowedVaults, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateSSHKeysFormat(secret, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateRegion(secret, secretName); err != nil {
+		return err
+	}
+
+	if secret.Canonical && (len(secret.Bundle) > 0 || len(secret.SSHKeys) > 0) {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.canonical", secretName),
+			"true",
+			"canonical only applies to JSON values and envFile and ini secrets",
+			[]string{"Remove canonical from bundle and sshKeys secrets, which keep their configured order"},
+		)
+	}
+
+	if err := v.validateAccount(secret.Account, secret.Accounts, secretName); err != nil {
+		return err
+	}
+
+	// Validate path and resolve final path
+	finalPath, err := v.resolvePath(secret.Path, secret.PathTemplate, secret.Variables, secret.Defaults, secretName)
+	if err != nil {
+		return err
+	}
+
+	// Apply the config-level prefix so duplicate detection sees the final path
+	finalPath, err = v.applyPathPrefix(finalPath, secret.PathPrefix, secretName)
+	if err != nil {
+		return err
+	}
+
+	if secret.Region {
+		if err := v.claimRegion(finalPath, secret, secretName, seenPaths); err != nil {
+			return err
+		}
+	}
+	if err := v.validatePath(finalPath, secretName, seenPaths); err != nil {
+		return err
+	}
+	if secret.Region {
+		seenPaths[pathKey(finalPath)+"\x00region"] = secretName
+	}
+	noteSource(seenPaths, secret.Source, finalPath)
+
+	// Validate symlinks
+	if err := v.validateSymlinks(secret.Symlinks, secretName, seenPaths); err != nil {
+		return err
+	}
+	noteSource(seenPaths, secret.Source, secret.Symlinks...)
+
+	if err := v.validateCopies(secret, secretName, seenPaths); err != nil {
+		return err
+	}
+
+	// Relative paths are checked against baseDir once the output directory is known
+	if err := v.validateBaseDir(secret.BaseDir, finalPath, secret.Symlinks, secretName); err != nil {
+		return err
+	}
+
+	// Validate ownership
+	if err := v.validateOwnership(secret.Owner, secret.Group, secretName); err != nil {
+		return err
+	}
+
+	// Validate permissions
+	if err := v.validateMode(secret.Mode, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateSymlinkDirMode(secret.SymlinkDirMode, secretName); err != nil {
+		return err
+	}
+
+	// Validate resolution overrides
+	if err := v.validateResolveOverrides(secret.MaxRetries, secret.Timeout, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateCacheTTL(secret.CacheTTL, secretName); err != nil {
+		return err
+	}
+
+	// Validate FIFO delivery settings
+	if err := v.validateFIFO(secret.FIFO, secret.FIFOTimeout, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateTransaction(secret.Transaction, secret.FIFO, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateFilter(secret.Filter, secret.FilterTimeout, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateValidateWith(secret.ValidateWith, secret.ValidateTimeout, secret.FIFO, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateOnlyIf(secret, secretName); err != nil {
+		return err
+	}
+
+	if err := v.validateCredential(secret, secretName); err != nil {
+		return err
+	}
+
+	if secret.CertExpiryDays < 0 {
+		return errors.ValidationError(
+			fmt.Sprintf("Validating %s.certExpiryWarnDays", secretName),
+			"certExpiryWarnDays",
+			fmt.Sprintf("%d", secret.CertExpiryDays),
+			"non-negative number of days",
+		)
+	}
+
+	if secret.SkipIfExists && secret.FIFO {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.skipIfExists", secretName),
+			"true",
+			"A named pipe stays in place after delivery, so skipIfExists would never deliver again",
+			[]string{"Remove skipIfExists or fifo from the secret"},
+		)
+	}
+
+	return nil
+}
+
+// validateFIFO validates named pipe delivery settings
+func (v *Validator) validateFIFO(fifo bool, fifoTimeout, secretName string) error {
+	if fifoTimeout == "" {
+		return nil
+	}
+
+	if !fifo {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.fifoTimeout", secretName),
+			fifoTimeout,
+			"fifoTimeout is only meaningful when fifo is enabled",
+			[]string{
+				"Set fifo: true to deliver the secret through a named pipe",
+				"Or remove fifoTimeout from the secret",
+			},
+		)
+	}
+
+	d, err := time.ParseDuration(fifoTimeout)
+	if err != nil || d <= 0 {
+		return errors.ValidationError(
+			fmt.Sprintf("Validating %s.fifoTimeout", secretName),
+			"fifoTimeout",
+			fifoTimeout,
+			"positive duration (e.g., 30s, 5m)",
+		)
+	}
+
+	return nil
 }
 
-// ValidateConfigStruct validates a config with slice of SecretData
-func (v *Validator) ValidateConfigStruct(secrets []SecretData) error {
-	if len(secrets) == 0 {
-		return errors.ConfigError(
-			"Configuration validation",
-			"No secrets defined in configuration",
-			nil,
+// validateResolveOverrides validates per-secret retry and timeout overrides
+func (v *Validator) validateResolveOverrides(maxRetries *int, timeout, secretName string) error {
+	if maxRetries != nil && *maxRetries < 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.maxRetries", secretName),
+			strconv.Itoa(*maxRetries),
+			"maxRetries cannot be negative",
+			[]string{
+				"Use 0 to disable retries for this secret",
+				"Remove the field to use the config-level default",
+			},
 		)
 	}
 
-	// Track seen paths to detect duplicates
-	seenPaths := make(map[string]string)
-
-	for i, secret := range secrets {
-		secretName := fmt.Sprintf("secret[%d]", i)
-		if err := v.validateSecret(secret, secretName, seenPaths); err != nil {
-			return err
+	if timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d <= 0 {
+			return errors.ValidationError(
+				fmt.Sprintf("Validating %s.timeout", secretName),
+				"timeout",
+				timeout,
+				"positive duration (e.g., 10s, 1m30s)",
+			)
 		}
 	}
 
 	return nil
 }
 
-// validateSecret validates individual secret configuration
-func (v *Validator) validateSecret(secret SecretData, secretName string, seenPaths map[string]string) error {
-	// Validate reference
-	if err := v.validateReference(secret.Reference, secretName); err != nil {
-		return err
+// validateRegion checks a secret written into a marked region of a shared
+// file. The whole file is read and replaced, so it must be a regular file
+// holding text.
+func (v *Validator) validateRegion(secret SecretData, secretName string) error {
+	if !secret.Region {
+		return nil
 	}
 
-	// Validate path and resolve final path
-	finalPath, err := v.resolvePath(secret.Path, secret.PathTemplate, secret.Variables, secret.Defaults, secretName)
-	if err != nil {
-		return err
+	conflicts := []struct {
+		option string
+		set    bool
+	}{
+		{"fifo", secret.FIFO},
+		{"credentialEncrypted", secret.CredentialEnc},
+		{"skipIfExists", secret.SkipIfExists},
+	}
+	for _, conflict := range conflicts {
+		if conflict.set {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.region", secretName),
+				conflict.option,
+				fmt.Sprintf("region cannot be combined with %s", conflict.option),
+				[]string{"Write the secret to a file of its own, or remove " + conflict.option},
+			)
+		}
 	}
 
-	if err := v.validatePath(finalPath, secretName, seenPaths); err != nil {
-		return err
+	for _, field := range []string{"name", "comment"} {
+		value := secret.RegionName
+		if field == "comment" {
+			value = secret.RegionComment
+		}
+		if strings.ContainsAny(value, "\r\n") {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.region.%s", secretName, field),
+				value,
+				"Marker lines cannot contain line breaks",
+				[]string{"Use a single-line region name and comment prefix, e.g. \"#\" or \"//\""},
+			)
+		}
 	}
+	return nil
+}
 
-	// Validate symlinks
-	if err := v.validateSymlinks(secret.Symlinks, secretName, seenPaths); err != nil {
-		return err
+// claimRegion lets region secrets share a file as long as their markers
+// differ. A file claimed by any other secret stays exclusive.
+func (v *Validator) claimRegion(path string, secret SecretData, secretName string, seenPaths map[string]string) error {
+	key := pathKey(path)
+	markers := key + "\x00region\x00" + secret.RegionComment + "\x00" + secret.RegionName
+	if owner, exists := seenPaths[markers]; exists {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.region", secretName),
+			path,
+			fmt.Sprintf("Region with the same markers is already written by %s", owner),
+			[]string{"Give each region in the file its own region.name"},
+		)
 	}
+	// Only region secrets have written the file so far
+	if _, shared := seenPaths[key+"\x00region"]; shared {
+		delete(seenPaths, key)
+	}
+	seenPaths[markers] = secretName
+	return nil
+}
 
-	// Validate ownership
-	if err := v.validateOwnership(secret.Owner, secret.Group, secretName); err != nil {
-		return err
+// validateCacheTTL checks a secret's override of resolve.cache.ttl, where 0
+// turns the cache off for the secret
+func (v *Validator) validateCacheTTL(cacheTTL, secretName string) error {
+	if cacheTTL == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(cacheTTL); err != nil || d < 0 {
+		return errors.ValidationError(
+			fmt.Sprintf("Validating %s.cacheTTL", secretName),
+			"cacheTTL",
+			cacheTTL,
+			"duration (e.g., 5m, 1h), or 0 to never serve this secret from the cache",
+		)
 	}
+	return nil
+}
 
-	// Validate permissions
-	if err := v.validateMode(secret.Mode, secretName); err != nil {
-		return err
+// ValidateResolveCache checks the resolve.cache settings
+func (v *Validator) ValidateResolveCache(file, ttl, keyFile string) error {
+	if file == "" {
+		if ttl != "" || keyFile != "" {
+			return errors.ConfigValidationError(
+				"resolve.cache.file",
+				"<empty>",
+				"resolve.cache.ttl and keyFile have no effect without a cache file",
+				[]string{"Set resolve.cache.file to enable the cache, or remove the other cache settings"},
+			)
+		}
+		return nil
 	}
 
+	if !filepath.IsAbs(file) {
+		return errors.ConfigValidationError(
+			"resolve.cache.file",
+			file,
+			"Cache file must be an absolute path",
+			[]string{"Use a path on persistent, root-only storage, e.g. /var/lib/opnix/resolve-cache"},
+		)
+	}
+	if keyFile != "" && !filepath.IsAbs(keyFile) {
+		return errors.ConfigValidationError(
+			"resolve.cache.keyFile",
+			keyFile,
+			"Cache key file must be an absolute path",
+			[]string{"Leave keyFile unset to derive the key from the service account token"},
+		)
+	}
+	if ttl != "" {
+		if d, err := time.ParseDuration(ttl); err != nil || d <= 0 {
+			return errors.ValidationError(
+				"Validating resolve.cache.ttl",
+				"ttl",
+				ttl,
+				"positive duration (e.g., 5m, 1h)",
+			)
+		}
+	}
 	return nil
 }
 
@@ -116,6 +1631,31 @@ func (v *Validator) resolvePath(path, pathTemplate string, variables, defaults m
 	return v.substituteVariables(pathTemplate, variables, defaults, secretName)
 }
 
+// applyPathPrefix prepends the config-level pathPrefix to relative paths
+func (v *Validator) applyPathPrefix(path, prefix, secretName string) (string, error) {
+	if prefix == "" {
+		return path, nil
+	}
+
+	if filepath.IsAbs(prefix) || strings.Contains(prefix, "..") {
+		return "", errors.ConfigValidationError(
+			"pathPrefix",
+			prefix,
+			"pathPrefix must be a relative path without '..'",
+			[]string{
+				"Use a relative directory such as 'myapp' or 'prod/myapp'",
+				"The prefix is joined with the output directory for relative secret paths",
+			},
+		)
+	}
+
+	if path == "" || filepath.IsAbs(path) {
+		return path, nil
+	}
+
+	return filepath.Join(prefix, path), nil
+}
+
 // substituteVariables replaces template variables in a path
 func (v *Validator) substituteVariables(template string, variables, defaults map[string]string, secretName string) (string, error) {
 	result := template
@@ -135,9 +1675,7 @@ func (v *Validator) substituteVariables(template string, variables, defaults map
 
 	for _, match := range matches {
 		placeholder := match[0] // {varname}
-		varName := match[1]     // varname
-
-		value, exists := allVars[varName]
+		varName, value, exists := LookupVariable(match[1], allVars)
 		if !exists {
 			availableVars := make([]string, 0, len(allVars))
 			for k := range allVars {
@@ -167,6 +1705,24 @@ func (v *Validator) substituteVariables(template string, variables, defaults map
 	return result, nil
 }
 
+// LookupVariable returns the value of a placeholder's variable, where the
+// placeholder may carry a shell-like inline default, e.g. service:-web. The
+// default applies when the variable is unset or empty.
+func LookupVariable(placeholder string, variables map[string]string) (name, value string, ok bool) {
+	name, fallback, hasDefault := strings.Cut(placeholder, ":-")
+	value, ok = variables[name]
+	if hasDefault && value == "" {
+		return name, fallback, true
+	}
+	return name, value, ok
+}
+
+// SubstituteVariables replaces {name} placeholders using variables, then
+// defaults, with the same checks applied to path templates
+func (v *Validator) SubstituteVariables(template string, variables, defaults map[string]string, name string) (string, error) {
+	return v.substituteVariables(template, variables, defaults, name)
+}
+
 // validateVariableValue validates that a template variable value is safe
 func (v *Validator) validateVariableValue(value, varName, secretName string) error {
 	if strings.Contains(value, "..") {
@@ -238,7 +1794,7 @@ func (v *Validator) validateSymlinks(symlinks []string, secretName string, seenP
 		}
 
 		// Check for duplicate symlink paths
-		if existingSecret, exists := seenPaths[symlink]; exists {
+		if existingSecret, exists := seenPaths[pathKey(symlink)]; exists {
 			return errors.ConfigValidationError(
 				symlinkName,
 				symlink,
@@ -250,14 +1806,15 @@ func (v *Validator) validateSymlinks(symlinks []string, secretName string, seenP
 			)
 		}
 
-		seenPaths[symlink] = fmt.Sprintf("%s (symlink)", secretName)
+		seenPaths[pathKey(symlink)] = fmt.Sprintf("%s (symlink)", secretName)
 	}
 
 	return nil
 }
 
-// validateReference validates 1Password reference format
-func (v *Validator) validateReference(reference, secretName string) error {
+// validateReference validates 1Password reference format and, when an
+// allowlist is configured, that the reference targets a permitted vault
+func (v *Validator) validateReference(reference string, allowedVaults []string, secretName string) error {
 	if reference == "" {
 		return errors.ConfigValidationError(
 			fmt.Sprintf("%s.reference", secretName),
@@ -271,6 +1828,23 @@ func (v *Validator) validateReference(reference, secretName string) error {
 		)
 	}
 
+	if err := v.checkUnicode(reference, fmt.Sprintf("%s.reference", secretName)); err != nil {
+		return err
+	}
+
+	// Local references name an entry of the -backend local secrets file
+	if local.IsReference(reference) {
+		if strings.TrimPrefix(reference, local.Scheme) == "" {
+			return errors.ConfigValidationError(
+				fmt.Sprintf("%s.reference", secretName),
+				reference,
+				"Local reference does not name an entry",
+				[]string{"Use format: local://name"},
+			)
+		}
+		return nil
+	}
+
 	// Extract and validate components first
 	if !strings.HasPrefix(reference, "op://") {
 		return errors.ConfigValidationError(
@@ -321,10 +1895,10 @@ func (v *Validator) validateReference(reference, secretName string) error {
 			fmt.Sprintf("%s.reference", secretName),
 			reference,
 			"Item name cannot be empty",
-			[]string{
-				"Specify a valid item name in the reference",
+			referenceSuggestions(vault, "",
+				"Specify a valid item name or ID in the reference",
 				fmt.Sprintf("List items in vault: op item list --vault '%s'", vault),
-			},
+			),
 		)
 	}
 
@@ -333,17 +1907,66 @@ func (v *Validator) validateReference(reference, secretName string) error {
 			fmt.Sprintf("%s.reference", secretName),
 			reference,
 			"Field name cannot be empty",
-			[]string{
+			referenceSuggestions(vault, item,
 				"Specify a valid field name in the reference",
 				fmt.Sprintf("View item details: op item get '%s' --vault '%s'", item, vault),
 				"Common field names: password, credential, token, key",
-			},
+			),
+		)
+	}
+
+	// A qualified vault is allowed by its name or by its qualifying ID
+	vaultID := ""
+	if ref, err := onepass.ParseReference(reference); err == nil {
+		vault, vaultID = ref.Vault, ref.VaultID
+	}
+	if len(allowedVaults) > 0 && !containsVault(allowedVaults, vault) && (vaultID == "" || !containsVault(allowedVaults, vaultID)) {
+		suggestions := []string{
+			fmt.Sprintf("Allowed vaults: %v", allowedVaults),
+			fmt.Sprintf("Add '%s' to allowedVaults if this access is intended", vault),
+			"Or move the item into one of the allowed vaults",
+		}
+		if onepass.IsID(vault) {
+			// IDs are matched literally, an allowlisted name does not cover its ID
+			suggestions = append(suggestions, "Vault IDs are matched literally: list the ID in allowedVaults, not the vault name")
+		}
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.reference", secretName),
+			reference,
+			fmt.Sprintf("Vault '%s' is not in the allowedVaults list", vault),
+			suggestions,
 		)
 	}
 
 	return nil
 }
 
+// referenceSuggestions returns the given suggestions, unless the vault or item
+// is addressed by ID: name-based lookups don't apply and IDs never change, so
+// point at the ID-based commands instead
+func referenceSuggestions(vault, item string, suggestions ...string) []string {
+	if !onepass.IsID(vault) && (item == "" || !onepass.IsID(item)) {
+		return suggestions
+	}
+
+	idSuggestions := []string{suggestions[0]}
+	idSuggestions = append(idSuggestions, fmt.Sprintf("Confirm the vault ID exists: op vault get %s", vault))
+	if item != "" {
+		idSuggestions = append(idSuggestions, fmt.Sprintf("Confirm the item exists: op item get %s --vault %s", item, vault))
+	}
+	return idSuggestions
+}
+
+// containsVault checks if a vault name is present in the allowlist
+func containsVault(allowedVaults []string, vault string) bool {
+	for _, allowed := range allowedVaults {
+		if allowed == vault {
+			return true
+		}
+	}
+	return false
+}
+
 // validatePath validates secret path and checks for duplicates
 func (v *Validator) validatePath(path, secretName string, seenPaths map[string]string) error {
 	if path == "" {
@@ -359,6 +1982,23 @@ func (v *Validator) validatePath(path, secretName string, seenPaths map[string]s
 		)
 	}
 
+	if err := v.checkUnicode(path, fmt.Sprintf("%s.path", secretName)); err != nil {
+		return err
+	}
+
+	if v.requireAbsolutePaths && !strings.HasPrefix(path, "/") {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.path", secretName),
+			path,
+			"Relative path not allowed (requireAbsolutePaths is set)",
+			[]string{
+				fmt.Sprintf("Give the full destination, e.g. /run/secrets/%s", path),
+				"Or start pathTemplate with an absolute directory, e.g. /run/secrets/{service}/{name}",
+				"Unset requireAbsolutePaths to write relative paths under the output directory",
+			},
+		)
+	}
+
 	// Check for path traversal attempts
 	if strings.Contains(path, "..") {
 		return errors.ConfigValidationError(
@@ -373,8 +2013,9 @@ func (v *Validator) validatePath(path, secretName string, seenPaths map[string]s
 		)
 	}
 
-	// Check for duplicate paths
-	if existingSecret, exists := seenPaths[path]; exists {
+	// Check for duplicate paths, comparing the cleaned final path so that
+	// e.g. "{dir}/key" with dir = "tls/" collides with "tls/key"
+	if existingSecret, exists := seenPaths[pathKey(path)]; exists {
 		return errors.ConfigValidationError(
 			fmt.Sprintf("%s.path", secretName),
 			path,
@@ -387,7 +2028,7 @@ func (v *Validator) validatePath(path, secretName string, seenPaths map[string]s
 		)
 	}
 
-	seenPaths[path] = secretName
+	seenPaths[pathKey(path)] = secretName
 
 	// Validate absolute path security
 	if strings.HasPrefix(path, "/") {
@@ -399,28 +2040,89 @@ func (v *Validator) validatePath(path, secretName string, seenPaths map[string]s
 	return nil
 }
 
+// dangerousPaths lists system locations secrets must never be written to
+var dangerousPaths = []string{
+	"/bin", "/sbin", "/usr/bin", "/usr/sbin",
+	"/boot", "/dev", "/proc", "/sys",
+	"/etc/passwd", "/etc/shadow", "/etc/group",
+}
+
+// DangerousPathPrefix returns the dangerous location path falls under, or ""
+func DangerousPathPrefix(path string) string {
+	for _, dangerous := range dangerousPaths {
+		if strings.HasPrefix(path, dangerous) {
+			return dangerous
+		}
+	}
+	return ""
+}
+
+// EvalParentSymlinks resolves symlinks in the parent directory of path.
+// When the parent does not exist yet, the nearest existing ancestor is
+// resolved and the missing components are appended unchanged.
+func EvalParentSymlinks(path string) (string, error) {
+	path = filepath.Clean(path)
+	dir := filepath.Dir(path)
+	var missing []string
+
+	for {
+		realDir, err := filepath.EvalSymlinks(dir)
+		if err == nil {
+			parts := append([]string{realDir}, missing...)
+			parts = append(parts, filepath.Base(path))
+			return filepath.Join(parts...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return path, nil
+		}
+		missing = append([]string{filepath.Base(dir)}, missing...)
+		dir = parent
+	}
+}
+
+// pathKey normalizes a final path for duplicate detection
+func pathKey(path string) string {
+	return filepath.Clean(path)
+}
+
 // validateAbsolutePath validates absolute paths for security
 func (v *Validator) validateAbsolutePath(path, secretName string) error {
 	// Check for potentially dangerous locations
-	dangerousPaths := []string{
-		"/bin", "/sbin", "/usr/bin", "/usr/sbin",
-		"/boot", "/dev", "/proc", "/sys",
-		"/etc/passwd", "/etc/shadow", "/etc/group",
+	if dangerous := DangerousPathPrefix(path); dangerous != "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.path", secretName),
+			path,
+			fmt.Sprintf("Path starts with potentially dangerous location: %s", dangerous),
+			[]string{
+				"Avoid placing secrets in system directories",
+				"Use /etc/secrets/, /var/lib/opnix/secrets/, or /run/secrets/ instead",
+				"Consider using relative paths under the configured output directory",
+			},
+		)
 	}
 
-	for _, dangerous := range dangerousPaths {
-		if strings.HasPrefix(path, dangerous) {
-			return errors.ConfigValidationError(
-				fmt.Sprintf("%s.path", secretName),
-				path,
-				fmt.Sprintf("Path starts with potentially dangerous location: %s", dangerous),
-				[]string{
-					"Avoid placing secrets in system directories",
-					"Use /etc/secrets/, /var/lib/opnix/secrets/, or /run/secrets/ instead",
-					"Consider using relative paths under the configured output directory",
-				},
-			)
-		}
+	// A symlinked parent directory could redirect a safe-looking path
+	realPath, err := EvalParentSymlinks(path)
+	if err != nil || realPath == path {
+		return nil // Unresolvable parents are caught when writing
+	}
+
+	if dangerous := DangerousPathPrefix(realPath); dangerous != "" {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.path", secretName),
+			path,
+			fmt.Sprintf("Path resolves through a symlinked parent directory to potentially dangerous location: %s (real path: %s)", dangerous, realPath),
+			[]string{
+				"Check where the parent directory symlink points: ls -la " + filepath.Dir(path),
+				"Avoid placing secrets in system directories",
+				"Use /etc/secrets/, /var/lib/opnix/secrets/, or /run/secrets/ instead",
+			},
+		)
 	}
 
 	return nil
@@ -489,8 +2191,8 @@ func (v *Validator) validateGroup(groupname, secretName string) error {
 
 // validateMode validates file permission mode
 func (v *Validator) validateMode(mode, secretName string) error {
-	if mode == "" {
-		return nil // Empty mode is ok, will use default
+	if mode == "" || mode == "preserve" {
+		return nil // Empty mode uses the default, preserve keeps the existing file's mode
 	}
 
 	// Check if it's a valid octal string
@@ -523,10 +2225,79 @@ func (v *Validator) validateMode(mode, secretName string) error {
 	return nil
 }
 
+// validateSymlinkDirMode validates the mode for symlink parent directories
+func (v *Validator) validateSymlinkDirMode(mode, secretName string) error {
+	if mode == "" {
+		return nil // Directories keep the default 0755
+	}
+
+	if !regexp.MustCompile(`^[0-7]{3,4}$`).MatchString(mode) {
+		return errors.ValidationError(
+			fmt.Sprintf("Validating %s.symlinkDirMode", secretName),
+			"symlinkDirMode",
+			mode,
+			"3-4 digit octal number (e.g., 0700, 0750, 0755)",
+		)
+	}
+
+	modeInt, _ := strconv.ParseUint(mode, 8, 32)
+	// setgid (group-shared directories) and sticky are applied; setuid means nothing here
+	if modeInt&04000 != 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.symlinkDirMode", secretName),
+			mode,
+			"Mode sets the setuid bit, which has no meaning on a directory",
+			[]string{
+				"Drop the leading 4, e.g. 0750 instead of 4750",
+				"Use setgid (2750) to have new entries inherit the directory's group",
+			},
+		)
+	}
+	if modeInt&0002 != 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.symlinkDirMode", secretName),
+			mode,
+			"Mode allows world write access to the symlink directory (others could replace the symlink)",
+			[]string{
+				"Remove write permission for others",
+				"Use modes like 0700, 0750, or 0755 instead",
+			},
+		)
+	}
+	if modeInt&0100 == 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.symlinkDirMode", secretName),
+			mode,
+			"Mode removes the owner's search permission, so the symlink could not be reached",
+			[]string{"Keep the owner execute bit, e.g. 0700 or 0750"},
+		)
+	}
+
+	return nil
+}
+
 // validateModeSecurity checks for potentially insecure file modes
 func (v *Validator) validateModeSecurity(mode, secretName string) error {
 	modeInt, _ := strconv.ParseUint(mode, 8, 32)
 
+	// A setuid secret is always a mistake: it is data, never a program to run
+	if modeInt&04000 != 0 {
+		return errors.ConfigValidationError(
+			fmt.Sprintf("%s.mode", secretName),
+			mode,
+			"Mode sets the setuid bit on a secret file",
+			[]string{
+				"Drop the leading 4, e.g. 0600 instead of 4600",
+				"Special bits are only meaningful on symlinkDirMode",
+			},
+		)
+	}
+
+	// setgid and sticky do nothing useful on a non-executable file, and are not applied
+	if modeInt&03000 != 0 {
+		v.warnings.Addf("validation", "%s.mode %s sets setgid or sticky, which has no effect on a secret file and is ignored", secretName, mode)
+	}
+
 	// Check for world-writable secrets (always an error)
 	if modeInt&0002 != 0 { // Others can write
 		return errors.ConfigValidationError(
@@ -541,31 +2312,38 @@ func (v *Validator) validateModeSecurity(mode, secretName string) error {
 		)
 	}
 
-	// Note: We allow world-readable modes like 0644 for certain use cases (certificates, etc.)
-	// but could add a warning in the future
+	// World-readable modes are fine for certificates, but worth a second look
+	if modeInt&0004 != 0 {
+		v.warnings.Addf("validation", "%s.mode %s makes the secret readable by every user", secretName, mode)
+	}
 
 	return nil
 }
 
+// Sources for user/group enumeration, overridable in tests
+var (
+	getentCommand = "getent"
+	passwdFile    = "/etc/passwd"
+	groupFile     = "/etc/group"
+)
+
 // getAvailableUsers returns a list of available system users
 func (v *Validator) getAvailableUsers() []string {
 	users := []string{"root"} // Always include root
 
-	// Try to read /etc/passwd for more users
-	if content, err := os.ReadFile("/etc/passwd"); err == nil {
-		lines := strings.Split(string(content), "\n")
-		for _, line := range lines {
-			if line == "" {
-				continue
-			}
-			parts := strings.Split(line, ":")
-			if len(parts) > 0 && parts[0] != "root" {
-				// Add common service users
-				username := parts[0]
-				if isServiceUser(username) {
-					users = append(users, username)
-				}
-			}
+	names, ok := listEntryNames("passwd", passwdFile)
+	if !ok {
+		// Neither NSS nor the flat file could be enumerated (hardened or
+		// container systems); probe well-known service users individually
+		names = probeNames(serviceUserNames, func(name string) error {
+			_, err := user.Lookup(name)
+			return err
+		})
+	}
+
+	for _, username := range names {
+		if username != "root" && isServiceUser(username) {
+			users = append(users, username)
 		}
 	}
 
@@ -576,35 +2354,81 @@ func (v *Validator) getAvailableUsers() []string {
 func (v *Validator) getAvailableGroups() []string {
 	groups := []string{"root"} // Always include root
 
-	// Try to read /etc/group for more groups
-	if content, err := os.ReadFile("/etc/group"); err == nil {
-		lines := strings.Split(string(content), "\n")
-		for _, line := range lines {
-			if line == "" {
-				continue
-			}
-			parts := strings.Split(line, ":")
-			if len(parts) > 0 && parts[0] != "root" {
-				groupname := parts[0]
-				if isServiceGroup(groupname) {
-					groups = append(groups, groupname)
-				}
-			}
+	names, ok := listEntryNames("group", groupFile)
+	if !ok {
+		names = probeNames(serviceGroupNames, func(name string) error {
+			_, err := user.LookupGroup(name)
+			return err
+		})
+	}
+
+	for _, groupname := range names {
+		if groupname != "root" && isServiceGroup(groupname) {
+			groups = append(groups, groupname)
 		}
 	}
 
 	return groups[:min(len(groups), 10)] // Limit to 10 suggestions
 }
 
-// isServiceUser checks if a username looks like a service user
-func isServiceUser(username string) bool {
-	serviceUsers := []string{
-		"nginx", "apache", "www-data", "caddy",
-		"postgres", "mysql", "redis",
-		"docker", "systemd", "nobody",
+// listEntryNames enumerates names from an NSS database. getent is preferred
+// since it reflects the real lookup backend (LDAP, systemd-homed, ...); the
+// flat file is only used when getent is unavailable.
+func listEntryNames(database, file string) ([]string, bool) {
+	if output, err := exec.Command(getentCommand, database).Output(); err == nil {
+		return parseEntryNames(string(output)), true
+	}
+
+	if content, err := os.ReadFile(file); err == nil {
+		return parseEntryNames(string(content)), true
+	}
+
+	return nil, false
+}
+
+// parseEntryNames extracts the name column from passwd/group formatted data
+func parseEntryNames(content string) []string {
+	var names []string
+	for _, line := range strings.Split(content, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.Split(line, ":")
+		if parts[0] != "" {
+			names = append(names, parts[0])
+		}
+	}
+	return names
+}
+
+// probeNames returns the candidates that lookup can resolve
+func probeNames(candidates []string, lookup func(string) error) []string {
+	var found []string
+	for _, name := range candidates {
+		if lookup(name) == nil {
+			found = append(found, name)
+		}
 	}
+	return found
+}
 
-	for _, service := range serviceUsers {
+// serviceUserNames are users worth suggesting in ownership errors
+var serviceUserNames = []string{
+	"nginx", "apache", "www-data", "caddy",
+	"postgres", "mysql", "redis",
+	"docker", "systemd", "nobody",
+}
+
+// serviceGroupNames are groups worth suggesting in ownership errors
+var serviceGroupNames = []string{
+	"nginx", "apache", "www-data", "caddy",
+	"postgres", "mysql", "redis",
+	"docker", "systemd", "nobody", "ssl-cert",
+}
+
+// isServiceUser checks if a username looks like a service user
+func isServiceUser(username string) bool {
+	for _, service := range serviceUserNames {
 		if username == service {
 			return true
 		}
@@ -615,13 +2439,7 @@ func isServiceUser(username string) bool {
 
 // isServiceGroup checks if a groupname looks like a service group
 func isServiceGroup(groupname string) bool {
-	serviceGroups := []string{
-		"nginx", "apache", "www-data", "caddy",
-		"postgres", "mysql", "redis",
-		"docker", "systemd", "nobody", "ssl-cert",
-	}
-
-	for _, service := range serviceGroups {
+	for _, service := range serviceGroupNames {
 		if groupname == service {
 			return true
 		}
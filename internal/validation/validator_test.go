@@ -2,10 +2,13 @@ package validation
 
 import (
 	"os"
+	"os/user"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/brizzbuzz/opnix/internal/errors"
+	"github.com/brizzbuzz/opnix/internal/warnings"
 )
 
 func TestValidator_ValidateConfig(t *testing.T) {
@@ -94,6 +97,17 @@ func TestValidator_ValidateReference(t *testing.T) {
 			reference: "op://Vault/Item/field",
 			wantError: false,
 		},
+		{
+			name:      "local reference",
+			reference: "local://db-password",
+			wantError: false,
+		},
+		{
+			name:      "local reference without a name",
+			reference: "local://",
+			wantError: true,
+			errorType: "does not name an entry",
+		},
 		{
 			name:      "invalid format - no op prefix",
 			reference: "vault/item/field",
@@ -111,6 +125,16 @@ func TestValidator_ValidateReference(t *testing.T) {
 			reference: "op://Vault/Item/Section/field",
 			wantError: false,
 		},
+		{
+			name:      "valid format - vault ID",
+			reference: "op://7xkq2mzv4bnlpd3rtyw8hc5ej6/Item/field",
+			wantError: false,
+		},
+		{
+			name:      "valid format - vault and item ID",
+			reference: "op://7xkq2mzv4bnlpd3rtyw8hc5ej6/q4n7ro2yjv5xk3bm8tz6wpa9lc/field",
+			wantError: false,
+		},
 		{
 			name:      "valid format - with nested sections",
 			reference: "op://Homelab/Cloudflare Origin Certs/rgbr.ink/cert",
@@ -133,118 +157,1057 @@ func TestValidator_ValidateReference(t *testing.T) {
 			errorType: "Field name cannot be empty",
 		},
 		{
-			name:      "empty vault",
-			reference: "op:///Item/field",
-			wantError: true,
-			errorType: "Vault name cannot be empty",
+			name:      "empty vault",
+			reference: "op:///Item/field",
+			wantError: true,
+			errorType: "Vault name cannot be empty",
+		},
+		{
+			name:      "empty item",
+			reference: "op://Vault//field",
+			wantError: true,
+			errorType: "Item name cannot be empty",
+		},
+		{
+			name:      "empty field",
+			reference: "op://Vault/Item/",
+			wantError: true,
+			errorType: "Field name cannot be empty",
+		},
+		{
+			name:      "valid complex reference",
+			reference: "op://My-Vault/Complex_Item-Name/custom.field",
+			wantError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateReference(tt.reference, nil, "test-secret")
+
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+					return
+				}
+				if tt.errorType != "" && !containsString(err.Error(), tt.errorType) {
+					t.Errorf("Expected error to contain %q, got: %v", tt.errorType, err)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Expected no error but got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestReferenceSuggestions(t *testing.T) {
+	byName := referenceSuggestions("Homelab", "Database", "first", "op item get 'Database' --vault 'Homelab'")
+	if len(byName) != 2 || byName[1] != "op item get 'Database' --vault 'Homelab'" {
+		t.Errorf("Expected name-based suggestions unchanged, got %v", byName)
+	}
+
+	byID := referenceSuggestions("7xkq2mzv4bnlpd3rtyw8hc5ej6", "Database", "first", "op item get 'Database' --vault 'Homelab'")
+	for _, suggestion := range byID {
+		if containsString(suggestion, "'Homelab'") {
+			t.Errorf("Expected name-based suggestions to be skipped for vault ID, got %v", byID)
+		}
+	}
+	if !containsString(byID[1], "op vault get 7xkq2mzv4bnlpd3rtyw8hc5ej6") {
+		t.Errorf("Expected ID-based suggestion, got %v", byID)
+	}
+}
+
+func TestValidator_ValidateReferenceAllowedVaults(t *testing.T) {
+	validator := NewValidator()
+	allowed := []string{"Homelab", "Shared"}
+
+	tests := []struct {
+		name      string
+		reference string
+		allowed   []string
+		wantError bool
+	}{
+		{
+			name:      "vault in allowlist",
+			reference: "op://Homelab/Database/password",
+			allowed:   allowed,
+			wantError: false,
+		},
+		{
+			name:      "vault not in allowlist",
+			reference: "op://Personal/Database/password",
+			allowed:   allowed,
+			wantError: true,
+		},
+		{
+			name:      "vault ID in allowlist",
+			reference: "op://7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password",
+			allowed:   []string{"7xkq2mzv4bnlpd3rtyw8hc5ej6"},
+			wantError: false,
+		},
+		{
+			name:      "vault ID not covered by vault name",
+			reference: "op://7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password",
+			allowed:   allowed,
+			wantError: true,
+		},
+		{
+			name:      "qualified vault allowed by name",
+			reference: "op://Homelab@work/Database/password",
+			allowed:   allowed,
+			wantError: false,
+		},
+		{
+			name:      "qualified vault allowed by ID",
+			reference: "op://Personal@7xkq2mzv4bnlpd3rtyw8hc5ej6/Database/password",
+			allowed:   []string{"7xkq2mzv4bnlpd3rtyw8hc5ej6"},
+			wantError: false,
+		},
+		{
+			name:      "empty allowlist allows any vault",
+			reference: "op://Personal/Database/password",
+			allowed:   nil,
+			wantError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateReference(tt.reference, tt.allowed, "test-secret")
+
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+					return
+				}
+				if !containsString(err.Error(), "not in the allowedVaults list") {
+					t.Errorf("Expected allowedVaults error, got: %v", err)
+				}
+			} else if err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidator_ValidatePath(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name      string
+		path      string
+		wantError bool
+		errorType string
+	}{
+		{
+			name:      "empty path",
+			path:      "",
+			wantError: true,
+			errorType: "Path cannot be empty",
+		},
+		{
+			name:      "valid relative path",
+			path:      "database/password",
+			wantError: false,
+		},
+		{
+			name:      "valid absolute path",
+			path:      "/etc/ssl/certs/app.pem",
+			wantError: false,
+		},
+		{
+			name:      "path traversal attempt",
+			path:      "../../../etc/passwd",
+			wantError: true,
+			errorType: "Path traversal detected",
+		},
+		{
+			name:      "dangerous absolute path - /etc/passwd",
+			path:      "/etc/passwd",
+			wantError: true,
+			errorType: "potentially dangerous location",
+		},
+		{
+			name:      "dangerous absolute path - /bin",
+			path:      "/bin/something",
+			wantError: true,
+			errorType: "potentially dangerous location",
+		},
+		{
+			name:      "safe absolute path",
+			path:      "/var/lib/app/secret",
+			wantError: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seenPaths := make(map[string]string)
+			err := validator.validatePath(tt.path, "test-secret", seenPaths)
+
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Expected error but got none")
+					return
+				}
+				if tt.errorType != "" && !containsString(err.Error(), tt.errorType) {
+					t.Errorf("Expected error to contain %q, got: %v", tt.errorType, err)
+				}
+			} else {
+				if err != nil {
+					t.Errorf("Expected no error but got: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestValidator_ValidatePathSymlinkedParent(t *testing.T) {
+	validator := NewValidator()
+	tmpDir := t.TempDir()
+
+	// A safe-looking directory that actually points into /proc
+	link := filepath.Join(tmpDir, "secrets")
+	if err := os.Symlink("/proc", link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	t.Run("symlinked parent into dangerous location", func(t *testing.T) {
+		err := validator.validatePath(filepath.Join(link, "app", "token"), "test-secret", make(map[string]string))
+		if err == nil {
+			t.Fatal("Expected error for symlinked parent, got none")
+		}
+		if !containsString(err.Error(), "symlinked parent directory") {
+			t.Errorf("Expected symlinked parent error, got: %v", err)
+		}
+	})
+
+	t.Run("parent does not exist yet", func(t *testing.T) {
+		path := filepath.Join(tmpDir, "not", "created", "yet", "token")
+		if err := validator.validatePath(path, "test-secret", make(map[string]string)); err != nil {
+			t.Errorf("Expected no error for missing parent, got: %v", err)
+		}
+	})
+}
+
+func TestEvalParentSymlinks(t *testing.T) {
+	tmpDir := t.TempDir()
+	realDir := filepath.Join(tmpDir, "real")
+	if err := os.Mkdir(realDir, 0755); err != nil {
+		t.Fatalf("Failed to create dir: %v", err)
+	}
+	link := filepath.Join(tmpDir, "link")
+	if err := os.Symlink(realDir, link); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	// tmpDir itself may live behind a symlink (e.g. /tmp on macOS)
+	expectedRoot, err := filepath.EvalSymlinks(realDir)
+	if err != nil {
+		t.Fatalf("Failed to resolve temp dir: %v", err)
+	}
+
+	got, err := EvalParentSymlinks(filepath.Join(link, "missing", "file"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if want := filepath.Join(expectedRoot, "missing", "file"); got != want {
+		t.Errorf("Expected %s, got %s", want, got)
+	}
+}
+
+func TestValidator_TemplatedPathCollisions(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secrets []SecretData
+	}{
+		{
+			name: "path template with the same variables",
+			secrets: []SecretData{
+				{Reference: "op://Vault/App/token", PathTemplate: "{service}/{name}", Variables: map[string]string{"service": "app", "name": "token"}},
+				{Reference: "op://Vault/App/other", PathTemplate: "{service}/{name}", Variables: map[string]string{"service": "app", "name": "token"}},
+			},
+		},
+		{
+			name: "variable from defaults matches a literal path",
+			secrets: []SecretData{
+				{Path: "{env}/db/password", Reference: "op://Vault/DB/password", Defaults: map[string]string{"env": "prod"}},
+				{Path: "prod/db/password", Reference: "op://Vault/DB/other"},
+			},
+		},
+		{
+			name: "substitution leaves a doubled separator",
+			secrets: []SecretData{
+				{Path: "{dir}/key", Reference: "op://Vault/TLS/key", Variables: map[string]string{"dir": "tls/"}},
+				{Path: "tls/key", Reference: "op://Vault/TLS/other"},
+			},
+		},
+		{
+			name: "symlink onto a templated path",
+			secrets: []SecretData{
+				{Path: "{dir}/cert.pem", Reference: "op://Vault/TLS/cert", Variables: map[string]string{"dir": "/etc/ssl/app/"}},
+				{Path: "other.pem", Reference: "op://Vault/TLS/other", Symlinks: []string{"/etc/ssl/app/cert.pem"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct(tt.secrets)
+			if err == nil || !containsString(err.Error(), "Duplicate") {
+				t.Errorf("Expected a duplicate path error, got: %v", err)
+			}
+		})
+	}
+
+	distinct := []SecretData{
+		{Reference: "op://Vault/App/token", PathTemplate: "{service}/{name}", Variables: map[string]string{"service": "app", "name": "token"}},
+		{Reference: "op://Vault/Web/token", PathTemplate: "{service}/{name}", Variables: map[string]string{"service": "web", "name": "token"}},
+	}
+	if err := validator.ValidateConfigStruct(distinct); err != nil {
+		t.Errorf("Expected distinct resolved paths to pass, got: %v", err)
+	}
+}
+
+func TestValidator_PathPrefix(t *testing.T) {
+	validator := NewValidator()
+
+	t.Run("duplicate detection uses prefixed path", func(t *testing.T) {
+		err := validator.ValidateConfigStruct([]SecretData{
+			{Path: "app/token", Reference: "op://Vault/Item/field"},
+			{Path: "token", Reference: "op://Vault/Item/other", PathPrefix: "app"},
+		})
+		if err == nil || !containsString(err.Error(), "Duplicate path") {
+			t.Errorf("Expected duplicate path error, got: %v", err)
+		}
+	})
+
+	t.Run("absolute paths ignore prefix", func(t *testing.T) {
+		got, err := validator.applyPathPrefix("/etc/app/token", "app", "test-secret")
+		if err != nil || got != "/etc/app/token" {
+			t.Errorf("Expected absolute path unchanged, got %q (err: %v)", got, err)
+		}
+	})
+
+	t.Run("absolute prefix rejected", func(t *testing.T) {
+		if _, err := validator.applyPathPrefix("token", "/etc", "test-secret"); err == nil {
+			t.Error("Expected error for absolute pathPrefix")
+		}
+	})
+
+	t.Run("traversal in prefix rejected", func(t *testing.T) {
+		if _, err := validator.applyPathPrefix("token", "../outside", "test-secret"); err == nil {
+			t.Error("Expected error for pathPrefix containing '..'")
+		}
+	})
+}
+
+func TestValidator_EnvFile(t *testing.T) {
+	validator := NewValidator()
+
+	t.Run("valid entries", func(t *testing.T) {
+		err := validator.ValidateConfigStruct([]SecretData{{
+			Path: "app/.env",
+			EnvFile: []EnvFileEntry{
+				{Key: "DB_PASSWORD", Reference: "op://Vault/Database/password"},
+				{Key: "API_KEY", Reference: "op://Vault/Api/key"},
+			},
+		}})
+		if err != nil {
+			t.Errorf("Expected valid env-file secret, got: %v", err)
+		}
+	})
+
+	t.Run("duplicate key names both references", func(t *testing.T) {
+		err := validator.ValidateConfigStruct([]SecretData{{
+			Path: "app/.env",
+			EnvFile: []EnvFileEntry{
+				{Key: "TOKEN", Reference: "op://Vault/One/token"},
+				{Key: "TOKEN", Reference: "op://Vault/Two/token"},
+			},
+		}})
+		if err == nil {
+			t.Fatal("Expected duplicate key error")
+		}
+		if !containsString(err.Error(), "op://Vault/One/token") || !containsString(err.Error(), "op://Vault/Two/token") {
+			t.Errorf("Expected both references in error, got: %v", err)
+		}
+	})
+
+	t.Run("invalid key", func(t *testing.T) {
+		err := validator.ValidateConfigStruct([]SecretData{{
+			Path:    "app/.env",
+			EnvFile: []EnvFileEntry{{Key: "MY-KEY", Reference: "op://Vault/Item/field"}},
+		}})
+		if err == nil || !containsString(err.Error(), "shell identifier") {
+			t.Errorf("Expected invalid identifier error, got: %v", err)
+		}
+	})
+
+	t.Run("reference and envFile both set", func(t *testing.T) {
+		err := validator.ValidateConfigStruct([]SecretData{{
+			Path:      "app/.env",
+			Reference: "op://Vault/Item/field",
+			EnvFile:   []EnvFileEntry{{Key: "KEY", Reference: "op://Vault/Item/other"}},
+		}})
+		if err == nil {
+			t.Error("Expected error when both reference and envFile are set")
+		}
+	})
+}
+
+func TestValidator_SymlinkDirMode(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		mode    string
+		wantErr bool
+	}{
+		{name: "unset", mode: ""},
+		{name: "private", mode: "0700"},
+		{name: "group readable", mode: "0750"},
+		{name: "not octal", mode: "0799", wantErr: true},
+		{name: "world writable", mode: "0777", wantErr: true},
+		{name: "no owner search", mode: "0600", wantErr: true},
+		{name: "setgid group-shared", mode: "2750"},
+		{name: "sticky", mode: "1750"},
+		{name: "setuid", mode: "4750", wantErr: true},
+		{name: "sticky but world writable", mode: "1777", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{{
+				Path:           "app/token",
+				Reference:      "op://Vault/Item/field",
+				Symlinks:       []string{"/etc/app/token"},
+				SymlinkDirMode: tt.mode,
+			}})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_Extract(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{
+			name:   "valid pattern",
+			secret: SecretData{Path: "token", Reference: "op://Vault/Item/url", Extract: `token=([^&]+)`},
+		},
+		{
+			name:    "invalid pattern",
+			secret:  SecretData{Path: "token", Reference: "op://Vault/Item/url", Extract: `token=([^&]+`},
+			wantErr: true,
+		},
+		{
+			name:    "no capture group",
+			secret:  SecretData{Path: "token", Reference: "op://Vault/Item/url", Extract: `token=[^&]+`},
+			wantErr: true,
+		},
+		{
+			name: "extract on envFile secret",
+			secret: SecretData{
+				Path:    "app/.env",
+				EnvFile: []EnvFileEntry{{Key: "TOKEN", Reference: "op://Vault/Item/url"}},
+				Extract: `token=([^&]+)`,
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_Transforms(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name       string
+		transforms []string
+		wantErr    bool
+	}{
+		{name: "pipeline", transforms: []string{`extract:token=([^&]+)`, "base64decode", "trim", "trimNewline", "base64encode"}},
+		{name: "unknown step", transforms: []string{"trim", "rot13"}, wantErr: true},
+		{name: "extract without group", transforms: []string{"extract:token=[^&]+"}, wantErr: true},
+		{name: "extract without pattern", transforms: []string{"extract"}, wantErr: true},
+		{name: "argument to trim", transforms: []string{"trim:x"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := SecretData{Path: "token", Reference: "op://Vault/Item/url", Transforms: tt.transforms}
+			err := validator.ValidateConfigStruct([]SecretData{secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_OnlyIf(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{
+			name:   "flag file and command",
+			secret: SecretData{Path: "key", Reference: "op://Vault/Item/key", OnlyIf: true, OnlyIfExists: "/etc/flags/key", OnlyIfCommand: []string{"/bin/true"}, OnlyIfTimeout: "5s"},
+		},
+		{
+			name:    "no condition",
+			secret:  SecretData{Path: "key", Reference: "op://Vault/Item/key", OnlyIf: true},
+			wantErr: true,
+		},
+		{
+			name:    "relative flag file",
+			secret:  SecretData{Path: "key", Reference: "op://Vault/Item/key", OnlyIf: true, OnlyIfExists: "flags/key"},
+			wantErr: true,
+		},
+		{
+			name:    "command looked up in PATH",
+			secret:  SecretData{Path: "key", Reference: "op://Vault/Item/key", OnlyIf: true, OnlyIfCommand: []string{"true"}},
+			wantErr: true,
+		},
+		{
+			name:    "timeout without command",
+			secret:  SecretData{Path: "key", Reference: "op://Vault/Item/key", OnlyIf: true, OnlyIfExists: "/etc/flags/key", OnlyIfTimeout: "5s"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid timeout",
+			secret:  SecretData{Path: "key", Reference: "op://Vault/Item/key", OnlyIf: true, OnlyIfCommand: []string{"/bin/true"}, OnlyIfTimeout: "soon"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_FieldFallbacks(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{
+			name:   "valid fallbacks",
+			secret: SecretData{Path: "token", Reference: "op://Vault/Item/credential", FieldFallbacks: []string{"password"}},
+		},
+		{
+			name:    "fallback with slash",
+			secret:  SecretData{Path: "token", Reference: "op://Vault/Item/credential", FieldFallbacks: []string{"section/password"}},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate fallback",
+			secret:  SecretData{Path: "token", Reference: "op://Vault/Item/credential", FieldFallbacks: []string{"password", "password"}},
+			wantErr: true,
+		},
+		{
+			name: "fallbacks on envFile secret",
+			secret: SecretData{
+				Path:           "app/.env",
+				EnvFile:        []EnvFileEntry{{Key: "TOKEN", Reference: "op://Vault/Item/credential"}},
+				FieldFallbacks: []string{"password"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_Bundle(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{
+			name:   "valid bundle",
+			secret: SecretData{Path: "ca.pem", Bundle: []string{"op://Vault/Root CA/cert", "op://Vault/Intermediate CA/cert"}},
+		},
+		{
+			name:    "bundle with reference",
+			secret:  SecretData{Path: "ca.pem", Reference: "op://Vault/Root CA/cert", Bundle: []string{"op://Vault/Intermediate CA/cert"}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid bundle reference",
+			secret:  SecretData{Path: "ca.pem", Bundle: []string{"op://Vault/Root CA/cert", "Vault/Intermediate CA/cert"}},
+			wantErr: true,
+		},
+		{
+			name: "bundle with envFile",
+			secret: SecretData{
+				Path:    "ca.pem",
+				Bundle:  []string{"op://Vault/Root CA/cert"},
+				EnvFile: []EnvFileEntry{{Key: "TOKEN", Reference: "op://Vault/Item/credential"}},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_SSHKeys(t *testing.T) {
+	validator := NewValidator()
+	keys := []string{"op://Vault/Alice/public key", "op://Vault/Bob/public key"}
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{name: "authorized_keys", secret: SecretData{Path: "authorized_keys", SSHKeys: keys}},
+		{name: "known_hosts", secret: SecretData{Path: "known_hosts", SSHKeys: keys, SSHKeysFormat: "known_hosts"}},
+		{name: "unknown format", secret: SecretData{Path: "keys", SSHKeys: keys, SSHKeysFormat: "ssh_config"}, wantErr: true},
+		{name: "format without sshKeys", secret: SecretData{Path: "keys", Reference: keys[0], SSHKeysFormat: "known_hosts"}, wantErr: true},
+		{name: "sshKeys with reference", secret: SecretData{Path: "keys", Reference: keys[0], SSHKeys: keys[1:]}, wantErr: true},
+		{name: "sshKeys with bundle", secret: SecretData{Path: "keys", SSHKeys: keys, Bundle: []string{"op://Vault/Root CA/cert"}}, wantErr: true},
+		{name: "invalid key reference", secret: SecretData{Path: "keys", SSHKeys: []string{"Vault/Alice/public key"}}, wantErr: true},
+		{name: "canonical sshKeys", secret: SecretData{Path: "keys", SSHKeys: keys, Canonical: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_Region(t *testing.T) {
+	validator := NewValidator()
+	region := func(name string) SecretData {
+		return SecretData{Path: "app.conf", Reference: "op://Vault/App/" + name, Region: true, RegionName: name}
+	}
+
+	tests := []struct {
+		name    string
+		secrets []SecretData
+		wantErr bool
+	}{
+		{name: "regions with distinct names share a file", secrets: []SecretData{region("db"), region("api")}},
+		{name: "same markers twice", secrets: []SecretData{region("db"), region("db")}, wantErr: true},
+		{name: "region after a whole-file secret", secrets: []SecretData{{Path: "app.conf", Reference: "op://Vault/App/file"}, region("db")}, wantErr: true},
+		{name: "whole-file secret after a region", secrets: []SecretData{region("db"), {Path: "app.conf", Reference: "op://Vault/App/file"}}, wantErr: true},
+		{name: "region with fifo", secrets: []SecretData{{Path: "app.conf", Reference: "op://Vault/App/db", Region: true, FIFO: true}}, wantErr: true},
+		{name: "multi-line name", secrets: []SecretData{region("db\nextra")}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct(tt.secrets)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_INI(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{
+			name: "valid entries",
+			secret: SecretData{Path: "app.ini", INI: []INIEntry{
+				{Key: "name", Reference: "op://Vault/App/name"},
+				{Section: "database", Key: "password", Reference: "op://Vault/Database/password"},
+			}},
+		},
+		{
+			name: "same key in different sections",
+			secret: SecretData{Path: "app.ini", INI: []INIEntry{
+				{Section: "primary", Key: "password", Reference: "op://Vault/Primary/password"},
+				{Section: "replica", Key: "password", Reference: "op://Vault/Replica/password"},
+			}},
+		},
+		{
+			name: "duplicate key in section",
+			secret: SecretData{Path: "app.ini", INI: []INIEntry{
+				{Section: "database", Key: "password", Reference: "op://Vault/One/password"},
+				{Section: "database", Key: "password", Reference: "op://Vault/Two/password"},
+			}},
+			wantErr: true,
+		},
+		{
+			name:    "section with bracket",
+			secret:  SecretData{Path: "app.ini", INI: []INIEntry{{Section: "db]", Key: "password", Reference: "op://Vault/Database/password"}}},
+			wantErr: true,
+		},
+		{
+			name:    "key with equals sign",
+			secret:  SecretData{Path: "app.ini", INI: []INIEntry{{Key: "a=b", Reference: "op://Vault/Database/password"}}},
+			wantErr: true,
+		},
+		{
+			name: "ini with bundle",
+			secret: SecretData{
+				Path:   "app.ini",
+				INI:    []INIEntry{{Key: "name", Reference: "op://Vault/App/name"}},
+				Bundle: []string{"op://Vault/Root CA/cert"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_FieldType(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{
+			name:   "password type",
+			secret: SecretData{Path: "db/password", Reference: "op://Vault/Database/password", Type: "password"},
+		},
+		{
+			name:   "sshKey type",
+			secret: SecretData{Path: "ssh/key", Reference: "op://Vault/Deploy Key/private key", Type: "sshKey"},
+		},
+		{
+			name:    "unknown type",
+			secret:  SecretData{Path: "db/password", Reference: "op://Vault/Database/password", Type: "secret"},
+			wantErr: true,
+		},
+		{
+			name: "type on envFile secret",
+			secret: SecretData{
+				Path:    "app/.env",
+				EnvFile: []EnvFileEntry{{Key: "TOKEN", Reference: "op://Vault/Item/credential"}},
+				Type:    "concealed",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_ValidateWith(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{name: "absolute command", secret: SecretData{ValidateWith: []string{"/usr/bin/openssl", "x509", "-noout", "-in"}, ValidateTimeout: "10s"}},
+		{name: "relative command", secret: SecretData{ValidateWith: []string{"openssl"}}, wantErr: true},
+		{name: "timeout without command", secret: SecretData{ValidateTimeout: "10s"}, wantErr: true},
+		{name: "invalid timeout", secret: SecretData{ValidateWith: []string{"/usr/bin/true"}, ValidateTimeout: "soon"}, wantErr: true},
+		{name: "fifo secret", secret: SecretData{ValidateWith: []string{"/usr/bin/true"}, FIFO: true}, wantErr: true},
+		{name: "skipIfExists on fifo", secret: SecretData{SkipIfExists: true, FIFO: true}, wantErr: true},
+		{name: "absolute filter", secret: SecretData{Filter: []string{"/usr/bin/age", "-d", "-i", "/etc/age/key"}, FilterTimeout: "5s"}},
+		{name: "relative filter", secret: SecretData{Filter: []string{"jq", "-r", ".password"}}, wantErr: true},
+		{name: "filter timeout without filter", secret: SecretData{FilterTimeout: "5s"}, wantErr: true},
+		{name: "invalid filter timeout", secret: SecretData{Filter: []string{"/usr/bin/jq"}, FilterTimeout: "-1s"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.secret.Path = "tls/cert.pem"
+			tt.secret.Reference = "op://Vault/Item/cert"
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_Copies(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{name: "encoded copies", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", Encoding: "base64"}, {Path: "tls/key.hex", Encoding: "hex"}, {Path: "legacy/key.pem"}}}},
+		{name: "unknown encoding", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b32", Encoding: "base32"}}}, wantErr: true},
+		{name: "empty path", secret: SecretData{Copies: []SecretCopy{{Encoding: "base64"}}}, wantErr: true},
+		{name: "same path as the secret", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.pem", Encoding: "base64"}}}, wantErr: true},
+		{name: "fifo secret", secret: SecretData{FIFO: true, Copies: []SecretCopy{{Path: "tls/key.b64", Encoding: "base64"}}}, wantErr: true},
+		{name: "own mode and owner", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", Owner: "root", Group: "root", Mode: "0640"}}}},
+		{name: "unknown copy owner", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", Owner: "nonexistentuser12345"}}}, wantErr: true},
+		{name: "unknown copy group", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", Group: "nonexistentgroup12345"}}}, wantErr: true},
+		{name: "invalid copy mode", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", Mode: "0999"}}}, wantErr: true},
+		{name: "world-writable copy", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", Mode: "0666"}}}, wantErr: true},
+		{name: "readable by root", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", ReadableBy: []string{"root"}}}}},
+		{name: "unknown reader", secret: SecretData{Copies: []SecretCopy{{Path: "tls/key.b64", ReadableBy: []string{"nonexistentuser12345"}}}}, wantErr: true},
+		{name: "unknown reader with preserved mode", secret: SecretData{Mode: "preserve", Copies: []SecretCopy{{Path: "tls/key.b64", ReadableBy: []string{"nonexistentuser12345"}}}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.secret.Path = "tls/key.pem"
+			tt.secret.Reference = "op://Vault/TLS/key"
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	item := SecretData{Item: "op://Vault/TLS", Fields: map[string]string{"key": "tls/key.pem"}, Copies: []SecretCopy{{Path: "tls/key.b64", Encoding: "base64"}}}
+	if err := validator.ValidateConfigStruct([]SecretData{item}); err == nil {
+		t.Error("Expected copies on an item secret to be rejected")
+	}
+}
+
+func TestValidator_CopyReaders(t *testing.T) {
+	reader, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("No 'nobody' user to read copies as")
+	}
+	group, err := user.LookupGroupId(reader.Gid)
+	if err != nil {
+		t.Skip("No primary group for 'nobody'")
+	}
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		copy    SecretCopy
+		wantErr bool
+	}{
+		{name: "default mode owned by root", copy: SecretCopy{}, wantErr: true},
+		{name: "owned by the reader", copy: SecretCopy{Owner: "nobody"}},
+		{name: "owner inherited from the secret", secret: SecretData{Owner: "nobody"}, copy: SecretCopy{}},
+		{name: "owner without read", copy: SecretCopy{Owner: "nobody", Mode: "0200"}, wantErr: true},
+		{name: "group readable", copy: SecretCopy{Group: group.Name, Mode: "0640"}},
+		{name: "group without read", copy: SecretCopy{Group: group.Name, Mode: "0600"}, wantErr: true},
+		{name: "world readable", copy: SecretCopy{Mode: "0644"}},
+		{name: "stricter than the secret", secret: SecretData{Owner: "nobody"}, copy: SecretCopy{Owner: "root"}, wantErr: true},
+		{name: "authorized keys default", secret: SecretData{SSHKeys: []string{"op://Vault/Deploy/public key"}}, copy: SecretCopy{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.copy.Path = "shared/key"
+			tt.copy.ReadableBy = []string{"nobody"}
+			tt.secret.Path = "tls/key.pem"
+			if tt.secret.SSHKeys == nil {
+				tt.secret.Reference = "op://Vault/TLS/key"
+			}
+			tt.secret.Copies = []SecretCopy{tt.copy}
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidator_ItemFields(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name    string
+		secret  SecretData
+		wantErr bool
+	}{
+		{
+			name: "valid item fields",
+			secret: SecretData{
+				Item:   "op://Vault/Database",
+				Fields: map[string]string{"username": "db/user", "password": "db/password"},
+			},
 		},
 		{
-			name:      "empty item",
-			reference: "op://Vault//field",
-			wantError: true,
-			errorType: "Item name cannot be empty",
+			name:    "item without fields",
+			secret:  SecretData{Item: "op://Vault/Database"},
+			wantErr: true,
 		},
 		{
-			name:      "empty field",
-			reference: "op://Vault/Item/",
-			wantError: true,
-			errorType: "Field name cannot be empty",
+			name: "item with reference",
+			secret: SecretData{
+				Item:      "op://Vault/Database",
+				Reference: "op://Vault/Database/password",
+				Fields:    map[string]string{"password": "db/password"},
+			},
+			wantErr: true,
 		},
 		{
-			name:      "valid complex reference",
-			reference: "op://My-Vault/Complex_Item-Name/custom.field",
-			wantError: false,
+			name: "item including a field",
+			secret: SecretData{
+				Item:   "op://Vault/Database/password",
+				Fields: map[string]string{"username": "db/user"},
+			},
+			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			err := validator.validateReference(tt.reference, "test-secret")
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateConfigStruct() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
 
-			if tt.wantError {
-				if err == nil {
-					t.Errorf("Expected error but got none")
-					return
-				}
-				if tt.errorType != "" && !containsString(err.Error(), tt.errorType) {
-					t.Errorf("Expected error to contain %q, got: %v", tt.errorType, err)
-				}
-			} else {
+func TestValidator_Account(t *testing.T) {
+	validator := NewValidator()
+	accounts := map[string]string{"team": "/etc/opnix-team-token", "broken": ""}
+
+	tests := []struct {
+		name    string
+		account string
+		wantErr string
+	}{
+		{name: "default account", account: ""},
+		{name: "defined account", account: "team"},
+		{name: "undefined account", account: "personal", wantErr: "not defined"},
+		{name: "account without token file", account: "broken", wantErr: "no tokenFile"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateConfigStruct([]SecretData{{
+				Path:      "secret",
+				Reference: "op://Vault/Item/field",
+				Account:   tt.account,
+				Accounts:  accounts,
+			}})
+			if tt.wantErr == "" {
 				if err != nil {
-					t.Errorf("Expected no error but got: %v", err)
+					t.Errorf("Expected no error, got: %v", err)
 				}
+				return
+			}
+			if err == nil || !containsString(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got: %v", tt.wantErr, err)
 			}
 		})
 	}
 }
 
-func TestValidator_ValidatePath(t *testing.T) {
+func TestValidator_BaseDir(t *testing.T) {
 	validator := NewValidator()
 
 	tests := []struct {
 		name      string
-		path      string
+		secret    SecretData
 		wantError bool
-		errorType string
 	}{
 		{
-			name:      "empty path",
-			path:      "",
-			wantError: true,
-			errorType: "Path cannot be empty",
-		},
-		{
-			name:      "valid relative path",
-			path:      "database/password",
-			wantError: false,
+			name:   "absolute path under base",
+			secret: SecretData{Path: "/run/secrets/app/token", BaseDir: "/run/secrets"},
 		},
 		{
-			name:      "valid absolute path",
-			path:      "/etc/ssl/certs/app.pem",
-			wantError: false,
+			name:   "relative path deferred to processing",
+			secret: SecretData{Path: "app/token", BaseDir: "/run/secrets"},
 		},
 		{
-			name:      "path traversal attempt",
-			path:      "../../../etc/passwd",
+			name:      "absolute path outside base",
+			secret:    SecretData{Path: "/var/lib/app/token", BaseDir: "/run/secrets"},
 			wantError: true,
-			errorType: "Path traversal detected",
 		},
 		{
-			name:      "dangerous absolute path - /etc/passwd",
-			path:      "/etc/passwd",
+			name:      "sibling with shared prefix",
+			secret:    SecretData{Path: "/run/secrets-old/token", BaseDir: "/run/secrets"},
 			wantError: true,
-			errorType: "potentially dangerous location",
 		},
 		{
-			name:      "dangerous absolute path - /bin",
-			path:      "/bin/something",
+			name:      "symlink outside base",
+			secret:    SecretData{Path: "/run/secrets/token", Symlinks: []string{"/var/lib/app/token"}, BaseDir: "/run/secrets"},
 			wantError: true,
-			errorType: "potentially dangerous location",
 		},
 		{
-			name:      "safe absolute path",
-			path:      "/var/lib/app/secret",
-			wantError: false,
+			name:      "relative base rejected",
+			secret:    SecretData{Path: "app/token", BaseDir: "run/secrets"},
+			wantError: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			seenPaths := make(map[string]string)
-			err := validator.validatePath(tt.path, "test-secret", seenPaths)
-
-			if tt.wantError {
-				if err == nil {
-					t.Errorf("Expected error but got none")
-					return
-				}
-				if tt.errorType != "" && !containsString(err.Error(), tt.errorType) {
-					t.Errorf("Expected error to contain %q, got: %v", tt.errorType, err)
-				}
-			} else {
-				if err != nil {
-					t.Errorf("Expected no error but got: %v", err)
-				}
+			tt.secret.Reference = "op://Vault/Item/field"
+			err := validator.ValidateConfigStruct([]SecretData{tt.secret})
+			if tt.wantError && err == nil {
+				t.Error("Expected error but got none")
+			} else if !tt.wantError && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
 			}
 		})
 	}
@@ -274,6 +1237,11 @@ func TestValidator_ValidateMode(t *testing.T) {
 			mode:      "0644",
 			wantError: false,
 		},
+		{
+			name:      "preserve existing mode",
+			mode:      "preserve",
+			wantError: false,
+		},
 		{
 			name:      "valid mode 0755",
 			mode:      "0755",
@@ -336,6 +1304,214 @@ func TestValidator_ValidateMode(t *testing.T) {
 	}
 }
 
+func TestValidator_WorldReadableModeWarning(t *testing.T) {
+	validator := NewValidator()
+	collector := warnings.NewCollector()
+	validator.SetWarnings(collector)
+
+	for _, mode := range []string{"0600", "0640", "0644"} {
+		if err := validator.validateMode(mode, "test-secret"); err != nil {
+			t.Fatalf("validateMode(%s) failed: %v", mode, err)
+		}
+	}
+
+	list := collector.List()
+	if len(list) != 1 || !strings.Contains(list[0].Message, "test-secret.mode 0644") {
+		t.Errorf("Expected one warning for the world-readable mode, got %+v", list)
+	}
+}
+
+func TestValidator_DifferingProcessingWarning(t *testing.T) {
+	validator := NewValidator()
+	collector := warnings.NewCollector()
+	validator.SetWarnings(collector)
+
+	secrets := []SecretData{
+		{Path: "api/key", Reference: "op://Vault/Api/key"},
+		{Path: "api/key.raw", Reference: "op://Vault/Api/key", Transforms: []string{"base64decode"}},
+		{Path: "api/key.copy", Reference: "op://Vault/Api/key"},
+		// Same processing everywhere is not worth a warning
+		{Path: "db/password", Reference: "op://Vault/Db/password", Transforms: []string{"trim"}},
+		{Path: "db/password.env", Reference: "op://Vault/Db/password", Transforms: []string{"trim"}},
+		// Templates and canonicalization change the written value too
+		{Path: "app/config.json", Reference: "op://Vault/App/config", Canonical: true},
+		{Path: "app/config.raw", Reference: "op://Vault/App/config"},
+		{Path: "smtp/env", Reference: "op://Vault/Smtp/password", Template: "SMTP_PASSWORD={{ .Secret }}"},
+		{Path: "smtp/password", Reference: "op://Vault/Smtp/password"},
+	}
+	if err := validator.ValidateConfigStruct(secrets); err != nil {
+		t.Fatalf("Differing processing must not fail validation: %v", err)
+	}
+
+	list := collector.List()
+	if len(list) != 3 {
+		t.Fatalf("Expected three warnings, got %+v", list)
+	}
+	for i, wants := range [][]string{
+		{"op://Vault/Api/key", "secret[0] (as resolved)", "secret[1] (transforms base64decode)", "secret[2]"},
+		{"op://Vault/App/config", "secret[5] (canonical)", "secret[6] (as resolved)"},
+		{"op://Vault/Smtp/password", `secret[7] (template "SMTP_PASSWORD={{ .Secret }}")`, "secret[8] (as resolved)"},
+	} {
+		for _, want := range wants {
+			if !strings.Contains(list[i].Message, want) {
+				t.Errorf("Expected warning %d to mention %q, got %q", i, want, list[i].Message)
+			}
+		}
+	}
+}
+
+func TestValidator_Unicode(t *testing.T) {
+	tests := []struct {
+		name      string
+		reference string
+		path      string
+		wantIssue string
+	}{
+		{name: "plain ascii", reference: "op://Vault/Item/password", path: "app/password"},
+		{name: "accented name", reference: "op://Vault/Caf\u00e9/password", path: "caf\u00e9/password"},
+		{name: "non-breaking space", reference: "op://My\u00a0Vault/Item/password", wantIssue: "non-breaking space (U+00A0) at character 8"},
+		{name: "zero-width space", path: "app/pass\u200bword", wantIssue: "zero-width space (U+200B)"},
+		{name: "combining accent", reference: "op://Vault/Cafe\u0301/password", wantIssue: "not NFC normalized"},
+		{name: "en dash", path: "app\u2013db/password", wantIssue: "en dash (U+2013)"},
+		{name: "full-width letter", reference: "op://Vault/\uff29tem/password", wantIssue: "full-width 'I'"},
+		{name: "cyrillic homoglyph", reference: "op://V\u0430ult/Item/password", wantIssue: "Cyrillic"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			validator := NewValidator()
+			collector := warnings.NewCollector()
+			validator.SetWarnings(collector)
+			if tt.reference != "" {
+				if err := validator.validateReference(tt.reference, nil, "test-secret"); err != nil {
+					t.Fatalf("validateReference failed: %v", err)
+				}
+			}
+			if tt.path != "" {
+				if err := validator.validatePath(tt.path, "test-secret", make(map[string]string)); err != nil {
+					t.Fatalf("validatePath failed: %v", err)
+				}
+			}
+
+			list := collector.List()
+			if tt.wantIssue == "" {
+				if len(list) != 0 {
+					t.Errorf("Expected no warnings, got %+v", list)
+				}
+				return
+			}
+			if len(list) != 1 || !strings.Contains(list[0].Message, tt.wantIssue) {
+				t.Errorf("Expected one warning about %q, got %+v", tt.wantIssue, list)
+			}
+
+			validator.SetUnicodeCheck(UnicodeCheckError)
+			err := validator.validateReference(tt.reference, nil, "test-secret")
+			if tt.path != "" {
+				err = validator.validatePath(tt.path, "test-secret", make(map[string]string))
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantIssue) {
+				t.Errorf("Expected error mode to fail with %q, got %v", tt.wantIssue, err)
+			}
+		})
+	}
+
+	if err := NewValidator().ValidateUnicodeCheck("fix"); err == nil {
+		t.Error("Expected an unknown unicodeCheck to be rejected")
+	}
+	if got := NormalizeUnicode("op://My\u00a0Vault/Item\ufeff/pass\u200bword"); got != "op://My Vault/Item/password" {
+		t.Errorf("NormalizeUnicode = %q", got)
+	}
+}
+
+func TestValidator_SpecialModeBits(t *testing.T) {
+	validator := NewValidator()
+	collector := warnings.NewCollector()
+	validator.SetWarnings(collector)
+
+	for _, mode := range []string{"4600", "4400", "6640"} {
+		err := validator.validateMode(mode, "test-secret")
+		if err == nil || !strings.Contains(err.Error(), "setuid") {
+			t.Errorf("validateMode(%s): expected setuid to be rejected, got %v", mode, err)
+		}
+	}
+
+	for _, mode := range []string{"2640", "1600"} {
+		if err := validator.validateMode(mode, "test-secret"); err != nil {
+			t.Errorf("validateMode(%s) failed: %v", mode, err)
+		}
+	}
+	list := collector.List()
+	if len(list) != 2 || !strings.Contains(list[0].Message, "has no effect") {
+		t.Errorf("Expected setgid and sticky on files to warn, got %+v", list)
+	}
+}
+
+func TestValidator_ValidateResolveOverrides(t *testing.T) {
+	validator := NewValidator()
+	negative := -1
+	zero := 0
+
+	tests := []struct {
+		name       string
+		maxRetries *int
+		timeout    string
+		wantError  bool
+	}{
+		{name: "no overrides", wantError: false},
+		{name: "zero retries", maxRetries: &zero, wantError: false},
+		{name: "negative retries", maxRetries: &negative, wantError: true},
+		{name: "valid timeout", timeout: "30s", wantError: false},
+		{name: "invalid timeout", timeout: "soon", wantError: true},
+		{name: "negative timeout", timeout: "-5s", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.validateResolveOverrides(tt.maxRetries, tt.timeout, "test-secret")
+			if tt.wantError && err == nil {
+				t.Errorf("Expected error but got none")
+			} else if !tt.wantError && err != nil {
+				t.Errorf("Expected no error but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestValidator_ValidateResolveCache(t *testing.T) {
+	validator := NewValidator()
+
+	tests := []struct {
+		name      string
+		file      string
+		ttl       string
+		keyFile   string
+		wantError bool
+	}{
+		{name: "disabled"},
+		{name: "defaults", file: "/var/lib/opnix/resolve-cache"},
+		{name: "all settings", file: "/var/lib/opnix/resolve-cache", ttl: "15m", keyFile: "/etc/opnix/cache-key"},
+		{name: "relative file", file: "resolve-cache", wantError: true},
+		{name: "relative key file", file: "/var/lib/opnix/resolve-cache", keyFile: "cache-key", wantError: true},
+		{name: "zero ttl", file: "/var/lib/opnix/resolve-cache", ttl: "0s", wantError: true},
+		{name: "ttl without file", ttl: "5m", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateResolveCache(tt.file, tt.ttl, tt.keyFile)
+			if (err != nil) != tt.wantError {
+				t.Errorf("ValidateResolveCache() error = %v, wantError %v", err, tt.wantError)
+			}
+		})
+	}
+
+	for cacheTTL, valid := range map[string]bool{"": true, "0": true, "1h": true, "-1m": false, "soon": false} {
+		if err := validator.validateCacheTTL(cacheTTL, "test-secret"); (err == nil) != valid {
+			t.Errorf("validateCacheTTL(%q) error = %v, want valid %v", cacheTTL, err, valid)
+		}
+	}
+}
+
 func TestValidator_ValidateUser(t *testing.T) {
 	validator := NewValidator()
 
@@ -554,6 +1730,44 @@ func TestValidator_GetAvailableGroups(t *testing.T) {
 	}
 }
 
+func TestValidator_GetAvailableUsersFallback(t *testing.T) {
+	// Simulate a hardened system where neither getent nor the flat files are usable
+	origGetent, origPasswd, origGroup := getentCommand, passwdFile, groupFile
+	getentCommand = "/nonexistent/getent"
+	passwdFile = "/nonexistent/passwd"
+	groupFile = "/nonexistent/group"
+	defer func() {
+		getentCommand, passwdFile, groupFile = origGetent, origPasswd, origGroup
+	}()
+
+	validator := NewValidator()
+
+	users := validator.getAvailableUsers()
+	if !containsStringSlice(users, "root") {
+		t.Errorf("Expected available users to include 'root', got: %v", users)
+	}
+	if _, err := user.Lookup("nobody"); err == nil && !containsStringSlice(users, "nobody") {
+		t.Errorf("Expected fallback lookup to find 'nobody', got: %v", users)
+	}
+
+	groups := validator.getAvailableGroups()
+	if !containsStringSlice(groups, "root") {
+		t.Errorf("Expected available groups to include 'root', got: %v", groups)
+	}
+	if len(groups) > 10 {
+		t.Errorf("Expected at most 10 groups, got %d", len(groups))
+	}
+}
+
+func TestParseEntryNames(t *testing.T) {
+	content := "root:x:0:0:root:/root:/bin/sh\n# comment\n\nnginx:x:101:101::/var/empty:/bin/false\n"
+	names := parseEntryNames(content)
+
+	if len(names) != 2 || names[0] != "root" || names[1] != "nginx" {
+		t.Errorf("Expected [root nginx], got %v", names)
+	}
+}
+
 func TestIsServiceUser(t *testing.T) {
 	tests := []struct {
 		username string
@@ -659,3 +1873,42 @@ func containsStringSlice(slice []string, item string) bool {
 	}
 	return false
 }
+
+func TestValidator_ValidateHashFileMode(t *testing.T) {
+	validator := NewValidator()
+
+	for mode, wantErr := range map[string]bool{
+		"":     false,
+		"0600": false,
+		"0644": false,
+		"0666": true,
+		"0620": true,
+		"rw":   true,
+	} {
+		if err := validator.ValidateHashFileMode(mode); (err != nil) != wantErr {
+			t.Errorf("ValidateHashFileMode(%q) error = %v, wantErr %v", mode, err, wantErr)
+		}
+	}
+}
+
+func TestValidator_SubstituteVariablesInlineDefaults(t *testing.T) {
+	validator := NewValidator()
+	defaults := map[string]string{"environment": "prod"}
+
+	got, err := validator.SubstituteVariables("op://{environment:-dev}/{service:-web}/token", nil, defaults, "secret[0]")
+	if err != nil || got != "op://prod/web/token" {
+		t.Errorf("SubstituteVariables() = %q, %v; want op://prod/web/token", got, err)
+	}
+
+	got, err = validator.SubstituteVariables("{service:-web}/token", map[string]string{"service": "api"}, nil, "secret[0]")
+	if err != nil || got != "api/token" {
+		t.Errorf("SubstituteVariables() = %q, %v; want the variable to win over the inline default", got, err)
+	}
+
+	if _, err := validator.SubstituteVariables("{service}/token", nil, defaults, "secret[0]"); err == nil {
+		t.Error("Expected a placeholder without an inline default to stay strict")
+	}
+	if _, err := validator.SubstituteVariables("{service:-../etc}/token", nil, nil, "secret[0]"); err == nil {
+		t.Error("Expected an inline default with path traversal to be rejected")
+	}
+}
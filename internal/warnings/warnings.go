@@ -0,0 +1,114 @@
+// Package warnings collects problems that don't stop a run, so they can be
+// reviewed together once it finishes instead of scattered through the log.
+package warnings
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/brizzbuzz/opnix/internal/errors"
+)
+
+// Warning is one non-fatal problem and the component that raised it
+type Warning struct {
+	Component string `json:"component"`
+	Message   string `json:"message"`
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("[%s] %s", w.Component, w.Message)
+}
+
+// Collector gathers warnings from concurrent callers
+type Collector struct {
+	mu       sync.Mutex
+	warnings []Warning
+}
+
+// NewCollector creates an empty collector
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+// Addf records a warning. A nil collector prints it to stderr straight away,
+// so callers that were not given one keep reporting warnings as before.
+func (c *Collector) Addf(component, format string, args ...interface{}) {
+	warning := Warning{Component: component, Message: fmt.Sprintf(format, args...)}
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "WARNING: %s\n", warning.Message)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.warnings = append(c.warnings, warning)
+}
+
+// List returns the warnings sorted by component and message, with repeats
+// of the same warning reported once
+func (c *Collector) List() []Warning {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	list := append([]Warning{}, c.warnings...)
+	c.mu.Unlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Component != list[j].Component {
+			return list[i].Component < list[j].Component
+		}
+		return list[i].Message < list[j].Message
+	})
+
+	unique := list[:0]
+	for i, warning := range list {
+		if i == 0 || warning != list[i-1] {
+			unique = append(unique, warning)
+		}
+	}
+	return unique
+}
+
+// Print writes the collected warnings as one block, or nothing if there are none
+func (c *Collector) Print(w io.Writer) {
+	list := c.List()
+	if len(list) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "WARNING: %d warnings during this run:\n", len(list))
+	for _, warning := range list {
+		fmt.Fprintf(w, "  %s\n", warning)
+	}
+}
+
+// Err turns the collected warnings into an error, for strict mode. It
+// returns nil when there are none.
+func (c *Collector) Err() error {
+	list := c.List()
+	if len(list) == 0 {
+		return nil
+	}
+
+	lines := make([]string, len(list))
+	for i, warning := range list {
+		lines[i] = warning.String()
+	}
+
+	return &errors.OpnixError{
+		Operation: "Checking warnings",
+		Component: "warnings",
+		Issue:     fmt.Sprintf("%d warnings were raised and strict warnings are enabled", len(list)),
+		Context:   strings.Join(lines, "\n  "),
+		Suggestions: []string{
+			"Fix the problems listed above",
+			"Disable strict warnings to let them pass",
+		},
+	}
+}
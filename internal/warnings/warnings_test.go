@@ -0,0 +1,53 @@
+package warnings
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector()
+	if collector.Err() != nil {
+		t.Error("Expected no error without warnings")
+	}
+
+	var wg sync.WaitGroup
+	for _, component := range []string{"systemd", "validation", "secret processing"} {
+		wg.Add(1)
+		go func(component string) {
+			defer wg.Done()
+			collector.Addf(component, "problem in %s", component)
+		}(component)
+	}
+	wg.Wait()
+	collector.Addf("systemd", "problem in %s", "systemd")
+
+	list := collector.List()
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 unique warnings, got %+v", list)
+	}
+	if list[0].Component != "secret processing" || list[1].Component != "systemd" || list[2].Component != "validation" {
+		t.Errorf("Expected warnings sorted by component, got %+v", list)
+	}
+
+	var out bytes.Buffer
+	collector.Print(&out)
+	if !strings.HasPrefix(out.String(), "WARNING: 3 warnings during this run:\n") || !strings.Contains(out.String(), "  [systemd] problem in systemd\n") {
+		t.Errorf("Unexpected warning block:\n%s", out.String())
+	}
+
+	err := collector.Err()
+	if err == nil || !strings.Contains(err.Error(), "[validation] problem in validation") {
+		t.Errorf("Expected strict mode error listing the warnings, got: %v", err)
+	}
+}
+
+func TestNilCollector(t *testing.T) {
+	var collector *Collector
+	collector.Addf("validation", "printed immediately")
+	if collector.List() != nil || collector.Err() != nil {
+		t.Error("Expected a nil collector to hold nothing")
+	}
+}
@@ -35,6 +35,23 @@ let
 
   # Create a system group for opnix token access
   opnixGroup = "onepassword-secrets";
+
+  # Options that default to null are left out of the generated config when
+  # unset, including those of nested submodules, so opnix applies its own
+  # defaults for them
+  withoutNulls =
+    value:
+    if lib.isAttrs value then
+      lib.mapAttrs (name: withoutNulls) (lib.filterAttrs (name: option: option != null) value)
+    else if lib.isList value then
+      map withoutNulls value
+    else
+      value;
+
+  # Where opnix places a credential secret that has no path of its own
+  credentialPath =
+    secret:
+    "/run/credstore${lib.optionalString (secret.credentialEncrypted == true) ".encrypted"}/${secret.credential}";
 in
 {
   options.services.onepassword-secrets = {
@@ -93,11 +110,43 @@ in
         lib.types.submodule {
           options = {
             reference = lib.mkOption {
-              type = lib.types.str;
-              description = "1Password reference in the format op://Vault/Item/field";
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "1Password reference in the format op://Vault/Item/field; required unless envFile is set";
               example = "op://Homelab/Database/password";
             };
 
+            fieldFallbacks = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Other fields of the same item to try, in order, when the referenced field does not exist";
+              example = [ "password" ];
+            };
+
+            envFile = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.listOf (
+                  lib.types.submodule {
+                    options = {
+                      key = lib.mkOption {
+                        type = lib.types.str;
+                        description = "Variable name written to the file";
+                        example = "DATABASE_PASSWORD";
+                      };
+
+                      reference = lib.mkOption {
+                        type = lib.types.str;
+                        description = "1Password reference for the variable's value";
+                        example = "op://Vault/Database/password";
+                      };
+                    };
+                  }
+                )
+              );
+              default = null;
+              description = "Combine several references into one dotenv file, used instead of reference";
+            };
+
             path = lib.mkOption {
               type = lib.types.nullOr lib.types.str;
               default = null;
@@ -153,6 +202,401 @@ in
               example = "API_KEY=\"{{ .Secret }}\"";
             };
 
+            account = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Name of an entry in accounts to resolve this secret with; null uses the default token";
+              example = "team";
+            };
+
+            description = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "What the secret is for; shown by opnix list, dry runs and the audit log";
+              example = "Reporting database credentials";
+            };
+
+            symlinkDirMode = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Octal mode set on each symlink's parent directory after it is created; null leaves existing directories alone";
+              example = "2750";
+            };
+
+            requireNonEmpty = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Fail instead of writing the secret when its final value is empty or only whitespace; null inherits the top-level requireNonEmpty";
+              example = false;
+            };
+
+            transaction = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Group name for secrets that must all be written or none at all";
+              example = "tls";
+            };
+
+            validateWith = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Command run after the secret is written, with the file path appended; a non-zero exit restores the previous file and fails the secret";
+              example = [
+                "/run/current-system/sw/bin/openssl"
+                "x509"
+                "-noout"
+                "-in"
+              ];
+            };
+
+            validateTimeout = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "How long validateWith may run before it is killed and treated as a failure; null allows 30s";
+              example = "1m";
+            };
+
+            bundle = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Certificate references concatenated, in order, into one PEM bundle, used instead of reference";
+              example = [
+                "op://Vault/Intermediate CA/cert"
+                "op://Vault/Root CA/cert"
+              ];
+            };
+
+            ini = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.listOf (
+                  lib.types.submodule {
+                    options = {
+                      section = lib.mkOption {
+                        type = lib.types.nullOr lib.types.str;
+                        default = null;
+                        description = "Section the key is placed in; null puts it before the first section";
+                        example = "database";
+                      };
+
+                      key = lib.mkOption {
+                        type = lib.types.str;
+                        description = "Key the value is written to";
+                        example = "password";
+                      };
+
+                      reference = lib.mkOption {
+                        type = lib.types.str;
+                        description = "1Password reference for the key's value";
+                        example = "op://Vault/Database/password";
+                      };
+                    };
+                  }
+                )
+              );
+              default = null;
+              description = "Place several references as section.key values in one INI file, used instead of reference";
+            };
+
+            type = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.enum [
+                  "password"
+                  "concealed"
+                  "text"
+                  "file"
+                  "otp"
+                  "sshKey"
+                ]
+              );
+              default = null;
+              description = "Expected type of the referenced field, checked against the item's metadata before the secret is written";
+              example = "password";
+            };
+
+            templateJSON = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Parse the resolved value as JSON and expose it to template as {{ .SecretJSON }}";
+              example = true;
+            };
+
+            skipIfExists = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Leave the target untouched when a file already exists at its path, without resolving the reference";
+              example = true;
+            };
+
+            copies = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.listOf (
+                  lib.types.submodule {
+                    options = {
+                      path = lib.mkOption {
+                        type = lib.types.str;
+                        description = "Path of the copy";
+                        example = "tls/key.pem.b64";
+                      };
+
+                      encoding = lib.mkOption {
+                        type = lib.types.nullOr (
+                          lib.types.enum [
+                            "raw"
+                            "base64"
+                            "hex"
+                          ]
+                        );
+                        default = null;
+                        description = "Encoding of the copy; null writes the value as is";
+                      };
+
+                      owner = lib.mkOption {
+                        type = lib.types.nullOr lib.types.str;
+                        default = null;
+                        description = "User who owns the copy; null uses the secret's owner";
+                      };
+
+                      group = lib.mkOption {
+                        type = lib.types.nullOr lib.types.str;
+                        default = null;
+                        description = "Group that owns the copy; null uses the secret's group";
+                      };
+
+                      mode = lib.mkOption {
+                        type = lib.types.nullOr lib.types.str;
+                        default = null;
+                        description = "File permissions of the copy; null uses the secret's mode";
+                      };
+
+                      readableBy = lib.mkOption {
+                        type = lib.types.nullOr (lib.types.listOf lib.types.str);
+                        default = null;
+                        description = "Users that must be able to read the copy, checked when the config is validated";
+                        example = [ "nginx" ];
+                      };
+                    };
+                  }
+                )
+              );
+              default = null;
+              description = "Further files written from the same resolved value, each in its own encoding";
+            };
+
+            tags = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Labels for selecting secrets across paths with -tag";
+              example = [
+                "tls"
+                "prod"
+              ];
+            };
+
+            certExpiryWarnDays = lib.mkOption {
+              type = lib.types.nullOr lib.types.ints.unsigned;
+              default = null;
+              description = "Warn when a certificate in the resolved value has expired or expires within this many days";
+              example = 30;
+            };
+
+            certExpiryStrict = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Fail the secret instead of warning about an expired or expiring certificate";
+              example = true;
+            };
+
+            credential = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Deliver the secret as the systemd credential of this name; without a path it goes to the credential store read by LoadCredential=";
+              example = "db-password";
+            };
+
+            credentialEncrypted = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Encrypt the credential with systemd-creds, for LoadCredentialEncrypted=";
+              example = true;
+            };
+
+            serial = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Resolve the secret only when its turn comes, instead of ahead of time in the parallel resolve.groupByItem batches";
+              example = true;
+            };
+
+            sshKeys = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Public key references assembled, one key line per line, into an authorized_keys or known_hosts file, used instead of reference";
+              example = [
+                "op://Fleet/Alice SSH/public key"
+                "op://Fleet/CI Deploy Key/public key"
+              ];
+            };
+
+            sshKeysFormat = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.enum [
+                  "authorized_keys"
+                  "known_hosts"
+                ]
+              );
+              default = null;
+              description = "Format of the sshKeys file; null uses authorized_keys";
+              example = "known_hosts";
+            };
+
+            filter = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Command, as an absolute path and its arguments, the resolved value is piped through before it is written; its stdout becomes the value";
+              example = [
+                "/run/current-system/sw/bin/jq"
+                "-r"
+                ".password"
+              ];
+            };
+
+            filterTimeout = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "How long filter may run before it is killed and treated as a failure; null allows 30s";
+              example = "1m";
+            };
+
+            canonical = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Write JSON, envFile and ini output in a stable, sorted form so the file can be diffed across runs and hosts";
+              example = true;
+            };
+
+            cacheTTL = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "How long this secret's value may be served from the resolve cache; \"0\" always resolves it from 1Password, null uses resolve.cache.ttl";
+              example = "1h";
+            };
+
+            region = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.submodule {
+                  options = {
+                    name = lib.mkOption {
+                      type = lib.types.nullOr lib.types.str;
+                      default = null;
+                      description = "Name added to both markers so one file can hold several regions";
+                      example = "db";
+                    };
+
+                    comment = lib.mkOption {
+                      type = lib.types.nullOr lib.types.str;
+                      default = null;
+                      description = "Comment prefix of the marker lines; null uses #";
+                      example = "//";
+                    };
+                  };
+                }
+              );
+              default = null;
+              description = "Write the value between # BEGIN OPNIX and # END OPNIX lines of a file other tools also edit, instead of owning the whole file";
+              example = {
+                name = "db";
+                comment = "//";
+              };
+            };
+
+            onErrorHint = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Runbook guidance added as the last suggestion when the secret fails to resolve or be written";
+              example = "Ask the DBA on call to rotate the reporting credentials";
+            };
+
+            backupRetention = lib.mkOption {
+              type = lib.types.nullOr lib.types.ints.unsigned;
+              default = null;
+              description = "Timestamped copies of the previous file to keep each time the secret is overwritten with a different value; null inherits the top-level backupRetention";
+              example = 3;
+            };
+
+            extract = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Regular expression whose first capture group replaces the resolved value, for fields holding more than the part to write";
+              example = "token=([^&]+)";
+            };
+
+            onlyIf = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.submodule {
+                  options = {
+                    exists = lib.mkOption {
+                      type = lib.types.nullOr lib.types.str;
+                      default = null;
+                      description = "Absolute path that must exist, such as a feature flag file";
+                      example = "/etc/opnix/flags/new-database";
+                    };
+
+                    command = lib.mkOption {
+                      type = lib.types.nullOr (lib.types.listOf lib.types.str);
+                      default = null;
+                      description = "Command, as an absolute path and its arguments, that must exit 0; exit 1 skips the secret, anything else fails it";
+                      example = [
+                        "/run/current-system/sw/bin/systemctl"
+                        "is-enabled"
+                        "--quiet"
+                        "newdb"
+                      ];
+                    };
+
+                    timeout = lib.mkOption {
+                      type = lib.types.nullOr lib.types.str;
+                      default = null;
+                      description = "How long command may run; null allows 30s";
+                      example = "10s";
+                    };
+                  };
+                }
+              );
+              default = null;
+              description = "Conditions checked on the host before the secret is resolved; when one is not met the secret is skipped";
+              example = {
+                exists = "/etc/opnix/flags/new-database";
+              };
+            };
+
+            transforms = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Steps applied to the resolved value in order: extract:<regex>, base64decode, base64encode, trim or trimNewline";
+              example = [
+                "extract:token=([^&]+)"
+                "base64decode"
+                "trimNewline"
+              ];
+            };
+
+            item = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Item reference without a field, op://Vault/Item, whose fields are written to their own paths from one batched resolve, used instead of reference and path";
+              example = "op://Vault/Database";
+            };
+
+            fields = lib.mkOption {
+              type = lib.types.nullOr (lib.types.attrsOf lib.types.str);
+              default = null;
+              description = "Field names of item mapped to the path each is written to";
+              example = {
+                username = "db/username";
+                password = "db/password";
+              };
+            };
+
             services = lib.mkOption {
               type = lib.types.either (lib.types.listOf lib.types.str) (
                 lib.types.attrsOf (
@@ -227,6 +671,338 @@ in
       };
     };
 
+    accounts = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.attrsOf (
+          lib.types.submodule {
+            options.tokenFile = lib.mkOption {
+              type = lib.types.str;
+              description = "File holding the account's service account token";
+              example = "/etc/opnix-team-token";
+            };
+          }
+        )
+      );
+      default = null;
+      description = "Named 1Password accounts with their own service account tokens, selected by a secret's account";
+      example = {
+        team.tokenFile = "/etc/opnix-team-token";
+      };
+    };
+
+    networkFilesystem = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.enum [
+          "warn"
+          "refuse"
+          "allow"
+        ]
+      );
+      default = null;
+      description = "What to do when a secret would be written to a network filesystem such as NFS or CIFS; null warns";
+      example = "refuse";
+    };
+
+    baseDir = lib.mkOption {
+      type = lib.types.nullOr lib.types.str;
+      default = null;
+      description = "Approved base directory; every secret and symlink path must resolve under it";
+      example = "/var/lib/opnix";
+    };
+
+    requireNonEmpty = lib.mkOption {
+      type = lib.types.nullOr lib.types.bool;
+      default = null;
+      description = "Fail instead of writing a secret whose final value is empty or only whitespace; null defaults to true";
+      example = false;
+    };
+
+    placeholderCheck = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.submodule {
+          options = {
+            mode = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.enum [
+                  "off"
+                  "warn"
+                  "strict"
+                ]
+              );
+              default = null;
+              description = "off, warn or strict; null is off";
+            };
+
+            placeholders = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Case-insensitive values to flag; null uses a built-in list";
+              example = [ "CHANGEME" ];
+            };
+
+            minLength = lib.mkOption {
+              type = lib.types.nullOr lib.types.ints.unsigned;
+              default = null;
+              description = "Flag values shorter than this many characters";
+            };
+
+            minEntropyBits = lib.mkOption {
+              type = lib.types.nullOr lib.types.number;
+              default = null;
+              description = "Flag values with less Shannon entropy than this many bits in total";
+            };
+          };
+        }
+      );
+      default = null;
+      description = "Flag resolved values that look like they were never set, before they are written";
+      example = {
+        mode = "strict";
+        minLength = 12;
+      };
+    };
+
+    resolve = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.submodule {
+          options = {
+            maxRetries = lib.mkOption {
+              type = lib.types.nullOr lib.types.ints.unsigned;
+              default = null;
+              description = "Retries for each resolve that fails with a transient error";
+            };
+
+            timeout = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "How long each resolve may take";
+              example = "30s";
+            };
+
+            groupByItem = lib.mkOption {
+              type = lib.types.nullOr lib.types.bool;
+              default = null;
+              description = "Resolve references that share a vault and item in one request per item";
+            };
+
+            parallel = lib.mkOption {
+              type = lib.types.nullOr lib.types.ints.positive;
+              default = null;
+              description = "How many item groups to resolve at once with groupByItem; null is 4";
+            };
+
+            retryableErrors = lib.mkOption {
+              type = lib.types.nullOr (lib.types.listOf lib.types.str);
+              default = null;
+              description = "Case-insensitive substrings marking further errors as retryable";
+              example = [ "item is locked" ];
+            };
+
+            cache = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.submodule {
+                  options = {
+                  file = lib.mkOption {
+                    type = lib.types.nullOr lib.types.str;
+                    default = null;
+                    description = "Encrypted file resolved values are kept in between runs; null turns the cache off";
+                    example = "/var/lib/opnix/resolve-cache";
+                  };
+
+                  ttl = lib.mkOption {
+                    type = lib.types.nullOr lib.types.str;
+                    default = null;
+                    description = "How long a value is served from the cache before it is resolved again; null is 5m";
+                    example = "15m";
+                  };
+
+                  keyFile = lib.mkOption {
+                    type = lib.types.nullOr lib.types.str;
+                    default = null;
+                    description = "File holding the cache encryption key; null derives it from the service account token";
+                  };
+                  };
+                }
+              );
+              default = null;
+              description = "Serve recently resolved values from an encrypted file instead of 1Password";
+            };
+
+            rateLimit = lib.mkOption {
+              type = lib.types.nullOr lib.types.number;
+              default = null;
+              description = "Most requests per second to 1Password, shared by every vault without its own limit; null is unlimited";
+            };
+
+            vaultRateLimits = lib.mkOption {
+              type = lib.types.nullOr (lib.types.attrsOf lib.types.number);
+              default = null;
+              description = "Requests per second for single vaults, by name, ID or Vault@account";
+              example = { Legacy = 2; };
+            };
+          };
+        }
+      );
+      default = null;
+      description = "How references are resolved from 1Password";
+      example = {
+        groupByItem = true;
+        parallel = 4;
+      };
+    };
+
+    network = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.submodule {
+          options = {
+            proxy = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "http or https proxy URL used for every request; null uses HTTPS_PROXY";
+              example = "http://proxy.internal:3128";
+            };
+
+            caBundle = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "PEM file of CAs trusted in addition to the system ones";
+              example = "/etc/ssl/proxy-ca.pem";
+            };
+          };
+        }
+      );
+      default = null;
+      description = "Reach 1Password through an egress proxy";
+    };
+
+    lock = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.submodule {
+          options = {
+            mode = lib.mkOption {
+              type = lib.types.nullOr (
+                lib.types.enum [
+                  "fail"
+                  "wait"
+                  "queue"
+                ]
+              );
+              default = null;
+              description = "fail, wait or queue; null waits";
+            };
+
+            timeout = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "How long to wait for the lock; null is 1m for wait and no limit for queue";
+              example = "10m";
+            };
+          };
+        }
+      );
+      default = null;
+      description = "What a run does while another run is writing the same output directory";
+      example = {
+        mode = "queue";
+        timeout = "10m";
+      };
+    };
+
+    webhook = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.submodule {
+          options = {
+            url = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "http or https URL the event is sent to";
+              example = "https://hooks.example.com/opnix";
+            };
+
+            timeout = lib.mkOption {
+              type = lib.types.nullOr lib.types.str;
+              default = null;
+              description = "Request timeout; null is 10s";
+              example = "5s";
+            };
+          };
+        }
+      );
+      default = null;
+      description = "POST a JSON event describing each run to url";
+    };
+
+    backupRetention = lib.mkOption {
+      type = lib.types.nullOr lib.types.ints.unsigned;
+      default = null;
+      description = "Timestamped copies of the previous file to keep each time a secret is overwritten with a different value; null keeps none";
+      example = 3;
+    };
+
+    modePolicies = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.listOf (
+          lib.types.submodule {
+            options = {
+              dir = lib.mkOption {
+                type = lib.types.str;
+                description = "Directory the policy applies to, including everything under it";
+                example = "/etc/ssl";
+              };
+
+              maxMode = lib.mkOption {
+                type = lib.types.str;
+                description = "Mode a secret under dir may not set any permission bit outside of";
+                example = "0644";
+              };
+            };
+          }
+        )
+      );
+      default = null;
+      description = "Per-directory limits on the modes of secrets and copies written under each dir";
+      example = [
+        {
+          dir = "/run/secrets";
+          maxMode = "0600";
+        }
+      ];
+    };
+
+    unicodeCheck = lib.mkOption {
+      type = lib.types.nullOr (
+        lib.types.enum [
+          "warn"
+          "error"
+          "normalize"
+        ]
+      );
+      default = null;
+      description = "What to do about copy-paste characters, such as non-breaking spaces or look-alike letters, in references and paths; null warns";
+      example = "normalize";
+    };
+
+    metricsFile = lib.mkOption {
+      type = lib.types.nullOr lib.types.str;
+      default = null;
+      description = "File run metrics are written to after every run, in the Prometheus text format read by the node exporter's textfile collector; null writes none";
+      example = "/var/lib/prometheus-node-exporter-text-files/opnix.prom";
+    };
+
+    requireAbsolutePaths = lib.mkOption {
+      type = lib.types.nullOr lib.types.bool;
+      default = null;
+      description = "Reject any secret whose final path is relative, so every destination is spelled out in full";
+      example = true;
+    };
+
+    accountTokenDir = lib.mkOption {
+      type = lib.types.nullOr lib.types.str;
+      default = null;
+      description = "Directory of token files, each registered as an account named after the file without its .token suffix";
+      example = "/etc/opnix/tokens";
+    };
+
     pathTemplate = lib.mkOption {
       type = lib.types.nullOr lib.types.str;
       default = null;
@@ -287,12 +1063,54 @@ in
                   default = "/var/lib/opnix/secret-hashes.json";
                   description = "File to store secret content hashes for change detection";
                 };
+
+                hashFileMode = lib.mkOption {
+                  type = lib.types.str;
+                  default = "0600";
+                  description = "Octal mode of the hash file; must not be group- or world-writable";
+                };
               };
             };
             default = { };
             description = "Change detection configuration";
           };
 
+          systemctl = lib.mkOption {
+            type = lib.types.nullOr lib.types.str;
+            default = null;
+            description = "Absolute path of the systemctl binary used to restart and reload services; null looks it up in PATH";
+            example = "/run/current-system/sw/bin/systemctl";
+          };
+
+          unitName = lib.mkOption {
+            type = lib.types.str;
+            default = "opnix-secrets.service";
+            description = "Unit that runs opnix on this host; services without their own `after` are ordered after it. `.service` is appended when no unit suffix is given";
+            example = "opnix-secrets-custom.service";
+          };
+
+          maintenanceWindows = lib.mkOption {
+            type = lib.types.listOf lib.types.str;
+            default = [ ];
+            description = "Local-time windows service restarts may run in; outside them restarts are deferred until a run inside one, or `opnix apply-pending`. Empty allows any time";
+            example = [
+              "Sat 02:00-06:00"
+              "Mon-Fri 22:00-06:00"
+            ];
+          };
+
+          parallelServices = lib.mkOption {
+            type = lib.types.ints.positive;
+            default = 1;
+            description = "Maximum number of service restarts and reloads run at once; services ordered by `after` still wait for each other";
+          };
+
+          pendingFile = lib.mkOption {
+            type = lib.types.str;
+            default = "/var/lib/opnix/pending-services.json";
+            description = "File recording service actions deferred to the next maintenance window";
+          };
+
           errorHandling = lib.mkOption {
             type = lib.types.submodule {
               options = {
@@ -339,8 +1157,14 @@ in
     # Always define secretPaths to prevent evaluation errors (fixes MMI-88, MMI-92)
     (lib.mkIf (cfg.enable && cfg.secrets != { }) {
       services.onepassword-secrets.secretPaths = lib.mapAttrs (
-        name: secret: if secret.path != null then secret.path else "${cfg.outputDir}/${name}"
-      ) (validateSecretKeys cfg.secrets);
+        name: secret:
+        if secret.path != null then
+          secret.path
+        else if secret.credential != null then
+          credentialPath secret
+        else
+          "${cfg.outputDir}/${name}"
+      ) (lib.filterAttrs (name: secret: secret.item == null) (validateSecretKeys cfg.secrets));
     })
 
     # Main configuration only when enabled
@@ -362,22 +1186,89 @@ in
         declarativeConfigFile =
           if hasDeclarativeSecrets then
             pkgs.writeText "opnix-declarative-secrets.json" (
-              builtins.toJSON {
-                secrets = lib.mapAttrsToList (name: secret: {
-                  path = if secret.path != null then secret.path else name;
-                  reference = secret.reference;
-                  owner = secret.owner;
-                  group = secret.group;
-                  mode = secret.mode;
-                  symlinks = secret.symlinks;
-                  variables = secret.variables;
-                  services = secret.services;
-                  template = secret.template;
-                }) (validateSecretKeys cfg.secrets);
-                pathTemplate = cfg.pathTemplate;
-                defaults = cfg.defaults;
-                systemdIntegration = cfg.systemdIntegration;
-              }
+              builtins.toJSON (
+                {
+                  secrets = lib.mapAttrsToList (
+                    name: secret:
+                    {
+                      owner = secret.owner;
+                      group = secret.group;
+                      mode = secret.mode;
+                      symlinks = secret.symlinks;
+                      variables = secret.variables;
+                      services = secret.services;
+                      template = secret.template;
+                    }
+                    // withoutNulls {
+                      # opnix places credentials without a path in the store itself,
+                      # and item secrets give each field its own path
+                      path =
+                        if secret.path != null then
+                          secret.path
+                        else if secret.credential != null || secret.item != null then
+                          null
+                        else
+                          name;
+                      reference = secret.reference;
+                      fieldFallbacks = secret.fieldFallbacks;
+                      envFile = secret.envFile;
+                      account = secret.account;
+                      description = secret.description;
+                      symlinkDirMode = secret.symlinkDirMode;
+                      requireNonEmpty = secret.requireNonEmpty;
+                      transaction = secret.transaction;
+                      validateWith = secret.validateWith;
+                      validateTimeout = secret.validateTimeout;
+                      bundle = secret.bundle;
+                      ini = secret.ini;
+                      type = secret.type;
+                      templateJSON = secret.templateJSON;
+                      skipIfExists = secret.skipIfExists;
+                      copies = secret.copies;
+                      tags = secret.tags;
+                      certExpiryWarnDays = secret.certExpiryWarnDays;
+                      certExpiryStrict = secret.certExpiryStrict;
+                      credential = secret.credential;
+                      credentialEncrypted = secret.credentialEncrypted;
+                      serial = secret.serial;
+                      sshKeys = secret.sshKeys;
+                      sshKeysFormat = secret.sshKeysFormat;
+                      filter = secret.filter;
+                      filterTimeout = secret.filterTimeout;
+                      canonical = secret.canonical;
+                      cacheTTL = secret.cacheTTL;
+                      region = secret.region;
+                      onErrorHint = secret.onErrorHint;
+                      backupRetention = secret.backupRetention;
+                      extract = secret.extract;
+                      onlyIf = secret.onlyIf;
+                      transforms = secret.transforms;
+                      item = secret.item;
+                      fields = secret.fields;
+                    }
+                  ) (validateSecretKeys cfg.secrets);
+                  pathTemplate = cfg.pathTemplate;
+                  defaults = cfg.defaults;
+                  systemdIntegration = cfg.systemdIntegration;
+                }
+                // withoutNulls {
+                  accounts = cfg.accounts;
+                  networkFilesystem = cfg.networkFilesystem;
+                  baseDir = cfg.baseDir;
+                  requireNonEmpty = cfg.requireNonEmpty;
+                  placeholderCheck = cfg.placeholderCheck;
+                  resolve = cfg.resolve;
+                  network = cfg.network;
+                  lock = cfg.lock;
+                  webhook = cfg.webhook;
+                  backupRetention = cfg.backupRetention;
+                  modePolicies = cfg.modePolicies;
+                  unicodeCheck = cfg.unicodeCheck;
+                  metricsFile = cfg.metricsFile;
+                  requireAbsolutePaths = cfg.requireAbsolutePaths;
+                  accountTokenDir = cfg.accountTokenDir;
+                }
+              )
             )
           else
             null;
@@ -398,6 +1289,17 @@ in
           ]
           ++ (lib.flatten (
             lib.mapAttrsToList (name: secret: [
+              {
+                assertion = lib.any (value: value != null) [
+                  secret.reference
+                  secret.envFile
+                  secret.bundle
+                  secret.ini
+                  secret.sshKeys
+                  secret.item
+                ];
+                message = "OpNix secret '${name}': one of reference, envFile, bundle, ini, sshKeys or item must be set";
+              }
               {
                 assertion = builtins.match "^[0-7]{3,4}$" secret.mode != null;
                 message = "OpNix secret '${name}': mode '${secret.mode}' is not a valid octal permission (e.g., 0644, 0600)";